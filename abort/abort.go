@@ -0,0 +1,387 @@
+// Package abort records stop messages arriving while a build is still starting in a DynamoDB table,
+// so the start tears the build down as soon as it completes instead of leaving it running.
+// Starts cut short by the lambda deadline are kept pending verification for the next invocation,
+// and started builds await the heartbeat of their launcher in the same index.
+// The build messages kept with the records hold no token, the token of the build is kept encrypted with a kms key.
+package abort
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+)
+
+const (
+	tableEnv        = "SD_IDEMPOTENCY_TABLE"
+	pendingIndexEnv = "SD_IDEMPOTENCY_PENDING_INDEX"
+	kmsKeyEnv       = "SD_IDEMPOTENCY_KMS_KEY"
+
+	// defaultPendingIndex is the sparse global secondary index of the records pending verification,
+	// partitioned by the string attribute pending
+	defaultPendingIndex = "pending"
+
+	// records expire through the table ttl attribute expiresAt, builds start and stop well within it
+	recordTTL = 24 * time.Hour
+
+	// states of a build record
+	starting = "STARTING"
+	started  = "STARTED"
+	// Aborted is the state of a record whose build was stopped
+	Aborted = "ABORTED"
+
+	// values of the pending attribute of builds pending verification and builds awaiting their heartbeat
+	pendingVerification = "true"
+	pendingHeartbeat    = "heartbeat"
+)
+
+// Record is the state of a build whose start was cut short, with the build message to verify it
+type Record struct {
+	BuildID      int    `dynamodbav:"buildId"`
+	Architecture string `dynamodbav:"architecture,omitempty"`
+	State        string `dynamodbav:"state"`
+	PendingAt    int64  `dynamodbav:"pendingAt"`
+	// Message is the build message without its token
+	Message string `dynamodbav:"message"`
+	// HeartbeatAt is when the launcher of the build posted its heartbeat, zero until it does
+	HeartbeatAt int64 `dynamodbav:"heartbeatAt,omitempty"`
+	// SealedToken is the token of the build encrypted with the kms key, absent without one
+	SealedToken []byte `dynamodbav:"sealedToken,omitempty"`
+	// Token is the token of the build decrypted from SealedToken, empty without a kms key
+	Token string `dynamodbav:"-"`
+}
+
+// Tracker records the start and stop of builds in a DynamoDB table keyed by the number attribute buildId
+// and the string attribute buildKey, the build id suffixed with the architecture of matrix builds
+type Tracker struct {
+	client       dynamodbiface.DynamoDBAPI
+	kms          kmsiface.KMSAPI
+	table        string
+	pendingIndex string
+	kmsKey       string
+}
+
+// FromEnv returns the tracker of SD_IDEMPOTENCY_TABLE, nil when abort tracking is disabled
+func FromEnv() *Tracker {
+	table := os.Getenv(tableEnv)
+	if table == "" {
+		return nil
+	}
+	pendingIndex := os.Getenv(pendingIndexEnv)
+	if pendingIndex == "" {
+		pendingIndex = defaultPendingIndex
+	}
+	return &Tracker{table: table, pendingIndex: pendingIndex, kmsKey: os.Getenv(kmsKeyEnv)}
+}
+
+// gets the dynamodb client, creating it on first use
+func (t *Tracker) dynamodb() (dynamodbiface.DynamoDBAPI, error) {
+	if t.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		t.client = dynamodb.New(sess)
+	}
+	return t.client, nil
+}
+
+// gets the kms client, creating it on first use
+func (t *Tracker) kmsClient() (kmsiface.KMSAPI, error) {
+	if t.kms == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		t.kms = kms.New(sess)
+	}
+	return t.kms, nil
+}
+
+// gets the encryption context binding a sealed token to the record of the build
+func encryptionContext(buildID int, arch string) map[string]*string {
+	return map[string]*string{"buildKey": aws.String(matrix.Key(buildID, arch))}
+}
+
+// encrypts the token of the build with the kms key, nil without a key or token
+func (t *Tracker) seal(buildID int, arch string, token string) ([]byte, error) {
+	if t.kmsKey == "" || token == "" {
+		return nil, nil
+	}
+	client, err := t.kmsClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Encrypt(&kms.EncryptInput{
+		KeyId:             aws.String(t.kmsKey),
+		Plaintext:         []byte(token),
+		EncryptionContext: encryptionContext(buildID, arch),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-Encrypt: %v", err)
+	}
+	return result.CiphertextBlob, nil
+}
+
+// decrypts the sealed token of the record into its token
+func (t *Tracker) unseal(record *Record) error {
+	if len(record.SealedToken) == 0 {
+		return nil
+	}
+	client, err := t.kmsClient()
+	if err != nil {
+		return err
+	}
+	result, err := client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    record.SealedToken,
+		EncryptionContext: encryptionContext(record.BuildID, record.Architecture),
+	})
+	if err != nil {
+		return fmt.Errorf("Error-Decrypt: %v", err)
+	}
+	record.Token = string(result.Plaintext)
+	return nil
+}
+
+// gets the key of the build record of the architecture
+func key(buildID int, arch string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"buildId":  {N: aws.String(strconv.Itoa(buildID))},
+		"buildKey": {S: aws.String(matrix.Key(buildID, arch))},
+	}
+}
+
+// gets the attribute values setting the state of a build record
+func values(state string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		":state":     {S: aws.String(state)},
+		":aborted":   {S: aws.String(Aborted)},
+		":expiresAt": {N: aws.String(strconv.FormatInt(time.Now().Add(recordTTL).Unix(), 10))},
+	}
+}
+
+// returns true if the error is a failed condition of a conditional write
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// sets the state of the build record unless the build is aborted, returns true if it is
+func (t *Tracker) transition(buildID int, arch string, state string) (bool, error) {
+	client, err := t.dynamodb()
+	if err != nil {
+		return false, err
+	}
+	_, err = client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.table),
+		Key:                       key(buildID, arch),
+		UpdateExpression:          aws.String("SET #state = :state, expiresAt = :expiresAt REMOVE pending, pendingAt, message, sealedToken"),
+		ConditionExpression:       aws.String("attribute_not_exists(#state) OR #state <> :aborted"),
+		ExpressionAttributeNames:  map[string]*string{"#state": aws.String("state")},
+		ExpressionAttributeValues: values(state),
+	})
+	if isConditionFailed(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error-UpdateItem: %v", err)
+	}
+	return false, nil
+}
+
+// Starting records that the build is being started, returns true if it was stopped already
+func (t *Tracker) Starting(buildID int, arch string) (bool, error) {
+	return t.transition(buildID, arch, starting)
+}
+
+// Started records that the start of the build completed, returns true if it was stopped meanwhile
+func (t *Tracker) Started(buildID int, arch string) (bool, error) {
+	return t.transition(buildID, arch, started)
+}
+
+// Abort records that the build is stopped, returns true if its start has not completed yet
+func (t *Tracker) Abort(buildID int, arch string) (bool, error) {
+	client, err := t.dynamodb()
+	if err != nil {
+		return false, err
+	}
+	vals := values(Aborted)
+	delete(vals, ":aborted")
+	result, err := client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.table),
+		Key:                       key(buildID, arch),
+		UpdateExpression:          aws.String("SET #state = :state, expiresAt = :expiresAt"),
+		ExpressionAttributeNames:  map[string]*string{"#state": aws.String("state")},
+		ExpressionAttributeValues: vals,
+		ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedOld),
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error-UpdateItem: %v", err)
+	}
+	previous := result.Attributes["state"]
+	return previous != nil && aws.StringValue(previous.S) == starting, nil
+}
+
+// Pending records that the start of the build may have been cut short, keeping the build message without its token
+// and the sealed token to verify it later
+func (t *Tracker) Pending(buildID int, arch string, message string, token string) error {
+	return t.setPending(buildID, arch, pendingVerification, message, token, nil)
+}
+
+// AwaitHeartbeat records that the build started and its launcher is expected to post a heartbeat,
+// keeping the build message to stop the build if it does not. A launcher may post its heartbeat before,
+// the build does not await it then.
+func (t *Tracker) AwaitHeartbeat(buildID int, arch string, message string, token string) error {
+	err := t.setPending(buildID, arch, pendingHeartbeat, message, token, aws.String("attribute_not_exists(heartbeatAt)"))
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// keeps the build message of the build in the pending index if the condition holds, a failed condition is returned as is
+func (t *Tracker) setPending(buildID int, arch string, pending string, message string, token string, condition *string) error {
+	client, err := t.dynamodb()
+	if err != nil {
+		return err
+	}
+	sealed, err := t.seal(buildID, arch, token)
+	if err != nil {
+		return err
+	}
+	update := "SET pending = :pending, pendingAt = :pendingAt, message = :message, architecture = :architecture, expiresAt = :expiresAt"
+	vals := map[string]*dynamodb.AttributeValue{
+		":pending":      {S: aws.String(pending)},
+		":pendingAt":    {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		":message":      {S: aws.String(message)},
+		":architecture": {S: aws.String(arch)},
+		":expiresAt":    {N: aws.String(strconv.FormatInt(time.Now().Add(recordTTL).Unix(), 10))},
+	}
+	if sealed != nil {
+		update += ", sealedToken = :sealedToken"
+		vals[":sealedToken"] = &dynamodb.AttributeValue{B: sealed}
+	}
+	_, err = client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.table),
+		Key:                       key(buildID, arch),
+		ConditionExpression:       condition,
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: vals,
+	})
+	if err != nil && !isConditionFailed(err) {
+		return fmt.Errorf("Error-UpdateItem: %v", err)
+	}
+	return err
+}
+
+// ListPending returns up to limit records pending verification sorted oldest first. The index has no sort key,
+// with more records pending than the limit they are not necessarily the oldest ones.
+func (t *Tracker) ListPending(limit int) ([]Record, error) {
+	return t.listPending(pendingVerification, limit)
+}
+
+// ListAwaitingHeartbeat returns up to limit records of started builds whose launcher did not post a heartbeat yet,
+// sorted oldest first like ListPending
+func (t *Tracker) ListAwaitingHeartbeat(limit int) ([]Record, error) {
+	return t.listPending(pendingHeartbeat, limit)
+}
+
+// Heartbeat records the heartbeat of the launcher of the build, returns false if the build is unknown
+func (t *Tracker) Heartbeat(buildID int, arch string, hostname string) (bool, error) {
+	client, err := t.dynamodb()
+	if err != nil {
+		return false, err
+	}
+	vals := map[string]*dynamodb.AttributeValue{
+		":heartbeatAt": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		":hostname":    {S: aws.String(hostname)},
+		":pending":     {S: aws.String(pendingHeartbeat)},
+	}
+	// builds awaiting the heartbeat leave the pending index, builds pending verification stay in it
+	_, err = client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.table),
+		Key:                       key(buildID, arch),
+		UpdateExpression:          aws.String("SET heartbeatAt = :heartbeatAt, hostname = :hostname REMOVE pending, pendingAt, message, sealedToken"),
+		ConditionExpression:       aws.String("pending = :pending"),
+		ExpressionAttributeValues: vals,
+	})
+	if !isConditionFailed(err) {
+		if err != nil {
+			return false, fmt.Errorf("Error-UpdateItem: %v", err)
+		}
+		return true, nil
+	}
+	delete(vals, ":pending")
+	_, err = client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.table),
+		Key:                       key(buildID, arch),
+		UpdateExpression:          aws.String("SET heartbeatAt = :heartbeatAt, hostname = :hostname"),
+		ConditionExpression:       aws.String("attribute_exists(buildKey)"),
+		ExpressionAttributeValues: vals,
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error-UpdateItem: %v", err)
+	}
+	return true, nil
+}
+
+// queries the records of the pending index with the pending value, unsealing their tokens
+func (t *Tracker) listPending(pending string, limit int) ([]Record, error) {
+	client, err := t.dynamodb()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String(t.table),
+		IndexName:                 aws.String(t.pendingIndex),
+		KeyConditionExpression:    aws.String("pending = :pending"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pending": {S: aws.String(pending)}},
+		Limit:                     aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-Query: %v", err)
+	}
+	var records []Record
+	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &records); err != nil {
+		return nil, fmt.Errorf("Got error decoding pending records: %v", err)
+	}
+	for i := range records {
+		if err := t.unseal(&records[i]); err != nil {
+			return nil, fmt.Errorf("Got error unsealing token of build %v: %v", records[i].BuildID, err)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].PendingAt < records[j].PendingAt })
+	return records, nil
+}
+
+// Resolve records that the start of the build is verified
+func (t *Tracker) Resolve(buildID int, arch string) error {
+	client, err := t.dynamodb()
+	if err != nil {
+		return err
+	}
+	_, err = client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String(t.table),
+		Key:              key(buildID, arch),
+		UpdateExpression: aws.String("REMOVE pending, pendingAt, message, sealedToken"),
+	})
+	if err != nil {
+		return fmt.Errorf("Error-UpdateItem: %v", err)
+	}
+	return nil
+}
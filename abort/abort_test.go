@@ -0,0 +1,279 @@
+package abort
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mock.Mock
+}
+
+func (m *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.UpdateItemOutput), args.Error(1)
+}
+
+func (m *mockDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.QueryOutput), args.Error(1)
+}
+
+type mockKMS struct {
+	kmsiface.KMSAPI
+	mock.Mock
+}
+
+func (m *mockKMS) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kms.EncryptOutput), args.Error(1)
+}
+
+func (m *mockKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kms.DecryptOutput), args.Error(1)
+}
+
+// matches updates of the record of build 1234 to the state
+func updateTo(state string) interface{} {
+	return mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return aws.StringValue(input.TableName) == "sd-idempotency" &&
+			aws.StringValue(input.Key["buildId"].N) == "1234" &&
+			input.ExpressionAttributeValues[":state"] != nil &&
+			aws.StringValue(input.ExpressionAttributeValues[":state"].S) == state &&
+			input.ExpressionAttributeValues[":expiresAt"] != nil
+	})
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(tableEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(tableEnv, "sd-idempotency")
+	assert.Equal(t, &Tracker{table: "sd-idempotency", pendingIndex: "pending"}, FromEnv())
+
+	t.Setenv(pendingIndexEnv, "pending-builds")
+	assert.Equal(t, "pending-builds", FromEnv().pendingIndex)
+}
+
+func TestStartingAndStarted(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("UpdateItem", updateTo(starting)).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	client.On("UpdateItem", updateTo(started)).
+		Return(&dynamodb.UpdateItemOutput{}, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)).Once()
+	tracker := &Tracker{client: client, table: "sd-idempotency"}
+
+	stopped, err := tracker.Starting(1234, "")
+	assert.Nil(t, err)
+	assert.False(t, stopped)
+
+	stopped, err = tracker.Started(1234, "")
+	assert.Nil(t, err)
+	assert.True(t, stopped)
+
+	client.On("UpdateItem", updateTo(starting)).Return(&dynamodb.UpdateItemOutput{}, errors.New("throttled")).Once()
+	_, err = tracker.Starting(1234, "")
+	assert.EqualError(t, err, "Error-UpdateItem: throttled")
+	client.AssertExpectations(t)
+}
+
+func TestAbort(t *testing.T) {
+	testCases := []struct {
+		message  string
+		previous map[string]*dynamodb.AttributeValue
+		expected bool
+	}{
+		{message: "starting", previous: map[string]*dynamodb.AttributeValue{"state": {S: aws.String(starting)}}, expected: true},
+		{message: "started", previous: map[string]*dynamodb.AttributeValue{"state": {S: aws.String(started)}}},
+		{message: "unknown"},
+	}
+	for _, tc := range testCases {
+		client := new(mockDynamoDB)
+		client.On("UpdateItem", updateTo(Aborted)).Return(&dynamodb.UpdateItemOutput{Attributes: tc.previous}, nil)
+		tracker := &Tracker{client: client, table: "sd-idempotency"}
+
+		starting, err := tracker.Abort(1234, "")
+		assert.Nil(t, err, tc.message)
+		assert.Equal(t, tc.expected, starting, tc.message)
+	}
+}
+
+func TestPendingVerification(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return aws.StringValue(input.Key["buildId"].N) == "1234" &&
+			aws.StringValue(input.Key["buildKey"].S) == "1234-arm64" &&
+			input.ExpressionAttributeValues[":message"] != nil &&
+			aws.StringValue(input.ExpressionAttributeValues[":architecture"].S) == "arm64" &&
+			aws.StringValue(input.ExpressionAttributeValues[":message"].S) == "eyJqb2IiOiAic3RhcnQifQ==" &&
+			aws.StringValue(input.ExpressionAttributeValues[":pending"].S) == "true" &&
+			input.ExpressionAttributeValues[":sealedToken"] == nil &&
+			aws.StringValue(input.UpdateExpression) == "SET pending = :pending, pendingAt = :pendingAt, message = :message, architecture = :architecture, expiresAt = :expiresAt"
+	})).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	client.On("Query", &dynamodb.QueryInput{
+		TableName:                 aws.String("sd-idempotency"),
+		IndexName:                 aws.String("pending"),
+		KeyConditionExpression:    aws.String("pending = :pending"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pending": {S: aws.String("true")}},
+		Limit:                     aws.Int64(10),
+	}).Return(&dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{
+		"buildId":      {N: aws.String("1234")},
+		"buildKey":     {S: aws.String("1234-arm64")},
+		"architecture": {S: aws.String("arm64")},
+		"state":        {S: aws.String(starting)},
+		"pending":      {S: aws.String("true")},
+		"pendingAt":    {N: aws.String("1646128800")},
+		"message":      {S: aws.String("eyJqb2IiOiAic3RhcnQifQ==")},
+	}}}, nil).Once()
+	client.On("UpdateItem", &dynamodb.UpdateItemInput{
+		TableName:        aws.String("sd-idempotency"),
+		Key:              key(1234, "arm64"),
+		UpdateExpression: aws.String("REMOVE pending, pendingAt, message, sealedToken"),
+	}).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	tracker := &Tracker{client: client, table: "sd-idempotency", pendingIndex: "pending"}
+
+	// without a kms key the token is not kept
+	assert.Nil(t, tracker.Pending(1234, "arm64", "eyJqb2IiOiAic3RhcnQifQ==", "testtoken"))
+	records, err := tracker.ListPending(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{BuildID: 1234, Architecture: "arm64", State: "STARTING", PendingAt: 1646128800, Message: "eyJqb2IiOiAic3RhcnQifQ=="}}, records)
+	assert.Nil(t, tracker.Resolve(records[0].BuildID, records[0].Architecture))
+	client.AssertExpectations(t)
+}
+
+func TestSealedToken(t *testing.T) {
+	encryption := map[string]*string{"buildKey": aws.String("1234-arm64")}
+	keys := new(mockKMS)
+	keys.On("Encrypt", &kms.EncryptInput{
+		KeyId:             aws.String("alias/sd-idempotency"),
+		Plaintext:         []byte("testtoken"),
+		EncryptionContext: encryption,
+	}).Return(&kms.EncryptOutput{CiphertextBlob: []byte("sealed")}, nil).Once()
+	keys.On("Decrypt", &kms.DecryptInput{CiphertextBlob: []byte("sealed"), EncryptionContext: encryption}).
+		Return(&kms.DecryptOutput{Plaintext: []byte("testtoken")}, nil).Once()
+	client := new(mockDynamoDB)
+	client.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return input.ExpressionAttributeValues[":sealedToken"] != nil &&
+			string(input.ExpressionAttributeValues[":sealedToken"].B) == "sealed" &&
+			aws.StringValue(input.UpdateExpression) == "SET pending = :pending, pendingAt = :pendingAt, message = :message, architecture = :architecture, expiresAt = :expiresAt, sealedToken = :sealedToken"
+	})).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	client.On("Query", mock.Anything).Return(&dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{
+		"buildId":      {N: aws.String("1234")},
+		"architecture": {S: aws.String("arm64")},
+		"pendingAt":    {N: aws.String("1646128800")},
+		"message":      {S: aws.String("{}")},
+		"sealedToken":  {B: []byte("sealed")},
+	}}}, nil).Once()
+	tracker := &Tracker{client: client, kms: keys, table: "sd-idempotency", pendingIndex: "pending", kmsKey: "alias/sd-idempotency"}
+
+	assert.Nil(t, tracker.Pending(1234, "arm64", "{}", "testtoken"))
+	records, err := tracker.ListPending(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{BuildID: 1234, Architecture: "arm64", PendingAt: 1646128800, Message: "{}", SealedToken: []byte("sealed"), Token: "testtoken"}}, records)
+	keys.AssertExpectations(t)
+
+	keys.On("Encrypt", mock.Anything).Return(&kms.EncryptOutput{}, errors.New("AccessDeniedException")).Once()
+	assert.EqualError(t, tracker.Pending(1234, "arm64", "{}", "testtoken"), "Error-Encrypt: AccessDeniedException")
+}
+
+func TestListPendingOldestFirst(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("Query", mock.Anything).Return(&dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{
+		{"buildId": {N: aws.String("2")}, "pendingAt": {N: aws.String("1646128900")}},
+		{"buildId": {N: aws.String("1")}, "pendingAt": {N: aws.String("1646128800")}},
+		{"buildId": {N: aws.String("3")}, "pendingAt": {N: aws.String("1646129000")}},
+	}}, nil).Once()
+	tracker := &Tracker{client: client, table: "sd-idempotency", pendingIndex: "pending"}
+
+	records, err := tracker.ListAwaitingHeartbeat(10)
+	assert.Nil(t, err)
+	var builds []int
+	for _, record := range records {
+		builds = append(builds, record.BuildID)
+	}
+	assert.Equal(t, []int{1, 2, 3}, builds)
+}
+
+func TestHeartbeat(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("UpdateItem", mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return input.ExpressionAttributeValues[":pending"] != nil &&
+			aws.StringValue(input.ExpressionAttributeValues[":pending"].S) == "heartbeat" &&
+			aws.StringValue(input.ConditionExpression) == "attribute_not_exists(heartbeatAt)" &&
+			input.ExpressionAttributeValues[":message"] != nil
+	})).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	client.On("Query", mock.MatchedBy(func(input *dynamodb.QueryInput) bool {
+		return aws.StringValue(input.ExpressionAttributeValues[":pending"].S) == "heartbeat"
+	})).Return(&dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{
+		"buildId":   {N: aws.String("1234")},
+		"state":     {S: aws.String(started)},
+		"pending":   {S: aws.String("heartbeat")},
+		"pendingAt": {N: aws.String("1646128800")},
+		"message":   {S: aws.String("eyJqb2IiOiAic3RhcnQifQ==")},
+	}}}, nil).Once()
+	tracker := &Tracker{client: client, table: "sd-idempotency", pendingIndex: "pending"}
+
+	assert.Nil(t, tracker.AwaitHeartbeat(1234, "", "eyJqb2IiOiAic3RhcnQifQ==", ""))
+	records, err := tracker.ListAwaitingHeartbeat(10)
+	assert.Nil(t, err)
+	assert.Equal(t, []Record{{BuildID: 1234, State: "STARTED", PendingAt: 1646128800, Message: "eyJqb2IiOiAic3RhcnQifQ=="}}, records)
+	client.AssertExpectations(t)
+
+	// the launcher posted its heartbeat before the build was recorded awaiting it
+	client = new(mockDynamoDB)
+	client.On("UpdateItem", mock.Anything).
+		Return(&dynamodb.UpdateItemOutput{}, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)).Once()
+	client.On("UpdateItem", mock.Anything).Return(&dynamodb.UpdateItemOutput{}, errors.New("throttled")).Once()
+	tracker = &Tracker{client: client, table: "sd-idempotency", pendingIndex: "pending"}
+	assert.Nil(t, tracker.AwaitHeartbeat(1234, "", "eyJqb2IiOiAic3RhcnQifQ==", ""))
+	assert.EqualError(t, tracker.AwaitHeartbeat(1234, "", "eyJqb2IiOiAic3RhcnQifQ==", ""), "Error-UpdateItem: throttled")
+}
+
+func TestRecordHeartbeat(t *testing.T) {
+	awaiting := mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return aws.StringValue(input.ConditionExpression) == "pending = :pending" &&
+			aws.StringValue(input.ExpressionAttributeValues[":hostname"].S) == "ip-10-0-1-12"
+	})
+	known := mock.MatchedBy(func(input *dynamodb.UpdateItemInput) bool {
+		return aws.StringValue(input.ConditionExpression) == "attribute_exists(buildKey)"
+	})
+	conditionFailed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+
+	// a build awaiting its heartbeat leaves the pending index
+	client := new(mockDynamoDB)
+	client.On("UpdateItem", awaiting).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	tracker := &Tracker{client: client, table: "sd-idempotency"}
+	found, err := tracker.Heartbeat(1234, "", "ip-10-0-1-12")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	client.AssertNotCalled(t, "UpdateItem", known)
+
+	// other known builds only record the heartbeat, unknown builds are reported
+	client = new(mockDynamoDB)
+	client.On("UpdateItem", awaiting).Return(&dynamodb.UpdateItemOutput{}, conditionFailed)
+	client.On("UpdateItem", known).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	client.On("UpdateItem", known).Return(&dynamodb.UpdateItemOutput{}, conditionFailed).Once()
+	tracker = &Tracker{client: client, table: "sd-idempotency"}
+	found, err = tracker.Heartbeat(1234, "", "ip-10-0-1-12")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	found, err = tracker.Heartbeat(1234, "", "ip-10-0-1-12")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	client = new(mockDynamoDB)
+	client.On("UpdateItem", awaiting).Return(&dynamodb.UpdateItemOutput{}, errors.New("throttled"))
+	tracker = &Tracker{client: client, table: "sd-idempotency"}
+	_, err = tracker.Heartbeat(1234, "", "ip-10-0-1-12")
+	assert.EqualError(t, err, "Error-UpdateItem: throttled")
+}
@@ -0,0 +1,138 @@
+// Package annotations translates the screwdriver.cd/* job annotations of a build into provider overrides
+package annotations
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/screwdriver-cd/aws-consumer-service/sizing"
+)
+
+const (
+	// Field is the build config field holding the job annotations
+	Field = "annotations"
+	// Prefix of the annotations read by the executors
+	Prefix = "screwdriver.cd/"
+)
+
+// named levels of the cpu (vCPUs), ram (GiB) and disk (GiB) annotations, as supported by sd-executor-k8s
+var levels = map[string]map[string]float64{
+	"cpu":  {"MICRO": 0.5, "LOW": 2, "HIGH": 6, "TURBO": 12},
+	"ram":  {"MICRO": 1, "LOW": 2, "HIGH": 12, "TURBO": 16},
+	"disk": {"LOW": 64, "HIGH": 128},
+}
+
+// gets an annotation value as a string, annotations may be decoded as strings, numbers or booleans
+func value(annotations map[string]interface{}, name string) (string, bool) {
+	switch v := annotations[Prefix+name].(type) {
+	case string:
+		return strings.TrimSpace(v), strings.TrimSpace(v) != ""
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// gets a named level or a positive number of a sizing annotation
+func level(annotations map[string]interface{}, name string) (float64, bool, error) {
+	v, ok := value(annotations, name)
+	if !ok {
+		return 0, false, nil
+	}
+	if n, ok := levels[name][strings.ToUpper(v)]; ok {
+		return n, true, nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		names := make([]string, 0, len(levels[name]))
+		for l := range levels[name] {
+			names = append(names, l)
+		}
+		sort.Slice(names, func(i, j int) bool { return levels[name][names[i]] < levels[name][names[j]] })
+		return 0, false, fmt.Errorf("annotation %s%s %q must be one of %s or a positive number", Prefix, name, v, strings.Join(names, ", "))
+	}
+	return n, true, nil
+}
+
+// gets the service containers of the services annotation, a json list of objects
+func services(annotations map[string]interface{}) ([]interface{}, bool, error) {
+	switch v := annotations[Prefix+"services"].(type) {
+	case []interface{}:
+		return v, true, nil
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil, false, nil
+		}
+		decoder := json.NewDecoder(strings.NewReader(v))
+		decoder.UseNumber()
+		var list []interface{}
+		if err := decoder.Decode(&list); err != nil {
+			return nil, false, fmt.Errorf("annotation %sservices must be a json list of services: %v", Prefix, err)
+		}
+		return list, true, nil
+	case nil:
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("annotation %sservices must be a json list of services", Prefix)
+}
+
+// Apply translates the cpu, ram, disk, dockerEnabled, architecture and services annotations of the build
+// into overrides of its provider, annotations take precedence over the provider.
+// The executor annotation is resolved by the queue service into the executor type of the message,
+// a provider executor takes precedence over it there.
+func Apply(buildConfig map[string]interface{}) error {
+	annotations, _ := buildConfig[Field].(map[string]interface{})
+	if len(annotations) == 0 {
+		return nil
+	}
+	provider := buildConfig["provider"].(map[string]interface{})
+
+	cpu, ok, err := level(annotations, "cpu")
+	if err != nil {
+		return err
+	}
+	if ok {
+		provider[sizing.CPUField] = json.Number(strconv.FormatFloat(cpu, 'f', -1, 64))
+	}
+	ram, ok, err := level(annotations, "ram")
+	if err != nil {
+		return err
+	}
+	if ok {
+		provider[sizing.MemoryField] = json.Number(strconv.FormatFloat(ram*1024, 'f', 0, 64))
+	}
+	disk, ok, err := level(annotations, "disk")
+	if err != nil {
+		return err
+	}
+	if ok {
+		provider[sizing.DiskField] = json.Number(strconv.FormatFloat(disk, 'f', 0, 64))
+	}
+
+	if v, ok := value(annotations, "dockerEnabled"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("annotation %sdockerEnabled %q must be a boolean", Prefix, v)
+		}
+		// docker in the build container needs a privileged container
+		if enabled {
+			provider["privilegedMode"] = true
+		}
+	}
+	if v, ok := value(annotations, "architecture"); ok {
+		provider["architecture"] = v
+	}
+	list, ok, err := services(annotations)
+	if err != nil {
+		return err
+	}
+	if ok {
+		provider["services"] = list
+	}
+	return nil
+}
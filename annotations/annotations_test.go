@@ -0,0 +1,66 @@
+package annotations
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBuildConfig(annotations map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"annotations": annotations,
+		"provider":    map[string]interface{}{"privilegedMode": false, "computeType": "BUILD_GENERAL1_SMALL"},
+	}
+}
+
+func TestApply(t *testing.T) {
+	buildConfig := testBuildConfig(map[string]interface{}{
+		"screwdriver.cd/cpu":               "HIGH",
+		"screwdriver.cd/ram":               json.Number("4"),
+		"screwdriver.cd/disk":              "high",
+		"screwdriver.cd/executor":          "sls",
+		"screwdriver.cd/dockerEnabled":     true,
+		"screwdriver.cd/architecture":      "arm64",
+		"screwdriver.cd/buildPeriodically": "H 0 * * *",
+	})
+	assert.Nil(t, Apply(buildConfig))
+	assert.Equal(t, map[string]interface{}{
+		"privilegedMode": true,
+		"computeType":    "BUILD_GENERAL1_SMALL",
+		"cpu":            json.Number("6"),
+		"memory":         json.Number("4096"),
+		"disk":           json.Number("128"),
+		"architecture":   "arm64",
+	}, buildConfig["provider"])
+
+	buildConfig = testBuildConfig(map[string]interface{}{"screwdriver.cd/cpu": "0.5", "screwdriver.cd/dockerEnabled": "false"})
+	assert.Nil(t, Apply(buildConfig))
+	assert.Equal(t, map[string]interface{}{"privilegedMode": false, "computeType": "BUILD_GENERAL1_SMALL", "cpu": json.Number("0.5")}, buildConfig["provider"])
+
+	buildConfig = testBuildConfig(map[string]interface{}{
+		"screwdriver.cd/services": `[{"name": "postgres", "image": "postgres:14", "port": 5432, "ready": "pg_isready -U postgres"}]`,
+	})
+	assert.Nil(t, Apply(buildConfig))
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"name": "postgres", "image": "postgres:14", "port": json.Number("5432"), "ready": "pg_isready -U postgres",
+	}}, buildConfig["provider"].(map[string]interface{})["services"])
+
+	buildConfig = map[string]interface{}{"provider": map[string]interface{}{}}
+	assert.Nil(t, Apply(buildConfig))
+	assert.Empty(t, buildConfig["provider"])
+}
+
+func TestApplyInvalid(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		`annotation screwdriver.cd/cpu "EXTREME" must be one of MICRO, LOW, HIGH, TURBO or a positive number`:                      {"screwdriver.cd/cpu": "EXTREME"},
+		`annotation screwdriver.cd/ram "-2" must be one of MICRO, LOW, HIGH, TURBO or a positive number`:                           {"screwdriver.cd/ram": json.Number("-2")},
+		`annotation screwdriver.cd/disk "huge" must be one of LOW, HIGH or a positive number`:                                      {"screwdriver.cd/disk": "huge"},
+		`annotation screwdriver.cd/dockerEnabled "yes please" must be a boolean`:                                                   {"screwdriver.cd/dockerEnabled": "yes please"},
+		`annotation screwdriver.cd/services must be a json list of services: invalid character 'p' looking for beginning of value`: {"screwdriver.cd/services": "postgres:14"},
+		`annotation screwdriver.cd/services must be a json list of services`:                                                       {"screwdriver.cd/services": json.Number("1")},
+	}
+	for expected, annotations := range tests {
+		assert.EqualError(t, Apply(testBuildConfig(annotations)), expected)
+	}
+}
@@ -0,0 +1,144 @@
+// Package archive writes the consumed kafka records to S3 for audits and replays, with the secrets of their build
+// messages redacted
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/message"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+)
+
+const (
+	bucketEnv = "SD_ARCHIVE_BUCKET"
+	prefixEnv = "SD_ARCHIVE_PREFIX"
+
+	defaultPrefix = "records"
+)
+
+// Record is an archived kafka record
+type Record struct {
+	Topic     string            `json:"topic"`
+	Partition int64             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	// Message is the redacted build message, missing when the value can't be decoded
+	Message json.RawMessage `json:"message,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Archive writes records to an s3 bucket, one object of json lines per batch of a partition
+type Archive struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+	now    func() time.Time
+}
+
+// FromEnv returns the archive of SD_ARCHIVE_BUCKET under SD_ARCHIVE_PREFIX, nil when archiving is disabled
+func FromEnv() *Archive {
+	bucket := os.Getenv(bucketEnv)
+	if bucket == "" {
+		return nil
+	}
+	prefix := strings.Trim(os.Getenv(prefixEnv), "/")
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &Archive{bucket: bucket, prefix: prefix, now: time.Now}
+}
+
+// gets the s3 client, creating it on first use
+func (a *Archive) s3() (s3iface.S3API, error) {
+	if a.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		a.client = s3.New(sess)
+	}
+	return a.client, nil
+}
+
+// NewRecord gets the archived record of a kafka record, redacting its build message
+func NewRecord(record events.KafkaRecord) Record {
+	archived := Record{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp.UTC(),
+	}
+	for _, header := range record.Headers {
+		for key, value := range header {
+			if archived.Headers == nil {
+				archived.Headers = map[string]string{}
+			}
+			archived.Headers[key] = redact.String(string(value))
+		}
+	}
+	data, err := message.DecodeValue(record.Value)
+	if err == nil && !json.Valid(data) {
+		err = fmt.Errorf("record value is not json")
+	}
+	if err != nil {
+		archived.Error = err.Error()
+		return archived
+	}
+	archived.Message = json.RawMessage(redact.JSON(string(data)))
+	return archived
+}
+
+// Key gets the key of the batch starting with the record, partitioned by date and topic
+func (a *Archive) Key(first Record) string {
+	date := first.Timestamp
+	if date.IsZero() {
+		date = a.now()
+	}
+	return fmt.Sprintf("%s/dt=%s/topic=%s/%d-%d.jsonl", a.prefix, date.UTC().Format("2006-01-02"), first.Topic, first.Partition, first.Offset)
+}
+
+// Archive uploads the records of a partition batch as json lines, returns the key of the object
+func (a *Archive) Archive(records []events.KafkaRecord) (string, error) {
+	if len(records) == 0 {
+		return "", nil
+	}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	var first Record
+	for i, record := range records {
+		archived := NewRecord(record)
+		if i == 0 {
+			first = archived
+		}
+		if err := encoder.Encode(archived); err != nil {
+			return "", fmt.Errorf("Error encoding record %v: %v", record.Offset, err)
+		}
+	}
+	client, err := a.s3()
+	if err != nil {
+		return "", err
+	}
+	key := a.Key(first)
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(a.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error-PutObject: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockS3 struct {
+	s3iface.S3API
+	inputs []*s3.PutObjectInput
+	bodies []string
+	err    error
+}
+
+func (m *mockS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, _ := io.ReadAll(input.Body)
+	m.inputs = append(m.inputs, input)
+	m.bodies = append(m.bodies, string(body))
+	return &s3.PutObjectOutput{}, m.err
+}
+
+const testMessage = `{"job": "start", "executorType": "sls", "buildConfig": {"buildId": 1234, "token": "eyJhbGciOi.eyJzdWIiOi.c2ln"}}`
+
+func testRecord(offset int64, value string) events.KafkaRecord {
+	return events.KafkaRecord{
+		Topic:     "builds",
+		Partition: 2,
+		Offset:    offset,
+		Timestamp: events.MilliSecondsEpochTime{Time: time.Date(2022, 3, 1, 23, 59, 0, 0, time.UTC)},
+		Value:     value,
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(bucketEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(bucketEnv, "sd-audit")
+	a := FromEnv()
+	assert.Equal(t, "sd-audit", a.bucket)
+	assert.Equal(t, "records", a.prefix)
+
+	t.Setenv(prefixEnv, "/consumer/records/")
+	assert.Equal(t, "consumer/records", FromEnv().prefix)
+}
+
+func TestNewRecord(t *testing.T) {
+	record := testRecord(12, base64.StdEncoding.EncodeToString([]byte(testMessage)))
+	record.Headers = []map[string][]byte{{"sd-job": []byte("start")}}
+	archived := NewRecord(record)
+	assert.Equal(t, "builds", archived.Topic)
+	assert.Equal(t, int64(12), archived.Offset)
+	assert.Equal(t, map[string]string{"sd-job": "start"}, archived.Headers)
+	assert.Equal(t, `{"buildConfig":{"buildId":1234,"token":"***"},"executorType":"sls","job":"start"}`, string(archived.Message))
+	assert.Empty(t, archived.Error)
+
+	plain := NewRecord(testRecord(13, testMessage))
+	assert.Equal(t, archived.Message, plain.Message)
+
+	invalid := NewRecord(testRecord(14, "not a message"))
+	assert.Nil(t, invalid.Message)
+	assert.Equal(t, "Error decoding base64 message: illegal base64 data at input byte 3", invalid.Error)
+
+	truncated := NewRecord(testRecord(15, `{"job": "start", "buildConfig": {"token": "eyJhbGciOi.eyJzdWIiOi.c2ln"`))
+	assert.Nil(t, truncated.Message)
+	assert.Equal(t, "record value is not json", truncated.Error)
+}
+
+func TestArchive(t *testing.T) {
+	client := &mockS3{}
+	a := &Archive{client: client, bucket: "sd-audit", prefix: "records", now: time.Now}
+
+	key, err := a.Archive([]events.KafkaRecord{testRecord(12, testMessage), testRecord(13, "not a message")})
+	assert.Nil(t, err)
+	assert.Equal(t, "records/dt=2022-03-01/topic=builds/2-12.jsonl", key)
+	assert.Equal(t, "sd-audit", *client.inputs[0].Bucket)
+	assert.Equal(t, key, *client.inputs[0].Key)
+	lines := strings.Split(strings.TrimSpace(client.bodies[0]), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, `{"topic":"builds","partition":2,"offset":12,"timestamp":"2022-03-01T23:59:00Z","message":{"buildConfig":{"buildId":1234,"token":"***"},"executorType":"sls","job":"start"}}`, lines[0])
+	assert.NotContains(t, client.bodies[0], "eyJhbGciOi")
+
+	key, err = a.Archive(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "", key)
+	assert.Equal(t, 1, len(client.inputs))
+
+	client.err = errors.New("AccessDenied: Access Denied")
+	_, err = a.Archive([]events.KafkaRecord{testRecord(14, testMessage)})
+	assert.EqualError(t, err, "Error-PutObject: AccessDenied: Access Denied")
+}
+
+func TestKey(t *testing.T) {
+	a := &Archive{prefix: "records", now: func() time.Time { return time.Date(2022, 3, 2, 1, 0, 0, 0, time.UTC) }}
+	assert.Equal(t, "records/dt=2022-03-02/topic=builds/0-7.jsonl", a.Key(Record{Topic: "builds", Offset: 7}))
+}
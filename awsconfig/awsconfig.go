@@ -0,0 +1,166 @@
+// Package awsconfig builds partition aware aws sessions honoring FIPS and VPC endpoint overrides
+package awsconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/screwdriver-cd/aws-consumer-service/fault"
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+)
+
+const (
+	// DefaultPartition is the partition of the commercial aws regions
+	DefaultPartition = endpoints.AwsPartitionID
+	// fipsEnv enables FIPS endpoints for all aws service clients
+	fipsEnv = "SD_AWS_USE_FIPS"
+	// endpointEnvPrefix is followed by the upper cased service endpoint id, e.g. SD_AWS_ENDPOINT_CODEBUILD
+	endpointEnvPrefix = "SD_AWS_ENDPOINT_"
+	// endpointsEnv holds a json map of service endpoint id to url, e.g. {"s3": "https://bucket.vpce-0a1b-c2d3.s3.{region}.vpce.amazonaws.com"}
+	endpointsEnv = "SD_AWS_ENDPOINTS"
+	// s3PathStyleEnv forces path style s3 addressing, required by some s3 interface endpoints
+	s3PathStyleEnv = "SD_AWS_S3_FORCE_PATH_STYLE"
+	// regionPlaceholder is replaced with the signing region in endpoint overrides
+	regionPlaceholder = "{region}"
+)
+
+// Partition returns the partition id (aws, aws-us-gov, aws-cn) of the region
+func Partition(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+	return DefaultPartition
+}
+
+// ValidateRegion returns an error if the region does not belong to any known partition
+func ValidateRegion(region string) error {
+	if region == "" {
+		return fmt.Errorf("region is empty")
+	}
+	if _, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); !ok {
+		return fmt.Errorf("region %q does not belong to a known aws partition", region)
+	}
+	return nil
+}
+
+// ARN builds an arn in the partition of the region
+func ARN(region, service, accountID, resource string) string {
+	return arn.ARN{
+		Partition: Partition(region),
+		Service:   service,
+		Region:    region,
+		AccountID: accountID,
+		Resource:  resource,
+	}.String()
+}
+
+// consoleHosts maps partitions to their aws console host
+var consoleHosts = map[string]string{
+	endpoints.AwsPartitionID:      "console.aws.amazon.com",
+	endpoints.AwsUsGovPartitionID: "console.amazonaws-us-gov.com",
+	endpoints.AwsCnPartitionID:    "console.amazonaws.cn",
+}
+
+// ConsoleURL builds an aws console url in the partition of the region, path may include a query and fragment
+func ConsoleURL(region, path string) string {
+	host, ok := consoleHosts[Partition(region)]
+	if !ok {
+		host = consoleHosts[DefaultPartition]
+	}
+	return "https://" + host + "/" + strings.TrimPrefix(path, "/")
+}
+
+// UseFIPS returns true if FIPS endpoints are enabled via SD_AWS_USE_FIPS
+func UseFIPS() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(fipsEnv))
+	return enabled
+}
+
+// gets the endpoint override of a service, SD_AWS_ENDPOINT_<SERVICE> takes precedence over SD_AWS_ENDPOINTS
+func endpointOverride(service string) (string, error) {
+	if url := os.Getenv(endpointEnvPrefix + strings.ToUpper(service)); url != "" {
+		return url, nil
+	}
+	value := os.Getenv(endpointsEnv)
+	if value == "" {
+		return "", nil
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return "", fmt.Errorf("Got error parsing %s: %v", endpointsEnv, err)
+	}
+	return overrides[service], nil
+}
+
+// resolves service endpoints from the configured overrides, falling back to the default resolver
+func endpointResolver(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+	url, err := endpointOverride(service)
+	if err != nil {
+		return endpoints.ResolvedEndpoint{}, err
+	}
+	if url != "" {
+		return endpoints.ResolvedEndpoint{
+			URL:           strings.ReplaceAll(url, regionPlaceholder, region),
+			PartitionID:   Partition(region),
+			SigningRegion: region,
+		}, nil
+	}
+	return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+}
+
+// Config returns the aws config for the region with FIPS and endpoint overrides applied.
+// An empty region is left to the sdk defaults (AWS_REGION).
+func Config(region string) *aws.Config {
+	config := &aws.Config{
+		EndpointResolver:    endpoints.ResolverFunc(endpointResolver),
+		STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
+	}
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+	if UseFIPS() {
+		config.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if forcePathStyle, _ := strconv.ParseBool(os.Getenv(s3PathStyleEnv)); forcePathStyle {
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	return config
+}
+
+// latency of aws api calls including retries
+var apiDuration = metrics.NewHistogram("sd_aws_consumer_aws_api_duration_seconds", "Duration of AWS API calls in seconds", metrics.DefaultBuckets)
+
+// records the latency of a completed aws api call
+func observeAPIDuration(r *request.Request) {
+	labels := callLabels(r)
+	labels["status"] = "success"
+	if r.Error != nil {
+		labels["status"] = "error"
+	}
+	apiDuration.Observe(time.Since(r.Time).Seconds(), labels)
+}
+
+// NewSession returns a new aws session for the region
+func NewSession(region string) (*session.Session, error) {
+	sess, err := session.NewSession(Config(region))
+	if err != nil {
+		return nil, err
+	}
+	sess.Handlers.Complete.PushBack(observeAPIDuration)
+	sess.Handlers.Complete.PushBack(observeAPICall)
+	sess.Handlers.Retry.PushBack(observeThrottle)
+	if injector := fault.FromEnv(); injector != nil {
+		injector.InjectSession(sess)
+	}
+	return sess, nil
+}
@@ -0,0 +1,156 @@
+package awsconfig
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartition(t *testing.T) {
+	tests := map[string]string{
+		"us-west-2":      "aws",
+		"ap-southeast-3": "aws",
+		"us-gov-west-1":  "aws-us-gov",
+		"cn-north-1":     "aws-cn",
+		"cn-northwest-1": "aws-cn",
+		"invalid":        "aws",
+	}
+	for region, expected := range tests {
+		assert.Equal(t, expected, Partition(region), region)
+	}
+}
+
+func TestValidateRegion(t *testing.T) {
+	assert.Nil(t, ValidateRegion("us-east-1"))
+	assert.Nil(t, ValidateRegion("us-gov-east-1"))
+	assert.Nil(t, ValidateRegion("cn-north-1"))
+	assert.EqualError(t, ValidateRegion(""), "region is empty")
+	assert.EqualError(t, ValidateRegion("mars-1"), `region "mars-1" does not belong to a known aws partition`)
+}
+
+func TestARN(t *testing.T) {
+	assert.Equal(t, "arn:aws:codebuild:us-west-2:123:project/build", ARN("us-west-2", "codebuild", "123", "project/build"))
+	assert.Equal(t, "arn:aws-us-gov:codebuild:us-gov-west-1:123:project/build", ARN("us-gov-west-1", "codebuild", "123", "project/build"))
+	assert.Equal(t, "arn:aws-cn:eks:cn-north-1:123:cluster/sd", ARN("cn-north-1", "eks", "123", "cluster/sd"))
+}
+
+func TestConsoleURL(t *testing.T) {
+	assert.Equal(t, "https://console.aws.amazon.com/eks/home?region=us-west-2", ConsoleURL("us-west-2", "/eks/home?region=us-west-2"))
+	assert.Equal(t, "https://console.amazonaws-us-gov.com/eks/home?region=us-gov-west-1", ConsoleURL("us-gov-west-1", "eks/home?region=us-gov-west-1"))
+	assert.Equal(t, "https://console.amazonaws.cn/eks/home", ConsoleURL("cn-north-1", "eks/home"))
+}
+
+func TestConfig(t *testing.T) {
+	t.Setenv(fipsEnv, "")
+	config := Config("us-gov-west-1")
+	assert.Equal(t, "us-gov-west-1", *config.Region)
+	assert.Equal(t, endpoints.FIPSEndpointStateUnset, config.UseFIPSEndpoint)
+
+	t.Setenv(fipsEnv, "true")
+	config = Config("us-gov-west-1")
+	assert.Equal(t, endpoints.FIPSEndpointStateEnabled, config.UseFIPSEndpoint)
+
+	resolved, err := config.EndpointResolver.EndpointFor("codebuild", "us-gov-west-1", endpoints.UseFIPSEndpointOption)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://codebuild-fips.us-gov-west-1.amazonaws.com", resolved.URL)
+}
+
+func TestEndpointOverride(t *testing.T) {
+	t.Setenv("SD_AWS_ENDPOINT_S3", "https://s3.internal.example.com")
+	config := Config("us-west-2")
+
+	resolved, err := config.EndpointResolver.EndpointFor("s3", "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://s3.internal.example.com", resolved.URL)
+	assert.Equal(t, "us-west-2", resolved.SigningRegion)
+
+	resolved, err = config.EndpointResolver.EndpointFor("sts", "cn-north-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://sts.cn-north-1.amazonaws.com.cn", resolved.URL)
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	t.Setenv("SD_AWS_ENDPOINTS", `{"codebuild": "https://vpce-0a1b.codebuild.{region}.vpce.amazonaws.com", "sts": "https://vpce-0c2d.sts.{region}.vpce.amazonaws.com"}`)
+	t.Setenv("SD_AWS_ENDPOINT_STS", "https://sts.internal.example.com")
+	t.Setenv("SD_AWS_S3_FORCE_PATH_STYLE", "true")
+	config := Config("us-west-2")
+	assert.True(t, *config.S3ForcePathStyle)
+
+	resolved, err := config.EndpointResolver.EndpointFor("codebuild", "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://vpce-0a1b.codebuild.us-west-2.vpce.amazonaws.com", resolved.URL)
+
+	resolved, err = config.EndpointResolver.EndpointFor("sts", "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://sts.internal.example.com", resolved.URL)
+
+	resolved, err = config.EndpointResolver.EndpointFor("eks", "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://eks.us-west-2.amazonaws.com", resolved.URL)
+
+	t.Setenv("SD_AWS_ENDPOINTS", "{invalid")
+	_, err = config.EndpointResolver.EndpointFor("eks", "us-west-2")
+	assert.EqualError(t, err, "Got error parsing SD_AWS_ENDPOINTS: invalid character 'i' looking for beginning of object key string")
+}
+
+func TestNewSession(t *testing.T) {
+	sess, err := NewSession("us-gov-east-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "us-gov-east-1", *sess.Config.Region)
+
+	t.Setenv("AWS_REGION", "cn-north-1")
+	assert.Nil(t, Config("").Region)
+	sess, err = NewSession("")
+	assert.Nil(t, err)
+	assert.Equal(t, "cn-north-1", *sess.Config.Region)
+}
+
+func TestNewSessionFaults(t *testing.T) {
+	t.Setenv("SD_FAULTS", `[{"target": "aws", "fault": "throttle", "rate": 1}]`)
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	sess, err := NewSession("us-west-2")
+	assert.Nil(t, err)
+	sess.Config.MaxRetries = aws.Int(0)
+	_, err = sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	var aerr awserr.Error
+	assert.True(t, errors.As(err, &aerr))
+	assert.Equal(t, "ThrottlingException", aerr.Code())
+
+	// invalid rules are skipped
+	t.Setenv("SD_FAULTS", `[{"fault": "throttle", "rate": 3}]`)
+	sess, err = NewSession("us-west-2")
+	assert.Nil(t, err)
+	assert.NotNil(t, sess)
+}
+
+func TestObserveAPIDuration(t *testing.T) {
+	observeAPIDuration(&request.Request{
+		ClientInfo: metadata.ClientInfo{ServiceName: "codebuild"},
+		Operation:  &request.Operation{Name: "StartBuild"},
+		Time:       time.Now().Add(-2 * time.Second),
+	})
+	observeAPIDuration(&request.Request{
+		ClientInfo: metadata.ClientInfo{ServiceName: "codebuild"},
+		Operation:  &request.Operation{Name: "StartBuild"},
+		Time:       time.Now(),
+		Error:      errors.New("ThrottlingException"),
+	})
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf)
+	out := buf.String()
+	assert.True(t, strings.Contains(out, `sd_aws_consumer_aws_api_duration_seconds_bucket{account="shared",operation="StartBuild",service="codebuild",status="success",le="2.5"} 1`), out)
+	assert.True(t, strings.Contains(out, `sd_aws_consumer_aws_api_duration_seconds_count{account="shared",operation="StartBuild",service="codebuild",status="error"} 1`), out)
+}
@@ -0,0 +1,156 @@
+package awsconfig
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+)
+
+const (
+	// apiRateLimitsEnv holds a json map of service:Operation to the calls per second of its account quota,
+	// e.g. {"codebuild:BatchGetBuilds": 20}, calls nearing the limit are warned about
+	apiRateLimitsEnv = "SD_AWS_API_RATE_LIMITS"
+	// share of a rate limit from which calls are warned about
+	apiRateWarnShare = 0.8
+	// sharedAccount labels the calls not naming the role of a provider account, like BatchGetBuilds
+	sharedAccount = "shared"
+)
+
+// window the call rates are counted in
+var apiRateWindow = 10 * time.Second
+
+var (
+	apiCalls     = metrics.NewCounter("sd_aws_consumer_aws_api_calls_total", "AWS API calls by service, operation, account and status")
+	apiThrottles = metrics.NewCounter("sd_aws_consumer_aws_api_throttles_total", "Throttled AWS API call attempts by service, operation and account")
+)
+
+// gets the provider account of a call from the role arns of its input, like the ServiceRoleOverride of StartBuild
+func requestAccount(params interface{}) string {
+	v := reflect.ValueOf(params)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return sharedAccount
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return sharedAccount
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !strings.Contains(v.Type().Field(i).Name, "Role") || field.Kind() != reflect.Ptr || field.IsNil() || field.Elem().Kind() != reflect.String {
+			continue
+		}
+		if roleArn, err := arn.Parse(field.Elem().String()); err == nil && roleArn.AccountID != "" {
+			return roleArn.AccountID
+		}
+	}
+	return sharedAccount
+}
+
+// gets the labels of an aws api call
+func callLabels(r *request.Request) map[string]string {
+	labels := map[string]string{"service": r.ClientInfo.ServiceName, "operation": "", "account": requestAccount(r.Params)}
+	if r.Operation != nil {
+		labels["operation"] = r.Operation.Name
+	}
+	return labels
+}
+
+// callRates counts the calls of each operation and account in the current window, warning about those nearing
+// their rate limit once per window
+type callRates struct {
+	mu     sync.Mutex
+	start  time.Time
+	limits map[string]float64
+	counts map[string]int
+	warned map[string]bool
+}
+
+var rates = &callRates{}
+
+// gets the rate limits of SD_AWS_API_RATE_LIMITS, none when unset or invalid
+func apiRateLimits() map[string]float64 {
+	value := os.Getenv(apiRateLimitsEnv)
+	if value == "" {
+		return nil
+	}
+	var limits map[string]float64
+	if err := json.Unmarshal([]byte(value), &limits); err != nil {
+		log.Printf("Got error parsing %s: %v", apiRateLimitsEnv, err)
+		return nil
+	}
+	return limits
+}
+
+// starts a new window with the current limits when the last one is over
+func (c *callRates) roll(at time.Time) {
+	if at.Sub(c.start) >= apiRateWindow {
+		c.start = at
+		c.limits = apiRateLimits()
+		c.counts = map[string]int{}
+		c.warned = map[string]bool{}
+	}
+}
+
+// counts a call of the window
+func (c *callRates) add(service, operation, account string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(at)
+	limit, ok := c.limits[service+":"+operation]
+	if !ok || limit <= 0 {
+		return
+	}
+	key := service + ":" + operation + ":" + account
+	c.counts[key]++
+	rate := float64(c.counts[key]) / apiRateWindow.Seconds()
+	if rate >= limit*apiRateWarnShare && !c.warned[key] {
+		c.warned[key] = true
+		log.Printf("Warning: %s %s calls of account %s are at %.1f/s, nearing the rate limit of %v/s", service, operation, account, rate, limit)
+	}
+}
+
+// warns about throttled calls once per window per operation and account
+func (c *callRates) throttled(service, operation, account string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(at)
+	key := "throttled:" + service + ":" + operation + ":" + account
+	if c.warned[key] {
+		return
+	}
+	c.warned[key] = true
+	log.Printf("Warning: %s %s calls of account %s are throttled", service, operation, account)
+}
+
+// records a completed aws api call and warns when its operation nears the rate limit
+func observeAPICall(r *request.Request) {
+	labels := callLabels(r)
+	rates.add(labels["service"], labels["operation"], labels["account"], time.Now())
+	labels["status"] = "success"
+	if r.Error != nil {
+		labels["status"] = "error"
+	}
+	apiCalls.Inc(labels)
+}
+
+// records a throttled attempt of an aws api call, which is retried, as a metric for throttle alarms
+func observeThrottle(r *request.Request) {
+	if !r.IsErrorThrottle() {
+		return
+	}
+	labels := callLabels(r)
+	apiThrottles.Inc(labels)
+	metrics.Put("AwsApiThrottles", 1, metrics.Count, map[string]string{"Service": labels["service"], "Operation": labels["operation"], "Account": labels["account"]})
+	rates.throttled(labels["service"], labels["operation"], labels["account"], time.Now())
+}
@@ -0,0 +1,108 @@
+package awsconfig
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+)
+
+func TestRequestAccount(t *testing.T) {
+	assert.Equal(t, "111111111111", requestAccount(&codebuild.StartBuildInput{
+		ProjectName:         aws.String("deploy-123"),
+		ServiceRoleOverride: aws.String("arn:aws:iam::111111111111:role/sd-build"),
+	}))
+	assert.Equal(t, "222222222222", requestAccount(&codebuild.CreateProjectInput{ServiceRole: aws.String("arn:aws-us-gov:iam::222222222222:role/sd-build")}))
+	assert.Equal(t, "shared", requestAccount(&codebuild.CreateProjectInput{ServiceRole: aws.String("sd-build")}))
+	assert.Equal(t, "shared", requestAccount(&codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:1"})}))
+	assert.Equal(t, "shared", requestAccount((*codebuild.StartBuildInput)(nil)))
+	assert.Equal(t, "shared", requestAccount(nil))
+}
+
+// captures the log output of fn
+func captureLogs(fn func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	fn()
+	return buf.String()
+}
+
+func TestCallRates(t *testing.T) {
+	t.Setenv("SD_AWS_API_RATE_LIMITS", `{"codebuild:BatchGetBuilds": 1}`)
+	c := &callRates{}
+	start := time.Now()
+
+	// 8 calls in the 10s window reach 80% of 1 call per second
+	out := captureLogs(func() {
+		for i := 0; i < 7; i++ {
+			c.add("codebuild", "BatchGetBuilds", "shared", start)
+			c.add("codebuild", "StartBuild", "shared", start)
+		}
+	})
+	assert.Equal(t, "", out)
+	out = captureLogs(func() {
+		c.add("codebuild", "BatchGetBuilds", "shared", start)
+		c.add("codebuild", "BatchGetBuilds", "shared", start)
+		c.add("codebuild", "BatchGetBuilds", "111111111111", start)
+	})
+	assert.Equal(t, 1, strings.Count(out, "Warning: codebuild BatchGetBuilds calls of account shared are at 0.8/s, nearing the rate limit of 1/s"), out)
+
+	// the next window counts from zero
+	out = captureLogs(func() {
+		c.add("codebuild", "BatchGetBuilds", "shared", start.Add(apiRateWindow))
+	})
+	assert.Equal(t, "", out)
+	assert.Equal(t, 1, c.counts["codebuild:BatchGetBuilds:shared"])
+
+	out = captureLogs(func() {
+		c.throttled("codebuild", "StartBuild", "111111111111", start.Add(apiRateWindow))
+		c.throttled("codebuild", "StartBuild", "111111111111", start.Add(apiRateWindow))
+	})
+	assert.Equal(t, 1, strings.Count(out, "Warning: codebuild StartBuild calls of account 111111111111 are throttled"), out)
+
+	t.Setenv("SD_AWS_API_RATE_LIMITS", "{invalid")
+	assert.Nil(t, apiRateLimits())
+}
+
+func TestObserveThrottles(t *testing.T) {
+	t.Setenv("SD_FAULTS", `[{"target": "aws", "service": "codebuild", "operation": "StartBuild", "fault": "throttle", "rate": 1}]`)
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var emf bytes.Buffer
+	metrics.SetOutput(&emf)
+	defer metrics.SetOutput(os.Stdout)
+
+	sess, err := NewSession("us-west-2")
+	assert.Nil(t, err)
+	sess.Config.Retryer = client.DefaultRetryer{
+		NumMaxRetries:    2,
+		MinRetryDelay:    time.Millisecond,
+		MaxRetryDelay:    time.Millisecond,
+		MinThrottleDelay: time.Millisecond,
+		MaxThrottleDelay: time.Millisecond,
+	}
+	_, err = codebuild.New(sess).StartBuild(&codebuild.StartBuildInput{
+		ProjectName:         aws.String("deploy-123"),
+		ServiceRoleOverride: aws.String("arn:aws:iam::333333333333:role/sd-build"),
+	})
+	assert.NotNil(t, err)
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf)
+	out := buf.String()
+	// every attempt is throttled, the call completes once
+	assert.Contains(t, out, `sd_aws_consumer_aws_api_throttles_total{account="333333333333",operation="StartBuild",service="codebuild"} 3`)
+	assert.Contains(t, out, `sd_aws_consumer_aws_api_calls_total{account="333333333333",operation="StartBuild",service="codebuild",status="error"} 1`)
+	assert.Equal(t, 3, strings.Count(emf.String(), `"Account":"333333333333"`), emf.String())
+	assert.Contains(t, emf.String(), `"AwsApiThrottles":1`)
+}
@@ -0,0 +1,90 @@
+// Package budget tracks the monthly spend of pipelines and provider accounts in a DynamoDB table,
+// which the budget limits of the policy are checked against
+package budget
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const tableEnv = "SD_BUDGET_TABLE"
+
+// Tracker reads and adds spend in a DynamoDB table keyed by the string attributes id (<scope>/<id>) and month (YYYY-MM)
+type Tracker struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+}
+
+// FromEnv returns the tracker of SD_BUDGET_TABLE, nil when budgets are not tracked
+func FromEnv() *Tracker {
+	table := os.Getenv(tableEnv)
+	if table == "" {
+		return nil
+	}
+	return &Tracker{table: table}
+}
+
+// gets the dynamodb client, creating it on first use
+func (t *Tracker) dynamodb() (dynamodbiface.DynamoDBAPI, error) {
+	if t.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		t.client = dynamodb.New(sess)
+	}
+	return t.client, nil
+}
+
+// gets the key of the spend of a pipeline or account in a month
+func key(scope string, id string, month string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id":    {S: aws.String(scope + "/" + id)},
+		"month": {S: aws.String(month)},
+	}
+}
+
+// Spend returns the spend in USD of the pipeline or account in the month, zero if none is recorded
+func (t *Tracker) Spend(scope string, id string, month string) (float64, error) {
+	client, err := t.dynamodb()
+	if err != nil {
+		return 0, err
+	}
+	result, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(t.table),
+		Key:       key(scope, id, month),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error-GetItem: %v", err)
+	}
+	spend, ok := result.Item["spend"]
+	if !ok || spend.N == nil {
+		return 0, nil
+	}
+	return strconv.ParseFloat(*spend.N, 64)
+}
+
+// Add adds the cost in USD to the spend of the pipeline or account in the month
+func (t *Tracker) Add(scope string, id string, month string, cost float64) error {
+	client, err := t.dynamodb()
+	if err != nil {
+		return err
+	}
+	_, err = client.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(t.table),
+		Key:                       key(scope, id, month),
+		UpdateExpression:          aws.String("ADD spend :cost"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":cost": {N: aws.String(strconv.FormatFloat(cost, 'f', -1, 64))}},
+	})
+	if err != nil {
+		return fmt.Errorf("Error-UpdateItem: %v", err)
+	}
+	return nil
+}
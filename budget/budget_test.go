@@ -0,0 +1,76 @@
+package budget
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mock.Mock
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.GetItemOutput), args.Error(1)
+}
+
+func (m *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.UpdateItemOutput), args.Error(1)
+}
+
+func getItemInput(id string) *dynamodb.GetItemInput {
+	return &dynamodb.GetItemInput{
+		TableName: aws.String("sd-budgets"),
+		Key:       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "month": {S: aws.String("2022-03")}},
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(tableEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(tableEnv, "sd-budgets")
+	assert.Equal(t, "sd-budgets", FromEnv().table)
+}
+
+func TestSpend(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("GetItem", getItemInput("pipeline/1898")).
+		Return(&dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{"spend": {N: aws.String("512.3")}}}, nil)
+	client.On("GetItem", getItemInput("account/111111111")).Return(&dynamodb.GetItemOutput{}, nil)
+	client.On("GetItem", getItemInput("pipeline/1")).Return(&dynamodb.GetItemOutput{}, errors.New("throttled"))
+	tracker := &Tracker{client: client, table: "sd-budgets"}
+
+	spend, err := tracker.Spend("pipeline", "1898", "2022-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 512.3, spend)
+
+	spend, err = tracker.Spend("account", "111111111", "2022-03")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, spend)
+
+	_, err = tracker.Spend("pipeline", "1", "2022-03")
+	assert.EqualError(t, err, "Error-GetItem: throttled")
+}
+
+func TestAdd(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("UpdateItem", &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("sd-budgets"),
+		Key:                       getItemInput("pipeline/1898").Key,
+		UpdateExpression:          aws.String("ADD spend :cost"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":cost": {N: aws.String("0.25")}},
+	}).Return(&dynamodb.UpdateItemOutput{}, nil).Once()
+	tracker := &Tracker{client: client, table: "sd-budgets"}
+
+	assert.Nil(t, tracker.Add("pipeline", "1898", "2022-03", 0.25))
+	client.AssertExpectations(t)
+}
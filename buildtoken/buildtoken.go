@@ -0,0 +1,145 @@
+// Package buildtoken exchanges the SD token of a build for a build scoped secret in Secrets Manager, whose
+// resource policy only lets the role of the build read it. Executors pass the reference of the secret into the
+// build environment instead of the token, and the secret is deleted once the build is stopped.
+package buildtoken
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+)
+
+const (
+	prefixEnv = "SD_TOKEN_SECRET_PREFIX"
+
+	// ArnKey is the build config key of the arn of the token secret of the build
+	ArnKey = "tokenSecretArn"
+)
+
+// Exchanger stores build tokens as secrets named by the prefix and the build id, suffixed with the architecture of
+// matrix builds, in the region of the build
+type Exchanger struct {
+	prefix  string
+	mu      sync.Mutex
+	clients map[string]secretsmanageriface.SecretsManagerAPI
+}
+
+// FromEnv returns the exchanger of SD_TOKEN_SECRET_PREFIX, nil when builds get their token directly
+func FromEnv() *Exchanger {
+	prefix := os.Getenv(prefixEnv)
+	if prefix == "" {
+		return nil
+	}
+	return &Exchanger{prefix: prefix, clients: map[string]secretsmanageriface.SecretsManagerAPI{}}
+}
+
+// gets the secrets manager client of the region, creating it on first use
+func (e *Exchanger) secretsmanager(region string) (secretsmanageriface.SecretsManagerAPI, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if client, ok := e.clients[region]; ok {
+		return client, nil
+	}
+	sess, err := awsconfig.NewSession(region)
+	if err != nil {
+		return nil, err
+	}
+	client := secretsmanager.New(sess)
+	e.clients[region] = client
+	return client, nil
+}
+
+// gets the name of the token secret of the build of the architecture
+func (e *Exchanger) name(buildID int, arch string) string {
+	return e.prefix + matrix.Key(buildID, arch)
+}
+
+// gets the resource policy letting only the role read the secret, identity policies of other principals included
+func resourcePolicy(roleArn string) string {
+	policy, _ := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":       "ReadByBuildRole",
+				"Effect":    "Allow",
+				"Principal": map[string]string{"AWS": roleArn},
+				"Action":    "secretsmanager:GetSecretValue",
+				"Resource":  "*",
+			},
+			{
+				"Sid":       "DenyOthers",
+				"Effect":    "Deny",
+				"Principal": "*",
+				"Action":    "secretsmanager:GetSecretValue",
+				"Resource":  "*",
+				"Condition": map[string]interface{}{"StringNotEquals": map[string]string{"aws:PrincipalArn": roleArn}},
+			},
+		},
+	})
+	return string(policy)
+}
+
+// Exchange stores the token of the build in its secret readable by the role, returns the arn of the secret.
+// A retried start replaces the token of the secret it created before.
+func (e *Exchanger) Exchange(region string, buildID int, arch string, token string, roleArn string) (string, error) {
+	client, err := e.secretsmanager(region)
+	if err != nil {
+		return "", err
+	}
+	name := e.name(buildID, arch)
+	var arn string
+	created, err := client.CreateSecret(&secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String(fmt.Sprintf("Screwdriver token of build %d", buildID)),
+		SecretString: aws.String(token),
+		Tags:         []*secretsmanager.Tag{{Key: aws.String("sd-build-id"), Value: aws.String(strconv.Itoa(buildID))}},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+		put, putErr := client.PutSecretValue(&secretsmanager.PutSecretValueInput{SecretId: aws.String(name), SecretString: aws.String(token)})
+		if putErr != nil {
+			return "", fmt.Errorf("Error-PutSecretValue: %v", putErr)
+		}
+		arn = aws.StringValue(put.ARN)
+	} else if err != nil {
+		return "", fmt.Errorf("Error-CreateSecret: %v", err)
+	} else {
+		arn = aws.StringValue(created.ARN)
+	}
+	if _, err := client.PutResourcePolicy(&secretsmanager.PutResourcePolicyInput{
+		SecretId:          aws.String(arn),
+		ResourcePolicy:    aws.String(resourcePolicy(roleArn)),
+		BlockPublicPolicy: aws.Bool(true),
+	}); err != nil {
+		return "", fmt.Errorf("Error-PutResourcePolicy: %v", err)
+	}
+	return arn, nil
+}
+
+// Revoke deletes the token secret of the build without recovery window, a missing secret is already revoked
+func (e *Exchanger) Revoke(region string, buildID int, arch string) error {
+	client, err := e.secretsmanager(region)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteSecret(&secretsmanager.DeleteSecretInput{
+		SecretId:                   aws.String(e.name(buildID, arch)),
+		ForceDeleteWithoutRecovery: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error-DeleteSecret: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package buildtoken
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	testRole   = "arn:aws:iam::123456789012:role/screwdriver/sd-pipeline-1898"
+	testSecret = "arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-tokens/1234-AbCdEf"
+)
+
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	mock.Mock
+}
+
+func (m *mockSecretsManager) CreateSecret(input *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*secretsmanager.CreateSecretOutput), args.Error(1)
+}
+
+func (m *mockSecretsManager) PutSecretValue(input *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*secretsmanager.PutSecretValueOutput), args.Error(1)
+}
+
+func (m *mockSecretsManager) PutResourcePolicy(input *secretsmanager.PutResourcePolicyInput) (*secretsmanager.PutResourcePolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*secretsmanager.PutResourcePolicyOutput), args.Error(1)
+}
+
+func (m *mockSecretsManager) DeleteSecret(input *secretsmanager.DeleteSecretInput) (*secretsmanager.DeleteSecretOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*secretsmanager.DeleteSecretOutput), args.Error(1)
+}
+
+func testExchanger(client *mockSecretsManager) *Exchanger {
+	return &Exchanger{prefix: "sd-build-tokens/", clients: map[string]secretsmanageriface.SecretsManagerAPI{"us-west-2": client}}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(prefixEnv, "")
+	assert.Nil(t, FromEnv())
+	t.Setenv(prefixEnv, "sd-build-tokens/")
+	assert.Equal(t, "sd-build-tokens/", FromEnv().prefix)
+}
+
+func TestResourcePolicy(t *testing.T) {
+	var policy map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(resourcePolicy(testRole)), &policy))
+	statements := policy["Statement"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"AWS": testRole}, statements[0].(map[string]interface{})["Principal"])
+	assert.Equal(t, "Deny", statements[1].(map[string]interface{})["Effect"])
+	assert.Equal(t, map[string]interface{}{"StringNotEquals": map[string]interface{}{"aws:PrincipalArn": testRole}}, statements[1].(map[string]interface{})["Condition"])
+}
+
+func TestExchange(t *testing.T) {
+	client := new(mockSecretsManager)
+	client.On("CreateSecret", mock.MatchedBy(func(input *secretsmanager.CreateSecretInput) bool {
+		return aws.StringValue(input.Name) == "sd-build-tokens/1234" && aws.StringValue(input.SecretString) == "jwt"
+	})).Return(&secretsmanager.CreateSecretOutput{ARN: aws.String(testSecret)}, nil).Once()
+	client.On("PutResourcePolicy", &secretsmanager.PutResourcePolicyInput{
+		SecretId:          aws.String(testSecret),
+		ResourcePolicy:    aws.String(resourcePolicy(testRole)),
+		BlockPublicPolicy: aws.Bool(true),
+	}).Return(&secretsmanager.PutResourcePolicyOutput{}, nil)
+	e := testExchanger(client)
+
+	arn, err := e.Exchange("us-west-2", 1234, "", "jwt", testRole)
+	assert.Nil(t, err)
+	assert.Equal(t, testSecret, arn)
+
+	// a retried start replaces the token
+	client.On("CreateSecret", mock.Anything).Return(&secretsmanager.CreateSecretOutput{}, awserr.New(secretsmanager.ErrCodeResourceExistsException, "already exists", nil))
+	client.On("PutSecretValue", &secretsmanager.PutSecretValueInput{SecretId: aws.String("sd-build-tokens/1234"), SecretString: aws.String("jwt2")}).
+		Return(&secretsmanager.PutSecretValueOutput{ARN: aws.String(testSecret)}, nil)
+	arn, err = e.Exchange("us-west-2", 1234, "", "jwt2", testRole)
+	assert.Nil(t, err)
+	assert.Equal(t, testSecret, arn)
+	client.AssertNumberOfCalls(t, "PutResourcePolicy", 2)
+
+	client = new(mockSecretsManager)
+	client.On("CreateSecret", mock.Anything).Return(&secretsmanager.CreateSecretOutput{}, errors.New("AccessDeniedException"))
+	_, err = testExchanger(client).Exchange("us-west-2", 1234, "", "jwt", testRole)
+	assert.EqualError(t, err, "Error-CreateSecret: AccessDeniedException")
+}
+
+func TestRevoke(t *testing.T) {
+	client := new(mockSecretsManager)
+	deleteInput := &secretsmanager.DeleteSecretInput{SecretId: aws.String("sd-build-tokens/1234"), ForceDeleteWithoutRecovery: aws.Bool(true)}
+	client.On("DeleteSecret", deleteInput).Return(&secretsmanager.DeleteSecretOutput{}, nil).Once()
+	client.On("DeleteSecret", deleteInput).Return(&secretsmanager.DeleteSecretOutput{}, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil)).Once()
+	client.On("DeleteSecret", deleteInput).Return(&secretsmanager.DeleteSecretOutput{}, errors.New("Throttling")).Once()
+	e := testExchanger(client)
+
+	assert.Nil(t, e.Revoke("us-west-2", 1234, ""))
+	assert.Nil(t, e.Revoke("us-west-2", 1234, ""))
+	assert.EqualError(t, e.Revoke("us-west-2", 1234, ""), "Error-DeleteSecret: Throttling")
+
+	// every architecture of a matrix build has its own secret
+	client.On("DeleteSecret", &secretsmanager.DeleteSecretInput{SecretId: aws.String("sd-build-tokens/1234-arm64"), ForceDeleteWithoutRecovery: aws.Bool(true)}).
+		Return(&secretsmanager.DeleteSecretOutput{}, nil).Once()
+	assert.Nil(t, e.Revoke("us-west-2", 1234, "arm64"))
+	client.AssertExpectations(t)
+}
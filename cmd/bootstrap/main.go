@@ -0,0 +1,68 @@
+// Command bootstrap provisions the build bucket of a build region.
+//
+//	bootstrap -region us-east-1 [-home-region us-west-2] [-bucket name] [-dry-run]
+//
+// The bucket name is derived from SD_SLS_BUILD_BUCKET by replacing the region short name of the
+// home region (AWS_REGION by default) with the one of the build region, like the consumer does.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	slsExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/serverless"
+)
+
+var provisionBucket = slsExecutor.ProvisionBucket
+
+// provisions the bucket of the build region named by args, returns the exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	log.SetOutput(stderr)
+
+	flags := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	region := flags.String("region", "", "build region to provision the bucket in")
+	homeRegion := flags.String("home-region", os.Getenv("AWS_REGION"), "region of SD_SLS_BUILD_BUCKET")
+	bucket := flags.String("bucket", "", "bucket name, derived from SD_SLS_BUILD_BUCKET when empty")
+	dryRun := flags.Bool("dry-run", false, "print the bucket without provisioning it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := awsconfig.ValidateRegion(*region); err != nil {
+		fmt.Fprintf(stderr, "invalid -region: %v\n", err)
+		return 2
+	}
+
+	name := *bucket
+	if name == "" {
+		if os.Getenv("SD_SLS_BUILD_BUCKET") == "" {
+			fmt.Fprintln(stderr, "SD_SLS_BUILD_BUCKET is not set, set it or pass -bucket")
+			return 2
+		}
+		var err error
+		name, err = slsExecutor.BucketName(map[string]interface{}{"region": *homeRegion, "buildRegion": *region})
+		if err != nil {
+			fmt.Fprintf(stderr, "Got error getting bucket name: %v\n", err)
+			return 1
+		}
+	}
+
+	if *dryRun {
+		fmt.Fprintf(stdout, "would provision bucket %s in %s\n", name, *region)
+		return 0
+	}
+	if err := provisionBucket(name, *region); err != nil {
+		fmt.Fprintf(stderr, "Got error provisioning bucket %s: %v\n", name, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "provisioned bucket %s in %s\n", name, *region)
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
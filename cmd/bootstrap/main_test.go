@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	slsExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/serverless"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	var provisioned []string
+	provisionBucket = func(bucket string, region string) error {
+		provisioned = append(provisioned, bucket+"/"+region)
+		if region == "eu-west-1" {
+			return errors.New("AccessDenied")
+		}
+		return nil
+	}
+	defer func() { provisionBucket = slsExecutor.ProvisionBucket }()
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-builds-usw2")
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"-region", "us-east-1", "-home-region", "us-west-2"}, &stdout, &stderr), stderr.String())
+	assert.Equal(t, "provisioned bucket sd-builds-use1 in us-east-1\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"-region", "us-east-2", "-home-region", "us-west-2", "-dry-run"}, &stdout, &stderr))
+	assert.Equal(t, "would provision bucket sd-builds-use2 in us-east-2\n", stdout.String())
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"-region", "eu-west-1", "-bucket", "sd-team-builds"}, &stdout, &stderr))
+	assert.Equal(t, "Got error provisioning bucket sd-team-builds: AccessDenied\n", stderr.String())
+	assert.Equal(t, []string{"sd-builds-use1/us-east-1", "sd-team-builds/eu-west-1"}, provisioned)
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"-region", "mars-1"}, &stdout, &stderr))
+	assert.Equal(t, "invalid -region: region \"mars-1\" does not belong to a known aws partition\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"-region", "us-east-1", "-home-region", ""}, &stdout, &stderr))
+	assert.Equal(t, "Got error getting bucket name: invalid region \"\"\n", stderr.String())
+
+	t.Setenv("SD_SLS_BUILD_BUCKET", "")
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"-region", "us-east-1"}, &stdout, &stderr))
+	assert.Equal(t, "SD_SLS_BUILD_BUCKET is not set, set it or pass -bucket\n", stderr.String())
+}
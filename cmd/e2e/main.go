@@ -0,0 +1,142 @@
+// Command e2e runs a build through an executor against local stand-ins of its backend and prints the result.
+//
+//	e2e [-executor sls|eks|fake] [-sample sls|eks] [-timeout 15m] [-interval 5s] [-cleanup] [message.json]
+//
+// The sls executor runs against the LocalStack of SD_E2E_LOCALSTACK_URL and the eks executor against the
+// cluster of SD_E2E_KUBE_SERVER, like an envtest or kind cluster. The fake executor runs builds in memory.
+// The start message is read from stdin when no file or sample is given, like the effective config printed by validate.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/screwdriver-cd/aws-consumer-service/e2e"
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+	eksExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/eks"
+	slsExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/serverless"
+	"github.com/screwdriver-cd/aws-consumer-service/message"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+)
+
+// creates the executor of the name for the build region, never against real aws accounts or clusters
+func newExecutor(name string, region string) (e2e.Executor, error) {
+	switch name {
+	case "fake":
+		return e2e.NewFakeExecutor(), nil
+	case "sls":
+		ok, err := e2e.UseLocalStack()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("SD_E2E_LOCALSTACK_URL is required by the sls executor")
+		}
+		return slsExecutor.New(region)
+	case "eks":
+		if _, err := e2e.UseLocalStack(); err != nil {
+			return nil, err
+		}
+		config, err := e2e.KubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			return nil, fmt.Errorf("SD_E2E_KUBE_SERVER is required by the eks executor")
+		}
+		return eksExecutor.NewForCluster(region, config)
+	}
+	return nil, fmt.Errorf("unknown executor %s", name)
+}
+
+// reads the start message of the sample, the file named by args or stdin
+func readMessage(sample string, args []string, stdin io.Reader) (*message.BuildMessage, error) {
+	if sample != "" {
+		return e2e.SampleMessage(sample)
+	}
+	input := stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("Error opening message: %v", err)
+		}
+		defer f.Close()
+		input = f
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading message: %v", err)
+	}
+	return message.Decode(data)
+}
+
+// runs the build of the message named by args, returns the exit code
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	log.SetOutput(stderr)
+
+	flags := flag.NewFlagSet("e2e", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	executorName := flags.String("executor", "", "executor running the build, sls, eks or fake, the executor type of the message when empty")
+	sample := flags.String("sample", "", "runs the sample start message of the sls or eks executor")
+	timeout := flags.Duration("timeout", 0, "stops the build if it did not finish in time, 15m when zero")
+	interval := flags.Duration("interval", 0, "interval of the status polls, 5s when zero")
+	cleanup := flags.Bool("cleanup", false, "cleans up the build resources after the build finished")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	m, err := readMessage(*sample, flags.Args(), stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if m.Job != "start" {
+		fmt.Fprintf(stderr, "invalid: job must be start, got %s\n", m.Job)
+		return 2
+	}
+	if problems := m.Validate(); len(problems) > 0 {
+		fmt.Fprintf(stderr, "invalid: %s\n", strings.Join(problems, "\ninvalid: "))
+		return 2
+	}
+	name := *executorName
+	if name == "" {
+		name = m.ExecutorType
+	}
+	e, err := newExecutor(name, m.BuildRegion())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	runner := e2e.NewRunner(e)
+	if *timeout > 0 {
+		runner.Timeout = *timeout
+	}
+	if *interval > 0 {
+		runner.Interval = *interval
+	}
+	runner.Cleanup = *cleanup
+	result, err := runner.Run(m.BuildConfig)
+	if result != nil {
+		result.Logs = redact.String(result.Logs)
+		output, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Fprintln(stdout, string(output))
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if result.Status.State != executor.Succeeded {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
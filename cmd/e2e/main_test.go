@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"-executor", "fake", "-sample", "sls", "-interval", "1ms", "-cleanup"}, nil, &stdout, &stderr), stderr.String())
+
+	var result map[string]interface{}
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	assert.Equal(t, "fake-node", result["node"])
+	assert.Equal(t, map[string]interface{}{"state": "SUCCEEDED"}, result["status"])
+	assert.Equal(t, float64(3), result["polls"])
+	assert.Contains(t, result["logs"], "build 1234 started in container node:18")
+}
+
+func TestRunStdin(t *testing.T) {
+	message := `{"job": "start", "executorType": "eks", "buildConfig": {"buildId": 1, "jobId": 2, "jobName": "main", "pipelineId": 3,
+		"apiUri": "a", "storeUri": "s", "uiUri": "u", "token": "t", "buildTimeout": 1,
+		"provider": {"region": "us-west-2", "role": "r", "namespace": "sd", "clusterName": "c", "launcherImage": "l", "launcherVersion": "v"}}}`
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run([]string{"-executor", "fake", "-interval", "1ms"}, strings.NewReader(message), &stdout, &stderr), stderr.String())
+	assert.Contains(t, stdout.String(), `"node": "fake-node"`)
+}
+
+func TestRunInvalid(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run([]string{"-executor", "fake"}, strings.NewReader(`{"job": "stop", "executorType": "sls"}`), &stdout, &stderr))
+	assert.Equal(t, "invalid: job must be start, got stop\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"-executor", "fake"}, strings.NewReader(`{"job": "start", "executorType": "sls", "buildConfig": {}}`), &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "invalid: buildConfig.buildId is required")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"-executor", "ecs", "-sample", "sls"}, nil, &stdout, &stderr))
+	assert.Equal(t, "unknown executor ecs\n", stderr.String())
+	assert.Empty(t, stdout.String())
+}
+
+func TestRunRequiresLocalBackends(t *testing.T) {
+	t.Setenv("SD_E2E_LOCALSTACK_URL", "")
+	t.Setenv("SD_E2E_KUBE_SERVER", "")
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run([]string{"-sample", "sls"}, nil, &stdout, &stderr))
+	assert.Equal(t, "SD_E2E_LOCALSTACK_URL is required by the sls executor\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"-sample", "eks"}, nil, &stdout, &stderr))
+	assert.Equal(t, "SD_E2E_KUBE_SERVER is required by the eks executor\n", stderr.String())
+}
@@ -0,0 +1,128 @@
+// Command validate checks a build message without side effects and prints the effective build config.
+//
+//	validate [message.json]
+//
+// The message is read from stdin when no file is given.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/screwdriver-cd/aws-consumer-service/annotations"
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	slsExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/serverless"
+	"github.com/screwdriver-cd/aws-consumer-service/image"
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	"github.com/screwdriver-cd/aws-consumer-service/message"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/sizing"
+)
+
+var loadPolicy = policy.Load
+
+// resolves the effective build config the consumer would start, returning all validation problems
+func validate(m *message.BuildMessage) []string {
+	problems := m.Validate()
+	provider, ok := m.BuildConfig["provider"].(map[string]interface{})
+	if !ok {
+		return problems
+	}
+
+	buildRegion := m.BuildRegion()
+	if err := awsconfig.ValidateRegion(buildRegion); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if m.Job != "start" {
+		return problems
+	}
+
+	if err := annotations.Apply(m.BuildConfig); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := launcher.Select(provider, m.ExecutorType); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := sizing.Apply(provider, m.ExecutorType); err != nil {
+		problems = append(problems, err.Error())
+	}
+	p, err := loadPolicy()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		if err := p.CheckBuildConfig(m.BuildConfig, buildRegion); err != nil {
+			problems = append(problems, err.Error())
+		}
+		environment, _ := m.BuildConfig["environment"].(map[string]interface{})
+		m.BuildConfig[policy.EnvironmentKey], _ = p.FilterEnvironment(environment)
+		if err := p.ApplyNaming(m.BuildConfig); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	rewriter, err := image.RewriterFromEnv()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	rewriter.RewriteBuildConfig(m.BuildConfig, buildRegion)
+
+	if m.ExecutorType == "sls" {
+		bucket, err := slsExecutor.BucketName(provider)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Got error getting bucket name: %v", err))
+		}
+		m.BuildConfig["bucket"] = bucket
+		provider["launcherBundle"] = launcher.Bundle(provider)
+	}
+
+	return problems
+}
+
+// validates the message of the file named by args or stdin, returns the exit code
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	log.SetOutput(stderr)
+
+	input := stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(stderr, "Error opening message: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		input = f
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error reading message: %v\n", err)
+		return 2
+	}
+	m, err := message.Decode(data)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	problems := validate(m)
+	effective, _ := json.MarshalIndent(map[string]interface{}{
+		"job":          m.Job,
+		"executorType": m.ExecutorType,
+		"buildConfig":  redact.Map(m.BuildConfig),
+	}, "", "  ")
+	fmt.Fprintln(stdout, string(effective))
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintf(stderr, "invalid: %s\n", problem)
+		}
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
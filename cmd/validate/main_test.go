@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMessage = `{
+	"job": "start",
+	"executorType": "sls",
+	"buildConfig": {
+		"buildId": 1234,
+		"jobId": 6822,
+		"jobName": "main",
+		"pipelineId": 1898,
+		"apiUri": "https://api.screwdriver.cd",
+		"storeUri": "https://store.screwdriver.cd",
+		"uiUri": "https://screwdriver.cd",
+		"token": "testtoken",
+		"container": "node:12",
+		"buildTimeout": 90,
+		"provider": {
+			"region": "us-west-2",
+			"buildRegion": "us-east-1",
+			"accountId": 111111111,
+			"role": "arn:aws:iam::111111111:role/sd-build",
+			"launcherImage": "screwdrivercd/launcher:v6.0.147",
+			"launcherVersion": "v6.0.147",
+			"environmentType": "ARM_CONTAINER",
+			"vpc": {"vpcId": "vpc-1", "securityGroupIds": ["sg-1"], "subnetIds": ["subnet-1"]}
+		}
+	}
+}`
+
+func TestRun(t *testing.T) {
+	loadPolicy = func() (*policy.Policy, error) { return &policy.Policy{}, nil }
+	defer func() { loadPolicy = policy.Load }()
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-builds-usw2")
+	t.Setenv("SD_LAUNCHER_IMAGES", `{"arm64": "screwdrivercd/launcher:{version}-arm64"}`)
+	t.Setenv("SD_IMAGE_REWRITES", `[{"prefix": "docker.io/", "replacement": "{accountId}.dkr.ecr.{region}.amazonaws.com/docker-hub/"}]`)
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run(nil, strings.NewReader(testMessage), &stdout, &stderr), stderr.String())
+
+	var effective map[string]interface{}
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &effective))
+	buildConfig := effective["buildConfig"].(map[string]interface{})
+	provider := buildConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "***", buildConfig["token"])
+	assert.Equal(t, "sd-builds-use1", buildConfig["bucket"])
+	assert.Equal(t, "111111111.dkr.ecr.us-east-1.amazonaws.com/docker-hub/library/node:12", buildConfig["container"])
+	assert.Equal(t, "111111111.dkr.ecr.us-east-1.amazonaws.com/docker-hub/screwdrivercd/launcher:v6.0.147-arm64", provider["launcherImage"])
+	assert.Equal(t, "v6.0.147-arm64", provider["launcherBundle"])
+	assert.Equal(t, "BUILD_GENERAL1_SMALL", provider["computeType"])
+}
+
+func TestRunSizing(t *testing.T) {
+	loadPolicy = func() (*policy.Policy, error) { return &policy.Policy{}, nil }
+	defer func() { loadPolicy = policy.Load }()
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-builds-usw2")
+
+	sized := strings.Replace(testMessage, `"environmentType": "ARM_CONTAINER",`, `"size": "large",`, 1)
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run(nil, strings.NewReader(sized), &stdout, &stderr), stderr.String())
+
+	var effective map[string]interface{}
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &effective))
+	provider := effective["buildConfig"].(map[string]interface{})["provider"].(map[string]interface{})
+	assert.Equal(t, "BUILD_GENERAL1_LARGE", provider["computeType"])
+
+	annotated := strings.Replace(sized, `"buildTimeout": 90,`, `"buildTimeout": 90, "annotations": {"screwdriver.cd/cpu": "TURBO", "screwdriver.cd/dockerEnabled": "true"},`, 1)
+	stdout.Reset()
+	assert.Equal(t, 0, run(nil, strings.NewReader(annotated), &stdout, &stderr), stderr.String())
+	assert.Nil(t, json.Unmarshal(stdout.Bytes(), &effective))
+	provider = effective["buildConfig"].(map[string]interface{})["provider"].(map[string]interface{})
+	assert.Equal(t, "BUILD_GENERAL1_XLARGE", provider["computeType"])
+	assert.Equal(t, true, provider["privilegedMode"])
+}
+
+func TestRunInvalid(t *testing.T) {
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{AllowedAccounts: []string{"222222222"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+
+	file := filepath.Join(t.TempDir(), "message.json")
+	invalid := strings.Replace(testMessage, `"buildRegion": "us-east-1"`, `"buildRegion": "mars-1"`, 1)
+	assert.Nil(t, os.WriteFile(file, []byte(invalid), 0600))
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{file}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "invalid: region \"mars-1\" does not belong to a known aws partition\n")
+	assert.Contains(t, stderr.String(), "invalid: Rejected by policy: account 111111111 is not allowed\n")
+	assert.Contains(t, stderr.String(), "invalid: Got error getting bucket name: invalid region \"mars-1\"\n")
+
+	stderr.Reset()
+	assert.Equal(t, 1, run(nil, strings.NewReader(`{"job"`), &stdout, &stderr))
+	assert.Equal(t, 2, run([]string{filepath.Join(t.TempDir(), "missing.json")}, nil, &stdout, &stderr))
+}
@@ -0,0 +1,162 @@
+// Package cost reports the month to date cost of the aws resources managed by Screwdriver per pipeline, from the
+// cost allocation tags of the resources in Cost Explorer
+package cost
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/tags"
+)
+
+const (
+	// the Cost Explorer api is served from a single region
+	endpointRegion = "us-east-1"
+	// the results are keyed by the metric name, not the enum value of the sdk
+	metric     = "UnblendedCost"
+	dateLayout = "2006-01-02"
+)
+
+// PipelineCost is the cost of the resources of a pipeline
+type PipelineCost struct {
+	PipelineID string  `json:"pipelineId"`
+	Cost       float64 `json:"cost"`
+}
+
+// AccountCost is the cost of the managed resources of a provider account
+type AccountCost struct {
+	AccountID string  `json:"accountId"`
+	Cost      float64 `json:"cost"`
+}
+
+// Summary is the cost of the resources managed by Screwdriver in a month up to the end date
+type Summary struct {
+	Month string `json:"month"`
+	// Start and End are the dates of the period, the end is exclusive
+	Start    string  `json:"start"`
+	End      string  `json:"end"`
+	Currency string  `json:"currency"`
+	Total    float64 `json:"total"`
+	// Unattributed is the cost of managed resources without the pipeline tag
+	Unattributed float64 `json:"unattributed"`
+	// Pipelines and Accounts are ordered by cost, highest first
+	Pipelines []PipelineCost `json:"pipelines"`
+	Accounts  []AccountCost  `json:"accounts"`
+}
+
+// Reporter reports the costs of the account from Cost Explorer
+type Reporter struct {
+	client costexploreriface.CostExplorerAPI
+	now    func() time.Time
+}
+
+// New returns a reporter of the costs of the account of the consumer
+func New() *Reporter {
+	return &Reporter{now: time.Now}
+}
+
+// gets the cost explorer client, creating it on first use
+func (r *Reporter) costExplorer() (costexploreriface.CostExplorerAPI, error) {
+	if r.client == nil {
+		sess, err := awsconfig.NewSession(endpointRegion)
+		if err != nil {
+			return nil, err
+		}
+		r.client = costexplorer.New(sess)
+	}
+	return r.client, nil
+}
+
+// Report gets the month to date cost of the managed resources by the pipeline tag and the account of the resources
+func (r *Reporter) Report() (*Summary, error) {
+	client, err := r.costExplorer()
+	if err != nil {
+		return nil, err
+	}
+	now := r.now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	// the end is exclusive, so the costs of today are included
+	end := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	summary := &Summary{Month: start.Format("2006-01"), Start: start.Format(dateLayout), End: end.Format(dateLayout)}
+
+	costs := map[string]float64{}
+	accounts := map[string]float64{}
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod:  &costexplorer.DateInterval{Start: aws.String(summary.Start), End: aws.String(summary.End)},
+		Granularity: aws.String(costexplorer.GranularityMonthly),
+		Metrics:     aws.StringSlice([]string{metric}),
+		Filter: &costexplorer.Expression{Tags: &costexplorer.TagValues{
+			Key:          aws.String(tags.ManagedBy),
+			Values:       aws.StringSlice([]string{tags.Screwdriver}),
+			MatchOptions: aws.StringSlice([]string{costexplorer.MatchOptionEquals}),
+		}},
+		GroupBy: []*costexplorer.GroupDefinition{
+			{Type: aws.String(costexplorer.GroupDefinitionTypeTag), Key: aws.String(tags.PipelineID)},
+			{Type: aws.String(costexplorer.GroupDefinitionTypeDimension), Key: aws.String(costexplorer.DimensionLinkedAccount)},
+		},
+	}
+	for {
+		result, err := client.GetCostAndUsage(input)
+		if err != nil {
+			return nil, fmt.Errorf("Error-GetCostAndUsage: %v", err)
+		}
+		for _, byTime := range result.ResultsByTime {
+			for _, group := range byTime.Groups {
+				value, ok := group.Metrics[metric]
+				if !ok || len(group.Keys) == 0 {
+					continue
+				}
+				amount, err := strconv.ParseFloat(aws.StringValue(value.Amount), 64)
+				if err != nil {
+					return nil, fmt.Errorf("Got error parsing cost of %v: %v", aws.StringValue(group.Keys[0]), err)
+				}
+				summary.Currency = aws.StringValue(value.Unit)
+				summary.Total += amount
+				if len(group.Keys) > 1 {
+					accounts[aws.StringValue(group.Keys[1])] += amount
+				}
+				// tag groups are keyed <tag>$<value>, with an empty value for resources without the tag
+				pipelineID := strings.TrimPrefix(aws.StringValue(group.Keys[0]), tags.PipelineID+"$")
+				if pipelineID == "" {
+					summary.Unattributed += amount
+					continue
+				}
+				costs[pipelineID] += amount
+			}
+		}
+		if aws.StringValue(result.NextPageToken) == "" {
+			break
+		}
+		input.NextPageToken = result.NextPageToken
+	}
+
+	summary.Pipelines = make([]PipelineCost, 0, len(costs))
+	for pipelineID, amount := range costs {
+		summary.Pipelines = append(summary.Pipelines, PipelineCost{PipelineID: pipelineID, Cost: amount})
+	}
+	sort.Slice(summary.Pipelines, func(i, j int) bool {
+		if summary.Pipelines[i].Cost != summary.Pipelines[j].Cost {
+			return summary.Pipelines[i].Cost > summary.Pipelines[j].Cost
+		}
+		return summary.Pipelines[i].PipelineID < summary.Pipelines[j].PipelineID
+	})
+	summary.Accounts = make([]AccountCost, 0, len(accounts))
+	for accountID, amount := range accounts {
+		summary.Accounts = append(summary.Accounts, AccountCost{AccountID: accountID, Cost: amount})
+	}
+	sort.Slice(summary.Accounts, func(i, j int) bool {
+		if summary.Accounts[i].Cost != summary.Accounts[j].Cost {
+			return summary.Accounts[i].Cost > summary.Accounts[j].Cost
+		}
+		return summary.Accounts[i].AccountID < summary.Accounts[j].AccountID
+	})
+	return summary, nil
+}
@@ -0,0 +1,97 @@
+package cost
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCostExplorer struct {
+	costexploreriface.CostExplorerAPI
+	inputs  []costexplorer.GetCostAndUsageInput
+	outputs []*costexplorer.GetCostAndUsageOutput
+	err     error
+}
+
+func (m *mockCostExplorer) GetCostAndUsage(input *costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error) {
+	m.inputs = append(m.inputs, *input)
+	if m.err != nil {
+		return nil, m.err
+	}
+	output := m.outputs[0]
+	m.outputs = m.outputs[1:]
+	return output, nil
+}
+
+// returns the group of the pipeline tag value and account with the cost
+func group(value string, account string, amount string) *costexplorer.Group {
+	return &costexplorer.Group{
+		Keys:    aws.StringSlice([]string{"sd-pipeline-id$" + value, account}),
+		Metrics: map[string]*costexplorer.MetricValue{"UnblendedCost": {Amount: aws.String(amount), Unit: aws.String("USD")}},
+	}
+}
+
+func TestReport(t *testing.T) {
+	client := &mockCostExplorer{outputs: []*costexplorer.GetCostAndUsageOutput{
+		{
+			ResultsByTime: []*costexplorer.ResultByTime{{Groups: []*costexplorer.Group{group("1898", "111111111", "12.5"), group("", "111111111", "0.75")}}},
+			NextPageToken: aws.String("page-2"),
+		},
+		{
+			ResultsByTime: []*costexplorer.ResultByTime{{Groups: []*costexplorer.Group{group("42", "222222222", "3.25"), group("7", "222222222", "12.5")}}},
+		},
+	}}
+	reporter := &Reporter{client: client, now: func() time.Time {
+		return time.Date(2022, 3, 31, 23, 0, 0, 0, time.UTC)
+	}}
+	summary, err := reporter.Report()
+	assert.Nil(t, err)
+	assert.Equal(t, &Summary{
+		Month:        "2022-03",
+		Start:        "2022-03-01",
+		End:          "2022-04-01",
+		Currency:     "USD",
+		Total:        29,
+		Unattributed: 0.75,
+		Pipelines: []PipelineCost{
+			{PipelineID: "1898", Cost: 12.5},
+			{PipelineID: "7", Cost: 12.5},
+			{PipelineID: "42", Cost: 3.25},
+		},
+		Accounts: []AccountCost{
+			{AccountID: "222222222", Cost: 15.75},
+			{AccountID: "111111111", Cost: 13.25},
+		},
+	}, summary)
+
+	input := client.inputs[0]
+	assert.Equal(t, "2022-03-01", aws.StringValue(input.TimePeriod.Start))
+	assert.Equal(t, &costexplorer.TagValues{
+		Key:          aws.String("managed-by"),
+		Values:       aws.StringSlice([]string{"screwdriver"}),
+		MatchOptions: aws.StringSlice([]string{"EQUALS"}),
+	}, input.Filter.Tags)
+	assert.Equal(t, "sd-pipeline-id", aws.StringValue(input.GroupBy[0].Key))
+	assert.Equal(t, "LINKED_ACCOUNT", aws.StringValue(input.GroupBy[1].Key))
+	assert.Nil(t, input.NextPageToken)
+	assert.Equal(t, "page-2", aws.StringValue(client.inputs[1].NextPageToken))
+}
+
+func TestReportErrors(t *testing.T) {
+	client := &mockCostExplorer{err: errors.New("DataUnavailableException")}
+	reporter := &Reporter{client: client, now: time.Now}
+	_, err := reporter.Report()
+	assert.EqualError(t, err, "Error-GetCostAndUsage: DataUnavailableException")
+
+	client = &mockCostExplorer{outputs: []*costexplorer.GetCostAndUsageOutput{
+		{ResultsByTime: []*costexplorer.ResultByTime{{Groups: []*costexplorer.Group{group("1898", "111111111", "n/a")}}}},
+	}}
+	reporter.client = client
+	_, err = reporter.Report()
+	assert.EqualError(t, err, `Got error parsing cost of sd-pipeline-id$1898: strconv.ParseFloat: parsing "n/a": invalid syntax`)
+}
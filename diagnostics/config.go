@@ -0,0 +1,37 @@
+package diagnostics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+)
+
+// configHashLength is the length of the hash of an effective config
+const configHashLength = 16
+
+// EffectiveConfig is the build config the consumer resolved for a start, after the defaults, the registry account,
+// the annotations, the overrides and the policy, for support to reproduce what the consumer decided
+type EffectiveConfig struct {
+	// Hash is the same for starts resolving the same config
+	Hash     string `json:"hash"`
+	Executor string `json:"executor"`
+	Region   string `json:"region"`
+	// BuildConfig is the resolved build config with the sensitive values masked
+	BuildConfig map[string]interface{} `json:"buildConfig"`
+}
+
+// NewEffectiveConfig gets the effective config of the build, hashed over its redacted fields
+func NewEffectiveConfig(executor, region string, buildConfig map[string]interface{}) *EffectiveConfig {
+	c := &EffectiveConfig{
+		Executor:    executor,
+		Region:      region,
+		BuildConfig: redact.Map(buildConfig),
+	}
+	// maps are marshalled with sorted keys, so equal configs have equal hashes
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	c.Hash = hex.EncodeToString(sum[:])[:configHashLength]
+	return c
+}
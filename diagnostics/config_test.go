@@ -0,0 +1,39 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+)
+
+func TestNewEffectiveConfig(t *testing.T) {
+	buildConfig := func(token string) map[string]interface{} {
+		return map[string]interface{}{
+			"buildId":  json.Number("1234"),
+			"token":    token,
+			"provider": map[string]interface{}{"region": "us-west-2", "computeType": "BUILD_GENERAL1_SMALL", "bucket": "sd-builds-usw2"},
+		}
+	}
+	config := NewEffectiveConfig("sls", "us-west-2", buildConfig("secret-token"))
+	assert.Len(t, config.Hash, configHashLength)
+	assert.Equal(t, &EffectiveConfig{
+		Hash:     config.Hash,
+		Executor: "sls",
+		Region:   "us-west-2",
+		BuildConfig: map[string]interface{}{
+			"buildId":  json.Number("1234"),
+			"token":    redact.Mask,
+			"provider": map[string]interface{}{"region": "us-west-2", "computeType": "BUILD_GENERAL1_SMALL", "bucket": "sd-builds-usw2"},
+		},
+	}, config)
+
+	// the masked token does not change the hash
+	assert.Equal(t, config.Hash, NewEffectiveConfig("sls", "us-west-2", buildConfig("other-token")).Hash)
+	assert.NotEqual(t, config.Hash, NewEffectiveConfig("sls", "us-east-1", buildConfig("secret-token")).Hash)
+	changed := buildConfig("secret-token")
+	changed["provider"].(map[string]interface{})["computeType"] = "BUILD_GENERAL1_LARGE"
+	assert.NotEqual(t, config.Hash, NewEffectiveConfig("sls", "us-west-2", changed).Hash)
+}
@@ -0,0 +1,69 @@
+// Package diagnostics exposes pprof endpoints and collects runtime profiles of the consumer on demand, along with
+// the diagnostics of builds failing to start
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// Register adds the pprof endpoints under /debug/pprof/ to the mux
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// MemStats is a summary of the runtime memory statistics
+type MemStats struct {
+	Goroutines   int    `json:"goroutines"`
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	HeapReleased uint64 `json:"heapReleased"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"numGC"`
+}
+
+// ReadMemStats returns the current memory statistics
+func ReadMemStats() MemStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return MemStats{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		HeapReleased: m.HeapReleased,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+	}
+}
+
+// Collect returns the goroutine dump, heap profile and memory statistics keyed by file name
+func Collect() (map[string][]byte, error) {
+	var goroutines, heap bytes.Buffer
+	if err := runtimepprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return nil, err
+	}
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(&heap); err != nil {
+		return nil, err
+	}
+	memstats, err := json.Marshal(ReadMemStats())
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		"goroutines.txt": goroutines.Bytes(),
+		"heap.pprof":     heap.Bytes(),
+		"memstats.json":  memstats,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Body.String(), "goroutine profile:"))
+}
+
+func TestCollect(t *testing.T) {
+	files, err := Collect()
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(files["goroutines.txt"]), "diagnostics.TestCollect"))
+	assert.NotEmpty(t, files["heap.pprof"])
+
+	var memstats MemStats
+	assert.Nil(t, json.Unmarshal(files["memstats.json"], &memstats))
+	assert.True(t, memstats.Goroutines > 0)
+	assert.True(t, memstats.HeapAlloc > 0)
+}
@@ -0,0 +1,73 @@
+package diagnostics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+)
+
+// StartArtifactName is the name of the store artifact holding the diagnostics of a failed start
+const StartArtifactName = "aws-start-diagnostics.json"
+
+// Error is an error of the chain of a failed start, with the details of aws errors
+type Error struct {
+	Message    string `json:"message"`
+	Code       string `json:"code,omitempty"`
+	RequestID  string `json:"requestId,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+}
+
+// Start is the diagnostics of a build which failed before its launcher ran, for users to find the root cause
+type Start struct {
+	BuildID       int    `json:"buildId"`
+	Executor      string `json:"executor"`
+	Region        string `json:"region"`
+	FailedAt      string `json:"failedAt"`
+	Category      string `json:"category,omitempty"`
+	StatusMessage string `json:"statusMessage"`
+	// Errors is the chain of the start error, outermost first
+	Errors []Error `json:"errors"`
+	// Events are the events of the build resources reported by the executor, like the events of eks pods
+	Events []string `json:"events,omitempty"`
+	// BuildConfig is the resolved build config with the sensitive values masked
+	BuildConfig map[string]interface{} `json:"buildConfig"`
+}
+
+// NewStart gets the diagnostics of the failed start of the build
+func NewStart(buildID int, executor, region string, buildConfig map[string]interface{}, err error, statusMessage string, failedAt time.Time) *Start {
+	return &Start{
+		BuildID:       buildID,
+		Executor:      executor,
+		Region:        region,
+		FailedAt:      failedAt.UTC().Format(time.RFC3339),
+		StatusMessage: statusMessage,
+		Errors:        ErrorChain(err),
+		BuildConfig:   redact.Map(buildConfig),
+	}
+}
+
+// ErrorChain gets the errors wrapped by err, following the original errors of aws errors
+func ErrorChain(err error) []Error {
+	var chain []Error
+	for err != nil && len(chain) < 16 {
+		e := Error{Message: redact.String(err.Error())}
+		var next error
+		if aerr, ok := err.(awserr.Error); ok {
+			e.Code = aerr.Code()
+			next = aerr.OrigErr()
+		}
+		if rerr, ok := err.(awserr.RequestFailure); ok {
+			e.RequestID = rerr.RequestID()
+			e.StatusCode = rerr.StatusCode()
+		}
+		chain = append(chain, e)
+		if next == nil {
+			next = errors.Unwrap(err)
+		}
+		err = next
+	}
+	return chain
+}
@@ -0,0 +1,55 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+)
+
+func TestErrorChain(t *testing.T) {
+	assert.Nil(t, ErrorChain(nil))
+
+	dial := errors.New("dial tcp: i/o timeout")
+	aerr := awserr.NewRequestFailure(awserr.New("RequestError", "send request failed", dial), 0, "")
+	err := fmt.Errorf("Error-CreateProject: %w", awserr.NewRequestFailure(awserr.New("AccessDeniedException", "not authorized", nil), 403, "req-1"))
+	assert.Equal(t, []Error{
+		{Message: "Error-CreateProject: AccessDeniedException: not authorized\n\tstatus code: 403, request id: req-1"},
+		{Message: "AccessDeniedException: not authorized\n\tstatus code: 403, request id: req-1", Code: "AccessDeniedException", RequestID: "req-1", StatusCode: 403},
+	}, ErrorChain(err))
+	assert.Equal(t, []Error{
+		{Message: aerr.Error(), Code: "RequestError"},
+		{Message: "dial tcp: i/o timeout"},
+	}, ErrorChain(aerr))
+}
+
+func TestNewStart(t *testing.T) {
+	buildConfig := map[string]interface{}{
+		"buildId":  json.Number("1234"),
+		"token":    "secret-token",
+		"provider": map[string]interface{}{"region": "us-west-2"},
+	}
+	failedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.FixedZone("PST", -8*3600))
+	err := errors.New("the build pod was rejected by a policy of the cluster")
+	start := NewStart(1234, "eks", "us-west-2", buildConfig, err, "Build pod rejected", failedAt)
+	assert.Equal(t, &Start{
+		BuildID:       1234,
+		Executor:      "eks",
+		Region:        "us-west-2",
+		FailedAt:      "2022-03-01T20:00:00Z",
+		StatusMessage: "Build pod rejected",
+		Errors:        []Error{{Message: "the build pod was rejected by a policy of the cluster"}},
+		BuildConfig: map[string]interface{}{
+			"buildId":  json.Number("1234"),
+			"token":    redact.Mask,
+			"provider": map[string]interface{}{"region": "us-west-2"},
+		},
+	}, start)
+	assert.Equal(t, "secret-token", buildConfig["token"])
+}
@@ -0,0 +1,93 @@
+// Package e2e runs builds through the executors against local stand-ins of their backends, LocalStack for
+// codebuild and s3 and an envtest or kind cluster for eks, so executor changes can be checked without aws accounts
+package e2e
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/screwdriver-cd/aws-consumer-service/message"
+)
+
+const (
+	// localStackEnv is the url of the LocalStack edge endpoint, e.g. http://localhost:4566
+	localStackEnv = "SD_E2E_LOCALSTACK_URL"
+	// the api server of the local cluster, authenticated with a bearer token or a client certificate
+	kubeServerEnv   = "SD_E2E_KUBE_SERVER"
+	kubeTokenEnv    = "SD_E2E_KUBE_TOKEN"
+	kubeCAFileEnv   = "SD_E2E_KUBE_CA_FILE"
+	kubeCertFileEnv = "SD_E2E_KUBE_CERT_FILE"
+	kubeKeyFileEnv  = "SD_E2E_KUBE_KEY_FILE"
+)
+
+// endpoint ids of the aws services used by the executors, all served by the LocalStack edge endpoint
+var localStackServices = []string{"codebuild", "s3", "logs", "ec2", "eks", "kms", "iam", "sts", "ssm", "secretsmanager", "api.ecr"}
+
+//go:embed testdata/*.json
+var messages embed.FS
+
+// UseLocalStack points the aws clients at SD_E2E_LOCALSTACK_URL with test credentials,
+// returns false when it is not set
+func UseLocalStack() (bool, error) {
+	url := os.Getenv(localStackEnv)
+	if url == "" {
+		return false, nil
+	}
+	endpoints := map[string]string{}
+	for _, service := range localStackServices {
+		endpoints[service] = url
+	}
+	value, err := json.Marshal(endpoints)
+	if err != nil {
+		return false, err
+	}
+	env := map[string]string{
+		"SD_AWS_ENDPOINTS":           string(value),
+		"SD_AWS_S3_FORCE_PATH_STYLE": "true",
+	}
+	// LocalStack accepts any credentials, keep the ones of the environment if there are
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		env["AWS_ACCESS_KEY_ID"] = "test"
+		env["AWS_SECRET_ACCESS_KEY"] = "test"
+	}
+	for k, v := range env {
+		if err := os.Setenv(k, v); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// KubeConfig gets the rest config of the local cluster of SD_E2E_KUBE_SERVER, nil when it is not set
+func KubeConfig() (*rest.Config, error) {
+	server := os.Getenv(kubeServerEnv)
+	if server == "" {
+		return nil, nil
+	}
+	config := &rest.Config{
+		Host:        server,
+		BearerToken: os.Getenv(kubeTokenEnv),
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:   os.Getenv(kubeCAFileEnv),
+			CertFile: os.Getenv(kubeCertFileEnv),
+			KeyFile:  os.Getenv(kubeKeyFileEnv),
+		},
+	}
+	if config.BearerToken == "" && (config.CertFile == "" || config.KeyFile == "") {
+		return nil, fmt.Errorf("%s needs %s or %s and %s", kubeServerEnv, kubeTokenEnv, kubeCertFileEnv, kubeKeyFileEnv)
+	}
+	return config, nil
+}
+
+// SampleMessage gets the start message of a build of the executor type, with the config of a resolved build
+func SampleMessage(executorType string) (*message.BuildMessage, error) {
+	data, err := messages.ReadFile("testdata/" + executorType + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no sample message for executor %s", executorType)
+	}
+	return message.Decode(data)
+}
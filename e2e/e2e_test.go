@@ -0,0 +1,73 @@
+package e2e
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseLocalStack(t *testing.T) {
+	t.Setenv("SD_E2E_LOCALSTACK_URL", "")
+	t.Setenv("SD_AWS_ENDPOINTS", "")
+	ok, err := UseLocalStack()
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "", os.Getenv("SD_AWS_ENDPOINTS"))
+
+	t.Setenv("SD_E2E_LOCALSTACK_URL", "http://localhost:4566")
+	t.Setenv("SD_AWS_S3_FORCE_PATH_STYLE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	ok, err = UseLocalStack()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	var endpoints map[string]string
+	assert.Nil(t, json.Unmarshal([]byte(os.Getenv("SD_AWS_ENDPOINTS")), &endpoints))
+	assert.Equal(t, "http://localhost:4566", endpoints["codebuild"])
+	assert.Equal(t, "http://localhost:4566", endpoints["s3"])
+	assert.Equal(t, "true", os.Getenv("SD_AWS_S3_FORCE_PATH_STYLE"))
+	assert.Equal(t, "test", os.Getenv("AWS_ACCESS_KEY_ID"))
+
+	// existing credentials are kept
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	_, err = UseLocalStack()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKIAEXAMPLE", os.Getenv("AWS_ACCESS_KEY_ID"))
+}
+
+func TestKubeConfig(t *testing.T) {
+	t.Setenv("SD_E2E_KUBE_SERVER", "")
+	config, err := KubeConfig()
+	assert.Nil(t, config)
+	assert.Nil(t, err)
+
+	t.Setenv("SD_E2E_KUBE_SERVER", "https://127.0.0.1:6443")
+	t.Setenv("SD_E2E_KUBE_TOKEN", "")
+	t.Setenv("SD_E2E_KUBE_CERT_FILE", "/tmp/client.crt")
+	t.Setenv("SD_E2E_KUBE_KEY_FILE", "")
+	_, err = KubeConfig()
+	assert.EqualError(t, err, "SD_E2E_KUBE_SERVER needs SD_E2E_KUBE_TOKEN or SD_E2E_KUBE_CERT_FILE and SD_E2E_KUBE_KEY_FILE")
+
+	t.Setenv("SD_E2E_KUBE_KEY_FILE", "/tmp/client.key")
+	t.Setenv("SD_E2E_KUBE_CA_FILE", "/tmp/ca.crt")
+	config, err = KubeConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://127.0.0.1:6443", config.Host)
+	assert.Equal(t, "/tmp/ca.crt", config.CAFile)
+	assert.Equal(t, "/tmp/client.crt", config.CertFile)
+	assert.Equal(t, "/tmp/client.key", config.KeyFile)
+}
+
+func TestSampleMessage(t *testing.T) {
+	for _, executorType := range []string{"sls", "eks"} {
+		m, err := SampleMessage(executorType)
+		assert.Nil(t, err)
+		assert.Equal(t, executorType, m.ExecutorType)
+		assert.Empty(t, m.Validate(), executorType)
+	}
+
+	_, err := SampleMessage("ecs")
+	assert.EqualError(t, err, "no sample message for executor ecs")
+}
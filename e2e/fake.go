@@ -0,0 +1,121 @@
+package e2e
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// FakeNode is the node builds of the fake executor run on
+const FakeNode = "fake-node"
+
+// a build of the fake executor
+type fakeBuild struct {
+	polls  int
+	status executor.Status
+	logs   []string
+}
+
+// FakeExecutor runs builds in memory, they are queued for one status poll,
+// run for RunningPolls more and then finish with State
+type FakeExecutor struct {
+	RunningPolls int
+	State        executor.State
+
+	mu     sync.Mutex
+	builds map[string]*fakeBuild
+}
+
+// NewFakeExecutor returns a fake executor of builds succeeding after the first running poll
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{RunningPolls: 1, State: executor.Succeeded, builds: map[string]*fakeBuild{}}
+}
+
+// gets the started build of the config
+func (e *FakeExecutor) build(config map[string]interface{}) (*fakeBuild, error) {
+	build, ok := e.builds[fmt.Sprint(config["buildId"])]
+	if !ok {
+		return nil, fmt.Errorf("build %v was not started", config["buildId"])
+	}
+	return build, nil
+}
+
+// Start starts the build, a build can only be started once
+func (e *FakeExecutor) Start(config map[string]interface{}) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id := fmt.Sprint(config["buildId"])
+	if _, ok := e.builds[id]; ok {
+		return "", fmt.Errorf("build %v is already started", id)
+	}
+	e.builds[id] = &fakeBuild{
+		status: executor.Status{State: executor.Queued},
+		logs:   []string{fmt.Sprintf("build %v started in container %v", id, config["container"])},
+	}
+	return FakeNode, nil
+}
+
+// Stop fails the build
+func (e *FakeExecutor) Stop(config map[string]interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	build, err := e.build(config)
+	if err != nil {
+		return err
+	}
+	if build.status.State == executor.Queued || build.status.State == executor.Running {
+		build.status = executor.Status{State: executor.Failed, Reason: "stopped"}
+		build.logs = append(build.logs, "build stopped")
+	}
+	return nil
+}
+
+// Status advances the build by one poll and returns its status
+func (e *FakeExecutor) Status(config map[string]interface{}) (executor.Status, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	build, err := e.build(config)
+	if err != nil {
+		return executor.Status{}, err
+	}
+	status := build.status
+	if status.State == executor.Queued || status.State == executor.Running {
+		build.polls++
+		switch {
+		case build.polls > e.RunningPolls:
+			build.status = executor.Status{State: e.State}
+			build.logs = append(build.logs, fmt.Sprintf("build finished %v", e.State))
+		case status.State == executor.Queued:
+			build.status = executor.Status{State: executor.Running}
+			build.logs = append(build.logs, "build running")
+		}
+	}
+	return status, nil
+}
+
+// Logs returns the log lines of the build
+func (e *FakeExecutor) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	build, err := e.build(config)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(build.logs, "\n") + "\n"), nil
+}
+
+// Cleanup forgets the build
+func (e *FakeExecutor) Cleanup(config map[string]interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.builds, fmt.Sprint(config["buildId"]))
+	return nil
+}
+
+// Name returns the name of the executor
+func (e *FakeExecutor) Name() string {
+	return "fake"
+}
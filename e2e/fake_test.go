@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeExecutor(t *testing.T) {
+	e := NewFakeExecutor()
+	e.State = executor.Failed
+	config := map[string]interface{}{"buildId": 1234}
+
+	node, err := e.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, FakeNode, node)
+	_, err = e.Start(config)
+	assert.EqualError(t, err, "build 1234 is already started")
+
+	var states []executor.State
+	for i := 0; i < 4; i++ {
+		status, err := e.Status(config)
+		assert.Nil(t, err)
+		states = append(states, status.State)
+	}
+	assert.Equal(t, []executor.State{executor.Queued, executor.Running, executor.Failed, executor.Failed}, states)
+
+	// finished builds are not stopped again
+	assert.Nil(t, e.Stop(config))
+	logs, _ := e.Logs(config, time.Time{})
+	assert.NotContains(t, string(logs), "stopped")
+
+	assert.Nil(t, e.Cleanup(config))
+	assert.EqualError(t, e.Stop(config), "build 1234 was not started")
+	_, err = e.Logs(config, time.Time{})
+	assert.EqualError(t, err, "build 1234 was not started")
+	assert.Equal(t, "fake", e.Name())
+}
+
+func TestFakeExecutorStop(t *testing.T) {
+	e := NewFakeExecutor()
+	config := map[string]interface{}{"buildId": 1234}
+	_, err := e.Start(config)
+	assert.Nil(t, err)
+
+	assert.Nil(t, e.Stop(config))
+	status, err := e.Status(config)
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "stopped"}, status)
+}
@@ -0,0 +1,102 @@
+package e2e
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = 15 * time.Minute
+)
+
+// Executor is the part of the consumer executors driven by the runner
+type Executor interface {
+	Start(config map[string]interface{}) (string, error)
+	Stop(config map[string]interface{}) error
+	Status(config map[string]interface{}) (executor.Status, error)
+	Logs(config map[string]interface{}, since time.Time) ([]byte, error)
+	Cleanup(config map[string]interface{}) error
+}
+
+// Result is the outcome of a build run by the runner
+type Result struct {
+	Node   string          `json:"node,omitempty"`
+	Status executor.Status `json:"status"`
+	Polls  int             `json:"polls"`
+	// Stopped is set when the build did not finish in time and was stopped
+	Stopped bool   `json:"stopped,omitempty"`
+	Logs    string `json:"logs,omitempty"`
+}
+
+// Runner starts a build and polls its status until it finishes, stopping it once the timeout is reached
+type Runner struct {
+	Executor Executor
+	Interval time.Duration
+	Timeout  time.Duration
+	// Cleanup removes the resources of the build after it finished
+	Cleanup bool
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewRunner returns a runner of the executor polling every 5 seconds for up to 15 minutes
+func NewRunner(e Executor) *Runner {
+	return &Runner{
+		Executor: e,
+		Interval: defaultInterval,
+		Timeout:  defaultTimeout,
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}
+}
+
+// Run runs the build of the config through the executor
+func (r *Runner) Run(config map[string]interface{}) (*Result, error) {
+	started := r.now()
+	node, err := r.Executor.Start(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error starting build: %v", err)
+	}
+	result := &Result{Node: node}
+	log.Printf("Started build %v on %q", config["buildId"], node)
+
+	for {
+		status, err := r.Executor.Status(config)
+		if err != nil {
+			return result, fmt.Errorf("Error getting build status: %v", err)
+		}
+		result.Polls++
+		result.Status = status
+		if status.State == executor.Succeeded || status.State == executor.Failed {
+			break
+		}
+		if r.now().Sub(started) >= r.Timeout {
+			if err := r.Executor.Stop(config); err != nil {
+				return result, fmt.Errorf("Error stopping build: %v", err)
+			}
+			result.Stopped = true
+			result.Status = executor.Status{State: executor.Failed, Reason: fmt.Sprintf("build did not finish in %v", r.Timeout)}
+			break
+		}
+		r.sleep(r.Interval)
+	}
+	log.Printf("Build %v finished %v after %d polls", config["buildId"], result.Status.State, result.Polls)
+
+	logs, err := r.Executor.Logs(config, started)
+	if err != nil {
+		log.Printf("Got error getting logs of build %v: %v", config["buildId"], err)
+	}
+	result.Logs = string(logs)
+
+	if r.Cleanup {
+		if err := r.Executor.Cleanup(config); err != nil {
+			return result, fmt.Errorf("Error cleaning up build: %v", err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,87 @@
+package e2e
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/stretchr/testify/assert"
+)
+
+// a fake executor failing some calls
+type failingExecutor struct {
+	*FakeExecutor
+	startErr   error
+	cleanupErr error
+}
+
+func (e *failingExecutor) Start(config map[string]interface{}) (string, error) {
+	if e.startErr != nil {
+		return "", e.startErr
+	}
+	return e.FakeExecutor.Start(config)
+}
+
+func (e *failingExecutor) Cleanup(config map[string]interface{}) error {
+	if e.cleanupErr != nil {
+		return e.cleanupErr
+	}
+	return e.FakeExecutor.Cleanup(config)
+}
+
+// returns a runner of the executor with a clock advanced by each sleep
+func testRunner(e Executor) *Runner {
+	r := NewRunner(e)
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.sleep = func(d time.Duration) { now = now.Add(d) }
+	return r
+}
+
+func TestRun(t *testing.T) {
+	fake := NewFakeExecutor()
+	r := testRunner(fake)
+	r.Cleanup = true
+	config := map[string]interface{}{"buildId": 1234, "container": "node:18"}
+
+	result, err := r.Run(config)
+	assert.Nil(t, err)
+	assert.Equal(t, &Result{
+		Node:   FakeNode,
+		Status: executor.Status{State: executor.Succeeded},
+		Polls:  3,
+		Logs:   "build 1234 started in container node:18\nbuild running\nbuild finished SUCCEEDED\n",
+	}, result)
+
+	// cleaned up builds are gone
+	_, err = fake.Status(config)
+	assert.EqualError(t, err, "build 1234 was not started")
+}
+
+func TestRunTimeout(t *testing.T) {
+	fake := NewFakeExecutor()
+	fake.RunningPolls = 100
+	r := testRunner(fake)
+	r.Interval = time.Minute
+	r.Timeout = 3 * time.Minute
+
+	result, err := r.Run(map[string]interface{}{"buildId": 1234})
+	assert.Nil(t, err)
+	assert.True(t, result.Stopped)
+	assert.Equal(t, 4, result.Polls)
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "build did not finish in 3m0s"}, result.Status)
+	assert.Contains(t, result.Logs, "build stopped")
+}
+
+func TestRunErrors(t *testing.T) {
+	r := testRunner(&failingExecutor{FakeExecutor: NewFakeExecutor(), startErr: errors.New("no capacity")})
+	_, err := r.Run(map[string]interface{}{"buildId": 1234})
+	assert.EqualError(t, err, "Error starting build: no capacity")
+
+	r = testRunner(&failingExecutor{FakeExecutor: NewFakeExecutor(), cleanupErr: errors.New("access denied")})
+	r.Cleanup = true
+	result, err := r.Run(map[string]interface{}{"buildId": 1234})
+	assert.EqualError(t, err, "Error cleaning up build: access denied")
+	assert.Equal(t, executor.Succeeded, result.Status.State)
+}
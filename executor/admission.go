@@ -0,0 +1,27 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AdmissionError reports that the cluster rejected the build with an admission policy, like an admission
+// webhook or pod security admission, retrying does not help until the policy or the build changes
+type AdmissionError struct {
+	msg string
+}
+
+func (e *AdmissionError) Error() string {
+	return e.msg
+}
+
+// AdmissionErrorf formats an admission error
+func AdmissionErrorf(format string, args ...interface{}) error {
+	return &AdmissionError{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsAdmission returns true if the error reports a rejection by an admission policy
+func IsAdmission(err error) bool {
+	var admissionErr *AdmissionError
+	return errors.As(err, &admissionErr)
+}
@@ -0,0 +1,19 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmissionError(t *testing.T) {
+	err := AdmissionErrorf("denied by %s", "validation.gatekeeper.sh")
+	assert.EqualError(t, err, "denied by validation.gatekeeper.sh")
+	assert.True(t, IsAdmission(err))
+	assert.True(t, IsAdmission(fmt.Errorf("Error creating pod %w", err)))
+	assert.False(t, IsAdmission(errors.New("denied by validation.gatekeeper.sh")))
+	assert.False(t, IsCapacity(err))
+	assert.False(t, IsAdmission(nil))
+}
@@ -0,0 +1,27 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CapacityError reports that a build cannot start because account concurrency or aws capacity is exhausted,
+// starting it later may succeed
+type CapacityError struct {
+	msg string
+}
+
+func (e *CapacityError) Error() string {
+	return e.msg
+}
+
+// CapacityErrorf formats a capacity error
+func CapacityErrorf(format string, args ...interface{}) error {
+	return &CapacityError{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsCapacity returns true if the error reports exhausted capacity
+func IsCapacity(err error) bool {
+	var capacityErr *CapacityError
+	return errors.As(err, &capacityErr)
+}
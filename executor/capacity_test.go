@@ -0,0 +1,18 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapacityError(t *testing.T) {
+	err := CapacityErrorf("concurrent build limit reached: %d of %d builds running", 3, 3)
+	assert.EqualError(t, err, "concurrent build limit reached: 3 of 3 builds running")
+	assert.True(t, IsCapacity(err))
+	assert.True(t, IsCapacity(fmt.Errorf("Pre-flight check failed: %w", err)))
+	assert.False(t, IsCapacity(errors.New("concurrent build limit reached: 3 of 3 builds running")))
+	assert.False(t, IsCapacity(nil))
+}
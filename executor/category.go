@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Category tells the consumer whether a failed executor call is retried, requeued or fails the build
+type Category string
+
+const (
+	// UserError failures are caused by the build config, like an invalid provider, the build fails
+	UserError Category = "user-error"
+	// InfraTransient failures may succeed when retried later, like throttling or exhausted capacity, the start is requeued
+	InfraTransient Category = "infra-transient"
+	// InfraPermanent failures need the infrastructure to be fixed, like a missing cluster or permission, the build fails
+	InfraPermanent Category = "infra-permanent"
+	// Policy failures are rejections of the build by a policy, the build fails with the rejection
+	Policy Category = "policy"
+)
+
+// Error is an executor error with its category
+type Error struct {
+	Category Category
+	err      error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Errorf formats an error of the category like fmt.Errorf, an empty category leaves the error uncategorized
+func Errorf(category Category, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if category == "" {
+		return err
+	}
+	return &Error{Category: category, err: err}
+}
+
+// CategoryOf gets the category of an error, empty if it is not categorized.
+// Capacity errors are transient and admission errors are policy failures.
+func CategoryOf(err error) Category {
+	var categorized *Error
+	var capacityErr *CapacityError
+	var admissionErr *AdmissionError
+	switch {
+	case errors.As(err, &categorized):
+		return categorized.Category
+	case errors.As(err, &capacityErr):
+		return InfraTransient
+	case errors.As(err, &admissionErr):
+		return Policy
+	}
+	return ""
+}
+
+// StatusMessage gets the status message of a build failing with the error, telling users whether they can fix it
+func StatusMessage(err error) string {
+	var admissionErr *AdmissionError
+	switch CategoryOf(err) {
+	case UserError:
+		return "Invalid build configuration: " + err.Error()
+	case InfraTransient:
+		return "Temporary infrastructure failure, restart the build later: " + err.Error()
+	case InfraPermanent:
+		return "Infrastructure failure, contact your Screwdriver admins: " + err.Error()
+	case Policy:
+		// admission errors already tell the policy rejected the build
+		if errors.As(err, &admissionErr) {
+			return admissionErr.Error()
+		}
+	}
+	return err.Error()
+}
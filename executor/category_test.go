@@ -0,0 +1,35 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCategoryOf(t *testing.T) {
+	err := Errorf(UserError, "invalid workload %q", "deployment")
+	assert.EqualError(t, err, `invalid workload "deployment"`)
+	assert.Equal(t, UserError, CategoryOf(err))
+	assert.Equal(t, UserError, CategoryOf(fmt.Errorf("Error starting build: %w", err)))
+
+	cause := errors.New("Throttling: Rate exceeded")
+	err = Errorf(InfraTransient, "Error-CreateProject: %w", cause)
+	assert.Equal(t, InfraTransient, CategoryOf(err))
+	assert.True(t, errors.Is(err, cause))
+
+	assert.Equal(t, InfraTransient, CategoryOf(CapacityErrorf("concurrent build limit reached")))
+	assert.Equal(t, Policy, CategoryOf(AdmissionErrorf("denied by validation.gatekeeper.sh")))
+	assert.Equal(t, Category(""), CategoryOf(Errorf("", "Error-CreateProject: %v", cause)))
+	assert.Equal(t, Category(""), CategoryOf(nil))
+}
+
+func TestStatusMessage(t *testing.T) {
+	assert.Equal(t, `Invalid build configuration: invalid workload "deployment"`, StatusMessage(Errorf(UserError, "invalid workload %q", "deployment")))
+	assert.Equal(t, "Temporary infrastructure failure, restart the build later: Throttling", StatusMessage(Errorf(InfraTransient, "Throttling")))
+	assert.Equal(t, "Infrastructure failure, contact your Screwdriver admins: cluster sd-build not found", StatusMessage(Errorf(InfraPermanent, "cluster sd-build not found")))
+	assert.Equal(t, "denied by validation.gatekeeper.sh", StatusMessage(fmt.Errorf("Error creating pod %w", AdmissionErrorf("denied by validation.gatekeeper.sh"))))
+	assert.Equal(t, "quota exceeded", StatusMessage(Errorf(Policy, "quota exceeded")))
+	assert.Equal(t, "unknown", StatusMessage(errors.New("unknown")))
+}
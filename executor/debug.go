@@ -0,0 +1,10 @@
+package executor
+
+import "time"
+
+// DebugSession holds the connection details of an interactive debug session of a running build
+type DebugSession struct {
+	Target    string    `json:"target"`
+	Command   string    `json:"command"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
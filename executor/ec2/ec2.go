@@ -0,0 +1,407 @@
+// Package ec2 runs builds on short-lived ec2 instances, for builds needing bare metal or nested virtualization which
+// neither codebuild nor eks pods provide. The user data of the instance copies the launcher from the launcher image,
+// runs the build container with docker and terminates the instance when the build exits.
+package ec2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/tags"
+)
+
+const (
+	executorName = "ec2"
+	// instance types of providers without an instance type or size
+	defaultInstanceType    = "m5.large"
+	defaultArmInstanceType = "m6g.large"
+	defaultRootDeviceName  = "/dev/xvda"
+	maxUserDataBytes       = 16 * 1024
+	maxRootVolumeGiB       = 16384
+	// the instance shuts down this many minutes after the build timeout in case the launcher never exits
+	shutdownGraceMinutes = 15
+	// InstanceIDKey is the build config key of the id of the instance of the build
+	InstanceIDKey = "ec2InstanceId"
+	// buildTag tags the instance with the screwdriver build, like the sdbuild label of the eks pods
+	buildTag = "sdbuild"
+	// exitCodeMarker prefixes the exit code of the build the user data writes to the console
+	exitCodeMarker = "sd-build-exit-code: "
+)
+
+// instance states of a build which did not terminate yet
+var liveStates = []string{
+	ec2.InstanceStateNamePending,
+	ec2.InstanceStateNameRunning,
+	ec2.InstanceStateNameStopping,
+	ec2.InstanceStateNameStopped,
+}
+
+// aws api definition struct
+type awsAPI struct {
+	ec2 ec2iface.EC2API
+}
+
+// AwsExecutorEC2 definition struct
+type AwsExecutorEC2 struct {
+	serviceClient *awsAPI
+	name          string
+}
+
+// gets the name tag of the instances of the build, the builds of a multi-architecture build only find their own
+func instanceName(config map[string]interface{}) string {
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	return matrix.Name(config, fmt.Sprintf("sdbuild-%v", buildID))
+}
+
+// gets the strings of a list of the provider
+func stringSlice(value interface{}) []*string {
+	list, _ := value.([]interface{})
+	var values []*string
+	for _, item := range list {
+		if s, ok := item.(string); ok && s != "" {
+			values = append(values, aws.String(s))
+		}
+	}
+	return values
+}
+
+// quotes s for the shell of the user data
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// gets the tags of the instance and volumes of the build
+func getTags(config map[string]interface{}) []*ec2.Tag {
+	buildTags := tags.Build(config)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	buildTags[buildTag] = fmt.Sprint(buildID)
+	buildTags["Name"] = instanceName(config)
+	var ec2Tags []*ec2.Tag
+	for _, k := range tags.Keys(buildTags) {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(buildTags[k])})
+	}
+	return ec2Tags
+}
+
+// gets the instance profile of the build, the provider instanceProfile or the profile named like the provider role
+func instanceProfile(provider map[string]interface{}) (*ec2.IamInstanceProfileSpecification, error) {
+	if profile, _ := provider["instanceProfile"].(string); profile != "" {
+		if strings.HasPrefix(profile, "arn:") {
+			return &ec2.IamInstanceProfileSpecification{Arn: aws.String(profile)}, nil
+		}
+		return &ec2.IamInstanceProfileSpecification{Name: aws.String(profile)}, nil
+	}
+	role, _ := provider["role"].(string)
+	roleArn, err := arn.Parse(role)
+	if err != nil {
+		return nil, fmt.Errorf("role %q is not an arn, set instanceProfile", role)
+	}
+	return &ec2.IamInstanceProfileSpecification{Name: aws.String(roleArn.Resource[strings.LastIndex(roleArn.Resource, "/")+1:])}, nil
+}
+
+// gets the instance type of the build, set from the size of the provider by sizing
+func instanceType(provider map[string]interface{}) (string, error) {
+	if instanceType, _ := provider["instanceType"].(string); instanceType != "" {
+		return instanceType, nil
+	}
+	arch, err := launcher.Architecture(provider)
+	if err != nil {
+		return "", err
+	}
+	if arch == launcher.ARM64 {
+		return defaultArmInstanceType, nil
+	}
+	return defaultInstanceType, nil
+}
+
+// gets the user data script of the build, copying the launcher into /opt/sd and running the build container
+// with docker, the instance shuts down and terminates when the script exits
+func getUserData(config map[string]interface{}, region string) (string, error) {
+	provider := config["provider"].(map[string]interface{})
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	pipelineID, _ := config["pipelineId"].(json.Number).Int64()
+	buildTimeout, _ := config["buildTimeout"].(json.Number).Int64()
+	// flags are validated when the build is started
+	flags, _ := launcher.GetFlags(provider, executorName)
+
+	env := map[string]string{
+		"CONTAINER_IMAGE":    config["container"].(string),
+		"SD_PIPELINE_ID":     fmt.Sprint(pipelineID),
+		"SD_TEMP":            "/opt/sd_tmp",
+		"SD_AWS_INTEGRATION": strconv.FormatBool(true),
+	}
+	for _, v := range flags.Env() {
+		env[v.Name] = v.Value
+	}
+	for _, v := range policy.Environment(config) {
+		env[v.Name] = v.Value
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\n")
+	fmt.Fprintf(&script, "# screwdriver build %v, the instance terminates when the build exits\n", buildID)
+	script.WriteString("exec > >(tee -a /var/log/sd-build.log > /dev/console) 2>&1\n")
+	script.WriteString("status=1\n")
+	fmt.Fprintf(&script, "finish() { echo \"%s$status\"; sync; shutdown -h now; }\n", exitCodeMarker)
+	script.WriteString("trap finish EXIT\n")
+	fmt.Fprintf(&script, "shutdown -h +%d\n", buildTimeout+shutdownGraceMinutes)
+	script.WriteString("mkdir -p /opt/sd /opt/sd_tmp /workspace\n")
+	fmt.Fprintf(&script, "docker run --rm -v /opt/sd:/opt/launcher %s /bin/sh -c %s || exit\n",
+		shellQuote(provider["launcherImage"].(string)),
+		shellQuote("cp -a /opt/sd/* /opt/launcher && if [ -d /hab ]; then mkdir -p /opt/launcher/hab && cp -a /hab/* /opt/launcher/hab; fi"))
+	// the instance reads the token from its secret with the role of its profile, the token never shows in the user data
+	if secretArn, _ := config[buildtoken.ArnKey].(string); secretArn != "" {
+		fmt.Fprintf(&script, "SD_TOKEN=$(aws secretsmanager get-secret-value --region %s --secret-id %s --query SecretString --output text) || exit\n",
+			shellQuote(region), shellQuote(secretArn))
+	} else {
+		fmt.Fprintf(&script, "SD_TOKEN=%s\n", shellQuote(config["token"].(string)))
+	}
+	script.WriteString("export SD_TOKEN\n")
+	script.WriteString("devices=\"\"\nif [ -e /dev/kvm ]; then devices=\"--device /dev/kvm\"; fi\n")
+
+	args := []string{"docker run --rm --name sdbuild $devices"}
+	if privileged, _ := provider["privilegedMode"].(bool); privileged {
+		args = append(args, "--privileged")
+	}
+	args = append(args, "-v /opt/sd:/opt/sd:ro -v /opt/sd_tmp:/opt/sd_tmp -v /workspace:/workspace -e SD_TOKEN")
+	for _, name := range names {
+		args = append(args, "-e "+shellQuote(name+"="+env[name]))
+	}
+	args = append(args, "--entrypoint /opt/sd/launcher_entrypoint.sh", shellQuote(config["container"].(string)),
+		shellQuote(fmt.Sprintf(`/opt/sd/run.sh "$SD_TOKEN" %v %v %v %v %v`,
+			config["apiUri"].(string),
+			config["storeUri"].(string),
+			fmt.Sprint(buildTimeout),
+			fmt.Sprint(buildID),
+			config["uiUri"].(string),
+		)))
+	script.WriteString(strings.Join(args, " \\\n  ") + "\n")
+	script.WriteString("status=$?\n")
+
+	if script.Len() > maxUserDataBytes {
+		return "", fmt.Errorf("user data of %d bytes exceeds the %d bytes of ec2, reduce the environment of the build", script.Len(), maxUserDataBytes)
+	}
+	return script.String(), nil
+}
+
+// gets the run instances request of the build in a subnet of the provider vpc
+func getRunInstancesInput(config map[string]interface{}, userData string, subnetID *string, clientToken string) (*ec2.RunInstancesInput, error) {
+	provider := config["provider"].(map[string]interface{})
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	profile, err := instanceProfile(provider)
+	if err != nil {
+		return nil, err
+	}
+	instanceType, err := instanceType(provider)
+	if err != nil {
+		return nil, err
+	}
+	public, _ := provider["assignPublicIp"].(bool)
+
+	input := &ec2.RunInstancesInput{
+		ImageId:                           aws.String(provider["imageId"].(string)),
+		InstanceType:                      aws.String(instanceType),
+		MinCount:                          aws.Int64(1),
+		MaxCount:                          aws.Int64(1),
+		ClientToken:                       aws.String(clientToken),
+		IamInstanceProfile:                profile,
+		InstanceInitiatedShutdownBehavior: aws.String(ec2.ShutdownBehaviorTerminate),
+		UserData:                          aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
+		MetadataOptions: &ec2.InstanceMetadataOptionsRequest{
+			HttpTokens:   aws.String(ec2.HttpTokensStateRequired),
+			HttpEndpoint: aws.String(ec2.InstanceMetadataEndpointStateEnabled),
+		},
+		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex:              aws.Int64(0),
+			SubnetId:                 subnetID,
+			Groups:                   stringSlice(vpc["securityGroupIds"]),
+			AssociatePublicIpAddress: aws.Bool(public),
+			DeleteOnTermination:      aws.Bool(true),
+		}},
+		TagSpecifications: []*ec2.TagSpecification{
+			{ResourceType: aws.String(ec2.ResourceTypeInstance), Tags: getTags(config)},
+			{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: getTags(config)},
+		},
+	}
+	if value, _ := provider["instanceDisk"].(string); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 || size > maxRootVolumeGiB {
+			return nil, fmt.Errorf("instanceDisk %q must be a number of GiB up to %d", value, maxRootVolumeGiB)
+		}
+		deviceName, _ := provider["rootDeviceName"].(string)
+		if deviceName == "" {
+			deviceName = defaultRootDeviceName
+		}
+		input.BlockDeviceMappings = []*ec2.BlockDeviceMapping{{
+			DeviceName: aws.String(deviceName),
+			Ebs: &ec2.EbsBlockDevice{
+				VolumeSize:          aws.Int64(size),
+				VolumeType:          aws.String(ec2.VolumeTypeGp3),
+				Encrypted:           aws.Bool(true),
+				DeleteOnTermination: aws.Bool(true),
+			},
+		}}
+	}
+	return input, nil
+}
+
+// Start launches the instance of the build, trying the subnets of the provider in turn while they lack capacity
+func (e *AwsExecutorEC2) Start(config map[string]interface{}) (string, error) {
+	provider := config["provider"].(map[string]interface{})
+	if _, err := launcher.GetFlags(provider, executorName); err != nil {
+		return "", executorState.Errorf(executorState.UserError, "%w", err)
+	}
+	region, _ := provider["region"].(string)
+	if buildRegion, _ := provider["buildRegion"].(string); buildRegion != "" {
+		region = buildRegion
+	}
+
+	// a redelivered start adopts the instance of the earlier start
+	if instanceID := e.liveInstance(config); instanceID != "" {
+		log.Printf("Instance %v of build %v is already running, adopting it", instanceID, config["buildId"])
+		config[InstanceIDKey] = instanceID
+		return instanceID, nil
+	}
+
+	userData, err := getUserData(config, region)
+	if err != nil {
+		return "", executorState.Errorf(executorState.UserError, "%w", err)
+	}
+	log.Printf("User data %v", redact.Values(userData, config["token"].(string)))
+
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	subnets := stringSlice(vpc["subnetIds"])
+	if len(subnets) == 0 {
+		return "", executorState.Errorf(executorState.UserError, "provider vpc has no subnetIds")
+	}
+	var startErr error
+	for i, subnetID := range subnets {
+		// each subnet gets its own token, ec2 rejects a token reused with other parameters
+		input, err := getRunInstancesInput(config, userData, subnetID, fmt.Sprintf("%s-%d", instanceName(config), i))
+		if err != nil {
+			return "", executorState.Errorf(executorState.UserError, "%w", err)
+		}
+		result, err := e.serviceClient.ec2.RunInstances(input)
+		if err != nil {
+			startErr = startError(err)
+			if executorState.IsCapacity(startErr) {
+				log.Printf("No capacity in subnet %v for build %v: %v", aws.StringValue(subnetID), config["buildId"], err)
+				continue
+			}
+			return "", startErr
+		}
+		if len(result.Instances) == 0 {
+			return "", executorState.Errorf(executorState.InfraTransient, "Error-RunInstances: no instance started")
+		}
+		instanceID := aws.StringValue(result.Instances[0].InstanceId)
+		config[InstanceIDKey] = instanceID
+		log.Printf("Started instance %v in subnet %v for build %v", instanceID, aws.StringValue(subnetID), config["buildId"])
+		return instanceID, nil
+	}
+	return "", startErr
+}
+
+// gets the id of an instance of the build which did not terminate, empty if there is none
+func (e *AwsExecutorEC2) liveInstance(config map[string]interface{}) string {
+	instances, err := e.listInstances(config, liveStates)
+	if err != nil {
+		log.Printf("Error listing instances of build %v, starting a new instance: %v", config["buildId"], err)
+		return ""
+	}
+	if len(instances) == 0 {
+		return ""
+	}
+	return aws.StringValue(instances[0].InstanceId)
+}
+
+// lists the instances of the build in the given states, the latest launched first
+func (e *AwsExecutorEC2) listInstances(config map[string]interface{}, states []string) ([]*ec2.Instance, error) {
+	var instances []*ec2.Instance
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{instanceName(config)})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice(states)},
+		},
+	}
+	err := e.serviceClient.ec2.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-DescribeInstances: %v", err)
+	}
+	sort.SliceStable(instances, func(i, j int) bool {
+		return aws.TimeValue(instances[i].LaunchTime).After(aws.TimeValue(instances[j].LaunchTime))
+	})
+	return instances, nil
+}
+
+// Stop terminates the instances of the build
+func (e *AwsExecutorEC2) Stop(config map[string]interface{}) error {
+	instances, err := e.listInstances(config, liveStates)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		log.Printf("No instances of build %v are left, nothing to stop", config["buildId"])
+		return nil
+	}
+	var ids []*string
+	for _, instance := range instances {
+		ids = append(ids, instance.InstanceId)
+	}
+	log.Printf("Terminating instances...%s", strings.Join(aws.StringValueSlice(ids), ", "))
+	if _, err := e.serviceClient.ec2.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: ids}); err != nil {
+		return fmt.Errorf("failed to terminate instances %v: Error-TerminateInstances: %v", strings.Join(aws.StringValueSlice(ids), ", "), err)
+	}
+	return nil
+}
+
+// Cleanup has nothing to clean up, the instances of a job terminate with their builds
+func (e *AwsExecutorEC2) Cleanup(config map[string]interface{}) error {
+	return nil
+}
+
+// Name returns the name of executor
+func (e *AwsExecutorEC2) Name() string {
+	return e.name
+}
+
+// New returns a new instance of the EC2 executor, failing when the aws session can't be created
+func New(region string) (*AwsExecutorEC2, error) {
+	sess, err := awsconfig.NewSession(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AwsExecutorEC2{
+		name: executorName,
+		serviceClient: &awsAPI{
+			ec2: ec2.New(sess),
+		},
+	}, nil
+}
@@ -0,0 +1,272 @@
+package ec2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+)
+
+const testInstanceID = "i-0123456789abcdef0"
+
+type mockEC2 struct {
+	ec2iface.EC2API
+	runs          []*ec2.RunInstancesInput
+	runErrs       []error
+	described     []*ec2.DescribeInstancesInput
+	instances     []*ec2.Instance
+	terminated    []string
+	terminateErr  error
+	consoleOutput string
+	consoleErr    error
+}
+
+func (m *mockEC2) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	m.runs = append(m.runs, input)
+	if len(m.runErrs) >= len(m.runs) && m.runErrs[len(m.runs)-1] != nil {
+		return nil, m.runErrs[len(m.runs)-1]
+	}
+	return &ec2.Reservation{Instances: []*ec2.Instance{{InstanceId: aws.String(testInstanceID)}}}, nil
+}
+
+func (m *mockEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	m.described = append(m.described, input)
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: m.instances}}}, true)
+	return nil
+}
+
+func (m *mockEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	m.described = append(m.described, input)
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: m.instances}}}, nil
+}
+
+func (m *mockEC2) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	m.terminated = append(m.terminated, aws.StringValueSlice(input.InstanceIds)...)
+	return &ec2.TerminateInstancesOutput{}, m.terminateErr
+}
+
+func (m *mockEC2) GetConsoleOutput(input *ec2.GetConsoleOutputInput) (*ec2.GetConsoleOutputOutput, error) {
+	if m.consoleErr != nil {
+		return nil, m.consoleErr
+	}
+	return &ec2.GetConsoleOutputOutput{
+		InstanceId: input.InstanceId,
+		Output:     aws.String(base64.StdEncoding.EncodeToString([]byte(m.consoleOutput))),
+	}, nil
+}
+
+func getTestConfig() map[string]interface{} {
+	configObj := `{
+		"jobName": "main",
+		"jobId": 123,
+		"buildId": 1234,
+		"container": "node:18",
+		"pipelineId": 1898,
+		"token": "abc",
+		"storeUri": "store.uri",
+		"apiUri": "api.uri",
+		"uiUri": "ui.uri",
+		"buildTimeout": 90,
+		"isPR": false,
+		"provider": {
+			"role": "arn:aws:iam::123456789012:role/builds/sd-build",
+			"region": "us-west-2",
+			"imageId": "ami-0abc",
+			"vpc": {
+				"vpcId": "vpc-12345",
+				"securityGroupIds": ["sg-123"],
+				"subnetIds": ["subnet-1111", "subnet-2222"]
+			},
+			"launcherImage": "screwdrivercd/launcher:v6.0.180",
+			"launcherVersion": "v6.0.180"
+		}
+	}`
+	decoder := json.NewDecoder(strings.NewReader(configObj))
+	decoder.UseNumber()
+	var config map[string]interface{}
+	_ = decoder.Decode(&config)
+	return config
+}
+
+func newTestExecutor(client *mockEC2) *AwsExecutorEC2 {
+	return &AwsExecutorEC2{name: executorName, serviceClient: &awsAPI{ec2: client}}
+}
+
+func TestInstanceName(t *testing.T) {
+	config := getTestConfig()
+	assert.Equal(t, "sdbuild-1234", instanceName(config))
+
+	config[matrix.ArchitectureKey] = "arm64"
+	assert.Equal(t, "sdbuild-1234-arm64", instanceName(config))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "'node:18'", shellQuote("node:18"))
+	assert.Equal(t, `'it'"'"'s'`, shellQuote("it's"))
+}
+
+func TestInstanceProfile(t *testing.T) {
+	provider := getTestConfig()["provider"].(map[string]interface{})
+	profile, err := instanceProfile(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, &ec2.IamInstanceProfileSpecification{Name: aws.String("sd-build")}, profile)
+
+	provider["instanceProfile"] = "arn:aws:iam::123456789012:instance-profile/sd-metal"
+	profile, err = instanceProfile(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, &ec2.IamInstanceProfileSpecification{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/sd-metal")}, profile)
+
+	provider["instanceProfile"] = "sd-metal"
+	profile, err = instanceProfile(provider)
+	assert.Nil(t, err)
+	assert.Equal(t, &ec2.IamInstanceProfileSpecification{Name: aws.String("sd-metal")}, profile)
+
+	_, err = instanceProfile(map[string]interface{}{"role": "sd-build"})
+	assert.EqualError(t, err, `role "sd-build" is not an arn, set instanceProfile`)
+}
+
+func TestGetUserData(t *testing.T) {
+	config := getTestConfig()
+	userData, err := getUserData(config, "us-west-2")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(userData, "#!/bin/bash\n"))
+	assert.Contains(t, userData, "shutdown -h +105\n")
+	assert.Contains(t, userData, "docker run --rm -v /opt/sd:/opt/launcher 'screwdrivercd/launcher:v6.0.180' /bin/sh -c ")
+	assert.Contains(t, userData, "SD_TOKEN='abc'\n")
+	assert.Contains(t, userData, "-e 'SD_HAB_ENABLED=false'")
+	assert.Contains(t, userData, "-e 'CONTAINER_IMAGE=node:18'")
+	assert.Contains(t, userData, "--entrypoint /opt/sd/launcher_entrypoint.sh \\\n  'node:18' \\\n  '/opt/sd/run.sh \"$SD_TOKEN\" api.uri store.uri 90 1234 ui.uri'\nstatus=$?\n")
+	assert.NotContains(t, userData, "--privileged")
+	assert.Contains(t, userData, exitCodeMarker)
+
+	// privileged builds with a token secret read the token on the instance
+	provider := config["provider"].(map[string]interface{})
+	provider["privilegedMode"] = true
+	config[buildtoken.ArnKey] = "arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-1234"
+	userData, err = getUserData(config, "us-west-2")
+	assert.Nil(t, err)
+	assert.Contains(t, userData, "--privileged")
+	assert.Contains(t, userData, "aws secretsmanager get-secret-value --region 'us-west-2' --secret-id 'arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-1234'")
+	assert.NotContains(t, userData, "abc")
+
+	config["container"] = strings.Repeat("x", maxUserDataBytes)
+	_, err = getUserData(config, "us-west-2")
+	assert.Error(t, err)
+}
+
+func TestGetRunInstancesInput(t *testing.T) {
+	config := getTestConfig()
+	input, err := getRunInstancesInput(config, "#!/bin/bash\n", aws.String("subnet-1111"), "sdbuild-1234-0")
+	assert.Nil(t, err)
+	assert.Equal(t, "ami-0abc", aws.StringValue(input.ImageId))
+	assert.Equal(t, "m5.large", aws.StringValue(input.InstanceType))
+	assert.Equal(t, "sdbuild-1234-0", aws.StringValue(input.ClientToken))
+	assert.Equal(t, "terminate", aws.StringValue(input.InstanceInitiatedShutdownBehavior))
+	assert.Equal(t, "sd-build", aws.StringValue(input.IamInstanceProfile.Name))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\n")), aws.StringValue(input.UserData))
+	assert.Equal(t, "required", aws.StringValue(input.MetadataOptions.HttpTokens))
+	assert.Equal(t, "subnet-1111", aws.StringValue(input.NetworkInterfaces[0].SubnetId))
+	assert.Equal(t, []string{"sg-123"}, aws.StringValueSlice(input.NetworkInterfaces[0].Groups))
+	assert.False(t, aws.BoolValue(input.NetworkInterfaces[0].AssociatePublicIpAddress))
+	assert.Nil(t, input.BlockDeviceMappings)
+	assert.Equal(t, []*ec2.Tag{
+		{Key: aws.String("Name"), Value: aws.String("sdbuild-1234")},
+		{Key: aws.String("managed-by"), Value: aws.String("screwdriver")},
+		{Key: aws.String("sd-job-id"), Value: aws.String("123")},
+		{Key: aws.String("sd-pipeline-id"), Value: aws.String("1898")},
+		{Key: aws.String("sdbuild"), Value: aws.String("1234")},
+	}, input.TagSpecifications[0].Tags)
+	assert.Equal(t, "volume", aws.StringValue(input.TagSpecifications[1].ResourceType))
+
+	provider := config["provider"].(map[string]interface{})
+	provider["architecture"] = "arm64"
+	provider["assignPublicIp"] = true
+	provider["instanceDisk"] = "200"
+	input, err = getRunInstancesInput(config, "", aws.String("subnet-2222"), "sdbuild-1234-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "m6g.large", aws.StringValue(input.InstanceType))
+	assert.True(t, aws.BoolValue(input.NetworkInterfaces[0].AssociatePublicIpAddress))
+	assert.Equal(t, "/dev/xvda", aws.StringValue(input.BlockDeviceMappings[0].DeviceName))
+	assert.Equal(t, int64(200), aws.Int64Value(input.BlockDeviceMappings[0].Ebs.VolumeSize))
+	assert.True(t, aws.BoolValue(input.BlockDeviceMappings[0].Ebs.Encrypted))
+
+	provider["instanceType"] = "c5.metal"
+	input, err = getRunInstancesInput(config, "", aws.String("subnet-2222"), "sdbuild-1234-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "c5.metal", aws.StringValue(input.InstanceType))
+
+	provider["instanceDisk"] = "lots"
+	_, err = getRunInstancesInput(config, "", aws.String("subnet-2222"), "sdbuild-1234-1")
+	assert.EqualError(t, err, `instanceDisk "lots" must be a number of GiB up to 16384`)
+}
+
+func TestStart(t *testing.T) {
+	client := &mockEC2{}
+	executor := newTestExecutor(client)
+	config := getTestConfig()
+	instanceID, err := executor.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, testInstanceID, instanceID)
+	assert.Equal(t, testInstanceID, config[InstanceIDKey])
+	assert.Len(t, client.runs, 1)
+	assert.Equal(t, "sdbuild-1234", aws.StringValue(client.described[0].Filters[0].Values[0]))
+
+	// the next subnet is tried while a subnet has no capacity
+	client = &mockEC2{runErrs: []error{awserr.New("InsufficientInstanceCapacity", "no c5.metal capacity", nil)}}
+	instanceID, err = newTestExecutor(client).Start(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, testInstanceID, instanceID)
+	assert.Equal(t, "subnet-2222", aws.StringValue(client.runs[1].NetworkInterfaces[0].SubnetId))
+	assert.Equal(t, "sdbuild-1234-1", aws.StringValue(client.runs[1].ClientToken))
+
+	// a redelivered start adopts the live instance
+	client = &mockEC2{instances: []*ec2.Instance{{InstanceId: aws.String(testInstanceID)}}}
+	instanceID, err = newTestExecutor(client).Start(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, testInstanceID, instanceID)
+	assert.Empty(t, client.runs)
+}
+
+func TestStartErrors(t *testing.T) {
+	capacity := awserr.New("InsufficientInstanceCapacity", "no capacity", nil)
+	client := &mockEC2{runErrs: []error{capacity, capacity}}
+	_, err := newTestExecutor(client).Start(getTestConfig())
+	assert.True(t, executorState.IsCapacity(err))
+	assert.Len(t, client.runs, 2)
+
+	client = &mockEC2{runErrs: []error{awserr.New("InvalidAMIID.NotFound", "The image id '[ami-0abc]' does not exist", nil)}}
+	_, err = newTestExecutor(client).Start(getTestConfig())
+	assert.Equal(t, executorState.UserError, executorState.CategoryOf(err))
+	assert.Len(t, client.runs, 1)
+
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["vpc"] = map[string]interface{}{}
+	_, err = newTestExecutor(&mockEC2{}).Start(config)
+	assert.EqualError(t, err, "provider vpc has no subnetIds")
+	assert.Equal(t, executorState.UserError, executorState.CategoryOf(err))
+}
+
+func TestStop(t *testing.T) {
+	client := &mockEC2{instances: []*ec2.Instance{{InstanceId: aws.String(testInstanceID)}}}
+	assert.Nil(t, newTestExecutor(client).Stop(getTestConfig()))
+	assert.Equal(t, []string{testInstanceID}, client.terminated)
+
+	client = &mockEC2{}
+	assert.Nil(t, newTestExecutor(client).Stop(getTestConfig()))
+	assert.Empty(t, client.terminated)
+
+	client = &mockEC2{instances: []*ec2.Instance{{InstanceId: aws.String(testInstanceID)}}, terminateErr: errors.New("UnauthorizedOperation")}
+	assert.EqualError(t, newTestExecutor(client).Stop(getTestConfig()),
+		"failed to terminate instances "+testInstanceID+": Error-TerminateInstances: UnauthorizedOperation")
+}
@@ -0,0 +1,43 @@
+package ec2
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// error codes of exhausted instance capacity or account limits, starting later or in another zone may succeed
+var capacityCodes = map[string]bool{
+	"InsufficientInstanceCapacity":      true,
+	"InstanceLimitExceeded":             true,
+	"VcpuLimitExceeded":                 true,
+	"InsufficientFreeAddressesInSubnet": true,
+}
+
+// categories of the aws error codes of failed starts, other codes are left uncategorized
+var errorCategories = map[string]executorState.Category{
+	"InvalidAMIID.NotFound":       executorState.UserError,
+	"InvalidAMIID.Malformed":      executorState.UserError,
+	"InvalidParameterValue":       executorState.UserError,
+	"InvalidParameterCombination": executorState.UserError,
+	"Unsupported":                 executorState.UserError,
+	"UnauthorizedOperation":       executorState.InfraPermanent,
+	"InvalidSubnetID.NotFound":    executorState.InfraPermanent,
+	"InvalidGroup.NotFound":       executorState.InfraPermanent,
+	"RequestLimitExceeded":        executorState.InfraTransient,
+	"InternalError":               executorState.InfraTransient,
+	"Unavailable":                 executorState.InfraTransient,
+	request.ErrCodeRequestError:   executorState.InfraTransient,
+}
+
+// gets the error of a run instances call, exhausted capacity is a capacity error so the next subnet is tried
+func startError(err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		if capacityCodes[aerr.Code()] {
+			return executorState.CapacityErrorf("Error-RunInstances: %v", err)
+		}
+		return executorState.Errorf(errorCategories[aerr.Code()], "Error-RunInstances: %v", err)
+	}
+	return executorState.Errorf("", "Error-RunInstances: %v", err)
+}
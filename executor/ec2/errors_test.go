@@ -0,0 +1,25 @@
+package ec2
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestStartError(t *testing.T) {
+	err := startError(awserr.New("VcpuLimitExceeded", "You have requested more vCPU capacity than your current vCPU limit", nil))
+	assert.True(t, executorState.IsCapacity(err))
+	assert.Equal(t, executorState.InfraTransient, executorState.CategoryOf(err))
+
+	err = startError(awserr.New("InvalidParameterValue", "Invalid IAM Instance Profile name", nil))
+	assert.EqualError(t, err, "Error-RunInstances: InvalidParameterValue: Invalid IAM Instance Profile name")
+	assert.Equal(t, executorState.UserError, executorState.CategoryOf(err))
+
+	assert.Equal(t, executorState.InfraPermanent, executorState.CategoryOf(startError(awserr.New("UnauthorizedOperation", "not authorized", nil))))
+	assert.Equal(t, executorState.InfraTransient, executorState.CategoryOf(startError(awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil))))
+	assert.Equal(t, executorState.Category(""), executorState.CategoryOf(startError(errors.New("unknown"))))
+}
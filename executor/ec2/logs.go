@@ -0,0 +1,28 @@
+package ec2
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Logs gets the console output of the instance of the build, ec2 keeps the latest 64 KB without timestamps,
+// so the whole output is returned whatever the given time
+func (e *AwsExecutorEC2) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	instance, err := e.getInstance(config)
+	if err != nil {
+		return nil, err
+	}
+	instanceID := aws.StringValue(instance.InstanceId)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "==> ec2 console of instance %s (%s) <==\n", instanceID, aws.StringValue(instance.State.Name))
+	output, err := e.consoleOutput(instance.InstanceId)
+	if err != nil {
+		fmt.Fprintf(buf, "%v\n", err)
+		return buf.Bytes(), nil
+	}
+	buf.WriteString(output)
+	return buf.Bytes(), nil
+}
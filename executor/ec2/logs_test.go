@@ -0,0 +1,25 @@
+package ec2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogs(t *testing.T) {
+	client := &mockEC2{instances: []*ec2.Instance{testInstance("running")}, consoleOutput: "==> sd-step install\nnpm ci\n"}
+	logs, err := newTestExecutor(client).Logs(getTestConfig(), time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, "==> ec2 console of instance "+testInstanceID+" (running) <==\n==> sd-step install\nnpm ci\n", string(logs))
+
+	client = &mockEC2{instances: []*ec2.Instance{testInstance("terminated")}, consoleErr: errors.New("InvalidInstanceID.NotFound")}
+	logs, err = newTestExecutor(client).Logs(getTestConfig(), time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, "==> ec2 console of instance "+testInstanceID+" (terminated) <==\nError-GetConsoleOutput: InvalidInstanceID.NotFound\n", string(logs))
+
+	_, err = newTestExecutor(&mockEC2{}).Logs(getTestConfig(), time.Now())
+	assert.EqualError(t, err, "no instance found for build 1234")
+}
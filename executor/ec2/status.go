@@ -0,0 +1,105 @@
+package ec2
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// gets the exit code of the build the user data wrote to the console output, false if the build did not exit
+func exitCode(consoleOutput string) (int, bool) {
+	i := strings.LastIndex(consoleOutput, exitCodeMarker)
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(consoleOutput[i+len(exitCodeMarker):])
+	if len(fields) == 0 {
+		return 0, false
+	}
+	code, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// normalizes the status of an instance, the build succeeded when the console output reports the exit code 0
+func instanceStatus(instance *ec2.Instance, consoleOutput string) executor.Status {
+	state := aws.StringValue(instance.State.Name)
+	switch state {
+	case ec2.InstanceStateNamePending:
+		return executor.Status{State: executor.Queued, Reason: state}
+	case ec2.InstanceStateNameRunning:
+		return executor.Status{State: executor.Running}
+	}
+	if code, ok := exitCode(consoleOutput); ok {
+		if code == 0 {
+			return executor.Status{State: executor.Succeeded}
+		}
+		return executor.Status{State: executor.Failed, Reason: fmt.Sprintf("build exited with %d", code)}
+	}
+	reason := state
+	if instance.StateReason != nil {
+		reason = aws.StringValue(instance.StateReason.Message)
+	}
+	return executor.Status{State: executor.Failed, Reason: reason}
+}
+
+// gets the instance of the build, the latest launched one when the build config has no instance id
+func (e *AwsExecutorEC2) getInstance(config map[string]interface{}) (*ec2.Instance, error) {
+	if instanceID, _ := config[InstanceIDKey].(string); instanceID != "" {
+		result, err := e.serviceClient.ec2.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{instanceID})})
+		if err != nil {
+			return nil, fmt.Errorf("Error-DescribeInstances: %v", err)
+		}
+		for _, reservation := range result.Reservations {
+			if len(reservation.Instances) > 0 {
+				return reservation.Instances[0], nil
+			}
+		}
+		return nil, fmt.Errorf("instance %v not found", instanceID)
+	}
+	instances, err := e.listInstances(config, ec2.InstanceStateName_Values())
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instance found for build %v", config["buildId"])
+	}
+	return instances[0], nil
+}
+
+// gets the decoded console output of the instance
+func (e *AwsExecutorEC2) consoleOutput(instanceID *string) (string, error) {
+	result, err := e.serviceClient.ec2.GetConsoleOutput(&ec2.GetConsoleOutputInput{InstanceId: instanceID})
+	if err != nil {
+		return "", fmt.Errorf("Error-GetConsoleOutput: %v", err)
+	}
+	output, err := base64.StdEncoding.DecodeString(aws.StringValue(result.Output))
+	if err != nil {
+		return "", fmt.Errorf("invalid console output of instance %v: %v", aws.StringValue(instanceID), err)
+	}
+	return string(output), nil
+}
+
+// Status gets the normalized state of the instance running the screwdriver build
+func (e *AwsExecutorEC2) Status(config map[string]interface{}) (executor.Status, error) {
+	instance, err := e.getInstance(config)
+	if err != nil {
+		return executor.Status{}, err
+	}
+	var output string
+	// only instances which shut down can have an exit code
+	if state := aws.StringValue(instance.State.Name); state != ec2.InstanceStateNamePending && state != ec2.InstanceStateNameRunning {
+		if output, err = e.consoleOutput(instance.InstanceId); err != nil {
+			return executor.Status{}, err
+		}
+	}
+	return instanceStatus(instance, output), nil
+}
@@ -0,0 +1,76 @@
+package ec2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// gets an instance of the build in the state
+func testInstance(state string) *ec2.Instance {
+	return &ec2.Instance{InstanceId: aws.String(testInstanceID), State: &ec2.InstanceState{Name: aws.String(state)}}
+}
+
+func TestExitCode(t *testing.T) {
+	code, ok := exitCode("[ 12.3] cloud-init\nsd-build-exit-code: 3\n[ 13.0] reboot: Power down\n")
+	assert.True(t, ok)
+	assert.Equal(t, 3, code)
+
+	code, ok = exitCode("sd-build-exit-code: 1\nsd-build-exit-code: 0\n")
+	assert.True(t, ok)
+	assert.Equal(t, 0, code)
+
+	_, ok = exitCode("[ 12.3] cloud-init\n")
+	assert.False(t, ok)
+	_, ok = exitCode("sd-build-exit-code: ")
+	assert.False(t, ok)
+}
+
+func TestInstanceStatus(t *testing.T) {
+	assert.Equal(t, executor.Status{State: executor.Queued, Reason: "pending"}, instanceStatus(testInstance("pending"), ""))
+	assert.Equal(t, executor.Status{State: executor.Running}, instanceStatus(testInstance("running"), ""))
+	assert.Equal(t, executor.Status{State: executor.Succeeded}, instanceStatus(testInstance("terminated"), "sd-build-exit-code: 0\n"))
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "build exited with 2"}, instanceStatus(testInstance("shutting-down"), "sd-build-exit-code: 2\n"))
+
+	stopped := testInstance("terminated")
+	stopped.StateReason = &ec2.StateReason{Code: aws.String("Client.UserInitiatedShutdown"), Message: aws.String("Client.UserInitiatedShutdown: User initiated shutdown")}
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "Client.UserInitiatedShutdown: User initiated shutdown"}, instanceStatus(stopped, ""))
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "stopped"}, instanceStatus(testInstance("stopped"), ""))
+}
+
+func TestStatus(t *testing.T) {
+	client := &mockEC2{instances: []*ec2.Instance{testInstance("running")}}
+	status, err := newTestExecutor(client).Status(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Running, status.State)
+
+	// the latest instance of the build is used without an instance id
+	older := testInstance("terminated")
+	older.InstanceId = aws.String("i-0older")
+	older.LaunchTime = aws.Time(time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC))
+	latest := testInstance("terminated")
+	latest.LaunchTime = aws.Time(time.Date(2022, 3, 1, 11, 0, 0, 0, time.UTC))
+	client = &mockEC2{instances: []*ec2.Instance{older, latest}, consoleOutput: "sd-build-exit-code: 0\n"}
+	status, err = newTestExecutor(client).Status(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Succeeded, status.State)
+	assert.Len(t, client.described[0].Filters, 2)
+
+	config := getTestConfig()
+	config[InstanceIDKey] = testInstanceID
+	client = &mockEC2{instances: []*ec2.Instance{testInstance("pending")}}
+	status, err = newTestExecutor(client).Status(config)
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Queued, status.State)
+	assert.Equal(t, []string{testInstanceID}, aws.StringValueSlice(client.described[0].InstanceIds))
+
+	_, err = newTestExecutor(&mockEC2{}).Status(getTestConfig())
+	assert.EqualError(t, err, "no instance found for build 1234")
+	_, err = newTestExecutor(&mockEC2{}).Status(config)
+	assert.EqualError(t, err, "instance "+testInstanceID+" not found")
+}
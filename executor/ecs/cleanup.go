@@ -0,0 +1,55 @@
+package ecs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// gets the family of a task definition arn, arn:aws:ecs:<region>:<account>:task-definition/<family>:<revision>
+func taskDefinitionFamily(taskDefinitionArn string) string {
+	parsed, err := arn.Parse(taskDefinitionArn)
+	if err != nil {
+		return ""
+	}
+	family := strings.TrimPrefix(parsed.Resource, "task-definition/")
+	if i := strings.LastIndex(family, ":"); i >= 0 {
+		family = family[:i]
+	}
+	return family
+}
+
+// Cleanup deregisters the active task definitions of the family of an archived job
+func (e *AwsExecutorECS) Cleanup(config map[string]interface{}) error {
+	family := getFamilyName(config)
+	var arns []*string
+	err := e.serviceClient.ecs.ListTaskDefinitionsPages(&ecs.ListTaskDefinitionsInput{
+		FamilyPrefix: aws.String(family),
+		Status:       aws.String(ecs.TaskDefinitionStatusActive),
+	}, func(page *ecs.ListTaskDefinitionsOutput, lastPage bool) bool {
+		// the prefix also matches the families of other jobs
+		for _, definitionArn := range page.TaskDefinitionArns {
+			if taskDefinitionFamily(aws.StringValue(definitionArn)) == family {
+				arns = append(arns, definitionArn)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean up task definitions %s: Error-ListTaskDefinitions: %v", family, err)
+	}
+
+	var failures []string
+	for _, definitionArn := range arns {
+		if _, err := e.serviceClient.ecs.DeregisterTaskDefinition(&ecs.DeregisterTaskDefinitionInput{TaskDefinition: definitionArn}); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", aws.StringValue(definitionArn), err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to clean up task definitions %s: %s", family, strings.Join(failures, "; "))
+	}
+	return nil
+}
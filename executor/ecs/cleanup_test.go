@@ -0,0 +1,32 @@
+package ecs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskDefinitionFamily(t *testing.T) {
+	assert.Equal(t, "main-123", taskDefinitionFamily("arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3"))
+	assert.Equal(t, "", taskDefinitionFamily("main-123"))
+}
+
+func TestCleanup(t *testing.T) {
+	client := &mockECS{definitions: aws.StringSlice([]string{
+		"arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:2",
+		"arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3",
+		"arn:aws:ecs:us-west-2:123456789012:task-definition/main-1234:1",
+	})}
+	assert.Nil(t, newTestExecutor(client).Cleanup(getTestConfig()))
+	assert.Equal(t, []string{
+		"arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:2",
+		"arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3",
+	}, client.deregistered)
+
+	client.definitions = client.definitions[:1]
+	client.deregisterErr = errors.New("ServerException")
+	assert.EqualError(t, newTestExecutor(client).Cleanup(getTestConfig()),
+		"failed to clean up task definitions main-123: arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:2: ServerException")
+}
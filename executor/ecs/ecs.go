@@ -0,0 +1,499 @@
+// Package ecs runs builds as fargate tasks, for accounts without an eks cluster or codebuild quota. The task of a
+// build copies the launcher from the launcher image into a volume shared with the build container, like the init
+// container of the eks pods.
+package ecs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/tags"
+)
+
+const (
+	executorName = "ecs"
+	// logGroupEnv is the cloudwatch log group of the task containers, created by the awslogs driver
+	logGroupEnv     = "SD_ECS_LOG_GROUP"
+	defaultLogGroup = "/aws/ecs/screwdriver"
+	logStreamPrefix = "sd"
+	// names of the containers of the task
+	launcherContainer = "launcher"
+	buildContainer    = "build"
+	// task size of providers without a size
+	defaultTaskCPU    = "1024"
+	defaultTaskMemory = "2048"
+	// fargate tasks get 20 GiB of ephemeral storage unless they ask for 21 to 200 GiB
+	minEphemeralStorageGiB = 21
+	maxEphemeralStorageGiB = 200
+	// TaskArnKey is the build config key of the arn of the task of the build
+	TaskArnKey = "ecsTaskArn"
+	// buildTag tags the task with the screwdriver build, like the sdbuild label of the eks pods
+	buildTag = "sdbuild"
+	// definitionTag tags task definitions with the hash of their registration, so the builds of a job reuse the
+	// revision registered for the same definition
+	definitionTag = "sd-definition-hash"
+)
+
+// aws api definition struct
+type awsAPI struct {
+	ecs  ecsiface.ECSAPI
+	logs cloudwatchlogsiface.CloudWatchLogsAPI
+}
+
+// AwsExecutorECS definition struct
+type AwsExecutorECS struct {
+	serviceClient *awsAPI
+	name          string
+}
+
+// gets the cloudwatch log group of the task containers
+func logGroup() string {
+	if group := os.Getenv(logGroupEnv); group != "" {
+		return group
+	}
+	return defaultLogGroup
+}
+
+// gets the ecs cluster of the build, the default cluster of the account when empty
+func clusterName(config map[string]interface{}) string {
+	provider := config["provider"].(map[string]interface{})
+	if name, _ := provider["clusterName"].(string); name != "" {
+		return name
+	}
+	name, _ := config["clusterName"].(string)
+	return name
+}
+
+// gets the cluster of an api input, nil for the default cluster
+func cluster(config map[string]interface{}) *string {
+	if name := clusterName(config); name != "" {
+		return aws.String(name)
+	}
+	return nil
+}
+
+// gets the task definition family of the job, named like the codebuild project of an sls build
+func getFamilyName(config map[string]interface{}) string {
+	if projectName, _ := config[policy.ProjectNameKey].(string); projectName != "" {
+		return projectName
+	}
+	jobName := config["jobName"].(string)
+	if isPR, _ := config["isPR"].(bool); isPR {
+		jobName = strings.Replace(jobName, ":", "-", 1)
+	}
+	jobID, _ := config["jobId"].(json.Number).Int64()
+	return matrix.Name(config, jobName+"-"+fmt.Sprint(jobID))
+}
+
+// gets the value the tasks of the build are started by, the builds of a multi-architecture build only find their own
+func startedBy(config map[string]interface{}) string {
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	return matrix.Name(config, fmt.Sprintf("sdbuild-%v", buildID))
+}
+
+// gets the strings of a list of the provider
+func stringSlice(value interface{}) []*string {
+	list, _ := value.([]interface{})
+	var values []*string
+	for _, item := range list {
+		if s, ok := item.(string); ok && s != "" {
+			values = append(values, aws.String(s))
+		}
+	}
+	return values
+}
+
+// gets the tags of the task definition of the job, or of the task of the build with its build id
+func getTags(config map[string]interface{}, withBuild bool) []*ecs.Tag {
+	buildTags := tags.Build(config)
+	if withBuild {
+		buildID, _ := config["buildId"].(json.Number).Int64()
+		buildTags[buildTag] = fmt.Sprint(buildID)
+	}
+	var ecsTags []*ecs.Tag
+	for _, k := range tags.Keys(buildTags) {
+		ecsTags = append(ecsTags, &ecs.Tag{Key: aws.String(k), Value: aws.String(buildTags[k])})
+	}
+	return ecsTags
+}
+
+// gets the awslogs configuration of a container of the task
+func logConfiguration(region string) *ecs.LogConfiguration {
+	return &ecs.LogConfiguration{
+		LogDriver: aws.String(ecs.LogDriverAwslogs),
+		Options: aws.StringMap(map[string]string{
+			"awslogs-group":         logGroup(),
+			"awslogs-region":        region,
+			"awslogs-stream-prefix": logStreamPrefix,
+			"awslogs-create-group":  "true",
+		}),
+	}
+}
+
+// gets the cpu units, memory and ephemeral storage of the task, set from the size of the provider by sizing
+func getTaskSize(provider map[string]interface{}) (string, string, int64, error) {
+	cpu, _ := provider["taskCpu"].(string)
+	if cpu == "" {
+		cpu = defaultTaskCPU
+	}
+	memory, _ := provider["taskMemory"].(string)
+	if memory == "" {
+		memory = defaultTaskMemory
+	}
+	var disk int64
+	if value, _ := provider["taskDisk"].(string); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n > maxEphemeralStorageGiB {
+			return "", "", 0, fmt.Errorf("taskDisk %q must be a number of GiB up to %d", value, maxEphemeralStorageGiB)
+		}
+		if n < minEphemeralStorageGiB {
+			n = minEphemeralStorageGiB
+		}
+		disk = n
+	}
+	return cpu, memory, disk, nil
+}
+
+// gets the task definition of the build, a launcher container copying the launcher into the shared volume
+// followed by the build container running it. The definition only holds the settings of the job, the values of
+// the build like its token are passed by the overrides of the task, so the builds of a job share a revision.
+func getTaskDefinitionInput(config map[string]interface{}, region string) (*ecs.RegisterTaskDefinitionInput, error) {
+	provider := config["provider"].(map[string]interface{})
+	pipelineID, _ := config["pipelineId"].(json.Number).Int64()
+	// flags are validated when the build is started
+	flags, _ := launcher.GetFlags(provider, executorName)
+
+	if privileged, _ := provider["privilegedMode"].(bool); privileged {
+		return nil, fmt.Errorf("privilegedMode is not supported by fargate tasks")
+	}
+	arch, err := launcher.Architecture(provider)
+	if err != nil {
+		return nil, err
+	}
+	cpuArchitecture := ecs.CPUArchitectureX8664
+	if arch == launcher.ARM64 {
+		cpuArchitecture = ecs.CPUArchitectureArm64
+	}
+	cpu, memory, disk, err := getTaskSize(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []*ecs.KeyValuePair{
+		{Name: aws.String("CONTAINER_IMAGE"), Value: aws.String(config["container"].(string))},
+		{Name: aws.String("SD_PIPELINE_ID"), Value: aws.String(fmt.Sprint(pipelineID))},
+		{Name: aws.String("SD_TEMP"), Value: aws.String("/opt/sd_tmp")},
+		{Name: aws.String("SD_AWS_INTEGRATION"), Value: aws.String(strconv.FormatBool(true))},
+	}
+	for _, v := range flags.Env() {
+		env = append(env, &ecs.KeyValuePair{Name: aws.String(v.Name), Value: aws.String(v.Value)})
+	}
+
+	var secrets []*ecs.Secret
+	// the task resolves the token from its secret with the execution role, the token never shows in the task.
+	// The secret is the build's own, its builds register a revision each.
+	if arn, _ := config[buildtoken.ArnKey].(string); arn != "" {
+		secrets = []*ecs.Secret{{Name: aws.String("SD_TOKEN"), ValueFrom: aws.String(arn)}}
+	}
+
+	role := provider["role"].(string)
+	executionRole, _ := provider["executionRole"].(string)
+	if executionRole == "" {
+		executionRole = role
+	}
+
+	input := &ecs.RegisterTaskDefinitionInput{
+		Family:                  aws.String(getFamilyName(config)),
+		RequiresCompatibilities: aws.StringSlice([]string{ecs.CompatibilityFargate}),
+		NetworkMode:             aws.String(ecs.NetworkModeAwsvpc),
+		Cpu:                     aws.String(cpu),
+		Memory:                  aws.String(memory),
+		TaskRoleArn:             aws.String(role),
+		ExecutionRoleArn:        aws.String(executionRole),
+		RuntimePlatform: &ecs.RuntimePlatform{
+			CpuArchitecture:       aws.String(cpuArchitecture),
+			OperatingSystemFamily: aws.String(ecs.OSFamilyLinux),
+		},
+		Volumes: []*ecs.Volume{
+			{Name: aws.String("screwdriver")},
+			{Name: aws.String("sdtemp")},
+			{Name: aws.String("workspace")},
+		},
+		ContainerDefinitions: []*ecs.ContainerDefinition{
+			{
+				Name:      aws.String(launcherContainer),
+				Image:     aws.String(provider["launcherImage"].(string)),
+				Essential: aws.Bool(false),
+				Command:   aws.StringSlice([]string{"/bin/sh", "-c", "echo launcher_start_ts:`date +%s` > /workspace/metrics && TEMP_DIR=`mktemp -d -p /opt/launcher` && cp -a /opt/sd/* $TEMP_DIR && if [ -d /hab ]; then mkdir -p $TEMP_DIR/hab && cp -a /hab/* $TEMP_DIR/hab; fi && mv $TEMP_DIR/* /opt/launcher && rm -rf $TEMP_DIR; echo launcher_end_ts:`date +%s` >> /workspace/metrics"}),
+				MountPoints: []*ecs.MountPoint{
+					{SourceVolume: aws.String("screwdriver"), ContainerPath: aws.String("/opt/launcher")},
+					{SourceVolume: aws.String("workspace"), ContainerPath: aws.String("/workspace")},
+				},
+				LogConfiguration: logConfiguration(region),
+			},
+			{
+				Name:       aws.String(buildContainer),
+				Image:      aws.String(config["container"].(string)),
+				Essential:  aws.Bool(true),
+				EntryPoint: aws.StringSlice([]string{"/opt/sd/launcher_entrypoint.sh"}),
+				Command: aws.StringSlice([]string{
+					fmt.Sprintf(`/opt/sd/run.sh "$SD_TOKEN" %v %v "$SD_BUILD_TIMEOUT" "$SD_BUILD_ID" %v`,
+						config["apiUri"].(string),
+						config["storeUri"].(string),
+						config["uiUri"].(string),
+					),
+				}),
+				DependsOn: []*ecs.ContainerDependency{
+					{ContainerName: aws.String(launcherContainer), Condition: aws.String(ecs.ContainerConditionSuccess)},
+				},
+				Environment: env,
+				Secrets:     secrets,
+				MountPoints: []*ecs.MountPoint{
+					{SourceVolume: aws.String("screwdriver"), ContainerPath: aws.String("/opt/sd"), ReadOnly: aws.Bool(true)},
+					{SourceVolume: aws.String("sdtemp"), ContainerPath: aws.String("/opt/sd_tmp")},
+					{SourceVolume: aws.String("workspace"), ContainerPath: aws.String("/workspace")},
+				},
+				LogConfiguration: logConfiguration(region),
+			},
+		},
+		Tags: getTags(config, false),
+	}
+	if disk > 0 {
+		input.EphemeralStorage = &ecs.EphemeralStorage{SizeInGiB: aws.Int64(disk)}
+	}
+	hash, err := definitionHash(input)
+	if err != nil {
+		return nil, err
+	}
+	input.Tags = append(input.Tags, &ecs.Tag{Key: aws.String(definitionTag), Value: aws.String(hash)})
+	return input, nil
+}
+
+// gets the hash of the registration of a task definition
+func definitionHash(input *ecs.RegisterTaskDefinitionInput) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("Got error encoding task definition: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// gets the environment of the build container with the values of the build, passed by the overrides of the task
+func getBuildEnvironment(config map[string]interface{}) []*ecs.KeyValuePair {
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	buildTimeout, _ := config["buildTimeout"].(json.Number).Int64()
+	env := []*ecs.KeyValuePair{
+		{Name: aws.String("SD_BUILD_ID"), Value: aws.String(fmt.Sprint(buildID))},
+		{Name: aws.String("SD_BUILD_TIMEOUT"), Value: aws.String(fmt.Sprint(buildTimeout))},
+	}
+	// builds with a token secret get it from the secrets of the definition
+	if arn, _ := config[buildtoken.ArnKey].(string); arn == "" {
+		env = append(env, &ecs.KeyValuePair{Name: aws.String("SD_TOKEN"), Value: aws.String(config["token"].(string))})
+	}
+	for _, v := range policy.Environment(config) {
+		env = append(env, &ecs.KeyValuePair{Name: aws.String(v.Name), Value: aws.String(v.Value)})
+	}
+	return env
+}
+
+// gets the run task request of the task definition in the vpc of the provider
+func getRunTaskInput(config map[string]interface{}, taskDefinitionArn string) *ecs.RunTaskInput {
+	provider := config["provider"].(map[string]interface{})
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	assignPublicIP := ecs.AssignPublicIpDisabled
+	if public, _ := provider["assignPublicIp"].(bool); public {
+		assignPublicIP = ecs.AssignPublicIpEnabled
+	}
+	return &ecs.RunTaskInput{
+		Cluster:        cluster(config),
+		TaskDefinition: aws.String(taskDefinitionArn),
+		LaunchType:     aws.String(ecs.LaunchTypeFargate),
+		Count:          aws.Int64(1),
+		StartedBy:      aws.String(startedBy(config)),
+		NetworkConfiguration: &ecs.NetworkConfiguration{
+			AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
+				Subnets:        stringSlice(vpc["subnetIds"]),
+				SecurityGroups: stringSlice(vpc["securityGroupIds"]),
+				AssignPublicIp: aws.String(assignPublicIP),
+			},
+		},
+		Overrides: &ecs.TaskOverride{ContainerOverrides: []*ecs.ContainerOverride{
+			{Name: aws.String(buildContainer), Environment: getBuildEnvironment(config)},
+		}},
+		EnableECSManagedTags: aws.Bool(true),
+		Tags:                 getTags(config, true),
+	}
+}
+
+// gets the arn of the latest revision of the family if it was registered for the same definition, empty if not
+func (e *AwsExecutorECS) registeredDefinition(definition *ecs.RegisterTaskDefinitionInput) string {
+	var hash string
+	for _, tag := range definition.Tags {
+		if aws.StringValue(tag.Key) == definitionTag {
+			hash = aws.StringValue(tag.Value)
+		}
+	}
+	result, err := e.serviceClient.ecs.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: definition.Family,
+		Include:        aws.StringSlice([]string{ecs.TaskDefinitionFieldTags}),
+	})
+	// families without revisions are not found
+	if err != nil || result.TaskDefinition == nil || aws.StringValue(result.TaskDefinition.Status) != ecs.TaskDefinitionStatusActive {
+		return ""
+	}
+	for _, tag := range result.Tags {
+		if aws.StringValue(tag.Key) == definitionTag && aws.StringValue(tag.Value) == hash {
+			return aws.StringValue(result.TaskDefinition.TaskDefinitionArn)
+		}
+	}
+	return ""
+}
+
+// Start registers the task definition of the build and runs it as a fargate task
+func (e *AwsExecutorECS) Start(config map[string]interface{}) (string, error) {
+	provider := config["provider"].(map[string]interface{})
+	if _, err := launcher.GetFlags(provider, executorName); err != nil {
+		return "", executorState.Errorf(executorState.UserError, "%w", err)
+	}
+	region, _ := provider["region"].(string)
+	if buildRegion, _ := provider["buildRegion"].(string); buildRegion != "" {
+		region = buildRegion
+	}
+
+	// a redelivered start adopts the task of the earlier start
+	if taskArn := e.runningTask(config); taskArn != "" {
+		log.Printf("Task %v of build %v is already running, adopting it", taskArn, config["buildId"])
+		config[TaskArnKey] = taskArn
+		return taskArn, nil
+	}
+
+	definition, err := getTaskDefinitionInput(config, region)
+	if err != nil {
+		return "", executorState.Errorf(executorState.UserError, "%w", err)
+	}
+	taskDefinitionArn := e.registeredDefinition(definition)
+	if taskDefinitionArn != "" {
+		log.Printf("Reusing task definition %v", taskDefinitionArn)
+	} else {
+		log.Printf("Task definition %v", redact.Values(fmt.Sprintf("%+v", definition.ContainerDefinitions), config["token"].(string)))
+		registered, err := e.serviceClient.ecs.RegisterTaskDefinition(definition)
+		if err != nil {
+			return "", executorState.Errorf(errorCategory(err), "Error-RegisterTaskDefinition: %v", err)
+		}
+		taskDefinitionArn = aws.StringValue(registered.TaskDefinition.TaskDefinitionArn)
+		log.Printf("Registered task definition %v", taskDefinitionArn)
+	}
+
+	result, err := e.serviceClient.ecs.RunTask(getRunTaskInput(config, taskDefinitionArn))
+	if err != nil {
+		return "", executorState.Errorf(errorCategory(err), "Error-RunTask: %v", err)
+	}
+	if len(result.Tasks) == 0 {
+		return "", runTaskFailure(result.Failures)
+	}
+	taskArn := aws.StringValue(result.Tasks[0].TaskArn)
+	config[TaskArnKey] = taskArn
+	log.Printf("Started task %v for build %v", taskArn, config["buildId"])
+
+	return taskArn, nil
+}
+
+// gets the arn of a task of the build which is not stopped, empty if there is none
+func (e *AwsExecutorECS) runningTask(config map[string]interface{}) string {
+	arns, err := e.listTasks(config, ecs.DesiredStatusRunning)
+	if err != nil {
+		log.Printf("Error listing tasks of build %v, starting a new task: %v", config["buildId"], err)
+		return ""
+	}
+	if len(arns) == 0 {
+		return ""
+	}
+	return aws.StringValue(arns[0])
+}
+
+// lists the arns of the tasks of the build with the desired status
+func (e *AwsExecutorECS) listTasks(config map[string]interface{}, desiredStatus string) ([]*string, error) {
+	var arns []*string
+	input := &ecs.ListTasksInput{
+		Cluster:       cluster(config),
+		StartedBy:     aws.String(startedBy(config)),
+		DesiredStatus: aws.String(desiredStatus),
+	}
+	err := e.serviceClient.ecs.ListTasksPages(input, func(page *ecs.ListTasksOutput, lastPage bool) bool {
+		arns = append(arns, page.TaskArns...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-ListTasks: %v", err)
+	}
+	return arns, nil
+}
+
+// Stop stops the running tasks of the build, the task definition is kept for the next builds of the job
+func (e *AwsExecutorECS) Stop(config map[string]interface{}) error {
+	arns, err := e.listTasks(config, ecs.DesiredStatusRunning)
+	if err != nil {
+		return err
+	}
+	if len(arns) == 0 {
+		log.Printf("No tasks of build %v are left, nothing to stop", config["buildId"])
+		return nil
+	}
+	var failures []string
+	for _, arn := range arns {
+		log.Printf("Stopping task...%s", aws.StringValue(arn))
+		_, err := e.serviceClient.ecs.StopTask(&ecs.StopTaskInput{
+			Cluster: cluster(config),
+			Task:    arn,
+			Reason:  aws.String("Stopped by Screwdriver"),
+		})
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", aws.StringValue(arn), err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to stop tasks %v", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// Name returns the name of executor
+func (e *AwsExecutorECS) Name() string {
+	return e.name
+}
+
+// New returns a new instance of the ECS executor, failing when the aws session can't be created
+func New(region string) (*AwsExecutorECS, error) {
+	sess, err := awsconfig.NewSession(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AwsExecutorECS{
+		name: executorName,
+		serviceClient: &awsAPI{
+			ecs:  ecs.New(sess),
+			logs: cloudwatchlogs.New(sess),
+		},
+	}, nil
+}
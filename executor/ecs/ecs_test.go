@@ -0,0 +1,319 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+)
+
+const testTaskArn = "arn:aws:ecs:us-west-2:123456789012:task/sd-builds/0123456789abcdef"
+
+type mockECS struct {
+	ecsiface.ECSAPI
+	registered     []*ecs.RegisterTaskDefinitionInput
+	runs           []*ecs.RunTaskInput
+	runOutput      *ecs.RunTaskOutput
+	runErr         error
+	listed         []*ecs.ListTasksInput
+	tasks          map[string][]*string
+	stopped        []*ecs.StopTaskInput
+	stopErr        error
+	describeOutput *ecs.DescribeTasksOutput
+	definitions    []*string
+	deregistered   []string
+	deregisterErr  error
+}
+
+func (m *mockECS) RegisterTaskDefinition(input *ecs.RegisterTaskDefinitionInput) (*ecs.RegisterTaskDefinitionOutput, error) {
+	m.registered = append(m.registered, input)
+	return &ecs.RegisterTaskDefinitionOutput{TaskDefinition: &ecs.TaskDefinition{
+		TaskDefinitionArn: aws.String("arn:aws:ecs:us-west-2:123456789012:task-definition/" + aws.StringValue(input.Family) + ":3"),
+	}}, nil
+}
+
+func (m *mockECS) DescribeTaskDefinition(input *ecs.DescribeTaskDefinitionInput) (*ecs.DescribeTaskDefinitionOutput, error) {
+	// the family has the revision registered last
+	if len(m.registered) == 0 {
+		return nil, awserr.New(ecs.ErrCodeClientException, "Unable to describe task definition.", nil)
+	}
+	last := m.registered[len(m.registered)-1]
+	return &ecs.DescribeTaskDefinitionOutput{
+		TaskDefinition: &ecs.TaskDefinition{
+			TaskDefinitionArn: aws.String("arn:aws:ecs:us-west-2:123456789012:task-definition/" + aws.StringValue(last.Family) + ":3"),
+			Status:            aws.String(ecs.TaskDefinitionStatusActive),
+		},
+		Tags: last.Tags,
+	}, nil
+}
+
+func (m *mockECS) RunTask(input *ecs.RunTaskInput) (*ecs.RunTaskOutput, error) {
+	m.runs = append(m.runs, input)
+	if m.runErr != nil {
+		return nil, m.runErr
+	}
+	if m.runOutput != nil {
+		return m.runOutput, nil
+	}
+	return &ecs.RunTaskOutput{Tasks: []*ecs.Task{{TaskArn: aws.String(testTaskArn)}}}, nil
+}
+
+func (m *mockECS) ListTasksPages(input *ecs.ListTasksInput, fn func(*ecs.ListTasksOutput, bool) bool) error {
+	m.listed = append(m.listed, input)
+	fn(&ecs.ListTasksOutput{TaskArns: m.tasks[aws.StringValue(input.DesiredStatus)]}, true)
+	return nil
+}
+
+func (m *mockECS) StopTask(input *ecs.StopTaskInput) (*ecs.StopTaskOutput, error) {
+	m.stopped = append(m.stopped, input)
+	return &ecs.StopTaskOutput{}, m.stopErr
+}
+
+func (m *mockECS) DescribeTasks(input *ecs.DescribeTasksInput) (*ecs.DescribeTasksOutput, error) {
+	return m.describeOutput, nil
+}
+
+func (m *mockECS) ListTaskDefinitionsPages(input *ecs.ListTaskDefinitionsInput, fn func(*ecs.ListTaskDefinitionsOutput, bool) bool) error {
+	fn(&ecs.ListTaskDefinitionsOutput{TaskDefinitionArns: m.definitions}, true)
+	return nil
+}
+
+func (m *mockECS) DeregisterTaskDefinition(input *ecs.DeregisterTaskDefinitionInput) (*ecs.DeregisterTaskDefinitionOutput, error) {
+	m.deregistered = append(m.deregistered, aws.StringValue(input.TaskDefinition))
+	return &ecs.DeregisterTaskDefinitionOutput{}, m.deregisterErr
+}
+
+func getTestConfig() map[string]interface{} {
+	configObj := `{
+		"jobName": "main",
+		"jobId": 123,
+		"buildId": 1234,
+		"container": "node:18",
+		"pipelineId": 1898,
+		"token": "abc",
+		"storeUri": "store.uri",
+		"apiUri": "api.uri",
+		"uiUri": "ui.uri",
+		"buildTimeout": 90,
+		"isPR": false,
+		"provider": {
+			"role": "arn:aws:iam::123456789012:role/sd-build",
+			"region": "us-west-2",
+			"clusterName": "sd-builds",
+			"vpc": {
+				"vpcId": "vpc-12345",
+				"securityGroupIds": ["sg-123"],
+				"subnetIds": ["subnet-1111", "subnet-2222"]
+			},
+			"launcherImage": "screwdrivercd/launcher:v6.0.180",
+			"launcherVersion": "v6.0.180"
+		}
+	}`
+	decoder := json.NewDecoder(strings.NewReader(configObj))
+	decoder.UseNumber()
+	var config map[string]interface{}
+	_ = decoder.Decode(&config)
+	return config
+}
+
+func newTestExecutor(client *mockECS) *AwsExecutorECS {
+	return &AwsExecutorECS{name: executorName, serviceClient: &awsAPI{ecs: client}}
+}
+
+func TestGetFamilyName(t *testing.T) {
+	config := getTestConfig()
+	assert.Equal(t, "main-123", getFamilyName(config))
+
+	config["jobName"] = "PR-42:main"
+	config["isPR"] = true
+	assert.Equal(t, "PR-42-main-123", getFamilyName(config))
+
+	config[matrix.ArchitectureKey] = "arm64"
+	assert.Equal(t, "PR-42-main-123-arm64", getFamilyName(config))
+	assert.Equal(t, "sdbuild-1234-arm64", startedBy(config))
+
+	config[policy.ProjectNameKey] = "p1898-main-123"
+	assert.Equal(t, "p1898-main-123", getFamilyName(config))
+}
+
+func TestGetTaskDefinitionInput(t *testing.T) {
+	config := getTestConfig()
+	input, err := getTaskDefinitionInput(config, "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "main-123", aws.StringValue(input.Family))
+	assert.Equal(t, []string{"FARGATE"}, aws.StringValueSlice(input.RequiresCompatibilities))
+	assert.Equal(t, "awsvpc", aws.StringValue(input.NetworkMode))
+	assert.Equal(t, "1024", aws.StringValue(input.Cpu))
+	assert.Equal(t, "2048", aws.StringValue(input.Memory))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/sd-build", aws.StringValue(input.TaskRoleArn))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/sd-build", aws.StringValue(input.ExecutionRoleArn))
+	assert.Equal(t, "X86_64", aws.StringValue(input.RuntimePlatform.CpuArchitecture))
+	assert.Nil(t, input.EphemeralStorage)
+
+	launcherDef, buildDef := input.ContainerDefinitions[0], input.ContainerDefinitions[1]
+	assert.Equal(t, "launcher", aws.StringValue(launcherDef.Name))
+	assert.Equal(t, "screwdrivercd/launcher:v6.0.180", aws.StringValue(launcherDef.Image))
+	assert.False(t, aws.BoolValue(launcherDef.Essential))
+	assert.Equal(t, "build", aws.StringValue(buildDef.Name))
+	assert.Equal(t, "node:18", aws.StringValue(buildDef.Image))
+	// the values of the build are passed by the overrides of the task
+	assert.Equal(t, []string{`/opt/sd/run.sh "$SD_TOKEN" api.uri store.uri "$SD_BUILD_TIMEOUT" "$SD_BUILD_ID" ui.uri`}, aws.StringValueSlice(buildDef.Command))
+	assert.NotContains(t, fmt.Sprintf("%+v", input.ContainerDefinitions), "abc")
+	assert.Equal(t, "launcher", aws.StringValue(buildDef.DependsOn[0].ContainerName))
+	assert.Equal(t, "SUCCESS", aws.StringValue(buildDef.DependsOn[0].Condition))
+	assert.Equal(t, "/opt/sd", aws.StringValue(buildDef.MountPoints[0].ContainerPath))
+	assert.True(t, aws.BoolValue(buildDef.MountPoints[0].ReadOnly))
+	assert.Equal(t, "awslogs", aws.StringValue(buildDef.LogConfiguration.LogDriver))
+	assert.Equal(t, "/aws/ecs/screwdriver", aws.StringValue(buildDef.LogConfiguration.Options["awslogs-group"]))
+	assert.Equal(t, "us-west-2", aws.StringValue(buildDef.LogConfiguration.Options["awslogs-region"]))
+	assert.Contains(t, buildDef.Environment, &ecs.KeyValuePair{Name: aws.String("SD_HAB_ENABLED"), Value: aws.String("false")})
+	assert.Nil(t, buildDef.Secrets)
+	assert.Equal(t, []*ecs.Tag{
+		{Key: aws.String("managed-by"), Value: aws.String("screwdriver")},
+		{Key: aws.String("sd-job-id"), Value: aws.String("123")},
+		{Key: aws.String("sd-pipeline-id"), Value: aws.String("1898")},
+		{Key: aws.String("sd-definition-hash"), Value: input.Tags[3].Value},
+	}, input.Tags)
+
+	// the builds of a job share the definition
+	next := getTestConfig()
+	next["buildId"], next["token"] = json.Number("1235"), "def"
+	nextInput, err := getTaskDefinitionInput(next, "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, input, nextInput)
+
+	// sized arm builds with their own execution role read the token from its secret
+	provider := config["provider"].(map[string]interface{})
+	provider["architecture"] = "aarch64"
+	provider["executionRole"] = "arn:aws:iam::123456789012:role/sd-task-execution"
+	provider["taskCpu"], provider["taskMemory"], provider["taskDisk"] = "4096", "8192", "10"
+	config[buildtoken.ArnKey] = "arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-1234"
+	input, err = getTaskDefinitionInput(config, "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "ARM64", aws.StringValue(input.RuntimePlatform.CpuArchitecture))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/sd-task-execution", aws.StringValue(input.ExecutionRoleArn))
+	assert.Equal(t, "4096", aws.StringValue(input.Cpu))
+	assert.Equal(t, "8192", aws.StringValue(input.Memory))
+	assert.Equal(t, int64(21), aws.Int64Value(input.EphemeralStorage.SizeInGiB))
+	buildDef = input.ContainerDefinitions[1]
+	assert.Equal(t, []*ecs.Secret{{Name: aws.String("SD_TOKEN"), ValueFrom: aws.String("arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-1234")}}, buildDef.Secrets)
+
+	provider["taskDisk"] = "500"
+	_, err = getTaskDefinitionInput(config, "us-west-2")
+	assert.EqualError(t, err, `taskDisk "500" must be a number of GiB up to 200`)
+
+	provider["privilegedMode"] = true
+	_, err = getTaskDefinitionInput(config, "us-west-2")
+	assert.EqualError(t, err, "privilegedMode is not supported by fargate tasks")
+}
+
+func TestGetRunTaskInput(t *testing.T) {
+	config := getTestConfig()
+	input := getRunTaskInput(config, "arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3")
+	assert.Equal(t, "sd-builds", aws.StringValue(input.Cluster))
+	assert.Equal(t, "FARGATE", aws.StringValue(input.LaunchType))
+	assert.Equal(t, "sdbuild-1234", aws.StringValue(input.StartedBy))
+	assert.Equal(t, []string{"subnet-1111", "subnet-2222"}, aws.StringValueSlice(input.NetworkConfiguration.AwsvpcConfiguration.Subnets))
+	assert.Equal(t, []string{"sg-123"}, aws.StringValueSlice(input.NetworkConfiguration.AwsvpcConfiguration.SecurityGroups))
+	assert.Equal(t, "DISABLED", aws.StringValue(input.NetworkConfiguration.AwsvpcConfiguration.AssignPublicIp))
+	assert.Contains(t, input.Tags, &ecs.Tag{Key: aws.String("sdbuild"), Value: aws.String("1234")})
+	override := input.Overrides.ContainerOverrides[0]
+	assert.Equal(t, "build", aws.StringValue(override.Name))
+	assert.Equal(t, []*ecs.KeyValuePair{
+		{Name: aws.String("SD_BUILD_ID"), Value: aws.String("1234")},
+		{Name: aws.String("SD_BUILD_TIMEOUT"), Value: aws.String("90")},
+		{Name: aws.String("SD_TOKEN"), Value: aws.String("abc")},
+	}, override.Environment[:3])
+
+	// builds with a token secret do not pass the token
+	config[buildtoken.ArnKey] = "arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-1234"
+	input = getRunTaskInput(config, "arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3")
+	assert.NotContains(t, input.Overrides.ContainerOverrides[0].Environment, &ecs.KeyValuePair{Name: aws.String("SD_TOKEN"), Value: aws.String("abc")})
+	delete(config, buildtoken.ArnKey)
+
+	provider := config["provider"].(map[string]interface{})
+	delete(provider, "clusterName")
+	provider["assignPublicIp"] = true
+	input = getRunTaskInput(config, "arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3")
+	assert.Nil(t, input.Cluster)
+	assert.Equal(t, "ENABLED", aws.StringValue(input.NetworkConfiguration.AwsvpcConfiguration.AssignPublicIp))
+}
+
+func TestStart(t *testing.T) {
+	client := &mockECS{}
+	executor := newTestExecutor(client)
+	config := getTestConfig()
+	taskArn, err := executor.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, testTaskArn, taskArn)
+	assert.Equal(t, testTaskArn, config[TaskArnKey])
+	assert.Len(t, client.registered, 1)
+	assert.Equal(t, "arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3", aws.StringValue(client.runs[0].TaskDefinition))
+
+	// the next build of the job reuses the revision
+	next := getTestConfig()
+	next["buildId"] = json.Number("1235")
+	_, err = executor.Start(next)
+	assert.Nil(t, err)
+	assert.Len(t, client.registered, 1)
+	assert.Equal(t, "arn:aws:ecs:us-west-2:123456789012:task-definition/main-123:3", aws.StringValue(client.runs[1].TaskDefinition))
+
+	// a changed definition registers a revision
+	next = getTestConfig()
+	next["container"] = "node:20"
+	_, err = executor.Start(next)
+	assert.Nil(t, err)
+	assert.Len(t, client.registered, 2)
+
+	// a redelivered start adopts the running task
+	client = &mockECS{tasks: map[string][]*string{"RUNNING": {aws.String(testTaskArn)}}}
+	executor = newTestExecutor(client)
+	taskArn, err = executor.Start(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, testTaskArn, taskArn)
+	assert.Empty(t, client.registered)
+	assert.Empty(t, client.runs)
+}
+
+func TestStartErrors(t *testing.T) {
+	client := &mockECS{runOutput: &ecs.RunTaskOutput{Failures: []*ecs.Failure{{Reason: aws.String("RESOURCE:ENI")}}}}
+	_, err := newTestExecutor(client).Start(getTestConfig())
+	assert.EqualError(t, err, "Error-RunTask: RESOURCE:ENI")
+	assert.True(t, executorState.IsCapacity(err))
+
+	client = &mockECS{runErr: awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil)}
+	_, err = newTestExecutor(client).Start(getTestConfig())
+	assert.Equal(t, executorState.InfraPermanent, executorState.CategoryOf(err))
+
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["privilegedMode"] = true
+	_, err = newTestExecutor(&mockECS{}).Start(config)
+	assert.Equal(t, executorState.UserError, executorState.CategoryOf(err))
+}
+
+func TestStop(t *testing.T) {
+	client := &mockECS{tasks: map[string][]*string{"RUNNING": {aws.String(testTaskArn)}}}
+	assert.Nil(t, newTestExecutor(client).Stop(getTestConfig()))
+	assert.Equal(t, "sdbuild-1234", aws.StringValue(client.listed[0].StartedBy))
+	assert.Equal(t, testTaskArn, aws.StringValue(client.stopped[0].Task))
+	assert.Equal(t, "sd-builds", aws.StringValue(client.stopped[0].Cluster))
+
+	client = &mockECS{}
+	assert.Nil(t, newTestExecutor(client).Stop(getTestConfig()))
+	assert.Empty(t, client.stopped)
+
+	client = &mockECS{tasks: map[string][]*string{"RUNNING": {aws.String(testTaskArn)}}, stopErr: errors.New("ServerException")}
+	assert.EqualError(t, newTestExecutor(client).Stop(getTestConfig()), "failed to stop tasks "+testTaskArn+": ServerException")
+}
@@ -0,0 +1,58 @@
+package ecs
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ecs"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// categories of the aws error codes of failed starts, other codes are left uncategorized
+var errorCategories = map[string]executorState.Category{
+	ecs.ErrCodeInvalidParameterException:   executorState.UserError,
+	ecs.ErrCodeClientException:             executorState.UserError,
+	ecs.ErrCodePlatformUnknownException:    executorState.UserError,
+	ecs.ErrCodeServerException:             executorState.InfraTransient,
+	ecs.ErrCodeBlockedException:            executorState.InfraPermanent,
+	ecs.ErrCodeClusterNotFoundException:    executorState.InfraPermanent,
+	ecs.ErrCodeAccessDeniedException:       executorState.InfraPermanent,
+	ecs.ErrCodeUnsupportedFeatureException: executorState.InfraPermanent,
+	"ThrottlingException":                  executorState.InfraTransient,
+	request.ErrCodeRequestError:            executorState.InfraTransient,
+}
+
+// gets the executor category of a failed aws api call
+func errorCategory(err error) executorState.Category {
+	if aerr, ok := err.(awserr.Error); ok {
+		return errorCategories[aerr.Code()]
+	}
+	return ""
+}
+
+// gets the error of a run task call which started no task. Fargate reports exhausted capacity as RESOURCE:* reasons,
+// which pass, other failures like a missing cluster need the admins.
+func runTaskFailure(failures []*ecs.Failure) error {
+	if len(failures) == 0 {
+		return executorState.Errorf(executorState.InfraTransient, "Error-RunTask: no task started")
+	}
+	var reasons []string
+	capacity := false
+	for _, failure := range failures {
+		reason := aws.StringValue(failure.Reason)
+		if strings.HasPrefix(reason, "RESOURCE:") {
+			capacity = true
+		}
+		if detail := aws.StringValue(failure.Detail); detail != "" {
+			reason += " (" + detail + ")"
+		}
+		reasons = append(reasons, reason)
+	}
+	if capacity {
+		return executorState.CapacityErrorf("Error-RunTask: %v", strings.Join(reasons, ", "))
+	}
+	return executorState.Errorf(executorState.InfraPermanent, "Error-RunTask: %v", strings.Join(reasons, ", "))
+}
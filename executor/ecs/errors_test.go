@@ -0,0 +1,33 @@
+package ecs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestErrorCategory(t *testing.T) {
+	assert.Equal(t, executorState.UserError, errorCategory(awserr.New(ecs.ErrCodeInvalidParameterException, "Invalid cpu", nil)))
+	assert.Equal(t, executorState.InfraTransient, errorCategory(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.Equal(t, executorState.InfraPermanent, errorCategory(awserr.New(ecs.ErrCodeClusterNotFoundException, "Cluster not found.", nil)))
+	assert.Equal(t, executorState.Category(""), errorCategory(errors.New("unknown")))
+}
+
+func TestRunTaskFailure(t *testing.T) {
+	err := runTaskFailure(nil)
+	assert.EqualError(t, err, "Error-RunTask: no task started")
+	assert.Equal(t, executorState.InfraTransient, executorState.CategoryOf(err))
+
+	err = runTaskFailure([]*ecs.Failure{{Reason: aws.String("MISSING"), Detail: aws.String("cluster sd-builds")}})
+	assert.EqualError(t, err, "Error-RunTask: MISSING (cluster sd-builds)")
+	assert.Equal(t, executorState.InfraPermanent, executorState.CategoryOf(err))
+
+	err = runTaskFailure([]*ecs.Failure{{Reason: aws.String("RESOURCE:MEMORY")}})
+	assert.True(t, executorState.IsCapacity(err))
+}
@@ -0,0 +1,65 @@
+package ecs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// maximum size of the logs collected for a screwdriver build
+const maxLogBytes = 1 << 20
+
+// gets the awslogs stream of a container of the task, <prefix>/<container>/<task id>
+func logStreamName(taskArn string, container string) string {
+	taskID := taskArn[strings.LastIndex(taskArn, "/")+1:]
+	return logStreamPrefix + "/" + container + "/" + taskID
+}
+
+// writes the cloudwatch log events of a container since the given time, up to maxLogBytes in total
+func writeContainerLogs(serviceClient *awsAPI, streamName string, since time.Time, buf *bytes.Buffer) error {
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(logGroup()),
+		LogStreamName: aws.String(streamName),
+		StartFromHead: aws.Bool(true),
+		StartTime:     aws.Int64(since.UnixNano() / int64(time.Millisecond)),
+	}
+	for {
+		eventsResult, err := serviceClient.logs.GetLogEvents(input)
+		if err != nil {
+			return fmt.Errorf("Error-GetLogEvents: %v", err)
+		}
+		for _, event := range eventsResult.Events {
+			message := aws.StringValue(event.Message) + "\n"
+			if buf.Len()+len(message) > maxLogBytes {
+				return fmt.Errorf("logs truncated at %d bytes", maxLogBytes)
+			}
+			buf.WriteString(message)
+		}
+		// the same forward token is returned at the end of the stream
+		if len(eventsResult.Events) == 0 || aws.StringValue(eventsResult.NextForwardToken) == aws.StringValue(input.NextToken) {
+			return nil
+		}
+		input.NextToken = eventsResult.NextForwardToken
+	}
+}
+
+// Logs gets the cloudwatch logs of the launcher and build containers of the task since the given time
+func (e *AwsExecutorECS) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	task, err := e.getTask(config)
+	if err != nil {
+		return nil, err
+	}
+	taskArn := aws.StringValue(task.TaskArn)
+	buf := new(bytes.Buffer)
+	for _, container := range []string{launcherContainer, buildContainer} {
+		fmt.Fprintf(buf, "==> ecs container %s of task %s (%s) <==\n", container, taskArn, aws.StringValue(task.LastStatus))
+		if err := writeContainerLogs(e.serviceClient, logStreamName(taskArn, container), since, buf); err != nil {
+			fmt.Fprintf(buf, "%v\n", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
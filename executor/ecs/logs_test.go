@@ -0,0 +1,56 @@
+package ecs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	inputs []cloudwatchlogs.GetLogEventsInput
+}
+
+func (m *mockLogs) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	m.inputs = append(m.inputs, *input)
+	if aws.StringValue(input.LogStreamName) == "sd/launcher/0123456789abcdef" {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	if input.NextToken != nil {
+		return &cloudwatchlogs.GetLogEventsOutput{NextForwardToken: input.NextToken}, nil
+	}
+	return &cloudwatchlogs.GetLogEventsOutput{
+		Events:           []*cloudwatchlogs.OutputLogEvent{{Message: aws.String("step install")}, {Message: aws.String("npm ci")}},
+		NextForwardToken: aws.String("f/1"),
+	}, nil
+}
+
+func TestLogStreamName(t *testing.T) {
+	assert.Equal(t, "sd/build/0123456789abcdef", logStreamName(testTaskArn, "build"))
+}
+
+func TestLogs(t *testing.T) {
+	logs := &mockLogs{}
+	e := &AwsExecutorECS{serviceClient: &awsAPI{
+		ecs:  &mockECS{describeOutput: &ecs.DescribeTasksOutput{Tasks: []*ecs.Task{{TaskArn: aws.String(testTaskArn), LastStatus: aws.String("STOPPED")}}}},
+		logs: logs,
+	}}
+	config := getTestConfig()
+	config[TaskArnKey] = testTaskArn
+	since := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	output, err := e.Logs(config, since)
+	assert.Nil(t, err)
+	assert.Equal(t, "==> ecs container launcher of task "+testTaskArn+" (STOPPED) <==\n"+
+		"Error-GetLogEvents: ResourceNotFoundException\n"+
+		"==> ecs container build of task "+testTaskArn+" (STOPPED) <==\n"+
+		"step install\nnpm ci\n", string(output))
+	assert.Equal(t, "/aws/ecs/screwdriver", aws.StringValue(logs.inputs[1].LogGroupName))
+	assert.Equal(t, since.UnixNano()/int64(time.Millisecond), aws.Int64Value(logs.inputs[1].StartTime))
+	assert.Equal(t, "f/1", aws.StringValue(logs.inputs[2].NextToken))
+}
@@ -0,0 +1,93 @@
+package ecs
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// task states before the build container runs
+var queuedStatuses = map[string]bool{
+	"PROVISIONING": true,
+	"PENDING":      true,
+	"ACTIVATING":   true,
+}
+
+// gets the container of the task
+func taskContainer(task *ecs.Task, name string) *ecs.Container {
+	for _, container := range task.Containers {
+		if aws.StringValue(container.Name) == name {
+			return container
+		}
+	}
+	return nil
+}
+
+// normalizes the status of a task, the build succeeded when the build container exited with 0
+func taskStatus(task *ecs.Task) executor.Status {
+	lastStatus := aws.StringValue(task.LastStatus)
+	build := taskContainer(task, buildContainer)
+	if build != nil && build.ExitCode != nil {
+		if aws.Int64Value(build.ExitCode) == 0 {
+			return executor.Status{State: executor.Succeeded}
+		}
+		return executor.Status{State: executor.Failed, Reason: fmt.Sprintf("build container exited with %d", aws.Int64Value(build.ExitCode))}
+	}
+	if lastStatus == ecs.DesiredStatusStopped {
+		reason := aws.StringValue(task.StoppedReason)
+		if build != nil && aws.StringValue(build.Reason) != "" {
+			reason = aws.StringValue(build.Reason)
+		}
+		if launcher := taskContainer(task, launcherContainer); launcher != nil && aws.Int64Value(launcher.ExitCode) != 0 {
+			reason = fmt.Sprintf("launcher container exited with %d", aws.Int64Value(launcher.ExitCode))
+		}
+		return executor.Status{State: executor.Failed, Reason: reason}
+	}
+	if queuedStatuses[lastStatus] {
+		return executor.Status{State: executor.Queued, Reason: lastStatus}
+	}
+	return executor.Status{State: executor.Running}
+}
+
+// gets the task of the build, the latest running or stopped one when the build config has no task arn
+func (e *AwsExecutorECS) getTask(config map[string]interface{}) (*ecs.Task, error) {
+	taskArn, _ := config[TaskArnKey].(string)
+	if taskArn == "" {
+		for _, desiredStatus := range []string{ecs.DesiredStatusRunning, ecs.DesiredStatusStopped} {
+			arns, err := e.listTasks(config, desiredStatus)
+			if err != nil {
+				return nil, err
+			}
+			if len(arns) > 0 {
+				taskArn = aws.StringValue(arns[0])
+				break
+			}
+		}
+	}
+	if taskArn == "" {
+		return nil, fmt.Errorf("no task found for build %v", config["buildId"])
+	}
+	result, err := e.serviceClient.ecs.DescribeTasks(&ecs.DescribeTasksInput{
+		Cluster: cluster(config),
+		Tasks:   []*string{aws.String(taskArn)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-DescribeTasks: %v", err)
+	}
+	if len(result.Tasks) == 0 {
+		return nil, fmt.Errorf("task %v not found", taskArn)
+	}
+	return result.Tasks[0], nil
+}
+
+// Status gets the normalized state of the fargate task running the screwdriver build
+func (e *AwsExecutorECS) Status(config map[string]interface{}) (executor.Status, error) {
+	task, err := e.getTask(config)
+	if err != nil {
+		return executor.Status{}, err
+	}
+	return taskStatus(task), nil
+}
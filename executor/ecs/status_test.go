@@ -0,0 +1,67 @@
+package ecs
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestTaskStatus(t *testing.T) {
+	for name, test := range map[string]struct {
+		task     *ecs.Task
+		expected executor.Status
+	}{
+		"provisioning": {
+			task:     &ecs.Task{LastStatus: aws.String("PROVISIONING")},
+			expected: executor.Status{State: executor.Queued, Reason: "PROVISIONING"},
+		},
+		"running": {
+			task:     &ecs.Task{LastStatus: aws.String("RUNNING"), Containers: []*ecs.Container{{Name: aws.String("build")}}},
+			expected: executor.Status{State: executor.Running},
+		},
+		"succeeded": {
+			task:     &ecs.Task{LastStatus: aws.String("DEPROVISIONING"), Containers: []*ecs.Container{{Name: aws.String("build"), ExitCode: aws.Int64(0)}}},
+			expected: executor.Status{State: executor.Succeeded},
+		},
+		"failed": {
+			task:     &ecs.Task{LastStatus: aws.String("STOPPED"), Containers: []*ecs.Container{{Name: aws.String("build"), ExitCode: aws.Int64(2)}}},
+			expected: executor.Status{State: executor.Failed, Reason: "build container exited with 2"},
+		},
+		"launcher failed": {
+			task: &ecs.Task{LastStatus: aws.String("STOPPED"), StoppedReason: aws.String("Essential container in task exited"), Containers: []*ecs.Container{
+				{Name: aws.String("launcher"), ExitCode: aws.Int64(1)},
+				{Name: aws.String("build")},
+			}},
+			expected: executor.Status{State: executor.Failed, Reason: "launcher container exited with 1"},
+		},
+		"image pull failed": {
+			task: &ecs.Task{LastStatus: aws.String("STOPPED"), StoppedReason: aws.String("Task stopped"), Containers: []*ecs.Container{
+				{Name: aws.String("launcher"), ExitCode: aws.Int64(0)},
+				{Name: aws.String("build"), Reason: aws.String("CannotPullContainerError: pull access denied")},
+			}},
+			expected: executor.Status{State: executor.Failed, Reason: "CannotPullContainerError: pull access denied"},
+		},
+	} {
+		assert.Equal(t, test.expected, taskStatus(test.task), name)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	// the task is found by the build when the config has no task arn
+	client := &mockECS{
+		tasks:          map[string][]*string{"STOPPED": {aws.String(testTaskArn)}},
+		describeOutput: &ecs.DescribeTasksOutput{Tasks: []*ecs.Task{{TaskArn: aws.String(testTaskArn), LastStatus: aws.String("RUNNING")}}},
+	}
+	status, err := newTestExecutor(client).Status(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Status{State: executor.Running}, status)
+	assert.Len(t, client.listed, 2)
+
+	client = &mockECS{}
+	_, err = newTestExecutor(client).Status(getTestConfig())
+	assert.EqualError(t, err, "no task found for build 1234")
+}
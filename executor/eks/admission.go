@@ -0,0 +1,32 @@
+package eks
+
+import (
+	"errors"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// parts of api server messages of pods rejected by admission webhooks or pod security admission,
+// rbac denials of the consumer are forbidden too but never carry them
+var admissionMarkers = []string{"admission webhook", "violates PodSecurity", "denied the request"}
+
+// gets an admission error for a pod creation rejected by an admission policy of the cluster, nil for other errors
+func admissionError(err error) error {
+	if !k8serrors.IsForbidden(err) && !k8serrors.IsInvalid(err) {
+		return nil
+	}
+	message := err.Error()
+	var status k8serrors.APIStatus
+	if errors.As(err, &status) && status.Status().Message != "" {
+		message = status.Status().Message
+	}
+	for _, marker := range admissionMarkers {
+		if strings.Contains(message, marker) {
+			return executor.AdmissionErrorf("the build pod was rejected by a policy of the cluster, not by Screwdriver: %s", message)
+		}
+	}
+	return nil
+}
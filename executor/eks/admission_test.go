@@ -0,0 +1,52 @@
+package eks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+var podsResource = schema.GroupResource{Resource: "pods"}
+
+func TestAdmissionError(t *testing.T) {
+	webhook := k8serrors.NewForbidden(podsResource, "1234-abcde", errors.New(`admission webhook "validation.gatekeeper.sh" denied the request: [no-host-path] hostPath volumes are not allowed`))
+	err := admissionError(webhook)
+	assert.True(t, executor.IsAdmission(err))
+	assert.EqualError(t, err, `the build pod was rejected by a policy of the cluster, not by Screwdriver: pods "1234-abcde" is forbidden: admission webhook "validation.gatekeeper.sh" denied the request: [no-host-path] hostPath volumes are not allowed`)
+
+	podSecurity := k8serrors.NewForbidden(podsResource, "1234-abcde", errors.New(`violates PodSecurity "restricted:latest": hostPath volumes (volumes "screwdriver", "sdtemp")`))
+	assert.True(t, executor.IsAdmission(admissionError(podSecurity)))
+
+	invalid := k8serrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, "1234-abcde", nil)
+	invalid.ErrStatus.Message = `admission webhook "mutate.kyverno.svc" denied the request: image registry not allowed`
+	assert.True(t, executor.IsAdmission(admissionError(invalid)))
+
+	// rbac denials and other api errors are not admission errors
+	assert.Nil(t, admissionError(k8serrors.NewForbidden(podsResource, "", errors.New(`User "sd-consumer" cannot create resource "pods" in the namespace "sd-builds"`))))
+	assert.Nil(t, admissionError(k8serrors.NewServerTimeout(podsResource, "create", 1)))
+	assert.Nil(t, admissionError(errors.New("admission webhook denied the request")))
+}
+
+func TestStartRejectedByAdmission(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	kubeclient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &core.Pod{}, k8serrors.NewForbidden(podsResource, "1234-abcde", errors.New(`violates PodSecurity "baseline:latest": privileged (container "1234" must not set securityContext.privileged=true)`))
+	})
+	eksExecutor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	_, err := eksExecutor.Start(getTestConfig())
+	assert.EqualError(t, err, `Error creating pod the build pod was rejected by a policy of the cluster, not by Screwdriver: pods "1234-abcde" is forbidden: violates PodSecurity "baseline:latest": privileged (container "1234" must not set securityContext.privileged=true)`)
+	assert.True(t, executor.IsAdmission(err))
+}
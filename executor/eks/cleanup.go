@@ -0,0 +1,68 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Cleanup removes the leftover jobs, pods and cache volumes of an archived job, and its namespace when the job owns it
+func (e *AwsExecutorEKS) Cleanup(config map[string]interface{}) error {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	jobID, _ := config["jobId"].(json.Number).Int64()
+	jobIDStr := fmt.Sprint(jobID)
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf("sdjob=%v", jobIDStr)}
+	coreClient := clientset.client.CoreV1()
+	// jobs go first, they would replace their deleted pods
+	failures := deleteJobs(clientset, namespace, selector)
+
+	podsClient := coreClient.Pods(namespace)
+	if pods, err := podsClient.List(context.TODO(), selector); err != nil {
+		failures = append(failures, fmt.Sprintf("pods: %v", err))
+	} else {
+		for _, pod := range pods.Items {
+			if err := podsClient.Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+				failures = append(failures, fmt.Sprintf("pod %s: %v", pod.Name, err))
+			}
+		}
+	}
+	claimsClient := coreClient.PersistentVolumeClaims(namespace)
+	if claims, err := claimsClient.List(context.TODO(), selector); err != nil {
+		failures = append(failures, fmt.Sprintf("persistent volume claims: %v", err))
+	} else {
+		for _, claim := range claims.Items {
+			if err := claimsClient.Delete(context.TODO(), claim.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+				failures = append(failures, fmt.Sprintf("persistent volume claim %s: %v", claim.Name, err))
+			}
+		}
+	}
+
+	// shared build namespaces are kept, only a namespace labeled with the job is removed
+	ns, err := coreClient.Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+	case err != nil:
+		failures = append(failures, fmt.Sprintf("namespace: %v", err))
+	case ns.Labels["sdjob"] == jobIDStr:
+		if err := coreClient.Namespaces().Delete(context.TODO(), namespace, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			failures = append(failures, fmt.Sprintf("namespace: %v", err))
+		} else {
+			log.Printf("Deleted namespace %s", namespace)
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to clean up job %d: %s", jobID, strings.Join(failures, ", "))
+	}
+	return nil
+}
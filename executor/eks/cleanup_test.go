@@ -0,0 +1,40 @@
+package eks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCleanup(t *testing.T) {
+	pod := buildPod(core.PodSucceeded, core.ContainerState{})
+	pod.Labels["sdjob"] = "123"
+	otherPod := buildPod(core.PodRunning, core.ContainerState{})
+	otherPod.Name = "1235-abcde"
+	otherPod.Labels["sdjob"] = "124"
+	cache := &core.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "cache-123", Namespace: testNamespace, Labels: map[string]string{"sdjob": "123"}}}
+	shared := &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace}}
+
+	client := fake.NewSimpleClientset(pod, otherPod, cache, shared)
+	e := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: client}}
+	assert.Nil(t, e.Cleanup(getTestConfig()))
+
+	pods, _ := client.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Equal(t, 1, len(pods.Items))
+	assert.Equal(t, "1235-abcde", pods.Items[0].Name)
+	claims, _ := client.CoreV1().PersistentVolumeClaims(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Empty(t, claims.Items)
+	_, err := client.CoreV1().Namespaces().Get(context.TODO(), testNamespace, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	owned := &core.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Labels: map[string]string{"sdjob": "123"}}}
+	client = fake.NewSimpleClientset(owned)
+	e = &AwsExecutorEKS{k8sClientset: &k8sClientset{client: client}}
+	assert.Nil(t, e.Cleanup(getTestConfig()))
+	namespaces, _ := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	assert.Empty(t, namespaces.Items)
+}
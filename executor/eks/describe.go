@@ -0,0 +1,49 @@
+package eks
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+// Describe reports the eks clusters of the region and whether the build cluster api server is reachable
+func (e *AwsExecutorEKS) Describe(config map[string]interface{}) (map[string]interface{}, error) {
+	var clusters []string
+	err := e.eksClient.service.ListClustersPages(&eks.ListClustersInput{}, func(page *eks.ListClustersOutput, lastPage bool) bool {
+		clusters = append(clusters, aws.StringValueSlice(page.Clusters)...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Got error listing clusters: %v", err)
+	}
+	capabilities := map[string]interface{}{"clusters": clusters}
+
+	provider := config["provider"].(map[string]interface{})
+	clusterName, _ := provider["clusterName"].(string)
+	if clusterName == "" {
+		clusterName, _ = config["clusterName"].(string)
+	}
+	if clusterName == "" {
+		return capabilities, nil
+	}
+	if config["clusterName"] == nil {
+		config["clusterName"] = clusterName
+	}
+	capabilities["cluster"] = clusterName
+	capabilities["clusterReachable"] = false
+
+	clientset, err := e.newClientSet(config)
+	if err == nil {
+		var version fmt.Stringer
+		if version, err = clientset.client.Discovery().ServerVersion(); err == nil {
+			capabilities["clusterReachable"] = true
+			capabilities["kubernetesVersion"] = version.String()
+		}
+	}
+	if err != nil {
+		capabilities["clusterError"] = err.Error()
+	}
+
+	return capabilities, nil
+}
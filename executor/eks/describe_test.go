@@ -0,0 +1,48 @@
+package eks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/stretchr/testify/assert"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDescribe(t *testing.T) {
+	mockEKSClient := new(mockEKS)
+	mockEKSClient.On("ListClustersPages", &eks.ListClustersInput{}).Return([]*eks.ListClustersOutput{
+		{Clusters: aws.StringSlice([]string{"sd-build-1"})},
+		{Clusters: aws.StringSlice([]string{"sd-build-2"})},
+	}, nil)
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{service: mockEKSClient},
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset()},
+	}
+
+	got, err := executor.Describe(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sd-build-1", "sd-build-2"}, got["clusters"])
+	assert.Equal(t, "test-cluster-1", got["cluster"])
+	assert.Equal(t, true, got["clusterReachable"])
+	assert.Contains(t, got, "kubernetesVersion")
+}
+
+func TestDescribeUnreachableCluster(t *testing.T) {
+	mockEKSClient := new(mockEKS)
+	mockEKSClient.On("ListClustersPages", &eks.ListClustersInput{}).Return([]*eks.ListClustersOutput{{}}, nil)
+	mockEKSClient.On("DescribeCluster", &eks.DescribeClusterInput{Name: aws.String("test-cluster-1")}).Return(&eks.DescribeClusterOutput{}, errors.New("ResourceNotFoundException"))
+	executor := &AwsExecutorEKS{eksClient: &eksClient{service: mockEKSClient}}
+
+	got, err := executor.Describe(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, false, got["clusterReachable"])
+	assert.Equal(t, "Error calling DescribeCluster:ResourceNotFoundException", got["clusterError"])
+
+	failing := new(mockEKS)
+	failing.On("ListClustersPages", &eks.ListClustersInput{}).Return([]*eks.ListClustersOutput{}, errors.New("AccessDeniedException"))
+	executor = &AwsExecutorEKS{eksClient: &eksClient{service: failing}}
+	_, err = executor.Describe(getTestConfig())
+	assert.EqualError(t, err, "Got error listing clusters: AccessDeniedException")
+}
@@ -0,0 +1,50 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// adopts the pod or job of an earlier delivery of the start of the build, so a start delivered twice runs a single
+// build. Returns the node of an adopted pod and false when the build has none or they can't be listed.
+func adoptExisting(clientset *k8sClientset, namespace string, config map[string]interface{}) (string, bool) {
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	selector := metav1.ListOptions{LabelSelector: buildSelector(config)}
+
+	pods, err := clientset.client.CoreV1().Pods(namespace).List(context.TODO(), selector)
+	if err != nil {
+		log.Printf("Error looking up existing pods of build %v, starting it: %v", buildID, err)
+		return "", false
+	}
+	for _, pod := range pods.Items {
+		// pods of a stopped build are being deleted
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		log.Printf("Build %v already has pod %v (%v), adopting it instead of creating another", buildID, pod.Name, pod.Status.Phase)
+		config["podName"] = pod.Name
+		if jobName := pod.Labels["job-name"]; jobName != "" {
+			config["k8sJobName"] = jobName
+		}
+		return pod.Spec.NodeName, true
+	}
+
+	// the pod of a job is created once the job controller syncs it
+	jobs, err := clientset.client.BatchV1().Jobs(namespace).List(context.TODO(), selector)
+	if err != nil {
+		log.Printf("Error looking up existing jobs of build %v, starting it: %v", buildID, err)
+		return "", false
+	}
+	for _, job := range jobs.Items {
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+		log.Printf("Build %v already has job %v, adopting it instead of creating another", buildID, job.Name)
+		config["k8sJobName"] = job.Name
+		return "", true
+	}
+	return "", false
+}
@@ -0,0 +1,58 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStartAdoptsExistingPod(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset(&core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "1234-erbf3", Namespace: testNamespace, Labels: map[string]string{"sdbuild": "1234"}},
+		Spec:       core.PodSpec{NodeName: "ip-12-3-4.example.com"},
+	})
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: kubeclient}}
+
+	config := getTestConfig()
+	nodeName, err := executor.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "ip-12-3-4.example.com", nodeName)
+	assert.Equal(t, "1234-erbf3", config["podName"])
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Equal(t, 1, len(pods.Items))
+}
+
+func TestAdoptExisting(t *testing.T) {
+	now := metav1.Now()
+	clientset := &k8sClientset{client: fake.NewSimpleClientset(
+		&core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "1234-stopped", Namespace: testNamespace, Labels: map[string]string{"sdbuild": "1234"}, DeletionTimestamp: &now}},
+		&core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "1235-x8f2k", Namespace: testNamespace, Labels: map[string]string{"sdbuild": "1235", "job-name": "1235-x8f2k"}}},
+		&batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "1236-u2k4j", Namespace: testNamespace, Labels: map[string]string{"sdbuild": "1236"}}},
+	)}
+
+	// pods being deleted by a stop are not adopted
+	_, ok := adoptExisting(clientset, testNamespace, getTestConfig())
+	assert.False(t, ok)
+
+	config := getTestConfig()
+	config["buildId"] = json.Number("1235")
+	_, ok = adoptExisting(clientset, testNamespace, config)
+	assert.True(t, ok)
+	assert.Equal(t, "1235-x8f2k", config["podName"])
+	assert.Equal(t, "1235-x8f2k", config["k8sJobName"])
+
+	// jobs adopted before their pod is created
+	config = getTestConfig()
+	config["buildId"] = json.Number("1236")
+	nodeName, ok := adoptExisting(clientset, testNamespace, config)
+	assert.True(t, ok)
+	assert.Equal(t, "", nodeName)
+	assert.Equal(t, "1236-u2k4j", config["k8sJobName"])
+	assert.Nil(t, config["podName"])
+}
@@ -7,40 +7,72 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"golang.org/x/sync/errgroup"
 	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	resource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rand "k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/fault"
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/subnet"
+
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
 )
 
 const (
 	executorName = "eks"
+	// how long the clientset of a cluster is reused, eks tokens are valid for 15 minutes
+	clientsetTTL = 10 * time.Minute
 )
 
 // eks client definition struct
 type eksClient struct {
-	service eksiface.EKSAPI
+	service    eksiface.EKSAPI
+	ec2        ec2iface.EC2API
+	cloudwatch cloudwatchiface.CloudWatchAPI
+	sess       *session.Session
 }
 
 // k8s clientset definition struct
 type k8sClientset struct {
-	client kubernetes.Interface
+	client  kubernetes.Interface
+	expires time.Time
+	// arn of the cluster
+	arn string
 }
 
-// AwsExecutorEKS definition struct
+// AwsExecutorEKS definition struct, executors are shared by the builds of a region
 type AwsExecutorEKS struct {
-	name         string
-	eksClient    *eksClient
+	name      string
+	eksClient *eksClient
+	// clientset used for all clusters when set
 	k8sClientset *k8sClientset
+	mu           sync.Mutex
+	clientsets   map[string]*k8sClientset
 }
 
 // describes an eks cluster
@@ -63,19 +95,26 @@ func (c *eksClient) describeCluster(clusterName string) (*eks.DescribeClusterOut
 	return clusterInfo, err
 }
 
-// newAWSService returns a new instance of eks
-func newEKSService(region string) *eksClient {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region)},
-	)
+// checks if the error reports a missing eks cluster
+func isClusterNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == eks.ErrCodeResourceNotFoundException
+}
+
+// newAWSService returns a new instance of eks, failing when the aws session can't be created
+func newEKSService(region string) (*eksClient, error) {
+	sess, err := awsconfig.NewSession(region)
 	if err != nil {
-		log.Printf("error while creating AWS session - %s", err.Error())
+		return nil, err
 	}
 	svcEks := eks.New(sess)
 
 	return &eksClient{
-		service: svcEks,
-	}
+		service:    svcEks,
+		ec2:        ec2.New(sess),
+		cloudwatch: cloudwatch.New(sess),
+		sess:       sess,
+	}, nil
 }
 
 // gets the token for creating clientset
@@ -87,6 +126,10 @@ func (e *AwsExecutorEKS) getToken(clusterName *string) (string, error) {
 	opts := &token.GetTokenOptions{
 		ClusterID: aws.StringValue(clusterName),
 	}
+	// sign with the executor session so the partition, FIPS and sts endpoint overrides apply
+	if e.eksClient != nil && e.eksClient.sess != nil {
+		opts.Session = e.eksClient.sess
+	}
 	tok, err := gen.GetWithOptions(opts)
 	if err != nil {
 		return "", err
@@ -94,15 +137,31 @@ func (e *AwsExecutorEKS) getToken(clusterName *string) (string, error) {
 	return tok.Token, nil
 }
 
-// Returns a new client set for kubernetes
+// injects the faults of SD_FAULTS into the calls of the clientset of the config
+func injectFaults(config *rest.Config) {
+	if injector := fault.FromEnv(); injector != nil {
+		config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return injector.WrapTransport(fault.Kubernetes, rt)
+		})
+	}
+}
+
+// Returns the client set for the kubernetes cluster of the build, reusing it until the token expires
 func (e *AwsExecutorEKS) newClientSet(config map[string]interface{}) (*k8sClientset, error) {
 	if e.k8sClientset != nil {
 		return e.k8sClientset, nil
 	}
+	clusterName := config["clusterName"].(string)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cached, ok := e.clientsets[clusterName]; ok && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+
 	//connect to cluster
-	clusterInfo, err := e.eksClient.describeCluster(config["clusterName"].(string))
+	clusterInfo, err := e.eksClient.describeCluster(clusterName)
 	if err != nil {
-		return nil, fmt.Errorf("Error calling DescribeCluster:%v", err)
+		return nil, fmt.Errorf("Error calling DescribeCluster:%w", err)
 	}
 	certificate := clusterInfo.Cluster.CertificateAuthority.Data
 	endpoint := clusterInfo.Cluster.Endpoint
@@ -111,27 +170,45 @@ func (e *AwsExecutorEKS) newClientSet(config map[string]interface{}) (*k8sClient
 
 	//get token
 	token, err := e.getToken(clusterInfo.Cluster.Name)
+	if err != nil {
+		return nil, executor.Errorf(executor.InfraTransient, "Error getting token: %w", err)
+	}
 	ca, err := base64.StdEncoding.DecodeString(aws.StringValue(certificate))
 	if err != nil {
 		return nil, err
 	}
-	clientset, err := kubernetes.NewForConfig(
-		&rest.Config{
-			Host:        aws.StringValue(endpoint),
-			BearerToken: token,
-			TLSClientConfig: rest.TLSClientConfig{
-				CAData: ca,
-			},
+	restConfig := &rest.Config{
+		Host:        aws.StringValue(endpoint),
+		BearerToken: token,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: ca,
 		},
-	)
+	}
+	injectFaults(restConfig)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating clientset: %v", err)
 	}
-	e.k8sClientset = &k8sClientset{
-		client: clientset,
+	cached := &k8sClientset{
+		client:  clientset,
+		expires: time.Now().Add(clientsetTTL),
+		arn:     aws.StringValue(arn),
 	}
+	if e.clientsets == nil {
+		e.clientsets = map[string]*k8sClientset{}
+	}
+	e.clientsets[clusterName] = cached
 
-	return e.k8sClientset, nil
+	return cached, nil
+}
+
+// gets the label selector of the pods of the build, the builds of a multi-architecture build only select their own
+func buildSelector(config map[string]interface{}) string {
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	if arch := matrix.Architecture(config); arch != "" {
+		return fmt.Sprintf("sdbuild=%v,sdarch=%v", buildID, arch)
+	}
+	return fmt.Sprintf("sdbuild=%v", buildID)
 }
 
 // gets the pod object for creating pod
@@ -140,17 +217,57 @@ func getPodObject(config map[string]interface{}, namespace string) *core.Pod {
 	buildID, _ := config["buildId"].(json.Number).Int64()
 	buildIDStr := fmt.Sprint(buildID)
 	podName := buildIDStr + "-" + rand.String(5)
+	if prefix, _ := config[policy.PodNamePrefixKey].(string); prefix != "" {
+		podName = prefix + "-" + podName
+	}
 	pipelineID, _ := config["pipelineId"].(json.Number).Int64()
+	jobID, _ := config["jobId"].(json.Number).Int64()
 	buildTimeout, _ := config["buildTimeout"].(json.Number).Int64()
+	// flags are validated when the build is started
+	flags, _ := launcher.GetFlags(provider, executorName)
+
+	env := []core.EnvVar{
+		{Name: "SD_RUNTIME_CLASS", Value: ""},
+		{Name: "SD_PUSHGATEWAY_URL", Value: ""},
+		{Name: "SD_TERMINATION_GRACE_PERIOD_SECONDS", Value: "60"}, //string(core.DefaultTerminationGracePeriodSeconds)
+		{Name: "CONTAINER_IMAGE", Value: config["container"].(string)},
+		{Name: "SD_PIPELINE_ID", Value: fmt.Sprint(pipelineID)},
+		{Name: "SD_BUILD_PREFIX", Value: ""},
+		{Name: "NODE_ID", ValueFrom: &core.EnvVarSource{FieldRef: &core.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
+		{Name: "SD_TEMP", Value: "/opt/sd_tmp"},
+		{Name: "DOCKER_HOST", Value: "tcp"},
+	}
+	for _, v := range flags.Env() {
+		env = append(env, core.EnvVar{Name: v.Name, Value: v.Value})
+	}
+	for _, v := range policy.Environment(config) {
+		env = append(env, core.EnvVar{Name: v.Name, Value: v.Value})
+	}
+
+	limits := map[core.ResourceName]resource.Quantity{
+		core.ResourceCPU:    resource.MustParse(provider["cpuLimit"].(string)),
+		core.ResourceMemory: resource.MustParse(provider["memoryLimit"].(string)),
+	}
+	if diskLimit, _ := provider["diskLimit"].(string); diskLimit != "" {
+		limits[core.ResourceEphemeralStorage] = resource.MustParse(diskLimit)
+	}
+	// builds may be placed by a custom or gang scheduler, the default scheduler when empty
+	schedulerName, _ := provider["schedulerName"].(string)
+
+	labels := map[string]string{"app": "screwdriver", "tier": "builds", "sdbuild": buildIDStr, "sdjob": fmt.Sprint(jobID), "sdpipeline": fmt.Sprint(pipelineID)}
+	if arch := matrix.Architecture(config); arch != "" {
+		labels["sdarch"] = arch
+	}
 
 	return &core.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      podName,
 			Namespace: namespace,
-			Labels:    map[string]string{"app": "screwdriver", "tier": "builds", "sdbuild": buildIDStr},
+			Labels:    labels,
 		},
 		Spec: core.PodSpec{
 			ServiceAccountName:            config["serviceAccountName"].(string),
+			SchedulerName:                 schedulerName,
 			AutomountServiceAccountToken:  &[]bool{true}[0],
 			TerminationGracePeriodSeconds: &[]int64{core.DefaultTerminationGracePeriodSeconds}[0],
 			RestartPolicy:                 core.RestartPolicyNever,
@@ -171,24 +288,9 @@ func getPodObject(config map[string]interface{}, namespace string) *core.Pod {
 						Privileged: &[]bool{provider["privilegedMode"].(bool)}[0],
 					},
 					Resources: core.ResourceRequirements{
-						Limits: map[core.ResourceName]resource.Quantity{
-							core.ResourceCPU:    resource.MustParse(provider["cpuLimit"].(string)),
-							core.ResourceMemory: resource.MustParse(provider["memoryLimit"].(string)),
-						},
-					},
-					Env: []core.EnvVar{
-						{Name: "SD_RUNTIME_CLASS", Value: ""},
-						{Name: "SD_PUSHGATEWAY_URL", Value: ""},
-						{Name: "SD_TERMINATION_GRACE_PERIOD_SECONDS", Value: "60"}, //string(core.DefaultTerminationGracePeriodSeconds)
-						{Name: "CONTAINER_IMAGE", Value: config["container"].(string)},
-						{Name: "SD_PIPELINE_ID", Value: fmt.Sprint(pipelineID)},
-						{Name: "SD_BUILD_PREFIX", Value: ""},
-						{Name: "NODE_ID", ValueFrom: &core.EnvVarSource{FieldRef: &core.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
-						{Name: "SD_BASE_COMMAND_PATH", Value: "/sd/commands/"},
-						{Name: "SD_TEMP", Value: "/opt/sd_tmp"},
-						{Name: "DOCKER_HOST", Value: "tcp"},
-						{Name: "SD_HAB_ENABLED", Value: "true"},
+						Limits: limits,
 					},
+					Env:     env,
 					Command: []string{"/opt/sd/launcher_entrypoint.sh"},
 					Args: []string{
 						fmt.Sprintf("/opt/sd/run.sh %v %v %v %v %v %v",
@@ -232,28 +334,154 @@ func getPodObject(config map[string]interface{}, namespace string) *core.Pod {
 	}
 }
 
+// gets the availability zone of the subnet picked by the subnet strategy, empty if none applies
+func (e *AwsExecutorEKS) selectZone(config map[string]interface{}) string {
+	if e.eksClient == nil || e.eksClient.ec2 == nil {
+		return ""
+	}
+	provider := config["provider"].(map[string]interface{})
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	strategy := subnet.Strategy(vpc)
+	subnetIDs := subnet.IDs(vpc)
+	if strategy == "" || len(subnetIDs) < 2 {
+		return ""
+	}
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	selected, err := subnet.Select(e.eksClient.ec2, strategy, subnetIDs, buildID)
+	if err != nil {
+		log.Printf("Error selecting subnet, scheduling without zone preference: %v", err)
+		return ""
+	}
+	log.Printf("Selected subnet %v in %v", aws.StringValue(selected.SubnetId), aws.StringValue(selected.AvailabilityZone))
+	return aws.StringValue(selected.AvailabilityZone)
+}
+
+// sets a preferred node affinity for the availability zone
+func setZoneAffinity(pod *core.Pod, zone string) {
+	pod.Spec.Affinity = &core.Affinity{
+		NodeAffinity: &core.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []core.PreferredSchedulingTerm{
+				{
+					Weight: 100,
+					Preference: core.NodeSelectorTerm{
+						MatchExpressions: []core.NodeSelectorRequirement{
+							{Key: core.LabelZoneFailureDomainStable, Operator: core.NodeSelectorOpIn, Values: []string{zone}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 // Start a kubernetes pod in eks cluster
 func (e *AwsExecutorEKS) Start(config map[string]interface{}) (string, error) {
-	clientset, _ := e.newClientSet(config)
 	provider := config["provider"].(map[string]interface{})
+	if _, err := launcher.GetFlags(provider, executorName); err != nil {
+		return "", executor.Errorf(executor.UserError, "%w", err)
+	}
+	workload, err := getWorkload(provider)
+	if err != nil {
+		return "", executor.Errorf(executor.UserError, "%w", err)
+	}
+	clientset, err := e.newClientSet(config)
+	if isClusterNotFound(err) {
+		return "", executor.Errorf(executor.InfraPermanent, "%w", err)
+	}
+	if err != nil {
+		return "", executor.Errorf(executor.InfraTransient, "%w", err)
+	}
+	if clientset.arn != "" {
+		config["clusterArn"] = clientset.arn
+	}
 	namespace := provider["namespace"].(string)
 	podsClient := clientset.client.CoreV1().Pods(namespace)
 	log.Printf("Namespace: %v, PodClient: +%v", namespace, &podsClient)
 
+	// run the pod with the service account of the scoped pipeline role
+	if roleArn, _ := provider["scopedRole"].(string); roleArn != "" {
+		serviceAccountName, err := ensureServiceAccount(clientset, namespace, config, roleArn)
+		if err != nil {
+			return "", err
+		}
+		config["serviceAccountName"] = serviceAccountName
+	}
+
 	// build the pod definition we want to deploy
 	pod := getPodObject(config, namespace)
-	log.Printf("Pod spec %+v", pod.Spec)
+	if err := setVolumeMode(pod, config); err != nil {
+		return "", err
+	}
+	if err := setWorkspaceVolume(pod, config); err != nil {
+		return "", err
+	}
+	if err := addServices(pod, config); err != nil {
+		return "", executor.Errorf(executor.UserError, "%w", err)
+	}
+	setTokenSecret(pod, config)
+	if zone := e.selectZone(config); zone != "" {
+		setZoneAffinity(pod, zone)
+	}
+	if err := setZoneSpread(pod, provider); err != nil {
+		return "", err
+	}
+	// pin the pod to nodes of the requested architecture
+	if provider["architecture"] != nil {
+		arch, err := launcher.Architecture(provider)
+		if err != nil {
+			return "", executor.Errorf(executor.UserError, "%w", err)
+		}
+		pod.Spec.NodeSelector = map[string]string{core.LabelArchStable: arch}
+	}
+	log.Printf("Pod spec %v", redact.Values(fmt.Sprintf("%+v", pod.Spec), config["token"].(string)))
+	if nodeName, ok := adoptExisting(clientset, namespace, config); ok {
+		return nodeName, nil
+	}
 	// create pod in eks cluster
+	if workload == workloadJob {
+		// the job creates the pod, which is not scheduled yet
+		jobName, err := createJob(clientset, pod)
+		if err != nil {
+			return "", err
+		}
+		config["k8sJobName"] = jobName
+		return "", nil
+	}
 	log.Println("Creating pod...")
-	podResponse, errPod := podsClient.Create(context.TODO(), pod, metav1.CreateOptions{})
+	var podResponse *core.Pod
+	attempt := 0
+	errPod := retryAPI("create pod", func() error {
+		attempt++
+		var err error
+		podResponse, err = podsClient.Create(context.TODO(), pod, metav1.CreateOptions{})
+		// a timed out create may have created the pod
+		if k8serrors.IsAlreadyExists(err) && attempt > 1 {
+			podResponse, err = pod, nil
+		}
+		return err
+	})
 	if errPod != nil {
-		return "", fmt.Errorf("Error creating pod %v", errPod)
+		if err := admissionError(errPod); err != nil {
+			return "", fmt.Errorf("Error creating pod %w", err)
+		}
+		return "", executor.Errorf(apiCategory(errPod), "Error creating pod %v", errPod)
 	}
 	log.Printf("Created pod %v.\n", podResponse.ObjectMeta.Name)
+	// set pod name to config
+	config["podName"] = podResponse.ObjectMeta.Name
 
-	getResponse, _ := podsClient.Get(context.TODO(), podResponse.ObjectMeta.Name, metav1.GetOptions{})
+	getResponse := podResponse
+	if err := retryAPI("get pod", func() error {
+		response, err := podsClient.Get(context.TODO(), podResponse.ObjectMeta.Name, metav1.GetOptions{})
+		if err == nil {
+			getResponse = response
+		}
+		return err
+	}); err != nil {
+		log.Printf("Error getting pod %v: %v", podResponse.ObjectMeta.Name, err)
+	}
 
-	log.Printf("Get pod response %+v.\n", getResponse.Spec)
+	log.Printf("Get pod response %v.\n", redact.Values(fmt.Sprintf("%+v", getResponse.Spec), config["token"].(string)))
 
 	nodeName := getResponse.Spec.NodeName
 
@@ -264,24 +492,64 @@ func (e *AwsExecutorEKS) Start(config map[string]interface{}) (string, error) {
 
 // Stop fn deletes a kubernetes pod in eks cluster
 func (e *AwsExecutorEKS) Stop(config map[string]interface{}) error {
-	clientset, _ := e.newClientSet(config)
 	provider := config["provider"].(map[string]interface{})
 	namespace := provider["namespace"].(string)
 	buildID, _ := config["buildId"].(json.Number).Int64()
 	buildIDStr := fmt.Sprint(buildID)
+	clientset, err := e.newClientSet(config)
+	// the pods of a deleted cluster are gone
+	if isClusterNotFound(err) {
+		log.Printf("Cluster %v is already deleted, nothing to stop for build %v", config["clusterName"], buildIDStr)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to cluster %v", err)
+	}
 	log.Print(namespace)
 
-	podsClient := clientset.client.CoreV1().Pods(namespace)
-	listPods, err := podsClient.List(context.TODO(), metav1.ListOptions{LabelSelector: fmt.Sprintf("sdbuild=%v", buildIDStr)})
+	// jobs would replace their deleted pods
+	jobFailures := deleteJobs(clientset, namespace, metav1.ListOptions{LabelSelector: buildSelector(config)})
 
+	podsClient := clientset.client.CoreV1().Pods(namespace)
+	var listPods *core.PodList
+	err = retryAPI("list pods", func() error {
+		var err error
+		listPods, err = podsClient.List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to get pods %v", err)
 	}
-	//delete pod in eks cluster
+	if len(listPods.Items) == 0 {
+		log.Printf("No pods of build %v are left, nothing to delete", buildIDStr)
+	}
+	//delete pods in eks cluster concurrently, collecting all failures
+	var mu sync.Mutex
+	var failures []string
+	var g errgroup.Group
 	for _, i := range listPods.Items {
-		log.Printf("Deleting pod...%s", i.Name)
-		result := podsClient.Delete(context.TODO(), i.Name, metav1.DeleteOptions{})
-		log.Printf("Deleted pod %s", result)
+		name := i.Name
+		g.Go(func() error {
+			log.Printf("Deleting pod...%s", name)
+			err := retryAPI("delete pod", func() error {
+				return podsClient.Delete(context.TODO(), name, metav1.DeleteOptions{})
+			})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				mu.Unlock()
+				return err
+			}
+			log.Printf("Deleted pod %s", name)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		sort.Strings(failures)
+		return fmt.Errorf("failed to delete pods %v", strings.Join(append(jobFailures, failures...), ", "))
+	}
+	if len(jobFailures) > 0 {
+		return fmt.Errorf("failed to delete jobs %v", strings.Join(jobFailures, ", "))
 	}
 
 	return nil
@@ -292,10 +560,30 @@ func (e *AwsExecutorEKS) Name() string {
 	return e.name
 }
 
-// New fn returns a new instance of EKS executor
-func New(region string) *AwsExecutorEKS {
+// New fn returns a new instance of EKS executor, failing when the aws session can't be created
+func New(region string) (*AwsExecutorEKS, error) {
+	eksClient, err := newEKSService(region)
+	if err != nil {
+		return nil, err
+	}
 	return &AwsExecutorEKS{
-		eksClient: newEKSService(region),
+		eksClient: eksClient,
 		name:      executorName,
+	}, nil
+}
+
+// NewForCluster returns an EKS executor starting all builds on the cluster of the rest config instead of the eks
+// cluster of the build, like a local envtest or kind cluster
+func NewForCluster(region string, config *rest.Config) (*AwsExecutorEKS, error) {
+	injectFaults(config)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating clientset: %v", err)
+	}
+	e, err := New(region)
+	if err != nil {
+		return nil, err
 	}
+	e.k8sClientset = &k8sClientset{client: clientset}
+	return e, nil
 }
@@ -6,18 +6,33 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	fake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 var (
@@ -88,15 +103,44 @@ type mockEKS struct {
 	mock.Mock
 }
 
+func (m *mockEKS) ListClustersPages(input *eks.ListClustersInput, fn func(*eks.ListClustersOutput, bool) bool) error {
+	args := m.Called(input)
+	pages := args.Get(0).([]*eks.ListClustersOutput)
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return args.Error(1)
+}
 func (m *mockEKS) DescribeCluster(input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*eks.DescribeClusterOutput), args.Error(1)
 }
 
+type mockEC2 struct {
+	ec2iface.EC2API
+	mock.Mock
+}
+
+func (m *mockEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ec2.DescribeSubnetsOutput), args.Error(1)
+}
+
+// session signing cluster tokens with the credentials, which are empty to fail the signing
+func testSession(accessKey string) *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials(accessKey, accessKey, ""),
+	}))
+}
+
 func setup() (*mockEKS, *eksClient) {
 	mockEKSClient := new(mockEKS)
 	mockEKS := &eksClient{
 		service: mockEKSClient,
+		sess:    testSession("AKIDEXAMPLE"),
 	}
 
 	return mockEKSClient, mockEKS
@@ -118,8 +162,14 @@ func (e *awsEKSExecutorMock) newClientSet(config map[string]interface{}) (*fake.
 }
 
 func TestNewAWSService(t *testing.T) {
-	awsService := newEKSService("us-west-2")
+	awsService, err := newEKSService("us-west-2")
+	assert.Nil(t, err)
 	assert.NotNil(t, awsService.service)
+
+	// executors are not built on sessions which can't be created
+	t.Setenv("AWS_CA_BUNDLE", "/missing/ca-bundle.pem")
+	_, err = New("us-west-2")
+	assert.NotNil(t, err)
 }
 
 func TestDescribeCluster(t *testing.T) {
@@ -221,7 +271,7 @@ func TestK8sClientSet(t *testing.T) {
 				"clusterName": "",
 			},
 			expectObj: &k8sClientset{},
-			err:       errors.New("Error calling DescribeCluster:cluster name is empty"),
+			err:       fmt.Errorf("Error calling DescribeCluster:%w", errors.New("cluster name is empty")),
 		},
 		{
 			request: map[string]interface{}{
@@ -243,6 +293,91 @@ func TestK8sClientSet(t *testing.T) {
 	}
 }
 
+func TestK8sClientSetCache(t *testing.T) {
+	mockEKSClient, mockEKS := setup()
+	for _, name := range []string{"sd-build-1", "sd-build-2"} {
+		mockEKSClient.On("DescribeCluster", &eks.DescribeClusterInput{Name: aws.String(name)}).Return(&eks.DescribeClusterOutput{Cluster: &eks.Cluster{
+			Arn:                  aws.String("arn:" + name),
+			CertificateAuthority: &eks.Certificate{Data: aws.String("somedata")},
+			Name:                 aws.String(name),
+			Endpoint:             aws.String("endpoint://" + name),
+		}}, nil)
+	}
+	executor := &AwsExecutorEKS{eksClient: mockEKS}
+
+	first, err := executor.newClientSet(map[string]interface{}{"clusterName": "sd-build-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:sd-build-1", first.arn)
+	cached, _ := executor.newClientSet(map[string]interface{}{"clusterName": "sd-build-1"})
+	assert.Same(t, first, cached)
+	other, _ := executor.newClientSet(map[string]interface{}{"clusterName": "sd-build-2"})
+	assert.NotSame(t, first, other)
+	mockEKSClient.AssertNumberOfCalls(t, "DescribeCluster", 2)
+
+	// expired clientsets are recreated with a new token
+	first.expires = time.Now().Add(-time.Second)
+	renewed, _ := executor.newClientSet(map[string]interface{}{"clusterName": "sd-build-1"})
+	assert.NotSame(t, first, renewed)
+	mockEKSClient.AssertNumberOfCalls(t, "DescribeCluster", 3)
+}
+
+func TestK8sClientSetTokenError(t *testing.T) {
+	mockEKSClient, mockEKS := setup()
+	mockEKS.sess = testSession("")
+	mockEKSClient.On("DescribeCluster", &eks.DescribeClusterInput{Name: aws.String("sd-build-1")}).Return(&eks.DescribeClusterOutput{Cluster: &eks.Cluster{
+		Arn:                  aws.String("arn:sd-build-1"),
+		CertificateAuthority: &eks.Certificate{Data: aws.String("somedata")},
+		Name:                 aws.String("sd-build-1"),
+		Endpoint:             aws.String("endpoint://sd-build-1"),
+	}}, nil)
+	executor := &AwsExecutorEKS{eksClient: mockEKS}
+
+	// clientsets without a token are not cached
+	_, err := executor.newClientSet(map[string]interface{}{"clusterName": "sd-build-1"})
+	assert.Equal(t, executorState.InfraTransient, executorState.CategoryOf(err))
+	assert.Contains(t, err.Error(), "Error getting token")
+	assert.Equal(t, 0, len(executor.clientsets))
+}
+
+func TestNewForCluster(t *testing.T) {
+	executor, err := NewForCluster("us-west-2", &rest.Config{Host: "https://127.0.0.1:6443", BearerToken: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, "eks", executor.Name())
+
+	// the local cluster is used whatever the cluster of the build
+	clientset, err := executor.newClientSet(map[string]interface{}{"clusterName": "sd-build-1"})
+	assert.Nil(t, err)
+	assert.Same(t, executor.k8sClientset, clientset)
+
+	_, err = NewForCluster("us-west-2", &rest.Config{Host: "https://127.0.0.1:6443", TLSClientConfig: rest.TLSClientConfig{CAFile: "/missing/ca.crt"}})
+	assert.Contains(t, err.Error(), "Error creating clientset")
+}
+
+func TestNewForClusterFaults(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "PodList", "apiVersion": "v1", "items": []}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("SD_FAULTS", `[{"target": "k8s", "service": "/pods", "operation": "GET", "fault": "error", "rate": 1}]`)
+	executor, err := NewForCluster("us-west-2", &rest.Config{Host: server.URL})
+	assert.Nil(t, err)
+	_, err = executor.k8sClientset.client.CoreV1().Pods("sd-builds").List(context.TODO(), metav1.ListOptions{})
+	assert.True(t, k8serrors.IsServiceUnavailable(err), err)
+	assert.Equal(t, 0, calls)
+
+	// invalid rules are skipped
+	t.Setenv("SD_FAULTS", `[{"fault": "crash"}]`)
+	executor, err = NewForCluster("us-west-2", &rest.Config{Host: server.URL})
+	assert.Nil(t, err)
+	_, err = executor.k8sClientset.client.CoreV1().Pods("sd-builds").List(context.TODO(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
 func TestStart(t *testing.T) {
 	testConfig := getTestConfig()
 	kubeclient := fake.NewSimpleClientset(&core.Pod{
@@ -355,3 +490,214 @@ func TestStop(t *testing.T) {
 		assert.Equal(t, test.expectedPodCount, len(pods.Items))
 	}
 }
+
+func TestStopDeletesAllPods(t *testing.T) {
+	var objects []runtime.Object
+	for _, suffix := range []string{"aaaaa", "bbbbb", "ccccc"} {
+		objects = append(objects, &core.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "1234-" + suffix,
+			Namespace: testNamespace,
+			Labels:    map[string]string{"sdbuild": "1234"},
+		}})
+	}
+	kubeclient := fake.NewSimpleClientset(objects...)
+	kubeclient.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.DeleteAction).GetName() == "1234-bbbbb" {
+			return true, nil, errors.New("etcdserver: request timed out")
+		}
+		return false, nil, nil
+	})
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: kubeclient}}
+
+	err := executor.Stop(getTestConfig())
+	assert.EqualError(t, err, "failed to delete pods 1234-bbbbb: etcdserver: request timed out")
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Equal(t, 1, len(pods.Items))
+	assert.Equal(t, "1234-bbbbb", pods.Items[0].Name)
+}
+
+func TestStopIgnoresDeletedPods(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset(&core.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "1234-aaaaa",
+		Namespace: testNamespace,
+		Labels:    map[string]string{"sdbuild": "1234"},
+	}})
+	kubeclient.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewNotFound(core.Resource("pods"), "1234-aaaaa")
+	})
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: kubeclient}}
+
+	assert.Nil(t, executor.Stop(getTestConfig()))
+}
+
+func TestStopDeletedCluster(t *testing.T) {
+	mockEKSClient, eksClient := setup()
+	mockEKSClient.On("DescribeCluster", mock.Anything).Return(&eks.DescribeClusterOutput{}, awserr.New(eks.ErrCodeResourceNotFoundException, "No cluster found for name: sd-build", nil)).Once()
+	mockEKSClient.On("DescribeCluster", mock.Anything).Return(&eks.DescribeClusterOutput{}, errors.New("AccessDeniedException"))
+	executor := &AwsExecutorEKS{eksClient: eksClient, clientsets: map[string]*k8sClientset{}}
+	testConfig := getTestConfig()
+	testConfig["clusterName"] = "sd-build"
+
+	// a deleted cluster has nothing to stop, other failures are reported
+	assert.Nil(t, executor.Stop(testConfig))
+	assert.EqualError(t, executor.Stop(testConfig), "failed to connect to cluster Error calling DescribeCluster:AccessDeniedException")
+}
+
+func TestStartZoneAffinity(t *testing.T) {
+	mockEC2Client := new(mockEC2)
+	mockEC2Client.On("DescribeSubnets", &ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice([]string{"subnet-1111", "subnet-2222", "subnet-3333"}),
+	}).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-1111"), AvailabilityZone: aws.String("us-west-2a"), AvailableIpAddressCount: aws.Int64(3)},
+		{SubnetId: aws.String("subnet-2222"), AvailabilityZone: aws.String("us-west-2b"), AvailableIpAddressCount: aws.Int64(2)},
+		{SubnetId: aws.String("subnet-3333"), AvailabilityZone: aws.String("us-west-2c"), AvailableIpAddressCount: aws.Int64(90)},
+	}}, nil)
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{ec2: mockEC2Client},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	testConfig := getTestConfig()
+	vpc := testConfig["provider"].(map[string]interface{})["vpc"].(map[string]interface{})
+	vpc["subnetStrategy"] = "most-free-ips"
+	_, err := executor.Start(testConfig)
+	assert.Nil(t, err)
+
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, 1, len(pods.Items))
+	terms := pods.Items[0].Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	assert.Equal(t, []core.PreferredSchedulingTerm{{
+		Weight: 100,
+		Preference: core.NodeSelectorTerm{MatchExpressions: []core.NodeSelectorRequirement{
+			{Key: "topology.kubernetes.io/zone", Operator: core.NodeSelectorOpIn, Values: []string{"us-west-2c"}},
+		}},
+	}}, terms)
+}
+
+func TestStartArchitecture(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	testConfig := getTestConfig()
+	_, err := executor.Start(testConfig)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Nil(t, pods.Items[0].Spec.NodeSelector)
+
+	kubeclient = fake.NewSimpleClientset()
+	executor.k8sClientset = &k8sClientset{client: kubeclient}
+	testConfig = getTestConfig()
+	testConfig["provider"].(map[string]interface{})["architecture"] = "aarch64"
+	_, err = executor.Start(testConfig)
+	assert.Nil(t, err)
+	pods, _ = kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, map[string]string{"kubernetes.io/arch": "arm64"}, pods.Items[0].Spec.NodeSelector)
+
+	testConfig = getTestConfig()
+	testConfig["provider"].(map[string]interface{})["architecture"] = "ppc64le"
+	_, err = executor.Start(testConfig)
+	assert.EqualError(t, err, `unsupported architecture "ppc64le"`)
+}
+
+func TestStartPassthroughEnvironment(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	testConfig := getTestConfig()
+	testConfig["environment"] = map[string]interface{}{"AWS_ACCESS_KEY_ID": "AKIA"}
+	testConfig[policy.EnvironmentKey] = map[string]string{"NODE_ENV": "production"}
+	_, err := executor.Start(testConfig)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	env := pods.Items[0].Spec.Containers[0].Env
+	assert.Equal(t, core.EnvVar{Name: "NODE_ENV", Value: "production"}, env[len(env)-1])
+	for _, v := range env {
+		assert.NotEqual(t, "AWS_ACCESS_KEY_ID", v.Name)
+	}
+}
+
+func TestStartLauncherFlags(t *testing.T) {
+	t.Setenv("SD_LAUNCHER_HABITAT", "")
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	testConfig := getTestConfig()
+	testConfig["provider"].(map[string]interface{})["sourceDir"] = "services/api"
+	_, err := executor.Start(testConfig)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	env := pods.Items[0].Spec.Containers[0].Env
+	assert.Equal(t, []core.EnvVar{
+		{Name: "SD_HAB_ENABLED", Value: "true"},
+		{Name: "SD_BASE_COMMAND_PATH", Value: "/sd/commands/"},
+		{Name: "SD_SOURCE_DIR", Value: "services/api"},
+	}, env[len(env)-3:])
+
+	testConfig = getTestConfig()
+	testConfig["provider"].(map[string]interface{})["habitat"] = "sometimes"
+	_, err = executor.Start(testConfig)
+	assert.EqualError(t, err, `invalid habitat flag "sometimes"`)
+}
+
+func TestStartDiskLimit(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	testConfig := getTestConfig()
+	testConfig["provider"].(map[string]interface{})["diskLimit"] = "100Gi"
+	_, err := executor.Start(testConfig)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	limits := pods.Items[0].Spec.Containers[0].Resources.Limits
+	assert.Equal(t, "100Gi", limits.StorageEphemeral().String())
+	assert.Equal(t, "2Gi", limits.Memory().String())
+}
+
+func TestStartSchedulerName(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	testConfig := getTestConfig()
+	testConfig["provider"].(map[string]interface{})["schedulerName"] = "volcano"
+	_, err := executor.Start(testConfig)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, "volcano", pods.Items[0].Spec.SchedulerName)
+
+	assert.Equal(t, "", getPodObject(getTestConfig(), testNamespace).Spec.SchedulerName)
+}
+
+func TestBuildSelector(t *testing.T) {
+	testConfig := getTestConfig()
+	assert.Equal(t, "sdbuild=1234", buildSelector(testConfig))
+	assert.NotContains(t, getPodObject(testConfig, testNamespace).Labels, "sdarch")
+
+	// the builds of a multi-architecture build select the pods of their architecture
+	testConfig[matrix.ArchitectureKey] = "arm64"
+	assert.Equal(t, "sdbuild=1234,sdarch=arm64", buildSelector(testConfig))
+	assert.Equal(t, "arm64", getPodObject(testConfig, testNamespace).Labels["sdarch"])
+}
+
+func TestGetPodObjectNamePrefix(t *testing.T) {
+	assert.Regexp(t, `^1234-[a-z0-9]{5}$`, getPodObject(getTestConfig(), testNamespace).Name)
+
+	testConfig := getTestConfig()
+	testConfig[policy.PodNamePrefixKey] = "sd-prod-12345"
+	assert.Regexp(t, `^sd-prod-12345-1234-[a-z0-9]{5}$`, getPodObject(testConfig, testNamespace).Name)
+}
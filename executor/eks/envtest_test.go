@@ -0,0 +1,51 @@
+//go:build integration
+
+package eks
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/e2e"
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// starts and stops the sample build on a local cluster, go test -tags integration with SD_E2E_KUBE_SERVER set.
+// envtest clusters have no nodes, their pods stay pending until they are stopped.
+func TestEnvtestBuild(t *testing.T) {
+	config, err := e2e.KubeConfig()
+	assert.Nil(t, err)
+	if config == nil {
+		t.Skip("SD_E2E_KUBE_SERVER is not set")
+	}
+	m, err := e2e.SampleMessage("eks")
+	assert.Nil(t, err)
+	e, err := NewForCluster(m.BuildRegion(), config)
+	assert.Nil(t, err)
+
+	_, err = e.Start(m.BuildConfig)
+	assert.Nil(t, err)
+	status, err := e.Status(m.BuildConfig)
+	assert.Nil(t, err)
+	assert.Contains(t, []executor.State{executor.Queued, executor.Running, executor.Succeeded}, status.State)
+
+	assert.Nil(t, e.Stop(m.BuildConfig))
+	assert.Nil(t, e.Cleanup(m.BuildConfig))
+	pods, err := e.k8sClientset.client.CoreV1().Pods(testNamespaceOf(m.BuildConfig)).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("sdbuild=%v", m.BuildConfig["buildId"]),
+	})
+	assert.Nil(t, err)
+	for _, pod := range pods.Items {
+		assert.NotNil(t, pod.DeletionTimestamp, pod.Name)
+	}
+}
+
+// gets the namespace of the pods of the build
+func testNamespaceOf(config map[string]interface{}) string {
+	provider := config["provider"].(map[string]interface{})
+	return provider["namespace"].(string)
+}
@@ -0,0 +1,119 @@
+package eks
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// kinds of kubernetes api errors
+const (
+	errorThrottled   = "throttled"
+	errorTimeout     = "timeout"
+	errorConflict    = "conflict"
+	errorForbidden   = "forbidden"
+	errorUnavailable = "unavailable"
+)
+
+// number of attempts of an api call failing with a transient error
+const apiAttempts = 4
+
+// wait before retrying a failed api call, doubled on every retry
+var apiRetryInterval = 500 * time.Millisecond
+
+// apiError is a kubernetes api error classified by its kind
+type apiError struct {
+	Kind string
+	Err  error
+}
+
+func (e *apiError) Error() string {
+	return e.Kind + ": " + e.Err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// gets the kind of a kubernetes api error, empty if it is of none of the known kinds
+func errorKind(err error) string {
+	switch {
+	case k8serrors.IsTooManyRequests(err):
+		return errorThrottled
+	case k8serrors.IsTimeout(err), k8serrors.IsServerTimeout(err):
+		return errorTimeout
+	case k8serrors.IsConflict(err):
+		return errorConflict
+	case k8serrors.IsForbidden(err):
+		return errorForbidden
+	case k8serrors.IsServiceUnavailable(err), k8serrors.IsInternalError(err):
+		return errorUnavailable
+	}
+	return ""
+}
+
+// wraps a kubernetes api error with its kind, errors of unknown kinds are returned as is
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	if kind := errorKind(err); kind != "" {
+		return &apiError{Kind: kind, Err: err}
+	}
+	return err
+}
+
+// isTransient returns true if an api call failing with the error may succeed when retried
+func isTransient(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Kind {
+	case errorThrottled, errorTimeout, errorConflict, errorUnavailable:
+		return true
+	}
+	return false
+}
+
+// gets the executor category of a failed api call. Forbidden calls lack a permission of the consumer,
+// invalid objects are built from an invalid build config.
+func apiCategory(err error) executor.Category {
+	switch {
+	case isTransient(err):
+		return executor.InfraTransient
+	case k8serrors.IsForbidden(err):
+		return executor.InfraPermanent
+	case k8serrors.IsInvalid(err):
+		return executor.UserError
+	}
+	return ""
+}
+
+// retryAPI calls fn until it succeeds, fails with an error which is not transient or runs out of attempts.
+// The api server asks throttled clients to wait, which is honored when longer than the backoff.
+func retryAPI(op string, fn func() error) error {
+	interval := apiRetryInterval
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = classify(fn())
+		if err == nil || !isTransient(err) || attempt == apiAttempts {
+			return err
+		}
+		wait := interval
+		if seconds, ok := k8serrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > wait {
+			wait = time.Duration(seconds) * time.Second
+		}
+		log.Printf("Retrying %s in %v (attempt %d of %d): %v", op, wait, attempt+1, apiAttempts, err)
+		time.Sleep(wait)
+		interval *= 2
+	}
+}
@@ -0,0 +1,139 @@
+package eks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err       error
+		kind      string
+		transient bool
+	}{
+		{err: k8serrors.NewTooManyRequests("too many requests", 0), kind: "throttled", transient: true},
+		{err: k8serrors.NewServerTimeout(podsResource, "create", 0), kind: "timeout", transient: true},
+		{err: k8serrors.NewTimeoutError("request timed out", 0), kind: "timeout", transient: true},
+		{err: k8serrors.NewConflict(podsResource, "1234-abcde", errors.New("object was modified")), kind: "conflict", transient: true},
+		{err: k8serrors.NewServiceUnavailable("etcd leader changed"), kind: "unavailable", transient: true},
+		{err: k8serrors.NewInternalError(errors.New("etcdserver: leader changed")), kind: "unavailable", transient: true},
+		{err: k8serrors.NewForbidden(podsResource, "", errors.New("cannot create pods")), kind: "forbidden"},
+	}
+	for _, test := range tests {
+		err := classify(test.err)
+		var apiErr *apiError
+		assert.True(t, errors.As(err, &apiErr), test.kind)
+		assert.Equal(t, test.kind, apiErr.Kind)
+		assert.Equal(t, test.kind+": "+test.err.Error(), err.Error())
+		assert.Equal(t, test.transient, isTransient(err), test.kind)
+		assert.Equal(t, err, classify(err))
+	}
+
+	notFound := k8serrors.NewNotFound(podsResource, "1234-abcde")
+	assert.Equal(t, notFound, classify(notFound))
+	assert.True(t, k8serrors.IsNotFound(classify(notFound)))
+	assert.False(t, isTransient(errors.New("etcdserver: request timed out")))
+	assert.Nil(t, classify(nil))
+}
+
+func TestRetryAPI(t *testing.T) {
+	defer func(interval time.Duration) { apiRetryInterval = interval }(apiRetryInterval)
+	apiRetryInterval = time.Millisecond
+
+	calls := 0
+	err := retryAPI("list pods", func() error {
+		calls++
+		if calls < 3 {
+			return k8serrors.NewTooManyRequests("too many requests", 0)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = retryAPI("list pods", func() error {
+		calls++
+		return k8serrors.NewServerTimeout(podsResource, "list", 0)
+	})
+	assert.EqualError(t, err, "timeout: The list operation against pods could not be completed at this time, please try again.")
+	assert.Equal(t, 4, calls)
+
+	calls = 0
+	err = retryAPI("create pod", func() error {
+		calls++
+		return k8serrors.NewForbidden(podsResource, "", errors.New("cannot create pods"))
+	})
+	assert.True(t, k8serrors.IsForbidden(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestStartRetriesTransientErrors(t *testing.T) {
+	defer func(interval time.Duration) { apiRetryInterval = interval }(apiRetryInterval)
+	apiRetryInterval = time.Millisecond
+
+	kubeclient := fake.NewSimpleClientset()
+	creates := 0
+	kubeclient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creates++
+		if creates == 1 {
+			return true, &core.Pod{}, k8serrors.NewTooManyRequests("too many requests", 0)
+		}
+		return false, nil, nil
+	})
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+	config := getTestConfig()
+	_, err := executor.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, creates)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, 1, len(pods.Items))
+	assert.Equal(t, pods.Items[0].Name, config["podName"])
+
+	// a create which timed out after creating the pod is not an error
+	kubeclient = fake.NewSimpleClientset()
+	creates = 0
+	kubeclient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		creates++
+		if creates == 1 {
+			kubeclient.Tracker().Add(action.(k8stesting.CreateAction).GetObject())
+			return true, &core.Pod{}, k8serrors.NewServerTimeout(podsResource, "create", 0)
+		}
+		return false, nil, nil
+	})
+	executor.k8sClientset = &k8sClientset{client: kubeclient}
+	_, err = executor.Start(getTestConfig())
+	assert.Nil(t, err)
+	pods, _ = kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, 1, len(pods.Items))
+
+	kubeclient = fake.NewSimpleClientset()
+	kubeclient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &core.Pod{}, k8serrors.NewServiceUnavailable("etcd leader changed")
+	})
+	executor.k8sClientset = &k8sClientset{client: kubeclient}
+	_, err = executor.Start(getTestConfig())
+	assert.EqualError(t, err, "Error creating pod unavailable: etcd leader changed")
+	assert.Equal(t, executorState.InfraTransient, executorState.CategoryOf(err))
+}
+
+func TestAPICategory(t *testing.T) {
+	assert.Equal(t, executorState.InfraTransient, apiCategory(classify(k8serrors.NewTooManyRequests("too many requests", 0))))
+	assert.Equal(t, executorState.InfraPermanent, apiCategory(classify(k8serrors.NewForbidden(podsResource, "1234", errors.New("rbac")))))
+	assert.Equal(t, executorState.UserError, apiCategory(k8serrors.NewInvalid(core.SchemeGroupVersion.WithKind("Pod").GroupKind(), "1234", nil)))
+	assert.Equal(t, executorState.Category(""), apiCategory(errors.New("unknown")))
+}
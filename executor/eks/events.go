@@ -0,0 +1,84 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxStartEvents limits the events reported for a failed start
+const maxStartEvents = 50
+
+// gets the time of an event, events reported by newer components only set the event time
+func eventTime(event core.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.FirstTimestamp.Time
+}
+
+// formats the events ordered by time, like kubectl get events, keeping the latest ones
+func formatEvents(events []core.Event) []string {
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	if len(events) > maxStartEvents {
+		events = events[len(events)-maxStartEvents:]
+	}
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("%s %s %s %s/%s: %s", eventTime(event).UTC().Format(time.RFC3339), event.Type, event.Reason,
+			event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Message))
+	}
+	return lines
+}
+
+// StartEvents returns the events of the job and pods of the build, which tell why a pod was not scheduled or created
+func (e *AwsExecutorEKS) StartEvents(config map[string]interface{}) ([]string, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return nil, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	selector := metav1.ListOptions{LabelSelector: buildSelector(config)}
+	coreClient := clientset.client.CoreV1()
+
+	var names []string
+	pods, err := coreClient.Pods(namespace).List(context.TODO(), selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods %v", err)
+	}
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	jobs, err := clientset.client.BatchV1().Jobs(namespace).List(context.TODO(), selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs %v", err)
+	}
+	for _, job := range jobs.Items {
+		names = append(names, job.Name)
+	}
+
+	var events []core.Event
+	for _, name := range names {
+		list, err := coreClient.Events(namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: fmt.Sprintf("involvedObject.name=%v", name)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get events %v", err)
+		}
+		for _, event := range list.Items {
+			// the fake clientset of the tests ignores field selectors
+			if event.InvolvedObject.Name == name {
+				events = append(events, event)
+			}
+		}
+	}
+	return formatEvents(events), nil
+}
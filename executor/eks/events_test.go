@@ -0,0 +1,61 @@
+package eks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func buildEvent(name string, kind string, object string, reason string, message string, at time.Time) *core.Event {
+	return &core.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		InvolvedObject: core.ObjectReference{Kind: kind, Name: object},
+		Type:           core.EventTypeWarning,
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+func TestStartEvents(t *testing.T) {
+	at := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "1234-job", Namespace: testNamespace, Labels: map[string]string{"sdbuild": "1234"}}}
+	executor := &AwsExecutorEKS{
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(
+			buildPod(core.PodPending, core.ContainerState{}),
+			job,
+			buildEvent("scheduling", "Pod", "1234-abcde", "FailedScheduling", "0/3 nodes are available: 3 Insufficient cpu.", at.Add(time.Minute)),
+			buildEvent("backoff", "Job", "1234-job", "BackoffLimitExceeded", "Job has reached the specified backoff limit", at.Add(2*time.Minute)),
+			buildEvent("created", "Job", "1234-job", "SuccessfulCreate", "Created pod: 1234-abcde", at),
+			buildEvent("other", "Pod", "5678-abcde", "FailedScheduling", "0/3 nodes are available", at),
+		)},
+	}
+	events, err := executor.StartEvents(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"2022-03-01T10:00:00Z Warning SuccessfulCreate Job/1234-job: Created pod: 1234-abcde",
+		"2022-03-01T10:01:00Z Warning FailedScheduling Pod/1234-abcde: 0/3 nodes are available: 3 Insufficient cpu.",
+		"2022-03-01T10:02:00Z Warning BackoffLimitExceeded Job/1234-job: Job has reached the specified backoff limit",
+	}, events)
+
+	executor.k8sClientset = &k8sClientset{client: fake.NewSimpleClientset()}
+	events, err = executor.StartEvents(getTestConfig())
+	assert.Nil(t, err)
+	assert.Empty(t, events)
+}
+
+func TestFormatEventsLimit(t *testing.T) {
+	var events []core.Event
+	at := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < maxStartEvents+5; i++ {
+		events = append(events, *buildEvent("e", "Pod", "1234-abcde", "BackOff", "Back-off pulling image", at.Add(time.Duration(i)*time.Second)))
+	}
+	lines := formatEvents(events)
+	assert.Len(t, lines, maxStartEvents)
+	assert.Equal(t, "2022-03-01T10:00:54Z Warning BackOff Pod/1234-abcde: Back-off pulling image", lines[len(lines)-1])
+}
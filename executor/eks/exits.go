@@ -0,0 +1,69 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// reason kubernetes reports for containers killed by the kernel for exceeding their memory limit
+const oomKilledReason = "OOMKilled"
+
+// gets the exits of the terminated containers of the pod, the build and service containers before the init containers.
+// A restarted container reports its last termination.
+func containerExits(pod *core.Pod) []executor.ContainerExit {
+	limits := map[string]string{}
+	for _, containers := range [][]core.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, c := range containers {
+			if memory, ok := c.Resources.Limits[core.ResourceMemory]; ok {
+				limits[c.Name] = memory.String()
+			}
+		}
+	}
+	var exits []executor.ContainerExit
+	for _, statuses := range [][]core.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses} {
+		for _, status := range statuses {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated == nil {
+				continue
+			}
+			exit := executor.ContainerExit{
+				Container: status.Name,
+				ExitCode:  terminated.ExitCode,
+				Reason:    terminated.Reason,
+				OOMKilled: terminated.Reason == oomKilledReason,
+				Restarts:  status.RestartCount,
+			}
+			if exit.OOMKilled {
+				exit.MemoryLimit = limits[status.Name]
+			}
+			exits = append(exits, exit)
+		}
+	}
+	return exits
+}
+
+// ContainerExits reports the exit codes of the terminated containers of the build pod and whether they were OOMKilled
+func (e *AwsExecutorEKS) ContainerExits(config map[string]interface{}) ([]executor.ContainerExit, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return nil, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	listPods, err := clientset.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods %v", err)
+	}
+	if len(listPods.Items) == 0 {
+		return nil, nil
+	}
+	return containerExits(&listPods.Items[0]), nil
+}
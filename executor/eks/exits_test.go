@@ -0,0 +1,40 @@
+package eks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestContainerExits(t *testing.T) {
+	pod := buildPod(core.PodFailed, core.ContainerState{Terminated: &core.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}})
+	pod.Spec.Containers = []core.Container{{
+		Name:      "1234",
+		Resources: core.ResourceRequirements{Limits: core.ResourceList{core.ResourceMemory: resource.MustParse("2Gi")}},
+	}}
+	pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses,
+		core.ContainerStatus{Name: "redis", State: core.ContainerState{Running: &core.ContainerStateRunning{}}},
+		core.ContainerStatus{Name: "db", RestartCount: 2, State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			LastTerminationState: core.ContainerState{Terminated: &core.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}}},
+	)
+	pod.Status.InitContainerStatuses = []core.ContainerStatus{{Name: "launcher", State: core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "Completed"}}}}
+
+	executorEks := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(pod)}}
+	exits, err := executorEks.ContainerExits(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, []executor.ContainerExit{
+		{Container: "1234", ExitCode: 137, Reason: "OOMKilled", OOMKilled: true, MemoryLimit: "2Gi"},
+		{Container: "db", ExitCode: 1, Reason: "Error", Restarts: 2},
+		{Container: "launcher", Reason: "Completed"},
+	}, exits)
+
+	executorEks = &AwsExecutorEKS{k8sClientset: &k8sClientset{client: fake.NewSimpleClientset()}}
+	exits, err = executorEks.ContainerExits(getTestConfig())
+	assert.Nil(t, err)
+	assert.Nil(t, exits)
+}
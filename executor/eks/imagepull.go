@@ -0,0 +1,67 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gets the time the kubelet started pulling the build container image from the pod events,
+// falling back to the end of the launcher init container which runs right before the pull
+func getImagePullStartTime(events []core.Event, pod core.Pod, buildIDStr string) time.Time {
+	fieldPath := fmt.Sprintf("spec.containers{%v}", buildIDStr)
+	for _, event := range events {
+		if event.InvolvedObject.Name != pod.Name || event.InvolvedObject.FieldPath != fieldPath || event.Reason != "Pulling" {
+			continue
+		}
+		if !event.EventTime.IsZero() {
+			return event.EventTime.Time
+		}
+		return event.FirstTimestamp.Time
+	}
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Terminated != nil {
+			return status.State.Terminated.FinishedAt.Time
+		}
+	}
+	return time.Time{}
+}
+
+// ImagePullStartTime returns the time the build container image pull started.
+// It polls until timeout and returns a zero time if the pull did not start.
+func (e *AwsExecutorEKS) ImagePullStartTime(config map[string]interface{}, timeout time.Duration) (time.Time, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return time.Time{}, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	buildIDStr := fmt.Sprint(buildID)
+	coreClient := clientset.client.CoreV1()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		listPods, err := coreClient.Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to get pods %v", err)
+		}
+		for _, pod := range listPods.Items {
+			events, err := coreClient.Events(namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: fmt.Sprintf("involvedObject.name=%v", pod.Name)})
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to get events %v", err)
+			}
+			if started := getImagePullStartTime(events.Items, pod, buildIDStr); !started.IsZero() {
+				return started, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,66 @@
+package eks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func pullingEvent(name string, fieldPath string, at time.Time) *core.Event {
+	return &core.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		InvolvedObject: core.ObjectReference{Kind: "Pod", Name: "1234-abcde", FieldPath: fieldPath},
+		Reason:         "Pulling",
+		FirstTimestamp: metav1.NewTime(at),
+	}
+}
+
+func TestImagePullStartTime(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	pullAt := time.Date(2022, 3, 1, 10, 0, 5, 0, time.UTC)
+	launcherDoneAt := time.Date(2022, 3, 1, 10, 0, 4, 0, time.UTC)
+
+	launcherDone := buildPod(core.PodPending, core.ContainerState{})
+	launcherDone.Status.InitContainerStatuses = []core.ContainerStatus{
+		{Name: "launcher-1234", State: core.ContainerState{Terminated: &core.ContainerStateTerminated{FinishedAt: metav1.NewTime(launcherDoneAt)}}},
+	}
+
+	tests := []struct {
+		message  string
+		objects  []runtime.Object
+		expected time.Time
+	}{
+		{
+			message: "pulling event",
+			objects: []runtime.Object{
+				launcherDone,
+				pullingEvent("launcher", "spec.initContainers{launcher-1234}", pullAt.Add(-10*time.Second)),
+				pullingEvent("build", "spec.containers{1234}", pullAt),
+			},
+			expected: pullAt,
+		},
+		{
+			message:  "launcher finished",
+			objects:  []runtime.Object{launcherDone},
+			expected: launcherDoneAt,
+		},
+		{
+			message: "not started",
+			objects: []runtime.Object{buildPod(core.PodPending, core.ContainerState{})},
+		},
+	}
+	for _, test := range tests {
+		executor := &AwsExecutorEKS{
+			k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(test.objects...)},
+		}
+		started, err := executor.ImagePullStartTime(getTestConfig(), 2*time.Millisecond)
+		assert.Nil(t, err, test.message)
+		assert.True(t, test.expected.Equal(started), test.message)
+	}
+}
@@ -0,0 +1,37 @@
+package eks
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+// ResourceLinks returns the cluster and pod of the started build with their aws console links
+func (e *AwsExecutorEKS) ResourceLinks(config map[string]interface{}) map[string]string {
+	provider := config["provider"].(map[string]interface{})
+	region, _ := provider["buildRegion"].(string)
+	if region == "" {
+		region, _ = provider["region"].(string)
+	}
+	clusterName, _ := provider["clusterName"].(string)
+	namespace, _ := provider["namespace"].(string)
+	clusterPath := fmt.Sprintf("eks/home?region=%s#/clusters/%s", region, url.PathEscape(clusterName))
+
+	links := map[string]string{
+		"cluster":    clusterName,
+		"clusterUrl": awsconfig.ConsoleURL(region, clusterPath),
+		"namespace":  namespace,
+	}
+	if podName, _ := config["podName"].(string); podName != "" {
+		links["pod"] = podName
+		links["podUrl"] = awsconfig.ConsoleURL(region, fmt.Sprintf("%s/pods/%s?namespace=%s", clusterPath, url.PathEscape(podName), url.QueryEscape(namespace)))
+	}
+	return links
+}
+
+// ResourceArn returns the arn of the cluster the build was started in, empty when it is not known
+func (e *AwsExecutorEKS) ResourceArn(config map[string]interface{}) string {
+	clusterArn, _ := config["clusterArn"].(string)
+	return clusterArn
+}
@@ -0,0 +1,38 @@
+package eks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceLinks(t *testing.T) {
+	executor := &AwsExecutorEKS{}
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["region"] = "us-west-2"
+
+	assert.Equal(t, map[string]string{
+		"cluster":    "test-cluster-1",
+		"clusterUrl": "https://console.aws.amazon.com/eks/home?region=us-west-2#/clusters/test-cluster-1",
+		"namespace":  "sd-builds",
+	}, executor.ResourceLinks(config))
+
+	config["podName"] = "1234-abcde"
+	assert.Equal(t, map[string]string{
+		"cluster":    "test-cluster-1",
+		"clusterUrl": "https://console.aws.amazon.com/eks/home?region=us-west-2#/clusters/test-cluster-1",
+		"namespace":  "sd-builds",
+		"pod":        "1234-abcde",
+		"podUrl":     "https://console.aws.amazon.com/eks/home?region=us-west-2#/clusters/test-cluster-1/pods/1234-abcde?namespace=sd-builds",
+	}, executor.ResourceLinks(config))
+}
+
+func TestResourceArn(t *testing.T) {
+	executor := &AwsExecutorEKS{}
+	config := getTestConfig()
+	assert.Equal(t, "", executor.ResourceArn(config))
+
+	config["clusterArn"] = "arn:aws:eks:us-west-2:111111111:cluster/test-cluster-1"
+	assert.Equal(t, "arn:aws:eks:us-west-2:111111111:cluster/test-cluster-1", executor.ResourceArn(config))
+}
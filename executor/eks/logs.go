@@ -0,0 +1,69 @@
+package eks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maximum size of the logs collected per container
+const maxLogBytes = 1 << 20
+
+// Logs gets the logs of the launcher init container and the build container of the pod since the given time
+func (e *AwsExecutorEKS) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return nil, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	buildIDStr := fmt.Sprint(buildID)
+	podsClient := clientset.client.CoreV1().Pods(namespace)
+
+	listPods, err := podsClient.List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods %v", err)
+	}
+	if len(listPods.Items) == 0 {
+		return nil, fmt.Errorf("no pod found for build %d", buildID)
+	}
+	pod := listPods.Items[0]
+
+	buf := new(bytes.Buffer)
+	sinceTime := metav1.NewTime(since)
+	limitBytes := int64(maxLogBytes)
+	containers := []string{"launcher-" + buildIDStr, buildIDStr}
+	for _, container := range pod.Spec.Containers {
+		if strings.HasPrefix(container.Name, servicePrefix) {
+			containers = append(containers, container.Name)
+		}
+	}
+	for _, container := range containers {
+		fmt.Fprintf(buf, "==> pod %s container %s (%s) <==\n", pod.Name, container, pod.Status.Phase)
+		logs, err := podsClient.GetLogs(pod.Name, &core.PodLogOptions{
+			Container:  container,
+			SinceTime:  &sinceTime,
+			LimitBytes: &limitBytes,
+		}).DoRaw(context.TODO())
+		if err != nil {
+			// containers which did not start have no logs yet
+			fmt.Fprintf(buf, "failed to get logs %v\n", err)
+			continue
+		}
+		buf.Write(logs)
+		if len(logs) > 0 && logs[len(logs)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	if reason := podReason(&pod); reason != "" {
+		fmt.Fprintf(buf, "pod reason: %s\n", reason)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,36 @@
+package eks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLogs(t *testing.T) {
+	pod := buildPod(core.PodPending, core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ImagePullBackOff"}})
+	e := &AwsExecutorEKS{
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(pod)},
+	}
+	logs, err := e.Logs(getTestConfig(), time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, "==> pod 1234-abcde container launcher-1234 (Pending) <==\nfake logs\n"+
+		"==> pod 1234-abcde container 1234 (Pending) <==\nfake logs\n"+
+		"pod reason: ImagePullBackOff\n", string(logs))
+
+	// logs of services follow the build container
+	pod.Spec.Containers = []core.Container{{Name: "svc-postgres"}, {Name: "1234"}}
+	e = &AwsExecutorEKS{
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(pod)},
+	}
+	logs, _ = e.Logs(getTestConfig(), time.Now().Add(-time.Hour))
+	assert.Contains(t, string(logs), "==> pod 1234-abcde container 1234 (Pending) <==\nfake logs\n==> pod 1234-abcde container svc-postgres (Pending) <==\nfake logs\n")
+
+	e = &AwsExecutorEKS{
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset()},
+	}
+	_, err = e.Logs(getTestConfig(), time.Now())
+	assert.EqualError(t, err, "no pod found for build 1234")
+}
@@ -0,0 +1,47 @@
+package eks
+
+import (
+	"context"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BuildStats reports the ipv4 and ipv6 addresses of the build pod, pods of ipv6 clusters only get an ipv6 address
+func (e *AwsExecutorEKS) BuildStats(config map[string]interface{}) map[string]interface{} {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return nil
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	listPods, err := clientset.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+	if err != nil || len(listPods.Items) == 0 {
+		return nil
+	}
+	status := listPods.Items[0].Status
+	podIPs := make([]string, 0, len(status.PodIPs))
+	for _, podIP := range status.PodIPs {
+		podIPs = append(podIPs, podIP.IP)
+	}
+	if len(podIPs) == 0 && status.PodIP != "" {
+		podIPs = append(podIPs, status.PodIP)
+	}
+
+	stats := map[string]interface{}{}
+	for _, podIP := range podIPs {
+		ip := net.ParseIP(podIP)
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil:
+			stats["podIPv4"] = podIP
+		default:
+			stats["podIPv6"] = podIP
+		}
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+	return stats
+}
@@ -0,0 +1,48 @@
+package eks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBuildStats(t *testing.T) {
+	tests := []struct {
+		message  string
+		status   core.PodStatus
+		expected map[string]interface{}
+	}{
+		{
+			message:  "ipv4",
+			status:   core.PodStatus{PodIP: "10.0.1.12"},
+			expected: map[string]interface{}{"podIPv4": "10.0.1.12"},
+		},
+		{
+			message:  "ipv6",
+			status:   core.PodStatus{PodIP: "2600:1f14:abc::12", PodIPs: []core.PodIP{{IP: "2600:1f14:abc::12"}}},
+			expected: map[string]interface{}{"podIPv6": "2600:1f14:abc::12"},
+		},
+		{
+			message:  "dual stack",
+			status:   core.PodStatus{PodIPs: []core.PodIP{{IP: "10.0.1.12"}, {IP: "2600:1f14:abc::12"}}},
+			expected: map[string]interface{}{"podIPv4": "10.0.1.12", "podIPv6": "2600:1f14:abc::12"},
+		},
+		{
+			message: "no address yet",
+			status:  core.PodStatus{},
+		},
+	}
+	for _, test := range tests {
+		pod := buildPod(core.PodPending, core.ContainerState{})
+		pod.Status = test.status
+		executor := &AwsExecutorEKS{
+			k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(pod)},
+		}
+		assert.Equal(t, test.expected, executor.BuildStats(getTestConfig()), test.message)
+	}
+
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: fake.NewSimpleClientset()}}
+	assert.Nil(t, executor.BuildStats(getTestConfig()))
+}
@@ -0,0 +1,60 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checks if the node is ready and accepts new pods
+func isSchedulable(node core.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core.NodeReady {
+			return condition.Status == core.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Preflight checks that the cluster has pod capacity left before a build is started
+func (e *AwsExecutorEKS) Preflight(config map[string]interface{}) error {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return err
+	}
+	nodes, err := clientset.client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get nodes %v", err)
+	}
+	var capacity int64
+	for _, node := range nodes.Items {
+		if isSchedulable(node) {
+			capacity += node.Status.Allocatable.Pods().Value()
+		}
+	}
+
+	pods, err := clientset.client.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get pods %v", err)
+	}
+	var scheduled int64
+	for _, pod := range pods.Items {
+		// field selectors are not honoured by every client, filter the phase again
+		if pod.Status.Phase != core.PodSucceeded && pod.Status.Phase != core.PodFailed {
+			scheduled++
+		}
+	}
+	if scheduled >= capacity {
+		provider := config["provider"].(map[string]interface{})
+		return fmt.Errorf("no pod capacity left in cluster %v: %d of %d pods scheduled", provider["clusterName"], scheduled, capacity)
+	}
+
+	return nil
+}
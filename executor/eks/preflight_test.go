@@ -0,0 +1,79 @@
+package eks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func testNode(name string, pods string, ready core.ConditionStatus, unschedulable bool) *core.Node {
+	return &core.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       core.NodeSpec{Unschedulable: unschedulable},
+		Status: core.NodeStatus{
+			Allocatable: core.ResourceList{core.ResourcePods: resource.MustParse(pods)},
+			Conditions:  []core.NodeCondition{{Type: core.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func testPod(name string, phase core.PodPhase) *core.Pod {
+	return &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Status:     core.PodStatus{Phase: phase},
+	}
+}
+
+func TestIsSchedulable(t *testing.T) {
+	assert.True(t, isSchedulable(*testNode("ready", "2", core.ConditionTrue, false)))
+	assert.False(t, isSchedulable(*testNode("cordoned", "2", core.ConditionTrue, true)))
+	assert.False(t, isSchedulable(*testNode("notready", "2", core.ConditionFalse, false)))
+	assert.False(t, isSchedulable(core.Node{}))
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		message string
+		objects []runtime.Object
+		err     error
+	}{
+		{
+			message: "capacity left",
+			objects: []runtime.Object{
+				testNode("node-1", "2", core.ConditionTrue, false),
+				testPod("pod-1", core.PodRunning),
+				testPod("pod-2", core.PodSucceeded),
+			},
+		},
+		{
+			message: "no capacity left",
+			objects: []runtime.Object{
+				testNode("node-1", "2", core.ConditionTrue, false),
+				testNode("node-2", "10", core.ConditionFalse, false),
+				testPod("pod-1", core.PodRunning),
+				testPod("pod-2", core.PodPending),
+				testPod("pod-3", core.PodFailed),
+			},
+			err: errors.New("no pod capacity left in cluster test-cluster-1: 2 of 2 pods scheduled"),
+		},
+		{
+			message: "no nodes",
+			err:     errors.New("no pod capacity left in cluster test-cluster-1: 0 of 0 pods scheduled"),
+		},
+	}
+	for _, test := range tests {
+		executor := &AwsExecutorEKS{
+			k8sClientset: &k8sClientset{
+				client: fake.NewSimpleClientset(test.objects...),
+			},
+		}
+		err := executor.Preflight(getTestConfig())
+		assert.Equal(t, test.err, err, test.message)
+	}
+}
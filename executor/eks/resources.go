@@ -0,0 +1,50 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// Resources lists the pending and running build pods of the pipeline in the namespace of the provider,
+// found by their sdpipeline label
+func (e *AwsExecutorEKS) Resources(config map[string]interface{}) ([]executor.Resource, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return nil, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	selector := fmt.Sprintf("app=screwdriver,sdpipeline=%v", config["pipelineId"])
+	var pods *core.PodList
+	err = retryAPI("list pods", func() error {
+		var err error
+		pods, err = clientset.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods %v", err)
+	}
+	resources := []executor.Resource{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == core.PodSucceeded || pod.Status.Phase == core.PodFailed {
+			continue
+		}
+		resource := executor.Resource{
+			Type:    "eks-pod",
+			Name:    namespace + "/" + pod.Name,
+			BuildID: pod.Labels["sdbuild"],
+			Status:  string(pod.Status.Phase),
+		}
+		if pod.Status.StartTime != nil {
+			startedAt := pod.Status.StartTime.UTC()
+			resource.StartedAt = &startedAt
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
@@ -0,0 +1,46 @@
+package eks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestResources(t *testing.T) {
+	assert.Equal(t, "12345", getPodObject(getTestConfig(), testNamespace).Labels["sdpipeline"])
+
+	startedAt := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	running := buildPod(core.PodRunning, core.ContainerState{})
+	running.Labels["sdpipeline"] = "12345"
+	running.Status.StartTime = &metav1.Time{Time: startedAt}
+	pending := buildPod(core.PodPending, core.ContainerState{})
+	pending.Name = "1235-abcde"
+	pending.Labels = map[string]string{"app": "screwdriver", "sdbuild": "1235", "sdpipeline": "12345"}
+	finished := buildPod(core.PodSucceeded, core.ContainerState{})
+	finished.Name = "1233-abcde"
+	finished.Labels["sdpipeline"] = "12345"
+	other := buildPod(core.PodRunning, core.ContainerState{})
+	other.Name = "1236-abcde"
+	other.Labels["sdpipeline"] = "54321"
+
+	client := fake.NewSimpleClientset(running, pending, finished, other)
+	e := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: client}}
+	resources, err := e.Resources(getTestConfig())
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []executor.Resource{
+		{Type: "eks-pod", Name: testNamespace + "/1234-abcde", BuildID: "1234", Status: "Running", StartedAt: &startedAt},
+		{Type: "eks-pod", Name: testNamespace + "/1235-abcde", BuildID: "1235", Status: "Pending"},
+	}, resources)
+
+	// pods of a pipeline without builds are an empty list
+	e = &AwsExecutorEKS{k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(other, finished)}}
+	resources, err = e.Resources(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, []executor.Resource{}, resources)
+}
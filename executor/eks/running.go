@@ -0,0 +1,49 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// interval between pod status polls
+var pollInterval = 2 * time.Second
+
+// WaitRunning waits for the build container of the pod to run and returns the time it started
+func (e *AwsExecutorEKS) WaitRunning(config map[string]interface{}, timeout time.Duration) (time.Time, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return time.Time{}, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	buildIDStr := fmt.Sprint(buildID)
+	podsClient := clientset.client.CoreV1().Pods(namespace)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		listPods, err := podsClient.List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to get pods %v", err)
+		}
+		for _, pod := range listPods.Items {
+			if pod.Status.Phase == core.PodFailed || pod.Status.Phase == core.PodSucceeded {
+				return time.Time{}, fmt.Errorf("pod %v finished with phase %v before running", pod.Name, pod.Status.Phase)
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == buildIDStr && status.State.Running != nil {
+					return status.State.Running.StartedAt.Time, nil
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, fmt.Errorf("timed out after %v waiting for build to run", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
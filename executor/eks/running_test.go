@@ -0,0 +1,66 @@
+package eks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func buildPod(phase core.PodPhase, state core.ContainerState) *core.Pod {
+	return &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "1234-abcde",
+			Namespace: testNamespace,
+			Labels:    map[string]string{"app": "screwdriver", "tier": "builds", "sdbuild": "1234"},
+		},
+		Status: core.PodStatus{
+			Phase:             phase,
+			ContainerStatuses: []core.ContainerStatus{{Name: "1234", State: state}},
+		},
+	}
+}
+
+func TestWaitRunning(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 2 * time.Second }()
+	startedAt := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		message  string
+		pod      *core.Pod
+		expected time.Time
+		err      string
+	}{
+		{
+			message:  "running",
+			pod:      buildPod(core.PodRunning, core.ContainerState{Running: &core.ContainerStateRunning{StartedAt: metav1.NewTime(startedAt)}}),
+			expected: startedAt,
+		},
+		{
+			message: "pending",
+			pod:     buildPod(core.PodPending, core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ContainerCreating"}}),
+			err:     "timed out after 5ms waiting for build to run",
+		},
+		{
+			message: "failed",
+			pod:     buildPod(core.PodFailed, core.ContainerState{}),
+			err:     "pod 1234-abcde finished with phase Failed before running",
+		},
+	}
+	for _, test := range tests {
+		executor := &AwsExecutorEKS{
+			k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(test.pod)},
+		}
+		started, err := executor.WaitRunning(getTestConfig(), 5*time.Millisecond)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.message)
+			continue
+		}
+		assert.Nil(t, err, test.message)
+		assert.True(t, test.expected.Equal(started), test.message)
+	}
+}
@@ -0,0 +1,80 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// annotation binding a service account to an IAM role (IRSA)
+const roleArnAnnotation = "eks.amazonaws.com/role-arn"
+
+// creates or updates the service account of the pipeline bound to the scoped role, returns its name
+func ensureServiceAccount(clientset *k8sClientset, namespace string, config map[string]interface{}, roleArn string) (string, error) {
+	pipelineID, _ := config["pipelineId"].(json.Number).Int64()
+	name := fmt.Sprintf("sd-pipeline-%d", pipelineID)
+	accounts := clientset.client.CoreV1().ServiceAccounts(namespace)
+
+	var account *core.ServiceAccount
+	err := retryAPI("get service account", func() error {
+		var err error
+		account, err = accounts.Get(context.TODO(), name, metav1.GetOptions{})
+		return err
+	})
+	if k8serrors.IsNotFound(err) {
+		err = retryAPI("create service account", func() error {
+			_, err := accounts.Create(context.TODO(), &core.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					Labels:      map[string]string{"app": "screwdriver", "sdpipeline": fmt.Sprint(pipelineID)},
+					Annotations: map[string]string{roleArnAnnotation: roleArn},
+				},
+			}, metav1.CreateOptions{})
+			return err
+		})
+		// a concurrent build of the pipeline created it
+		if k8serrors.IsAlreadyExists(err) {
+			return name, nil
+		}
+		if err != nil {
+			return "", executor.Errorf(apiCategory(err), "failed to create service account %v", err)
+		}
+		return name, nil
+	}
+	if err != nil {
+		return "", executor.Errorf(apiCategory(err), "failed to get service account %v", err)
+	}
+	if account.Annotations[roleArnAnnotation] != roleArn {
+		if err := retryAPI("update service account", func() error {
+			// a conflict means the account changed since it was read, it is read again before retrying
+			if account == nil {
+				var err error
+				if account, err = accounts.Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+					return err
+				}
+				if account.Annotations[roleArnAnnotation] == roleArn {
+					return nil
+				}
+			}
+			if account.Annotations == nil {
+				account.Annotations = map[string]string{}
+			}
+			account.Annotations[roleArnAnnotation] = roleArn
+			_, err := accounts.Update(context.TODO(), account, metav1.UpdateOptions{})
+			if k8serrors.IsConflict(err) {
+				account = nil
+			}
+			return err
+		}); err != nil {
+			return "", executor.Errorf(apiCategory(err), "failed to update service account %v", err)
+		}
+	}
+	return name, nil
+}
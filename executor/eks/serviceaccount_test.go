@@ -0,0 +1,78 @@
+package eks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestEnsureServiceAccount(t *testing.T) {
+	roleArn := "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-12345"
+	kubeclient := fake.NewSimpleClientset(&core.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "sd-pipeline-12345", Namespace: "stale"},
+	})
+	clientset := &k8sClientset{client: kubeclient}
+
+	for _, namespace := range []string{testNamespace, "stale"} {
+		name, err := ensureServiceAccount(clientset, namespace, getTestConfig(), roleArn)
+		assert.Nil(t, err, namespace)
+		assert.Equal(t, "sd-pipeline-12345", name, namespace)
+
+		account, err := kubeclient.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		assert.Nil(t, err, namespace)
+		assert.Equal(t, roleArn, account.Annotations["eks.amazonaws.com/role-arn"], namespace)
+	}
+}
+
+func TestEnsureServiceAccountConflict(t *testing.T) {
+	defer func(interval time.Duration) { apiRetryInterval = interval }(apiRetryInterval)
+	apiRetryInterval = time.Millisecond
+	roleArn := "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-12345"
+	kubeclient := fake.NewSimpleClientset(&core.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "sd-pipeline-12345", Namespace: testNamespace, ResourceVersion: "1"},
+	})
+	gets, updates := 0, 0
+	kubeclient.PrependReactor("get", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	kubeclient.PrependReactor("update", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		// another build updated the account after it was read
+		if updates == 1 {
+			resource := schema.GroupResource{Resource: "serviceaccounts"}
+			return true, nil, k8serrors.NewConflict(resource, "sd-pipeline-12345", errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	_, err := ensureServiceAccount(&k8sClientset{client: kubeclient}, testNamespace, getTestConfig(), roleArn)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, updates)
+	// the account is read again before the update is retried
+	assert.Equal(t, 2, gets)
+}
+
+func TestStartScopedRole(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: kubeclient}}
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["scopedRole"] = "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-12345"
+
+	_, err := executor.Start(config)
+	assert.Nil(t, err)
+
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, 1, len(pods.Items))
+	assert.Equal(t, "sd-pipeline-12345", pods.Items[0].Spec.ServiceAccountName)
+}
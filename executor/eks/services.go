@@ -0,0 +1,136 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// prefix of the container names of services
+	servicePrefix = "svc-"
+	// serviceReadyTimeoutEnv is how long the build waits for a service to get ready
+	serviceReadyTimeoutEnv = "SD_EKS_SERVICE_READY_TIMEOUT_SECS"
+	// defaultServiceReadyTimeout is the wait for a service to get ready in seconds when SD_EKS_SERVICE_READY_TIMEOUT_SECS is unset
+	defaultServiceReadyTimeout = 120
+)
+
+// service is an auxiliary container running alongside the build container, like a database
+type service struct {
+	name  string
+	image string
+	port  int32
+	ready string
+	env   map[string]string
+}
+
+// gets the seconds the build waits for a service to get ready
+func serviceReadyTimeout() int {
+	if timeout, err := strconv.Atoi(os.Getenv(serviceReadyTimeoutEnv)); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultServiceReadyTimeout
+}
+
+// gets the services of the provider
+func getServices(provider map[string]interface{}) ([]service, error) {
+	list, ok := provider["services"].([]interface{})
+	if !ok {
+		if provider["services"] != nil {
+			return nil, fmt.Errorf("services must be a list of services")
+		}
+		return nil, nil
+	}
+	var services []service
+	names := map[string]bool{}
+	for i, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("services[%d] must be an object", i)
+		}
+		s := service{env: map[string]string{}}
+		s.name, _ = fields["name"].(string)
+		if errs := validation.IsDNS1123Label(servicePrefix + s.name); s.name == "" || len(errs) > 0 {
+			return nil, fmt.Errorf("services[%d].name %q must be a lowercase dns label", i, s.name)
+		}
+		if names[s.name] {
+			return nil, fmt.Errorf("services[%d].name %q is not unique", i, s.name)
+		}
+		names[s.name] = true
+		if s.image, _ = fields["image"].(string); s.image == "" {
+			return nil, fmt.Errorf("services[%d].image is required", i)
+		}
+		if port, ok := fields["port"]; ok {
+			number, _ := port.(json.Number)
+			value, err := number.Int64()
+			if err != nil || value < 1 || value > 65535 {
+				return nil, fmt.Errorf("services[%d].port %v must be a port number", i, port)
+			}
+			s.port = int32(value)
+		}
+		s.ready, _ = fields["ready"].(string)
+		if env, ok := fields["env"].(map[string]interface{}); ok {
+			for k, v := range env {
+				s.env[k] = fmt.Sprint(v)
+			}
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// gets the container of a service. A service with a ready command gates the build container:
+// the kubelet starts containers in order and waits for the post start hook, which waits for the service.
+func serviceContainer(s service) core.Container {
+	container := core.Container{
+		Name:  servicePrefix + s.name,
+		Image: s.image,
+	}
+	keys := make([]string, 0, len(s.env))
+	for k := range s.env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		container.Env = append(container.Env, core.EnvVar{Name: k, Value: s.env[k]})
+	}
+	if s.port > 0 {
+		container.Ports = []core.ContainerPort{{Name: "tcp", Protocol: core.ProtocolTCP, ContainerPort: s.port}}
+		container.ReadinessProbe = &core.Probe{
+			Handler:       core.Handler{TCPSocket: &core.TCPSocketAction{Port: intstr.FromInt(int(s.port))}},
+			PeriodSeconds: 2,
+		}
+	}
+	if s.ready != "" {
+		container.ReadinessProbe = &core.Probe{
+			Handler:       core.Handler{Exec: &core.ExecAction{Command: []string{"/bin/sh", "-c", s.ready}}},
+			PeriodSeconds: 2,
+		}
+		wait := fmt.Sprintf("i=0; until %s; do i=$((i+1)); if [ $i -ge %d ]; then exit 1; fi; sleep 1; done", s.ready, serviceReadyTimeout())
+		container.Lifecycle = &core.Lifecycle{PostStart: &core.Handler{Exec: &core.ExecAction{Command: []string{"/bin/sh", "-c", wait}}}}
+	}
+	return container
+}
+
+// addServices runs the services of the build in its pod, ahead of the build container so ready services gate it
+func addServices(pod *core.Pod, config map[string]interface{}) error {
+	services, err := getServices(config["provider"].(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	containers := make([]core.Container, 0, len(services)+len(pod.Spec.Containers))
+	for _, s := range services {
+		containers = append(containers, serviceContainer(s))
+	}
+	pod.Spec.Containers = append(containers, pod.Spec.Containers...)
+	return nil
+}
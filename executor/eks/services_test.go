@@ -0,0 +1,90 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func testServices() []interface{} {
+	return []interface{}{
+		map[string]interface{}{"name": "postgres", "image": "postgres:14", "port": json.Number("5432"), "ready": "pg_isready -U postgres",
+			"env": map[string]interface{}{"POSTGRES_PASSWORD": "sd", "POSTGRES_DB": "test"}},
+		map[string]interface{}{"name": "redis", "image": "redis:7", "port": json.Number("6379")},
+	}
+}
+
+func TestGetServices(t *testing.T) {
+	services, err := getServices(map[string]interface{}{"services": testServices()})
+	assert.Nil(t, err)
+	assert.Equal(t, []service{
+		{name: "postgres", image: "postgres:14", port: 5432, ready: "pg_isready -U postgres", env: map[string]string{"POSTGRES_PASSWORD": "sd", "POSTGRES_DB": "test"}},
+		{name: "redis", image: "redis:7", port: 6379, env: map[string]string{}},
+	}, services)
+
+	services, err = getServices(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Nil(t, services)
+
+	tests := map[string]interface{}{
+		"services must be a list of services":                          "postgres",
+		"services[0] must be an object":                                []interface{}{"postgres"},
+		`services[0].name "Postgres_DB" must be a lowercase dns label`: []interface{}{map[string]interface{}{"name": "Postgres_DB", "image": "postgres:14"}},
+		`services[1].name "redis" is not unique`: []interface{}{
+			map[string]interface{}{"name": "redis", "image": "redis:7"}, map[string]interface{}{"name": "redis", "image": "redis:6"},
+		},
+		"services[0].image is required":                []interface{}{map[string]interface{}{"name": "redis"}},
+		"services[0].port 70000 must be a port number": []interface{}{map[string]interface{}{"name": "redis", "image": "redis:7", "port": json.Number("70000")}},
+	}
+	for expected, list := range tests {
+		_, err := getServices(map[string]interface{}{"services": list})
+		assert.EqualError(t, err, expected)
+	}
+}
+
+func TestServiceContainer(t *testing.T) {
+	t.Setenv("SD_EKS_SERVICE_READY_TIMEOUT_SECS", "30")
+	container := serviceContainer(service{name: "postgres", image: "postgres:14", port: 5432, ready: "pg_isready -U postgres",
+		env: map[string]string{"POSTGRES_PASSWORD": "sd", "POSTGRES_DB": "test"}})
+	assert.Equal(t, "svc-postgres", container.Name)
+	assert.Equal(t, []core.EnvVar{{Name: "POSTGRES_DB", Value: "test"}, {Name: "POSTGRES_PASSWORD", Value: "sd"}}, container.Env)
+	assert.Equal(t, int32(5432), container.Ports[0].ContainerPort)
+	assert.Equal(t, []string{"/bin/sh", "-c", "pg_isready -U postgres"}, container.ReadinessProbe.Exec.Command)
+	assert.Equal(t, []string{"/bin/sh", "-c", "i=0; until pg_isready -U postgres; do i=$((i+1)); if [ $i -ge 30 ]; then exit 1; fi; sleep 1; done"},
+		container.Lifecycle.PostStart.Exec.Command)
+
+	// services without a ready command do not gate the build
+	container = serviceContainer(service{name: "redis", image: "redis:7", port: 6379})
+	assert.Equal(t, intstr.FromInt(6379), container.ReadinessProbe.TCPSocket.Port)
+	assert.Nil(t, container.Lifecycle)
+}
+
+func TestStartServices(t *testing.T) {
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["services"] = testServices()
+	_, err := executor.Start(config)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	var names []string
+	for _, container := range pods.Items[0].Spec.Containers {
+		names = append(names, container.Name)
+	}
+	assert.Equal(t, []string{"svc-postgres", "svc-redis", "1234"}, names)
+
+	config = getTestConfig()
+	config["provider"].(map[string]interface{})["services"] = []interface{}{map[string]interface{}{"name": "redis"}}
+	_, err = executor.Start(config)
+	assert.EqualError(t, err, "services[0].image is required")
+}
@@ -0,0 +1,80 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// gets the reason of the build container, preferring the pod reason and OOMKilled containers
+func podReason(pod *core.Pod) string {
+	if pod.Status.Reason != "" {
+		if pod.Status.Message != "" {
+			return pod.Status.Reason + ": " + pod.Status.Message
+		}
+		return pod.Status.Reason
+	}
+	for _, exit := range containerExits(pod) {
+		if exit.OOMKilled {
+			return executor.ExitMessage([]executor.ContainerExit{exit})
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		switch {
+		case status.State.Waiting != nil && status.State.Waiting.Reason != "":
+			return status.State.Waiting.Reason
+		case status.State.Terminated != nil && status.State.Terminated.Reason != "":
+			return fmt.Sprintf("%s with exit code %d", status.State.Terminated.Reason, status.State.Terminated.ExitCode)
+		}
+	}
+	return ""
+}
+
+// Status gets the normalized state of the build pod from its phase
+func (e *AwsExecutorEKS) Status(config map[string]interface{}) (executor.Status, error) {
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return executor.Status{}, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+
+	listPods, err := clientset.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+	if err != nil {
+		return executor.Status{}, fmt.Errorf("failed to get pods %v", err)
+	}
+	if len(listPods.Items) == 0 {
+		return executor.Status{}, fmt.Errorf("no pod found for build %d", buildID)
+	}
+	pod := &listPods.Items[0]
+	switch pod.Status.Phase {
+	case core.PodPending:
+		return executor.Status{State: executor.Queued, Reason: podReason(pod)}, nil
+	case core.PodSucceeded:
+		return executor.Status{State: executor.Succeeded}, nil
+	case core.PodFailed:
+		return executor.Status{State: executor.Failed, Reason: podReason(pod)}, nil
+	case core.PodRunning:
+		// services keep the pod running after the build container finished
+		for _, status := range pod.Status.ContainerStatuses {
+			if terminated := status.State.Terminated; status.Name == fmt.Sprint(buildID) && terminated != nil {
+				if terminated.ExitCode == 0 {
+					return executor.Status{State: executor.Succeeded}, nil
+				}
+				if terminated.Reason == oomKilledReason {
+					return executor.Status{State: executor.Failed, Reason: podReason(pod)}, nil
+				}
+				return executor.Status{State: executor.Failed, Reason: fmt.Sprintf("%s with exit code %d", terminated.Reason, terminated.ExitCode)}, nil
+			}
+		}
+		return executor.Status{State: executor.Running}, nil
+	default:
+		return executor.Status{State: executor.Running, Reason: "pod status is unknown"}, nil
+	}
+}
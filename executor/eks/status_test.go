@@ -0,0 +1,92 @@
+package eks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestStatus(t *testing.T) {
+	evicted := buildPod(core.PodFailed, core.ContainerState{})
+	evicted.Status.Reason = "Evicted"
+	evicted.Status.Message = "The node was low on resource: memory."
+	serviceOOMKilled := buildPod(core.PodFailed, core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "Error", ExitCode: 1}})
+	serviceOOMKilled.Status.ContainerStatuses = append(serviceOOMKilled.Status.ContainerStatuses, core.ContainerStatus{
+		Name: "redis", State: core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+	})
+
+	tests := []struct {
+		message  string
+		pod      *core.Pod
+		expected executor.Status
+	}{
+		{
+			message:  "pending",
+			pod:      buildPod(core.PodPending, core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: "ContainerCreating"}}),
+			expected: executor.Status{State: executor.Queued, Reason: "ContainerCreating"},
+		},
+		{
+			message:  "running",
+			pod:      buildPod(core.PodRunning, core.ContainerState{Running: &core.ContainerStateRunning{}}),
+			expected: executor.Status{State: executor.Running},
+		},
+		{
+			message:  "succeeded",
+			pod:      buildPod(core.PodSucceeded, core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "Completed"}}),
+			expected: executor.Status{State: executor.Succeeded},
+		},
+		{
+			message:  "failed",
+			pod:      buildPod(core.PodFailed, core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "Error", ExitCode: 2}}),
+			expected: executor.Status{State: executor.Failed, Reason: "Error with exit code 2"},
+		},
+		{
+			message:  "evicted",
+			pod:      evicted,
+			expected: executor.Status{State: executor.Failed, Reason: "Evicted: The node was low on resource: memory."},
+		},
+		{
+			message:  "running services after the build succeeded",
+			pod:      buildPod(core.PodRunning, core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "Completed"}}),
+			expected: executor.Status{State: executor.Succeeded},
+		},
+		{
+			message:  "running services after the build failed",
+			pod:      buildPod(core.PodRunning, core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}),
+			expected: executor.Status{State: executor.Failed, Reason: "Error with exit code 1"},
+		},
+		{
+			message:  "service OOMKilled",
+			pod:      serviceOOMKilled,
+			expected: executor.Status{State: executor.Failed, Reason: "Container redis was OOMKilled with exit code 137"},
+		},
+		{
+			message:  "running services after the build was OOMKilled",
+			pod:      buildPod(core.PodRunning, core.ContainerState{Terminated: &core.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}}),
+			expected: executor.Status{State: executor.Failed, Reason: "Container 1234 was OOMKilled with exit code 137"},
+		},
+		{
+			message:  "unknown",
+			pod:      buildPod(core.PodUnknown, core.ContainerState{}),
+			expected: executor.Status{State: executor.Running, Reason: "pod status is unknown"},
+		},
+	}
+	for _, test := range tests {
+		e := &AwsExecutorEKS{
+			k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(test.pod)},
+		}
+		status, err := e.Status(getTestConfig())
+		assert.Nil(t, err, test.message)
+		assert.Equal(t, test.expected, status, test.message)
+	}
+
+	e := &AwsExecutorEKS{
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset()},
+	}
+	_, err := e.Status(getTestConfig())
+	assert.EqualError(t, err, "no pod found for build 1234")
+}
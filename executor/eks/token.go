@@ -0,0 +1,60 @@
+package eks
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+)
+
+const (
+	// tokenImageEnv is the image with the aws cli reading the token secret of the build
+	tokenImageEnv = "SD_EKS_TOKEN_IMAGE"
+
+	defaultTokenImage = "public.ecr.aws/aws-cli/aws-cli:2.13.0"
+	// tokenPath is the file the build reads its token from
+	tokenPath = "/opt/sd_token/token"
+)
+
+// setTokenSecret makes the build read its token from its secret instead of the pod spec. An init container running
+// with the service account of the scoped role writes the token to a memory backed volume of the build container.
+func setTokenSecret(pod *core.Pod, config map[string]interface{}) {
+	arn, _ := config[buildtoken.ArnKey].(string)
+	if arn == "" {
+		return
+	}
+	image := os.Getenv(tokenImageEnv)
+	if image == "" {
+		image = defaultTokenImage
+	}
+	region := ""
+	if parts := strings.Split(arn, ":"); len(parts) > 3 {
+		region = parts[3]
+	}
+	mount := core.VolumeMount{Name: "sdtoken", MountPath: "/opt/sd_token"}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, core.Volume{Name: "sdtoken", VolumeSource: core.VolumeSource{
+		EmptyDir: &core.EmptyDirVolumeSource{Medium: core.StorageMediumMemory},
+	}})
+	buildID := fmt.Sprint(config["buildId"])
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, core.Container{
+		Name:  "token-" + buildID,
+		Image: image,
+		Command: []string{"/bin/sh", "-c", fmt.Sprintf("aws secretsmanager get-secret-value --region %s --secret-id %s --query SecretString --output text > %s",
+			region, arn, tokenPath)},
+		VolumeMounts: []core.VolumeMount{mount},
+	})
+	mount.ReadOnly = true
+	token := config["token"].(string)
+	for i, container := range pod.Spec.Containers {
+		if container.Name != buildID {
+			continue
+		}
+		for j, arg := range container.Args {
+			pod.Spec.Containers[i].Args[j] = strings.Replace(arg, token, fmt.Sprintf(`"$(cat %s)"`, tokenPath), 1)
+		}
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, mount)
+	}
+}
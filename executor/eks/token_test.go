@@ -0,0 +1,32 @@
+package eks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+)
+
+func TestSetTokenSecret(t *testing.T) {
+	config := getTestConfig()
+	pod := getPodObject(config, testNamespace)
+	setTokenSecret(pod, config)
+	assert.Equal(t, 1, len(pod.Spec.InitContainers))
+
+	config["tokenSecretArn"] = "arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-tokens/1234-AbCdEf"
+	pod = getPodObject(config, testNamespace)
+	setTokenSecret(pod, config)
+
+	assert.Equal(t, core.Volume{Name: "sdtoken", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{Medium: core.StorageMediumMemory}}},
+		pod.Spec.Volumes[len(pod.Spec.Volumes)-1])
+	token := pod.Spec.InitContainers[1]
+	assert.Equal(t, "token-1234", token.Name)
+	assert.Equal(t, "public.ecr.aws/aws-cli/aws-cli:2.13.0", token.Image)
+	assert.Equal(t, "aws secretsmanager get-secret-value --region us-west-2 --secret-id arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-tokens/1234-AbCdEf --query SecretString --output text > /opt/sd_token/token", token.Command[2])
+
+	build := pod.Spec.Containers[0]
+	assert.False(t, strings.Contains(build.Args[0], config["token"].(string)))
+	assert.True(t, strings.HasPrefix(build.Args[0], `/opt/sd/run.sh "$(cat /opt/sd_token/token)" `))
+	assert.Equal(t, core.VolumeMount{Name: "sdtoken", MountPath: "/opt/sd_token", ReadOnly: true}, build.VolumeMounts[len(build.VolumeMounts)-1])
+}
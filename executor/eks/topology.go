@@ -0,0 +1,58 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// zoneSpreadEnv is the maximum skew of builds between zones of builds without a provider.zoneSpreadMaxSkew
+	zoneSpreadEnv = "SD_EKS_ZONE_SPREAD_MAX_SKEW"
+	// zoneSpreadStrictEnv keeps builds pending rather than exceeding the skew
+	zoneSpreadStrictEnv = "SD_EKS_ZONE_SPREAD_STRICT"
+)
+
+// gets the maximum skew of builds between zones, zero when builds are not spread
+func zoneSpreadMaxSkew(provider map[string]interface{}) (int32, error) {
+	value := os.Getenv(zoneSpreadEnv)
+	source := zoneSpreadEnv
+	if skew, ok := provider["zoneSpreadMaxSkew"]; ok {
+		value, source = fmt.Sprint(skew), "zoneSpreadMaxSkew"
+		if number, ok := skew.(json.Number); ok {
+			value = number.String()
+		}
+	}
+	if value == "" {
+		return 0, nil
+	}
+	skew, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || skew < 0 {
+		return 0, fmt.Errorf("invalid %s %q, it must be a positive number", source, value)
+	}
+	return int32(skew), nil
+}
+
+// setZoneSpread spreads the builds of the cluster across zones, so fan-out events don't saturate the node group
+// of a single zone. The spread is best effort unless it is strict.
+func setZoneSpread(pod *core.Pod, provider map[string]interface{}) error {
+	skew, err := zoneSpreadMaxSkew(provider)
+	if err != nil || skew == 0 {
+		return err
+	}
+	whenUnsatisfiable := core.ScheduleAnyway
+	if strict, _ := strconv.ParseBool(os.Getenv(zoneSpreadStrictEnv)); strict {
+		whenUnsatisfiable = core.DoNotSchedule
+	}
+	pod.Spec.TopologySpreadConstraints = []core.TopologySpreadConstraint{{
+		MaxSkew:           skew,
+		TopologyKey:       core.LabelZoneFailureDomainStable,
+		WhenUnsatisfiable: whenUnsatisfiable,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "screwdriver", "tier": "builds"}},
+	}}
+	return nil
+}
@@ -0,0 +1,55 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestZoneSpreadMaxSkew(t *testing.T) {
+	skew, err := zoneSpreadMaxSkew(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), skew)
+
+	t.Setenv("SD_EKS_ZONE_SPREAD_MAX_SKEW", "2")
+	skew, _ = zoneSpreadMaxSkew(map[string]interface{}{})
+	assert.Equal(t, int32(2), skew)
+	// the provider takes precedence, zero turns the spread off
+	skew, _ = zoneSpreadMaxSkew(map[string]interface{}{"zoneSpreadMaxSkew": json.Number("0")})
+	assert.Equal(t, int32(0), skew)
+
+	_, err = zoneSpreadMaxSkew(map[string]interface{}{"zoneSpreadMaxSkew": "wide"})
+	assert.EqualError(t, err, `invalid zoneSpreadMaxSkew "wide", it must be a positive number`)
+	t.Setenv("SD_EKS_ZONE_SPREAD_MAX_SKEW", "-1")
+	_, err = zoneSpreadMaxSkew(map[string]interface{}{})
+	assert.EqualError(t, err, `invalid SD_EKS_ZONE_SPREAD_MAX_SKEW "-1", it must be a positive number`)
+}
+
+func TestStartZoneSpread(t *testing.T) {
+	t.Setenv("SD_EKS_ZONE_SPREAD_MAX_SKEW", "1")
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	_, err := executor.Start(getTestConfig())
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, []core.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: core.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "screwdriver", "tier": "builds"}},
+	}}, pods.Items[0].Spec.TopologySpreadConstraints)
+
+	t.Setenv("SD_EKS_ZONE_SPREAD_STRICT", "true")
+	pod := getPodObject(getTestConfig(), testNamespace)
+	assert.Nil(t, setZoneSpread(pod, getTestConfig()["provider"].(map[string]interface{})))
+	assert.Equal(t, core.DoNotSchedule, pod.Spec.TopologySpreadConstraints[0].WhenUnsatisfiable)
+}
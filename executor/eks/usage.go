@@ -0,0 +1,192 @@
+package eks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/screwdriver-cd/aws-consumer-service/annotations"
+	"github.com/screwdriver-cd/aws-consumer-service/sizing"
+)
+
+// usageMetricsEnv selects where the cpu and memory usage of build pods is read for sizing recommendations,
+// metrics-server or container-insights, no recommendations are made when unset
+const usageMetricsEnv = "SD_EKS_USAGE_METRICS"
+
+const (
+	usageMetricsServer     = "metrics-server"
+	usageContainerInsights = "container-insights"
+	// namespace of the metrics of cloudwatch container insights
+	containerInsightsNamespace = "ContainerInsights"
+	// cloudwatch returns at most 1440 datapoints per call
+	maxDatapoints = 1440
+	mebibyte      = 1024 * 1024
+)
+
+// gets the source of the usage of build pods, empty when no recommendations are made
+func usageMetrics() string {
+	return strings.TrimSpace(os.Getenv(usageMetricsEnv))
+}
+
+// reads the pod metrics of metrics-server, a variable as fake clientsets have no rest client
+var getPodMetrics = func(client kubernetes.Interface, namespace string, name string) ([]byte, error) {
+	return client.CoreV1().RESTClient().Get().AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", namespace, "pods", name).DoRaw(context.TODO())
+}
+
+// pod metrics of metrics-server
+type podMetrics struct {
+	Containers []struct {
+		Name  string            `json:"name"`
+		Usage core.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+// gets the current usage of the build container from metrics-server, ok is false once the container finished
+func metricsServerUsage(client kubernetes.Interface, pod *core.Pod, container string) (usage sizing.Size, ok bool, err error) {
+	raw, err := getPodMetrics(client, pod.Namespace, pod.Name)
+	if err != nil {
+		return sizing.Size{}, false, fmt.Errorf("failed to get pod metrics %v", err)
+	}
+	var metrics podMetrics
+	if err := json.Unmarshal(raw, &metrics); err != nil {
+		return sizing.Size{}, false, fmt.Errorf("failed to decode pod metrics %v", err)
+	}
+	for _, c := range metrics.Containers {
+		if c.Name != container {
+			continue
+		}
+		return sizing.Size{
+			CPU:       float64(c.Usage.Cpu().MilliValue()) / 1000,
+			MemoryMiB: c.Usage.Memory().Value() / mebibyte,
+		}, true, nil
+	}
+	return sizing.Size{}, false, nil
+}
+
+// gets the maximum of a container insights pod metric since the pod was created, ok is false without datapoints
+func (c *eksClient) maxPodMetric(metric string, cluster string, pod *core.Pod, end time.Time) (float64, bool, error) {
+	start := pod.CreationTimestamp.Time
+	period := int64(60)
+	if minutes := int64(end.Sub(start).Minutes()); minutes > maxDatapoints {
+		period *= int64(math.Ceil(float64(minutes) / maxDatapoints))
+	}
+	output, err := c.cloudwatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(containerInsightsNamespace),
+		MetricName: aws.String(metric),
+		Dimensions: []*cloudwatch.Dimension{
+			{Name: aws.String("ClusterName"), Value: aws.String(cluster)},
+			{Name: aws.String("Namespace"), Value: aws.String(pod.Namespace)},
+			{Name: aws.String("PodName"), Value: aws.String(pod.Name)},
+		},
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int64(period),
+		Statistics: aws.StringSlice([]string{cloudwatch.StatisticMaximum}),
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("Error-GetMetricStatistics: %v", err)
+	}
+	max, ok := 0.0, false
+	for _, datapoint := range output.Datapoints {
+		max, ok = math.Max(max, aws.Float64Value(datapoint.Maximum)), true
+	}
+	return max, ok, nil
+}
+
+// gets the peak usage of the pod from container insights, the utilization of its limit over the life of the pod.
+// The limit of a pod is the sum of the limits of its build and service containers.
+func (c *eksClient) containerInsightsUsage(cluster string, pod *core.Pod, end time.Time) (usage sizing.Size, ok bool, err error) {
+	if c == nil || c.cloudwatch == nil {
+		return sizing.Size{}, false, errors.New("cloudwatch is not available")
+	}
+	var cpuLimit, memoryLimit int64
+	for _, container := range pod.Spec.Containers {
+		cpuLimit += container.Resources.Limits.Cpu().MilliValue()
+		memoryLimit += container.Resources.Limits.Memory().Value()
+	}
+	cpu, hasCPU, err := c.maxPodMetric("pod_cpu_utilization_over_pod_limit", cluster, pod, end)
+	if err != nil {
+		return sizing.Size{}, false, err
+	}
+	memory, hasMemory, err := c.maxPodMetric("pod_memory_utilization_over_pod_limit", cluster, pod, end)
+	if err != nil {
+		return sizing.Size{}, false, err
+	}
+	if !hasCPU || !hasMemory {
+		return sizing.Size{}, false, nil
+	}
+	return sizing.Size{
+		CPU:       cpu / 100 * float64(cpuLimit) / 1000,
+		MemoryMiB: int64(memory / 100 * float64(memoryLimit) / mebibyte),
+	}, true, nil
+}
+
+// SizingRecommendation reports the peak cpu and memory usage of the build pod read from SD_EKS_USAGE_METRICS, with the
+// cpu and ram annotations fitting it. Nil when no recommendations are made or the usage is unknown.
+func (e *AwsExecutorEKS) SizingRecommendation(config map[string]interface{}) (map[string]interface{}, error) {
+	source := usageMetrics()
+	if source == "" {
+		return nil, nil
+	}
+	clientset, err := e.newClientSet(config)
+	if err != nil {
+		return nil, err
+	}
+	provider := config["provider"].(map[string]interface{})
+	namespace := provider["namespace"].(string)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+
+	listPods, err := clientset.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: buildSelector(config)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods %v", err)
+	}
+	if len(listPods.Items) == 0 {
+		return nil, nil
+	}
+	pod := &listPods.Items[0]
+
+	var usage sizing.Size
+	var ok bool
+	switch source {
+	case usageMetricsServer:
+		usage, ok, err = metricsServerUsage(clientset.client, pod, fmt.Sprint(buildID))
+	case usageContainerInsights:
+		clusterName, _ := config["clusterName"].(string)
+		usage, ok, err = e.eksClient.containerInsightsUsage(clusterName, pod, time.Now())
+	default:
+		return nil, fmt.Errorf("unknown %s %q, valid sources are %s and %s", usageMetricsEnv, source, usageMetricsServer, usageContainerInsights)
+	}
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	recommended := sizing.Recommend(usage)
+	recommendation := map[string]interface{}{
+		"source":         source,
+		"cpuUsage":       math.Round(usage.CPU*100) / 100,
+		"memoryUsageMiB": usage.MemoryMiB,
+		"annotations": map[string]string{
+			annotations.Prefix + "cpu": strconv.FormatFloat(recommended.CPU, 'f', -1, 64),
+			annotations.Prefix + "ram": fmt.Sprint(recommended.MemoryMiB / 1024),
+		},
+	}
+	if cpuLimit, _ := provider["cpuLimit"].(string); cpuLimit != "" {
+		recommendation["cpuLimit"] = cpuLimit
+	}
+	if memoryLimit, _ := provider["memoryLimit"].(string); memoryLimit != "" {
+		recommendation["memoryLimit"] = memoryLimit
+	}
+	return recommendation, nil
+}
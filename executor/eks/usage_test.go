@@ -0,0 +1,151 @@
+package eks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+type mockCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	mock.Mock
+}
+
+func (m *mockCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatch.GetMetricStatisticsOutput), args.Error(1)
+}
+
+// returns a build pod with a build container of 2 vCPUs and 4Gi and a service container of 1 vCPU and 1Gi
+func usagePod(created time.Time) *core.Pod {
+	pod := buildPod(core.PodRunning, core.ContainerState{Running: &core.ContainerStateRunning{}})
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.Spec.Containers = []core.Container{
+		{Name: "1234", Resources: core.ResourceRequirements{Limits: core.ResourceList{core.ResourceCPU: resource.MustParse("2"), core.ResourceMemory: resource.MustParse("4Gi")}}},
+		{Name: "svc-redis", Resources: core.ResourceRequirements{Limits: core.ResourceList{core.ResourceCPU: resource.MustParse("1"), core.ResourceMemory: resource.MustParse("1Gi")}}},
+	}
+	return pod
+}
+
+// returns the maximums of a metric
+func datapoints(maximums ...float64) *cloudwatch.GetMetricStatisticsOutput {
+	output := &cloudwatch.GetMetricStatisticsOutput{}
+	for _, max := range maximums {
+		output.Datapoints = append(output.Datapoints, &cloudwatch.Datapoint{Maximum: aws.Float64(max)})
+	}
+	return output
+}
+
+func TestSizingRecommendationMetricsServer(t *testing.T) {
+	defer func(get func(kubernetes.Interface, string, string) ([]byte, error)) { getPodMetrics = get }(getPodMetrics)
+	var metrics []byte
+	var metricsErr error
+	getPodMetrics = func(client kubernetes.Interface, namespace string, name string) ([]byte, error) {
+		assert.Equal(t, testNamespace, namespace)
+		assert.Equal(t, "1234-abcde", name)
+		return metrics, metricsErr
+	}
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(usagePod(time.Now()))}}
+
+	// no recommendations without a usage source
+	t.Setenv(usageMetricsEnv, "")
+	recommendation, err := executor.SizingRecommendation(getTestConfig())
+	assert.Nil(t, err)
+	assert.Nil(t, recommendation)
+
+	t.Setenv(usageMetricsEnv, "metrics-server")
+	metrics = []byte(`{"containers": [{"name": "svc-redis", "usage": {"cpu": "100m", "memory": "64Mi"}}, {"name": "1234", "usage": {"cpu": "1250m", "memory": "3100Mi"}}]}`)
+	recommendation, err = executor.SizingRecommendation(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"source":         "metrics-server",
+		"cpuUsage":       1.25,
+		"memoryUsageMiB": int64(3100),
+		"cpuLimit":       "2Gi",
+		"memoryLimit":    "2Gi",
+		"annotations":    map[string]string{"screwdriver.cd/cpu": "1.5", "screwdriver.cd/ram": "4"},
+	}, recommendation)
+
+	// finished build containers have no metrics
+	metrics = []byte(`{"containers": [{"name": "svc-redis", "usage": {"cpu": "100m", "memory": "64Mi"}}]}`)
+	recommendation, err = executor.SizingRecommendation(getTestConfig())
+	assert.Nil(t, err)
+	assert.Nil(t, recommendation)
+
+	metricsErr = errors.New("the server could not find the requested resource")
+	_, err = executor.SizingRecommendation(getTestConfig())
+	assert.EqualError(t, err, "failed to get pod metrics the server could not find the requested resource")
+
+	t.Setenv(usageMetricsEnv, "prometheus")
+	_, err = executor.SizingRecommendation(getTestConfig())
+	assert.EqualError(t, err, `unknown SD_EKS_USAGE_METRICS "prometheus", valid sources are metrics-server and container-insights`)
+}
+
+func TestSizingRecommendationContainerInsights(t *testing.T) {
+	t.Setenv(usageMetricsEnv, "container-insights")
+	created := time.Now().Add(-30 * time.Minute)
+	config := getTestConfig()
+	config["clusterName"] = "sd-build"
+
+	mockCW := new(mockCloudWatch)
+	input := func(metric string) interface{} {
+		return mock.MatchedBy(func(input *cloudwatch.GetMetricStatisticsInput) bool {
+			return aws.StringValue(input.MetricName) == metric &&
+				aws.StringValue(input.Namespace) == "ContainerInsights" &&
+				aws.StringValue(input.Dimensions[0].Value) == "sd-build" &&
+				aws.StringValue(input.Dimensions[2].Value) == "1234-abcde" &&
+				aws.Int64Value(input.Period) == 60 &&
+				input.StartTime.Sub(created).Abs() < time.Second
+		})
+	}
+	mockCW.On("GetMetricStatistics", input("pod_cpu_utilization_over_pod_limit")).Return(datapoints(20, 50, 35), nil)
+	mockCW.On("GetMetricStatistics", input("pod_memory_utilization_over_pod_limit")).Return(datapoints(40, 80), nil)
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{cloudwatch: mockCW},
+		k8sClientset: &k8sClientset{client: fake.NewSimpleClientset(usagePod(created))},
+	}
+	recommendation, err := executor.SizingRecommendation(config)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, recommendation["cpuUsage"])
+	assert.Equal(t, int64(4096), recommendation["memoryUsageMiB"])
+	assert.Equal(t, map[string]string{"screwdriver.cd/cpu": "2", "screwdriver.cd/ram": "5"}, recommendation["annotations"])
+
+	// pods without datapoints yet have no recommendation
+	mockCW = new(mockCloudWatch)
+	mockCW.On("GetMetricStatistics", mock.Anything).Return(datapoints(), nil)
+	executor.eksClient = &eksClient{cloudwatch: mockCW}
+	recommendation, err = executor.SizingRecommendation(config)
+	assert.Nil(t, err)
+	assert.Nil(t, recommendation)
+
+	mockCW = new(mockCloudWatch)
+	mockCW.On("GetMetricStatistics", mock.Anything).Return(datapoints(), errors.New("AccessDenied"))
+	executor.eksClient = &eksClient{cloudwatch: mockCW}
+	_, err = executor.SizingRecommendation(config)
+	assert.EqualError(t, err, "Error-GetMetricStatistics: AccessDenied")
+}
+
+func TestMaxPodMetricPeriod(t *testing.T) {
+	mockCW := new(mockCloudWatch)
+	mockCW.On("GetMetricStatistics", mock.Anything).Return(datapoints(10), nil)
+	end := time.Now()
+	pod := usagePod(end.Add(-48 * time.Hour))
+	max, ok, err := (&eksClient{cloudwatch: mockCW}).maxPodMetric("pod_cpu_utilization_over_pod_limit", "sd-build", pod, end)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, max)
+	// long running pods are read in larger periods to stay within the datapoints of a call
+	input := mockCW.Calls[0].Arguments.Get(0).(*cloudwatch.GetMetricStatisticsInput)
+	assert.Equal(t, int64(120), aws.Int64Value(input.Period))
+}
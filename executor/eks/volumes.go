@@ -0,0 +1,116 @@
+package eks
+
+import (
+	"fmt"
+	"os"
+
+	core "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// volumeModeHostPath keeps the launcher and tmp dirs on the node, the launcher is shared by the builds of a node
+	volumeModeHostPath = "hostPath"
+	// volumeModeEphemeral puts the launcher and tmp dirs on generic ephemeral volumes, provisioned per pod
+	volumeModeEphemeral = "ephemeral"
+	// volumeModeCSI puts the launcher dir on an inline volume of a csi driver and the tmp dir on an empty dir
+	volumeModeCSI = "csi"
+
+	// volumeModeEnv is the volume mode of builds without a provider.volumeMode
+	volumeModeEnv = "SD_EKS_VOLUME_MODE"
+	// ephemeralStorageClassEnv is the storage class of the ephemeral volumes, the cluster default when unset
+	ephemeralStorageClassEnv = "SD_EKS_EPHEMERAL_STORAGE_CLASS"
+	// ephemeralVolumeSizeEnv is the requested size of each ephemeral volume
+	ephemeralVolumeSizeEnv = "SD_EKS_EPHEMERAL_VOLUME_SIZE"
+	// launcherCSIDriverEnv is the csi driver of the launcher volume in csi mode
+	launcherCSIDriverEnv = "SD_EKS_LAUNCHER_CSI_DRIVER"
+
+	defaultEphemeralVolumeSize = "5Gi"
+)
+
+// gets the volume mode of the build
+func getVolumeMode(provider map[string]interface{}) string {
+	if mode, _ := provider["volumeMode"].(string); mode != "" {
+		return mode
+	}
+	if mode := os.Getenv(volumeModeEnv); mode != "" {
+		return mode
+	}
+	return volumeModeHostPath
+}
+
+// gets a generic ephemeral volume of the size and storage class, its claim is deleted along with the pod
+func claimVolume(name string, quantity resource.Quantity, storageClass string) core.Volume {
+	spec := core.PersistentVolumeClaimSpec{
+		AccessModes: []core.PersistentVolumeAccessMode{core.ReadWriteOnce},
+		Resources: core.ResourceRequirements{
+			Requests: core.ResourceList{core.ResourceStorage: quantity},
+		},
+	}
+	if storageClass != "" {
+		spec.StorageClassName = &storageClass
+	}
+	return core.Volume{Name: name, VolumeSource: core.VolumeSource{Ephemeral: &core.EphemeralVolumeSource{
+		VolumeClaimTemplate: &core.PersistentVolumeClaimTemplate{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "screwdriver", "tier": "builds"}},
+			Spec:       spec,
+		},
+	}}}
+}
+
+// gets a generic ephemeral volume of the ephemeral volume mode
+func ephemeralVolume(name string) (core.Volume, error) {
+	size := os.Getenv(ephemeralVolumeSizeEnv)
+	if size == "" {
+		size = defaultEphemeralVolumeSize
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return core.Volume{}, fmt.Errorf("invalid %s %q: %v", ephemeralVolumeSizeEnv, size, err)
+	}
+	return claimVolume(name, quantity, os.Getenv(ephemeralStorageClassEnv)), nil
+}
+
+// setVolumeMode replaces the hostPath launcher and tmp volumes of the pod for clusters which forbid hostPath.
+// The launcher init container fills the launcher volume on every start when it is not shared by the node.
+func setVolumeMode(pod *core.Pod, config map[string]interface{}) error {
+	provider := config["provider"].(map[string]interface{})
+	mode := getVolumeMode(provider)
+
+	var launcherVolume, tmpVolume core.Volume
+	switch mode {
+	case volumeModeHostPath:
+		return nil
+	case volumeModeEphemeral:
+		var err error
+		if launcherVolume, err = ephemeralVolume("screwdriver"); err != nil {
+			return err
+		}
+		if tmpVolume, err = ephemeralVolume("sdtemp"); err != nil {
+			return err
+		}
+	case volumeModeCSI:
+		driver := os.Getenv(launcherCSIDriverEnv)
+		if driver == "" {
+			return fmt.Errorf("volume mode %s requires %s", volumeModeCSI, launcherCSIDriverEnv)
+		}
+		launcherVolume = core.Volume{Name: "screwdriver", VolumeSource: core.VolumeSource{CSI: &core.CSIVolumeSource{
+			Driver:           driver,
+			VolumeAttributes: map[string]string{"launcherImage": provider["launcherImage"].(string), "launcherVersion": provider["launcherVersion"].(string)},
+		}}}
+		tmpVolume = core.Volume{Name: "sdtemp", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}}
+	default:
+		return fmt.Errorf("invalid volume mode %q, use one of %s, %s or %s", mode, volumeModeHostPath, volumeModeEphemeral, volumeModeCSI)
+	}
+
+	for i, volume := range pod.Spec.Volumes {
+		switch volume.Name {
+		case "screwdriver":
+			pod.Spec.Volumes[i] = launcherVolume
+		case "sdtemp":
+			pod.Spec.Volumes[i] = tmpVolume
+		}
+	}
+	return nil
+}
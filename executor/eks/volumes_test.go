@@ -0,0 +1,83 @@
+package eks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+// gets the volumes of the pod by name
+func podVolumes(pod *core.Pod) map[string]core.Volume {
+	volumes := map[string]core.Volume{}
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = volume
+	}
+	return volumes
+}
+
+func TestSetVolumeMode(t *testing.T) {
+	config := getTestConfig()
+	pod := getPodObject(config, testNamespace)
+	assert.Nil(t, setVolumeMode(pod, config))
+	assert.Equal(t, "/opt/screwdriver/sdlauncher/v101", podVolumes(pod)["screwdriver"].HostPath.Path)
+
+	t.Setenv("SD_EKS_VOLUME_MODE", "ephemeral")
+	t.Setenv("SD_EKS_EPHEMERAL_STORAGE_CLASS", "gp3")
+	pod = getPodObject(config, testNamespace)
+	assert.Nil(t, setVolumeMode(pod, config))
+	volumes := podVolumes(pod)
+	assert.Equal(t, 4, len(volumes))
+	for _, name := range []string{"screwdriver", "sdtemp"} {
+		assert.Nil(t, volumes[name].HostPath)
+		claim := volumes[name].Ephemeral.VolumeClaimTemplate.Spec
+		assert.Equal(t, "gp3", *claim.StorageClassName)
+		assert.Equal(t, []core.PersistentVolumeAccessMode{core.ReadWriteOnce}, claim.AccessModes)
+		assert.Equal(t, "5Gi", claim.Resources.Requests.Storage().String())
+	}
+
+	// the provider volume mode takes precedence
+	provider := config["provider"].(map[string]interface{})
+	provider["volumeMode"] = "csi"
+	t.Setenv("SD_EKS_LAUNCHER_CSI_DRIVER", "launcher.csi.screwdriver.cd")
+	pod = getPodObject(config, testNamespace)
+	assert.Nil(t, setVolumeMode(pod, config))
+	volumes = podVolumes(pod)
+	assert.Equal(t, "launcher.csi.screwdriver.cd", volumes["screwdriver"].CSI.Driver)
+	assert.Equal(t, map[string]string{"launcherImage": "launcher:v101", "launcherVersion": "v101"}, volumes["screwdriver"].CSI.VolumeAttributes)
+	assert.NotNil(t, volumes["sdtemp"].EmptyDir)
+
+	t.Setenv("SD_EKS_LAUNCHER_CSI_DRIVER", "")
+	assert.EqualError(t, setVolumeMode(getPodObject(config, testNamespace), config), "volume mode csi requires SD_EKS_LAUNCHER_CSI_DRIVER")
+
+	provider["volumeMode"] = "ephemeral"
+	t.Setenv("SD_EKS_EPHEMERAL_VOLUME_SIZE", "lots")
+	assert.EqualError(t, setVolumeMode(getPodObject(config, testNamespace), config),
+		`invalid SD_EKS_EPHEMERAL_VOLUME_SIZE "lots": quantities must match the regular expression '^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$'`)
+
+	provider["volumeMode"] = "nfs"
+	assert.EqualError(t, setVolumeMode(getPodObject(config, testNamespace), config), `invalid volume mode "nfs", use one of hostPath, ephemeral or csi`)
+}
+
+func TestStartVolumeMode(t *testing.T) {
+	t.Setenv("SD_EKS_VOLUME_MODE", "ephemeral")
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	_, err := executor.Start(getTestConfig())
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	for _, volume := range pods.Items[0].Spec.Volumes {
+		assert.Nil(t, volume.HostPath, volume.Name)
+	}
+
+	t.Setenv("SD_EKS_VOLUME_MODE", "nfs")
+	_, err = executor.Start(getTestConfig())
+	assert.EqualError(t, err, `invalid volume mode "nfs", use one of hostPath, ephemeral or csi`)
+}
@@ -0,0 +1,123 @@
+package eks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+const (
+	// workloadPod starts builds as bare pods
+	workloadPod = "pod"
+	// workloadJob starts builds as jobs, which remove their pod a while after it finished
+	workloadJob = "job"
+
+	// workloadEnv is the workload of builds without a provider.workload
+	workloadEnv = "SD_EKS_WORKLOAD"
+	// jobTTLEnv is how long a finished build job and its pod are kept, in seconds
+	jobTTLEnv = "SD_EKS_JOB_TTL_SECS"
+
+	defaultJobTTL = 600
+)
+
+// gets the workload builds are started as
+func getWorkload(provider map[string]interface{}) (string, error) {
+	workload, _ := provider["workload"].(string)
+	if workload == "" {
+		workload = os.Getenv(workloadEnv)
+	}
+	switch workload {
+	case "":
+		return workloadPod, nil
+	case workloadPod, workloadJob:
+		return workload, nil
+	}
+	return "", fmt.Errorf("invalid workload %q, use one of %s or %s", workload, workloadPod, workloadJob)
+}
+
+// gets the seconds a finished build job is kept
+func jobTTL() int32 {
+	if ttl, err := strconv.ParseInt(os.Getenv(jobTTLEnv), 10, 32); err == nil && ttl >= 0 {
+		return int32(ttl)
+	}
+	return defaultJobTTL
+}
+
+// gets the job running the build pod once, it keeps its terminal status until its ttl expires
+func getJobObject(pod *core.Pod) *batch.Job {
+	return &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Labels:    pod.Labels,
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit:            &[]int32{0}[0],
+			TTLSecondsAfterFinished: &[]int32{jobTTL()}[0],
+			Template: core.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: pod.Labels},
+				Spec:       pod.Spec,
+			},
+		},
+	}
+}
+
+// creates the job of the build pod and returns its name
+func createJob(clientset *k8sClientset, pod *core.Pod) (string, error) {
+	jobsClient := clientset.client.BatchV1().Jobs(pod.Namespace)
+	job := getJobObject(pod)
+	attempt := 0
+	err := retryAPI("create job", func() error {
+		attempt++
+		_, err := jobsClient.Create(context.TODO(), job, metav1.CreateOptions{})
+		// a timed out create may have created the job
+		if k8serrors.IsAlreadyExists(err) && attempt > 1 {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		if admissionErr := admissionError(err); admissionErr != nil {
+			return "", fmt.Errorf("Error creating job %w", admissionErr)
+		}
+		return "", executor.Errorf(apiCategory(err), "Error creating job %v", err)
+	}
+	log.Printf("Created job %v", job.Name)
+	return job.Name, nil
+}
+
+// deletes the jobs of the selector along with their pods, returns the failures
+func deleteJobs(clientset *k8sClientset, namespace string, selector metav1.ListOptions) []string {
+	jobsClient := clientset.client.BatchV1().Jobs(namespace)
+	var jobs *batch.JobList
+	if err := retryAPI("list jobs", func() error {
+		var err error
+		jobs, err = jobsClient.List(context.TODO(), selector)
+		return err
+	}); err != nil {
+		return []string{fmt.Sprintf("jobs: %v", err)}
+	}
+	var failures []string
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range jobs.Items {
+		name := job.Name
+		err := retryAPI("delete job", func() error {
+			return jobsClient.Delete(context.TODO(), name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			failures = append(failures, fmt.Sprintf("job %s: %v", name, err))
+			continue
+		}
+		log.Printf("Deleted job %s", name)
+	}
+	return failures
+}
@@ -0,0 +1,90 @@
+package eks
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGetWorkload(t *testing.T) {
+	workload, err := getWorkload(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, "pod", workload)
+
+	t.Setenv("SD_EKS_WORKLOAD", "job")
+	workload, _ = getWorkload(map[string]interface{}{})
+	assert.Equal(t, "job", workload)
+	workload, _ = getWorkload(map[string]interface{}{"workload": "pod"})
+	assert.Equal(t, "pod", workload)
+
+	_, err = getWorkload(map[string]interface{}{"workload": "deployment"})
+	assert.EqualError(t, err, `invalid workload "deployment", use one of pod or job`)
+}
+
+func TestGetJobObject(t *testing.T) {
+	t.Setenv("SD_EKS_JOB_TTL_SECS", "300")
+	pod := getPodObject(getTestConfig(), testNamespace)
+	job := getJobObject(pod)
+	assert.Equal(t, pod.Name, job.Name)
+	assert.Equal(t, testNamespace, job.Namespace)
+	assert.Equal(t, "1234", job.Labels["sdbuild"])
+	assert.Equal(t, pod.Labels, job.Spec.Template.Labels)
+	assert.Equal(t, int32(0), *job.Spec.BackoffLimit)
+	assert.Equal(t, int32(300), *job.Spec.TTLSecondsAfterFinished)
+	assert.Equal(t, core.RestartPolicyNever, job.Spec.Template.Spec.RestartPolicy)
+
+	t.Setenv("SD_EKS_JOB_TTL_SECS", "")
+	assert.Equal(t, int32(600), *getJobObject(pod).Spec.TTLSecondsAfterFinished)
+}
+
+func TestStartJob(t *testing.T) {
+	t.Setenv("SD_EKS_WORKLOAD", "job")
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	config := getTestConfig()
+	node, err := executor.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "", node)
+	jobs, _ := kubeclient.BatchV1().Jobs(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	assert.Equal(t, 1, len(jobs.Items))
+	assert.Equal(t, jobs.Items[0].Name, config["k8sJobName"])
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Empty(t, pods.Items)
+
+	config["provider"].(map[string]interface{})["workload"] = "deployment"
+	_, err = executor.Start(config)
+	assert.EqualError(t, err, `invalid workload "deployment", use one of pod or job`)
+}
+
+func TestStopDeletesJobs(t *testing.T) {
+	labels := map[string]string{"sdbuild": "1234"}
+	job := &batch.Job{ObjectMeta: metav1.ObjectMeta{Name: "1234-abcde", Namespace: testNamespace, Labels: labels}}
+	pod := &core.Pod{ObjectMeta: metav1.ObjectMeta{Name: "1234-abcde-xyz12", Namespace: testNamespace, Labels: labels}}
+	kubeclient := fake.NewSimpleClientset(job, pod)
+	executor := &AwsExecutorEKS{k8sClientset: &k8sClientset{client: kubeclient}}
+
+	assert.Nil(t, executor.Stop(getTestConfig()))
+	jobs, _ := kubeclient.BatchV1().Jobs(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Empty(t, jobs.Items)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{})
+	assert.Empty(t, pods.Items)
+
+	kubeclient = fake.NewSimpleClientset(job)
+	kubeclient.PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("etcdserver: request timed out")
+	})
+	executor = &AwsExecutorEKS{k8sClientset: &k8sClientset{client: kubeclient}}
+	assert.EqualError(t, executor.Stop(getTestConfig()), "failed to delete jobs job 1234-abcde: etcdserver: request timed out")
+}
@@ -0,0 +1,104 @@
+package eks
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// nodeDiskEnv is the disk in GiB builds may use of the ephemeral storage of a node,
+	// builds asking for more get their workspace on an ephemeral volume
+	nodeDiskEnv = "SD_EKS_NODE_EPHEMERAL_STORAGE_GIB"
+	// workspaceStorageClassesEnv lists the storage classes of workspace volumes by the largest size
+	// in GiB they serve, like gp3:1000,io2. The last class may omit its size and serves all larger workspaces.
+	workspaceStorageClassesEnv = "SD_EKS_WORKSPACE_STORAGE_CLASSES"
+
+	defaultWorkspaceStorageClass = "gp3"
+)
+
+// storage class of workspaces up to a size
+type storageClass struct {
+	name    string
+	maxSize int64
+}
+
+// gets the storage classes of workspace volumes, a class without a size serves any size
+func workspaceStorageClasses() ([]storageClass, error) {
+	value := strings.TrimSpace(os.Getenv(workspaceStorageClassesEnv))
+	if value == "" {
+		return []storageClass{{name: defaultWorkspaceStorageClass}}, nil
+	}
+	var classes []storageClass
+	for _, item := range strings.Split(value, ",") {
+		name, size, sized := strings.Cut(strings.TrimSpace(item), ":")
+		class := storageClass{name: name}
+		if sized {
+			maxSize, err := strconv.ParseInt(size, 10, 64)
+			if err != nil || maxSize <= 0 {
+				return nil, fmt.Errorf("invalid %s %q: size of %s must be a positive number of GiB", workspaceStorageClassesEnv, value, name)
+			}
+			class.maxSize = maxSize
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid %s %q: storage class name is empty", workspaceStorageClassesEnv, value)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// selects the first storage class serving the workspace size in GiB
+func selectStorageClass(classes []storageClass, size int64) (string, error) {
+	for _, class := range classes {
+		if class.maxSize == 0 || size <= class.maxSize {
+			return class.name, nil
+		}
+	}
+	return "", fmt.Errorf("no storage class of %s serves a workspace of %dGi", workspaceStorageClassesEnv, size)
+}
+
+// setWorkspaceVolume moves the workspace of builds asking for more disk than the nodes provide to
+// an ephemeral volume of a storage class picked by its size. The disk is no longer taken from the
+// ephemeral storage of the node, so the build container drops its ephemeral storage limit.
+func setWorkspaceVolume(pod *core.Pod, config map[string]interface{}) error {
+	provider := config["provider"].(map[string]interface{})
+	diskLimit, _ := provider["diskLimit"].(string)
+	nodeDisk, _ := strconv.ParseInt(os.Getenv(nodeDiskEnv), 10, 64)
+	if diskLimit == "" || nodeDisk <= 0 {
+		return nil
+	}
+	disk, err := resource.ParseQuantity(diskLimit)
+	if err != nil {
+		return fmt.Errorf("invalid diskLimit %q: %v", diskLimit, err)
+	}
+	if disk.Cmp(*resource.NewQuantity(nodeDisk<<30, resource.BinarySI)) <= 0 {
+		return nil
+	}
+	classes, err := workspaceStorageClasses()
+	if err != nil {
+		return err
+	}
+	size := (disk.Value() + 1<<30 - 1) >> 30
+	class, err := selectStorageClass(classes, size)
+	if err != nil {
+		return err
+	}
+
+	for i, volume := range pod.Spec.Volumes {
+		if volume.Name == "workspace" {
+			pod.Spec.Volumes[i] = claimVolume("workspace", disk, class)
+		}
+	}
+	buildID := fmt.Sprint(config["buildId"])
+	for i, container := range pod.Spec.Containers {
+		if container.Name == buildID {
+			delete(pod.Spec.Containers[i].Resources.Limits, core.ResourceEphemeralStorage)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package eks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWorkspaceStorageClasses(t *testing.T) {
+	classes, err := workspaceStorageClasses()
+	assert.Nil(t, err)
+	assert.Equal(t, []storageClass{{name: "gp3"}}, classes)
+
+	t.Setenv("SD_EKS_WORKSPACE_STORAGE_CLASSES", "gp3:1000, io2")
+	classes, err = workspaceStorageClasses()
+	assert.Nil(t, err)
+	assert.Equal(t, []storageClass{{name: "gp3", maxSize: 1000}, {name: "io2"}}, classes)
+
+	class, _ := selectStorageClass(classes, 500)
+	assert.Equal(t, "gp3", class)
+	class, _ = selectStorageClass(classes, 2000)
+	assert.Equal(t, "io2", class)
+	_, err = selectStorageClass(classes[:1], 2000)
+	assert.EqualError(t, err, "no storage class of SD_EKS_WORKSPACE_STORAGE_CLASSES serves a workspace of 2000Gi")
+
+	t.Setenv("SD_EKS_WORKSPACE_STORAGE_CLASSES", "gp3:big")
+	_, err = workspaceStorageClasses()
+	assert.EqualError(t, err, `invalid SD_EKS_WORKSPACE_STORAGE_CLASSES "gp3:big": size of gp3 must be a positive number of GiB`)
+	t.Setenv("SD_EKS_WORKSPACE_STORAGE_CLASSES", ":100")
+	_, err = workspaceStorageClasses()
+	assert.EqualError(t, err, `invalid SD_EKS_WORKSPACE_STORAGE_CLASSES ":100": storage class name is empty`)
+}
+
+func TestSetWorkspaceVolume(t *testing.T) {
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["diskLimit"] = "500Gi"
+
+	// the node serves the disk unless a node disk is configured
+	pod := getPodObject(config, testNamespace)
+	assert.Nil(t, setWorkspaceVolume(pod, config))
+	assert.NotNil(t, podVolumes(pod)["workspace"].EmptyDir)
+
+	t.Setenv("SD_EKS_NODE_EPHEMERAL_STORAGE_GIB", "500")
+	pod = getPodObject(config, testNamespace)
+	assert.Nil(t, setWorkspaceVolume(pod, config))
+	assert.NotNil(t, podVolumes(pod)["workspace"].EmptyDir)
+
+	t.Setenv("SD_EKS_NODE_EPHEMERAL_STORAGE_GIB", "100")
+	t.Setenv("SD_EKS_WORKSPACE_STORAGE_CLASSES", "gp3:200,io2")
+	pod = getPodObject(config, testNamespace)
+	assert.Nil(t, setWorkspaceVolume(pod, config))
+	claim := podVolumes(pod)["workspace"].Ephemeral.VolumeClaimTemplate.Spec
+	assert.Equal(t, "io2", *claim.StorageClassName)
+	assert.Equal(t, "500Gi", claim.Resources.Requests.Storage().String())
+	_, limited := pod.Spec.Containers[0].Resources.Limits[core.ResourceEphemeralStorage]
+	assert.False(t, limited)
+
+	config["provider"].(map[string]interface{})["diskLimit"] = "lots"
+	assert.EqualError(t, setWorkspaceVolume(getPodObject(getTestConfig(), testNamespace), config),
+		`invalid diskLimit "lots": quantities must match the regular expression '^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$'`)
+}
+
+func TestStartWorkspaceVolume(t *testing.T) {
+	t.Setenv("SD_EKS_NODE_EPHEMERAL_STORAGE_GIB", "100")
+	kubeclient := fake.NewSimpleClientset()
+	executor := &AwsExecutorEKS{
+		eksClient:    &eksClient{},
+		k8sClientset: &k8sClientset{client: kubeclient},
+	}
+
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["diskLimit"] = "128Gi"
+	_, err := executor.Start(config)
+	assert.Nil(t, err)
+	pods, _ := kubeclient.CoreV1().Pods(testNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "sdbuild=1234"})
+	claim := podVolumes(&pods.Items[0])["workspace"].Ephemeral.VolumeClaimTemplate.Spec
+	assert.Equal(t, "gp3", *claim.StorageClassName)
+	assert.Equal(t, "128Gi", claim.Resources.Requests.Storage().String())
+}
@@ -0,0 +1,39 @@
+package executor
+
+import "fmt"
+
+// ContainerExit holds how a container of a build terminated
+type ContainerExit struct {
+	Container string `json:"container"`
+	ExitCode  int32  `json:"exitCode"`
+	Reason    string `json:"reason,omitempty"`
+	OOMKilled bool   `json:"oomKilled,omitempty"`
+	// MemoryLimit is the memory limit of an OOMKilled container, like 2Gi
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+	Restarts    int32  `json:"restarts,omitempty"`
+}
+
+// ExitMessage explains the first OOMKilled or failed container of the exits, empty when all containers exited successfully
+func ExitMessage(exits []ContainerExit) string {
+	for _, exit := range exits {
+		if !exit.OOMKilled {
+			continue
+		}
+		message := fmt.Sprintf("Container %s was OOMKilled with exit code %d", exit.Container, exit.ExitCode)
+		if exit.MemoryLimit != "" {
+			message += fmt.Sprintf(", it ran out of its memory limit of %s", exit.MemoryLimit)
+		}
+		return message
+	}
+	for _, exit := range exits {
+		if exit.ExitCode == 0 {
+			continue
+		}
+		message := fmt.Sprintf("Container %s exited with code %d", exit.Container, exit.ExitCode)
+		if exit.Reason != "" {
+			message += fmt.Sprintf(" (%s)", exit.Reason)
+		}
+		return message
+	}
+	return ""
+}
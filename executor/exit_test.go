@@ -0,0 +1,32 @@
+package executor
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitMessage(t *testing.T) {
+	assert.Equal(t, "", ExitMessage(nil))
+	assert.Equal(t, "", ExitMessage([]ContainerExit{{Container: "1234", Reason: "Completed"}}))
+	assert.Equal(t, "Container 1234 exited with code 2 (Error)", ExitMessage([]ContainerExit{
+		{Container: "redis"},
+		{Container: "1234", ExitCode: 2, Reason: "Error"},
+	}))
+	// OOMKilled containers explain the failure of the others
+	assert.Equal(t, "Container redis was OOMKilled with exit code 137, it ran out of its memory limit of 512Mi", ExitMessage([]ContainerExit{
+		{Container: "1234", ExitCode: 1},
+		{Container: "redis", ExitCode: 137, Reason: "OOMKilled", OOMKilled: true, MemoryLimit: "512Mi"},
+	}))
+	assert.Equal(t, "Container 1234 was OOMKilled with exit code 137", ExitMessage([]ContainerExit{{Container: "1234", ExitCode: 137, OOMKilled: true}}))
+}
+
+func TestContainerExitJSON(t *testing.T) {
+	out, err := json.Marshal(ContainerExit{Container: "1234", ExitCode: 137, Reason: "OOMKilled", OOMKilled: true, MemoryLimit: "2Gi"})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"container": "1234", "exitCode": 137, "reason": "OOMKilled", "oomKilled": true, "memoryLimit": "2Gi"}`, string(out))
+
+	out, _ = json.Marshal(ContainerExit{Container: "1234"})
+	assert.JSONEq(t, `{"container": "1234", "exitCode": 0}`, string(out))
+}
@@ -0,0 +1,15 @@
+package executor
+
+import "time"
+
+// Resource is an aws resource a pipeline currently consumes, like a codebuild project or an eks pod
+type Resource struct {
+	// Type is the kind of the resource, e.g. codebuild-project, codebuild-build or eks-pod
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Arn  string `json:"arn,omitempty"`
+	// BuildID is the id of the Screwdriver build running on the resource, empty for resources shared by builds
+	BuildID   string     `json:"buildId,omitempty"`
+	Status    string     `json:"status,omitempty"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+}
@@ -0,0 +1,150 @@
+package sls
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+const (
+	// validateBucketEnv enables checking the region, encryption and public access of a build bucket before its first use
+	validateBucketEnv = "SD_SLS_VALIDATE_BUCKET"
+	// encryptionKeyEnv is the kms key alias of build artifacts, which the build bucket is expected to be encrypted with
+	encryptionKeyEnv = "SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS"
+)
+
+// buckets which passed validation, keyed by bucket and region
+var validatedBuckets sync.Map
+
+// checks if build buckets are validated before their first use
+func validateBucketEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(validateBucketEnv))
+	return enabled
+}
+
+// gets the region of a bucket location constraint, the constraint is empty for us-east-1 and EU for eu-west-1
+func bucketRegion(locationConstraint string) string {
+	switch locationConstraint {
+	case "":
+		return "us-east-1"
+	case "EU":
+		return "eu-west-1"
+	}
+	return locationConstraint
+}
+
+// checks that the bucket exists in the region
+func checkBucketRegion(serviceClient *awsAPI, bucket string, region string) error {
+	location, err := serviceClient.s3.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchBucket {
+		return executorState.Errorf(executorState.InfraPermanent, "build bucket %s does not exist, create it in %s or point SD_SLS_BUILD_BUCKET or provider.bucket to an existing bucket", bucket, region)
+	}
+	if err != nil {
+		return executorState.Errorf(errorCategory(err), "Error-GetBucketLocation: %v", err)
+	}
+	if actual := bucketRegion(aws.StringValue(location.LocationConstraint)); actual != region {
+		return executorState.Errorf(executorState.InfraPermanent, "build bucket %s is in %s but builds run in %s, use the bucket of the build region", bucket, actual, region)
+	}
+	return nil
+}
+
+// gets the arn of a kms key id, alias or arn
+func keyARN(serviceClient *awsAPI, keyID string) (string, error) {
+	key, err := serviceClient.kms.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return "", fmt.Errorf("Error-DescribeKey %s: %v", keyID, err)
+	}
+	return aws.StringValue(key.KeyMetadata.Arn), nil
+}
+
+// checks that the bucket default encryption uses the build encryption key, or any encryption if no key is configured
+func checkBucketEncryption(serviceClient *awsAPI, bucket string) error {
+	encryption, err := serviceClient.s3.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ServerSideEncryptionConfigurationNotFoundError" {
+		return fmt.Errorf("build bucket %s has no default encryption, enable SSE-KMS on the bucket", bucket)
+	}
+	if err != nil {
+		return fmt.Errorf("Error-GetBucketEncryption: %v", err)
+	}
+	var rule *s3.ServerSideEncryptionByDefault
+	if config := encryption.ServerSideEncryptionConfiguration; config != nil {
+		for _, r := range config.Rules {
+			if r.ApplyServerSideEncryptionByDefault != nil {
+				rule = r.ApplyServerSideEncryptionByDefault
+				break
+			}
+		}
+	}
+	if rule == nil {
+		return fmt.Errorf("build bucket %s has no default encryption, enable SSE-KMS on the bucket", bucket)
+	}
+
+	expected := os.Getenv(encryptionKeyEnv)
+	if expected == "" {
+		return nil
+	}
+	if aws.StringValue(rule.SSEAlgorithm) != s3.ServerSideEncryptionAwsKms || aws.StringValue(rule.KMSMasterKeyID) == "" {
+		return fmt.Errorf("build bucket %s is encrypted with %s, set its default encryption to SSE-KMS with %s of %s",
+			bucket, aws.StringValue(rule.SSEAlgorithm), expected, encryptionKeyEnv)
+	}
+	expectedARN, err := keyARN(serviceClient, expected)
+	if err != nil {
+		return err
+	}
+	actualARN, err := keyARN(serviceClient, aws.StringValue(rule.KMSMasterKeyID))
+	if err != nil {
+		return err
+	}
+	if actualARN != expectedARN {
+		return fmt.Errorf("build bucket %s is encrypted with %s, not %s of %s: change the bucket default encryption or %s",
+			bucket, actualARN, expected, encryptionKeyEnv, encryptionKeyEnv)
+	}
+	return nil
+}
+
+// checks that all public access of the bucket is blocked
+func checkBucketPublicAccess(serviceClient *awsAPI, bucket string) error {
+	access, err := serviceClient.s3.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchPublicAccessBlockConfiguration" {
+		err, access = nil, &s3.GetPublicAccessBlockOutput{}
+	}
+	if err != nil {
+		return fmt.Errorf("Error-GetPublicAccessBlock: %v", err)
+	}
+	block := access.PublicAccessBlockConfiguration
+	if block == nil || !aws.BoolValue(block.BlockPublicAcls) || !aws.BoolValue(block.IgnorePublicAcls) ||
+		!aws.BoolValue(block.BlockPublicPolicy) || !aws.BoolValue(block.RestrictPublicBuckets) {
+		return fmt.Errorf("build bucket %s does not block public access, enable all four Block Public Access settings on the bucket", bucket)
+	}
+	return nil
+}
+
+// validateBucket checks the region, encryption and public access of the build bucket once per bucket and region
+func validateBucket(serviceClient *awsAPI, bucket string, region string) error {
+	key := bucket + "/" + region
+	if _, ok := validatedBuckets.Load(key); ok {
+		return nil
+	}
+	if bucket == "" {
+		return fmt.Errorf("build bucket is not configured, set SD_SLS_BUILD_BUCKET or provider.bucket")
+	}
+	if err := checkBucketRegion(serviceClient, bucket, region); err != nil {
+		return err
+	}
+	if err := checkBucketEncryption(serviceClient, bucket); err != nil {
+		return err
+	}
+	if err := checkBucketPublicAccess(serviceClient, bucket); err != nil {
+		return err
+	}
+	validatedBuckets.Store(key, true)
+	return nil
+}
@@ -0,0 +1,153 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockKMSClient struct {
+	kmsiface.KMSAPI
+	mock.Mock
+}
+
+func (m *mockKMSClient) DescribeKey(input *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*kms.DescribeKeyOutput), args.Error(1)
+}
+
+func (m *mockS3Client) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketLocationOutput), args.Error(1)
+}
+func (m *mockS3Client) GetBucketEncryption(input *s3.GetBucketEncryptionInput) (*s3.GetBucketEncryptionOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketEncryptionOutput), args.Error(1)
+}
+func (m *mockS3Client) GetPublicAccessBlock(input *s3.GetPublicAccessBlockInput) (*s3.GetPublicAccessBlockOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetPublicAccessBlockOutput), args.Error(1)
+}
+
+const testKeyARN = "arn:aws:kms:us-west-2:123:key/0a1b2c3d"
+
+func kmsEncryption(keyID string) *s3.GetBucketEncryptionOutput {
+	return &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+		Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+			SSEAlgorithm:   aws.String("aws:kms"),
+			KMSMasterKeyID: aws.String(keyID),
+		}}},
+	}}
+}
+
+func blockedPublicAccess() *s3.GetPublicAccessBlockOutput {
+	return &s3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       aws.Bool(true),
+		BlockPublicPolicy:     aws.Bool(true),
+		IgnorePublicAcls:      aws.Bool(true),
+		RestrictPublicBuckets: aws.Bool(true),
+	}}
+}
+
+func setupBucket(location string, encryption *s3.GetBucketEncryptionOutput, encryptionErr error, access *s3.GetPublicAccessBlockOutput) (*awsAPI, *mockS3Client) {
+	mockServiceClient, _, mockS3API := setup()
+	mockKMSAPI := new(mockKMSClient)
+	mockServiceClient.kms = mockKMSAPI
+	mockKMSAPI.On("DescribeKey", &kms.DescribeKeyInput{KeyId: aws.String("alias/testKey")}).Return(&kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Arn: aws.String(testKeyARN)}}, nil)
+	mockKMSAPI.On("DescribeKey", &kms.DescribeKeyInput{KeyId: aws.String(testKeyARN)}).Return(&kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Arn: aws.String(testKeyARN)}}, nil)
+	mockKMSAPI.On("DescribeKey", mock.Anything).Return(&kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Arn: aws.String("arn:aws:kms:us-west-2:123:key/other")}}, nil)
+	mockS3API.On("GetBucketLocation", mock.Anything).Return(&s3.GetBucketLocationOutput{LocationConstraint: aws.String(location)}, nil)
+	mockS3API.On("GetBucketEncryption", mock.Anything).Return(encryption, encryptionErr)
+	mockS3API.On("GetPublicAccessBlock", mock.Anything).Return(access, nil)
+	return mockServiceClient, mockS3API
+}
+
+func TestBucketRegion(t *testing.T) {
+	assert.Equal(t, "us-east-1", bucketRegion(""))
+	assert.Equal(t, "eu-west-1", bucketRegion("EU"))
+	assert.Equal(t, "us-gov-west-1", bucketRegion("us-gov-west-1"))
+}
+
+func TestValidateBucket(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS", "alias/testKey")
+	validatedBuckets.Delete("valid-bucket/us-west-2")
+
+	serviceClient, mockS3API := setupBucket("us-west-2", kmsEncryption(testKeyARN), nil, blockedPublicAccess())
+	assert.Nil(t, validateBucket(serviceClient, "valid-bucket", "us-west-2"))
+	// validated buckets are not checked again
+	assert.Nil(t, validateBucket(serviceClient, "valid-bucket", "us-west-2"))
+	mockS3API.AssertNumberOfCalls(t, "GetBucketLocation", 1)
+
+	tests := []struct {
+		location      string
+		encryption    *s3.GetBucketEncryptionOutput
+		encryptionErr error
+		access        *s3.GetPublicAccessBlockOutput
+		err           string
+	}{
+		{
+			location: "us-east-2", encryption: kmsEncryption(testKeyARN), access: blockedPublicAccess(),
+			err: "build bucket test-bucket is in us-east-2 but builds run in us-west-2, use the bucket of the build region",
+		},
+		{
+			location: "us-west-2", encryption: &s3.GetBucketEncryptionOutput{}, access: blockedPublicAccess(),
+			encryptionErr: awserr.New("ServerSideEncryptionConfigurationNotFoundError", "not found", nil),
+			err:           "build bucket test-bucket has no default encryption, enable SSE-KMS on the bucket",
+		},
+		{
+			location: "us-west-2", encryption: &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+				Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String("AES256")}}},
+			}}, access: blockedPublicAccess(),
+			err: "build bucket test-bucket is encrypted with AES256, set its default encryption to SSE-KMS with alias/testKey of SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS",
+		},
+		{
+			location: "us-west-2", encryption: kmsEncryption("other"), access: blockedPublicAccess(),
+			err: "build bucket test-bucket is encrypted with arn:aws:kms:us-west-2:123:key/other, not alias/testKey of SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS: change the bucket default encryption or SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS",
+		},
+		{
+			location: "us-west-2", encryption: kmsEncryption(testKeyARN), encryptionErr: errors.New("AccessDenied"), access: blockedPublicAccess(),
+			err: "Error-GetBucketEncryption: AccessDenied",
+		},
+		{
+			location: "us-west-2", encryption: kmsEncryption(testKeyARN), access: &s3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{BlockPublicAcls: aws.Bool(true)}},
+			err: "build bucket test-bucket does not block public access, enable all four Block Public Access settings on the bucket",
+		},
+	}
+	for _, test := range tests {
+		serviceClient, _ := setupBucket(test.location, test.encryption, test.encryptionErr, test.access)
+		assert.EqualError(t, validateBucket(serviceClient, "test-bucket", "us-west-2"), test.err)
+	}
+
+	mockServiceClient, _, mockS3API := setup()
+	mockS3API.On("GetBucketLocation", mock.Anything).Return(&s3.GetBucketLocationOutput{}, awserr.New(s3.ErrCodeNoSuchBucket, "not found", nil))
+	assert.EqualError(t, validateBucket(mockServiceClient, "missing-bucket", "us-west-2"),
+		"build bucket missing-bucket does not exist, create it in us-west-2 or point SD_SLS_BUILD_BUCKET or provider.bucket to an existing bucket")
+	assert.EqualError(t, validateBucket(mockServiceClient, "", "us-west-2"), "build bucket is not configured, set SD_SLS_BUILD_BUCKET or provider.bucket")
+}
+
+func TestValidateBucketWithoutKey(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS", "")
+	validatedBuckets.Delete("aes-bucket/us-east-1")
+	serviceClient, _ := setupBucket("", &s3.GetBucketEncryptionOutput{ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+		Rules: []*s3.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{SSEAlgorithm: aws.String("AES256")}}},
+	}}, nil, blockedPublicAccess())
+	assert.Nil(t, validateBucket(serviceClient, "aes-bucket", "us-east-1"))
+}
+
+func TestStartValidatesBucket(t *testing.T) {
+	t.Setenv("SD_SLS_VALIDATE_BUCKET", "true")
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-aws-consumer-use2-bucket")
+	serviceClient, mockS3API := setupBucket("us-east-2", kmsEncryption(testKeyARN), nil, blockedPublicAccess())
+
+	e := &AwsServerless{serviceClient: serviceClient}
+	_, err := e.Start(getTestConfig())
+	assert.EqualError(t, err, "Got error validating build bucket: build bucket sd-aws-consumer-use2-bucket is in us-east-2 but builds run in us-west-2, use the bucket of the build region")
+	mockS3API.AssertNotCalled(t, "ListObjectsV2", mock.Anything)
+}
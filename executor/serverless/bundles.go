@@ -0,0 +1,174 @@
+package sls
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// keepBundlesEnv is the number of most recently staged launcher bundles kept in a build bucket
+const keepBundlesEnv = "SD_SLS_KEEP_LAUNCHER_BUNDLES"
+
+// defaultKeepBundles is the number of kept launcher bundles when SD_SLS_KEEP_LAUNCHER_BUNDLES is unset
+const defaultKeepBundles = 10
+
+// batchArtifactPrefix is the artifact prefix of the main build of a batch build graph
+const batchArtifactPrefix = "main-"
+
+// gets the number of launcher bundles to keep, zero keeps all bundles
+func keepBundles() int {
+	value := strings.TrimSpace(os.Getenv(keepBundlesEnv))
+	if value == "" {
+		return defaultKeepBundles
+	}
+	keep, err := strconv.Atoi(value)
+	if err != nil || keep < 0 {
+		return defaultKeepBundles
+	}
+	return keep
+}
+
+// lists the launcher bundles of the bucket, newest first
+func listBundles(serviceClient *awsAPI, bucket string) ([]*s3.Object, error) {
+	var bundles []*s3.Object
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(sdInitPrefix)}
+	for {
+		listResult, err := serviceClient.s3.ListObjectsV2(input)
+		if err != nil {
+			return nil, fmt.Errorf("Error-ListObjectsV2: %v", err)
+		}
+		bundles = append(bundles, listResult.Contents...)
+		if !aws.BoolValue(listResult.IsTruncated) || listResult.NextContinuationToken == nil {
+			break
+		}
+		input.ContinuationToken = listResult.NextContinuationToken
+	}
+	sort.SliceStable(bundles, func(i, j int) bool {
+		return aws.TimeValue(bundles[i].LastModified).After(aws.TimeValue(bundles[j].LastModified))
+	})
+	return bundles, nil
+}
+
+// gets the launcher bundle of the bucket a build source is staged from, empty for other sources
+func sourceBundle(source *codebuild.ProjectSource, bucket string) string {
+	if source == nil {
+		return ""
+	}
+	prefix := bucket + "/" + sdInitPrefix
+	location := aws.StringValue(source.Location)
+	if !strings.HasPrefix(location, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(location, prefix)
+}
+
+// lists the launcher bundles of the bucket the in progress builds run with
+func listLiveBundles(serviceClient *awsAPI, bucket string) (map[string]bool, error) {
+	live := map[string]bool{}
+	input := &codebuild.ListBuildsInput{SortOrder: aws.String(codebuild.SortOrderTypeDescending)}
+	for {
+		listResult, err := serviceClient.cb.ListBuilds(input)
+		if err != nil {
+			return nil, fmt.Errorf("Error-ListBuilds: %v", err)
+		}
+		if len(listResult.Ids) == 0 {
+			return live, nil
+		}
+		buildsResult, err := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{Ids: listResult.Ids})
+		if err != nil {
+			return nil, fmt.Errorf("Error-BatchGetBuilds: %v", err)
+		}
+		inProgress := 0
+		for _, build := range buildsResult.Builds {
+			if aws.StringValue(build.BuildStatus) != codebuild.StatusTypeInProgress {
+				continue
+			}
+			inProgress++
+			for _, source := range append([]*codebuild.ProjectSource{build.Source}, build.SecondarySources...) {
+				if bundle := sourceBundle(source, bucket); bundle != "" {
+					live[bundle] = true
+				}
+			}
+		}
+		// builds are listed newest first, a page without running builds means the older ones have finished
+		if inProgress == 0 || listResult.NextToken == nil {
+			return live, nil
+		}
+		input.NextToken = listResult.NextToken
+	}
+}
+
+// cleanupBundles deletes all but the most recently staged launcher bundles of the bucket, along with the
+// artifacts batch builds left for them and the sync projects which staged them. The bundle of the current
+// build and the bundles of builds still in progress are always kept.
+func cleanupBundles(serviceClient *awsAPI, bucket string, current string) error {
+	keep := keepBundles()
+	if keep == 0 {
+		return nil
+	}
+	bundles, err := listBundles(serviceClient, bucket)
+	if err != nil {
+		return err
+	}
+	if len(bundles) <= keep {
+		return nil
+	}
+	live, err := listLiveBundles(serviceClient, bucket)
+	if err != nil {
+		return err
+	}
+
+	var bundleNames []string
+	var expired []*s3.ObjectIdentifier
+	kept := 0
+	for _, bundle := range bundles {
+		key := aws.StringValue(bundle.Key)
+		bundleName := strings.TrimPrefix(key, sdInitPrefix)
+		if kept < keep {
+			kept++
+			continue
+		}
+		if bundleName == current || live[bundleName] {
+			continue
+		}
+		bundleNames = append(bundleNames, bundleName)
+		// missing keys are not an error of delete objects
+		expired = append(expired, &s3.ObjectIdentifier{Key: bundle.Key}, &s3.ObjectIdentifier{Key: aws.String(batchArtifactPrefix + bundleName)})
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	// delete objects takes up to 1000 keys
+	for start := 0; start < len(expired); start += 1000 {
+		end := start + 1000
+		if end > len(expired) {
+			end = len(expired)
+		}
+		deleteResult, err := serviceClient.s3.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: expired[start:end], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("Error-DeleteObjects: %v", err)
+		}
+		for _, deleteErr := range deleteResult.Errors {
+			log.Printf("Error deleting launcher bundle %v: %v", aws.StringValue(deleteErr.Key), aws.StringValue(deleteErr.Message))
+		}
+	}
+	for _, bundleName := range bundleNames {
+		project := syncProjectName(bucket, bundleName)
+		if _, err := serviceClient.cb.DeleteProject(&codebuild.DeleteProjectInput{Name: aws.String(project)}); err != nil && !isProjectNotFound(err) {
+			log.Printf("Error deleting sync project %q: %v", project, err)
+		}
+	}
+	log.Printf("Deleted %d launcher bundles of bucket %v", len(bundleNames), bucket)
+	return nil
+}
@@ -0,0 +1,131 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func (m *mockS3Client) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.DeleteObjectsOutput), args.Error(1)
+}
+
+func bundleObject(key string, age time.Duration) *s3.Object {
+	return &s3.Object{Key: aws.String(key), LastModified: aws.Time(time.Now().Add(-age))}
+}
+
+func TestKeepBundles(t *testing.T) {
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "")
+	assert.Equal(t, 10, keepBundles())
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "3")
+	assert.Equal(t, 3, keepBundles())
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "0")
+	assert.Equal(t, 0, keepBundles())
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "-1")
+	assert.Equal(t, 10, keepBundles())
+}
+
+func TestCleanupBundles(t *testing.T) {
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "3")
+	mockServiceClient, mockCBAPI, mockS3API := setup()
+	listInput := &s3.ListObjectsV2Input{Bucket: aws.String(testBucket), Prefix: aws.String("sdinit-")}
+	mockS3API.On("ListObjectsV2", listInput).Return(&s3.ListObjectsV2Output{
+		Contents:              []*s3.Object{bundleObject("sdinit-v98", 4*time.Hour), bundleObject("sdinit-v101", time.Hour)},
+		IsTruncated:           aws.Bool(true),
+		NextContinuationToken: aws.String("next"),
+	}, nil).Once()
+	mockS3API.On("ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String(testBucket), Prefix: aws.String("sdinit-"), ContinuationToken: aws.String("next")}).
+		Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{bundleObject("sdinit-v99", 3*time.Hour), bundleObject("sdinit-v100", 2*time.Hour)}}, nil)
+	mockS3API.On("DeleteObjects", mock.Anything).Return(&s3.DeleteObjectsOutput{}, nil)
+	mockCBAPI.On("ListBuilds", mock.Anything).Return(&codebuild.ListBuildsOutput{}, nil)
+	mockCBAPI.On("DeleteProject", &codebuild.DeleteProjectInput{Name: aws.String(syncProjectName(testBucket, "v98"))}).
+		Return(&codebuild.DeleteProjectOutput{}, nil)
+	mockCBAPI.On("DeleteProject", &codebuild.DeleteProjectInput{Name: aws.String(syncProjectName(testBucket, "v99"))}).
+		Return(&codebuild.DeleteProjectOutput{}, awserr.New(codebuild.ErrCodeResourceNotFoundException, "not found", nil))
+
+	// keeps the current bundle beyond the newest ones
+	assert.Nil(t, cleanupBundles(mockServiceClient, testBucket, "v98"))
+	mockS3API.AssertNotCalled(t, "DeleteObjects", mock.Anything)
+
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "2")
+	mockS3API.On("ListObjectsV2", listInput).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{bundleObject("sdinit-v98", 4*time.Hour), bundleObject("sdinit-v101", time.Hour), bundleObject("sdinit-v99", 3*time.Hour), bundleObject("sdinit-v100", 2*time.Hour)},
+	}, nil)
+	assert.Nil(t, cleanupBundles(mockServiceClient, testBucket, "v101"))
+	mockS3API.AssertCalled(t, "DeleteObjects", &s3.DeleteObjectsInput{
+		Bucket: aws.String(testBucket),
+		Delete: &s3.Delete{Quiet: aws.Bool(true), Objects: []*s3.ObjectIdentifier{
+			{Key: aws.String("sdinit-v99")}, {Key: aws.String("main-v99")},
+			{Key: aws.String("sdinit-v98")}, {Key: aws.String("main-v98")},
+		}},
+	})
+	mockCBAPI.AssertNumberOfCalls(t, "DeleteProject", 2)
+}
+
+func TestCleanupBundlesKeepsLiveBundles(t *testing.T) {
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "1")
+	mockServiceClient, mockCBAPI, mockS3API := setup()
+	mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{bundleObject("sdinit-v101", time.Hour), bundleObject("sdinit-v100", 2*time.Hour), bundleObject("sdinit-v99", 3*time.Hour), bundleObject("sdinit-v98", 4*time.Hour)},
+	}, nil)
+	mockS3API.On("DeleteObjects", mock.Anything).Return(&s3.DeleteObjectsOutput{}, nil)
+	mockCBAPI.On("DeleteProject", mock.Anything).Return(&codebuild.DeleteProjectOutput{}, nil)
+	listInput := &codebuild.ListBuildsInput{SortOrder: aws.String("DESCENDING")}
+	mockCBAPI.On("ListBuilds", listInput).Return(&codebuild.ListBuildsOutput{Ids: aws.StringSlice([]string{"b1", "b2", "b3"}), NextToken: aws.String("next")}, nil).Once()
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"b1", "b2", "b3"})}).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{
+		{Id: aws.String("b1"), BuildStatus: aws.String("IN_PROGRESS"), Source: &codebuild.ProjectSource{Location: aws.String(testBucket + "/sdinit-v99")}},
+		// finished builds don't keep their bundle
+		{Id: aws.String("b2"), BuildStatus: aws.String("SUCCEEDED"), Source: &codebuild.ProjectSource{Location: aws.String(testBucket + "/sdinit-v98")}},
+		// other buckets are not matched
+		{Id: aws.String("b3"), BuildStatus: aws.String("IN_PROGRESS"), Source: &codebuild.ProjectSource{Location: aws.String("other-bucket/sdinit-v100")}},
+	}}, nil).Once()
+	mockCBAPI.On("ListBuilds", &codebuild.ListBuildsInput{SortOrder: aws.String("DESCENDING"), NextToken: aws.String("next")}).
+		Return(&codebuild.ListBuildsOutput{Ids: aws.StringSlice([]string{"b4"})}, nil).Once()
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"b4"})}).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{
+		{Id: aws.String("b4"), BuildStatus: aws.String("IN_PROGRESS"), SecondarySources: []*codebuild.ProjectSource{{Location: aws.String(testBucket + "/sdinit-v100")}}},
+	}}, nil).Once()
+
+	assert.Nil(t, cleanupBundles(mockServiceClient, testBucket, "v101"))
+	mockS3API.AssertCalled(t, "DeleteObjects", &s3.DeleteObjectsInput{
+		Bucket: aws.String(testBucket),
+		Delete: &s3.Delete{Quiet: aws.Bool(true), Objects: []*s3.ObjectIdentifier{{Key: aws.String("sdinit-v98")}, {Key: aws.String("main-v98")}}},
+	})
+	mockCBAPI.AssertNumberOfCalls(t, "DeleteProject", 1)
+
+	// nothing is deleted when the live builds can't be listed
+	mockServiceClient, mockCBAPI, mockS3API = setup()
+	mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{bundleObject("sdinit-v101", time.Hour), bundleObject("sdinit-v100", 2*time.Hour)},
+	}, nil)
+	mockCBAPI.On("ListBuilds", mock.Anything).Return(&codebuild.ListBuildsOutput{}, errors.New("AccessDenied"))
+	assert.EqualError(t, cleanupBundles(mockServiceClient, testBucket, "v101"), "Error-ListBuilds: AccessDenied")
+	mockS3API.AssertNotCalled(t, "DeleteObjects", mock.Anything)
+}
+
+func TestCleanupBundlesErrors(t *testing.T) {
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "1")
+	mockServiceClient, mockCBAPI, mockS3API := setup()
+	mockCBAPI.On("ListBuilds", mock.Anything).Return(&codebuild.ListBuildsOutput{}, nil)
+	mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{}, errors.New("AccessDenied")).Once()
+	assert.EqualError(t, cleanupBundles(mockServiceClient, testBucket, "v101"), "Error-ListObjectsV2: AccessDenied")
+
+	mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{
+		Contents: []*s3.Object{bundleObject("sdinit-v101", time.Hour), bundleObject("sdinit-v100", 2*time.Hour)},
+	}, nil)
+	mockS3API.On("DeleteObjects", mock.Anything).Return(&s3.DeleteObjectsOutput{}, errors.New("AccessDenied"))
+	assert.EqualError(t, cleanupBundles(mockServiceClient, testBucket, "v101"), "Error-DeleteObjects: AccessDenied")
+
+	// zero keeps every bundle
+	t.Setenv("SD_SLS_KEEP_LAUNCHER_BUNDLES", "0")
+	mockServiceClient, _, mockS3API = setup()
+	assert.Nil(t, cleanupBundles(mockServiceClient, testBucket, "v101"))
+	mockS3API.AssertNotCalled(t, "ListObjectsV2", mock.Anything)
+}
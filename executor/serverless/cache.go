@@ -0,0 +1,99 @@
+package sls
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+)
+
+const (
+	// cacheWarmEnv is the longest PROVISIONING phase in seconds of builds reported to run on a warm host
+	cacheWarmEnv = "SD_SLS_CACHE_WARM_SECONDS"
+	// only warm build hosts keep the local caches, provisioning a new host takes longer
+	defaultCacheWarmSeconds = 30
+)
+
+// gets the local cache modes of a build with dlc, the docker layer cache unless cacheModes is set
+func getCacheModes(provider map[string]interface{}) []string {
+	modes, _ := provider["cacheModes"].([]interface{})
+	if len(modes) == 0 {
+		return []string{codebuild.CacheModeLocalDockerLayerCache}
+	}
+	var names []string
+	for _, mode := range modes {
+		names = append(names, mode.(string))
+	}
+	return names
+}
+
+// gets the paths cached by the LOCAL_CUSTOM_CACHE mode of a build
+func getCachePaths(provider map[string]interface{}) []string {
+	if dlc, _ := provider["dlc"].(bool); !dlc {
+		return nil
+	}
+	paths, _ := provider["cachePaths"].([]interface{})
+	var names []string
+	for _, path := range paths {
+		names = append(names, path.(string))
+	}
+	return names
+}
+
+// gets the cache section of a buildspec caching the paths, joined by the line separator of the buildspec
+func cacheSpec(paths []string, newline string) string {
+	lines := []string{"cache:", "  paths:"}
+	for _, path := range paths {
+		lines = append(lines, fmt.Sprintf("    - '%s'", path))
+	}
+	return strings.Join(lines, newline)
+}
+
+// gets the longest PROVISIONING phase of builds on warm hosts
+func cacheWarmSeconds() int64 {
+	if seconds, err := strconv.ParseInt(strings.TrimSpace(os.Getenv(cacheWarmEnv)), 10, 64); err == nil && seconds > 0 {
+		return seconds
+	}
+	return defaultCacheWarmSeconds
+}
+
+// gets the duration in seconds of a finished build phase, -1 if it did not finish
+func phaseSeconds(build *codebuild.Build, phaseType string) int64 {
+	for _, phase := range build.Phases {
+		if aws.StringValue(phase.PhaseType) == phaseType && phase.DurationInSeconds != nil {
+			return aws.Int64Value(phase.DurationInSeconds)
+		}
+	}
+	return -1
+}
+
+// CacheStats returns the local cache modes of a build with dlc and whether it ran on a warm host keeping the caches,
+// estimated from the PROVISIONING phase as codebuild does not report cache hits. Nil without dlc or build.
+func (e *AwsServerless) CacheStats(config map[string]interface{}) map[string]interface{} {
+	provider := config["provider"].(map[string]interface{})
+	if dlc, _ := provider["dlc"].(bool); !dlc {
+		return nil
+	}
+	build, err := getMainBuild(e.serviceClient, config)
+	if err != nil {
+		build, err = findProjectBuild(e.serviceClient, config)
+	}
+	if err != nil || build == nil {
+		return nil
+	}
+	cache := map[string]interface{}{"modes": getCacheModes(provider)}
+	if seconds := phaseSeconds(build, codebuild.BuildPhaseTypeProvisioning); seconds >= 0 {
+		cache["provisioningSeconds"] = seconds
+		cache["warm"] = seconds <= cacheWarmSeconds()
+	}
+	if seconds := phaseSeconds(build, codebuild.BuildPhaseTypeDownloadSource); seconds >= 0 {
+		cache["downloadSourceSeconds"] = seconds
+	}
+	if seconds := phaseSeconds(build, codebuild.BuildPhaseTypeBuild); seconds >= 0 {
+		cache["buildSeconds"] = seconds
+	}
+	return map[string]interface{}{"cache": cache}
+}
@@ -0,0 +1,99 @@
+package sls
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// returns the test config of a build with dlc and the cache settings
+func getCacheConfig(modes []interface{}, paths []interface{}) map[string]interface{} {
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["dlc"] = true
+	if modes != nil {
+		provider["cacheModes"] = modes
+	}
+	if paths != nil {
+		provider["cachePaths"] = paths
+	}
+	return config
+}
+
+func TestGetCacheModes(t *testing.T) {
+	provider := getCacheConfig(nil, nil)["provider"].(map[string]interface{})
+	assert.Equal(t, []string{"LOCAL_DOCKER_LAYER_CACHE"}, getCacheModes(provider))
+	assert.Nil(t, getCachePaths(provider))
+
+	provider = getCacheConfig([]interface{}{"LOCAL_SOURCE_CACHE", "LOCAL_CUSTOM_CACHE"}, []interface{}{"/root/.npm", "node_modules/**/*"})["provider"].(map[string]interface{})
+	assert.Equal(t, []string{"LOCAL_SOURCE_CACHE", "LOCAL_CUSTOM_CACHE"}, getCacheModes(provider))
+	assert.Equal(t, []string{"/root/.npm", "node_modules/**/*"}, getCachePaths(provider))
+
+	// paths only apply with dlc
+	provider["dlc"] = false
+	assert.Nil(t, getCachePaths(provider))
+}
+
+func TestCacheBuildSpec(t *testing.T) {
+	config := getCacheConfig([]interface{}{"LOCAL_CUSTOM_CACHE"}, []interface{}{"/root/.npm"})
+	request, batchBuildSpec := getRequestObject("deploy-123", testLauncherVersion, false, config)
+	assert.Equal(t, []*string{aws.String("LOCAL_CUSTOM_CACHE")}, request.Cache.Modes)
+	assert.Equal(t, "LOCAL", aws.StringValue(request.Cache.Type))
+	assert.True(t, strings.HasSuffix(aws.StringValue(request.Source.Buildspec), "\ncache:\n  paths:\n    - '/root/.npm'\n"), aws.StringValue(request.Source.Buildspec))
+	assert.Contains(t, batchBuildSpec, `\ncache:\n  paths:\n    - '/root/.npm'"`)
+
+	// without cache paths the buildspec is unchanged
+	request, batchBuildSpec = getRequestObject("deploy-123", testLauncherVersion, false, getCacheConfig(nil, nil))
+	assert.Equal(t, []*string{aws.String("LOCAL_DOCKER_LAYER_CACHE")}, request.Cache.Modes)
+	assert.NotContains(t, aws.StringValue(request.Source.Buildspec), "cache:")
+	assert.NotContains(t, batchBuildSpec, "cache:")
+}
+
+func TestCacheStats(t *testing.T) {
+	assert.Nil(t, (&AwsServerless{}).CacheStats(getTestConfig()))
+
+	config := getCacheConfig([]interface{}{"LOCAL_SOURCE_CACHE"}, nil)
+	config["codebuildBuildId"] = "deploy-123:abc"
+	mockServiceClient, mockCBAPI, _ := setup()
+	build := &codebuild.Build{Id: aws.String("deploy-123:abc"), Phases: []*codebuild.BuildPhase{
+		{PhaseType: aws.String("SUBMITTED"), DurationInSeconds: aws.Int64(0)},
+		{PhaseType: aws.String("PROVISIONING"), DurationInSeconds: aws.Int64(12)},
+		{PhaseType: aws.String("DOWNLOAD_SOURCE"), DurationInSeconds: aws.Int64(3)},
+		{PhaseType: aws.String("BUILD")},
+	}}
+	mockCBAPI.On("BatchGetBuilds", mock.Anything).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{build}}, nil)
+	e := &AwsServerless{serviceClient: mockServiceClient}
+	assert.Equal(t, map[string]interface{}{"cache": map[string]interface{}{
+		"modes":                 []string{"LOCAL_SOURCE_CACHE"},
+		"provisioningSeconds":   int64(12),
+		"warm":                  true,
+		"downloadSourceSeconds": int64(3),
+	}}, e.CacheStats(config))
+
+	t.Setenv("SD_SLS_CACHE_WARM_SECONDS", "10")
+	assert.Equal(t, false, e.CacheStats(config)["cache"].(map[string]interface{})["warm"])
+}
+
+func TestCacheStatsOfStop(t *testing.T) {
+	// stops find the build in the recent builds of the project
+	config := getCacheConfig(nil, nil)
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockCBAPI.On("ListBuildsForProject", mock.Anything).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:2"})}, nil)
+	build := testBuildOf("1234")
+	build.Phases = []*codebuild.BuildPhase{{PhaseType: aws.String("PROVISIONING"), DurationInSeconds: aws.Int64(95)}}
+	mockCBAPI.On("BatchGetBuilds", mock.Anything).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{build}}, nil)
+	e := &AwsServerless{serviceClient: mockServiceClient}
+	assert.Equal(t, map[string]interface{}{"cache": map[string]interface{}{
+		"modes":               []string{"LOCAL_DOCKER_LAYER_CACHE"},
+		"provisioningSeconds": int64(95),
+		"warm":                false,
+	}}, e.CacheStats(config))
+
+	mockServiceClient, mockCBAPI, _ = setup()
+	mockCBAPI.On("ListBuildsForProject", mock.Anything).Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+	assert.Nil(t, (&AwsServerless{serviceClient: mockServiceClient}).CacheStats(config))
+}
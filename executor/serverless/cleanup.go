@@ -0,0 +1,24 @@
+package sls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Cleanup deletes the codebuild project of an archived job and the cloudwatch log group of its builds
+func (e *AwsServerless) Cleanup(config map[string]interface{}) error {
+	project := getProjectName(config)
+	var failures []string
+
+	if err := deleteProject(e.serviceClient, project); err != nil {
+		failures = append(failures, err.Error())
+	}
+	if err := deleteLogGroup(e.serviceClient, project); err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to clean up project %s: %s", project, strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+)
+
+func (m *mockLogsClient) DeleteLogGroup(input *cloudwatchlogs.DeleteLogGroupInput) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.DeleteLogGroupOutput), args.Error(1)
+}
+
+func TestCleanup(t *testing.T) {
+	testCases := []struct {
+		message   string
+		deleteErr error
+		logsErr   error
+		expErr    string
+	}{
+		{message: "deleted"},
+		{message: "no log group", logsErr: awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "not found", nil)},
+		{
+			message:   "failures",
+			deleteErr: errors.New("AccessDenied"),
+			logsErr:   errors.New("throttled"),
+			expErr:    "failed to clean up project deploy-123: Got error deleting project: AccessDenied; Error-DeleteLogGroup: throttled",
+		},
+	}
+	for _, tc := range testCases {
+		mockServiceClient, mockCBAPI, _ := setup()
+		mockLogsAPI := new(mockLogsClient)
+		mockServiceClient.logs = mockLogsAPI
+		mockCBAPI.On("DeleteProject", &codebuild.DeleteProjectInput{Name: aws.String("deploy-123")}).Return(&codebuild.DeleteProjectOutput{}, tc.deleteErr)
+		mockLogsAPI.On("DeleteLogGroup", &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String("/aws/codebuild/deploy-123")}).Return(&cloudwatchlogs.DeleteLogGroupOutput{}, tc.logsErr)
+
+		e := &AwsServerless{serviceClient: mockServiceClient}
+		err := e.Cleanup(getTestConfig())
+		if tc.expErr != "" {
+			assert.EqualError(t, err, tc.expErr, tc.message)
+		} else {
+			assert.Nil(t, err, tc.message)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package sls
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// maxDebugSessionEnv caps the timeout of builds with a debug session, which stay paused at codebuild-breakpoint until they time out
+const maxDebugSessionEnv = "SD_SLS_MAX_DEBUG_SESSION_MINS"
+
+// defaultMaxDebugSessionMins is the debug session cap when SD_SLS_MAX_DEBUG_SESSION_MINS is unset
+const defaultMaxDebugSessionMins = 60
+
+// gets the max duration in minutes of a build with a debug session
+func maxDebugSessionMinutes() int64 {
+	mins, err := strconv.ParseInt(strings.TrimSpace(os.Getenv(maxDebugSessionEnv)), 10, 64)
+	if err != nil || mins <= 0 {
+		return defaultMaxDebugSessionMins
+	}
+	return mins
+}
+
+// gets the timeout in minutes of the build, capped to the max debug duration when a debug session is enabled.
+// The keep alive of failed builds is added on top.
+func debugTimeout(provider map[string]interface{}, buildTimeout int64) int64 {
+	if enabled, _ := provider["debugSession"].(bool); !enabled {
+		return buildTimeout
+	}
+	if max := maxDebugSessionMinutes(); buildTimeout <= 0 || buildTimeout > max {
+		buildTimeout = max
+	}
+	return buildTimeout + keepAliveMinutes(provider)
+}
+
+// DebugSession polls until timeout for the session manager target of a build started with debugSession.
+// It returns an empty session if the provider has no debug session or the target is not available yet.
+func (e *AwsServerless) DebugSession(config map[string]interface{}, timeout time.Duration) (executorState.DebugSession, error) {
+	provider := config["provider"].(map[string]interface{})
+	if enabled, _ := provider["debugSession"].(bool); !enabled {
+		return executorState.DebugSession{}, nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		build, err := getMainBuild(e.serviceClient, config)
+		if err != nil {
+			return executorState.DebugSession{}, err
+		}
+		if build != nil && build.DebugSession != nil && aws.StringValue(build.DebugSession.SessionTarget) != "" {
+			target := aws.StringValue(build.DebugSession.SessionTarget)
+			session := executorState.DebugSession{
+				Target:  target,
+				Command: fmt.Sprintf("aws ssm start-session --target %s --region %s", target, getBuildRegion(provider)),
+			}
+			if build.StartTime != nil && build.TimeoutInMinutes != nil {
+				session.ExpiresAt = build.StartTime.Add(time.Duration(*build.TimeoutInMinutes) * time.Minute)
+			}
+			return session, nil
+		}
+		if build != nil && aws.StringValue(build.BuildStatus) != codebuild.StatusTypeInProgress {
+			return executorState.DebugSession{}, fmt.Errorf("build %s finished with status %s before the debug session started", aws.StringValue(build.Id), aws.StringValue(build.BuildStatus))
+		}
+		if time.Now().After(deadline) {
+			return executorState.DebugSession{}, nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
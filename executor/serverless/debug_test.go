@@ -0,0 +1,68 @@
+package sls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestDebugTimeout(t *testing.T) {
+	assert.Equal(t, int64(90), debugTimeout(map[string]interface{}{"debugSession": false}, 90))
+	assert.Equal(t, int64(60), debugTimeout(map[string]interface{}{"debugSession": true}, 90))
+	assert.Equal(t, int64(20), debugTimeout(map[string]interface{}{"debugSession": true}, 20))
+
+	t.Setenv(maxDebugSessionEnv, "15")
+	assert.Equal(t, int64(15), debugTimeout(map[string]interface{}{"debugSession": true}, 20))
+	t.Setenv(maxDebugSessionEnv, "invalid")
+	assert.Equal(t, int64(60), debugTimeout(map[string]interface{}{"debugSession": true}, 0))
+}
+
+func TestDebugSession(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 5 * time.Second }()
+
+	withTarget := buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "QUEUED", "PROVISIONING", "BUILD")
+	withTarget.StartTime = aws.Time(testBuildStart)
+	withTarget.TimeoutInMinutes = aws.Int64(60)
+	withTarget.DebugSession = &codebuild.DebugSession{SessionEnabled: aws.Bool(true), SessionTarget: aws.String("i-0abc")}
+
+	testCases := []struct {
+		message  string
+		enabled  bool
+		build    *codebuild.Build
+		expected executorState.DebugSession
+		err      string
+	}{
+		{message: "disabled", build: withTarget},
+		{message: "ready", enabled: true, build: withTarget, expected: executorState.DebugSession{
+			Target:    "i-0abc",
+			Command:   "aws ssm start-session --target i-0abc --region us-west-2",
+			ExpiresAt: testBuildStart.Add(time.Hour),
+		}},
+		{message: "not ready", enabled: true, build: buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "QUEUED")},
+		{message: "finished", enabled: true, build: buildWithPhases("p:1", "FAILED", "SUBMITTED"), err: "build p:1 finished with status FAILED before the debug session started"},
+	}
+	for _, tc := range testCases {
+		mockServiceClient, mockCBAPI, _ := setup()
+		mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"p:1"})}).
+			Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{tc.build}}, nil)
+		executor := &AwsServerless{serviceClient: mockServiceClient}
+
+		config := map[string]interface{}{
+			"codebuildBuildId": "p:1",
+			"provider":         map[string]interface{}{"debugSession": tc.enabled, "region": "us-west-2"},
+		}
+		session, err := executor.DebugSession(config, 2*time.Millisecond)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.message)
+		} else {
+			assert.Nil(t, err, tc.message)
+		}
+		assert.Equal(t, tc.expected, session, tc.message)
+	}
+}
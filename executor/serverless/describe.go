@@ -0,0 +1,36 @@
+package sls
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Describe reports the codebuild compute and environment types and whether the build bucket exists
+func (e *AwsServerless) Describe(config map[string]interface{}) (map[string]interface{}, error) {
+	provider := config["provider"].(map[string]interface{})
+	bucket, err := BucketName(provider)
+	if err != nil {
+		return nil, fmt.Errorf("Got error getting bucket name: %v", err)
+	}
+
+	capabilities := map[string]interface{}{
+		"computeTypes":     codebuild.ComputeType_Values(),
+		"environmentTypes": codebuild.EnvironmentType_Values(),
+		"bucket":           bucket,
+		"bucketPresent":    false,
+	}
+	if bucket == "" {
+		capabilities["bucketError"] = "build bucket is not configured"
+		return capabilities, nil
+	}
+	if _, err := e.serviceClient.s3.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		capabilities["bucketError"] = err.Error()
+	} else {
+		capabilities["bucketPresent"] = true
+	}
+
+	return capabilities, nil
+}
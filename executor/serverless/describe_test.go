@@ -0,0 +1,44 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribe(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-builds-usw2")
+	mockServiceClient, _, mockS3API := setup()
+	mockS3API.On("HeadBucket", &s3.HeadBucketInput{Bucket: aws.String("sd-builds-usw2")}).Return(&s3.HeadBucketOutput{}, nil)
+	mockS3API.On("HeadBucket", &s3.HeadBucketInput{Bucket: aws.String("sd-builds-use1")}).Return(&s3.HeadBucketOutput{}, errors.New("NotFound"))
+	executor := &AwsServerless{serviceClient: mockServiceClient}
+
+	got, err := executor.Describe(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"computeTypes":     codebuild.ComputeType_Values(),
+		"environmentTypes": codebuild.EnvironmentType_Values(),
+		"bucket":           "sd-builds-usw2",
+		"bucketPresent":    true,
+	}, got)
+
+	config := getTestConfig()
+	config["provider"].(map[string]interface{})["buildRegion"] = "us-east-1"
+	got, err = executor.Describe(config)
+	assert.Nil(t, err)
+	assert.Equal(t, false, got["bucketPresent"])
+	assert.Equal(t, "NotFound", got["bucketError"])
+
+	config["provider"].(map[string]interface{})["buildRegion"] = "mars-1"
+	_, err = executor.Describe(config)
+	assert.EqualError(t, err, `Got error getting bucket name: invalid region "mars-1"`)
+
+	t.Setenv("SD_SLS_BUILD_BUCKET", "")
+	got, err = executor.Describe(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, "build bucket is not configured", got["bucketError"])
+}
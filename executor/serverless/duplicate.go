@@ -0,0 +1,35 @@
+package sls
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// adopts the codebuild build of an earlier delivery of the start of the build, so a start delivered twice runs a single
+// build. Returns false when the project has no build of the screwdriver build or it can't be looked up.
+func adoptExisting(serviceClient *awsAPI, config map[string]interface{}) bool {
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	build, err := findProjectBuild(serviceClient, config)
+	if err != nil {
+		log.Printf("Error looking up existing codebuild builds of build %v, starting it: %v", buildID, err)
+		return false
+	}
+	if build == nil {
+		return false
+	}
+	batchID, err := buildBatchID(build)
+	if err != nil {
+		log.Printf("Error adopting codebuild build %v of build %v, starting it: %v", aws.StringValue(build.Id), buildID, err)
+		return false
+	}
+	if batchID != "" {
+		config["codebuildBatchId"] = batchID
+	} else {
+		config["codebuildBuildId"] = aws.StringValue(build.Id)
+	}
+	log.Printf("Build %v already has codebuild build %v (%v), adopting it instead of starting another",
+		buildID, aws.StringValue(build.Id), aws.StringValue(build.BuildStatus))
+	return true
+}
@@ -0,0 +1,65 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// gets an in progress codebuild build started for the screwdriver build
+func testBuildOf(sdBuildID string) *codebuild.Build {
+	build := buildWithPhases("deploy-123:"+sdBuildID, "IN_PROGRESS")
+	build.Environment = &codebuild.ProjectEnvironment{EnvironmentVariables: []*codebuild.EnvironmentVariable{{Name: aws.String("SDBUILDID"), Value: aws.String(sdBuildID)}}}
+	return build
+}
+
+func TestStartAdoptsExistingBuild(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_BUCKET", testBucket)
+	mockServiceClient, mockCBAPI, mockS3API := setup()
+	mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{}, nil)
+	mockCBAPI.On("BatchGetProjects", &codebuild.BatchGetProjectsInput{Names: aws.StringSlice([]string{"deploy-123"})}).
+		Return(&codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{{Name: aws.String("deploy-123"), Arn: aws.String("arn:aws:codebuild:project//deploy-123")}}}, nil)
+	mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String("deploy-123"), SortOrder: aws.String("DESCENDING")}).
+		Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:1234"})}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:1234"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{testBuildOf("1234")}}, nil)
+
+	config := getTestConfig()
+	got, err := (&AwsServerless{serviceClient: mockServiceClient}).Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:aws:codebuild:project//deploy-123", got)
+	assert.Equal(t, "deploy-123:1234", config["codebuildBuildId"])
+	mockCBAPI.AssertNotCalled(t, "UpdateProject", mock.Anything)
+	mockCBAPI.AssertNotCalled(t, "StartBuild", mock.Anything)
+}
+
+func TestAdoptExisting(t *testing.T) {
+	mockServiceClient, mockCBAPI, _ := setup()
+	listInput := &codebuild.ListBuildsForProjectInput{ProjectName: aws.String("deploy-123"), SortOrder: aws.String("DESCENDING")}
+	mockCBAPI.On("ListBuildsForProject", listInput).
+		Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:1235", "deploy-123:1234"})}, nil).Once()
+	batched := testBuildOf("1234")
+	batched.BuildBatchArn = aws.String("arn:aws:codebuild:us-west-2:111111111:build-batch/deploy-123:batch")
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:1235", "deploy-123:1234"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{testBuildOf("1235"), batched}}, nil)
+	config := getTestConfig()
+	assert.True(t, adoptExisting(mockServiceClient, config))
+	assert.Equal(t, "deploy-123:batch", config["codebuildBatchId"])
+	assert.Nil(t, config["codebuildBuildId"])
+
+	// builds of other screwdriver builds are not adopted
+	mockCBAPI.On("ListBuildsForProject", listInput).
+		Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:1235"})}, nil).Once()
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:1235"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{testBuildOf("1235")}}, nil)
+	assert.False(t, adoptExisting(mockServiceClient, getTestConfig()))
+
+	// a failed lookup starts the build
+	mockCBAPI.On("ListBuildsForProject", listInput).Return(&codebuild.ListBuildsForProjectOutput{}, errors.New("ThrottlingException: Rate exceeded")).Once()
+	assert.False(t, adoptExisting(mockServiceClient, getTestConfig()))
+}
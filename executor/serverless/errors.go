@@ -0,0 +1,29 @@
+package sls
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// categories of the aws error codes of failed starts, other codes are left uncategorized
+var errorCategories = map[string]executorState.Category{
+	codebuild.ErrCodeInvalidInputException:         executorState.UserError,
+	codebuild.ErrCodeAccountLimitExceededException: executorState.InfraTransient,
+	"ThrottlingException":                          executorState.InfraTransient,
+	"ServiceUnavailableException":                  executorState.InfraTransient,
+	request.ErrCodeRequestError:                    executorState.InfraTransient,
+	"AccessDeniedException":                        executorState.InfraPermanent,
+	codebuild.ErrCodeOAuthProviderException:        executorState.InfraPermanent,
+	codebuild.ErrCodeResourceNotFoundException:     executorState.InfraPermanent,
+}
+
+// gets the executor category of a failed aws api call
+func errorCategory(err error) executorState.Category {
+	if aerr, ok := err.(awserr.Error); ok {
+		return errorCategories[aerr.Code()]
+	}
+	return ""
+}
@@ -0,0 +1,21 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestErrorCategory(t *testing.T) {
+	assert.Equal(t, executorState.UserError, errorCategory(awserr.New(codebuild.ErrCodeInvalidInputException, "Invalid compute type", nil)))
+	assert.Equal(t, executorState.InfraTransient, errorCategory(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.Equal(t, executorState.InfraPermanent, errorCategory(awserr.New("AccessDeniedException", "not authorized", nil)))
+	assert.Equal(t, executorState.Category(""), errorCategory(awserr.New("UnknownError", "unknown", nil)))
+	assert.Equal(t, executorState.Category(""), errorCategory(errors.New("unknown")))
+
+}
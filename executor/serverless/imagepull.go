@@ -0,0 +1,29 @@
+package sls
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/codebuild"
+)
+
+// ImagePullStartTime returns the start of the PROVISIONING phase, in which codebuild pulls the build image,
+// falling back to the DOWNLOAD_SOURCE phase. It polls until timeout and returns a zero time if neither started.
+func (e *AwsServerless) ImagePullStartTime(config map[string]interface{}, timeout time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		build, err := getMainBuild(e.serviceClient, config)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if started := phaseStartTime(build, codebuild.BuildPhaseTypeProvisioning); !started.IsZero() {
+			return started, nil
+		}
+		if started := phaseStartTime(build, codebuild.BuildPhaseTypeDownloadSource); !started.IsZero() {
+			return started, nil
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
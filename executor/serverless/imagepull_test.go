@@ -0,0 +1,35 @@
+package sls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagePullStartTime(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 5 * time.Second }()
+
+	testCases := []struct {
+		message  string
+		build    *codebuild.Build
+		expected time.Time
+	}{
+		{message: "provisioning", build: buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "QUEUED", "PROVISIONING"), expected: testBuildStart.Add(2 * time.Second)},
+		{message: "download source", build: buildWithPhases("p:1", "IN_PROGRESS", "DOWNLOAD_SOURCE"), expected: testBuildStart},
+		{message: "not started", build: buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "QUEUED")},
+	}
+	for _, tc := range testCases {
+		mockServiceClient, mockCBAPI, _ := setup()
+		mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"p:1"})}).
+			Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{tc.build}}, nil)
+		executor := &AwsServerless{serviceClient: mockServiceClient}
+
+		started, err := executor.ImagePullStartTime(map[string]interface{}{"codebuildBuildId": "p:1"}, 2*time.Millisecond)
+		assert.Nil(t, err, tc.message)
+		assert.Equal(t, tc.expected, started, tc.message)
+	}
+}
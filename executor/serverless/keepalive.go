@@ -0,0 +1,52 @@
+package sls
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+)
+
+// keepAliveEnv gates the post_build sleep keeping the environment of a failed build alive, in minutes
+const keepAliveEnv = "SD_KEEP_ALIVE_MINUTES"
+
+// gets the minutes a failed build with a debug session is kept alive, 0 when it is not.
+// They are capped to the max debug duration like the build timeout.
+func keepAliveMinutes(provider map[string]interface{}) int64 {
+	if enabled, _ := provider["debugSession"].(bool); !enabled {
+		return 0
+	}
+	mins, err := strconv.ParseInt(fmt.Sprint(provider["keepAliveMinutes"]), 10, 64)
+	if err != nil || mins <= 0 {
+		return 0
+	}
+	if max := maxDebugSessionMinutes(); mins > max {
+		return max
+	}
+	return mins
+}
+
+// gets the post_build command sleeping for SD_KEEP_ALIVE_MINUTES when the build failed,
+// without double quotes as the main buildspec is quoted into the batch buildspec
+func keepAliveCommand(environmentType string) string {
+	if isWindows(environmentType) {
+		return "if ($env:CODEBUILD_BUILD_SUCCEEDING -eq 0 -and [int]$env:" + keepAliveEnv + " -gt 0) { Write-Output 'Keeping the failed build alive for debugging'; Start-Sleep -Seconds ([int]$env:" + keepAliveEnv + " * 60) }"
+	}
+	return "if [ ${CODEBUILD_BUILD_SUCCEEDING:-1} = 0 ] && [ ${" + keepAliveEnv + ":-0} -gt 0 ]; then echo 'Keeping the failed build alive for debugging'; sleep $((" + keepAliveEnv + " * 60)); fi"
+}
+
+// gets the build kept alive after a failure, nil if the build is not in its keep alive phase
+func keptAliveBuild(serviceClient *awsAPI, config map[string]interface{}) *codebuild.Build {
+	build, err := getMainBuild(serviceClient, config)
+	if err != nil {
+		build, err = findProjectBuild(serviceClient, config)
+	}
+	if err != nil || build == nil {
+		return nil
+	}
+	if aws.StringValue(build.BuildStatus) != codebuild.StatusTypeInProgress || aws.StringValue(build.CurrentPhase) != codebuild.BuildPhaseTypePostBuild {
+		return nil
+	}
+	return build
+}
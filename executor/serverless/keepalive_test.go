@@ -0,0 +1,85 @@
+package sls
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// returns the test config of a build with a debug session kept alive after a failure
+func getKeepAliveConfig(minutes string) map[string]interface{} {
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["debugSession"] = true
+	provider["keepAliveMinutes"] = json.Number(minutes)
+	return config
+}
+
+func TestKeepAliveMinutes(t *testing.T) {
+	assert.Equal(t, int64(0), keepAliveMinutes(map[string]interface{}{"debugSession": true}))
+	assert.Equal(t, int64(0), keepAliveMinutes(map[string]interface{}{"debugSession": false, "keepAliveMinutes": json.Number("10")}))
+	assert.Equal(t, int64(0), keepAliveMinutes(map[string]interface{}{"debugSession": true, "keepAliveMinutes": json.Number("-1")}))
+	assert.Equal(t, int64(10), keepAliveMinutes(map[string]interface{}{"debugSession": true, "keepAliveMinutes": json.Number("10")}))
+	assert.Equal(t, int64(60), keepAliveMinutes(map[string]interface{}{"debugSession": true, "keepAliveMinutes": json.Number("600")}))
+
+	// the keep alive extends the timeout of the build
+	assert.Equal(t, int64(30), debugTimeout(map[string]interface{}{"debugSession": true, "keepAliveMinutes": json.Number("10")}, 20))
+	t.Setenv(maxDebugSessionEnv, "15")
+	assert.Equal(t, int64(30), debugTimeout(map[string]interface{}{"debugSession": true, "keepAliveMinutes": json.Number("20")}, 20))
+}
+
+func TestKeepAliveBuildSpec(t *testing.T) {
+	config := getKeepAliveConfig("10")
+	request, batchBuildSpec := getRequestObject("deploy-123", testLauncherVersion, false, config)
+	command := "if [ ${CODEBUILD_BUILD_SUCCEEDING:-1} = 0 ] && [ ${SD_KEEP_ALIVE_MINUTES:-0} -gt 0 ]; then echo 'Keeping the failed build alive for debugging'; sleep $((SD_KEEP_ALIVE_MINUTES * 60)); fi"
+	assert.True(t, strings.HasSuffix(aws.StringValue(request.Source.Buildspec), "  post_build:\n    commands:\n       - "+command+"\n"), aws.StringValue(request.Source.Buildspec))
+	assert.Contains(t, batchBuildSpec, `\n  post_build:\n    commands:\n      - `+command+`"`)
+	assert.Contains(t, getEnvVars(config), &codebuild.EnvironmentVariable{Name: aws.String("SD_KEEP_ALIVE_MINUTES"), Value: aws.String("10")})
+
+	assert.Contains(t, keepAliveCommand("WINDOWS_SERVER_2019_CONTAINER"), "Start-Sleep -Seconds ([int]$env:SD_KEEP_ALIVE_MINUTES * 60)")
+
+	// builds without keep alive have no post_build phase
+	request, batchBuildSpec = getRequestObject("deploy-123", testLauncherVersion, false, getTestConfig())
+	assert.NotContains(t, aws.StringValue(request.Source.Buildspec), "post_build")
+	assert.NotContains(t, batchBuildSpec, "post_build")
+	for _, env := range getEnvVars(getTestConfig()) {
+		assert.NotEqual(t, "SD_KEEP_ALIVE_MINUTES", aws.StringValue(env.Name))
+	}
+}
+
+func TestStopKeepsFailedBuildAlive(t *testing.T) {
+	config := getKeepAliveConfig("10")
+	config["codebuildBuildId"] = "deploy-123:1"
+	config["provider"].(map[string]interface{})["prune"] = true
+
+	mockServiceClient, mockCBAPI, _ := setup()
+	build := buildWithPhases("deploy-123:1", "IN_PROGRESS", "SUBMITTED", "PROVISIONING", "BUILD", "POST_BUILD")
+	build.CurrentPhase = aws.String("POST_BUILD")
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:1"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{build}}, nil)
+	executor := &AwsServerless{serviceClient: mockServiceClient}
+	assert.Nil(t, executor.Stop(config))
+	mockCBAPI.AssertNotCalled(t, "StopBuild", mock.Anything)
+	mockCBAPI.AssertNotCalled(t, "DeleteProject", mock.Anything)
+
+	// builds still running the launcher are stopped
+	mockServiceClient, mockCBAPI, _ = setup()
+	build.CurrentPhase = aws.String("BUILD")
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:1"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{build}}, nil)
+	mockCBAPI.On("BatchGetProjects", mock.Anything).Return(&codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{
+		{Name: aws.String("deploy-123"), Tags: []*codebuild.Tag{{Key: aws.String("sd-start-mode"), Value: aws.String("build")}}},
+	}}, nil)
+	mockCBAPI.On("ListBuildsForProject", mock.Anything).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:1"})}, nil)
+	mockCBAPI.On("StopBuild", mock.Anything).Return(&codebuild.StopBuildOutput{Build: &codebuild.Build{BuildNumber: aws.Int64(1)}}, nil)
+	mockCBAPI.On("DeleteProject", mock.Anything).Return(&codebuild.DeleteProjectOutput{}, nil)
+	executor = &AwsServerless{serviceClient: mockServiceClient}
+	assert.Nil(t, executor.Stop(config))
+	mockCBAPI.AssertCalled(t, "StopBuild", &codebuild.StopBuildInput{Id: aws.String("deploy-123:1")})
+	mockCBAPI.AssertCalled(t, "DeleteProject", &codebuild.DeleteProjectInput{Name: aws.String("deploy-123")})
+}
@@ -0,0 +1,54 @@
+package sls
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// lambdaComputePrefix prefixes the compute types running builds on lambda, BUILD_LAMBDA_1GB to BUILD_LAMBDA_10GB
+	lambdaComputePrefix = "BUILD_LAMBDA_"
+	// lambdaLauncherDir is where lambda builds install the launcher, /tmp is the only writable path on lambda
+	lambdaLauncherDir = "/tmp/sd"
+)
+
+// lambda environment types of the container environment types, lambda compute runs linux and arm containers only
+var lambdaEnvironmentTypes = map[string]string{
+	"LINUX_CONTAINER": "LINUX_LAMBDA_CONTAINER",
+	"ARM_CONTAINER":   "ARM_LAMBDA_CONTAINER",
+}
+
+// isLambda checks if the codebuild compute type runs builds on lambda
+func isLambda(computeType string) bool {
+	return strings.HasPrefix(computeType, lambdaComputePrefix)
+}
+
+// isLambdaEnvironment checks if the codebuild environment type runs lambda containers
+func isLambdaEnvironment(environmentType string) bool {
+	return strings.HasSuffix(environmentType, "_LAMBDA_CONTAINER")
+}
+
+// options of the provider lambda compute does not support
+var lambdaUnsupported = []string{"privilegedMode", "dlc", "debugSession"}
+
+// checks that the provider of a build on lambda compute enables none of the options lambda does not support
+func checkLambda(provider map[string]interface{}) error {
+	for _, key := range lambdaUnsupported {
+		if enabled, _ := provider[key].(bool); enabled {
+			return fmt.Errorf("%v is not supported by lambda compute type %v", key, provider["computeType"])
+		}
+	}
+	return nil
+}
+
+// adjusts the provider of a build on lambda compute. Lambda runs the build in a lambda environment,
+// without privileged mode, local caches or debug sessions, and within its own 15 minute limit.
+// Builds enabling them are rejected by checkLambda.
+func applyLambda(provider map[string]interface{}) {
+	if environmentType, ok := lambdaEnvironmentTypes[provider["environmentType"].(string)]; ok {
+		provider["environmentType"] = environmentType
+	}
+	for _, key := range lambdaUnsupported {
+		provider[key] = false
+	}
+}
@@ -0,0 +1,65 @@
+package sls
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestIsLambda(t *testing.T) {
+	assert.True(t, isLambda("BUILD_LAMBDA_1GB"))
+	assert.True(t, isLambda("BUILD_LAMBDA_10GB"))
+	assert.False(t, isLambda("BUILD_GENERAL1_SMALL"))
+	assert.True(t, isLambdaEnvironment("ARM_LAMBDA_CONTAINER"))
+	assert.False(t, isLambdaEnvironment("LINUX_CONTAINER"))
+}
+
+func TestCheckLambda(t *testing.T) {
+	assert.Nil(t, checkLambda(map[string]interface{}{"computeType": "BUILD_LAMBDA_2GB", "privilegedMode": false}))
+	assert.EqualError(t, checkLambda(map[string]interface{}{"computeType": "BUILD_LAMBDA_2GB", "dlc": true}),
+		"dlc is not supported by lambda compute type BUILD_LAMBDA_2GB")
+
+	testConfig := getTestConfig()
+	provider := testConfig["provider"].(map[string]interface{})
+	provider["computeType"] = "BUILD_LAMBDA_4GB"
+	provider["privilegedMode"] = true
+	_, err := (&AwsServerless{}).Start(testConfig)
+	assert.EqualError(t, err, "privilegedMode is not supported by lambda compute type BUILD_LAMBDA_4GB")
+	assert.Equal(t, executorState.UserError, executorState.CategoryOf(err))
+}
+
+func TestApplyLambda(t *testing.T) {
+	provider := map[string]interface{}{"computeType": "BUILD_LAMBDA_2GB", "environmentType": "ARM_CONTAINER"}
+	applyLambda(provider)
+	assert.Equal(t, map[string]interface{}{"computeType": "BUILD_LAMBDA_2GB", "environmentType": "ARM_LAMBDA_CONTAINER", "privilegedMode": false, "dlc": false, "debugSession": false}, provider)
+
+	provider = map[string]interface{}{"computeType": "BUILD_LAMBDA_2GB", "environmentType": "LINUX_LAMBDA_CONTAINER"}
+	applyLambda(provider)
+	assert.Equal(t, "LINUX_LAMBDA_CONTAINER", provider["environmentType"])
+}
+
+func TestGetRequestObjectLambda(t *testing.T) {
+	testConfig := getTestConfig()
+	provider := testConfig["provider"].(map[string]interface{})
+	provider["computeType"] = "BUILD_LAMBDA_4GB"
+
+	createRequest, _ := getRequestObject("project", "v101", false, testConfig)
+	assert.Equal(t, "LINUX_LAMBDA_CONTAINER", aws.StringValue(createRequest.Environment.Type))
+	assert.Equal(t, "BUILD_LAMBDA_4GB", aws.StringValue(createRequest.Environment.ComputeType))
+	assert.False(t, aws.BoolValue(createRequest.Environment.PrivilegedMode))
+	assert.Nil(t, createRequest.QueuedTimeoutInMinutes)
+	assert.Nil(t, createRequest.TimeoutInMinutes)
+	assert.Nil(t, createRequest.Cache)
+	assert.Equal(t, "version: 0.2\nphases:\n  install:\n    commands:\n       - mkdir -p /tmp/sd && cp -r $CODEBUILD_SRC_DIR/opt/sd/* /tmp/sd/\n  build:\n    commands:\n       - /tmp/sd/launcher_entrypoint.sh /tmp/sd/run.sh $TOKEN $API $STORE $TIMEOUT $SDBUILDID $UI\n",
+		aws.StringValue(createRequest.Source.Buildspec))
+
+	testConfig = getTestConfig()
+	provider = testConfig["provider"].(map[string]interface{})
+	provider["computeType"] = "BUILD_LAMBDA_1GB"
+	provider["architecture"] = "arm64"
+	createRequest, _ = getRequestObject("project", "v101-arm64", false, testConfig)
+	assert.Equal(t, "ARM_LAMBDA_CONTAINER", aws.StringValue(createRequest.Environment.Type))
+}
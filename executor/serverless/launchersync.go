@@ -0,0 +1,188 @@
+package sls
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+const (
+	// launcherSyncEnv selects how a missing sdinit bundle is staged into the build bucket,
+	// "job" (default) runs an internal sync build first, "batch" runs the build as a batch build graph
+	launcherSyncEnv = "SD_SLS_LAUNCHER_SYNC"
+	// launcherSyncTimeoutEnv is the time a start waits for the sync build before it is requeued
+	launcherSyncTimeoutEnv = "SD_SLS_LAUNCHER_SYNC_TIMEOUT_SECS"
+	// defaultLauncherSyncTimeout is the sync wait when SD_SLS_LAUNCHER_SYNC_TIMEOUT_SECS is unset
+	defaultLauncherSyncTimeout = 3 * time.Minute
+	// syncProjectPrefix prefixes the internal projects staging sdinit bundles
+	syncProjectPrefix = "sdinit-sync-"
+	// syncBuildTimeoutMins is the codebuild timeout of a sync build
+	syncBuildTimeoutMins = 15
+)
+
+// characters not allowed in codebuild project names
+var invalidProjectChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// checks if missing launcher bundles are staged by running the build as a batch build graph
+func batchLauncherSync() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv(launcherSyncEnv)), "batch")
+}
+
+// gets the time to wait for a launcher sync build
+func launcherSyncTimeout() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv(launcherSyncTimeoutEnv)))
+	if err != nil || secs <= 0 {
+		return defaultLauncherSyncTimeout
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// gets the name of the project staging the bundle into the bucket
+func syncProjectName(bucket string, bundle string) string {
+	return syncProjectPrefix + invalidProjectChars.ReplaceAllString(bucket+"-"+bundle, "-")
+}
+
+// gets the create project request of the project exporting /opt/sd of the launcher image as sdinit-<bundle>
+func getSyncProjectInput(project string, bundle string, config map[string]interface{}) *codebuild.CreateProjectInput {
+	provider := config["provider"].(map[string]interface{})
+	launcherImage := provider["launcherImage"].(string)
+	imagePullCredentialsType := "SERVICE_ROLE"
+	if strings.HasPrefix(launcherImage, "aws/codebuild/") {
+		imagePullCredentialsType = "CODEBUILD"
+	}
+	buildSpec := "version: 0.2\nphases:\n  build:\n    commands:\n      - ls /opt/sd\nartifacts:\n  base-directory: /opt\n  files:  \n    - '/opt/**/*'"
+
+	input := &codebuild.CreateProjectInput{
+		Name: aws.String(project),
+		Artifacts: &codebuild.ProjectArtifacts{
+			EncryptionDisabled:   aws.Bool(false),
+			Location:             aws.String(config["bucket"].(string)),
+			Name:                 aws.String(sdInitPrefix + bundle),
+			NamespaceType:        aws.String("NONE"),
+			OverrideArtifactName: aws.Bool(false),
+			Packaging:            aws.String("ZIP"),
+			Type:                 aws.String("S3"),
+		},
+		Source: &codebuild.ProjectSource{
+			Buildspec: aws.String(buildSpec),
+			Type:      aws.String("NO_SOURCE"),
+		},
+		ServiceRole:          aws.String(provider["role"].(string)),
+		TimeoutInMinutes:     aws.Int64(syncBuildTimeoutMins),
+		ConcurrentBuildLimit: aws.Int64(1),
+		Environment: &codebuild.ProjectEnvironment{
+			ComputeType:              aws.String(provider["launcherComputeType"].(string)),
+			Image:                    aws.String(launcherImage),
+			ImagePullCredentialsType: aws.String(imagePullCredentialsType),
+			PrivilegedMode:           aws.Bool(false),
+			Type:                     aws.String(provider["launcherEnvironmentType"].(string)),
+		},
+		EncryptionKey: aws.String(os.Getenv("SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS")),
+	}
+	if vpc, ok := provider["vpc"].(map[string]interface{}); ok {
+		input.VpcConfig = &codebuild.VpcConfig{
+			SecurityGroupIds: aws.StringSlice(stringSlice(vpc["securityGroupIds"])),
+			Subnets:          aws.StringSlice(stringSlice(vpc["subnetIds"])),
+			VpcId:            aws.String(vpc["vpcId"].(string)),
+		}
+	}
+	return input
+}
+
+// converts a json array of strings
+func stringSlice(value interface{}) []string {
+	var values []string
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// gets the id of the newest in progress build of the project, empty if none is running
+func inProgressBuild(serviceClient *awsAPI, project string) (string, error) {
+	ids, err := inProgressBuilds(serviceClient, project)
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return aws.StringValue(ids[0]), nil
+}
+
+// creates or updates the sync project and starts a sync build, returns the build id
+func startSyncBuild(serviceClient *awsAPI, project string, bundle string, config map[string]interface{}) (string, error) {
+	createRequest := getSyncProjectInput(project, bundle, config)
+	batchResult, err := serviceClient.cb.BatchGetProjects(&codebuild.BatchGetProjectsInput{Names: []*string{aws.String(project)}})
+	if err != nil {
+		log.Printf("Error-BatchGetProjects: %v, creating project", err)
+	}
+	if batchResult == nil || len(batchResult.Projects) == 0 {
+		if _, err := serviceClient.cb.CreateProject(createRequest); err != nil {
+			return "", fmt.Errorf("Error-CreateProject: %v", err)
+		}
+	} else {
+		updateRequest := codebuild.UpdateProjectInput(*createRequest)
+		if _, err := serviceClient.cb.UpdateProject(&updateRequest); err != nil {
+			return "", fmt.Errorf("Error-UpdateProject: %v", err)
+		}
+	}
+
+	startResult, err := serviceClient.cb.StartBuild(&codebuild.StartBuildInput{ProjectName: aws.String(project)})
+	if err != nil {
+		return "", fmt.Errorf("Error-StartBuild: %v", err)
+	}
+	if startResult.Build == nil {
+		return "", fmt.Errorf("sync build of project %s did not start", project)
+	}
+	return aws.StringValue(startResult.Build.Id), nil
+}
+
+// syncLauncher stages the sdinit bundle into the build bucket with an internal sync build.
+// Concurrent starts wait for the sync build already in progress. A capacity error is returned
+// when the sync does not finish within the sync timeout, so the start can be requeued.
+func syncLauncher(serviceClient *awsAPI, bundle string, config map[string]interface{}) error {
+	project := syncProjectName(config["bucket"].(string), bundle)
+	buildID, err := inProgressBuild(serviceClient, project)
+	if err != nil {
+		log.Printf("Error getting sync builds of project %q, starting sync: %v", project, err)
+	}
+	if buildID == "" {
+		if buildID, err = startSyncBuild(serviceClient, project, bundle, config); err != nil {
+			return err
+		}
+		log.Printf("Started launcher sync build %v", buildID)
+	} else {
+		log.Printf("Waiting for launcher sync build %v", buildID)
+	}
+
+	timeout := launcherSyncTimeout()
+	deadline := time.Now().Add(timeout)
+	for {
+		build, err := getBuild(serviceClient, buildID)
+		if err != nil {
+			return err
+		}
+		switch status := aws.StringValue(build.BuildStatus); status {
+		case codebuild.StatusTypeSucceeded:
+			log.Printf("Synced launcher %v with build %v", bundle, buildID)
+			return nil
+		case codebuild.StatusTypeInProgress:
+		default:
+			return fmt.Errorf("launcher sync build %v finished with status %v", buildID, status)
+		}
+		if time.Now().After(deadline) {
+			return executorState.CapacityErrorf("launcher %v is still syncing in build %v after %v", bundle, buildID, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,131 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestSyncProjectName(t *testing.T) {
+	assert.Equal(t, "sdinit-sync-sd-aws-consumer-usw2-bucket-v6-0-150-arm64", syncProjectName("sd-aws-consumer-usw2-bucket", "v6.0.150-arm64"))
+}
+
+func TestGetSyncProjectInput(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS", "alias/testKey")
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["launcherEnvironmentType"] = "ARM_CONTAINER"
+
+	input := getSyncProjectInput("sdinit-sync-project", "v101", config)
+	assert.Equal(t, "sdinit-sync-project", aws.StringValue(input.Name))
+	assert.Equal(t, testBucket, aws.StringValue(input.Artifacts.Location))
+	assert.Equal(t, "sdinit-v101", aws.StringValue(input.Artifacts.Name))
+	assert.Equal(t, "NONE", aws.StringValue(input.Artifacts.NamespaceType))
+	assert.Equal(t, "NO_SOURCE", aws.StringValue(input.Source.Type))
+	assert.Equal(t, "ARM_CONTAINER", aws.StringValue(input.Environment.Type))
+	assert.Equal(t, "launcher:v101", aws.StringValue(input.Environment.Image))
+	assert.Equal(t, "SERVICE_ROLE", aws.StringValue(input.Environment.ImagePullCredentialsType))
+	assert.Equal(t, int64(1), aws.Int64Value(input.ConcurrentBuildLimit))
+	assert.Equal(t, []string{"subnet-1111", "subnet-2222", "subnet-3333"}, aws.StringValueSlice(input.VpcConfig.Subnets))
+	assert.Equal(t, "alias/testKey", aws.StringValue(input.EncryptionKey))
+}
+
+func TestSyncLauncher(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+	config := getTestConfig()
+	project := syncProjectName(testBucket, "v102")
+	listInput := &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(project), SortOrder: aws.String("DESCENDING")}
+
+	// starts a sync build when none is running
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockCBAPI.On("ListBuildsForProject", listInput).Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+	mockCBAPI.On("BatchGetProjects", mock.Anything).Return(&codebuild.BatchGetProjectsOutput{}, nil)
+	mockCBAPI.On("CreateProject", getSyncProjectInput(project, "v102", config)).Return(&codebuild.CreateProjectOutput{}, nil)
+	mockCBAPI.On("StartBuild", &codebuild.StartBuildInput{ProjectName: aws.String(project)}).
+		Return(&codebuild.StartBuildOutput{Build: &codebuild.Build{Id: aws.String(project + ":1")}}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{project + ":1"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String(project + ":1"), BuildStatus: aws.String("IN_PROGRESS")}}}, nil).Once()
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{project + ":1"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String(project + ":1"), BuildStatus: aws.String("SUCCEEDED")}}}, nil)
+	assert.Nil(t, syncLauncher(mockServiceClient, "v102", config))
+	mockCBAPI.AssertNumberOfCalls(t, "StartBuild", 1)
+
+	// waits for the sync build in progress and requeues when it does not finish in time
+	t.Setenv("SD_SLS_LAUNCHER_SYNC_TIMEOUT_SECS", "1")
+	mockServiceClient, mockCBAPI, _ = setup()
+	mockCBAPI.On("ListBuildsForProject", listInput).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{project + ":3", project + ":2"})}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{project + ":3", project + ":2"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{
+			{Id: aws.String(project + ":3"), BuildStatus: aws.String("IN_PROGRESS")},
+			{Id: aws.String(project + ":2"), BuildStatus: aws.String("SUCCEEDED")},
+		}}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{project + ":3"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String(project + ":3"), BuildStatus: aws.String("IN_PROGRESS")}}}, nil)
+	err := syncLauncher(mockServiceClient, "v102", config)
+	assert.EqualError(t, err, "launcher v102 is still syncing in build "+project+":3 after 1s")
+	assert.True(t, executorState.IsCapacity(err))
+	mockCBAPI.AssertNotCalled(t, "StartBuild", mock.Anything)
+
+	// fails when the sync build fails
+	mockServiceClient, mockCBAPI, _ = setup()
+	mockCBAPI.On("ListBuildsForProject", listInput).Return(&codebuild.ListBuildsForProjectOutput{}, errors.New("throttled"))
+	mockCBAPI.On("BatchGetProjects", mock.Anything).Return(&codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{{Name: aws.String(project)}}}, nil)
+	mockCBAPI.On("UpdateProject", mock.Anything).Return(&codebuild.UpdateProjectOutput{}, nil)
+	mockCBAPI.On("StartBuild", mock.Anything).Return(&codebuild.StartBuildOutput{Build: &codebuild.Build{Id: aws.String(project + ":4")}}, nil)
+	mockCBAPI.On("BatchGetBuilds", mock.Anything).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String(project + ":4"), BuildStatus: aws.String("FAILED")}}}, nil)
+	assert.EqualError(t, syncLauncher(mockServiceClient, "v102", config), "launcher sync build "+project+":4 finished with status FAILED")
+}
+
+func TestStartWithLauncherSync(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+	t.Setenv("SD_SLS_BUILD_BUCKET", testBucket)
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["launcherVersion"] = "v102"
+	syncProject := syncProjectName(testBucket, "v102")
+
+	mockServiceClient, mockCBAPI, mockS3API := setup()
+	mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{}, nil)
+	mockS3API.On("HeadObject", &s3.HeadObjectInput{Bucket: aws.String(testBucket), Key: aws.String("sdinit-v102")}).Return(&s3.HeadObjectOutput{VersionId: aws.String("3sL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY")}, nil)
+	mockCBAPI.On("BatchGetProjects", mock.Anything).Return(&codebuild.BatchGetProjectsOutput{}, nil)
+	mockCBAPI.On("CreateProject", mock.Anything).Return(&codebuild.CreateProjectOutput{Project: &codebuild.Project{Arn: aws.String("arn:project")}}, nil)
+	mockCBAPI.On("ListBuildsForProject", mock.Anything).Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+	mockCBAPI.On("StartBuild", &codebuild.StartBuildInput{ProjectName: aws.String(syncProject)}).
+		Return(&codebuild.StartBuildOutput{Build: &codebuild.Build{Id: aws.String(syncProject + ":1")}}, nil)
+	mockCBAPI.On("BatchGetBuilds", mock.Anything).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String(syncProject + ":1"), BuildStatus: aws.String("SUCCEEDED")}}}, nil)
+	mockCBAPI.On("StartBuild", mock.MatchedBy(func(input *codebuild.StartBuildInput) bool {
+		return aws.StringValue(input.ProjectName) == testJobName+"-"+testJobID && aws.StringValue(input.SourceVersion) == "3sL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY"
+	})).Return(&codebuild.StartBuildOutput{Build: &codebuild.Build{Id: aws.String("build:1")}}, nil)
+
+	executor := &AwsServerless{serviceClient: mockServiceClient}
+	_, err := executor.Start(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "build:1", config["codebuildBuildId"])
+	assert.Equal(t, map[string]interface{}{"launcherSourceVersion": "3sL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY"}, executor.BuildStats(config))
+	mockCBAPI.AssertNotCalled(t, "StartBuildBatch", mock.Anything)
+
+	// the build project is created after the sync and runs the bundle version it staged
+	var createInputs []*codebuild.CreateProjectInput
+	for _, call := range mockCBAPI.Calls {
+		if call.Method == "CreateProject" {
+			createInputs = append(createInputs, call.Arguments.Get(0).(*codebuild.CreateProjectInput))
+		}
+	}
+	assert.Equal(t, 2, len(createInputs))
+	assert.Equal(t, syncProject, aws.StringValue(createInputs[0].Name))
+	assert.Equal(t, testBucket+"/sdinit-v102", aws.StringValue(createInputs[1].Source.Location))
+	assert.Nil(t, createInputs[1].BuildBatchConfig)
+	assert.Equal(t, "3sL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY", aws.StringValue(createInputs[1].SourceVersion))
+}
@@ -0,0 +1,71 @@
+package sls
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+// gets the region the build runs in
+func getBuildRegion(provider map[string]interface{}) string {
+	if region, _ := provider["buildRegion"].(string); region != "" {
+		return region
+	}
+	region, _ := provider["region"].(string)
+	return region
+}
+
+// escapes a value for the cloudwatch console fragment, which encodes values twice with $ instead of %
+func cloudWatchEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "%", "$25")
+}
+
+// ResourceLinks returns the aws console links of the codebuild project and the started build
+func (e *AwsServerless) ResourceLinks(config map[string]interface{}) map[string]string {
+	provider := config["provider"].(map[string]interface{})
+	region := getBuildRegion(provider)
+	project := getProjectName(config)
+	projectPath := "codesuite/codebuild/projects/" + url.PathEscape(project)
+
+	links := map[string]string{
+		"project":    project,
+		"projectUrl": awsconfig.ConsoleURL(region, fmt.Sprintf("%s?region=%s", projectPath, region)),
+	}
+	if batchID, _ := config["codebuildBatchId"].(string); batchID != "" {
+		links["buildBatch"] = batchID
+		links["buildBatchUrl"] = awsconfig.ConsoleURL(region, fmt.Sprintf("%s/batch/%s/?region=%s", projectPath, url.PathEscape(batchID), region))
+	}
+	if buildID, _ := config["codebuildBuildId"].(string); buildID != "" {
+		links["build"] = buildID
+		links["buildUrl"] = awsconfig.ConsoleURL(region, fmt.Sprintf("%s/build/%s/?region=%s", projectPath, url.PathEscape(buildID), region))
+		// cloudwatch logs are only enabled with executorLogs, the stream is named after the build uuid
+		if executorLogs, _ := provider["executorLogs"].(bool); executorLogs {
+			stream := buildID[strings.LastIndex(buildID, ":")+1:]
+			links["logsUrl"] = awsconfig.ConsoleURL(region, fmt.Sprintf("cloudwatch/home?region=%s#logsV2:log-groups/log-group/%s/log-events/%s",
+				region, cloudWatchEscape("/aws/codebuild/"+project), cloudWatchEscape(stream)))
+		}
+	}
+	return links
+}
+
+// ResourceArn returns the arn of the started codebuild build or build batch, in the account of the service role of the
+// build, empty when neither is known
+func (e *AwsServerless) ResourceArn(config map[string]interface{}) string {
+	provider := config["provider"].(map[string]interface{})
+	role, _ := provider["role"].(string)
+	roleArn, err := arn.Parse(role)
+	if err != nil {
+		return ""
+	}
+	if batchID, _ := config["codebuildBatchId"].(string); batchID != "" {
+		return awsconfig.ARN(getBuildRegion(provider), "codebuild", roleArn.AccountID, "build-batch/"+batchID)
+	}
+	if buildID, _ := config["codebuildBuildId"].(string); buildID != "" {
+		return awsconfig.ARN(getBuildRegion(provider), "codebuild", roleArn.AccountID, "build/"+buildID)
+	}
+	return ""
+}
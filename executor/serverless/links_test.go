@@ -0,0 +1,63 @@
+package sls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildRegion(t *testing.T) {
+	assert.Equal(t, "us-east-1", getBuildRegion(map[string]interface{}{"region": "us-west-2", "buildRegion": "us-east-1"}))
+	assert.Equal(t, "us-west-2", getBuildRegion(map[string]interface{}{"region": "us-west-2", "buildRegion": ""}))
+}
+
+func TestResourceLinks(t *testing.T) {
+	executor := &AwsServerless{}
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["region"] = "us-west-2"
+	provider["buildRegion"] = ""
+	provider["executorLogs"] = true
+	config["codebuildBuildId"] = "deploy-123:2f1c7a1e-0c1d-4b6a-9d2e-5a1f0b3c4d5e"
+
+	assert.Equal(t, map[string]string{
+		"project":    "deploy-123",
+		"projectUrl": "https://console.aws.amazon.com/codesuite/codebuild/projects/deploy-123?region=us-west-2",
+		"build":      "deploy-123:2f1c7a1e-0c1d-4b6a-9d2e-5a1f0b3c4d5e",
+		"buildUrl":   "https://console.aws.amazon.com/codesuite/codebuild/projects/deploy-123/build/deploy-123:2f1c7a1e-0c1d-4b6a-9d2e-5a1f0b3c4d5e/?region=us-west-2",
+		"logsUrl":    "https://console.aws.amazon.com/cloudwatch/home?region=us-west-2#logsV2:log-groups/log-group/$252Faws$252Fcodebuild$252Fdeploy-123/log-events/2f1c7a1e-0c1d-4b6a-9d2e-5a1f0b3c4d5e",
+	}, executor.ResourceLinks(config))
+
+	config = getTestConfig()
+	provider = config["provider"].(map[string]interface{})
+	provider["region"] = "us-gov-west-1"
+	provider["buildRegion"] = ""
+	config["codebuildBatchId"] = "deploy-123:9a8b"
+	assert.Equal(t, map[string]string{
+		"project":       "deploy-123",
+		"projectUrl":    "https://console.amazonaws-us-gov.com/codesuite/codebuild/projects/deploy-123?region=us-gov-west-1",
+		"buildBatch":    "deploy-123:9a8b",
+		"buildBatchUrl": "https://console.amazonaws-us-gov.com/codesuite/codebuild/projects/deploy-123/batch/deploy-123:9a8b/?region=us-gov-west-1",
+	}, executor.ResourceLinks(config))
+}
+
+func TestResourceArn(t *testing.T) {
+	executor := &AwsServerless{}
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["region"] = "us-gov-west-1"
+	provider["buildRegion"] = ""
+	config["codebuildBuildId"] = "deploy-123:2f1c7a1e"
+	// the role is no arn
+	assert.Equal(t, "", executor.ResourceArn(config))
+
+	provider["role"] = "arn:aws-us-gov:iam::111111111:role/sd-build"
+	assert.Equal(t, "arn:aws-us-gov:codebuild:us-gov-west-1:111111111:build/deploy-123:2f1c7a1e", executor.ResourceArn(config))
+
+	config["codebuildBatchId"] = "deploy-123:9a8b"
+	assert.Equal(t, "arn:aws-us-gov:codebuild:us-gov-west-1:111111111:build-batch/deploy-123:9a8b", executor.ResourceArn(config))
+
+	delete(config, "codebuildBuildId")
+	delete(config, "codebuildBatchId")
+	assert.Equal(t, "", executor.ResourceArn(config))
+}
@@ -0,0 +1,43 @@
+//go:build integration
+
+package sls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/e2e"
+)
+
+// runs the sample build against LocalStack, go test -tags integration with SD_E2E_LOCALSTACK_URL set
+func TestLocalStackBuild(t *testing.T) {
+	ok, err := e2e.UseLocalStack()
+	assert.Nil(t, err)
+	if !ok {
+		t.Skip("SD_E2E_LOCALSTACK_URL is not set")
+	}
+	m, err := e2e.SampleMessage("sls")
+	assert.Nil(t, err)
+	region := m.BuildRegion()
+
+	sess, err := awsconfig.NewSession(region)
+	assert.Nil(t, err)
+	_, err = s3.New(sess).CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(m.BuildConfig["bucket"].(string))})
+	assert.Nil(t, err)
+
+	executor, err := New(region)
+	assert.Nil(t, err)
+	runner := e2e.NewRunner(executor)
+	runner.Interval = time.Second
+	runner.Timeout = 5 * time.Minute
+	runner.Cleanup = true
+	result, err := runner.Run(m.BuildConfig)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, m.BuildConfig["codebuildBuildId"])
+	assert.False(t, result.Stopped, "build did not finish: %+v", result)
+}
@@ -0,0 +1,84 @@
+package sls
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/screwdriver-cd/aws-consumer-service/tags"
+)
+
+// prefix of the cloudwatch log groups created by codebuild for a project
+const logGroupPrefix = "/aws/codebuild/"
+
+// logRetentionEnv is the retention in days of the project log groups
+const logRetentionEnv = "SD_SLS_LOG_RETENTION_DAYS"
+
+// defaultLogRetentionDays is the log group retention when SD_SLS_LOG_RETENTION_DAYS is unset
+const defaultLogRetentionDays = 30
+
+// retention periods supported by cloudwatch logs
+var retentionDays = []int64{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1827, 3653}
+
+// gets the log group retention in days, rounded up to a period supported by cloudwatch logs
+func logRetentionDays() int64 {
+	days, err := strconv.ParseInt(strings.TrimSpace(os.Getenv(logRetentionEnv)), 10, 64)
+	if err != nil || days <= 0 {
+		days = defaultLogRetentionDays
+	}
+	for _, supported := range retentionDays {
+		if days <= supported {
+			return supported
+		}
+	}
+	return retentionDays[len(retentionDays)-1]
+}
+
+// gets the tags of the project log group
+func logGroupTags(config map[string]interface{}) map[string]*string {
+	return aws.StringMap(tags.Build(config))
+}
+
+// creates the log group of the project codebuild writes the build logs to, instead of codebuild creating it
+// with infinite retention, and sets its retention
+func ensureLogGroup(serviceClient *awsAPI, config map[string]interface{}, project string) error {
+	logGroup := logGroupPrefix + project
+	_, err := serviceClient.logs.CreateLogGroup(&cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(logGroup),
+		Tags:         logGroupTags(config),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error-CreateLogGroup: %v", err)
+	}
+	_, err = serviceClient.logs.PutRetentionPolicy(&cloudwatchlogs.PutRetentionPolicyInput{
+		LogGroupName:    aws.String(logGroup),
+		RetentionInDays: aws.Int64(logRetentionDays()),
+	})
+	if err != nil {
+		return fmt.Errorf("Error-PutRetentionPolicy: %v", err)
+	}
+	return nil
+}
+
+// deletes the log group of the project, a missing log group is not an error
+func deleteLogGroup(serviceClient *awsAPI, project string) error {
+	logGroup := logGroupPrefix + project
+	_, err := serviceClient.logs.DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String(logGroup)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error-DeleteLogGroup: %v", err)
+	}
+	log.Printf("Deleted log group %q", logGroup)
+	return nil
+}
@@ -0,0 +1,95 @@
+package sls
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+)
+
+func (m *mockLogsClient) CreateLogGroup(input *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.CreateLogGroupOutput), args.Error(1)
+}
+
+func (m *mockLogsClient) PutRetentionPolicy(input *cloudwatchlogs.PutRetentionPolicyInput) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.PutRetentionPolicyOutput), args.Error(1)
+}
+
+func TestLogRetentionDays(t *testing.T) {
+	testCases := map[string]int64{"": 30, "invalid": 30, "7": 7, "10": 14, "365": 365, "5000": 3653}
+	for value, expected := range testCases {
+		t.Setenv(logRetentionEnv, value)
+		assert.Equal(t, expected, logRetentionDays(), value)
+	}
+}
+
+func TestEnsureLogGroup(t *testing.T) {
+	t.Setenv(logRetentionEnv, "14")
+	config := map[string]interface{}{"pipelineId": json.Number("1898"), "jobId": json.Number("123")}
+	createInput := &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String("/aws/codebuild/deploy-123"),
+		Tags: map[string]*string{
+			"managed-by":     aws.String("screwdriver"),
+			"sd-pipeline-id": aws.String("1898"),
+			"sd-job-id":      aws.String("123"),
+		},
+	}
+	retentionInput := &cloudwatchlogs.PutRetentionPolicyInput{LogGroupName: aws.String("/aws/codebuild/deploy-123"), RetentionInDays: aws.Int64(14)}
+
+	testCases := []struct {
+		message      string
+		createErr    error
+		retentionErr error
+		expErr       string
+	}{
+		{message: "created"},
+		{message: "exists", createErr: awserr.New(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "exists", nil)},
+		{message: "create failure", createErr: errors.New("AccessDenied"), expErr: "Error-CreateLogGroup: AccessDenied"},
+		{message: "retention failure", retentionErr: errors.New("AccessDenied"), expErr: "Error-PutRetentionPolicy: AccessDenied"},
+	}
+	for _, tc := range testCases {
+		mockServiceClient, _, _ := setup()
+		mockLogsAPI := new(mockLogsClient)
+		mockServiceClient.logs = mockLogsAPI
+		mockLogsAPI.On("CreateLogGroup", createInput).Return(&cloudwatchlogs.CreateLogGroupOutput{}, tc.createErr)
+		mockLogsAPI.On("PutRetentionPolicy", retentionInput).Return(&cloudwatchlogs.PutRetentionPolicyOutput{}, tc.retentionErr)
+
+		err := ensureLogGroup(mockServiceClient, config, "deploy-123")
+		if tc.expErr != "" {
+			assert.EqualError(t, err, tc.expErr, tc.message)
+		} else {
+			assert.Nil(t, err, tc.message)
+			mockLogsAPI.AssertExpectations(t)
+		}
+	}
+}
+
+func TestStopPruneDeletesLogGroup(t *testing.T) {
+	projectName := testJobName + "-" + testJobID
+	config := getTestConfig()
+	provider := config["provider"].(map[string]interface{})
+	provider["prune"] = true
+	provider["executorLogs"] = true
+	t.Setenv("SD_SLS_BUILD_BUCKET", testBucket)
+
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockLogsAPI := new(mockLogsClient)
+	mockServiceClient.logs = mockLogsAPI
+	mockCBAPI.On("BatchGetProjects", &codebuild.BatchGetProjectsInput{Names: []*string{aws.String(projectName)}}).Return(projectWithStartMode(projectName, startModeBuild), nil)
+	mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(projectName), SortOrder: aws.String("DESCENDING")}).
+		Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+	mockCBAPI.On("DeleteProject", &codebuild.DeleteProjectInput{Name: aws.String(projectName)}).Return(&codebuild.DeleteProjectOutput{}, nil)
+	mockLogsAPI.On("DeleteLogGroup", &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String("/aws/codebuild/" + projectName)}).
+		Return(&cloudwatchlogs.DeleteLogGroupOutput{}, nil).Once()
+
+	e := &AwsServerless{serviceClient: mockServiceClient}
+	assert.Nil(t, e.Stop(config))
+	mockLogsAPI.AssertExpectations(t)
+}
@@ -0,0 +1,116 @@
+package sls
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+)
+
+// maximum size of the logs collected for a screwdriver build
+const maxLogBytes = 1 << 20
+
+// gets the started builds of a batch, the launcher build comes before the main build
+func batchBuilds(serviceClient *awsAPI, batchID string) ([]*codebuild.Build, error) {
+	batchResult, err := serviceClient.cb.BatchGetBuildBatches(&codebuild.BatchGetBuildBatchesInput{Ids: []*string{aws.String(batchID)}})
+	if err != nil {
+		return nil, fmt.Errorf("Error-BatchGetBuildBatches: %v", err)
+	}
+	if len(batchResult.BuildBatches) == 0 {
+		return nil, fmt.Errorf("build batch %v not found", batchID)
+	}
+	var builds []*codebuild.Build
+	for _, group := range batchResult.BuildBatches[0].BuildGroups {
+		if group.CurrentBuildSummary == nil {
+			continue
+		}
+		id, err := groupBuildID(group)
+		if err != nil {
+			return nil, err
+		}
+		build, err := getBuild(serviceClient, id)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+// gets the codebuild builds of the screwdriver build
+func sdBuilds(serviceClient *awsAPI, config map[string]interface{}) ([]*codebuild.Build, error) {
+	if id, _ := config["codebuildBuildId"].(string); id != "" {
+		build, err := getBuild(serviceClient, id)
+		if err != nil {
+			return nil, err
+		}
+		return []*codebuild.Build{build}, nil
+	}
+	if batchID, _ := config["codebuildBatchId"].(string); batchID != "" {
+		return batchBuilds(serviceClient, batchID)
+	}
+	build, err := latestProjectBuild(serviceClient, config)
+	if err != nil {
+		return nil, err
+	}
+	batchID, err := buildBatchID(build)
+	if err != nil {
+		return nil, err
+	}
+	if batchID != "" {
+		return batchBuilds(serviceClient, batchID)
+	}
+	return []*codebuild.Build{build}, nil
+}
+
+// writes the cloudwatch log events of a build since the given time, up to maxLogBytes in total
+func writeBuildLogs(serviceClient *awsAPI, build *codebuild.Build, since time.Time, buf *bytes.Buffer) error {
+	if build.Logs == nil || aws.StringValue(build.Logs.GroupName) == "" || aws.StringValue(build.Logs.StreamName) == "" {
+		return fmt.Errorf("cloudwatch logs are not enabled for build %s, enable executorLogs in the provider", aws.StringValue(build.Id))
+	}
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  build.Logs.GroupName,
+		LogStreamName: build.Logs.StreamName,
+		StartFromHead: aws.Bool(true),
+		StartTime:     aws.Int64(since.UnixNano() / int64(time.Millisecond)),
+	}
+	for {
+		eventsResult, err := serviceClient.logs.GetLogEvents(input)
+		if err != nil {
+			return fmt.Errorf("Error-GetLogEvents: %v", err)
+		}
+		for _, event := range eventsResult.Events {
+			if buf.Len()+len(aws.StringValue(event.Message)) > maxLogBytes {
+				return fmt.Errorf("logs truncated at %d bytes", maxLogBytes)
+			}
+			buf.WriteString(aws.StringValue(event.Message))
+		}
+		// the same forward token is returned at the end of the stream
+		if len(eventsResult.Events) == 0 || aws.StringValue(eventsResult.NextForwardToken) == aws.StringValue(input.NextToken) {
+			return nil
+		}
+		input.NextToken = eventsResult.NextForwardToken
+	}
+}
+
+// Logs gets the cloudwatch logs of the launcher and main codebuild builds since the given time
+func (e *AwsServerless) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	builds, err := sdBuilds(e.serviceClient, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(builds) == 0 {
+		return nil, fmt.Errorf("no codebuild build started yet")
+	}
+	buf := new(bytes.Buffer)
+	for _, build := range builds {
+		fmt.Fprintf(buf, "==> codebuild build %s (%s) <==\n", aws.StringValue(build.Id), aws.StringValue(build.BuildStatus))
+		if err := writeBuildLogs(e.serviceClient, build, since, buf); err != nil {
+			fmt.Fprintf(buf, "%v\n", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+package sls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockLogsClient struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	mock.Mock
+}
+
+func (m *mockLogsClient) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*cloudwatchlogs.GetLogEventsOutput), args.Error(1)
+}
+
+func TestLogs(t *testing.T) {
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockLogsAPI := new(mockLogsClient)
+	mockServiceClient.logs = mockLogsAPI
+	e := &AwsServerless{serviceClient: mockServiceClient}
+	since := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	launcherBuild := buildWithPhases("deploy-123:init", "SUCCEEDED")
+	launcherBuild.Logs = &codebuild.LogsLocation{GroupName: aws.String("/aws/codebuild/deploy-123"), StreamName: aws.String("init")}
+	mainBuild := buildWithPhases("deploy-123:abc", "FAILED")
+	mockCBAPI.On("BatchGetBuildBatches", &codebuild.BatchGetBuildBatchesInput{Ids: aws.StringSlice([]string{"deploy-123:batch"})}).
+		Return(&codebuild.BatchGetBuildBatchesOutput{BuildBatches: []*codebuild.BuildBatch{{BuildGroups: []*codebuild.BuildGroup{
+			{Identifier: aws.String("sdinit"), CurrentBuildSummary: &codebuild.BuildSummary{Arn: aws.String("arn:aws:codebuild:us-west-2:123:build/deploy-123:init")}},
+			{Identifier: aws.String("main"), CurrentBuildSummary: &codebuild.BuildSummary{Arn: aws.String("arn:aws:codebuild:us-west-2:123:build/deploy-123:abc")}},
+		}}}}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:init"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{launcherBuild}}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:abc"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{mainBuild}}, nil)
+
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String("/aws/codebuild/deploy-123"),
+		LogStreamName: aws.String("init"),
+		StartFromHead: aws.Bool(true),
+		StartTime:     aws.Int64(1646128800000),
+	}
+	mockLogsAPI.On("GetLogEvents", input).Return(&cloudwatchlogs.GetLogEventsOutput{
+		Events:           []*cloudwatchlogs.OutputLogEvent{{Message: aws.String("copying launcher\n")}, {Message: aws.String("done\n")}},
+		NextForwardToken: aws.String("f/1"),
+	}, nil).Once()
+	mockLogsAPI.On("GetLogEvents", mock.MatchedBy(func(in *cloudwatchlogs.GetLogEventsInput) bool {
+		return aws.StringValue(in.NextToken) == "f/1"
+	})).Return(&cloudwatchlogs.GetLogEventsOutput{NextForwardToken: aws.String("f/1")}, nil)
+
+	config := getTestConfig()
+	config["codebuildBatchId"] = "deploy-123:batch"
+	logs, err := e.Logs(config, since)
+	assert.Nil(t, err)
+	assert.Equal(t, "==> codebuild build deploy-123:init (SUCCEEDED) <==\ncopying launcher\ndone\n"+
+		"==> codebuild build deploy-123:abc (FAILED) <==\ncloudwatch logs are not enabled for build deploy-123:abc, enable executorLogs in the provider\n", string(logs))
+
+	mockCBAPI.On("BatchGetBuildBatches", &codebuild.BatchGetBuildBatchesInput{Ids: aws.StringSlice([]string{"deploy-123:pending"})}).
+		Return(&codebuild.BatchGetBuildBatchesOutput{BuildBatches: []*codebuild.BuildBatch{{BuildGroups: []*codebuild.BuildGroup{
+			{Identifier: aws.String("sdinit")},
+		}}}}, nil)
+	config["codebuildBatchId"] = "deploy-123:pending"
+	_, err = e.Logs(config, since)
+	assert.EqualError(t, err, "no codebuild build started yet")
+}
@@ -0,0 +1,74 @@
+package sls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+)
+
+const (
+	// startOverridesEnv starts the single builds of existing projects with the environment of the build as overrides,
+	// so projects are only updated when more than their environment changed
+	startOverridesEnv = "SD_SLS_START_OVERRIDES"
+	// projectHashTag records the hash of the project config without the environment overridden by starts
+	projectHashTag    = "sd-project-hash"
+	projectHashLength = 16
+)
+
+// checks if builds are started with environment overrides
+func startOverridesEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(startOverridesEnv))
+	return enabled
+}
+
+// gets the hash of a project config, leaving out its tags, the pinned launcher version
+// and the image, compute and environment type of the build
+func projectHash(request *codebuild.CreateProjectInput) string {
+	stable := *request
+	stable.SourceVersion = nil
+	stable.Tags = nil
+	if request.Environment != nil {
+		environment := *request.Environment
+		environment.ComputeType = nil
+		environment.Image = nil
+		environment.ImagePullCredentialsType = nil
+		environment.PrivilegedMode = nil
+		environment.Type = nil
+		stable.Environment = &environment
+	}
+	data, _ := json.Marshal(stable)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:projectHashLength]
+}
+
+// tags the project request with its hash, returns true if the existing project has the same one and needs no update
+func tagProjectHash(request *codebuild.CreateProjectInput, existing *codebuild.Project) bool {
+	hash := projectHash(request)
+	request.Tags = append(request.Tags, &codebuild.Tag{Key: aws.String(projectHashTag), Value: aws.String(hash)})
+	if existing == nil {
+		return false
+	}
+	for _, tag := range existing.Tags {
+		if aws.StringValue(tag.Key) == projectHashTag {
+			return aws.StringValue(tag.Value) == hash
+		}
+	}
+	return false
+}
+
+// overrides the environment of the project with the one of the build
+func setEnvironmentOverrides(input *codebuild.StartBuildInput, environment *codebuild.ProjectEnvironment) {
+	if environment == nil {
+		return
+	}
+	input.ComputeTypeOverride = environment.ComputeType
+	input.EnvironmentTypeOverride = environment.Type
+	input.ImageOverride = environment.Image
+	input.ImagePullCredentialsTypeOverride = environment.ImagePullCredentialsType
+	input.PrivilegedModeOverride = environment.PrivilegedMode
+}
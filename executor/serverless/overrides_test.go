@@ -0,0 +1,119 @@
+package sls
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProjectHash(t *testing.T) {
+	request, _ := getRequestObject("deploy-123", testLauncherVersion, false, getTestConfig())
+	hash := projectHash(request)
+	assert.Len(t, hash, projectHashLength)
+
+	// the container and sizes of builds don't change the hash
+	config := getTestConfig()
+	config["container"] = "golang:1.19"
+	provider := config["provider"].(map[string]interface{})
+	provider["computeType"] = "BUILD_GENERAL1_LARGE"
+	provider["privilegedMode"] = true
+	other, _ := getRequestObject("deploy-123", testLauncherVersion, false, config)
+	other.SourceVersion = aws.String("v2")
+	assert.Equal(t, hash, projectHash(other))
+	assert.Equal(t, "golang:1.19", aws.StringValue(other.Environment.Image))
+
+	provider["role"] = "role:456"
+	changed, _ := getRequestObject("deploy-123", testLauncherVersion, false, config)
+	assert.NotEqual(t, hash, projectHash(changed))
+}
+
+func TestTagProjectHash(t *testing.T) {
+	request, _ := getRequestObject("deploy-123", testLauncherVersion, false, getTestConfig())
+	hash := projectHash(request)
+
+	assert.False(t, tagProjectHash(request, nil))
+	assert.Equal(t, &codebuild.Tag{Key: aws.String(projectHashTag), Value: aws.String(hash)}, request.Tags[len(request.Tags)-1])
+
+	tagged := func(value string) *codebuild.Project {
+		return &codebuild.Project{Tags: []*codebuild.Tag{{Key: aws.String(projectHashTag), Value: aws.String(value)}}}
+	}
+	request, _ = getRequestObject("deploy-123", testLauncherVersion, false, getTestConfig())
+	assert.True(t, tagProjectHash(request, tagged(hash)))
+	request, _ = getRequestObject("deploy-123", testLauncherVersion, false, getTestConfig())
+	assert.False(t, tagProjectHash(request, tagged("0123456789abcdef")))
+	request, _ = getRequestObject("deploy-123", testLauncherVersion, false, getTestConfig())
+	assert.False(t, tagProjectHash(request, &codebuild.Project{}))
+}
+
+func TestSetEnvironmentOverrides(t *testing.T) {
+	input := &codebuild.StartBuildInput{}
+	setEnvironmentOverrides(input, nil)
+	assert.Equal(t, &codebuild.StartBuildInput{}, input)
+
+	setEnvironmentOverrides(input, &codebuild.ProjectEnvironment{
+		ComputeType:              aws.String("BUILD_GENERAL1_MEDIUM"),
+		Image:                    aws.String("node:18"),
+		ImagePullCredentialsType: aws.String("SERVICE_ROLE"),
+		PrivilegedMode:           aws.Bool(true),
+		Type:                     aws.String("ARM_CONTAINER"),
+	})
+	assert.Equal(t, &codebuild.StartBuildInput{
+		ComputeTypeOverride:              aws.String("BUILD_GENERAL1_MEDIUM"),
+		EnvironmentTypeOverride:          aws.String("ARM_CONTAINER"),
+		ImageOverride:                    aws.String("node:18"),
+		ImagePullCredentialsTypeOverride: aws.String("SERVICE_ROLE"),
+		PrivilegedModeOverride:           aws.Bool(true),
+	}, input)
+}
+
+func TestStartWithEnvironmentOverrides(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_BUCKET", testBucket)
+	t.Setenv("SD_SLS_START_OVERRIDES", "true")
+	projectName := testJobName + "-" + testJobID
+	projectArn := "arn:aws:codebuild:project/" + projectName
+	sourceID := sdInitPrefix + testLauncherVersion
+
+	for _, upToDate := range []bool{true, false} {
+		config := getTestConfig()
+		config["container"] = "golang:1.19"
+		request, _ := getRequestObject(projectName, testLauncherVersion, false, getTestConfig())
+		hash := projectHash(request)
+		if !upToDate {
+			hash = "0123456789abcdef"
+		}
+
+		mockServiceClient, mockCBAPI, mockS3API := setup()
+		mockS3API.On("ListObjectsV2", mock.Anything).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(sourceID)}}}, nil)
+		mockS3API.On("HeadObject", mock.Anything).Return(&s3.HeadObjectOutput{VersionId: aws.String("null")}, nil)
+		mockCBAPI.On("BatchGetProjects", mock.Anything).Return(&codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{{
+			Name: aws.String(projectName),
+			Arn:  aws.String(projectArn),
+			Tags: []*codebuild.Tag{{Key: aws.String(projectHashTag), Value: aws.String(hash)}},
+		}}}, nil)
+		mockCBAPI.On("ListBuildsForProject", mock.Anything).Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+		mockCBAPI.On("UpdateProject", mock.Anything).Return(&codebuild.UpdateProjectOutput{Project: &codebuild.Project{Arn: aws.String(projectArn)}}, nil)
+		mockCBAPI.On("StartBuild", mock.Anything).Return(&codebuild.StartBuildOutput{Build: &codebuild.Build{Id: aws.String(projectName + ":1")}}, nil)
+
+		executor := &AwsServerless{serviceClient: mockServiceClient}
+		got, err := executor.Start(config)
+		assert.Nil(t, err)
+		assert.Equal(t, projectArn, got)
+
+		if upToDate {
+			mockCBAPI.AssertNotCalled(t, "UpdateProject", mock.Anything)
+		} else {
+			update := mockCBAPI.Calls[len(mockCBAPI.Calls)-2].Arguments.Get(0).(*codebuild.UpdateProjectInput)
+			assert.Equal(t, "UpdateProject", mockCBAPI.Calls[len(mockCBAPI.Calls)-2].Method)
+			assert.Contains(t, update.Tags, &codebuild.Tag{Key: aws.String(projectHashTag), Value: aws.String(projectHash(request))})
+		}
+		start := mockCBAPI.Calls[len(mockCBAPI.Calls)-1].Arguments.Get(0).(*codebuild.StartBuildInput)
+		assert.Equal(t, "golang:1.19", aws.StringValue(start.ImageOverride), upToDate)
+		assert.Equal(t, "BUILD_GENERAL1_SMALL", aws.StringValue(start.ComputeTypeOverride), upToDate)
+		assert.Equal(t, "LINUX_CONTAINER", aws.StringValue(start.EnvironmentTypeOverride), upToDate)
+		assert.Equal(t, projectName+":1", config["codebuildBuildId"])
+	}
+}
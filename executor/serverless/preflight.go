@@ -0,0 +1,109 @@
+package sls
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/subnet"
+)
+
+// maxConcurrentBuildsEnv is the concurrent running builds quota of the account, unset skips the check
+const maxConcurrentBuildsEnv = "SD_SLS_MAX_CONCURRENT_BUILDS"
+
+// gets the concurrent builds limit
+func maxConcurrentBuilds() int {
+	limit, _ := strconv.Atoi(strings.TrimSpace(os.Getenv(maxConcurrentBuildsEnv)))
+	return limit
+}
+
+// gets the subnet ids of the build vpc config
+func getSubnetIDs(config map[string]interface{}) []string {
+	provider, _ := config["provider"].(map[string]interface{})
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	return subnet.IDs(vpc)
+}
+
+// checks the number of in progress builds against the limit
+func checkConcurrentBuilds(serviceClient *awsAPI, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	input := &codebuild.ListBuildsInput{SortOrder: aws.String(codebuild.SortOrderTypeDescending)}
+	running := 0
+	for {
+		listResult, err := serviceClient.cb.ListBuilds(input)
+		if err != nil {
+			return fmt.Errorf("Error-ListBuilds: %v", err)
+		}
+		if len(listResult.Ids) == 0 {
+			return nil
+		}
+		buildsResult, err := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{Ids: listResult.Ids})
+		if err != nil {
+			return fmt.Errorf("Error-BatchGetBuilds: %v", err)
+		}
+		inProgress := 0
+		for _, build := range buildsResult.Builds {
+			if aws.StringValue(build.BuildStatus) == codebuild.StatusTypeInProgress {
+				inProgress++
+			}
+		}
+		running += inProgress
+		if running >= limit {
+			return executorState.CapacityErrorf("concurrent build limit reached: %d of %d builds running", running, limit)
+		}
+		// builds are listed newest first, a page without running builds means the older ones have finished
+		if inProgress == 0 || listResult.NextToken == nil {
+			return nil
+		}
+		input.NextToken = listResult.NextToken
+	}
+}
+
+// checks that at least one subnet has a free ip address for the build network interface
+func checkSubnetCapacity(serviceClient *awsAPI, subnetIDs []string) error {
+	if len(subnetIDs) == 0 {
+		return nil
+	}
+	result, err := serviceClient.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnetIDs)})
+	if err != nil {
+		return fmt.Errorf("Error-DescribeSubnets: %v", err)
+	}
+	for _, subnet := range result.Subnets {
+		if aws.Int64Value(subnet.AvailableIpAddressCount) > 0 {
+			return nil
+		}
+	}
+	return executorState.CapacityErrorf("no free IP addresses in subnets %s", strings.Join(subnetIDs, ", "))
+}
+
+// checks that the subnets and security groups of the build are usable by codebuild, logging ipv6 warnings of dual stack subnets
+func checkVPC(serviceClient *awsAPI, config map[string]interface{}) error {
+	provider, _ := config["provider"].(map[string]interface{})
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	vpcID, _ := vpc["vpcId"].(string)
+	warnings, err := subnet.CheckVPC(serviceClient.ec2, vpcID, subnet.IDs(vpc), subnet.SecurityGroupIDs(vpc))
+	for _, warning := range warnings {
+		log.Printf("WARNING: %v", warning)
+	}
+	return err
+}
+
+// Preflight checks the concurrent builds quota, the vpc config and subnet capacity before a build is started
+func (e *AwsServerless) Preflight(config map[string]interface{}) error {
+	if err := checkConcurrentBuilds(e.serviceClient, maxConcurrentBuilds()); err != nil {
+		return err
+	}
+	if err := checkVPC(e.serviceClient, config); err != nil {
+		return err
+	}
+	return checkSubnetCapacity(e.serviceClient, getSubnetIDs(config))
+}
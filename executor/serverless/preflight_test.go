@@ -0,0 +1,179 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+type mockEC2Client struct {
+	ec2iface.EC2API
+	mock.Mock
+}
+
+func (m *mockEC2Client) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ec2.DescribeSubnetsOutput), args.Error(1)
+}
+
+func (m *mockEC2Client) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ec2.DescribeSecurityGroupsOutput), args.Error(1)
+}
+
+func builds(statuses ...string) *codebuild.BatchGetBuildsOutput {
+	output := &codebuild.BatchGetBuildsOutput{}
+	for _, status := range statuses {
+		output.Builds = append(output.Builds, &codebuild.Build{BuildStatus: aws.String(status)})
+	}
+	return output
+}
+
+func TestGetSubnetIDs(t *testing.T) {
+	assert.Equal(t, []string{"subnet-1111", "subnet-2222", "subnet-3333"}, getSubnetIDs(getTestConfig()))
+	assert.Nil(t, getSubnetIDs(map[string]interface{}{"provider": map[string]interface{}{}}))
+}
+
+func TestCheckConcurrentBuilds(t *testing.T) {
+	firstPage := &codebuild.ListBuildsInput{SortOrder: aws.String("DESCENDING")}
+	secondPage := &codebuild.ListBuildsInput{SortOrder: aws.String("DESCENDING"), NextToken: aws.String("next")}
+	firstIds := &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"a", "b", "c"})}
+	secondIds := &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"d", "e"})}
+
+	testCases := []struct {
+		message  string
+		limit    int
+		setup    func(cb *mockCodeBuildClient)
+		expected error
+	}{
+		{message: "disabled", limit: 0, setup: func(cb *mockCodeBuildClient) {}},
+		{
+			message: "below limit",
+			limit:   5,
+			setup: func(cb *mockCodeBuildClient) {
+				cb.On("ListBuilds", firstPage).Return(&codebuild.ListBuildsOutput{Ids: firstIds.Ids, NextToken: aws.String("next")}, nil)
+				cb.On("BatchGetBuilds", firstIds).Return(builds("IN_PROGRESS", "IN_PROGRESS", "SUCCEEDED"), nil)
+				cb.On("ListBuilds", secondPage).Return(&codebuild.ListBuildsOutput{Ids: secondIds.Ids}, nil)
+				cb.On("BatchGetBuilds", secondIds).Return(builds("IN_PROGRESS", "FAILED"), nil)
+			},
+		},
+		{
+			message: "limit reached across pages",
+			limit:   3,
+			setup: func(cb *mockCodeBuildClient) {
+				cb.On("ListBuilds", firstPage).Return(&codebuild.ListBuildsOutput{Ids: firstIds.Ids, NextToken: aws.String("next")}, nil)
+				cb.On("BatchGetBuilds", firstIds).Return(builds("IN_PROGRESS", "IN_PROGRESS", "SUCCEEDED"), nil)
+				cb.On("ListBuilds", secondPage).Return(&codebuild.ListBuildsOutput{Ids: secondIds.Ids}, nil)
+				cb.On("BatchGetBuilds", secondIds).Return(builds("IN_PROGRESS", "FAILED"), nil)
+			},
+			expected: executorState.CapacityErrorf("concurrent build limit reached: 3 of 3 builds running"),
+		},
+		{
+			message: "stops at page without running builds",
+			limit:   3,
+			setup: func(cb *mockCodeBuildClient) {
+				cb.On("ListBuilds", firstPage).Return(&codebuild.ListBuildsOutput{Ids: firstIds.Ids, NextToken: aws.String("next")}, nil)
+				cb.On("BatchGetBuilds", firstIds).Return(builds("SUCCEEDED", "SUCCEEDED", "FAILED"), nil)
+			},
+		},
+		{
+			message: "list error",
+			limit:   3,
+			setup: func(cb *mockCodeBuildClient) {
+				cb.On("ListBuilds", firstPage).Return(&codebuild.ListBuildsOutput{}, errors.New("AccessDenied"))
+			},
+			expected: errors.New("Error-ListBuilds: AccessDenied"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			mockServiceClient, mockCBAPI, _ := setup()
+			tc.setup(mockCBAPI)
+			err := checkConcurrentBuilds(mockServiceClient, tc.limit)
+			assert.Equal(t, tc.expected, err)
+			mockCBAPI.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheckSubnetCapacity(t *testing.T) {
+	subnetIDs := []string{"subnet-1111", "subnet-2222"}
+	input := &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnetIDs)}
+
+	testCases := []struct {
+		message  string
+		output   *ec2.DescribeSubnetsOutput
+		err      error
+		expected error
+	}{
+		{
+			message: "free ips",
+			output: &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-1111"), AvailableIpAddressCount: aws.Int64(0)},
+				{SubnetId: aws.String("subnet-2222"), AvailableIpAddressCount: aws.Int64(12)},
+			}},
+		},
+		{
+			message: "exhausted",
+			output: &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-1111"), AvailableIpAddressCount: aws.Int64(0)},
+				{SubnetId: aws.String("subnet-2222"), AvailableIpAddressCount: aws.Int64(0)},
+			}},
+			expected: executorState.CapacityErrorf("no free IP addresses in subnets subnet-1111, subnet-2222"),
+		},
+		{
+			message:  "describe error",
+			output:   &ec2.DescribeSubnetsOutput{},
+			err:      errors.New("InvalidSubnetID.NotFound"),
+			expected: errors.New("Error-DescribeSubnets: InvalidSubnetID.NotFound"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.message, func(t *testing.T) {
+			mockEC2API := new(mockEC2Client)
+			mockEC2API.On("DescribeSubnets", input).Return(tc.output, tc.err)
+			err := checkSubnetCapacity(&awsAPI{ec2: mockEC2API}, subnetIDs)
+			assert.Equal(t, tc.expected, err)
+		})
+	}
+
+	assert.Nil(t, checkSubnetCapacity(&awsAPI{}, nil))
+}
+
+func TestPreflight(t *testing.T) {
+	t.Setenv("SD_SLS_MAX_CONCURRENT_BUILDS", "")
+	mockEC2API := new(mockEC2Client)
+	mockEC2API.On("DescribeSubnets", mock.Anything).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-1111"), VpcId: aws.String("vpc-12345"), AvailableIpAddressCount: aws.Int64(0)},
+	}}, nil)
+	mockEC2API.On("DescribeSecurityGroups", &ec2.DescribeSecurityGroupsInput{GroupIds: aws.StringSlice([]string{"sg-123"})}).Return(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+		{GroupId: aws.String("sg-123"), VpcId: aws.String("vpc-12345")},
+	}}, nil)
+	executor := &AwsServerless{serviceClient: &awsAPI{ec2: mockEC2API}}
+
+	err := executor.Preflight(getTestConfig())
+	assert.EqualError(t, err, "no free IP addresses in subnets subnet-1111, subnet-2222, subnet-3333")
+}
+
+func TestPreflightIPv6OnlySubnet(t *testing.T) {
+	t.Setenv("SD_SLS_MAX_CONCURRENT_BUILDS", "")
+	mockEC2API := new(mockEC2Client)
+	mockEC2API.On("DescribeSubnets", mock.Anything).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-1111"), VpcId: aws.String("vpc-12345"), Ipv6Native: aws.Bool(true)},
+	}}, nil)
+	mockEC2API.On("DescribeSecurityGroups", mock.Anything).Return(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+		{GroupId: aws.String("sg-123"), VpcId: aws.String("vpc-12345")},
+	}}, nil)
+	executor := &AwsServerless{serviceClient: &awsAPI{ec2: mockEC2API}}
+
+	err := executor.Preflight(getTestConfig())
+	assert.EqualError(t, err, "invalid vpc config: subnet subnet-1111 is IPv6 only, codebuild needs subnets with IPv4 addresses")
+}
@@ -0,0 +1,234 @@
+package sls
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	// noncurrentVersionDays is the age in days after which replaced launcher bundle versions expire
+	noncurrentVersionDays = 30
+	// abortMultipartDays is the age in days after which incomplete uploads are aborted
+	abortMultipartDays = 7
+	// tlsStatementSid is the sid of the bucket policy statement denying requests without tls
+	tlsStatementSid = "DenyInsecureTransport"
+
+	// error codes of buckets without lifecycle configuration or policy, not in the sdk
+	errCodeNoSuchLifecycleConfiguration = "NoSuchLifecycleConfiguration"
+	errCodeNoSuchBucketPolicy           = "NoSuchBucketPolicy"
+)
+
+// gets the lifecycle rules of a build bucket
+func bucketLifecycleRules() []*s3.LifecycleRule {
+	return []*s3.LifecycleRule{
+		{
+			ID:                          aws.String("sd-expire-noncurrent-versions"),
+			Status:                      aws.String(s3.ExpirationStatusEnabled),
+			Filter:                      &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+			NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(noncurrentVersionDays)},
+		},
+		{
+			ID:                             aws.String("sd-abort-incomplete-uploads"),
+			Status:                         aws.String(s3.ExpirationStatusEnabled),
+			Filter:                         &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+			AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int64(abortMultipartDays)},
+		},
+		{
+			// deleted launcher bundles leave a delete marker once their last version expired
+			ID:         aws.String("sd-expire-delete-markers"),
+			Status:     aws.String(s3.ExpirationStatusEnabled),
+			Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+			Expiration: &s3.LifecycleExpiration{ExpiredObjectDeleteMarker: aws.Bool(true)},
+		},
+	}
+}
+
+// gets the policy statement of a build bucket denying requests without tls
+func tlsStatement(bucket string, region string) map[string]interface{} {
+	bucketARN := fmt.Sprintf("arn:%s:s3:::%s", awsconfig.Partition(region), bucket)
+	return map[string]interface{}{
+		"Sid":       tlsStatementSid,
+		"Effect":    "Deny",
+		"Principal": "*",
+		"Action":    "s3:*",
+		"Resource":  []string{bucketARN, bucketARN + "/*"},
+		"Condition": map[string]interface{}{"Bool": map[string]string{"aws:SecureTransport": "false"}},
+	}
+}
+
+// gets the policy of a build bucket denying requests without tls
+func bucketPolicy(bucket string, region string) string {
+	policy, _ := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": []map[string]interface{}{tlsStatement(bucket, region)},
+	})
+	return string(policy)
+}
+
+// merges the tls only statement into the existing policy of a bucket, replacing an earlier version of the statement
+func mergeBucketPolicy(existing string, bucket string, region string) (string, error) {
+	var policy map[string]interface{}
+	if err := json.Unmarshal([]byte(existing), &policy); err != nil {
+		return "", fmt.Errorf("Got error decoding policy of bucket %s: %v", bucket, err)
+	}
+	var statements []interface{}
+	switch statement := policy["Statement"].(type) {
+	case []interface{}:
+		statements = statement
+	case map[string]interface{}:
+		statements = []interface{}{statement}
+	}
+	merged := []interface{}{}
+	for _, statement := range statements {
+		if s, ok := statement.(map[string]interface{}); ok && s["Sid"] == tlsStatementSid {
+			continue
+		}
+		merged = append(merged, statement)
+	}
+	policy["Statement"] = append(merged, tlsStatement(bucket, region))
+	mergedPolicy, err := json.Marshal(policy)
+	if err != nil {
+		return "", fmt.Errorf("Got error encoding policy of bucket %s: %v", bucket, err)
+	}
+	return string(mergedPolicy), nil
+}
+
+// returns true if the error is an aws error with the code
+func isErrorCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
+
+// gets the lifecycle rules of the bucket with the build bucket rules, keeping the rules of the bucket
+// which are not build bucket rules
+func mergedLifecycleRules(serviceClient *awsAPI, bucket string) ([]*s3.LifecycleRule, error) {
+	rules := bucketLifecycleRules()
+	existing, err := serviceClient.s3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if isErrorCode(err, errCodeNoSuchLifecycleConfiguration) {
+		return rules, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error-GetBucketLifecycleConfiguration: %v", err)
+	}
+	own := map[string]bool{}
+	for _, rule := range rules {
+		own[aws.StringValue(rule.ID)] = true
+	}
+	var merged []*s3.LifecycleRule
+	for _, rule := range existing.Rules {
+		if !own[aws.StringValue(rule.ID)] {
+			merged = append(merged, rule)
+		}
+	}
+	return append(merged, rules...), nil
+}
+
+// gets the policy of the bucket with the tls only statement, keeping the other statements of the bucket
+func mergedBucketPolicy(serviceClient *awsAPI, bucket string, region string) (string, error) {
+	existing, err := serviceClient.s3.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+	if isErrorCode(err, errCodeNoSuchBucketPolicy) {
+		return bucketPolicy(bucket, region), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error-GetBucketPolicy: %v", err)
+	}
+	return mergeBucketPolicy(aws.StringValue(existing.Policy), bucket, region)
+}
+
+// gets the default encryption of a build bucket, SSE-KMS with the build encryption key if one is configured
+func bucketEncryption() *s3.ServerSideEncryptionConfiguration {
+	rule := &s3.ServerSideEncryptionRule{ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+		SSEAlgorithm: aws.String(s3.ServerSideEncryptionAes256),
+	}}
+	if key := os.Getenv(encryptionKeyEnv); key != "" {
+		rule.ApplyServerSideEncryptionByDefault = &s3.ServerSideEncryptionByDefault{
+			SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+			KMSMasterKeyID: aws.String(key),
+		}
+		rule.BucketKeyEnabled = aws.Bool(true)
+	}
+	return &s3.ServerSideEncryptionConfiguration{Rules: []*s3.ServerSideEncryptionRule{rule}}
+}
+
+// creates the bucket in the region if it does not exist and applies the build bucket settings
+func provisionBucket(serviceClient *awsAPI, bucket string, region string) error {
+	createInput := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	// us-east-1 buckets are created without a location constraint
+	if region != "us-east-1" {
+		createInput.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(region)}
+	}
+	_, err := serviceClient.s3.CreateBucket(createInput)
+	if isErrorCode(err, s3.ErrCodeBucketAlreadyOwnedByYou) {
+		log.Printf("Bucket %s already exists, updating its settings", bucket)
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error-CreateBucket: %v", err)
+	}
+
+	if _, err := serviceClient.s3.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	}); err != nil {
+		return fmt.Errorf("Error-PutPublicAccessBlock: %v", err)
+	}
+	if _, err := serviceClient.s3.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+		Bucket:                            aws.String(bucket),
+		ServerSideEncryptionConfiguration: bucketEncryption(),
+	}); err != nil {
+		return fmt.Errorf("Error-PutBucketEncryption: %v", err)
+	}
+	if _, err := serviceClient.s3.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String(s3.BucketVersioningStatusEnabled)},
+	}); err != nil {
+		return fmt.Errorf("Error-PutBucketVersioning: %v", err)
+	}
+	// lifecycle rules and policy statements of existing buckets are kept
+	rules, err := mergedLifecycleRules(serviceClient, bucket)
+	if err != nil {
+		return err
+	}
+	if _, err := serviceClient.s3.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	}); err != nil {
+		return fmt.Errorf("Error-PutBucketLifecycleConfiguration: %v", err)
+	}
+	policy, err := mergedBucketPolicy(serviceClient, bucket, region)
+	if err != nil {
+		return err
+	}
+	if _, err := serviceClient.s3.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucket),
+		Policy: aws.String(policy),
+	}); err != nil {
+		return fmt.Errorf("Error-PutBucketPolicy: %v", err)
+	}
+	log.Printf("Provisioned build bucket %s in %s", bucket, region)
+	return nil
+}
+
+// ProvisionBucket creates the build bucket in the region with versioning, default encryption,
+// blocked public access, lifecycle rules and a tls only policy. Existing buckets get their settings updated,
+// keeping their other lifecycle rules and policy statements.
+func ProvisionBucket(bucket string, region string) error {
+	sess, err := awsconfig.NewSession(region)
+	if err != nil {
+		return err
+	}
+	return provisionBucket(&awsAPI{s3: s3.New(sess)}, bucket, region)
+}
@@ -0,0 +1,189 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func (m *mockS3Client) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.CreateBucketOutput), args.Error(1)
+}
+func (m *mockS3Client) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutPublicAccessBlockOutput), args.Error(1)
+}
+func (m *mockS3Client) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketEncryptionOutput), args.Error(1)
+}
+func (m *mockS3Client) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketVersioningOutput), args.Error(1)
+}
+func (m *mockS3Client) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketLifecycleConfigurationOutput), args.Error(1)
+}
+func (m *mockS3Client) GetBucketLifecycleConfiguration(input *s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketLifecycleConfigurationOutput), args.Error(1)
+}
+func (m *mockS3Client) GetBucketPolicy(input *s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.GetBucketPolicyOutput), args.Error(1)
+}
+func (m *mockS3Client) PutBucketPolicy(input *s3.PutBucketPolicyInput) (*s3.PutBucketPolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.PutBucketPolicyOutput), args.Error(1)
+}
+
+func setupProvision(createErr error, policyErr error) (*awsAPI, *mockS3Client) {
+	mockServiceClient, _, mockS3API := setup()
+	mockS3API.On("CreateBucket", mock.Anything).Return(&s3.CreateBucketOutput{}, createErr)
+	mockS3API.On("PutPublicAccessBlock", mock.Anything).Return(&s3.PutPublicAccessBlockOutput{}, nil)
+	mockS3API.On("PutBucketEncryption", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, nil)
+	mockS3API.On("PutBucketVersioning", mock.Anything).Return(&s3.PutBucketVersioningOutput{}, nil)
+	mockS3API.On("GetBucketLifecycleConfiguration", mock.Anything).
+		Return(&s3.GetBucketLifecycleConfigurationOutput{}, awserr.New(errCodeNoSuchLifecycleConfiguration, "none", nil)).Maybe()
+	mockS3API.On("GetBucketPolicy", mock.Anything).
+		Return(&s3.GetBucketPolicyOutput{}, awserr.New(errCodeNoSuchBucketPolicy, "none", nil)).Maybe()
+	mockS3API.On("PutBucketLifecycleConfiguration", mock.Anything).Return(&s3.PutBucketLifecycleConfigurationOutput{}, nil)
+	mockS3API.On("PutBucketPolicy", mock.Anything).Return(&s3.PutBucketPolicyOutput{}, policyErr)
+	return mockServiceClient, mockS3API
+}
+
+func TestBucketPolicy(t *testing.T) {
+	assert.JSONEq(t, `{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Sid": "DenyInsecureTransport",
+			"Effect": "Deny",
+			"Principal": "*",
+			"Action": "s3:*",
+			"Resource": ["arn:aws-us-gov:s3:::sd-builds-usgove1", "arn:aws-us-gov:s3:::sd-builds-usgove1/*"],
+			"Condition": {"Bool": {"aws:SecureTransport": "false"}}
+		}]
+	}`, bucketPolicy("sd-builds-usgove1", "us-gov-east-1"))
+}
+
+func TestBucketEncryption(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS", "")
+	assert.Equal(t, "AES256", aws.StringValue(bucketEncryption().Rules[0].ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+
+	t.Setenv("SD_SLS_BUILD_ENCRYPTION_KEY_ALIAS", "alias/testKey")
+	rule := bucketEncryption().Rules[0]
+	assert.Equal(t, "aws:kms", aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+	assert.Equal(t, "alias/testKey", aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID))
+	assert.True(t, aws.BoolValue(rule.BucketKeyEnabled))
+}
+
+func TestProvisionBucket(t *testing.T) {
+	serviceClient, mockS3API := setupProvision(nil, nil)
+	assert.Nil(t, provisionBucket(serviceClient, "sd-builds-use2", "us-east-2"))
+	mockS3API.AssertCalled(t, "CreateBucket", &s3.CreateBucketInput{
+		Bucket:                    aws.String("sd-builds-use2"),
+		CreateBucketConfiguration: &s3.CreateBucketConfiguration{LocationConstraint: aws.String("us-east-2")},
+	})
+	mockS3API.AssertCalled(t, "PutBucketVersioning", &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String("sd-builds-use2"),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String("Enabled")},
+	})
+	mockS3API.AssertNumberOfCalls(t, "PutBucketLifecycleConfiguration", 1)
+
+	// existing buckets are updated, us-east-1 buckets have no location constraint
+	serviceClient, mockS3API = setupProvision(awserr.New(s3.ErrCodeBucketAlreadyOwnedByYou, "owned", nil), nil)
+	assert.Nil(t, provisionBucket(serviceClient, "sd-builds-use1", "us-east-1"))
+	mockS3API.AssertCalled(t, "CreateBucket", &s3.CreateBucketInput{Bucket: aws.String("sd-builds-use1")})
+	mockS3API.AssertNumberOfCalls(t, "PutBucketPolicy", 1)
+
+	serviceClient, mockS3API = setupProvision(awserr.New(s3.ErrCodeBucketAlreadyExists, "taken", nil), nil)
+	assert.EqualError(t, provisionBucket(serviceClient, "sd-builds-use1", "us-east-1"), "Error-CreateBucket: BucketAlreadyExists: taken")
+	mockS3API.AssertNotCalled(t, "PutPublicAccessBlock", mock.Anything)
+
+	serviceClient, _ = setupProvision(nil, errors.New("MalformedPolicy"))
+	assert.EqualError(t, provisionBucket(serviceClient, "sd-builds-use1", "us-east-1"), "Error-PutBucketPolicy: MalformedPolicy")
+}
+
+func TestMergeBucketPolicy(t *testing.T) {
+	existing := `{
+		"Version": "2012-10-17",
+		"Id": "team-policy",
+		"Statement": [
+			{"Sid": "AllowAudit", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::222222222:root"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::sd-builds-use1/*"},
+			{"Sid": "DenyInsecureTransport", "Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::old"}
+		]
+	}`
+	merged, err := mergeBucketPolicy(existing, "sd-builds-use1", "us-east-1")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"Version": "2012-10-17",
+		"Id": "team-policy",
+		"Statement": [
+			{"Sid": "AllowAudit", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::222222222:root"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::sd-builds-use1/*"},
+			{
+				"Sid": "DenyInsecureTransport",
+				"Effect": "Deny",
+				"Principal": "*",
+				"Action": "s3:*",
+				"Resource": ["arn:aws:s3:::sd-builds-use1", "arn:aws:s3:::sd-builds-use1/*"],
+				"Condition": {"Bool": {"aws:SecureTransport": "false"}}
+			}
+		]
+	}`, merged)
+
+	// a single statement object
+	merged, err = mergeBucketPolicy(`{"Version": "2012-10-17", "Statement": {"Sid": "AllowAudit", "Effect": "Allow"}}`, "sd-builds-use1", "us-east-1")
+	assert.Nil(t, err)
+	assert.Contains(t, merged, `"Sid":"AllowAudit"`)
+	assert.Contains(t, merged, `"Sid":"DenyInsecureTransport"`)
+
+	_, err = mergeBucketPolicy("not json", "sd-builds-use1", "us-east-1")
+	assert.EqualError(t, err, "Got error decoding policy of bucket sd-builds-use1: invalid character 'o' in literal null (expecting 'u')")
+}
+
+func TestProvisionExistingBucket(t *testing.T) {
+	mockServiceClient, _, mockS3API := setup()
+	mockS3API.On("CreateBucket", mock.Anything).Return(&s3.CreateBucketOutput{}, awserr.New(s3.ErrCodeBucketAlreadyOwnedByYou, "owned", nil))
+	mockS3API.On("PutPublicAccessBlock", mock.Anything).Return(&s3.PutPublicAccessBlockOutput{}, nil)
+	mockS3API.On("PutBucketEncryption", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, nil)
+	mockS3API.On("PutBucketVersioning", mock.Anything).Return(&s3.PutBucketVersioningOutput{}, nil)
+	teamRule := &s3.LifecycleRule{ID: aws.String("team-expire-logs"), Status: aws.String("Enabled"),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")}, Expiration: &s3.LifecycleExpiration{Days: aws.Int64(14)}}
+	oldRule := &s3.LifecycleRule{ID: aws.String("sd-expire-noncurrent-versions"), Status: aws.String("Disabled")}
+	mockS3API.On("GetBucketLifecycleConfiguration", &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String("sd-builds-use1")}).
+		Return(&s3.GetBucketLifecycleConfigurationOutput{Rules: []*s3.LifecycleRule{teamRule, oldRule}}, nil)
+	mockS3API.On("GetBucketPolicy", &s3.GetBucketPolicyInput{Bucket: aws.String("sd-builds-use1")}).
+		Return(&s3.GetBucketPolicyOutput{Policy: aws.String(`{"Version": "2012-10-17", "Statement": [{"Sid": "AllowAudit", "Effect": "Allow"}]}`)}, nil)
+	mockS3API.On("PutBucketLifecycleConfiguration", mock.Anything).Return(&s3.PutBucketLifecycleConfigurationOutput{}, nil)
+	mockS3API.On("PutBucketPolicy", mock.Anything).Return(&s3.PutBucketPolicyOutput{}, nil)
+
+	assert.Nil(t, provisionBucket(mockServiceClient, "sd-builds-use1", "us-east-1"))
+	// the rules of the team are kept, the build bucket rules replace their earlier versions
+	mockS3API.AssertCalled(t, "PutBucketLifecycleConfiguration", &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String("sd-builds-use1"),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: append([]*s3.LifecycleRule{teamRule}, bucketLifecycleRules()...)},
+	})
+	policy := mockS3API.Calls[len(mockS3API.Calls)-1].Arguments.Get(0).(*s3.PutBucketPolicyInput)
+	assert.Contains(t, aws.StringValue(policy.Policy), `"Sid":"AllowAudit"`)
+	assert.Contains(t, aws.StringValue(policy.Policy), `"Sid":"DenyInsecureTransport"`)
+}
+
+func TestProvisionBucketReadErrors(t *testing.T) {
+	mockServiceClient, _, mockS3API := setup()
+	mockS3API.On("CreateBucket", mock.Anything).Return(&s3.CreateBucketOutput{}, nil)
+	mockS3API.On("PutPublicAccessBlock", mock.Anything).Return(&s3.PutPublicAccessBlockOutput{}, nil)
+	mockS3API.On("PutBucketEncryption", mock.Anything).Return(&s3.PutBucketEncryptionOutput{}, nil)
+	mockS3API.On("PutBucketVersioning", mock.Anything).Return(&s3.PutBucketVersioningOutput{}, nil)
+	mockS3API.On("GetBucketLifecycleConfiguration", mock.Anything).Return(&s3.GetBucketLifecycleConfigurationOutput{}, errors.New("AccessDenied"))
+
+	// existing settings which can't be read are not overwritten
+	assert.EqualError(t, provisionBucket(mockServiceClient, "sd-builds-use1", "us-east-1"), "Error-GetBucketLifecycleConfiguration: AccessDenied")
+	mockS3API.AssertNotCalled(t, "PutBucketLifecycleConfiguration", mock.Anything)
+}
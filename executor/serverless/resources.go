@@ -0,0 +1,67 @@
+package sls
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/tags"
+)
+
+// gets the arns of the codebuild projects tagged with the pipeline
+func pipelineProjects(serviceClient *awsAPI, pipelineID string) ([]string, error) {
+	var arns []string
+	err := serviceClient.tagging.GetResourcesPages(&resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: aws.StringSlice([]string{"codebuild:project"}),
+		TagFilters: []*resourcegroupstaggingapi.TagFilter{
+			{Key: aws.String(tags.PipelineID), Values: aws.StringSlice([]string{pipelineID})},
+		},
+	}, func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
+		for _, mapping := range page.ResourceTagMappingList {
+			arns = append(arns, aws.StringValue(mapping.ResourceARN))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-GetResources: %v", err)
+	}
+	return arns, nil
+}
+
+// Resources lists the codebuild projects of the pipeline, found by their pipeline tag, and their in progress builds
+func (e *AwsServerless) Resources(config map[string]interface{}) ([]executorState.Resource, error) {
+	arns, err := pipelineProjects(e.serviceClient, fmt.Sprint(config["pipelineId"]))
+	if err != nil {
+		return nil, err
+	}
+	resources := []executorState.Resource{}
+	for _, projectArn := range arns {
+		parsed, err := arn.Parse(projectArn)
+		if err != nil {
+			log.Printf("Skipping project with invalid arn %v", projectArn)
+			continue
+		}
+		project := strings.TrimPrefix(parsed.Resource, "project/")
+		resources = append(resources, executorState.Resource{Type: "codebuild-project", Name: project, Arn: projectArn})
+		builds, err := inProgressProjectBuilds(e.serviceClient, project)
+		if err != nil {
+			return nil, err
+		}
+		for _, build := range builds {
+			resources = append(resources, executorState.Resource{
+				Type:      "codebuild-build",
+				Name:      aws.StringValue(build.Id),
+				Arn:       aws.StringValue(build.Arn),
+				BuildID:   buildEnv(build, "SDBUILDID"),
+				Status:    aws.StringValue(build.CurrentPhase),
+				StartedAt: build.StartTime,
+			})
+		}
+	}
+	return resources, nil
+}
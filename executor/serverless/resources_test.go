@@ -0,0 +1,79 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/stretchr/testify/assert"
+
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+type mockTaggingClient struct {
+	resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	inputs []*resourcegroupstaggingapi.GetResourcesInput
+	arns   []string
+	err    error
+}
+
+func (m *mockTaggingClient) GetResourcesPages(input *resourcegroupstaggingapi.GetResourcesInput, fn func(*resourcegroupstaggingapi.GetResourcesOutput, bool) bool) error {
+	m.inputs = append(m.inputs, input)
+	if m.err != nil {
+		return m.err
+	}
+	var mappings []*resourcegroupstaggingapi.ResourceTagMapping
+	for _, arn := range m.arns {
+		mappings = append(mappings, &resourcegroupstaggingapi.ResourceTagMapping{ResourceARN: aws.String(arn)})
+	}
+	fn(&resourcegroupstaggingapi.GetResourcesOutput{ResourceTagMappingList: mappings}, true)
+	return nil
+}
+
+func TestResources(t *testing.T) {
+	mockServiceClient, mockCBAPI, _ := setup()
+	tagging := &mockTaggingClient{arns: []string{
+		"arn:aws:codebuild:us-west-2:123456789012:project/deploy-123",
+		"arn:aws:codebuild:us-west-2:123456789012:project/test-124",
+	}}
+	mockServiceClient.tagging = tagging
+	startedAt := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	running := &codebuild.Build{
+		Id:           aws.String("deploy-123:abc"),
+		Arn:          aws.String("arn:aws:codebuild:us-west-2:123456789012:build/deploy-123:abc"),
+		BuildStatus:  aws.String("IN_PROGRESS"),
+		CurrentPhase: aws.String("BUILD"),
+		StartTime:    &startedAt,
+		Environment: &codebuild.ProjectEnvironment{EnvironmentVariables: []*codebuild.EnvironmentVariable{
+			{Name: aws.String("SDBUILDID"), Value: aws.String("1234")},
+		}},
+	}
+	finished := &codebuild.Build{Id: aws.String("deploy-123:abb"), BuildStatus: aws.String("SUCCEEDED")}
+	mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String("deploy-123"), SortOrder: aws.String("DESCENDING")}).
+		Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:abc", "deploy-123:abb"})}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:abc", "deploy-123:abb"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{running, finished}}, nil)
+	mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String("test-124"), SortOrder: aws.String("DESCENDING")}).
+		Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+
+	e := &AwsServerless{serviceClient: mockServiceClient}
+	resources, err := e.Resources(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, []executorState.Resource{
+		{Type: "codebuild-project", Name: "deploy-123", Arn: "arn:aws:codebuild:us-west-2:123456789012:project/deploy-123"},
+		{Type: "codebuild-build", Name: "deploy-123:abc", Arn: "arn:aws:codebuild:us-west-2:123456789012:build/deploy-123:abc", BuildID: "1234", Status: "BUILD", StartedAt: &startedAt},
+		{Type: "codebuild-project", Name: "test-124", Arn: "arn:aws:codebuild:us-west-2:123456789012:project/test-124"},
+	}, resources)
+	assert.Equal(t, "sd-pipeline-id", aws.StringValue(tagging.inputs[0].TagFilters[0].Key))
+	assert.Equal(t, []string{"12345"}, aws.StringValueSlice(tagging.inputs[0].TagFilters[0].Values))
+	assert.Equal(t, []string{"codebuild:project"}, aws.StringValueSlice(tagging.inputs[0].ResourceTypeFilters))
+
+	mockServiceClient.tagging = &mockTaggingClient{err: errors.New("AccessDeniedException")}
+	_, err = e.Resources(getTestConfig())
+	assert.EqualError(t, err, "Error-GetResources: AccessDeniedException")
+	mockCBAPI.AssertExpectations(t)
+}
@@ -0,0 +1,93 @@
+package sls
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+)
+
+// identifier of the screwdriver build in the batch build graph
+const mainBuildIdentifier = "main"
+
+// interval between codebuild status polls
+var pollInterval = 5 * time.Second
+
+// gets a codebuild build by id
+func getBuild(serviceClient *awsAPI, id string) (*codebuild.Build, error) {
+	buildsResult, err := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{Ids: []*string{aws.String(id)}})
+	if err != nil {
+		return nil, fmt.Errorf("Error-BatchGetBuilds: %v", err)
+	}
+	if len(buildsResult.Builds) == 0 {
+		return nil, fmt.Errorf("build %v not found", id)
+	}
+	return buildsResult.Builds[0], nil
+}
+
+// gets the codebuild build running the screwdriver build, nil if the main build of a batch is not started yet
+func getMainBuild(serviceClient *awsAPI, config map[string]interface{}) (*codebuild.Build, error) {
+	if id, _ := config["codebuildBuildId"].(string); id != "" {
+		return getBuild(serviceClient, id)
+	}
+	batchID, _ := config["codebuildBatchId"].(string)
+	if batchID == "" {
+		return nil, errors.New("codebuild build id is unknown")
+	}
+	batchResult, err := serviceClient.cb.BatchGetBuildBatches(&codebuild.BatchGetBuildBatchesInput{Ids: []*string{aws.String(batchID)}})
+	if err != nil {
+		return nil, fmt.Errorf("Error-BatchGetBuildBatches: %v", err)
+	}
+	if len(batchResult.BuildBatches) == 0 {
+		return nil, fmt.Errorf("build batch %v not found", batchID)
+	}
+	for _, group := range batchResult.BuildBatches[0].BuildGroups {
+		if aws.StringValue(group.Identifier) != mainBuildIdentifier || group.CurrentBuildSummary == nil {
+			continue
+		}
+		buildArn, err := arn.Parse(aws.StringValue(group.CurrentBuildSummary.Arn))
+		if err != nil {
+			return nil, fmt.Errorf("invalid build arn %v", aws.StringValue(group.CurrentBuildSummary.Arn))
+		}
+		return getBuild(serviceClient, strings.TrimPrefix(buildArn.Resource, "build/"))
+	}
+	return nil, nil
+}
+
+// gets the start time of a build phase, zero if the phase has not started
+func phaseStartTime(build *codebuild.Build, phaseType string) time.Time {
+	if build == nil {
+		return time.Time{}
+	}
+	for _, phase := range build.Phases {
+		if aws.StringValue(phase.PhaseType) == phaseType {
+			return aws.TimeValue(phase.StartTime)
+		}
+	}
+	return time.Time{}
+}
+
+// WaitRunning waits for the started build to reach the BUILD phase and returns the time it started
+func (e *AwsServerless) WaitRunning(config map[string]interface{}, timeout time.Duration) (time.Time, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		build, err := getMainBuild(e.serviceClient, config)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if started := phaseStartTime(build, codebuild.BuildPhaseTypeBuild); !started.IsZero() {
+			return started, nil
+		}
+		if build != nil && aws.StringValue(build.BuildStatus) != codebuild.StatusTypeInProgress {
+			return time.Time{}, fmt.Errorf("build %v finished with status %v before running", aws.StringValue(build.Id), aws.StringValue(build.BuildStatus))
+		}
+		if time.Now().After(deadline) {
+			return time.Time{}, fmt.Errorf("timed out after %v waiting for build to run", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
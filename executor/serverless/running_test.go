@@ -0,0 +1,97 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+)
+
+var testBuildStart = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func buildWithPhases(id string, status string, phases ...string) *codebuild.Build {
+	build := &codebuild.Build{Id: aws.String(id), BuildStatus: aws.String(status)}
+	for i, phase := range phases {
+		build.Phases = append(build.Phases, &codebuild.BuildPhase{
+			PhaseType: aws.String(phase),
+			StartTime: aws.Time(testBuildStart.Add(time.Duration(i) * time.Second)),
+		})
+	}
+	return build
+}
+
+func TestPhaseStartTime(t *testing.T) {
+	build := buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "QUEUED", "PROVISIONING", "BUILD")
+	assert.Equal(t, testBuildStart.Add(3*time.Second), phaseStartTime(build, "BUILD"))
+	assert.Equal(t, testBuildStart.Add(2*time.Second), phaseStartTime(build, "PROVISIONING"))
+	assert.True(t, phaseStartTime(build, "POST_BUILD").IsZero())
+	assert.True(t, phaseStartTime(nil, "BUILD").IsZero())
+}
+
+func TestGetMainBuild(t *testing.T) {
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:abc"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{buildWithPhases("deploy-123:abc", "IN_PROGRESS")}}, nil)
+	mockCBAPI.On("BatchGetBuildBatches", &codebuild.BatchGetBuildBatchesInput{Ids: aws.StringSlice([]string{"deploy-123:batch"})}).
+		Return(&codebuild.BatchGetBuildBatchesOutput{BuildBatches: []*codebuild.BuildBatch{{BuildGroups: []*codebuild.BuildGroup{
+			{Identifier: aws.String("sdinit"), CurrentBuildSummary: &codebuild.BuildSummary{Arn: aws.String("arn:aws:codebuild:us-west-2:123:build/deploy-123:init")}},
+			{Identifier: aws.String("main"), CurrentBuildSummary: &codebuild.BuildSummary{Arn: aws.String("arn:aws:codebuild:us-west-2:123:build/deploy-123:abc")}},
+		}}}}, nil)
+	mockCBAPI.On("BatchGetBuildBatches", &codebuild.BatchGetBuildBatchesInput{Ids: aws.StringSlice([]string{"deploy-123:pending"})}).
+		Return(&codebuild.BatchGetBuildBatchesOutput{BuildBatches: []*codebuild.BuildBatch{{BuildGroups: []*codebuild.BuildGroup{
+			{Identifier: aws.String("main")},
+		}}}}, nil)
+
+	build, err := getMainBuild(mockServiceClient, map[string]interface{}{"codebuildBuildId": "deploy-123:abc"})
+	assert.Nil(t, err)
+	assert.Equal(t, "deploy-123:abc", *build.Id)
+
+	build, err = getMainBuild(mockServiceClient, map[string]interface{}{"codebuildBatchId": "deploy-123:batch"})
+	assert.Nil(t, err)
+	assert.Equal(t, "deploy-123:abc", *build.Id)
+
+	build, err = getMainBuild(mockServiceClient, map[string]interface{}{"codebuildBatchId": "deploy-123:pending"})
+	assert.Nil(t, err)
+	assert.Nil(t, build)
+
+	_, err = getMainBuild(mockServiceClient, map[string]interface{}{})
+	assert.EqualError(t, err, "codebuild build id is unknown")
+}
+
+func TestWaitRunning(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 5 * time.Second }()
+
+	testCases := []struct {
+		message  string
+		build    *codebuild.Build
+		err      error
+		expected time.Time
+		expErr   string
+	}{
+		{message: "running", build: buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "BUILD"), expected: testBuildStart.Add(time.Second)},
+		{message: "timeout", build: buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED"), expErr: "timed out after 5ms waiting for build to run"},
+		{message: "finished", build: buildWithPhases("p:1", "FAILED", "SUBMITTED"), expErr: "build p:1 finished with status FAILED before running"},
+		{message: "api error", build: nil, err: errors.New("throttled"), expErr: "Error-BatchGetBuilds: throttled"},
+	}
+	for _, tc := range testCases {
+		mockServiceClient, mockCBAPI, _ := setup()
+		output := &codebuild.BatchGetBuildsOutput{}
+		if tc.build != nil {
+			output.Builds = []*codebuild.Build{tc.build}
+		}
+		mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"p:1"})}).Return(output, tc.err)
+		executor := &AwsServerless{serviceClient: mockServiceClient}
+
+		started, err := executor.WaitRunning(map[string]interface{}{"codebuildBuildId": "p:1"}, 5*time.Millisecond)
+		if tc.expErr != "" {
+			assert.EqualError(t, err, tc.expErr, tc.message)
+			continue
+		}
+		assert.Nil(t, err, tc.message)
+		assert.Equal(t, tc.expected, started, tc.message)
+	}
+}
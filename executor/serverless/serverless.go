@@ -2,24 +2,47 @@ package sls
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
 	"github.com/aws/aws-sdk-go/service/codebuild"
 	"github.com/aws/aws-sdk-go/service/codebuild/codebuildiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/subnet"
+	"github.com/screwdriver-cd/aws-consumer-service/tags"
 )
 
 // aws api definition struct
 type awsAPI struct {
-	cb codebuildiface.CodeBuildAPI
-	s3 s3iface.S3API
+	cb      codebuildiface.CodeBuildAPI
+	s3      s3iface.S3API
+	ec2     ec2iface.EC2API
+	logs    cloudwatchlogsiface.CloudWatchLogsAPI
+	kms     kmsiface.KMSAPI
+	tagging resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
 }
 
 // AwsServerless definition struct
@@ -31,37 +54,102 @@ type AwsServerless struct {
 const (
 	executorName = "sls"
 	sdInitPrefix = "sdinit-"
+	// startModeTag records on the project whether its builds are started as single builds or build batches
+	startModeTag   = "sd-start-mode"
+	startModeBuild = "build"
+	startModeBatch = "batch"
+	// stopAttempts is the number of attempts of each step of a stop
+	stopAttempts = 3
+	// stopMaxPages limits the pages of recent builds inspected for in progress builds
+	stopMaxPages = 5
+	// accountBucketField is the provider field of the bucket of the registry account, named for the region of the provider
+	accountBucketField = "accountBucket"
+	// bucket layouts of SD_SLS_BUCKET_LAYOUT, one SD_SLS_BUILD_BUCKET for all tenants or a bucket per account
+	sharedBucketLayout  = "shared"
+	accountBucketLayout = "account"
 )
 
+// interval between the attempts of a stop step
+var stopRetryInterval = 2 * time.Second
+
 // awsRegionMap for region short names
 var awsRegionMap = map[string]string{
 	"north":     "n",
-	"west":      "w",
 	"northeast": "ne",
-	"east":      "e",
+	"northwest": "nw",
 	"south":     "s",
-	"central":   "c",
 	"southeast": "se",
+	"southwest": "sw",
+	"east":      "e",
+	"west":      "w",
+	"central":   "c",
 }
 
-// gets the region short name
-func getRegionShortName(region string) string {
-	items := strings.Split(region, "-")
-	shortRegion := strings.Join([]string{items[0], awsRegionMap[items[1]], items[2]}, "")
-	return shortRegion
+// regionPattern matches <area>[-<partition qualifier>]-<direction>-<number>, e.g. us-west-2, us-gov-west-1, us-isob-east-1
+var regionPattern = regexp.MustCompile(`^([a-z]{2,4}(?:-(?:gov|iso[a-z]?))?)-([a-z]+)-([0-9]+)$`)
+
+// gets the region short name, keeping the partition qualifier of regions like us-gov-west-1
+func getRegionShortName(region string) (string, error) {
+	matches := regionPattern.FindStringSubmatch(region)
+	if matches == nil {
+		return "", fmt.Errorf("invalid region %q", region)
+	}
+	direction, ok := awsRegionMap[matches[2]]
+	if !ok {
+		return "", fmt.Errorf("unknown direction %q in region %q", matches[2], region)
+	}
+	return strings.ReplaceAll(matches[1], "-", "") + direction + matches[3], nil
+}
+
+// BucketName gets the build bucket of the provider, a provider bucket takes precedence over the bucket of the layout,
+// the account bucket with SD_SLS_BUCKET_LAYOUT=account or SD_SLS_BUILD_BUCKET otherwise
+func BucketName(provider map[string]interface{}) (string, error) {
+	if bucket, _ := provider["bucket"].(string); bucket != "" {
+		return bucket, nil
+	}
+	region, _ := provider["region"].(string)
+	buildRegion, _ := provider["buildRegion"].(string)
+	if accountBucket, _ := provider[accountBucketField].(string); accountBucket != "" {
+		return regionalBucketName(accountBucket, region, buildRegion)
+	}
+	if bucketLayout() == accountBucketLayout {
+		// tenants of the account layout never share a bucket
+		return "", fmt.Errorf("no build bucket for account %v, SD_SLS_BUCKET_LAYOUT=account needs the bucket of the account in the provider registry or provider.bucket", provider["accountId"])
+	}
+	return getBucketName(region, buildRegion)
+}
+
+// gets the bucket layout of SD_SLS_BUCKET_LAYOUT, shared by default
+func bucketLayout() string {
+	if os.Getenv("SD_SLS_BUCKET_LAYOUT") == accountBucketLayout {
+		return accountBucketLayout
+	}
+	return sharedBucketLayout
 }
 
 // gets the bucket name in case of cross region deployments
-func getBucketName(region string, buildRegion string) string {
-	bucket := os.Getenv("SD_SLS_BUILD_BUCKET")
+func getBucketName(region string, buildRegion string) (string, error) {
+	return regionalBucketName(os.Getenv("SD_SLS_BUILD_BUCKET"), region, buildRegion)
+}
+
+// gets the bucket of the build region by replacing the region short name in the bucket of the region
+func regionalBucketName(bucket string, region string, buildRegion string) (string, error) {
 	if buildRegion == "" || region == buildRegion {
-		return bucket
+		return bucket, nil
+	}
+	shortRegion, err := getRegionShortName(region)
+	if err != nil {
+		return "", err
 	}
-	bucketName := strings.Replace(bucket, getRegionShortName(region), getRegionShortName(buildRegion), 1)
+	shortBuildRegion, err := getRegionShortName(buildRegion)
+	if err != nil {
+		return "", err
+	}
+	bucketName := strings.Replace(bucket, shortRegion, shortBuildRegion, 1)
 
 	log.Printf("Regional Bucket Name: %v", bucketName)
 
-	return bucketName
+	return bucketName, nil
 }
 
 // checks if launcher update is required
@@ -88,11 +176,15 @@ func checkLauncherUpdate(serviceClient *awsAPI, launcherVersion string, bucket s
 	return launcherUpdate
 }
 
-// deletes a build project using codebuild service api
+// deletes a build project using codebuild service api, a missing project is not an error
 func deleteProject(serviceClient *awsAPI, project string) error {
 	deleteProjectResponse, err := serviceClient.cb.DeleteProject(&codebuild.DeleteProjectInput{
 		Name: aws.String(project),
 	})
+	if isProjectNotFound(err) {
+		log.Printf("Project %q is already deleted", project)
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("Got error deleting project: %v", err)
 	}
@@ -101,79 +193,244 @@ func deleteProject(serviceClient *awsAPI, project string) error {
 	return nil
 }
 
-// stops a build using codebuild service api
-func stopBuild(serviceClient *awsAPI, project string) error {
-	buildsResponse, _ := serviceClient.cb.ListBuildsForProject(&codebuild.ListBuildsForProjectInput{
-		ProjectName: aws.String(project),
-		SortOrder:   aws.String("DESCENDING"),
+// gets how the builds of the project are started from its start mode tag, empty if unknown
+func getStartMode(serviceClient *awsAPI, project string) string {
+	batchResult, err := serviceClient.cb.BatchGetProjects(&codebuild.BatchGetProjectsInput{
+		Names: []*string{aws.String(project)},
 	})
+	if err != nil {
+		log.Printf("Error-BatchGetProjects: %v", err)
+		return ""
+	}
+	for _, p := range batchResult.Projects {
+		for _, tag := range p.Tags {
+			if aws.StringValue(tag.Key) == startModeTag {
+				return aws.StringValue(tag.Value)
+			}
+		}
+	}
+	return ""
+}
 
-	log.Printf("Build ids for project %q: %v", project, buildsResponse.Ids)
-	if len(buildsResponse.Ids) > 0 {
-		buildResp, _ := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{
-			Ids: []*string{
-				buildsResponse.Ids[0],
-			},
-		})
-		if string(*buildResp.Builds[0].BuildStatus) == "IN_PROGRESS" {
-			stopBuildResponse, err := serviceClient.cb.StopBuild(&codebuild.StopBuildInput{
-				Id: buildsResponse.Ids[0],
-			})
-			if err != nil {
-				return fmt.Errorf("Got error stopping build: %v", err)
+// checks if the error reports a missing codebuild project
+func isProjectNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == codebuild.ErrCodeResourceNotFoundException
+}
+
+// gets the in progress builds of the project, newest first. The recent builds are inspected
+// page by page, up to stopMaxPages pages, until a page has a finished build.
+func inProgressProjectBuilds(serviceClient *awsAPI, project string) ([]*codebuild.Build, error) {
+	var builds []*codebuild.Build
+	input := &codebuild.ListBuildsForProjectInput{
+		ProjectName: aws.String(project),
+		SortOrder:   aws.String("DESCENDING"),
+	}
+	for page := 0; page < stopMaxPages; page++ {
+		buildsResponse, err := serviceClient.cb.ListBuildsForProject(input)
+		if err != nil {
+			return nil, fmt.Errorf("Error-ListBuildsForProject: %w", err)
+		}
+		if len(buildsResponse.Ids) == 0 {
+			break
+		}
+		buildsResult, err := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{Ids: buildsResponse.Ids})
+		if err != nil {
+			return nil, fmt.Errorf("Error-BatchGetBuilds: %v", err)
+		}
+		finished := false
+		for _, build := range buildsResult.Builds {
+			if aws.StringValue(build.BuildStatus) == codebuild.StatusTypeInProgress {
+				builds = append(builds, build)
+			} else {
+				finished = true
 			}
-			log.Printf("Stopped build %q for project %v", project, *stopBuildResponse.Build.BuildNumber)
 		}
+		if finished || buildsResponse.NextToken == nil {
+			break
+		}
+		input.NextToken = buildsResponse.NextToken
 	}
-	return nil
+	return builds, nil
 }
 
-// stops builds running in batch using codebuild service api
-func stopBuildBatch(serviceClient *awsAPI, project string) error {
-	buildBatchesResponse, _ := serviceClient.cb.ListBuildBatchesForProject(&codebuild.ListBuildBatchesForProjectInput{
-		MaxResults:  aws.Int64(5),
+// gets the ids of the in progress builds of the project, newest first
+func inProgressBuilds(serviceClient *awsAPI, project string) ([]*string, error) {
+	builds, err := inProgressProjectBuilds(serviceClient, project)
+	if err != nil {
+		return nil, err
+	}
+	var ids []*string
+	for _, build := range builds {
+		ids = append(ids, build.Id)
+	}
+	return ids, nil
+}
+
+// gets the ids of the in progress build batches of the project, newest first, like inProgressBuilds
+func inProgressBuildBatches(serviceClient *awsAPI, project string) ([]*string, error) {
+	var ids []*string
+	input := &codebuild.ListBuildBatchesForProjectInput{
+		MaxResults:  aws.Int64(100),
 		ProjectName: aws.String(project),
 		SortOrder:   aws.String("DESCENDING"),
-	})
-	log.Printf("Build ids for project %q: %v", project, buildBatchesResponse.Ids)
-	if len(buildBatchesResponse.Ids) > 0 {
-		batchBuildResp, _ := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{
-			Ids: []*string{
-				buildBatchesResponse.Ids[0],
-			},
-		})
-		if string(*batchBuildResp.Builds[0].BuildStatus) == "IN_PROGRESS" {
-			stopBuildBatchResponse, err := serviceClient.cb.StopBuildBatch(&codebuild.StopBuildBatchInput{
-				Id: buildBatchesResponse.Ids[0],
-			})
-			if err != nil {
-				return fmt.Errorf("Got error stopping build: %v", err)
+	}
+	for page := 0; page < stopMaxPages; page++ {
+		batchesResponse, err := serviceClient.cb.ListBuildBatchesForProject(input)
+		if err != nil {
+			return nil, fmt.Errorf("Error-ListBuildBatchesForProject: %w", err)
+		}
+		if len(batchesResponse.Ids) == 0 {
+			break
+		}
+		batchesResult, err := serviceClient.cb.BatchGetBuildBatches(&codebuild.BatchGetBuildBatchesInput{Ids: batchesResponse.Ids})
+		if err != nil {
+			return nil, fmt.Errorf("Error-BatchGetBuildBatches: %v", err)
+		}
+		finished := false
+		for _, batch := range batchesResult.BuildBatches {
+			if aws.StringValue(batch.BuildBatchStatus) == codebuild.StatusTypeInProgress {
+				ids = append(ids, batch.Id)
+			} else {
+				finished = true
 			}
-			log.Printf("Stopped build batch %q for project %v", project, *stopBuildBatchResponse.BuildBatch.BuildBatchNumber)
 		}
+		if finished || batchesResponse.NextToken == nil {
+			break
+		}
+		input.NextToken = batchesResponse.NextToken
 	}
-	return nil
+	return ids, nil
+}
+
+// stops the in progress builds of the project using codebuild service api, a missing project has nothing to stop
+func stopBuild(serviceClient *awsAPI, project string) error {
+	ids, err := inProgressBuilds(serviceClient, project)
+	if isProjectNotFound(errors.Unwrap(err)) {
+		log.Printf("Project %q is already deleted, no builds to stop", project)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("In progress build ids for project %q: %v", project, aws.StringValueSlice(ids))
+	var stopErr error
+	for _, id := range ids {
+		stopBuildResponse, err := serviceClient.cb.StopBuild(&codebuild.StopBuildInput{Id: id})
+		// the build was deleted along with its project by a concurrent stop
+		if isProjectNotFound(err) {
+			log.Printf("Build %q of project %q is already deleted", aws.StringValue(id), project)
+			continue
+		}
+		if err != nil {
+			stopErr = fmt.Errorf("Got error stopping build: %v", err)
+			continue
+		}
+		log.Printf("Stopped build %q for project %v", project, aws.Int64Value(stopBuildResponse.Build.BuildNumber))
+	}
+	return stopErr
+}
+
+// stops the in progress build batches of the project using codebuild service api, a missing project has nothing to stop
+func stopBuildBatch(serviceClient *awsAPI, project string) error {
+	ids, err := inProgressBuildBatches(serviceClient, project)
+	if isProjectNotFound(errors.Unwrap(err)) {
+		log.Printf("Project %q is already deleted, no build batchs to stop", project)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("In progress build batch ids for project %q: %v", project, aws.StringValueSlice(ids))
+	var stopErr error
+	for _, id := range ids {
+		stopBuildBatchResponse, err := serviceClient.cb.StopBuildBatch(&codebuild.StopBuildBatchInput{Id: id})
+		// the build batch was deleted along with its project by a concurrent stop
+		if isProjectNotFound(err) {
+			log.Printf("Build batch %q of project %q is already deleted", aws.StringValue(id), project)
+			continue
+		}
+		if err != nil {
+			stopErr = fmt.Errorf("Got error stopping build: %v", err)
+			continue
+		}
+		log.Printf("Stopped build batch %q for project %v", project, aws.Int64Value(stopBuildBatchResponse.BuildBatch.BuildBatchNumber))
+	}
+	return stopErr
 }
 
-// gets the formatted build spec files for codebuild project
-func getBuildSpec(provider map[string]interface{}) (string, string) {
-	mainBuildspec := fmt.Sprintf("version: 0.2\\nphases:\\n  install:\\n    commands:\\n      - mkdir /opt/sd && cp -r $CODEBUILD_SRC_DIR_sdinit_sdinit/opt/sd/* /opt/sd/\\n  build:\\n    commands:\\n      - /opt/sd/launcher_entrypoint.sh /opt/sd/run.sh $TOKEN $API $STORE $TIMEOUT $SDBUILDID $UI")
-	batchBuildSpec := fmt.Sprintf("version: 0.2\nbatch:\n  fast-fail: false\n  build-graph:\n    - identifier: sdinit\n      env:\n        type: %v\n        image: %v\n        compute-type: %v\n        privileged-mode: false\n      ignore-failure: false\n    - identifier: main\n      buildspec: \"%v\"\n      depend-on:\n        - sdinit\nartifacts:\n  base-directory: /opt\n  files:  \n    - '/opt/**/*'",
-		provider["launcherEnvironmentType"].(string), provider["launcherImage"].(string), provider["launcherComputeType"].(string), mainBuildspec)
-	singleBuildSpec := fmt.Sprintf("version: 0.2\nphases:\n  install:\n    commands:\n       - mkdir /opt/sd && cp -r $CODEBUILD_SRC_DIR/opt/sd/* /opt/sd/\n  build:\n    commands:\n       - /opt/sd/launcher_entrypoint.sh /opt/sd/run.sh $TOKEN $API $STORE $TIMEOUT $SDBUILDID $UI\n")
+// isWindows checks if the codebuild environment type runs windows containers
+func isWindows(environmentType string) bool {
+	return strings.HasPrefix(environmentType, "WINDOWS_")
+}
+
+// gets the commands installing and running the launcher from srcDir, windows builds run the powershell entrypoint
+// and lambda builds install the launcher to /tmp
+func getLauncherCommands(environmentType string, srcDir string) (string, string) {
+	if isWindows(environmentType) {
+		return fmt.Sprintf("New-Item -ItemType Directory -Force -Path C:/sd | Out-Null; Copy-Item -Recurse -Force -Path %v/opt/sd/* -Destination C:/sd/", srcDir),
+			"C:/sd/launcher_entrypoint.ps1 C:/sd/run.ps1 $env:TOKEN $env:API $env:STORE $env:TIMEOUT $env:SDBUILDID $env:UI"
+	}
+	if isLambdaEnvironment(environmentType) {
+		return fmt.Sprintf("mkdir -p %[1]v && cp -r %[2]v/opt/sd/* %[1]v/", lambdaLauncherDir, srcDir),
+			fmt.Sprintf("%[1]v/launcher_entrypoint.sh %[1]v/run.sh $TOKEN $API $STORE $TIMEOUT $SDBUILDID $UI", lambdaLauncherDir)
+	}
+	return fmt.Sprintf("mkdir /opt/sd && cp -r %v/opt/sd/* /opt/sd/", srcDir),
+		"/opt/sd/launcher_entrypoint.sh /opt/sd/run.sh $TOKEN $API $STORE $TIMEOUT $SDBUILDID $UI"
+}
+
+// gets the formatted build spec files for codebuild project.
+// The launcher and main phases of the batch build graph each run in their own environment,
+// so the launcher may run on a different architecture or os than the build.
+func getBuildSpec(config map[string]interface{}) (string, string) {
+	provider := config["provider"].(map[string]interface{})
+	environmentType := provider["environmentType"].(string)
+	privilegedMode := provider["privilegedMode"].(bool) || provider["dlc"].(bool)
+
+	cachePaths := getCachePaths(provider)
+	keepAlive := keepAliveMinutes(provider) > 0
+
+	install, build := getLauncherCommands(environmentType, "$CODEBUILD_SRC_DIR_sdinit_sdinit")
+	mainBuildspec := fmt.Sprintf("version: 0.2\\nphases:\\n  install:\\n    commands:\\n      - %v\\n  build:\\n    commands:\\n      - %v", install, build)
+	if keepAlive {
+		mainBuildspec += fmt.Sprintf("\\n  post_build:\\n    commands:\\n      - %v", keepAliveCommand(environmentType))
+	}
+	if len(cachePaths) > 0 {
+		mainBuildspec += "\\n" + cacheSpec(cachePaths, "\\n")
+	}
+	batchBuildSpec := fmt.Sprintf("version: 0.2\nbatch:\n  fast-fail: false\n  build-graph:\n    - identifier: sdinit\n      env:\n        type: %v\n        image: %v\n        compute-type: %v\n        privileged-mode: false\n      ignore-failure: false\n    - identifier: main\n      env:\n        type: %v\n        image: %v\n        compute-type: %v\n        privileged-mode: %v\n      buildspec: \"%v\"\n      depend-on:\n        - sdinit\nartifacts:\n  base-directory: /opt\n  files:  \n    - '/opt/**/*'",
+		provider["launcherEnvironmentType"].(string), provider["launcherImage"].(string), provider["launcherComputeType"].(string),
+		environmentType, config["container"].(string), provider["computeType"].(string), privilegedMode, mainBuildspec)
+
+	install, build = getLauncherCommands(environmentType, "$CODEBUILD_SRC_DIR")
+	singleBuildSpec := fmt.Sprintf("version: 0.2\nphases:\n  install:\n    commands:\n       - %v\n  build:\n    commands:\n       - %v\n", install, build)
+	if keepAlive {
+		singleBuildSpec += fmt.Sprintf("  post_build:\n    commands:\n       - %v\n", keepAliveCommand(environmentType))
+	}
+	if len(cachePaths) > 0 {
+		singleBuildSpec += cacheSpec(cachePaths, "\n") + "\n"
+	}
 
 	return batchBuildSpec, singleBuildSpec
 }
 
-// starts a build using codebuild service api
-func startBuild(project string, envVars []*codebuild.EnvironmentVariable, provider map[string]interface{}, serviceClient *awsAPI) error {
+// starts a build using codebuild service api, returns the build id.
+// The environment overrides the one of the project when set.
+func startBuild(project string, envVars []*codebuild.EnvironmentVariable, environment *codebuild.ProjectEnvironment, config map[string]interface{}, serviceClient *awsAPI) (string, error) {
 	log.Printf("Starting single build for project %q", project)
+	provider := config["provider"].(map[string]interface{})
 
 	buildInput := &codebuild.StartBuildInput{
 		EnvironmentVariablesOverride: envVars,
 		ProjectName:                  aws.String(project),
 		ServiceRoleOverride:          aws.String(provider["role"].(string)),
 	}
+	setEnvironmentOverrides(buildInput, environment)
+	if version, _ := config[sourceVersionKey].(string); version != "" {
+		buildInput.SourceVersion = aws.String(version)
+	}
 	if provider["executorLogs"].(bool) {
 		buildInput.LogsConfigOverride = &codebuild.LogsConfig{
 			CloudWatchLogs: &codebuild.CloudWatchLogsConfig{
@@ -182,21 +439,28 @@ func startBuild(project string, envVars []*codebuild.EnvironmentVariable, provid
 		}
 	}
 	if provider["debugSession"].(bool) {
+		buildTimeout, _ := config["buildTimeout"].(json.Number).Int64()
 		buildInput.DebugSessionEnabled = aws.Bool(true)
+		buildInput.TimeoutInMinutesOverride = aws.Int64(debugTimeout(provider, buildTimeout))
 	}
 
-	_, err := serviceClient.cb.StartBuild(buildInput)
-	return err
+	startResult, err := serviceClient.cb.StartBuild(buildInput)
+	if err != nil || startResult.Build == nil {
+		return "", err
+	}
+	return aws.StringValue(startResult.Build.Id), nil
 }
 
-// starts builds in batch using codebuild service api
-func startBuildBatch(project string, envVars []*codebuild.EnvironmentVariable, config map[string]interface{}, batchBuildSpec string, serviceClient *awsAPI) error {
+// starts builds in batch using codebuild service api, returns the build batch id
+func startBuildBatch(project string, envVars []*codebuild.EnvironmentVariable, config map[string]interface{}, batchBuildSpec string, serviceClient *awsAPI) (string, error) {
 	log.Printf("Starting batch build for project %q", project)
 
 	buildBatchInput := getStartBuildBatchInput(envVars, project, config, batchBuildSpec)
-	_, err := serviceClient.cb.StartBuildBatch(buildBatchInput)
-
-	return err
+	startResult, err := serviceClient.cb.StartBuildBatch(buildBatchInput)
+	if err != nil || startResult.BuildBatch == nil {
+		return "", err
+	}
+	return aws.StringValue(startResult.BuildBatch.Id), nil
 }
 
 // gets the input required for running a build batch
@@ -213,13 +477,13 @@ func getStartBuildBatchInput(envVars []*codebuild.EnvironmentVariable, project s
 			OverrideArtifactName: aws.Bool(false),
 			Packaging:            aws.String("ZIP"),
 			Type:                 aws.String("S3"),
-			Name:                 aws.String(provider["launcherVersion"].(string)),
+			Name:                 aws.String(launcher.Bundle(provider)),
 		},
 		BuildBatchConfigOverride: &codebuild.ProjectBuildBatchConfig{
 			CombineArtifacts: aws.Bool(false),
 			Restrictions:     &codebuild.BatchRestrictions{MaximumBuildsAllowed: aws.Int64(2)},
 			ServiceRole:      aws.String(provider["role"].(string)),
-			TimeoutInMins:    aws.Int64(buildTimeout),
+			TimeoutInMins:    aws.Int64(debugTimeout(provider, buildTimeout)),
 		},
 		BuildspecOverride:  aws.String(batchBuildSpec),
 		SourceTypeOverride: aws.String("NO_SOURCE"),
@@ -242,11 +506,18 @@ func getStartBuildBatchInput(envVars []*codebuild.EnvironmentVariable, project s
 func getRequestObject(project string, launcherVersion string, launcherUpdate bool, config map[string]interface{}) (*codebuild.CreateProjectInput, string) {
 	provider := config["provider"].(map[string]interface{})
 
-	if provider["environmentType"].(string) == "ARM_CONTAINER" {
+	if arch, _ := launcher.Architecture(provider); arch == launcher.ARM64 {
+		if provider["environmentType"].(string) == "LINUX_CONTAINER" {
+			provider["environmentType"] = "ARM_CONTAINER"
+		}
 		provider["launcherEnvironmentType"] = "ARM_CONTAINER"
 	}
+	lambda := isLambda(provider["computeType"].(string))
+	if lambda {
+		applyLambda(provider)
+	}
 
-	batchBuildSpec, singleBuildSpec := getBuildSpec(provider)
+	batchBuildSpec, singleBuildSpec := getBuildSpec(config)
 	sourceIdentifier := sdInitPrefix + launcherVersion
 
 	vpc := provider["vpc"].(map[string]interface{})
@@ -319,15 +590,30 @@ func getRequestObject(project string, launcherVersion string, launcherUpdate boo
 			TimeoutInMins:    aws.Int64(buildTimeout),
 		}
 	}
+	startMode := startModeBuild
+	if launcherUpdate {
+		startMode = startModeBatch
+	}
+	createRequest.Tags = []*codebuild.Tag{{Key: aws.String(startModeTag), Value: aws.String(startMode)}}
+	// the tags of the build attribute the project costs to the pipeline
+	buildTags := tags.Build(config)
+	for _, k := range tags.Keys(buildTags) {
+		createRequest.Tags = append(createRequest.Tags, &codebuild.Tag{Key: aws.String(k), Value: aws.String(buildTags[k])})
+	}
 	if provider["dlc"].(bool) {
 		createRequest.Cache = &codebuild.ProjectCache{
 			Location: new(string),
-			Modes:    []*string{aws.String("LOCAL_DOCKER_LAYER_CACHE")},
+			Modes:    aws.StringSlice(getCacheModes(provider)),
 			Type:     aws.String("LOCAL"),
 		}
 
 		createRequest.Environment.PrivilegedMode = aws.Bool(true)
 	}
+	if lambda {
+		// lambda compute has no build queue and stops the builds at its own limit
+		createRequest.QueuedTimeoutInMinutes = nil
+		createRequest.TimeoutInMinutes = nil
+	}
 	return createRequest, batchBuildSpec
 }
 
@@ -335,20 +621,41 @@ func getRequestObject(project string, launcherVersion string, launcherUpdate boo
 func getEnvVars(config map[string]interface{}) []*codebuild.EnvironmentVariable {
 	buildTimeout, _ := config["buildTimeout"].(json.Number).Int64()
 	buildID, _ := config["buildId"].(json.Number).Int64()
-	return []*codebuild.EnvironmentVariable{
+	provider := config["provider"].(map[string]interface{})
+	// flags are validated when the build is started
+	flags, _ := launcher.GetFlags(provider, executorName)
+	envVars := []*codebuild.EnvironmentVariable{
 		{Name: aws.String("TOKEN"), Value: aws.String(config["token"].(string))},
 		{Name: aws.String("API"), Value: aws.String(config["apiUri"].(string))},
 		{Name: aws.String("STORE"), Value: aws.String(config["storeUri"].(string))},
 		{Name: aws.String("UI"), Value: aws.String(config["uiUri"].(string))},
 		{Name: aws.String("TIMEOUT"), Value: aws.String(fmt.Sprint(buildTimeout))},
 		{Name: aws.String("SDBUILDID"), Value: aws.String(fmt.Sprint(buildID))},
-		{Name: aws.String("SD_HAB_ENABLED"), Value: aws.String(strconv.FormatBool(false))},
 		{Name: aws.String("SD_AWS_INTEGRATION"), Value: aws.String(strconv.FormatBool(true))},
 	}
+	// codebuild resolves the token from its secret with the service role, the token never shows in the project
+	if arn, _ := config[buildtoken.ArnKey].(string); arn != "" {
+		envVars[0] = &codebuild.EnvironmentVariable{Name: aws.String("TOKEN"), Value: aws.String(arn), Type: aws.String(codebuild.EnvironmentVariableTypeSecretsManager)}
+	}
+	if mins := keepAliveMinutes(provider); mins > 0 {
+		envVars = append(envVars, &codebuild.EnvironmentVariable{Name: aws.String(keepAliveEnv), Value: aws.String(fmt.Sprint(mins))})
+	}
+	for _, env := range flags.Env() {
+		envVars = append(envVars, &codebuild.EnvironmentVariable{Name: aws.String(env.Name), Value: aws.String(env.Value)})
+	}
+	for _, env := range policy.Environment(config) {
+		envVars = append(envVars, &codebuild.EnvironmentVariable{Name: aws.String(env.Name), Value: aws.String(env.Value)})
+	}
+	return envVars
 }
 
 // gets the formatted project name
 func getProjectName(config map[string]interface{}) string {
+	// named by the naming of the deployment policy
+	if projectName, _ := config[policy.ProjectNameKey].(string); projectName != "" {
+		log.Printf("Project name: %v", projectName)
+		return projectName
+	}
 	var jobName = config["jobName"].(string)
 	if config["isPR"].(bool) {
 		jobName = strings.Replace(jobName, ":", "-", 1)
@@ -361,19 +668,64 @@ func getProjectName(config map[string]interface{}) string {
 	return projectName
 }
 
+// narrows the vpc subnets to the one picked by the subnet strategy, keeping all subnets if the selection fails
+func selectSubnet(serviceClient *awsAPI, config map[string]interface{}) {
+	provider := config["provider"].(map[string]interface{})
+	vpc, _ := provider["vpc"].(map[string]interface{})
+	strategy := subnet.Strategy(vpc)
+	subnetIDs := subnet.IDs(vpc)
+	if strategy == "" || len(subnetIDs) < 2 {
+		return
+	}
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	selected, err := subnet.Select(serviceClient.ec2, strategy, subnetIDs, buildID)
+	if err != nil {
+		log.Printf("Error selecting subnet, using all subnets: %v", err)
+		return
+	}
+	log.Printf("Selected subnet %v in %v", aws.StringValue(selected.SubnetId), aws.StringValue(selected.AvailabilityZone))
+	vpc["subnetIds"] = []interface{}{aws.StringValue(selected.SubnetId)}
+}
+
 // Start function of executor creates a codebuild project and starts a build
 func (e *AwsServerless) Start(config map[string]interface{}) (string, error) {
 	provider := config["provider"].(map[string]interface{})
+	if _, err := launcher.GetFlags(provider, executorName); err != nil {
+		return "", executorState.Errorf(executorState.UserError, "%w", err)
+	}
+	if isLambda(provider["computeType"].(string)) {
+		if err := checkLambda(provider); err != nil {
+			return "", executorState.Errorf(executorState.UserError, "%w", err)
+		}
+	}
 
-	launcherVersion := provider["launcherVersion"].(string)
-	bucket := getBucketName(provider["region"].(string), provider["buildRegion"].(string))
+	launcherVersion := launcher.Bundle(provider)
+	bucket, err := BucketName(provider)
+	if err != nil {
+		return "", executorState.Errorf(executorState.InfraPermanent, "Got error getting bucket name: %v", err)
+	}
 	// set bucket to config
 	config["bucket"] = bucket
 
+	if validateBucketEnabled() {
+		if err := validateBucket(e.serviceClient, bucket, getBuildRegion(provider)); err != nil {
+			return "", fmt.Errorf("Got error validating build bucket: %w", err)
+		}
+	}
+
 	launcherUpdate := checkLauncherUpdate(e.serviceClient, launcherVersion, bucket)
 
 	log.Printf("Launcher Updated: %v", launcherUpdate)
 
+	// the bundle is staged by a sync build unless the build runs as a batch build graph,
+	// lambda compute does not run batch builds
+	syncLauncherBundle := launcherUpdate && (!batchLauncherSync() || isLambda(provider["computeType"].(string)))
+	if syncLauncherBundle {
+		launcherUpdate = false
+	}
+
+	selectSubnet(e.serviceClient, config)
+
 	project := getProjectName(config)
 
 	var names []*string
@@ -384,72 +736,162 @@ func (e *AwsServerless) Start(config map[string]interface{}) (string, error) {
 
 	if err != nil {
 		log.Printf("Error-BatchGetProjects: %v, creating project", err)
+	} else if len(batchResult.Projects) > 0 && adoptExisting(e.serviceClient, config) {
+		return aws.StringValue(batchResult.Projects[0].Arn), nil
 	}
 
 	createRequest, batchBuildSpec := getRequestObject(project, launcherVersion, launcherUpdate, config)
 
+	if syncLauncherBundle {
+		if err := syncLauncher(e.serviceClient, launcherVersion, config); err != nil {
+			return "", fmt.Errorf("Got error syncing launcher: %w", err)
+		}
+	}
+	if !launcherUpdate {
+		// pin the bundle version, so an overwrite of the bundle can't change it mid-build
+		version, err := getLauncherSourceVersion(e.serviceClient, bucket, launcherVersion)
+		if err != nil {
+			log.Printf("Error getting version of launcher bundle, starting unpinned: %v", err)
+		} else if version == "" {
+			log.Printf("Bucket %v is not versioned, starting unpinned", bucket)
+		} else {
+			log.Printf("Pinned launcher bundle to version %v", version)
+			createRequest.SourceVersion = aws.String(version)
+			config[sourceVersionKey] = version
+		}
+	}
+
 	var projectArn string
+	var existing *codebuild.Project
+	if batchResult != nil && len(batchResult.Projects) > 0 {
+		existing = batchResult.Projects[0]
+	}
+	// single builds of projects differing only in their environment start with it as overrides
+	var environmentOverrides *codebuild.ProjectEnvironment
+	upToDate := false
+	if startOverridesEnabled() && !launcherUpdate {
+		environmentOverrides = createRequest.Environment
+		upToDate = tagProjectHash(createRequest, existing)
+	}
 
-	if batchResult == nil || len(batchResult.Projects) == 0 {
+	if existing == nil {
 		log.Printf("Project does not exist, creating project")
 		createResult, err := e.serviceClient.cb.CreateProject(createRequest)
 		if err != nil {
-			return "", fmt.Errorf("Error-CreateProject: %v", err)
+			return "", executorState.Errorf(errorCategory(err), "Error-CreateProject: %v", err)
 		}
 		projectArn = *createResult.Project.Arn
+	} else if upToDate {
+		log.Printf("Project is up to date, starting with environment overrides")
+		projectArn = aws.StringValue(existing.Arn)
 	} else {
 		log.Printf("Project already exists, updating project")
 		updateRequest := codebuild.UpdateProjectInput(*createRequest)
 		updateResult, err := e.serviceClient.cb.UpdateProject(&updateRequest)
 		if err != nil {
-			return "", fmt.Errorf("Error-UpdateProject: %v", err)
+			return "", executorState.Errorf(errorCategory(err), "Error-UpdateProject: %v", err)
 		}
 		projectArn = *updateResult.Project.Arn
 	}
 
 	log.Printf("Project Arn%q", projectArn)
 
+	if provider["executorLogs"].(bool) {
+		if err := ensureLogGroup(e.serviceClient, config, project); err != nil {
+			log.Printf("Error setting up log group of project %q: %v", project, err)
+		}
+	}
+
 	envVars := getEnvVars(config)
 
 	if launcherUpdate {
 		// starts a batch build with launcher->build else starts only the build
-		err = startBuildBatch(project, envVars, config, batchBuildSpec, e.serviceClient)
+		config["codebuildBatchId"], err = startBuildBatch(project, envVars, config, batchBuildSpec, e.serviceClient)
 	} else {
 		// Start single build
-		err = startBuild(project, envVars, provider, e.serviceClient)
+		config["codebuildBuildId"], err = startBuild(project, envVars, environmentOverrides, config, e.serviceClient)
 	}
 
 	if err != nil {
-		return "", fmt.Errorf("Got error building project: %v", err)
+		return "", executorState.Errorf(errorCategory(err), "Got error building project: %v", err)
 	}
 
 	log.Printf("Started build for project %q", project)
 
+	if syncLauncherBundle || launcherUpdate {
+		// a new bundle was staged, drop the oldest ones
+		if err := cleanupBundles(e.serviceClient, bucket, launcherVersion); err != nil {
+			log.Printf("Error cleaning up launcher bundles of bucket %v: %v", bucket, err)
+		}
+	}
+
 	return projectArn, nil
 }
 
-// Stop a build and delete build project
+// stops the running build or build batch of the project
+func stopActive(serviceClient *awsAPI, project string) error {
+	switch getStartMode(serviceClient, project) {
+	case startModeBatch:
+		return stopBuildBatch(serviceClient, project)
+	case startModeBuild:
+		return stopBuild(serviceClient, project)
+	}
+	// projects created before the start mode was tagged may run either
+	log.Printf("Start mode of project %q is unknown, stopping builds and build batches", project)
+	stopErr := stopBuild(serviceClient, project)
+	if err := stopBuildBatch(serviceClient, project); err != nil {
+		stopErr = err
+	}
+	return stopErr
+}
+
+// runs a step of a stop until it succeeds or all attempts failed, returns the last error
+func retryStopStep(step string, fn func() error) (err error) {
+	for attempt := 1; attempt <= stopAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		log.Printf("Error %s (attempt %d of %d): %v", step, attempt, stopAttempts, err)
+		if attempt < stopAttempts {
+			time.Sleep(stopRetryInterval)
+		}
+	}
+	return err
+}
+
+// Stop a build and delete build project. With prune the project is only deleted once the build
+// is stopped, a project with a build which could not be stopped is kept for the next stop.
 func (e *AwsServerless) Stop(config map[string]interface{}) (err error) {
 	provider := config["provider"].(map[string]interface{})
 	project := getProjectName(config)
 
-	bucket := getBucketName(provider["region"].(string), provider["buildRegion"].(string))
-	// set bucket to config
-	config["bucket"] = bucket
-
-	var stopErr error
-	if checkLauncherUpdate(e.serviceClient, provider["launcherVersion"].(string), bucket) {
-		stopErr = stopBuildBatch(e.serviceClient, project)
-	} else {
-		stopErr = stopBuild(e.serviceClient, project)
+	// the stop of a failed build kept alive for debugging leaves it and its project to the build timeout
+	if keepAliveMinutes(provider) > 0 {
+		if build := keptAliveBuild(e.serviceClient, config); build != nil {
+			log.Printf("Not stopping build %v of project %q, it is kept alive until it times out", aws.StringValue(build.Id), project)
+			return nil
+		}
 	}
 
+	stopErr := retryStopStep("stopping build", func() error {
+		return stopActive(e.serviceClient, project)
+	})
 	if stopErr != nil {
 		log.Printf("Error stopping build: %v", stopErr)
 	}
 
 	if provider["prune"].(bool) {
-		return deleteProject(e.serviceClient, project)
+		if stopErr != nil {
+			return fmt.Errorf("Got error stopping build, keeping project %s: %v", project, stopErr)
+		}
+		if provider["executorLogs"].(bool) {
+			if err := deleteLogGroup(e.serviceClient, project); err != nil {
+				log.Printf("Error deleting log group of project %q: %v", project, err)
+			}
+		}
+		return retryStopStep("deleting project", func() error {
+			return deleteProject(e.serviceClient, project)
+		})
 	}
 
 	return nil
@@ -460,19 +902,24 @@ func (e *AwsServerless) Name() string {
 	return e.name
 }
 
-// New returns a new instance of executor and service client
-func New(region string) *AwsServerless {
-	sess, _ := session.NewSession(&aws.Config{
-		Region: aws.String(region)},
-	)
-	// Create CodeBuild & S3 service client
+// New returns a new instance of executor and service client, failing when the aws session can't be created
+func New(region string) (*AwsServerless, error) {
+	sess, err := awsconfig.NewSession(region)
+	if err != nil {
+		return nil, err
+	}
+	// Create CodeBuild, S3, EC2, CloudWatch Logs, KMS & tagging service client
 	svcClient := &awsAPI{
-		s3: s3.New(sess),
-		cb: codebuild.New(sess),
+		s3:      s3.New(sess),
+		cb:      codebuild.New(sess),
+		ec2:     ec2.New(sess),
+		logs:    cloudwatchlogs.New(sess),
+		kms:     kms.New(sess),
+		tagging: resourcegroupstaggingapi.New(sess),
 	}
 
 	return &AwsServerless{
 		name:          executorName,
 		serviceClient: svcClient,
-	}
+	}, nil
 }
@@ -7,12 +7,18 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/service/codebuild"
 	"github.com/aws/aws-sdk-go/service/codebuild/codebuildiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -122,18 +128,38 @@ func (m *mockCodeBuildClient) ListBuildsForProject(input *codebuild.ListBuildsFo
 	args := m.Called(input)
 	return args.Get(0).(*codebuild.ListBuildsForProjectOutput), args.Error(1)
 }
+func (m *mockCodeBuildClient) ListBuilds(input *codebuild.ListBuildsInput) (*codebuild.ListBuildsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*codebuild.ListBuildsOutput), args.Error(1)
+}
 func (m *mockCodeBuildClient) BatchGetBuilds(input *codebuild.BatchGetBuildsInput) (*codebuild.BatchGetBuildsOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*codebuild.BatchGetBuildsOutput), args.Error(1)
 }
+func (m *mockCodeBuildClient) BatchGetBuildBatches(input *codebuild.BatchGetBuildBatchesInput) (*codebuild.BatchGetBuildBatchesOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*codebuild.BatchGetBuildBatchesOutput), args.Error(1)
+}
 func (m *mockCodeBuildClient) StopBuild(input *codebuild.StopBuildInput) (*codebuild.StopBuildOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*codebuild.StopBuildOutput), args.Error(1)
 }
+func (m *mockCodeBuildClient) ListBuildBatchesForProject(input *codebuild.ListBuildBatchesForProjectInput) (*codebuild.ListBuildBatchesForProjectOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*codebuild.ListBuildBatchesForProjectOutput), args.Error(1)
+}
 func (m *mockCodeBuildClient) StopBuildBatch(input *codebuild.StopBuildBatchInput) (*codebuild.StopBuildBatchOutput, error) {
 	args := m.Called(input)
 	return args.Get(0).(*codebuild.StopBuildBatchOutput), args.Error(1)
 }
+func (m *mockS3Client) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.HeadBucketOutput), args.Error(1)
+}
+func (m *mockS3Client) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
+}
 func (m *mockS3Client) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
 	args := m.Called(input)
 	return args.Get(0).(*s3.ListObjectsV2Output), args.Error(1)
@@ -208,11 +234,115 @@ func TestCheckLauncherUpdateWithFailure(t *testing.T) {
 	assert.Equal(t, errTestCase.expectedOutput, got)
 }
 
-// func TestGetBucketName(t *testing.T) {
+func TestGetRegionShortName(t *testing.T) {
+	tests := []struct {
+		region   string
+		expected string
+		err      string
+	}{
+		{region: "us-west-2", expected: "usw2"},
+		{region: "us-east-1", expected: "use1"},
+		{region: "ap-southeast-1", expected: "apse1"},
+		{region: "ap-southeast-3", expected: "apse3"},
+		{region: "ap-northeast-3", expected: "apne3"},
+		{region: "eu-central-2", expected: "euc2"},
+		{region: "eu-south-1", expected: "eus1"},
+		{region: "us-gov-west-1", expected: "usgovw1"},
+		{region: "us-gov-east-1", expected: "usgove1"},
+		{region: "us-iso-east-1", expected: "usisoe1"},
+		{region: "us-isob-east-1", expected: "usisobe1"},
+		{region: "cn-north-1", expected: "cnn1"},
+		{region: "cn-northwest-1", expected: "cnnw1"},
+		{region: "", err: `invalid region ""`},
+		{region: "us-west", err: `invalid region "us-west"`},
+		{region: "us-west-2a", err: `invalid region "us-west-2a"`},
+		{region: "US-WEST-2", err: `invalid region "US-WEST-2"`},
+		{region: "us-foo-west-1", err: `invalid region "us-foo-west-1"`},
+		{region: "us-middle-1", err: `unknown direction "middle" in region "us-middle-1"`},
+	}
+	for _, test := range tests {
+		shortName, err := getRegionShortName(test.region)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.region)
+			continue
+		}
+		assert.Nil(t, err, test.region)
+		assert.Equal(t, test.expected, shortName, test.region)
+	}
+}
 
-// }
+func TestGetRegionShortNameKnownRegions(t *testing.T) {
+	shortNames := map[string]string{}
+	for _, partition := range endpoints.DefaultPartitions() {
+		for region := range partition.Regions() {
+			shortName, err := getRegionShortName(region)
+			assert.Nil(t, err, region)
+			if other, ok := shortNames[shortName]; ok {
+				t.Errorf("regions %s and %s have the same short name %s", region, other, shortName)
+			}
+			shortNames[shortName] = region
+		}
+	}
+}
+
+func TestGetBucketName(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-aws-consumer-usw2-bucket")
+	bucket, err := getBucketName("us-west-2", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-aws-consumer-usw2-bucket", bucket)
+
+	bucket, err = getBucketName("us-west-2", "us-east-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-aws-consumer-use1-bucket", bucket)
+
+	_, err = getBucketName("us-west-2", "invalid")
+	assert.EqualError(t, err, `invalid region "invalid"`)
+
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-aws-consumer-usgovw1-bucket")
+	bucket, err = getBucketName("us-gov-west-1", "us-gov-east-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-aws-consumer-usgove1-bucket", bucket)
+}
+
+func TestBucketName(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-aws-consumer-usw2-bucket")
+	bucket, err := BucketName(map[string]interface{}{"region": "us-west-2", "buildRegion": "us-east-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-aws-consumer-use1-bucket", bucket)
+
+	bucket, err = BucketName(map[string]interface{}{"region": "us-west-2", "buildRegion": "us-east-1", "bucket": "sd-team-builds"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-team-builds", bucket)
+
+	bucket, err = BucketName(map[string]interface{}{"region": "us-west-2", "buildRegion": "us-east-1", "accountBucket": "sd-team-a-usw2-builds"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-team-a-use1-builds", bucket)
+
+	bucket, err = BucketName(map[string]interface{}{"region": "us-west-2", "accountBucket": "sd-team-a-usw2-builds"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-team-a-usw2-builds", bucket)
+}
+
+func TestBucketNameAccountLayout(t *testing.T) {
+	t.Setenv("SD_SLS_BUILD_BUCKET", "sd-aws-consumer-usw2-bucket")
+	t.Setenv("SD_SLS_BUCKET_LAYOUT", "account")
+	bucket, err := BucketName(map[string]interface{}{"region": "us-west-2", "buildRegion": "us-east-1", "accountBucket": "sd-team-a-usw2-builds"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-team-a-use1-builds", bucket)
+
+	bucket, err = BucketName(map[string]interface{}{"region": "us-west-2", "bucket": "sd-team-builds", "accountBucket": "sd-team-a-usw2-builds"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sd-team-builds", bucket)
+
+	_, err = BucketName(map[string]interface{}{"region": "us-west-2", "accountId": "111111111"})
+	assert.EqualError(t, err, "no build bucket for account 111111111, SD_SLS_BUCKET_LAYOUT=account needs the bucket of the account in the provider registry or provider.bucket")
+
+	_, err = BucketName(map[string]interface{}{"region": "us-west-2", "buildRegion": "invalid", "accountBucket": "sd-team-a-usw2-builds"})
+	assert.EqualError(t, err, `invalid region "invalid"`)
+}
 
 func TestStart(t *testing.T) {
+	t.Setenv("SD_SLS_LAUNCHER_SYNC", "batch")
 	projectName := testJobName + "-" + testJobID
 	projectArn := "arn:aws:codebuild:project//" + projectName
 	testConfig := getTestConfig()
@@ -315,6 +445,8 @@ func TestStart(t *testing.T) {
 
 		mockServiceClient, mockCBAPI, mockS3API := setup()
 		mockS3API.On("ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String(testBucket)}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(sourceID)}}}, nil)
+		mockS3API.On("ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String(testBucket), Prefix: aws.String(sdInitPrefix)}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(sourceID)}}}, nil)
+		mockS3API.On("HeadObject", &s3.HeadObjectInput{Bucket: aws.String(testBucket), Key: aws.String(sourceID)}).Return(&s3.HeadObjectOutput{VersionId: aws.String("null")}, nil)
 		mockCBAPI.On("BatchGetProjects", &codebuild.BatchGetProjectsInput{Names: names}).Return(&codebuild.BatchGetProjectsOutput{}, testCase.batchGetError)
 		mockCBAPI.On("CreateProject", createRequest).Return(&codebuild.CreateProjectOutput{Project: &codebuild.Project{Arn: aws.String(projectArn)}}, testCase.createProjectError)
 		mockCBAPI.On("StartBuild", startBuildRequest).Return(&codebuild.StartBuildOutput{}, testCase.startBuildError)
@@ -334,6 +466,7 @@ func TestStart(t *testing.T) {
 }
 
 func TestStartWhenProjectExists(t *testing.T) {
+	t.Setenv("SD_SLS_LAUNCHER_SYNC", "batch")
 	projectName := testJobName + "-" + testJobID
 	projectArn := "arn:aws:codebuild:project//" + projectName
 	testConfig := getTestConfig()
@@ -434,7 +567,13 @@ func TestStartWhenProjectExists(t *testing.T) {
 
 		mockServiceClient, mockCBAPI, mockS3API := setup()
 		mockS3API.On("ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String(testBucket)}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(sourceID)}}}, nil)
+		mockS3API.On("ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String(testBucket), Prefix: aws.String(sdInitPrefix)}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(sourceID)}}}, nil)
+		mockS3API.On("HeadObject", &s3.HeadObjectInput{Bucket: aws.String(testBucket), Key: aws.String(sourceID)}).Return(&s3.HeadObjectOutput{VersionId: aws.String("null")}, nil)
 		mockCBAPI.On("BatchGetProjects", &codebuild.BatchGetProjectsInput{Names: names}).Return(&codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{{Name: aws.String(projectName)}}}, testCase.batchGetError)
+		mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(projectName), SortOrder: aws.String("DESCENDING")}).
+			Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{projectName + ":2"})}, nil)
+		mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{projectName + ":2"})}).
+			Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{testBuildOf("1233")}}, nil)
 		mockCBAPI.On("UpdateProject", &updateRequest).Return(&codebuild.UpdateProjectOutput{Project: &codebuild.Project{Arn: aws.String(projectArn)}}, testCase.updateProjectError)
 		mockCBAPI.On("StartBuild", startBuildRequest).Return(&codebuild.StartBuildOutput{}, testCase.startBuildError)
 		mockCBAPI.On("StartBuildBatch", buildBatchInput).Return(&codebuild.StartBuildBatchOutput{}, testCase.startBuildBatchError)
@@ -453,12 +592,13 @@ func TestStartWhenProjectExists(t *testing.T) {
 }
 
 func TestStop(t *testing.T) {
+	defer func(interval time.Duration) { stopRetryInterval = interval }(stopRetryInterval)
+	stopRetryInterval = time.Millisecond
 	projectName := testJobName + "-" + testJobID
 	stopConfig := getTestConfig()
 	provider := stopConfig["provider"].(map[string]interface{})
 	provider["prune"] = true
 	stopConfig["provider"] = provider
-	sourceID := "sdinit-" + testLauncherVersion
 	os.Setenv("SD_SLS_BUILD_BUCKET", testBucket)
 	defer os.Unsetenv("SD_SLS_BUILD_BUCKET")
 	buildID := "1234"
@@ -484,12 +624,26 @@ func TestStop(t *testing.T) {
 			deleteProjectError: errors.New("Access Denied"),
 			stopBuildError:     nil,
 		},
+		{
+			message:            "Deleted project is not an error",
+			expectedInput:      stopConfig,
+			expectedError:      nil,
+			deleteProjectError: awserr.New(codebuild.ErrCodeResourceNotFoundException, "project not found", nil),
+			stopBuildError:     nil,
+		},
+		{
+			message:            "Project is kept when the build is not stopped",
+			expectedInput:      stopConfig,
+			expectedError:      errors.New("Got error stopping build, keeping project " + projectName + ": Got error stopping build: Throttling"),
+			deleteProjectError: nil,
+			stopBuildError:     errors.New("Throttling"),
+		},
 	}
 
 	for _, testCase := range testCases {
-		mockServiceClient, mockCBAPI, mockS3API := setup()
+		mockServiceClient, mockCBAPI, _ := setup()
 
-		mockS3API.On("ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String(testBucket)}).Return(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(sourceID)}}}, nil)
+		mockCBAPI.On("BatchGetProjects", &codebuild.BatchGetProjectsInput{Names: []*string{aws.String(projectName)}}).Return(projectWithStartMode(projectName, startModeBuild), nil)
 		mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(projectName), SortOrder: aws.String("DESCENDING")}).Return(&codebuild.ListBuildsForProjectOutput{Ids: []*string{aws.String(buildID)}}, nil)
 		mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: []*string{aws.String(buildID)}}).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String(buildID), BuildStatus: aws.String("IN_PROGRESS")}}}, nil)
 		mockCBAPI.On("StopBuild", &codebuild.StopBuildInput{Id: aws.String(buildID)}).Return(&codebuild.StopBuildOutput{Build: &codebuild.Build{BuildNumber: aws.Int64(int64(testBuildID))}}, testCase.stopBuildError)
@@ -501,5 +655,258 @@ func TestStop(t *testing.T) {
 		err := executor.Stop(testCase.expectedInput)
 		assert.IsType(t, testCase.expectedError, err, testCase.message)
 		assert.Equal(t, testCase.expectedError, err, testCase.message)
+		if testCase.stopBuildError != nil {
+			mockCBAPI.AssertNumberOfCalls(t, "StopBuild", stopAttempts)
+			mockCBAPI.AssertNotCalled(t, "DeleteProject", mock.Anything)
+		} else if testCase.deleteProjectError != nil && err != nil {
+			mockCBAPI.AssertNumberOfCalls(t, "DeleteProject", stopAttempts)
+		}
+	}
+}
+
+func TestStopBuild(t *testing.T) {
+	project := "deploy-123"
+	firstPage := &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(project), SortOrder: aws.String("DESCENDING")}
+	secondPage := &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(project), SortOrder: aws.String("DESCENDING"), NextToken: aws.String("page2")}
+
+	// stops all in progress builds and pages until a finished build
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockCBAPI.On("ListBuildsForProject", firstPage).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"b:4", "b:3"}), NextToken: aws.String("page2")}, nil)
+	mockCBAPI.On("ListBuildsForProject", secondPage).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"b:2", "b:1"}), NextToken: aws.String("page3")}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"b:4", "b:3"})}).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{
+		{Id: aws.String("b:4"), BuildStatus: aws.String("IN_PROGRESS")},
+		{Id: aws.String("b:3"), BuildStatus: aws.String("IN_PROGRESS")},
+	}}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"b:2", "b:1"})}).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{
+		{Id: aws.String("b:2"), BuildStatus: aws.String("SUCCEEDED")},
+		{Id: aws.String("b:1"), BuildStatus: aws.String("IN_PROGRESS")},
+	}}, nil)
+	mockCBAPI.On("StopBuild", &codebuild.StopBuildInput{Id: aws.String("b:4")}).Return(&codebuild.StopBuildOutput{}, errors.New("Throttling"))
+	mockCBAPI.On("StopBuild", mock.Anything).Return(&codebuild.StopBuildOutput{Build: &codebuild.Build{BuildNumber: aws.Int64(1)}}, nil)
+	assert.EqualError(t, stopBuild(mockServiceClient, project), "Got error stopping build: Throttling")
+	for _, id := range []string{"b:4", "b:3", "b:1"} {
+		mockCBAPI.AssertCalled(t, "StopBuild", &codebuild.StopBuildInput{Id: aws.String(id)})
 	}
+	mockCBAPI.AssertNumberOfCalls(t, "ListBuildsForProject", 2)
+
+	// list errors are returned, a missing project has nothing to stop
+	mockServiceClient, mockCBAPI, _ = setup()
+	mockCBAPI.On("ListBuildsForProject", firstPage).Return(&codebuild.ListBuildsForProjectOutput{}, errors.New("AccessDenied")).Once()
+	mockCBAPI.On("ListBuildsForProject", firstPage).Return(&codebuild.ListBuildsForProjectOutput{}, awserr.New(codebuild.ErrCodeResourceNotFoundException, "project not found", nil))
+	assert.EqualError(t, stopBuild(mockServiceClient, project), "Error-ListBuildsForProject: AccessDenied")
+	assert.Nil(t, stopBuild(mockServiceClient, project))
+
+	mockServiceClient, mockCBAPI, _ = setup()
+	mockCBAPI.On("ListBuildsForProject", firstPage).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"b:1"})}, nil)
+	mockCBAPI.On("BatchGetBuilds", mock.Anything).Return(&codebuild.BatchGetBuildsOutput{}, errors.New("Throttling"))
+	assert.EqualError(t, stopBuild(mockServiceClient, project), "Error-BatchGetBuilds: Throttling")
+
+	// builds deleted by a concurrent stop are already stopped
+	mockServiceClient, mockCBAPI, _ = setup()
+	mockCBAPI.On("ListBuildsForProject", firstPage).Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"b:1"})}, nil)
+	mockCBAPI.On("BatchGetBuilds", mock.Anything).Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{{Id: aws.String("b:1"), BuildStatus: aws.String("IN_PROGRESS")}}}, nil)
+	mockCBAPI.On("StopBuild", mock.Anything).Return(&codebuild.StopBuildOutput{}, awserr.New(codebuild.ErrCodeResourceNotFoundException, "build not found", nil))
+	assert.Nil(t, stopBuild(mockServiceClient, project))
+}
+
+func TestStopBuildBatch(t *testing.T) {
+	project := "deploy-123"
+	mockServiceClient, mockCBAPI, _ := setup()
+	mockCBAPI.On("ListBuildBatchesForProject", &codebuild.ListBuildBatchesForProjectInput{MaxResults: aws.Int64(100), ProjectName: aws.String(project), SortOrder: aws.String("DESCENDING")}).
+		Return(&codebuild.ListBuildBatchesForProjectOutput{Ids: aws.StringSlice([]string{"batch:2", "batch:1"})}, nil)
+	mockCBAPI.On("BatchGetBuildBatches", &codebuild.BatchGetBuildBatchesInput{Ids: aws.StringSlice([]string{"batch:2", "batch:1"})}).
+		Return(&codebuild.BatchGetBuildBatchesOutput{BuildBatches: []*codebuild.BuildBatch{
+			{Id: aws.String("batch:2"), BuildBatchStatus: aws.String("IN_PROGRESS")},
+			{Id: aws.String("batch:1"), BuildBatchStatus: aws.String("STOPPED")},
+		}}, nil)
+	mockCBAPI.On("StopBuildBatch", &codebuild.StopBuildBatchInput{Id: aws.String("batch:2")}).
+		Return(&codebuild.StopBuildBatchOutput{BuildBatch: &codebuild.BuildBatch{BuildBatchNumber: aws.Int64(2)}}, nil)
+	assert.Nil(t, stopBuildBatch(mockServiceClient, project))
+	mockCBAPI.AssertNumberOfCalls(t, "StopBuildBatch", 1)
+}
+
+func projectWithStartMode(project string, mode string) *codebuild.BatchGetProjectsOutput {
+	return &codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{{
+		Name: aws.String(project),
+		Tags: []*codebuild.Tag{{Key: aws.String(startModeTag), Value: aws.String(mode)}},
+	}}}
+}
+
+func TestStopStartMode(t *testing.T) {
+	projectName := testJobName + "-" + testJobID
+	listBuilds := &codebuild.ListBuildsForProjectInput{ProjectName: aws.String(projectName), SortOrder: aws.String("DESCENDING")}
+	listBatches := &codebuild.ListBuildBatchesForProjectInput{MaxResults: aws.Int64(100), ProjectName: aws.String(projectName), SortOrder: aws.String("DESCENDING")}
+
+	tests := []struct {
+		projects        *codebuild.BatchGetProjectsOutput
+		projectsErr     error
+		stopsBuilds     bool
+		stopsBuildBatch bool
+	}{
+		{projects: projectWithStartMode(projectName, startModeBuild), stopsBuilds: true},
+		{projects: projectWithStartMode(projectName, startModeBatch), stopsBuildBatch: true},
+		{projects: &codebuild.BatchGetProjectsOutput{Projects: []*codebuild.Project{{Name: aws.String(projectName)}}}, stopsBuilds: true, stopsBuildBatch: true},
+		{projects: &codebuild.BatchGetProjectsOutput{}, projectsErr: errors.New("throttled"), stopsBuilds: true, stopsBuildBatch: true},
+	}
+	for _, test := range tests {
+		config := getTestConfig()
+		config["provider"].(map[string]interface{})["prune"] = false
+		mockServiceClient, mockCBAPI, mockS3API := setup()
+		mockCBAPI.On("BatchGetProjects", mock.Anything).Return(test.projects, test.projectsErr)
+		mockCBAPI.On("ListBuildsForProject", listBuilds).Return(&codebuild.ListBuildsForProjectOutput{}, nil)
+		mockCBAPI.On("ListBuildBatchesForProject", listBatches).Return(&codebuild.ListBuildBatchesForProjectOutput{}, nil)
+
+		e := &AwsServerless{serviceClient: mockServiceClient}
+		assert.Nil(t, e.Stop(config))
+		if test.stopsBuilds {
+			mockCBAPI.AssertCalled(t, "ListBuildsForProject", listBuilds)
+		} else {
+			mockCBAPI.AssertNotCalled(t, "ListBuildsForProject", listBuilds)
+		}
+		if test.stopsBuildBatch {
+			mockCBAPI.AssertCalled(t, "ListBuildBatchesForProject", listBatches)
+		} else {
+			mockCBAPI.AssertNotCalled(t, "ListBuildBatchesForProject", listBatches)
+		}
+		mockS3API.AssertNotCalled(t, "ListObjectsV2", mock.Anything)
+	}
+}
+
+func TestGetRequestObjectStartMode(t *testing.T) {
+	buildTags := []*codebuild.Tag{
+		{Key: aws.String("managed-by"), Value: aws.String("screwdriver")},
+		{Key: aws.String("sd-job-id"), Value: aws.String("123")},
+		{Key: aws.String("sd-pipeline-id"), Value: aws.String("12345")},
+	}
+	createRequest, _ := getRequestObject("project", "v101", false, getTestConfig())
+	assert.Equal(t, append([]*codebuild.Tag{{Key: aws.String("sd-start-mode"), Value: aws.String("build")}}, buildTags...), createRequest.Tags)
+
+	createRequest, _ = getRequestObject("project", "v101", true, getTestConfig())
+	assert.Equal(t, append([]*codebuild.Tag{{Key: aws.String("sd-start-mode"), Value: aws.String("batch")}}, buildTags...), createRequest.Tags)
+}
+
+func TestSelectSubnet(t *testing.T) {
+	t.Setenv("SD_SUBNET_STRATEGY", "")
+	mockEC2API := new(mockEC2Client)
+	mockEC2API.On("DescribeSubnets", mock.Anything).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-1111"), AvailabilityZone: aws.String("us-west-2a"), AvailableIpAddressCount: aws.Int64(3)},
+		{SubnetId: aws.String("subnet-2222"), AvailabilityZone: aws.String("us-west-2b"), AvailableIpAddressCount: aws.Int64(250)},
+		{SubnetId: aws.String("subnet-3333"), AvailabilityZone: aws.String("us-west-2c"), AvailableIpAddressCount: aws.Int64(40)},
+	}}, nil)
+	serviceClient := &awsAPI{ec2: mockEC2API}
+
+	config := getTestConfig()
+	selectSubnet(serviceClient, config)
+	vpc := config["provider"].(map[string]interface{})["vpc"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"subnet-1111", "subnet-2222", "subnet-3333"}, vpc["subnetIds"])
+	mockEC2API.AssertNotCalled(t, "DescribeSubnets", mock.Anything)
+
+	t.Setenv("SD_SUBNET_STRATEGY", "most-free-ips")
+	selectSubnet(serviceClient, config)
+	assert.Equal(t, []interface{}{"subnet-2222"}, vpc["subnetIds"])
+
+	config = getTestConfig()
+	vpc = config["provider"].(map[string]interface{})["vpc"].(map[string]interface{})
+	vpc["subnetStrategy"] = "random"
+	selectSubnet(serviceClient, config)
+	assert.Equal(t, []interface{}{"subnet-1111", "subnet-2222", "subnet-3333"}, vpc["subnetIds"])
+}
+
+func TestGetRequestObjectArm64(t *testing.T) {
+	testConfig := getTestConfig()
+	provider := testConfig["provider"].(map[string]interface{})
+	provider["architecture"] = "arm64"
+
+	createRequest, batchBuildSpec := getRequestObject("project", "v101-arm64", false, testConfig)
+	assert.Equal(t, "ARM_CONTAINER", aws.StringValue(createRequest.Environment.Type))
+	assert.Equal(t, testBucket+"/sdinit-v101-arm64", aws.StringValue(createRequest.Source.Location))
+	assert.Contains(t, batchBuildSpec, "type: ARM_CONTAINER")
+
+	buildBatchInput := getStartBuildBatchInput(nil, "project", testConfig, batchBuildSpec)
+	assert.Equal(t, "v101-arm64", aws.StringValue(buildBatchInput.ArtifactsOverride.Name))
+}
+
+func TestGetBuildSpecMixedGraph(t *testing.T) {
+	testConfig := getTestConfig()
+	testConfig["container"] = "node:18"
+	provider := testConfig["provider"].(map[string]interface{})
+	provider["launcherEnvironmentType"] = "ARM_CONTAINER"
+	provider["computeType"] = "BUILD_GENERAL1_LARGE"
+	provider["dlc"] = true
+
+	batchBuildSpec, singleBuildSpec := getBuildSpec(testConfig)
+	assert.Contains(t, batchBuildSpec, "- identifier: sdinit\n      env:\n        type: ARM_CONTAINER\n        image: launcher:v101\n        compute-type: BUILD_GENERAL1_SMALL\n        privileged-mode: false\n")
+	assert.Contains(t, batchBuildSpec, "- identifier: main\n      env:\n        type: LINUX_CONTAINER\n        image: node:18\n        compute-type: BUILD_GENERAL1_LARGE\n        privileged-mode: true\n")
+	assert.Contains(t, batchBuildSpec, "mkdir /opt/sd && cp -r $CODEBUILD_SRC_DIR_sdinit_sdinit/opt/sd/* /opt/sd/")
+	assert.Contains(t, singleBuildSpec, "mkdir /opt/sd && cp -r $CODEBUILD_SRC_DIR/opt/sd/* /opt/sd/")
+
+	provider["launcherEnvironmentType"] = "LINUX_CONTAINER"
+	provider["environmentType"] = "WINDOWS_SERVER_2019_CONTAINER"
+	testConfig["container"] = "mcr.microsoft.com/windows/servercore:ltsc2019"
+	batchBuildSpec, singleBuildSpec = getBuildSpec(testConfig)
+	assert.Contains(t, batchBuildSpec, "type: LINUX_CONTAINER\n        image: launcher:v101")
+	assert.Contains(t, batchBuildSpec, "type: WINDOWS_SERVER_2019_CONTAINER\n        image: mcr.microsoft.com/windows/servercore:ltsc2019")
+	assert.Contains(t, batchBuildSpec, "Copy-Item -Recurse -Force -Path $CODEBUILD_SRC_DIR_sdinit_sdinit/opt/sd/* -Destination C:/sd/")
+	assert.Contains(t, batchBuildSpec, "C:/sd/launcher_entrypoint.ps1 C:/sd/run.ps1 $env:TOKEN")
+	assert.NotContains(t, singleBuildSpec, "/opt/sd/launcher_entrypoint.sh")
+	assert.Contains(t, singleBuildSpec, "Copy-Item -Recurse -Force -Path $CODEBUILD_SRC_DIR/opt/sd/* -Destination C:/sd/")
+}
+
+func TestGetEnvVarsPassthroughEnvironment(t *testing.T) {
+	testConfig := getTestConfig()
+	testConfig["environment"] = map[string]interface{}{"AWS_ACCESS_KEY_ID": "AKIA"}
+	testConfig[policy.EnvironmentKey] = map[string]string{"NODE_ENV": "production", "DEBUG": "true"}
+
+	envVars := getEnvVars(testConfig)
+	assert.Equal(t, 11, len(envVars))
+	assert.Equal(t, []*codebuild.EnvironmentVariable{
+		{Name: aws.String("DEBUG"), Value: aws.String("true")},
+		{Name: aws.String("NODE_ENV"), Value: aws.String("production")},
+	}, envVars[9:])
+}
+
+func TestGetEnvVarsTokenSecret(t *testing.T) {
+	testConfig := getTestConfig()
+	testConfig[buildtoken.ArnKey] = "arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-tokens/1234-AbCdEf"
+	envVars := getEnvVars(testConfig)
+	assert.Equal(t, &codebuild.EnvironmentVariable{
+		Name:  aws.String("TOKEN"),
+		Value: aws.String("arn:aws:secretsmanager:us-west-2:123456789012:secret:sd-build-tokens/1234-AbCdEf"),
+		Type:  aws.String("SECRETS_MANAGER"),
+	}, envVars[0])
+	for _, env := range envVars {
+		assert.NotEqual(t, testConfig["token"], aws.StringValue(env.Value))
+	}
+}
+
+func TestGetEnvVarsLauncherFlags(t *testing.T) {
+	t.Setenv("SD_LAUNCHER_HABITAT", "")
+	testConfig := getTestConfig()
+	envVars := getEnvVars(testConfig)
+	assert.Equal(t, []*codebuild.EnvironmentVariable{
+		{Name: aws.String("SD_HAB_ENABLED"), Value: aws.String("false")},
+		{Name: aws.String("SD_BASE_COMMAND_PATH"), Value: aws.String("/sd/commands/")},
+	}, envVars[7:])
+
+	provider := testConfig["provider"].(map[string]interface{})
+	provider["habitat"] = true
+	provider["sourceDir"] = "services/api"
+	envVars = getEnvVars(testConfig)
+	assert.Equal(t, []*codebuild.EnvironmentVariable{
+		{Name: aws.String("SD_HAB_ENABLED"), Value: aws.String("true")},
+		{Name: aws.String("SD_BASE_COMMAND_PATH"), Value: aws.String("/sd/commands/")},
+		{Name: aws.String("SD_SOURCE_DIR"), Value: aws.String("services/api")},
+	}, envVars[7:])
+
+	provider["baseCommandPath"] = "commands"
+	_, err := (&AwsServerless{}).Start(testConfig)
+	assert.EqualError(t, err, `baseCommandPath "commands" is not an absolute path`)
+}
+
+func TestGetProjectName(t *testing.T) {
+	testConfig := getTestConfig()
+	assert.Equal(t, testJobName+"-"+testJobID, getProjectName(testConfig))
+
+	testConfig[policy.ProjectNameKey] = "sd-prod-42-main-123"
+	assert.Equal(t, "sd-prod-42-main-123", getProjectName(testConfig))
 }
@@ -0,0 +1,36 @@
+package sls
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// sourceVersionKey holds the s3 object version of the launcher bundle in the build config
+const sourceVersionKey = "launcherSourceVersion"
+
+// gets the s3 object version of the sdinit bundle, empty if the bucket is not versioned
+func getLauncherSourceVersion(serviceClient *awsAPI, bucket string, bundle string) (string, error) {
+	head, err := serviceClient.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(sdInitPrefix + bundle),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error-HeadObject: %v", err)
+	}
+	// objects written before versioning was enabled have the null version
+	if version := aws.StringValue(head.VersionId); version != "null" {
+		return version, nil
+	}
+	return "", nil
+}
+
+// BuildStats reports the s3 object version of the launcher bundle the build was pinned to
+func (e *AwsServerless) BuildStats(config map[string]interface{}) map[string]interface{} {
+	version, _ := config[sourceVersionKey].(string)
+	if version == "" {
+		return nil
+	}
+	return map[string]interface{}{sourceVersionKey: version}
+}
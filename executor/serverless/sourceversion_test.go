@@ -0,0 +1,41 @@
+package sls
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLauncherSourceVersion(t *testing.T) {
+	input := &s3.HeadObjectInput{Bucket: aws.String(testBucket), Key: aws.String("sdinit-v101")}
+	mockServiceClient, _, mockS3API := setup()
+	mockS3API.On("HeadObject", input).Return(&s3.HeadObjectOutput{VersionId: aws.String("Wq2h7bE0.Tz8")}, nil).Once()
+	mockS3API.On("HeadObject", input).Return(&s3.HeadObjectOutput{VersionId: aws.String("null")}, nil).Once()
+	mockS3API.On("HeadObject", input).Return(&s3.HeadObjectOutput{}, nil).Once()
+	mockS3API.On("HeadObject", input).Return(&s3.HeadObjectOutput{}, errors.New("Forbidden")).Once()
+
+	version, err := getLauncherSourceVersion(mockServiceClient, testBucket, "v101")
+	assert.Nil(t, err)
+	assert.Equal(t, "Wq2h7bE0.Tz8", version)
+
+	for i := 0; i < 2; i++ {
+		version, err = getLauncherSourceVersion(mockServiceClient, testBucket, "v101")
+		assert.Nil(t, err)
+		assert.Equal(t, "", version)
+	}
+
+	_, err = getLauncherSourceVersion(mockServiceClient, testBucket, "v101")
+	assert.EqualError(t, err, "Error-HeadObject: Forbidden")
+}
+
+func TestBuildStats(t *testing.T) {
+	e := &AwsServerless{}
+	config := getTestConfig()
+	assert.Nil(t, e.BuildStats(config))
+
+	config[sourceVersionKey] = "Wq2h7bE0.Tz8"
+	assert.Equal(t, map[string]interface{}{"launcherSourceVersion": "Wq2h7bE0.Tz8"}, e.BuildStats(config))
+}
@@ -0,0 +1,190 @@
+package sls
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+// number of recent project builds searched for the screwdriver build
+const statusBuildsLimit = 10
+
+// phases of an in progress build before the build environment is ready
+var queuedPhases = map[string]bool{
+	codebuild.BuildPhaseTypeSubmitted:    true,
+	codebuild.BuildPhaseTypeQueued:       true,
+	codebuild.BuildPhaseTypeProvisioning: true,
+}
+
+// gets the reason of a failed build from the first phase which did not succeed
+func failureReason(build *codebuild.Build) string {
+	reason := aws.StringValue(build.BuildStatus)
+	for _, phase := range build.Phases {
+		status := aws.StringValue(phase.PhaseStatus)
+		if status == "" || status == codebuild.StatusTypeSucceeded {
+			continue
+		}
+		reason = fmt.Sprintf("%s in %s phase", status, aws.StringValue(phase.PhaseType))
+		for _, context := range phase.Contexts {
+			if message := aws.StringValue(context.Message); message != "" {
+				reason += ": " + message
+				break
+			}
+		}
+		break
+	}
+	return reason
+}
+
+// normalizes the status of a codebuild build
+func buildStatus(build *codebuild.Build) executor.Status {
+	switch aws.StringValue(build.BuildStatus) {
+	case codebuild.StatusTypeInProgress:
+		if phase := aws.StringValue(build.CurrentPhase); queuedPhases[phase] {
+			return executor.Status{State: executor.Queued, Reason: phase}
+		}
+		return executor.Status{State: executor.Running}
+	case codebuild.StatusTypeSucceeded:
+		return executor.Status{State: executor.Succeeded}
+	default:
+		return executor.Status{State: executor.Failed, Reason: failureReason(build)}
+	}
+}
+
+// gets the id of the current build of a build batch group
+func groupBuildID(group *codebuild.BuildGroup) (string, error) {
+	buildArn, err := arn.Parse(aws.StringValue(group.CurrentBuildSummary.Arn))
+	if err != nil {
+		return "", fmt.Errorf("invalid build arn %v", aws.StringValue(group.CurrentBuildSummary.Arn))
+	}
+	return strings.TrimPrefix(buildArn.Resource, "build/"), nil
+}
+
+// gets the status of the main build of a batch, the batch is queued while the launcher build runs
+func batchStatus(serviceClient *awsAPI, batchID string) (executor.Status, error) {
+	batchResult, err := serviceClient.cb.BatchGetBuildBatches(&codebuild.BatchGetBuildBatchesInput{Ids: []*string{aws.String(batchID)}})
+	if err != nil {
+		return executor.Status{}, fmt.Errorf("Error-BatchGetBuildBatches: %v", err)
+	}
+	if len(batchResult.BuildBatches) == 0 {
+		return executor.Status{}, fmt.Errorf("build batch %v not found", batchID)
+	}
+	batch := batchResult.BuildBatches[0]
+	for _, group := range batch.BuildGroups {
+		if aws.StringValue(group.Identifier) != mainBuildIdentifier || group.CurrentBuildSummary == nil {
+			continue
+		}
+		id, err := groupBuildID(group)
+		if err != nil {
+			return executor.Status{}, err
+		}
+		build, err := getBuild(serviceClient, id)
+		if err != nil {
+			return executor.Status{}, err
+		}
+		return buildStatus(build), nil
+	}
+	status := aws.StringValue(batch.BuildBatchStatus)
+	if status == codebuild.StatusTypeInProgress {
+		return executor.Status{State: executor.Queued, Reason: "waiting for the launcher build"}, nil
+	}
+	return executor.Status{State: executor.Failed, Reason: fmt.Sprintf("build batch %s before the build started", status)}, nil
+}
+
+// gets the value of an environment variable of a build
+func buildEnv(build *codebuild.Build, name string) string {
+	if build.Environment == nil {
+		return ""
+	}
+	for _, env := range build.Environment.EnvironmentVariables {
+		if aws.StringValue(env.Name) == name {
+			return aws.StringValue(env.Value)
+		}
+	}
+	return ""
+}
+
+// finds the latest build of the project started for the screwdriver build
+func latestProjectBuild(serviceClient *awsAPI, config map[string]interface{}) (*codebuild.Build, error) {
+	build, err := findProjectBuild(serviceClient, config)
+	if err == nil && build == nil {
+		buildID, _ := config["buildId"].(json.Number).Int64()
+		err = fmt.Errorf("no codebuild build found for build %d in project %s", buildID, getProjectName(config))
+	}
+	return build, err
+}
+
+// finds the latest build of the project started for the screwdriver build, nil if the recent builds have none
+func findProjectBuild(serviceClient *awsAPI, config map[string]interface{}) (*codebuild.Build, error) {
+	project := getProjectName(config)
+	buildID, _ := config["buildId"].(json.Number).Int64()
+	listResult, err := serviceClient.cb.ListBuildsForProject(&codebuild.ListBuildsForProjectInput{
+		ProjectName: aws.String(project),
+		SortOrder:   aws.String(codebuild.SortOrderTypeDescending),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-ListBuildsForProject: %v", err)
+	}
+	ids := listResult.Ids
+	if len(ids) > statusBuildsLimit {
+		ids = ids[:statusBuildsLimit]
+	}
+	if len(ids) > 0 {
+		buildsResult, err := serviceClient.cb.BatchGetBuilds(&codebuild.BatchGetBuildsInput{Ids: ids})
+		if err != nil {
+			return nil, fmt.Errorf("Error-BatchGetBuilds: %v", err)
+		}
+		for _, build := range buildsResult.Builds {
+			if buildEnv(build, "SDBUILDID") == fmt.Sprint(buildID) {
+				return build, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// gets the id of the batch a build belongs to, empty for single builds
+func buildBatchID(build *codebuild.Build) (string, error) {
+	batchArn := aws.StringValue(build.BuildBatchArn)
+	if batchArn == "" {
+		return "", nil
+	}
+	parsed, err := arn.Parse(batchArn)
+	if err != nil {
+		return "", fmt.Errorf("invalid build batch arn %v", batchArn)
+	}
+	return strings.TrimPrefix(parsed.Resource, "build-batch/"), nil
+}
+
+// Status gets the normalized state of the codebuild build running the screwdriver build
+func (e *AwsServerless) Status(config map[string]interface{}) (executor.Status, error) {
+	if id, _ := config["codebuildBuildId"].(string); id != "" {
+		build, err := getBuild(e.serviceClient, id)
+		if err != nil {
+			return executor.Status{}, err
+		}
+		return buildStatus(build), nil
+	}
+	if batchID, _ := config["codebuildBatchId"].(string); batchID != "" {
+		return batchStatus(e.serviceClient, batchID)
+	}
+
+	build, err := latestProjectBuild(e.serviceClient, config)
+	if err != nil {
+		return executor.Status{}, err
+	}
+	batchID, err := buildBatchID(build)
+	if err != nil {
+		return executor.Status{}, err
+	}
+	if batchID != "" {
+		return batchStatus(e.serviceClient, batchID)
+	}
+	return buildStatus(build), nil
+}
@@ -0,0 +1,70 @@
+package sls
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/executor"
+)
+
+func TestBuildStatus(t *testing.T) {
+	queued := buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "QUEUED", "PROVISIONING")
+	queued.CurrentPhase = aws.String("PROVISIONING")
+	running := buildWithPhases("p:1", "IN_PROGRESS", "SUBMITTED", "BUILD")
+	running.CurrentPhase = aws.String("BUILD")
+	failed := buildWithPhases("p:1", "FAILED", "SUBMITTED", "BUILD")
+	failed.Phases[0].PhaseStatus = aws.String("SUCCEEDED")
+	failed.Phases[1].PhaseStatus = aws.String("FAILED")
+	failed.Phases[1].Contexts = []*codebuild.PhaseContext{{StatusCode: aws.String("COMMAND_EXECUTION_ERROR"), Message: aws.String("Error while executing command: exit status 1")}}
+
+	assert.Equal(t, executor.Status{State: executor.Queued, Reason: "PROVISIONING"}, buildStatus(queued))
+	assert.Equal(t, executor.Status{State: executor.Running}, buildStatus(running))
+	assert.Equal(t, executor.Status{State: executor.Succeeded}, buildStatus(buildWithPhases("p:1", "SUCCEEDED")))
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "FAILED in BUILD phase: Error while executing command: exit status 1"}, buildStatus(failed))
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "STOPPED"}, buildStatus(buildWithPhases("p:1", "STOPPED")))
+}
+
+func TestStatus(t *testing.T) {
+	mockServiceClient, mockCBAPI, _ := setup()
+	e := &AwsServerless{serviceClient: mockServiceClient}
+
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:abc"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{buildWithPhases("deploy-123:abc", "SUCCEEDED")}}, nil)
+	config := getTestConfig()
+	config["codebuildBuildId"] = "deploy-123:abc"
+	status, err := e.Status(config)
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Succeeded, status.State)
+
+	mockCBAPI.On("BatchGetBuildBatches", &codebuild.BatchGetBuildBatchesInput{Ids: aws.StringSlice([]string{"deploy-123:pending"})}).
+		Return(&codebuild.BatchGetBuildBatchesOutput{BuildBatches: []*codebuild.BuildBatch{{
+			BuildBatchStatus: aws.String("IN_PROGRESS"),
+			BuildGroups:      []*codebuild.BuildGroup{{Identifier: aws.String("main")}},
+		}}}, nil)
+	config = getTestConfig()
+	config["codebuildBatchId"] = "deploy-123:pending"
+	status, err = e.Status(config)
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Status{State: executor.Queued, Reason: "waiting for the launcher build"}, status)
+
+	// without a known codebuild id the latest project build of the screwdriver build is used
+	other := buildWithPhases("deploy-123:new", "IN_PROGRESS")
+	other.Environment = &codebuild.ProjectEnvironment{EnvironmentVariables: []*codebuild.EnvironmentVariable{{Name: aws.String("SDBUILDID"), Value: aws.String("1235")}}}
+	current := buildWithPhases("deploy-123:old", "FAILED")
+	current.Environment = &codebuild.ProjectEnvironment{EnvironmentVariables: []*codebuild.EnvironmentVariable{{Name: aws.String("SDBUILDID"), Value: aws.String("1234")}}}
+	mockCBAPI.On("ListBuildsForProject", &codebuild.ListBuildsForProjectInput{ProjectName: aws.String("deploy-123"), SortOrder: aws.String("DESCENDING")}).
+		Return(&codebuild.ListBuildsForProjectOutput{Ids: aws.StringSlice([]string{"deploy-123:new", "deploy-123:old"})}, nil)
+	mockCBAPI.On("BatchGetBuilds", &codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"deploy-123:new", "deploy-123:old"})}).
+		Return(&codebuild.BatchGetBuildsOutput{Builds: []*codebuild.Build{other, current}}, nil)
+	status, err = e.Status(getTestConfig())
+	assert.Nil(t, err)
+	assert.Equal(t, executor.Status{State: executor.Failed, Reason: "FAILED"}, status)
+
+	config = getTestConfig()
+	config["buildId"] = config["jobId"]
+	_, err = e.Status(config)
+	assert.EqualError(t, err, "no codebuild build found for build 123 in project deploy-123")
+}
@@ -0,0 +1,22 @@
+// Package executor defines the normalized build states reported by all executors
+package executor
+
+// State is the normalized state of a build
+type State string
+
+const (
+	// Queued builds are accepted but do not run the build steps yet
+	Queued State = "QUEUED"
+	// Running builds run the build steps
+	Running State = "RUNNING"
+	// Succeeded builds finished successfully
+	Succeeded State = "SUCCEEDED"
+	// Failed builds failed, timed out or were stopped
+	Failed State = "FAILED"
+)
+
+// Status is the normalized state of a build with the reason reported by the executor
+type Status struct {
+	State  State  `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
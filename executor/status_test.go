@@ -0,0 +1,17 @@
+package executor
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusJSON(t *testing.T) {
+	out, err := json.Marshal(Status{State: Failed, Reason: "pod evicted"})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"state": "FAILED", "reason": "pod evicted"}`, string(out))
+
+	out, _ = json.Marshal(Status{State: Running})
+	assert.JSONEq(t, `{"state": "RUNNING"}`, string(out))
+}
@@ -0,0 +1,104 @@
+// Package failover retries build submissions in the fallback regions of a provider when its region is unavailable
+package failover
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+// Field lists the fallback regions of the provider, each a region name or an object with the
+// region and the vpc, bucket and clusterName of the build in that region
+const Field = "fallbackRegions"
+
+// outageCodes are the aws error codes signaling that a region cannot take builds right now
+var outageCodes = []string{
+	"ServiceUnavailable",
+	"ServiceUnavailableException",
+	"InternalFailure",
+	"InternalServerException",
+	"ServerException",
+	"RequestLimitExceeded",
+	"InsufficientCapacity",
+	"InsufficientCapacityException",
+	"AccountLimitExceededException",
+	"ResourceLimitExceeded",
+}
+
+// IsRegionalOutage returns true if the start error signals a region level outage or a lack of capacity.
+// Executors flatten aws errors into their messages, so the error codes are matched in the message.
+func IsRegionalOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, code := range outageCodes {
+		if strings.Contains(msg, code+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Fallback is a region a build is retried in with the provider fields overridden for it
+type Fallback struct {
+	Region      string
+	VPC         map[string]interface{}
+	Bucket      string
+	ClusterName string
+}
+
+// Regions returns the fallback regions of the provider, which must be in the partition of the build region
+func Regions(provider map[string]interface{}, buildRegion string) ([]Fallback, error) {
+	values, ok := provider[Field]
+	if !ok || values == nil {
+		return nil, nil
+	}
+	list, ok := values.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array", Field)
+	}
+	partition := awsconfig.Partition(buildRegion)
+	fallbacks := make([]Fallback, 0, len(list))
+	for i, value := range list {
+		var f Fallback
+		switch v := value.(type) {
+		case string:
+			f.Region = v
+		case map[string]interface{}:
+			f.Region, _ = v["region"].(string)
+			f.VPC, _ = v["vpc"].(map[string]interface{})
+			f.Bucket, _ = v["bucket"].(string)
+			f.ClusterName, _ = v["clusterName"].(string)
+		default:
+			return nil, fmt.Errorf("%s[%d] must be a region or an object", Field, i)
+		}
+		if err := awsconfig.ValidateRegion(f.Region); err != nil {
+			return nil, fmt.Errorf("%s[%d]: %v", Field, i, err)
+		}
+		if p := awsconfig.Partition(f.Region); p != partition {
+			return nil, fmt.Errorf("%s[%d] %s is in partition %s, not %s of region %s", Field, i, f.Region, p, partition, buildRegion)
+		}
+		fallbacks = append(fallbacks, f)
+	}
+	return fallbacks, nil
+}
+
+// Apply moves the build to the fallback region, the bucket of the region is derived from the region unless set
+func (f Fallback) Apply(buildConfig map[string]interface{}) {
+	provider := buildConfig["provider"].(map[string]interface{})
+	provider["buildRegion"] = f.Region
+	if f.VPC != nil {
+		provider["vpc"] = f.VPC
+	}
+	if f.Bucket != "" {
+		provider["bucket"] = f.Bucket
+	} else {
+		delete(provider, "bucket")
+	}
+	if f.ClusterName != "" {
+		provider["clusterName"] = f.ClusterName
+		buildConfig["clusterName"] = f.ClusterName
+	}
+}
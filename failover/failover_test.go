@@ -0,0 +1,67 @@
+package failover
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRegionalOutage(t *testing.T) {
+	assert.True(t, IsRegionalOutage(errors.New("Got error building project: ServiceUnavailableException: Service is unavailable")))
+	assert.True(t, IsRegionalOutage(errors.New("Error-CreateProject: AccountLimitExceededException: Cannot have more than 5000 projects")))
+	assert.True(t, IsRegionalOutage(errors.New("Error-DescribeCluster: ServerException: internal error")))
+	assert.False(t, IsRegionalOutage(errors.New("Error-CreateProject: InvalidInputException: Invalid role")))
+	assert.False(t, IsRegionalOutage(nil))
+}
+
+func TestRegions(t *testing.T) {
+	vpc := map[string]interface{}{"vpcId": "vpc-2"}
+	fallbacks, err := Regions(map[string]interface{}{
+		Field: []interface{}{"us-east-1", map[string]interface{}{"region": "us-east-2", "vpc": vpc, "bucket": "sd-builds-use2", "clusterName": "sd-use2"}},
+	}, "us-west-2")
+	assert.Nil(t, err)
+	assert.Equal(t, []Fallback{
+		{Region: "us-east-1"},
+		{Region: "us-east-2", VPC: vpc, Bucket: "sd-builds-use2", ClusterName: "sd-use2"},
+	}, fallbacks)
+
+	fallbacks, err = Regions(map[string]interface{}{}, "us-west-2")
+	assert.Nil(t, err)
+	assert.Nil(t, fallbacks)
+
+	testCases := map[string]interface{}{
+		"fallbackRegions must be an array":                                                         "us-east-1",
+		"fallbackRegions[0] must be a region or an object":                                         []interface{}{1},
+		`fallbackRegions[0]: region "mars-1" does not belong to a known aws partition`:             []interface{}{"mars-1"},
+		"fallbackRegions[0] us-gov-west-1 is in partition aws-us-gov, not aws of region us-west-2": []interface{}{"us-gov-west-1"},
+	}
+	for expected, value := range testCases {
+		_, err := Regions(map[string]interface{}{Field: value}, "us-west-2")
+		assert.EqualError(t, err, expected)
+	}
+}
+
+func TestApply(t *testing.T) {
+	buildConfig := map[string]interface{}{
+		"clusterName": "sd-usw2",
+		"provider":    map[string]interface{}{"region": "us-west-2", "bucket": "sd-builds-usw2", "vpc": map[string]interface{}{"vpcId": "vpc-1"}},
+	}
+	Fallback{Region: "us-east-1"}.Apply(buildConfig)
+	assert.Equal(t, map[string]interface{}{
+		"clusterName": "sd-usw2",
+		"provider":    map[string]interface{}{"region": "us-west-2", "buildRegion": "us-east-1", "vpc": map[string]interface{}{"vpcId": "vpc-1"}},
+	}, buildConfig)
+
+	Fallback{Region: "us-east-2", VPC: map[string]interface{}{"vpcId": "vpc-2"}, Bucket: "sd-builds-use2", ClusterName: "sd-use2"}.Apply(buildConfig)
+	assert.Equal(t, map[string]interface{}{
+		"clusterName": "sd-use2",
+		"provider": map[string]interface{}{
+			"region":      "us-west-2",
+			"buildRegion": "us-east-2",
+			"bucket":      "sd-builds-use2",
+			"clusterName": "sd-use2",
+			"vpc":         map[string]interface{}{"vpcId": "vpc-2"},
+		},
+	}, buildConfig)
+}
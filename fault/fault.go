@@ -0,0 +1,286 @@
+// Package fault injects throttling, timeouts and errors into the aws, kubernetes and Screwdriver api calls of the
+// consumer as configured by SD_FAULTS, so the retry, dead letter and reconcile paths can be exercised in staging
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+)
+
+// faultsEnv holds a json list of rules, e.g. [{"target": "aws", "service": "codebuild", "operation": "StartBuild", "fault": "throttle", "rate": 0.2}]
+const faultsEnv = "SD_FAULTS"
+
+// Kind is the kind of an injected fault
+type Kind string
+
+const (
+	// Throttle fails calls like a rate limited api, with a ThrottlingException or a 429
+	Throttle Kind = "throttle"
+	// Timeout fails calls with a timeout after the delay of the rule
+	Timeout Kind = "timeout"
+	// Error fails calls with an internal error of the service, with an InternalFailure or a 503
+	Error Kind = "error"
+)
+
+// targets of the rules
+const (
+	AWS         = "aws"
+	Kubernetes  = "k8s"
+	Screwdriver = "sd"
+)
+
+// Rule fails a share of the calls it matches
+type Rule struct {
+	// Target is aws, k8s or sd, all targets when empty
+	Target string `json:"target"`
+	// Service is the aws service like codebuild, or a part of the url path of k8s and sd calls like /pods, all when empty
+	Service string `json:"service"`
+	// Operation is the aws operation like StartBuild or the http method of k8s and sd calls, all when empty
+	Operation string `json:"operation"`
+	Fault     Kind   `json:"fault"`
+	// Rate is the share of the matching calls failing, from 0 to 1
+	Rate float64 `json:"rate"`
+	// Delay is waited before a timeout is reported, like 10s
+	Delay string `json:"delay"`
+
+	delay time.Duration
+}
+
+// Injector fails the calls matching its rules
+type Injector struct {
+	rules  []Rule
+	random func() float64
+	sleep  func(ctx context.Context, d time.Duration)
+}
+
+// injected faults by target and kind
+var faultsInjected = metrics.NewCounter("sd_aws_consumer_faults_injected_total", "Faults injected into api calls by target and kind")
+
+// Parse returns the injector of a json list of rules
+func Parse(value string) (*Injector, error) {
+	var rules []Rule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("Got error parsing %s: %v", faultsEnv, err)
+	}
+	for i := range rules {
+		if err := rules[i].validate(i); err != nil {
+			return nil, err
+		}
+	}
+	return newInjector(rules), nil
+}
+
+func newInjector(rules []Rule) *Injector {
+	return &Injector{rules: rules, random: rand.Float64, sleep: sleep}
+}
+
+// validates the rule at index i of the list and parses its delay
+func (rule *Rule) validate(i int) error {
+	switch rule.Target {
+	case "", AWS, Kubernetes, Screwdriver:
+	default:
+		return fmt.Errorf("%s rule %d: unknown target %q", faultsEnv, i, rule.Target)
+	}
+	switch rule.Fault {
+	case Throttle, Timeout, Error:
+	default:
+		return fmt.Errorf("%s rule %d: unknown fault %q", faultsEnv, i, rule.Fault)
+	}
+	if rule.Rate < 0 || rule.Rate > 1 {
+		return fmt.Errorf("%s rule %d: rate must be between 0 and 1", faultsEnv, i)
+	}
+	if rule.Delay != "" {
+		delay, err := time.ParseDuration(rule.Delay)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("%s rule %d: invalid delay %q", faultsEnv, i, rule.Delay)
+		}
+		rule.delay = delay
+	}
+	return nil
+}
+
+// FromEnv returns the injector of SD_FAULTS, nil when no faults are injected. Invalid rules are logged and
+// skipped, so a broken fault config never keeps the clients of the consumer from being created.
+func FromEnv() *Injector {
+	value := strings.TrimSpace(os.Getenv(faultsEnv))
+	if value == "" {
+		return nil
+	}
+	var rules []Rule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		log.Printf("Ignoring %s: Got error parsing it: %v", faultsEnv, err)
+		return nil
+	}
+	valid := make([]Rule, 0, len(rules))
+	for i := range rules {
+		if err := rules[i].validate(i); err != nil {
+			log.Printf("Ignoring %v", err)
+			continue
+		}
+		valid = append(valid, rules[i])
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return newInjector(valid)
+}
+
+// waits for the duration or until the context is done
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// Inject returns the rule failing a call, nil when the call goes through
+func (i *Injector) Inject(target, service, operation string) *Rule {
+	if i == nil {
+		return nil
+	}
+	for n := range i.rules {
+		rule := &i.rules[n]
+		if rule.Target != "" && rule.Target != target {
+			continue
+		}
+		if rule.Service != "" && !matchService(target, rule.Service, service) {
+			continue
+		}
+		if rule.Operation != "" && !strings.EqualFold(rule.Operation, operation) {
+			continue
+		}
+		if i.random() >= rule.Rate {
+			continue
+		}
+		log.Printf("Injecting %s fault into %s call %s %s", rule.Fault, target, operation, service)
+		faultsInjected.Inc(map[string]string{"target": target, "fault": string(rule.Fault)})
+		return rule
+	}
+	return nil
+}
+
+// aws services match by name, k8s and sd calls by a part of their path
+func matchService(target, ruleService, service string) bool {
+	if target == AWS {
+		return strings.EqualFold(ruleService, service)
+	}
+	return strings.Contains(service, ruleService)
+}
+
+// timeoutError is the error of injected timeouts, which are temporary like network timeouts
+type timeoutError struct {
+	delay time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("injected fault: timeout after %v", e.delay)
+}
+
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// fails the aws request if a rule matches it, returns true when it was failed
+func (i *Injector) failRequest(r *request.Request) bool {
+	operation := ""
+	if r.Operation != nil {
+		operation = r.Operation.Name
+	}
+	rule := i.Inject(AWS, r.ClientInfo.ServiceName, operation)
+	if rule == nil {
+		return false
+	}
+	status := http.StatusBadRequest
+	switch rule.Fault {
+	case Throttle:
+		r.Error = awserr.New("ThrottlingException", "Rate exceeded (injected fault)", nil)
+	case Timeout:
+		i.sleep(r.Context(), rule.delay)
+		status = 0
+		r.Error = awserr.New(request.ErrCodeRequestError, "send request failed", &timeoutError{delay: rule.delay})
+	case Error:
+		status = http.StatusInternalServerError
+		r.Error = awserr.New("InternalFailure", "Internal failure (injected fault)", nil)
+	}
+	r.HTTPResponse = &http.Response{StatusCode: status, Header: http.Header{}, Body: http.NoBody}
+	return true
+}
+
+// InjectSession fails the aws api calls of the session matching the rules, they are retried like real failures
+func (i *Injector) InjectSession(sess *session.Session) {
+	sess.Handlers.Send.Swap(corehandlers.SendHandler.Name, request.NamedHandler{
+		Name: corehandlers.SendHandler.Name,
+		Fn: func(r *request.Request) {
+			if !i.failRequest(r) {
+				corehandlers.SendHandler.Fn(r)
+			}
+		},
+	})
+}
+
+// transport injecting faults into the http calls of a target
+type transport struct {
+	injector *Injector
+	target   string
+	next     http.RoundTripper
+}
+
+// RoundTrip fails the request if a rule matches it, sends it otherwise
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rule := t.injector.Inject(t.target, req.URL.Path, req.Method)
+	if rule == nil {
+		return t.next.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	switch rule.Fault {
+	case Timeout:
+		t.injector.sleep(req.Context(), rule.delay)
+		return nil, &timeoutError{delay: rule.delay}
+	case Throttle:
+		res := faultResponse(req, http.StatusTooManyRequests, "Too Many Requests (injected fault)")
+		res.Header.Set("Retry-After", "1")
+		return res, nil
+	}
+	return faultResponse(req, http.StatusServiceUnavailable, "Service Unavailable (injected fault)"), nil
+}
+
+// gets the response of an injected fault
+func faultResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// WrapTransport returns the transport failing the calls of the target matching the rules
+func (i *Injector) WrapTransport(target string, next http.RoundTripper) http.RoundTripper {
+	if i == nil {
+		return next
+	}
+	return &transport{injector: i, target: target, next: next}
+}
@@ -0,0 +1,189 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/codebuild"
+	"github.com/stretchr/testify/assert"
+)
+
+// returns the injector of the rules failing every matching call without waiting
+func testInjector(t *testing.T, rules string) *Injector {
+	injector, err := Parse(rules)
+	assert.Nil(t, err)
+	injector.random = func() float64 { return 0 }
+	injector.sleep = func(ctx context.Context, d time.Duration) {}
+	return injector
+}
+
+func TestParse(t *testing.T) {
+	injector, err := Parse(`[{"target": "aws", "service": "codebuild", "fault": "timeout", "rate": 0.5, "delay": "2s"}]`)
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, injector.rules[0].delay)
+
+	for value, expected := range map[string]string{
+		`{}`:                                    "Got error parsing SD_FAULTS: json: cannot unmarshal object into Go value of type []fault.Rule",
+		`[{"target": "ecs", "fault": "error"}]`: `SD_FAULTS rule 0: unknown target "ecs"`,
+		`[{"fault": "crash"}]`:                  `SD_FAULTS rule 0: unknown fault "crash"`,
+		`[{"fault": "error", "rate": 2}]`:       "SD_FAULTS rule 0: rate must be between 0 and 1",
+		`[{"fault": "timeout", "delay": "1x"}]`: `SD_FAULTS rule 0: invalid delay "1x"`,
+	} {
+		_, err := Parse(value)
+		assert.EqualError(t, err, expected, value)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("SD_FAULTS", "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv("SD_FAULTS", `[{"fault": "throttle", "rate": 1}]`)
+	injector := FromEnv()
+	assert.Len(t, injector.rules, 1)
+
+	// invalid rules are skipped
+	t.Setenv("SD_FAULTS", `[{"fault": "crash"}, {"fault": "timeout", "delay": "2s", "rate": 1}]`)
+	injector = FromEnv()
+	assert.Len(t, injector.rules, 1)
+	assert.Equal(t, 2*time.Second, injector.rules[0].delay)
+
+	t.Setenv("SD_FAULTS", `{}`)
+	assert.Nil(t, FromEnv())
+	t.Setenv("SD_FAULTS", `[{"target": "ecs", "fault": "error"}]`)
+	assert.Nil(t, FromEnv())
+}
+
+func TestInject(t *testing.T) {
+	injector := testInjector(t, `[
+		{"target": "aws", "service": "codebuild", "operation": "StartBuild", "fault": "throttle", "rate": 0.5},
+		{"target": "k8s", "service": "/pods", "operation": "POST", "fault": "error", "rate": 1},
+		{"target": "sd", "fault": "timeout", "rate": 0}
+	]`)
+	assert.Equal(t, Throttle, injector.Inject(AWS, "codebuild", "StartBuild").Fault)
+	assert.Nil(t, injector.Inject(AWS, "codebuild", "BatchGetBuilds"))
+	assert.Nil(t, injector.Inject(AWS, "s3", "StartBuild"))
+	assert.Equal(t, Error, injector.Inject(Kubernetes, "/api/v1/namespaces/sd/pods", "post").Fault)
+	assert.Nil(t, injector.Inject(Kubernetes, "/api/v1/namespaces/sd/pods", "GET"))
+	// a rate of 0 never fails
+	assert.Nil(t, injector.Inject(Screwdriver, "/v4/builds/1", "PUT"))
+
+	// calls fail with the rate of the rule
+	injector.random = func() float64 { return 0.6 }
+	assert.Nil(t, injector.Inject(AWS, "codebuild", "StartBuild"))
+
+	var disabled *Injector
+	assert.Nil(t, disabled.Inject(AWS, "codebuild", "StartBuild"))
+}
+
+// returns a codebuild client of a session sending requests to the server
+func testCodeBuild(t *testing.T, injector *Injector, url string) *codebuild.CodeBuild {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(url),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	assert.Nil(t, err)
+	sess.Config.Retryer = client.DefaultRetryer{
+		NumMaxRetries:    2,
+		MinRetryDelay:    time.Millisecond,
+		MaxRetryDelay:    time.Millisecond,
+		MinThrottleDelay: time.Millisecond,
+		MaxThrottleDelay: time.Millisecond,
+	}
+	injector.InjectSession(sess)
+	return codebuild.New(sess)
+}
+
+func TestInjectSession(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"builds": []}`))
+	}))
+	defer server.Close()
+
+	for fault, code := range map[string]string{"throttle": "ThrottlingException", "error": "InternalFailure", "timeout": "RequestError"} {
+		injector := testInjector(t, `[{"target": "aws", "service": "codebuild", "operation": "StartBuild", "fault": "`+fault+`", "rate": 1}]`)
+		builds := testCodeBuild(t, injector, server.URL)
+
+		req, _ := builds.StartBuildRequest(&codebuild.StartBuildInput{ProjectName: aws.String("main-123")})
+		err := req.Send()
+		var aerr awserr.Error
+		assert.True(t, errors.As(err, &aerr), fault)
+		assert.Equal(t, code, aerr.Code(), fault)
+		// injected faults are retried like real ones
+		assert.Equal(t, 2, req.RetryCount, fault)
+		if fault == "timeout" {
+			var netErr net.Error
+			assert.True(t, errors.As(aerr.OrigErr(), &netErr))
+			assert.True(t, netErr.Timeout())
+		}
+
+		// other calls go through
+		_, err = builds.BatchGetBuilds(&codebuild.BatchGetBuildsInput{Ids: aws.StringSlice([]string{"main-123:1"})})
+		assert.Nil(t, err, fault)
+	}
+	assert.Equal(t, 3, calls)
+}
+
+func TestWrapTransport(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	injector := testInjector(t, `[
+		{"target": "sd", "service": "/v4/builds", "operation": "PUT", "fault": "throttle", "rate": 1},
+		{"target": "sd", "service": "/v4/events", "fault": "error", "rate": 1},
+		{"target": "sd", "service": "/v4/pipelines", "fault": "timeout", "rate": 1, "delay": "1s"}
+	]`)
+	client := &http.Client{Transport: injector.WrapTransport(Screwdriver, http.DefaultTransport)}
+
+	send := func(method, path string) (*http.Response, error) {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		return client.Do(req)
+	}
+	res, err := send("PUT", "/v4/builds/1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("Retry-After"))
+
+	res, err = send("GET", "/v4/events/2")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, "", res.Header.Get("Retry-After"))
+
+	_, err = send("GET", "/v4/pipelines/3")
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+
+	res, err = send("GET", "/v4/builds/1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 1, calls)
+
+	var disabled *Injector
+	assert.Equal(t, http.DefaultTransport, disabled.WrapTransport(Screwdriver, http.DefaultTransport))
+}
+
+func TestSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	sleep(ctx, time.Minute)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
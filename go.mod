@@ -7,6 +7,7 @@ require (
 	github.com/aws/aws-sdk-go v1.43.1
 	github.com/hashicorp/go-retryablehttp v0.7.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/sync v0.1.0
 	k8s.io/api v0.19.0
 	k8s.io/apimachinery v0.19.0
 	k8s.io/client-go v0.19.0
@@ -0,0 +1,100 @@
+// Package heartbeat lets the launcher of a build report that it initialized by posting to the heartbeat endpoint
+// of the consumer, so builds whose compute started but whose launcher never initialized can be detected.
+// Each build gets a token signed with SD_HEARTBEAT_SECRET, the endpoint only accepts heartbeats carrying it.
+package heartbeat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+)
+
+const (
+	urlEnv     = "SD_HEARTBEAT_URL"
+	secretEnv  = "SD_HEARTBEAT_SECRET"
+	timeoutEnv = "SD_HEARTBEAT_TIMEOUT_SECS"
+
+	// defaultTimeout is how long after the start a build without heartbeat is failed
+	defaultTimeout = 10 * time.Minute
+
+	// URLVar is the build environment variable holding the url the launcher posts its heartbeat to
+	URLVar = "SD_HEARTBEAT_URL"
+	// TokenVar is the build environment variable holding the token of the heartbeat, sent as bearer token
+	TokenVar = "SD_HEARTBEAT_TOKEN"
+	// ArchitectureParam is the query parameter of the heartbeat url carrying the architecture of matrix builds
+	ArchitectureParam = "architecture"
+)
+
+// Config is the heartbeat endpoint with the secret signing the tokens of builds
+type Config struct {
+	URL     string
+	Timeout time.Duration
+	secret  []byte
+}
+
+// Beat is the heartbeat the launcher posts once it initialized
+type Beat struct {
+	BuildID  int    `json:"buildId"`
+	Hostname string `json:"hostname"`
+}
+
+// FromEnv returns the heartbeat endpoint of SD_HEARTBEAT_URL signed with SD_HEARTBEAT_SECRET, nil when heartbeats are disabled
+func FromEnv() *Config {
+	url, secret := os.Getenv(urlEnv), os.Getenv(secretEnv)
+	if url == "" || secret == "" {
+		return nil
+	}
+	c := &Config{URL: url, Timeout: defaultTimeout, secret: []byte(secret)}
+	if secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv(timeoutEnv))); err == nil && secs > 0 {
+		c.Timeout = time.Duration(secs) * time.Second
+	}
+	return c
+}
+
+// Token gets the heartbeat token of the build of the architecture, every architecture of a matrix build has its own
+func (c *Config) Token(buildID int, arch string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(matrix.Key(buildID, arch)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if the token is the heartbeat token of the build of the architecture
+func (c *Config) Verify(buildID int, arch string, token string) bool {
+	return hmac.Equal([]byte(c.Token(buildID, arch)), []byte(token))
+}
+
+// Env gets the environment variables telling the launcher of the build where and how to post its heartbeat,
+// the url of matrix builds carries their architecture so the launcher posts the same heartbeat for any of them
+func (c *Config) Env(buildID int, arch string) map[string]string {
+	heartbeatURL := c.URL
+	if arch != "" {
+		if u, err := url.Parse(c.URL); err == nil {
+			query := u.Query()
+			query.Set(ArchitectureParam, arch)
+			u.RawQuery = query.Encode()
+			heartbeatURL = u.String()
+		}
+	}
+	return map[string]string{URLVar: heartbeatURL, TokenVar: c.Token(buildID, arch)}
+}
+
+// Decode decodes a heartbeat posted by a launcher
+func Decode(body []byte) (*Beat, error) {
+	var beat Beat
+	if err := json.Unmarshal(body, &beat); err != nil {
+		return nil, fmt.Errorf("Got error decoding heartbeat: %v", err)
+	}
+	if beat.BuildID <= 0 {
+		return nil, fmt.Errorf("heartbeat buildId is required")
+	}
+	return &beat, nil
+}
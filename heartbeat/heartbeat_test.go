@@ -0,0 +1,51 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(urlEnv, "https://heartbeat.lambda-url.us-west-2.on.aws/")
+	t.Setenv(secretEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(secretEnv, "s3cret")
+	c := FromEnv()
+	assert.Equal(t, "https://heartbeat.lambda-url.us-west-2.on.aws/", c.URL)
+	assert.Equal(t, 10*time.Minute, c.Timeout)
+
+	t.Setenv(timeoutEnv, "300")
+	assert.Equal(t, 5*time.Minute, FromEnv().Timeout)
+}
+
+func TestToken(t *testing.T) {
+	c := &Config{URL: "https://heartbeat", secret: []byte("s3cret")}
+	token := c.Token(1234, "")
+	assert.Len(t, token, 64)
+	assert.True(t, c.Verify(1234, "", token))
+	assert.False(t, c.Verify(1235, "", token))
+	assert.False(t, c.Verify(1234, "", ""))
+	assert.False(t, c.Verify(1234, "arm64", token))
+	assert.False(t, (&Config{secret: []byte("other")}).Verify(1234, "", token))
+
+	assert.Equal(t, map[string]string{"SD_HEARTBEAT_URL": "https://heartbeat", "SD_HEARTBEAT_TOKEN": token}, c.Env(1234, ""))
+
+	armToken := c.Token(1234, "arm64")
+	assert.NotEqual(t, token, armToken)
+	assert.True(t, c.Verify(1234, "arm64", armToken))
+	assert.Equal(t, map[string]string{"SD_HEARTBEAT_URL": "https://heartbeat?architecture=arm64", "SD_HEARTBEAT_TOKEN": armToken}, c.Env(1234, "arm64"))
+}
+
+func TestDecode(t *testing.T) {
+	beat, err := Decode([]byte(`{"buildId": 1234, "hostname": "ip-10-0-1-12"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &Beat{BuildID: 1234, Hostname: "ip-10-0-1-12"}, beat)
+
+	_, err = Decode([]byte(`{"hostname": "ip-10-0-1-12"}`))
+	assert.EqualError(t, err, "heartbeat buildId is required")
+	_, err = Decode([]byte(`alive`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+// Package image normalizes container image references and rewrites them to registry mirrors
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	// prefix of images managed by CodeBuild, they are not hosted on a registry
+	codebuildImagePrefix = "aws/codebuild/"
+	// rewritesEnv holds the json list of rewrite rules
+	rewritesEnv = "SD_IMAGE_REWRITES"
+)
+
+// splits an image reference into its repository and its tag or digest suffix (":tag", "@sha256:...")
+func split(image string) (string, string) {
+	repository := image
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository = repository[:i]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return repository, image[len(repository):]
+}
+
+// resolves the default registry of a repository
+func normalizeRepository(repository string) string {
+	if strings.HasPrefix(repository, codebuildImagePrefix) {
+		return repository
+	}
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 1 {
+		return "docker.io/library/" + repository
+	}
+	if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return "docker.io/" + repository
+	}
+	return repository
+}
+
+// Repository gets the repository of an image reference with the default registry resolved, without tag or digest
+func Repository(image string) string {
+	repository, _ := split(image)
+	return normalizeRepository(repository)
+}
+
+// Normalize gets the image reference with the default registry resolved, keeping the tag or digest
+func Normalize(image string) string {
+	repository, suffix := split(image)
+	return normalizeRepository(repository) + suffix
+}
+
+// matches ECR registry hosts, capturing the registry id and region
+var ecrHostPattern = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ECRImage is an image reference hosted on an ECR registry
+type ECRImage struct {
+	RegistryID string
+	Region     string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseECR parses an ECR image reference, a reference without tag or digest gets the latest tag
+func ParseECR(image string) (*ECRImage, bool) {
+	repository, suffix := split(image)
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	matches := ecrHostPattern.FindStringSubmatch(parts[0])
+	if matches == nil {
+		return nil, false
+	}
+	ecrImage := &ECRImage{RegistryID: matches[1], Region: matches[2], Repository: parts[1]}
+	switch {
+	case strings.HasPrefix(suffix, "@"):
+		ecrImage.Digest = suffix[1:]
+	case strings.HasPrefix(suffix, ":"):
+		ecrImage.Tag = suffix[1:]
+	default:
+		ecrImage.Tag = "latest"
+	}
+	return ecrImage, true
+}
+
+// Rule rewrites images whose normalized reference starts with Prefix to Replacement,
+// e.g. docker.io/ to {accountId}.dkr.ecr.{region}.amazonaws.com/docker-hub/ for an ECR pull-through cache
+type Rule struct {
+	Prefix      string `json:"prefix"`
+	Replacement string `json:"replacement"`
+}
+
+// Rewriter rewrites images with the first matching rule
+type Rewriter struct {
+	Rules []Rule
+}
+
+// RewriterFromEnv returns the rewriter configured by SD_IMAGE_REWRITES, nil when no rules are set
+func RewriterFromEnv() (*Rewriter, error) {
+	value := os.Getenv(rewritesEnv)
+	if value == "" {
+		return nil, nil
+	}
+	var rules []Rule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("Got error parsing %s: %v", rewritesEnv, err)
+	}
+	return &Rewriter{Rules: rules}, nil
+}
+
+// Rewrite returns the rewritten image, or the image unchanged when no rule matches.
+// The {region} and {accountId} placeholders of the replacement are filled in.
+func (r *Rewriter) Rewrite(image string, region string, accountID string) string {
+	if r == nil || image == "" || strings.HasPrefix(image, codebuildImagePrefix) {
+		return image
+	}
+	normalized := Normalize(image)
+	for _, rule := range r.Rules {
+		if rule.Prefix == "" || !strings.HasPrefix(normalized, rule.Prefix) {
+			continue
+		}
+		replacement := strings.NewReplacer("{region}", region, "{accountId}", accountID).Replace(rule.Replacement)
+		return replacement + strings.TrimPrefix(normalized, rule.Prefix)
+	}
+	return image
+}
+
+// RewriteBuildConfig rewrites the build container and launcher image of a build config
+func (r *Rewriter) RewriteBuildConfig(buildConfig map[string]interface{}, region string) {
+	if r == nil {
+		return
+	}
+	provider, _ := buildConfig["provider"].(map[string]interface{})
+	var accountID string
+	if provider["accountId"] != nil {
+		accountID = fmt.Sprint(provider["accountId"])
+	}
+	if container, ok := buildConfig["container"].(string); ok {
+		if rewritten := r.Rewrite(container, region, accountID); rewritten != container {
+			log.Printf("Rewriting container image %v to %v", container, rewritten)
+			buildConfig["container"] = rewritten
+		}
+	}
+	if launcherImage, ok := provider["launcherImage"].(string); ok {
+		if rewritten := r.Rewrite(launcherImage, region, accountID); rewritten != launcherImage {
+			log.Printf("Rewriting launcher image %v to %v", launcherImage, rewritten)
+			provider["launcherImage"] = rewritten
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package image
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepository(t *testing.T) {
+	tests := map[string]string{
+		"node:12":                                 "docker.io/library/node",
+		"screwdrivercd/launcher:v6.0.1":           "docker.io/screwdrivercd/launcher",
+		"aws/codebuild/standard:5.0":              "aws/codebuild/standard",
+		"localhost:5000/node:12":                  "localhost:5000/node",
+		"registry.example.com:443/a/b@sha256:abc": "registry.example.com:443/a/b",
+		"111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12": "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub",
+	}
+	for image, expected := range tests {
+		assert.Equal(t, expected, Repository(image), image)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"node:12":                       "docker.io/library/node:12",
+		"node":                          "docker.io/library/node",
+		"screwdrivercd/launcher:v6.0.1": "docker.io/screwdrivercd/launcher:v6.0.1",
+		"aws/codebuild/standard:5.0":    "aws/codebuild/standard:5.0",
+		"registry.example.com:443/a/b@sha256:abc": "registry.example.com:443/a/b@sha256:abc",
+	}
+	for image, expected := range tests {
+		assert.Equal(t, expected, Normalize(image), image)
+	}
+}
+
+func TestParseECR(t *testing.T) {
+	got, ok := ParseECR("111111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12")
+	assert.True(t, ok)
+	assert.Equal(t, &ECRImage{RegistryID: "111111111111", Region: "us-east-2", Repository: "screwdriver-hub", Tag: "node12"}, got)
+
+	got, ok = ParseECR("111111111111.dkr.ecr-fips.us-gov-west-1.amazonaws.com/docker-hub/library/node@sha256:abc")
+	assert.True(t, ok)
+	assert.Equal(t, &ECRImage{RegistryID: "111111111111", Region: "us-gov-west-1", Repository: "docker-hub/library/node", Digest: "sha256:abc"}, got)
+
+	got, ok = ParseECR("111111111111.dkr.ecr.cn-north-1.amazonaws.com.cn/node")
+	assert.True(t, ok)
+	assert.Equal(t, &ECRImage{RegistryID: "111111111111", Region: "cn-north-1", Repository: "node", Tag: "latest"}, got)
+
+	for _, image := range []string{"node:12", "screwdrivercd/launcher:v6", "111111111.dkr.ecr.us-east-2.amazonaws.com/node:12", "ghcr.io/sd/node"} {
+		_, ok = ParseECR(image)
+		assert.False(t, ok, image)
+	}
+}
+
+func TestRewriterFromEnv(t *testing.T) {
+	t.Setenv("SD_IMAGE_REWRITES", "")
+	r, err := RewriterFromEnv()
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+
+	t.Setenv("SD_IMAGE_REWRITES", `[{"prefix": "docker.io/", "replacement": "mirror.example.com/"}]`)
+	r, err = RewriterFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, []Rule{{Prefix: "docker.io/", Replacement: "mirror.example.com/"}}, r.Rules)
+
+	t.Setenv("SD_IMAGE_REWRITES", `{`)
+	_, err = RewriterFromEnv()
+	assert.EqualError(t, err, "Got error parsing SD_IMAGE_REWRITES: unexpected end of JSON input")
+}
+
+func TestRewrite(t *testing.T) {
+	r := &Rewriter{Rules: []Rule{
+		{Prefix: "docker.io/", Replacement: "{accountId}.dkr.ecr.{region}.amazonaws.com/docker-hub/"},
+		{Prefix: "111111111.dkr.ecr.us-east-2.amazonaws.com/", Replacement: "111111111.dkr.ecr.{region}.amazonaws.com/"},
+		{Prefix: "quay.io/", Replacement: ""},
+	}}
+	tests := map[string]string{
+		"node:12":                       "222222222.dkr.ecr.us-west-2.amazonaws.com/docker-hub/library/node:12",
+		"screwdrivercd/launcher:v6.0.1": "222222222.dkr.ecr.us-west-2.amazonaws.com/docker-hub/screwdrivercd/launcher:v6.0.1",
+		"111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12": "111111111.dkr.ecr.us-west-2.amazonaws.com/screwdriver-hub:node12",
+		"aws/codebuild/standard:5.0":                                       "aws/codebuild/standard:5.0",
+		"ghcr.io/screwdriver-cd/node:12":                                   "ghcr.io/screwdriver-cd/node:12",
+		"quay.io/prometheus/busybox@sha256":                                "prometheus/busybox@sha256",
+		"":                                                                 "",
+	}
+	for image, expected := range tests {
+		assert.Equal(t, expected, r.Rewrite(image, "us-west-2", "222222222"), image)
+	}
+
+	var disabled *Rewriter
+	assert.Equal(t, "node:12", disabled.Rewrite("node:12", "us-west-2", "222222222"))
+}
+
+func TestRewriteBuildConfig(t *testing.T) {
+	r := &Rewriter{Rules: []Rule{{Prefix: "docker.io/", Replacement: "{accountId}.dkr.ecr.{region}.amazonaws.com/docker-hub/"}}}
+	buildConfig := map[string]interface{}{
+		"container": "node:12",
+		"provider": map[string]interface{}{
+			"accountId":     json.Number("111111111111"),
+			"launcherImage": "screwdrivercd/launcher:v6.0.147",
+		},
+	}
+	r.RewriteBuildConfig(buildConfig, "us-west-2")
+	assert.Equal(t, "111111111111.dkr.ecr.us-west-2.amazonaws.com/docker-hub/library/node:12", buildConfig["container"])
+	assert.Equal(t, "111111111111.dkr.ecr.us-west-2.amazonaws.com/docker-hub/screwdrivercd/launcher:v6.0.147", buildConfig["provider"].(map[string]interface{})["launcherImage"])
+
+	var disabled *Rewriter
+	buildConfig["container"] = "node:12"
+	disabled.RewriteBuildConfig(buildConfig, "us-west-2")
+	assert.Equal(t, "node:12", buildConfig["container"])
+}
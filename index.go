@@ -1,15 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,52 +19,1679 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/screwdriver-cd/aws-consumer-service/abort"
+	"github.com/screwdriver-cd/aws-consumer-service/annotations"
+	"github.com/screwdriver-cd/aws-consumer-service/archive"
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/budget"
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	"github.com/screwdriver-cd/aws-consumer-service/cost"
+	"github.com/screwdriver-cd/aws-consumer-service/diagnostics"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	ec2Executor "github.com/screwdriver-cd/aws-consumer-service/executor/ec2"
+	ecsExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/ecs"
 	eksExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/eks"
 	slsExecutor "github.com/screwdriver-cd/aws-consumer-service/executor/serverless"
+	"github.com/screwdriver-cd/aws-consumer-service/failover"
+	"github.com/screwdriver-cd/aws-consumer-service/heartbeat"
+	"github.com/screwdriver-cd/aws-consumer-service/image"
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/message"
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+	"github.com/screwdriver-cd/aws-consumer-service/notify"
+	"github.com/screwdriver-cd/aws-consumer-service/overrides"
+	"github.com/screwdriver-cd/aws-consumer-service/pause"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/receipt"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/registry"
+	"github.com/screwdriver-cd/aws-consumer-service/requeue"
+	"github.com/screwdriver-cd/aws-consumer-service/role"
 	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+	"github.com/screwdriver-cd/aws-consumer-service/sizing"
 )
 
 var utcLoc, _ = time.LoadLocation("UTC")
 var api = sd.New
+var store = sd.NewStore
+var loadPolicy = policy.Load
+var imageScanner policy.Scanner = policy.NewECRScanner()
+
+// resolves scoped IAM roles per pipeline, disabled when nil
+var roleResolver = newRoleResolver()
+
+// fills in provider config from the account registry, disabled when nil
+var accountRegistry = newAccountRegistry()
+
+// forces provider fields of pipelines, disabled when nil
+var pipelineOverrides = newPipelineOverrides()
+
+// records stops of builds which are still starting, disabled when nil
+var abortTracker = newAbortTracker()
+
+// exchanges the tokens of builds for build scoped secrets, disabled when nil
+var tokenExchanger = newTokenExchanger()
+
+// lets launchers report their build initialized, disabled when nil or without abort tracking
+var heartbeats = heartbeat.FromEnv()
+
+// reads and records the monthly spend of pipelines and accounts for the policy budgets, disabled when nil
+var budgetTracker = newBudgetTracker()
+
+// archives the consumed kafka records to s3, disabled when nil
+var recordArchive = newRecordArchive()
+
+// requeues starts which find no capacity to a delay queue, disabled when nil
+var requeueQueue = newRequeueQueue()
+
+// rewrites build and launcher images to registry mirrors, disabled when nil
+var imageRewriter = newImageRewriter()
+
+// queue coalescing build stats updates within a batch, disabled when nil
+var updateQueue = newUpdateQueue()
+
+// notifies on-call of infrastructure failures, disabled when nil
+var notifier = notify.FromEnv()
+
+// publishes the receipts of started builds for the queue service, disabled when nil
+var startReceipts = newStartReceipts()
+
+// pauses the start of builds during incidents, disabled when nil
+var startPause = newStartPause()
+
+// reports the month to date costs of pipelines from the tags of their resources
+var costReporter ICostReporter = cost.New()
+
+// prometheus metrics exposed on SD_METRICS_LISTEN_ADDR
+var (
+	buildsStarted = metrics.NewCounter("sd_aws_consumer_builds_started_total", "Builds started by executor")
+	buildsStopped = metrics.NewCounter("sd_aws_consumer_builds_stopped_total", "Builds stopped by executor")
+	buildsAborted = metrics.NewCounter("sd_aws_consumer_builds_aborted_total", "Builds stopped while starting by executor")
+	buildFailures = metrics.NewCounter("sd_aws_consumer_build_failures_total", "Failed build starts and stops by executor")
+	buildsPaused  = metrics.NewCounter("sd_aws_consumer_builds_paused_total", "Build starts held back while starts are paused by executor")
+	kafkaLag      = metrics.NewHistogram("sd_aws_consumer_kafka_lag_seconds", "Time in seconds between a record being produced and consumed", metrics.DefaultBuckets)
+)
 
 // BuildMessage structure definition
-type BuildMessage struct {
-	Job          string                 `json:"job"`
-	BuildConfig  map[string]interface{} `json:"buildConfig"`
-	ExecutorType string                 `json:"executorType"`
+type BuildMessage = message.BuildMessage
+
+// IExecutor interface with method definition
+type IExecutor interface {
+	Start(config map[string]interface{}) (string, error)
+	Stop(config map[string]interface{}) error
+	Status(config map[string]interface{}) (executorState.Status, error)
+	Logs(config map[string]interface{}, since time.Time) ([]byte, error)
+	Cleanup(config map[string]interface{}) error
+	Name() string
+}
+
+// IPreflight is implemented by executors which can check quotas and capacity before a build is started
+type IPreflight interface {
+	Preflight(config map[string]interface{}) error
+}
+
+// IWaitRunning is implemented by executors which can wait for a started build to run
+type IWaitRunning interface {
+	WaitRunning(config map[string]interface{}, timeout time.Duration) (time.Time, error)
+}
+
+// IImagePullStartTime is implemented by executors which can tell when the build image pull started
+type IImagePullStartTime interface {
+	ImagePullStartTime(config map[string]interface{}, timeout time.Duration) (time.Time, error)
+}
+
+// IResourceLinks is implemented by executors which can link the aws resources of a started build
+type IResourceLinks interface {
+	ResourceLinks(config map[string]interface{}) map[string]string
+}
+
+// IStartEvents is implemented by executors which can report the events of the resources of a build failing to start
+type IStartEvents interface {
+	StartEvents(config map[string]interface{}) ([]string, error)
+}
+
+// IResourceArn is implemented by executors which can tell the arn of the aws resource running a started build
+type IResourceArn interface {
+	ResourceArn(config map[string]interface{}) string
+}
+
+// IBuildStats is implemented by executors which can report additional stats of a started build, e.g. its ip addresses
+type IBuildStats interface {
+	BuildStats(config map[string]interface{}) map[string]interface{}
+}
+
+// ICacheStats is implemented by executors which can report how the local caches of a build were used
+type ICacheStats interface {
+	CacheStats(config map[string]interface{}) map[string]interface{}
+}
+
+// IContainerExits is implemented by executors which can report how the containers of a finished build exited
+type IContainerExits interface {
+	ContainerExits(config map[string]interface{}) ([]executorState.ContainerExit, error)
+}
+
+// ISizingRecommendation is implemented by executors which can recommend the size of a build from its usage
+type ISizingRecommendation interface {
+	SizingRecommendation(config map[string]interface{}) (map[string]interface{}, error)
+}
+
+// IDebugSession is implemented by executors which can report the connection details of a build debug session
+type IDebugSession interface {
+	DebugSession(config map[string]interface{}, timeout time.Duration) (executorState.DebugSession, error)
+}
+
+// IDescriber is implemented by executors which can report their capabilities for a provider
+type IDescriber interface {
+	Describe(config map[string]interface{}) (map[string]interface{}, error)
+}
+
+// IResourceLister is implemented by executors which can list the aws resources a pipeline currently consumes
+type IResourceLister interface {
+	Resources(config map[string]interface{}) ([]executorState.Resource, error)
+}
+
+// context key of the kafka record timestamp
+type contextKey string
+
+const enqueuedAtKey contextKey = "enqueuedAt"
+
+// context key of the number of times the message was requeued
+const attemptKey contextKey = "attempt"
+
+// kafka record headers with the job and executor type of the build message, set by producers so records are routed before decoding
+const (
+	jobHeader      = "sd-job"
+	executorHeader = "sd-executor"
+)
+
+// IRequeue publishes build messages to a delay queue to retry them later
+type IRequeue interface {
+	Requeue(value string, attempt int) (bool, error)
+	Delay(attempt int) time.Duration
+	MaxAttempts() int
+}
+
+// IRoleResolver resolves the scoped IAM role of a pipeline
+type IRoleResolver interface {
+	Resolve(t role.Template) (string, error)
+}
+
+// IAccountRegistry looks up provider accounts by alias
+type IAccountRegistry interface {
+	Lookup(alias string) (*registry.Account, error)
+}
+
+// IPipelineOverrides looks up the provider overrides of pipelines
+type IPipelineOverrides interface {
+	Lookup(pipelineID string) (*overrides.Overrides, error)
+}
+
+// IAbortTracker records stops of builds which arrive while the build is still starting
+type IAbortTracker interface {
+	Starting(buildID int, arch string) (bool, error)
+	Started(buildID int, arch string) (bool, error)
+	Abort(buildID int, arch string) (bool, error)
+	Pending(buildID int, arch string, message string, token string) error
+	ListPending(limit int) ([]abort.Record, error)
+	Resolve(buildID int, arch string) error
+	AwaitHeartbeat(buildID int, arch string, message string, token string) error
+	ListAwaitingHeartbeat(limit int) ([]abort.Record, error)
+	Heartbeat(buildID int, arch string, hostname string) (bool, error)
+}
+
+// ITokenExchanger stores build tokens in secrets which only the role of the build can read
+type ITokenExchanger interface {
+	Exchange(region string, buildID int, arch string, token string, roleArn string) (string, error)
+	Revoke(region string, buildID int, arch string) error
+}
+
+// IRecordArchive archives the records of a partition batch for audits and replays
+type IRecordArchive interface {
+	Archive(records []events.KafkaRecord) (string, error)
+}
+
+// IStartReceipts publishes the receipts of started builds and reads them back for their stops
+type IStartReceipts interface {
+	Put(r receipt.Receipt) error
+	Get(buildID int, arch string) (*receipt.Receipt, error)
+}
+
+// IPause tells if the start of builds is paused
+type IPause interface {
+	Paused() (bool, error)
+}
+
+// IBudgetTracker reads and adds the monthly spend of pipelines and accounts
+type IBudgetTracker interface {
+	policy.SpendReader
+	Add(scope string, id string, month string, cost float64) error
+}
+
+// ICostReporter reports the costs of the resources managed by Screwdriver
+type ICostReporter interface {
+	Report() (*cost.Summary, error)
+}
+
+// executorFactory constructs the executor of a region
+type executorFactory func(region string) (IExecutor, error)
+
+// executor factories by name, only the executor of a message is constructed
+var executorFactories = map[string]executorFactory{
+	"ec2": func(region string) (IExecutor, error) {
+		executor, err := ec2Executor.New(region)
+		if err != nil {
+			return nil, err
+		}
+		return executor, nil
+	},
+	"ecs": func(region string) (IExecutor, error) {
+		executor, err := ecsExecutor.New(region)
+		if err != nil {
+			return nil, err
+		}
+		return executor, nil
+	},
+	"eks": func(region string) (IExecutor, error) {
+		executor, err := eksExecutor.New(region)
+		if err != nil {
+			return nil, err
+		}
+		return executor, nil
+	},
+	"sls": func(region string) (IExecutor, error) {
+		executor, err := slsExecutor.New(region)
+		if err != nil {
+			return nil, err
+		}
+		return executor, nil
+	},
+}
+
+// executor which could not be constructed, its jobs fail with the error until it is constructed
+type unavailableExecutor struct {
+	name string
+	err  error
+}
+
+// failure of the jobs, the executor can only be fixed by its admins
+func (e *unavailableExecutor) failure() error {
+	return executorState.Errorf(executorState.InfraPermanent, "Error creating %v executor: %w", e.name, e.err)
+}
+
+// Start fails with the construction error
+func (e *unavailableExecutor) Start(config map[string]interface{}) (string, error) {
+	return "", e.failure()
+}
+
+// Stop fails with the construction error
+func (e *unavailableExecutor) Stop(config map[string]interface{}) error {
+	return e.failure()
+}
+
+// Status fails with the construction error
+func (e *unavailableExecutor) Status(config map[string]interface{}) (executorState.Status, error) {
+	return executorState.Status{}, e.failure()
+}
+
+// Logs fails with the construction error
+func (e *unavailableExecutor) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	return nil, e.failure()
+}
+
+// Cleanup fails with the construction error
+func (e *unavailableExecutor) Cleanup(config map[string]interface{}) error {
+	return e.failure()
+}
+
+// Name returns the name of the executor
+func (e *unavailableExecutor) Name() string {
+	return e.name
+}
+
+// executorCache memoizes executors per name and region, executors are shared by concurrent builds
+type executorCache struct {
+	mu        sync.Mutex
+	executors map[string]IExecutor
+}
+
+// executors constructed so far
+var executors = newExecutorCache()
+
+// returns an empty executor cache
+func newExecutorCache() *executorCache {
+	return &executorCache{executors: map[string]IExecutor{}}
+}
+
+// GetExecutor returns the executor of the name and region, constructing it on first use. Executors which
+// can't be constructed are not cached and fail their jobs as infrastructure failures.
+func GetExecutor(name string, region string) IExecutor {
+	executors.mu.Lock()
+	defer executors.mu.Unlock()
+
+	key := name + "/" + region
+	if executor, ok := executors.executors[key]; ok {
+		return executor
+	}
+	factory, ok := executorFactories[name]
+	if !ok {
+		return nil
+	}
+	executor, err := factory(region)
+	if err != nil {
+		// not cached, the next job constructs it again
+		log.Printf("Failed to create executor %v of region %v: %v", name, region, err)
+		return &unavailableExecutor{name: name, err: err}
+	}
+	executors.executors[key] = executor
+
+	return executor
+}
+
+// returns true when pre-flight checks are enabled via SD_PREFLIGHT_CHECKS
+func preflightEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SD_PREFLIGHT_CHECKS"))
+	return enabled
+}
+
+// gets how long to wait for a started build to run when measuring start latency, from SD_SLO_RUNNING_TIMEOUT_SECS
+func runningTimeout() time.Duration {
+	secs, _ := strconv.Atoi(strings.TrimSpace(os.Getenv("SD_SLO_RUNNING_TIMEOUT_SECS")))
+	return time.Duration(secs) * time.Second
+}
+
+// emits the latency from enqueue to start accepted and, when enabled, from enqueue to running
+func emitStartLatency(ctx context.Context, executor IExecutor, buildConfig map[string]interface{}, dimensions map[string]string) {
+	enqueuedAt, ok := ctx.Value(enqueuedAtKey).(time.Time)
+	if !ok || enqueuedAt.IsZero() {
+		return
+	}
+	metrics.Since("BuildStartAcceptedLatency", enqueuedAt, dimensions)
+
+	waiter, ok := executor.(IWaitRunning)
+	timeout := runningTimeout()
+	if !ok || timeout <= 0 {
+		return
+	}
+	runningAt, err := waiter.WaitRunning(buildConfig, timeout)
+	if err != nil {
+		log.Printf("Waiting for build to run: %v", err)
+		metrics.Put("BuildRunningWaitErrors", 1, metrics.Count, dimensions)
+		return
+	}
+	metrics.Put("BuildRunningLatency", float64(runningAt.Sub(enqueuedAt))/float64(time.Millisecond), metrics.Milliseconds, dimensions)
+}
+
+// creates the update queue when SDAPI_UPDATE_COALESCE_MS is set
+func newUpdateQueue() *sd.UpdateQueue {
+	delay, _ := strconv.Atoi(strings.TrimSpace(os.Getenv("SDAPI_UPDATE_COALESCE_MS")))
+	if delay <= 0 {
+		return nil
+	}
+	return sd.NewUpdateQueue(time.Duration(delay) * time.Millisecond)
+}
+
+// CheckPolicy validates the build config against the deployment policy. A policy which cannot be loaded is a
+// transient infrastructure failure, only violations of the policy reject the build.
+func CheckPolicy(buildConfig map[string]interface{}, buildRegion string) error {
+	p, err := loadPolicy()
+	if err != nil {
+		return executorState.Errorf(executorState.InfraTransient, "%w", err)
+	}
+	if err := p.CheckBuildConfig(buildConfig, buildRegion); err != nil {
+		if policy.IsViolation(err) {
+			return executorState.Errorf(executorState.Policy, "%w", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// creates the budget tracker when SD_BUDGET_TABLE is set
+func newBudgetTracker() IBudgetTracker {
+	if t := budget.FromEnv(); t != nil {
+		return t
+	}
+	return nil
+}
+
+// CheckBudget validates the spend of the pipeline and account against the budgets of the deployment policy.
+// A policy or spend which cannot be read is a transient infrastructure failure, only exceeded budgets reject the build.
+func CheckBudget(buildConfig map[string]interface{}) error {
+	if budgetTracker == nil {
+		return nil
+	}
+	p, err := loadPolicy()
+	if err != nil {
+		return executorState.Errorf(executorState.InfraTransient, "%w", err)
+	}
+	if err := p.CheckBudget(buildConfig, budgetTracker, time.Now()); err != nil {
+		if policy.IsViolation(err) {
+			return executorState.Errorf(executorState.Policy, "%w", err)
+		}
+		return executorState.Errorf(executorState.InfraTransient, "%w", err)
+	}
+	return nil
+}
+
+// filters the build config environment forwarded to the build by the deployment policy
+func applyEnvironmentPolicy(buildConfig map[string]interface{}) error {
+	p, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	environment, _ := buildConfig["environment"].(map[string]interface{})
+	allowed, dropped := p.FilterEnvironment(environment)
+	if len(dropped) > 0 {
+		log.Printf("Not forwarding environment variables denied by policy: %v", strings.Join(dropped, ", "))
+	}
+	buildConfig[policy.EnvironmentKey] = allowed
+
+	return nil
+}
+
+// names the codebuild project and pods of the build by the naming of the deployment policy
+func applyNaming(buildConfig map[string]interface{}) error {
+	p, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	return nameBuild(p, buildConfig)
+}
+
+// names the resources of a build which is not starting. Stops use the names of the start receipt, the naming may
+// have changed since the build started, other jobs fall back to the default naming when the policy can't be loaded
+// instead of being dropped.
+func applyJobNaming(buildConfig map[string]interface{}, started *receipt.Receipt) error {
+	if started != nil && started.ProjectName != "" {
+		buildConfig[policy.ProjectNameKey] = started.ProjectName
+		buildConfig[policy.PodNamePrefixKey] = started.PodNamePrefix
+		return nil
+	}
+	if err := applyNaming(buildConfig); err != nil {
+		log.Printf("Naming build %v with the default naming: %v", buildConfig["buildId"], err)
+		return nameBuild(&policy.Policy{}, buildConfig)
+	}
+	return nil
+}
+
+// names the resources of the build by the naming of the policy
+func nameBuild(p *policy.Policy, buildConfig map[string]interface{}) error {
+	if err := p.ApplyNaming(buildConfig); err != nil {
+		return err
+	}
+	// the builds of a multi-architecture build run in projects of their own
+	if projectName, _ := buildConfig[policy.ProjectNameKey].(string); projectName != "" {
+		buildConfig[policy.ProjectNameKey] = matrix.Name(buildConfig, projectName)
+	}
+	return nil
+}
+
+// CheckImageScan validates the scan findings of the build container against the deployment policy
+func CheckImageScan(buildConfig map[string]interface{}) error {
+	p, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	container, _ := buildConfig["container"].(string)
+
+	return p.CheckVulnerabilities(imageScanner, container)
+}
+
+// creates the role resolver when SD_SCOPED_ROLE_MODE is set
+func newRoleResolver() IRoleResolver {
+	if m := role.FromEnv(); m != nil {
+		return m
+	}
+	return nil
+}
+
+// replaces the provider role with the scoped role of the pipeline when scoped roles are enabled
+func applyScopedRole(buildConfig map[string]interface{}, buildRegion string) error {
+	if roleResolver == nil {
+		return nil
+	}
+	provider := buildConfig["provider"].(map[string]interface{})
+	t := role.Template{Region: buildRegion}
+	if provider["accountId"] != nil {
+		t.AccountID = fmt.Sprint(provider["accountId"])
+	}
+	if buildConfig["pipelineId"] != nil {
+		t.PipelineID = fmt.Sprint(buildConfig["pipelineId"])
+	}
+	roleArn, err := roleResolver.Resolve(t)
+	if err != nil {
+		return fmt.Errorf("Got error resolving scoped role: %v", err)
+	}
+	// the scoped role replaces the provider role checked by the policy
+	p, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	if err := p.CheckRole(roleArn, t.AccountID, awsconfig.Partition(buildRegion)); err != nil {
+		return err
+	}
+	log.Printf("Using scoped role %v for pipeline %v", roleArn, t.PipelineID)
+	provider["role"] = roleArn
+	provider["scopedRole"] = roleArn
+
+	return nil
+}
+
+// principals assuming the provider role per executor, eks builds only need the role to exist
+var rolePrincipals = map[string]string{
+	"ec2": "ec2.amazonaws.com",
+	"ecs": "ecs-tasks.amazonaws.com",
+	"sls": "codebuild.amazonaws.com",
+}
+
+// checks that the provider role exists and can be assumed by the service principal
+var checkRoleAssumable = func(roleArn string, service string) error {
+	sess, err := awsconfig.NewSession("")
+	if err != nil {
+		return err
+	}
+	return role.CheckAssumable(iam.New(sess), roleArn, service)
+}
+
+// writes the normalized state of the build reported by the executor into the build meta
+func reportStatus(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	status, err := executor.Status(buildConfig)
+	if err != nil {
+		log.Printf("Failed to get status of build %v: %v", buildID, err)
+		return
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"status": status}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+	reportSizingRecommendation(executor, buildConfig, buildID, api)
+}
+
+// window of the logs pushed by a logs job without logsSince
+const defaultLogsWindow = time.Hour
+
+// name of the store artifact holding the executor logs
+const logsArtifactName = "aws-executor.log"
+
+// pushes the recent logs of the build reported by the executor into the build artifacts in the SD store
+func pushLogs(executor IExecutor, buildConfig map[string]interface{}, buildID int) {
+	since := time.Now().Add(-defaultLogsWindow)
+	if logsSince, _ := buildConfig["logsSince"].(string); logsSince != "" {
+		if t, err := time.Parse(time.RFC3339, logsSince); err == nil {
+			since = t
+		}
+	}
+	logs, err := executor.Logs(buildConfig, since)
+	if err != nil {
+		log.Printf("Failed to get logs of build %v: %v", buildID, err)
+		return
+	}
+	token := buildConfig["token"].(string)
+	store, err := store(buildConfig["storeUri"].(string), token)
+	if err != nil {
+		log.Printf("Failed to create store client: %v", err)
+		return
+	}
+	// the launcher may echo the build token while bootstrapping
+	body := []byte(redact.Values(string(logs), token))
+	if err := store.UploadArtifact(buildID, logsArtifactName, "text/plain", body); err != nil {
+		log.Printf("Uploading logs of build %v: %v", buildID, err)
+	}
+}
+
+// name of the setting uploading the diagnostics of failed starts
+const startDiagnosticsEnv = "SD_START_DIAGNOSTICS"
+
+// uploads the diagnostics of a start which failed before the launcher ran into the build artifacts in the SD store,
+// so users can find the root cause themselves
+func uploadStartDiagnostics(executor IExecutor, buildConfig map[string]interface{}, buildRegion string, buildID int, err error, statusMessage string) {
+	if enabled, _ := strconv.ParseBool(os.Getenv(startDiagnosticsEnv)); !enabled {
+		return
+	}
+	d := diagnostics.NewStart(buildID, executor.Name(), buildRegion, buildConfig, err, statusMessage, time.Now())
+	d.Category = string(executorState.CategoryOf(err))
+	if reporter, ok := executor.(IStartEvents); ok {
+		events, everr := reporter.StartEvents(buildConfig)
+		if everr != nil {
+			log.Printf("Getting events of build %v: %v", buildID, everr)
+		}
+		d.Events = events
+	}
+	body, jerr := json.MarshalIndent(d, "", "  ")
+	if jerr != nil {
+		log.Printf("Encoding start diagnostics of build %v: %v", buildID, jerr)
+		return
+	}
+	token := buildConfig["token"].(string)
+	store, serr := store(buildConfig["storeUri"].(string), token)
+	if serr != nil {
+		log.Printf("Failed to create store client: %v", serr)
+		return
+	}
+	// errors of the executor may carry the token
+	body = []byte(redact.Values(string(body), token))
+	if uerr := store.UploadArtifact(buildID, diagnostics.StartArtifactName, "application/json", body); uerr != nil {
+		log.Printf("Uploading start diagnostics of build %v: %v", buildID, uerr)
+	}
+}
+
+// logs the effective config resolved for the start of the build and writes it into the build meta,
+// so support can reproduce what the consumer decided
+func reportEffectiveConfig(buildConfig map[string]interface{}, executorType string, buildRegion string, buildID int, api sd.API) {
+	config := diagnostics.NewEffectiveConfig(executorType, buildRegion, buildConfig)
+	if body, err := json.Marshal(config); err == nil {
+		// the environment may carry the token
+		log.Printf("Effective config of build %v: %s", buildID, redact.Values(string(body), buildConfig["token"].(string)))
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"config": config}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+}
+
+// writes what the executor can do for the provider into the build meta
+func reportCapabilities(executor IExecutor, buildConfig map[string]interface{}, buildRegion string, buildID int, api sd.API) {
+	provider := buildConfig["provider"].(map[string]interface{})
+	capabilities := map[string]interface{}{"region": buildRegion, "roleAssumable": false}
+	if err := awsconfig.ValidateRegion(buildRegion); err != nil {
+		capabilities["regionError"] = err.Error()
+	}
+	if roleArn, _ := provider["role"].(string); roleArn == "" {
+		capabilities["roleError"] = "provider role is not set"
+	} else if err := checkRoleAssumable(roleArn, rolePrincipals[executor.Name()]); err != nil {
+		capabilities["roleError"] = err.Error()
+	} else {
+		capabilities["roleAssumable"] = true
+	}
+	if describer, ok := executor.(IDescriber); ok {
+		executorCapabilities, err := describer.Describe(buildConfig)
+		if err != nil {
+			capabilities["error"] = err.Error()
+		}
+		for k, v := range executorCapabilities {
+			capabilities[k] = v
+		}
+	}
+
+	meta := map[string]interface{}{"aws": map[string]interface{}{"capabilities": map[string]interface{}{executor.Name(): capabilities}}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+}
+
+// writes the active resources of the pipeline of the build into the build meta, under the executor listing them
+func reportResources(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	lister, ok := executor.(IResourceLister)
+	if !ok {
+		log.Printf("Executor %v can't list the resources of pipeline %v", executor.Name(), buildConfig["pipelineId"])
+		return
+	}
+	listing := map[string]interface{}{"pipelineId": fmt.Sprint(buildConfig["pipelineId"]), "listedAt": time.Now().UTC().Format(time.RFC3339)}
+	resources, err := lister.Resources(buildConfig)
+	if err != nil {
+		log.Printf("Failed to list resources of pipeline %v: %v", buildConfig["pipelineId"], err)
+		listing["error"] = err.Error()
+	} else {
+		listing["items"] = resources
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"resources": map[string]interface{}{executor.Name(): listing}}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+}
+
+// creates the account registry when SD_PROVIDER_REGISTRY_TABLE is set
+func newAccountRegistry() IAccountRegistry {
+	if r := registry.FromEnv(); r != nil {
+		return r
+	}
+	return nil
+}
+
+// creates the pipeline overrides when SD_PIPELINE_OVERRIDES_TABLE is set
+func newPipelineOverrides() IPipelineOverrides {
+	if t := overrides.FromEnv(); t != nil {
+		return t
+	}
+	return nil
+}
+
+// creates the abort tracker when SD_IDEMPOTENCY_TABLE is set
+func newAbortTracker() IAbortTracker {
+	if t := abort.FromEnv(); t != nil {
+		return t
+	}
+	return nil
+}
+
+// creates the token exchanger when SD_TOKEN_SECRET_PREFIX is set
+func newTokenExchanger() ITokenExchanger {
+	if e := buildtoken.FromEnv(); e != nil {
+		return e
+	}
+	return nil
+}
+
+// creates the record archive when SD_ARCHIVE_BUCKET is set
+func newRecordArchive() IRecordArchive {
+	if a := archive.FromEnv(); a != nil {
+		return a
+	}
+	return nil
+}
+
+// creates the start receipts when SD_RECEIPT_TABLE is set
+func newStartReceipts() IStartReceipts {
+	if t := receipt.FromEnv(); t != nil {
+		return t
+	}
+	return nil
+}
+
+// creates the start pause when SD_PAUSE_SSM_PARAMETER is set
+func newStartPause() IPause {
+	if s := pause.FromEnv(); s != nil {
+		return s
+	}
+	return nil
+}
+
+// creates the delay queue when SD_REQUEUE_QUEUE_URL is set
+func newRequeueQueue() IRequeue {
+	if q := requeue.FromEnv(); q != nil {
+		return q
+	}
+	return nil
+}
+
+// requeues a start which found no capacity instead of failing it, returns true if the message was requeued
+func requeueStart(ctx context.Context, value string, buildID int, api sd.API, reason error) bool {
+	if requeueQueue == nil {
+		return false
+	}
+	attempt, _ := ctx.Value(attemptKey).(int)
+	attempt++
+	requeued, err := requeueQueue.Requeue(value, attempt)
+	if err != nil {
+		log.Printf("Requeueing build %v: %v", buildID, err)
+		return false
+	}
+	if !requeued {
+		log.Printf("Giving up on build %v after %v attempts: %v", buildID, requeueQueue.MaxAttempts(), reason)
+		notifier.Notify(notify.Event{
+			Kind:    notify.DeadLetter,
+			BuildID: buildID,
+			Message: fmt.Sprintf("Gave up on build %v after %v attempts: %v", buildID, requeueQueue.MaxAttempts(), reason),
+		})
+		return false
+	}
+	delay := requeueQueue.Delay(attempt)
+	log.Printf("Requeued build %v, retrying in %v (attempt %v of %v): %v", buildID, delay, attempt, requeueQueue.MaxAttempts(), reason)
+	statusMessage := fmt.Sprintf("Waiting for capacity, retrying in %v (attempt %v of %v): %v", delay, attempt, requeueQueue.MaxAttempts(), reason)
+	if apierr := api.UpdateBuild(nil, buildID, statusMessage); apierr != nil {
+		log.Printf("Updating build status message: %v", apierr)
+	}
+	return true
+}
+
+// holds back a start while starts are paused, requeueing it without using up an attempt or failing it without a
+// delay queue, returns true if the start was held back
+func pauseStart(ctx context.Context, value string, buildMessage *BuildMessage) bool {
+	if startPause == nil {
+		return false
+	}
+	paused, err := startPause.Paused()
+	if err != nil {
+		log.Printf("Checking if starts are paused: %v", err)
+	}
+	if !paused {
+		return false
+	}
+	buildConfig := buildMessage.BuildConfig
+	buildID, _ := buildConfig["buildId"].(json.Number).Int64()
+	api, err := api(buildConfig["apiUri"].(string), buildConfig["token"].(string))
+	if err != nil {
+		// without a client the build can neither be told it is requeued nor failed
+		log.Printf("Creating api client of paused build %v: %v", buildID, err)
+	}
+	buildsPaused.Inc(map[string]string{"executor": buildMessage.ExecutorType})
+	if requeueQueue != nil {
+		attempt, _ := ctx.Value(attemptKey).(int)
+		requeued, err := requeueQueue.Requeue(value, attempt)
+		if err != nil {
+			log.Printf("Requeueing paused build %v: %v", buildID, err)
+		}
+		if requeued {
+			delay := requeueQueue.Delay(attempt)
+			log.Printf("Starts are paused, requeued build %v, retrying in %v", buildID, delay)
+			statusMessage := fmt.Sprintf("Starts are paused by the Screwdriver admins, retrying in %v", delay)
+			if api == nil {
+				return true
+			}
+			if apierr := api.UpdateBuild(nil, int(buildID), statusMessage); apierr != nil {
+				log.Printf("Updating build status message: %v", apierr)
+			}
+			return true
+		}
+	}
+	if api == nil {
+		log.Printf("Starts are paused, skipping build %v", buildID)
+		return true
+	}
+	log.Printf("Starts are paused, failing build %v", buildID)
+	FailBuild(int(buildID), "Starts are paused by the Screwdriver admins, restart the build once they are resumed", api)
+	return true
+}
+
+// notifies on-call of a start failed by exhausted quotas, and of accounts failing to start builds repeatedly
+func notifyStartFailure(buildConfig map[string]interface{}, buildID int, err error) {
+	if notifier == nil {
+		return
+	}
+	account := policy.ProviderAccount(buildConfig["provider"].(map[string]interface{}))
+	if executorState.IsCapacity(err) {
+		notifier.Notify(notify.Event{
+			Kind:    notify.QuotaExhausted,
+			Account: account,
+			BuildID: buildID,
+			Message: fmt.Sprintf("Build %v found no capacity: %v", buildID, err),
+		})
+	}
+	notifier.StartFailed(account, buildID, err)
+}
+
+// records the start of the build, returns true if the build was stopped before it started
+func beginStart(buildID int, arch string) bool {
+	if abortTracker == nil {
+		return false
+	}
+	stopped, err := abortTracker.Starting(buildID, arch)
+	if err != nil {
+		log.Printf("Recording start of build %v: %v", buildID, err)
+		return false
+	}
+	return stopped
+}
+
+// stops the started build if a stop arrived while it was starting, returns true if it did
+func abortIfStopped(executor IExecutor, buildConfig map[string]interface{}, buildID int, arch string) bool {
+	if abortTracker == nil {
+		return false
+	}
+	stopped, err := abortTracker.Started(buildID, arch)
+	if err != nil {
+		log.Printf("Recording start of build %v: %v", buildID, err)
+		return false
+	}
+	if !stopped {
+		return false
+	}
+	log.Printf("Build %v was stopped while starting, stopping it", buildID)
+	if err := executor.Stop(buildConfig); err != nil {
+		log.Printf("Failed to stop aborted build %v: %v", buildID, redact.String(err.Error()))
+	}
+	return true
+}
+
+// gets how long before the lambda deadline a start still in progress is recorded pending verification, from SD_START_DEADLINE_MARGIN_SECS
+func startDeadlineMargin() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SD_START_DEADLINE_MARGIN_SECS")))
+	if err != nil || secs <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// records the start of the build pending verification if it still runs close to the deadline of the invocation,
+// the returned func cancels the watch once the start completed, waiting for a recording already in progress
+func watchDeadline(ctx context.Context, buildID int, arch string, value string) func() {
+	deadline, ok := ctx.Deadline()
+	if abortTracker == nil || !ok {
+		return func() {}
+	}
+	done := make(chan struct{})
+	timer := time.AfterFunc(time.Until(deadline)-startDeadlineMargin(), func() {
+		defer close(done)
+		log.Printf("Start of build %v is still in progress close to the deadline, recording it pending verification", buildID)
+		stripped, token, err := message.WithoutToken(value)
+		if err == nil {
+			err = abortTracker.Pending(buildID, arch, stripped, token)
+		}
+		if err != nil {
+			log.Printf("Recording start of build %v pending verification: %v", buildID, err)
+		}
+	})
+	return func() {
+		if !timer.Stop() {
+			<-done
+		}
+	}
+}
+
+// number of starts pending verification checked per invocation
+const pendingVerifyLimit = 10
+
+// starts pending verification whose build is still not found after this long are failed
+const pendingFailAfter = 15 * time.Minute
+
+// checks the builds of starts cut short by the lambda deadline, failing the ones which did not start and
+// stopping the ones which were stopped meanwhile
+func verifyPendingStarts() {
+	if abortTracker == nil {
+		return
+	}
+	records, err := abortTracker.ListPending(pendingVerifyLimit)
+	if err != nil {
+		log.Printf("Listing starts pending verification: %v", err)
+		return
+	}
+	for _, record := range records {
+		if verifyPendingStart(record) {
+			if err := abortTracker.Resolve(record.BuildID, record.Architecture); err != nil {
+				log.Printf("Resolving start of build %v: %v", record.BuildID, err)
+			}
+		}
+	}
+}
+
+// gets the executor, build config and api of the build message kept with the record, returns false if it can't be used
+func recordBuild(record abort.Record) (IExecutor, map[string]interface{}, sd.API, bool) {
+	buildMessage, err := decodeMessage(record.Message)
+	if err != nil {
+		log.Printf("Decoding start of build %v pending verification: %v", record.BuildID, err)
+		return nil, nil, nil, false
+	}
+	buildConfig := buildMessage.BuildConfig
+	// the message is kept without its token, which the tracker unseals when it has a kms key
+	if record.Token != "" {
+		buildConfig["token"] = record.Token
+	}
+	if err := applyExecutorOverride(buildMessage); err != nil {
+		log.Printf("Failed to override executor of build %v: %v", record.BuildID, err)
+		return nil, nil, nil, false
+	}
+	if err := applyAccount(buildConfig, buildMessage.ExecutorType); err != nil {
+		log.Printf("Failed to resolve provider: %v", err)
+		return nil, nil, nil, false
+	}
+	if err := applyNaming(buildConfig); err != nil {
+		log.Printf("Failed to name build %v: %v", record.BuildID, err)
+		return nil, nil, nil, false
+	}
+	executor := GetExecutor(buildMessage.ExecutorType, buildMessage.BuildRegion())
+	if executor == nil {
+		log.Printf("Unknown executor %v for build %v", buildMessage.ExecutorType, record.BuildID)
+		return nil, nil, nil, false
+	}
+	token, _ := buildConfig["token"].(string)
+	api, err := api(buildConfig["apiUri"].(string), token)
+	if err != nil {
+		log.Printf("Creating api client of build %v: %v", record.BuildID, err)
+		return nil, nil, nil, false
+	}
+	return executor, buildConfig, matrix.WrapAPI(api, buildConfig), true
+}
+
+// checks the build of a start pending verification, returns true once it is resolved
+func verifyPendingStart(record abort.Record) bool {
+	executor, buildConfig, api, ok := recordBuild(record)
+	if !ok {
+		return true
+	}
+	if record.State == abort.Aborted {
+		log.Printf("Build %v was stopped while starting, stopping it", record.BuildID)
+		if err := executor.Stop(buildConfig); err != nil {
+			log.Printf("Failed to stop aborted build %v: %v", record.BuildID, redact.String(err.Error()))
+		}
+		return true
+	}
+	status, err := executor.Status(buildConfig)
+	if err != nil {
+		if time.Since(time.Unix(record.PendingAt, 0)) < pendingFailAfter {
+			log.Printf("Build %v pending verification is not found yet: %v", record.BuildID, err)
+			return false
+		}
+		log.Printf("Build %v pending verification did not start: %v", record.BuildID, err)
+		FailBuild(record.BuildID, "Build did not start before the consumer timed out, restart the build", api)
+		notifier.Notify(notify.Event{
+			Kind:    notify.ReconcilerKill,
+			Account: policy.ProviderAccount(buildConfig["provider"].(map[string]interface{})),
+			BuildID: record.BuildID,
+			Message: fmt.Sprintf("Failed build %v which did not start within %v: %v", record.BuildID, pendingFailAfter, err),
+		})
+		return true
+	}
+	log.Printf("Build %v pending verification is %v", record.BuildID, status.State)
+	if status.State == executorState.Failed {
+		FailBuild(record.BuildID, fmt.Sprintf("Build failed to start: %v", status.Reason), api)
+		return true
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"status": status}}
+	if apierr := api.UpdateBuildMeta(meta, record.BuildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+	return true
+}
+
+// gets the role reading the token secret of the build, empty if the build has no role of its own
+func tokenReader(buildConfig map[string]interface{}, executorType string) string {
+	provider := buildConfig["provider"].(map[string]interface{})
+	// eks pods only get a role of their own through the service account of the scoped role
+	if executorType == "eks" {
+		roleArn, _ := provider["scopedRole"].(string)
+		return roleArn
+	}
+	// ecs tasks resolve their secrets with the execution role, which defaults to the role of the task
+	if executorType == "ecs" {
+		if roleArn, _ := provider["executionRole"].(string); roleArn != "" {
+			return roleArn
+		}
+	}
+	roleArn, _ := provider["role"].(string)
+	return roleArn
+}
+
+// exchanges the token of the build for its secret, so the build only gets the reference of the secret
+func exchangeToken(buildConfig map[string]interface{}, executorType string, buildRegion string, buildID int, arch string) error {
+	if tokenExchanger == nil {
+		return nil
+	}
+	roleArn := tokenReader(buildConfig, executorType)
+	if roleArn == "" {
+		log.Printf("Build %v has no role of its own to read a token secret, passing its token", buildID)
+		return nil
+	}
+	arn, err := tokenExchanger.Exchange(buildRegion, buildID, arch, buildConfig["token"].(string), roleArn)
+	if err != nil {
+		return fmt.Errorf("Got error exchanging build token: %v", err)
+	}
+	buildConfig[buildtoken.ArnKey] = arn
+	return nil
+}
+
+// deletes the token secret of the build
+func revokeToken(buildRegion string, buildID int, arch string) {
+	if tokenExchanger == nil {
+		return
+	}
+	if err := tokenExchanger.Revoke(buildRegion, buildID, arch); err != nil {
+		log.Printf("Revoking token secret of build %v: %v", buildID, err)
+	}
+}
+
+// passes the heartbeat url and token of the build to its launcher
+func addHeartbeatEnvironment(buildConfig map[string]interface{}, buildID int, arch string) {
+	if heartbeats == nil || abortTracker == nil {
+		return
+	}
+	environment, _ := buildConfig[policy.EnvironmentKey].(map[string]string)
+	if environment == nil {
+		environment = map[string]string{}
+	}
+	for name, value := range heartbeats.Env(buildID, arch) {
+		environment[name] = value
+	}
+	buildConfig[policy.EnvironmentKey] = environment
+}
+
+// records that the launcher of the started build is expected to post a heartbeat
+func awaitHeartbeat(buildID int, arch string, value string) {
+	if heartbeats == nil || abortTracker == nil {
+		return
+	}
+	stripped, token, err := message.WithoutToken(value)
+	if err == nil {
+		err = abortTracker.AwaitHeartbeat(buildID, arch, stripped, token)
+	}
+	if err != nil {
+		log.Printf("Recording build %v awaiting its heartbeat: %v", buildID, err)
+	}
+}
+
+// stops and fails the started builds whose launcher did not post a heartbeat within the heartbeat timeout
+func verifyHeartbeats() {
+	if heartbeats == nil || abortTracker == nil {
+		return
+	}
+	records, err := abortTracker.ListAwaitingHeartbeat(pendingVerifyLimit)
+	if err != nil {
+		log.Printf("Listing builds awaiting their heartbeat: %v", err)
+		return
+	}
+	for _, record := range records {
+		if time.Since(time.Unix(record.PendingAt, 0)) < heartbeats.Timeout {
+			continue
+		}
+		verifyHeartbeat(record)
+		if err := abortTracker.Resolve(record.BuildID, record.Architecture); err != nil {
+			log.Printf("Resolving heartbeat of build %v: %v", record.BuildID, err)
+		}
+	}
+}
+
+// stops and fails a build whose launcher never initialized, unless it finished, was stopped meanwhile
+// or its heartbeat arrived after all
+func verifyHeartbeat(record abort.Record) {
+	if record.State == abort.Aborted || record.HeartbeatAt != 0 {
+		return
+	}
+	executor, buildConfig, api, ok := recordBuild(record)
+	if !ok {
+		return
+	}
+	if status, err := executor.Status(buildConfig); err == nil && (status.State == executorState.Succeeded || status.State == executorState.Failed) {
+		log.Printf("Build %v without heartbeat is %v", record.BuildID, status.State)
+		return
+	}
+	log.Printf("Launcher of build %v did not post a heartbeat within %v, stopping the build", record.BuildID, heartbeats.Timeout)
+	if err := executor.Stop(buildConfig); err != nil {
+		log.Printf("Failed to stop build %v without heartbeat: %v", record.BuildID, redact.String(err.Error()))
+	}
+	FailBuild(record.BuildID, fmt.Sprintf("The launcher did not initialize within %v of the start of the build, restart the build", heartbeats.Timeout), api)
+	notifier.Notify(notify.Event{
+		Kind:    notify.ReconcilerKill,
+		Account: policy.ProviderAccount(buildConfig["provider"].(map[string]interface{})),
+		BuildID: record.BuildID,
+		Message: fmt.Sprintf("Stopped build %v whose launcher did not post a heartbeat within %v", record.BuildID, heartbeats.Timeout),
+	})
+}
+
+// response of the heartbeat endpoint with the status and a plain text message
+func heartbeatResponse(status int, message string) events.APIGatewayV2HTTPResponse {
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "text/plain"},
+		Body:       message,
+	}
+}
+
+// HandleHeartbeatRequest records the heartbeats launchers post to the function url or http api of the consumer,
+// authenticated by the heartbeat token of their build
+func HandleHeartbeatRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if heartbeats == nil || abortTracker == nil {
+		return heartbeatResponse(http.StatusServiceUnavailable, "heartbeats are disabled"), nil
+	}
+	if request.RequestContext.HTTP.Method != http.MethodPost {
+		return heartbeatResponse(http.StatusMethodNotAllowed, "heartbeats are posted"), nil
+	}
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return heartbeatResponse(http.StatusBadRequest, "invalid base64 body"), nil
+		}
+		body = decoded
+	}
+	beat, err := heartbeat.Decode(body)
+	if err != nil {
+		return heartbeatResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	// http apis and function urls lowercase the header names
+	token := strings.TrimPrefix(request.Headers["authorization"], "Bearer ")
+	arch := request.QueryStringParameters[heartbeat.ArchitectureParam]
+	if !heartbeats.Verify(beat.BuildID, arch, token) {
+		return heartbeatResponse(http.StatusUnauthorized, "invalid heartbeat token"), nil
+	}
+	found, err := abortTracker.Heartbeat(beat.BuildID, arch, beat.Hostname)
+	if err != nil {
+		log.Printf("Recording heartbeat of build %v: %v", beat.BuildID, err)
+		return heartbeatResponse(http.StatusInternalServerError, "heartbeat not recorded"), nil
+	}
+	if !found {
+		return heartbeatResponse(http.StatusNotFound, "unknown build"), nil
+	}
+	log.Printf("Heartbeat of build %v from %v", beat.BuildID, beat.Hostname)
+	return heartbeatResponse(http.StatusNoContent, ""), nil
+}
+
+// records the stop of the build so that a start still in progress stops it once it completes
+func recordAbort(buildID int, arch string) {
+	if abortTracker == nil {
+		return
+	}
+	starting, err := abortTracker.Abort(buildID, arch)
+	if err != nil {
+		log.Printf("Recording stop of build %v: %v", buildID, err)
+		return
+	}
+	if starting {
+		log.Printf("Build %v is still starting, it is stopped once the start completes", buildID)
+	}
+}
+
+// images of a build as submitted, before they are rewritten to the mirrors of the build region
+type sourceImages struct {
+	container     interface{}
+	launcherImage interface{}
+}
+
+// gets the images of the build before they are rewritten
+func getSourceImages(buildConfig map[string]interface{}) sourceImages {
+	provider := buildConfig["provider"].(map[string]interface{})
+	return sourceImages{container: buildConfig["container"], launcherImage: provider["launcherImage"]}
+}
+
+// resolves the scoped role, image mirrors and token secret of the build for the fallback region, the token secret
+// stays in the build region and only lets the role of the fallback region read it
+func resolveFallback(buildConfig map[string]interface{}, images sourceImages, executorType string, buildRegion string, region string) error {
+	provider := buildConfig["provider"].(map[string]interface{})
+	scopedRole := provider["scopedRole"]
+	if err := applyScopedRole(buildConfig, region); err != nil {
+		return err
+	}
+	if images.container != nil {
+		buildConfig["container"] = images.container
+	}
+	if images.launcherImage != nil {
+		provider["launcherImage"] = images.launcherImage
+	}
+	imageRewriter.RewriteBuildConfig(buildConfig, region)
+	if provider["scopedRole"] == scopedRole || buildConfig[buildtoken.ArnKey] == nil {
+		return nil
+	}
+	buildID, _ := buildConfig["buildId"].(json.Number).Int64()
+	return exchangeToken(buildConfig, executorType, buildRegion, int(buildID), matrix.Architecture(buildConfig))
 }
 
-// IExecutor interface with method definition
-type IExecutor interface {
-	Start(config map[string]interface{}) (string, error)
-	Stop(config map[string]interface{}) error
-	Name() string
+// retries a start failed by a regional outage in the fallback regions of the provider,
+// returns the executor and region of the last attempt with its result
+func startFallback(buildConfig map[string]interface{}, images sourceImages, executorType string, buildRegion string, startErr error) (IExecutor, string, string, error) {
+	provider := buildConfig["provider"].(map[string]interface{})
+	fallbacks, err := failover.Regions(provider, buildRegion)
+	if err != nil {
+		log.Printf("Skipping fallback regions: %v", err)
+		return nil, buildRegion, "", startErr
+	}
+	var executor IExecutor
+	region := buildRegion
+	for _, f := range fallbacks {
+		if !failover.IsRegionalOutage(startErr) {
+			break
+		}
+		if f.Region == region {
+			continue
+		}
+		if err := CheckPolicy(buildConfig, f.Region); err != nil {
+			log.Printf("Skipping fallback region %v: %v", f.Region, err)
+			continue
+		}
+		fallbackExecutor := GetExecutor(executorType, f.Region)
+		if fallbackExecutor == nil {
+			continue
+		}
+		if err := resolveFallback(buildConfig, images, executorType, buildRegion, f.Region); err != nil {
+			log.Printf("Skipping fallback region %v: %v", f.Region, err)
+			continue
+		}
+		log.Printf("Region %v is unavailable, retrying the build in %v: %v", region, f.Region, redact.String(startErr.Error()))
+		f.Apply(buildConfig)
+		executor, region = fallbackExecutor, f.Region
+		var hostname string
+		hostname, startErr = executor.Start(buildConfig)
+		if startErr == nil {
+			return executor, region, hostname, nil
+		}
+	}
+	return executor, region, "", startErr
 }
 
-// List Executors
-var executorsList = func(region string) []IExecutor {
-	return []IExecutor{eksExecutor.New(region), slsExecutor.New(region)}
+// stops the build in the fallback regions of the provider too, a build without start receipt may have failed over
+// to any of them. Stops of builds which never ran in a region are no-ops there.
+func stopFallbacks(buildConfig map[string]interface{}, executorType string, buildRegion string) {
+	provider := buildConfig["provider"].(map[string]interface{})
+	fallbacks, err := failover.Regions(provider, buildRegion)
+	if err != nil {
+		log.Printf("Skipping fallback regions: %v", err)
+		return
+	}
+	for _, f := range fallbacks {
+		if f.Region == buildRegion {
+			continue
+		}
+		executor := GetExecutor(executorType, f.Region)
+		if executor == nil {
+			continue
+		}
+		// the fallback is applied to a copy, the build config keeps its region for the rest of the stop
+		fallbackProvider := make(map[string]interface{}, len(provider))
+		for key, value := range provider {
+			fallbackProvider[key] = value
+		}
+		config := map[string]interface{}{"provider": fallbackProvider}
+		for key, value := range buildConfig {
+			if key != "provider" {
+				config[key] = value
+			}
+		}
+		f.Apply(config)
+		if err := executor.Stop(config); err != nil {
+			log.Printf("Failed to stop build %v in fallback region %v: %v", buildConfig["buildId"], f.Region, redact.String(err.Error()))
+		}
+	}
 }
 
-// GetExecutor selects the executor based on the executor name
-func GetExecutor(name string, region string) IExecutor {
-	var currentExecutor IExecutor
-	executors := executorsList(region)
-	for _, v := range executors {
-		if v.Name() == name {
-			currentExecutor = v
+// forces the executor the deployment policy overrides for the pipeline of the build, so admins move pipelines between
+// executors without changing their config. The message must be valid for the forced executor.
+func applyExecutorOverride(buildMessage *BuildMessage) error {
+	p, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+	executorType, ok := p.ExecutorOverride(buildMessage.BuildConfig)
+	if !ok || executorType == buildMessage.ExecutorType {
+		return nil
+	}
+	pipelineID := buildMessage.BuildConfig["pipelineId"]
+	if _, ok := executorFactories[executorType]; !ok {
+		return fmt.Errorf("executor override %s of pipeline %v is not an executor", executorType, pipelineID)
+	}
+	log.Printf("Overriding executor %v of pipeline %v with %v", buildMessage.ExecutorType, pipelineID, executorType)
+	buildMessage.ExecutorType = executorType
+	if provider, ok := buildMessage.BuildConfig["provider"].(map[string]interface{}); ok && provider["executor"] != nil {
+		provider["executor"] = executorType
+	}
+	if problems := buildMessage.Validate(); len(problems) > 0 {
+		return fmt.Errorf("executor override %s of pipeline %v: %s", executorType, pipelineID, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// fills in and validates the provider with the registry account of its alias
+func applyAccount(buildConfig map[string]interface{}, executorType string) error {
+	provider := buildConfig["provider"].(map[string]interface{})
+	alias, _ := provider[registry.AliasField].(string)
+	if alias == "" {
+		return nil
+	}
+	if accountRegistry == nil {
+		return fmt.Errorf("provider account alias %s is set but the account registry is disabled", alias)
+	}
+	account, err := accountRegistry.Lookup(alias)
+	if err != nil {
+		return fmt.Errorf("Got error looking up provider account %s: %v", alias, err)
+	}
+	if err := account.Apply(provider, executorType); err != nil {
+		return err
+	}
+	// eks connects to the cluster of the build config
+	if buildConfig["clusterName"] == nil && provider["clusterName"] != nil {
+		buildConfig["clusterName"] = provider["clusterName"]
+	}
+	return nil
+}
+
+// replaces the provider fields with the overrides of the pipeline
+func applyPipelineOverrides(buildConfig map[string]interface{}) error {
+	if pipelineOverrides == nil || buildConfig["pipelineId"] == nil {
+		return nil
+	}
+	pipelineID := fmt.Sprint(buildConfig["pipelineId"])
+	o, err := pipelineOverrides.Lookup(pipelineID)
+	if err != nil {
+		return fmt.Errorf("Got error looking up overrides of pipeline %v: %v", pipelineID, err)
+	}
+	if o == nil {
+		return nil
+	}
+	if changed := o.Apply(buildConfig["provider"].(map[string]interface{})); len(changed) > 0 {
+		log.Printf("Overrode provider %v of pipeline %v", strings.Join(changed, ", "), pipelineID)
+	}
+	return nil
+}
+
+// creates the image rewriter when SD_IMAGE_REWRITES is set
+func newImageRewriter() *image.Rewriter {
+	r, err := image.RewriterFromEnv()
+	if err != nil {
+		log.Printf("Image rewrites disabled: %v", err)
+		return nil
+	}
+	return r
+}
+
+// FailBuild calls SD API to set the build status to failure
+func FailBuild(buildID int, statusMessage string, api sd.API) {
+	if apierr := api.UpdateBuildStatus(sd.Failure, buildID, statusMessage); apierr != nil {
+		log.Printf("Updating build status: %v", apierr)
+	}
+}
+
+// gets how long to wait for the executor to report the image pull start, from SD_IMAGE_PULL_TIMEOUT_SECS
+func imagePullTimeout() time.Duration {
+	secs, _ := strconv.Atoi(strings.TrimSpace(os.Getenv("SD_IMAGE_PULL_TIMEOUT_SECS")))
+	return time.Duration(secs) * time.Second
+}
+
+// gets the image pull start time reported by the executor, zero if unknown
+func getImagePullStartTime(executor IExecutor, buildConfig map[string]interface{}) time.Time {
+	reporter, ok := executor.(IImagePullStartTime)
+	if !ok {
+		return time.Time{}
+	}
+	startTime, err := reporter.ImagePullStartTime(buildConfig, imagePullTimeout())
+	if err != nil {
+		log.Printf("Getting image pull start time: %v", err)
+		return time.Time{}
+	}
+	return startTime
+}
+
+// writes the aws resource links of the started build into the build meta
+func reportResourceLinks(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	reporter, ok := executor.(IResourceLinks)
+	if !ok {
+		return
+	}
+	links := reporter.ResourceLinks(buildConfig)
+	if len(links) == 0 {
+		return
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"links": links}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+}
+
+// publishes the receipt of a started build with the arn of the resource running it, a failed write does not fail the build
+func publishReceipt(executor IExecutor, buildConfig map[string]interface{}, executorType string, buildRegion string, buildID int) {
+	if startReceipts == nil {
+		return
+	}
+	var resourceArn string
+	if reporter, ok := executor.(IResourceArn); ok {
+		resourceArn = reporter.ResourceArn(buildConfig)
+	}
+	r := receipt.New(buildID, executorType, resourceArn, buildRegion, time.Now())
+	r.Architecture = matrix.Architecture(buildConfig)
+	r.ProjectName, _ = buildConfig[policy.ProjectNameKey].(string)
+	r.PodNamePrefix, _ = buildConfig[policy.PodNamePrefixKey].(string)
+	if err := startReceipts.Put(r); err != nil {
+		log.Printf("Publishing start receipt of build %v: %v", buildID, err)
+	}
+}
+
+// gets the receipt of the start of the build being stopped and routes the stop to the executor the build started on,
+// nil when receipts are disabled or the build has none
+func stopReceipt(buildMessage *BuildMessage) *receipt.Receipt {
+	if startReceipts == nil {
+		return nil
+	}
+	buildConfig := buildMessage.BuildConfig
+	buildID, err := json.Number(fmt.Sprint(buildConfig["buildId"])).Int64()
+	if err != nil {
+		return nil
+	}
+	r, err := startReceipts.Get(int(buildID), matrix.Architecture(buildConfig))
+	if err != nil {
+		log.Printf("Reading start receipt of build %v, stopping it with its message: %v", buildID, err)
+		return nil
+	}
+	if r == nil {
+		return nil
+	}
+	if _, ok := executorFactories[r.Executor]; ok && r.Executor != buildMessage.ExecutorType {
+		log.Printf("Build %v started on executor %v, stopping it there", buildID, r.Executor)
+		buildMessage.ExecutorType = r.Executor
+		if provider := buildConfig["provider"].(map[string]interface{}); provider["executor"] != nil {
+			provider["executor"] = r.Executor
+		}
+	}
+	return r
+}
+
+// moves the build config of the message into the region, with the provider fields of the fallback region of that name
+func moveToRegion(buildMessage *BuildMessage, region string) {
+	provider := buildMessage.BuildConfig["provider"].(map[string]interface{})
+	fallbacks, _ := failover.Regions(provider, buildMessage.BuildRegion())
+	for _, f := range fallbacks {
+		if f.Region == region {
+			f.Apply(buildMessage.BuildConfig)
+			return
 		}
 	}
+	failover.Fallback{Region: region}.Apply(buildMessage.BuildConfig)
+}
+
+// gets how long to wait for the executor to report the debug session of a build, from SD_DEBUG_SESSION_TIMEOUT_SECS
+func debugSessionTimeout() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SD_DEBUG_SESSION_TIMEOUT_SECS")))
+	if err != nil {
+		return 2 * time.Minute
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// debug sessions being reported, waited for before a request completes
+var debugSessions sync.WaitGroup
+
+// reports the debug session of the started build in the background, as the session can take minutes to come up
+func reportDebugSessionAsync(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	if _, ok := executor.(IDebugSession); !ok {
+		return
+	}
+	debugSessions.Add(1)
+	go func() {
+		defer debugSessions.Done()
+		reportDebugSession(executor, buildConfig, buildID, api)
+	}()
+}
+
+// writes the debug session connection details of the started build into the build meta and status message
+func reportDebugSession(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	reporter, ok := executor.(IDebugSession)
+	if !ok {
+		return
+	}
+	session, err := reporter.DebugSession(buildConfig, debugSessionTimeout())
+	if err != nil {
+		log.Printf("Getting debug session of build %v: %v", buildID, err)
+		return
+	}
+	if session.Target == "" {
+		return
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"debugSession": session}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+	statusMessage := fmt.Sprintf("Debug session ready, connect with: %s", session.Command)
+	if !session.ExpiresAt.IsZero() {
+		statusMessage += fmt.Sprintf(" (expires at %s)", session.ExpiresAt.In(utcLoc).Format(time.RFC3339))
+	}
+	if apierr := api.UpdateBuild(nil, buildID, statusMessage); apierr != nil {
+		log.Printf("Updating build status message: %v", apierr)
+	}
+}
+
+// reports the cache stats of a finished build into its SD stats, before a stop can delete the resources of the build
+func reportCacheStats(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	reporter, ok := executor.(ICacheStats)
+	if !ok {
+		return
+	}
+	stats := reporter.CacheStats(buildConfig)
+	if len(stats) == 0 {
+		return
+	}
+	if apierr := api.UpdateBuild(stats, buildID, ""); apierr != nil {
+		log.Printf("Updating cache stats of build %v: %v", buildID, apierr)
+	}
+}
+
+// reports the exit codes of the containers of a finished build into its SD stats and explains OOMKilled or failed
+// containers in its status message, before a stop deletes them
+func reportContainerExits(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	reporter, ok := executor.(IContainerExits)
+	if !ok {
+		return
+	}
+	exits, err := reporter.ContainerExits(buildConfig)
+	if err != nil {
+		log.Printf("Getting container exits of build %v: %v", buildID, err)
+		return
+	}
+	if len(exits) == 0 {
+		return
+	}
+	stats := map[string]interface{}{"containerExits": exits}
+	if apierr := api.UpdateBuild(stats, buildID, executorState.ExitMessage(exits)); apierr != nil {
+		log.Printf("Updating container exits of build %v: %v", buildID, apierr)
+	}
+}
+
+// writes the sizing recommendation of the build from its cpu and memory usage into the build meta
+func reportSizingRecommendation(executor IExecutor, buildConfig map[string]interface{}, buildID int, api sd.API) {
+	recommender, ok := executor.(ISizingRecommendation)
+	if !ok {
+		return
+	}
+	recommendation, err := recommender.SizingRecommendation(buildConfig)
+	if err != nil {
+		log.Printf("Getting sizing recommendation of build %v: %v", buildID, err)
+		return
+	}
+	if len(recommendation) == 0 {
+		return
+	}
+	meta := map[string]interface{}{"aws": map[string]interface{}{"sizing": recommendation}}
+	if apierr := api.UpdateBuildMeta(meta, buildID); apierr != nil {
+		log.Printf("Updating build meta: %v", apierr)
+	}
+}
 
-	return currentExecutor
+// gets the additional stats reported by the executor, nil if none
+func getBuildStats(executor IExecutor, buildConfig map[string]interface{}) map[string]interface{} {
+	reporter, ok := executor.(IBuildStats)
+	if !ok {
+		return nil
+	}
+	return reporter.BuildStats(buildConfig)
 }
 
-// UpdateBuildStats calls SD API to update stats
-func UpdateBuildStats(hostname string, buildID int, api sd.API) {
+// UpdateBuildStats calls SD API to update stats merged with the executor stats, a zero imagePullStartTime is reported as now
+func UpdateBuildStats(hostname string, imagePullStartTime time.Time, executorStats map[string]interface{}, buildID int, api sd.API) {
 	if hostname != "" { // update SD stats
+		if imagePullStartTime.IsZero() {
+			imagePullStartTime = time.Now()
+		}
 		stats := map[string]interface{}{
 			"hostname":           hostname,
-			"imagePullStartTime": time.Now().In(utcLoc),
+			"imagePullStartTime": imagePullStartTime.In(utcLoc),
+		}
+		for k, v := range executorStats {
+			stats[k] = v
+		}
+		// the queue merges the stats of all architectures of a build, they are queued with their prefix
+		if archAPI, ok := api.(*matrix.API); ok {
+			stats, api = archAPI.Stats(stats), archAPI.API
+		}
+		if updateQueue != nil {
+			updateQueue.Add(api, stats, buildID, "")
+			return
 		}
 		if apierr := api.UpdateBuild(stats, int(buildID), ""); apierr != nil {
 			log.Printf("Updating build stats: %v", apierr)
@@ -70,110 +1699,442 @@ func UpdateBuildStats(hostname string, buildID int, api sd.API) {
 	}
 }
 
-// ProcessMessage receives messages from the kafka broker endpoint and processes them
-var ProcessMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
-	defer recoverPanic()
-	defer wg.Done()
+// decodes a base64 encoded or plain json build message
+func decodeMessage(value string) (*BuildMessage, error) {
+	data, err := message.DecodeValue(value)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("Processing id: %v", id)
-	str, err := base64.StdEncoding.DecodeString(value)
+	return message.Decode(data)
+}
+
+// fails the build of a start message rejected before it reaches an executor, the build config may be incomplete
+// so builds without a build id, api or token are only logged
+func failRejectedStart(buildMessage *BuildMessage, statusMessage string) {
+	if buildMessage.Job != "start" {
+		return
+	}
+	buildConfig := buildMessage.BuildConfig
+	buildID, err := json.Number(fmt.Sprint(buildConfig["buildId"])).Int64()
+	apiURI, _ := buildConfig["apiUri"].(string)
+	token, _ := buildConfig["token"].(string)
+	if err != nil || apiURI == "" || token == "" {
+		log.Printf("Cannot fail build %v without buildId, apiUri and token: %v", buildConfig["buildId"], statusMessage)
+		return
+	}
+	api, err := api(apiURI, token)
 	if err != nil {
-		log.Printf("key: %v Base64 Decode Error:%v", id, err)
+		log.Printf("Failing build %v: %v", buildID, err)
+		return
 	}
+	FailBuild(int(buildID), statusMessage, api)
+}
 
-	message := string(str)
+// processes the messages of the builds of a multi-architecture build, set in init as ProcessMessage refers to it
+var processMessage func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error
 
-	log.Printf("executor processing:%v", message)
+func init() {
+	processMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
+		return ProcessMessage(id, value, wg, ctx)
+	}
+}
 
-	buildMesage := &BuildMessage{
-		BuildConfig: map[string]interface{}{
-			//default values
-			"container":          "aws/codebuild/standard:5.0",
-			"serviceAccountName": "default",
-		},
+// processes the message of a multi-architecture build as the messages of a build per architecture, running at the
+// same time, returns false for the messages of other builds
+func processArchitectures(id int, buildMessage *BuildMessage, ctx context.Context) bool {
+	buildConfig := buildMessage.BuildConfig
+	architectures, err := matrix.Architectures(buildConfig["provider"].(map[string]interface{}))
+	if err == nil && architectures == nil {
+		return false
+	}
+	var configs []map[string]interface{}
+	if err == nil {
+		configs, err = matrix.Expand(buildConfig, architectures)
+	}
+	if err != nil {
+		log.Printf("Rejecting %v message: %v", buildMessage.Job, err)
+		failRejectedStart(buildMessage, err.Error())
+		return true
+	}
+	log.Printf("Processing %v message for architectures %v", buildMessage.Job, strings.Join(architectures, ", "))
+	var wg sync.WaitGroup
+	for _, config := range configs {
+		value, err := json.Marshal(&BuildMessage{Job: buildMessage.Job, BuildConfig: config, ExecutorType: buildMessage.ExecutorType})
+		if err != nil {
+			log.Printf("Encoding %v message for %v: %v", buildMessage.Job, matrix.Architecture(config), err)
+			continue
+		}
+		wg.Add(1)
+		go processMessage(id, string(value), &wg, ctx)
 	}
+	wg.Wait()
+	return true
+}
+
+// ProcessMessage receives messages from the kafka broker endpoint and processes them
+var ProcessMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
+	defer wg.Done()
+	// deferred last so that a panicking build is failed before the batch completes
+	target := &panicTarget{}
+	defer recoverPanic(target)
 
-	decoder := json.NewDecoder(strings.NewReader(message))
-	decoder.UseNumber()
-	if err := decoder.Decode(&buildMesage); err != nil {
-		log.Fatal(err)
+	log.Printf("Processing id: %v", id)
+	buildMesage, err := decodeMessage(value)
+	if err != nil {
+		// a malformed record is skipped, the other records of the batch are still processed
+		log.Printf("key: %v %v", id, err)
+		return fmt.Errorf("Got error decoding record %v: %v", id, err)
+	}
+	if buildMesage.Job == "diagnostics" {
+		dumpDiagnostics()
+		return nil
+	}
+	if buildMesage.Job == "cost-report" {
+		reportCosts()
+		return nil
 	}
 	buildConfig := buildMesage.BuildConfig
+	// pathological messages are rejected before they reach the AWS APIs
+	if problems := buildMesage.CheckLimits(); len(problems) > 0 {
+		err := message.LimitsError(problems)
+		log.Printf("Rejecting %v message: %v", buildMesage.Job, err)
+		failRejectedStart(buildMesage, err.Error())
+		return nil
+	}
+	// messages without a provider are rejected, there is no executor to process them
+	if _, ok := buildConfig["provider"].(map[string]interface{}); !ok {
+		problem := "buildConfig.provider is required"
+		if buildConfig["provider"] != nil {
+			problem = "buildConfig.provider must be an object"
+		}
+		err := executorState.Errorf(executorState.UserError, problem)
+		log.Printf("Rejecting %v message: %v", buildMesage.Job, err)
+		failRejectedStart(buildMesage, executorState.StatusMessage(err))
+		return nil
+	}
+	// stops are processed while paused so running builds can be drained
+	if buildMesage.Job == "start" && pauseStart(ctx, value, buildMesage) {
+		return nil
+	}
+	if processArchitectures(id, buildMesage, ctx) {
+		return nil
+	}
 	provider := buildConfig["provider"].(map[string]interface{})
-
-	messageProviderDefaults := `{
-		"executorLogs":             false,
-		"dlc":                      false,
-		"privilegedMode":           false,
-		"prune":                    true,
-		"imagePullCredentialsType": "SERVICE_ROLE",
-		"launcherEnvironmentType":  "LINUX_CONTAINER",
-		"environmentType":          "LINUX_CONTAINER",
-		"computeType":              "BUILD_GENERAL1_SMALL",
-		"queuedTimeout":            5,
-		"launcherComputeType":      "BUILD_GENERAL1_SMALL",
-		"buildRegion" : 			"",
-		"debugSession" : 			false
-	}`
-
-	var providerDefaults map[string]interface{}
-	pDecoder := json.NewDecoder(strings.NewReader(messageProviderDefaults))
-	pDecoder.UseNumber()
-	if err := pDecoder.Decode(&providerDefaults); err != nil {
-		log.Fatal(err)
+	// executor overrides move the starts of pipelines, the other jobs of a build go to the executor it started on
+	var started *receipt.Receipt
+	switch buildMesage.Job {
+	case "start":
+		err = applyExecutorOverride(buildMesage)
+	case "stop":
+		// stops go where the build started, which the message does not know after a failover or an executor override
+		started = stopReceipt(buildMesage)
 	}
-
-	for k, v := range providerDefaults {
-		if provider[k] == nil {
-			provider[k] = v
+	if err == nil {
+		err = applyAccount(buildConfig, buildMesage.ExecutorType)
+	}
+	if err == nil {
+		// annotations are applied for every job, stop needs the architecture of the launcher bundle
+		err = annotations.Apply(buildConfig)
+	}
+	if err == nil {
+		// the overrides of admins win over the annotations of the job
+		err = applyPipelineOverrides(buildConfig)
+		// the other jobs of a build are not dropped when the overrides can't be looked up
+		if err != nil && buildMesage.Job != "start" {
+			log.Printf("Processing %v of build %v without pipeline overrides: %v", buildMesage.Job, buildConfig["buildId"], err)
+			err = nil
 		}
 	}
-	buildConfig["provider"] = provider
+	if err == nil && buildMesage.Job == "start" {
+		err = applyNaming(buildConfig)
+	} else if err == nil {
+		// every job finds the codebuild project by its name
+		err = applyJobNaming(buildConfig, started)
+	}
+	if err != nil {
+		log.Printf("Failed to resolve provider: %v", err)
+		failRejectedStart(buildMesage, err.Error())
+		return nil
+	}
 
 	job := buildMesage.Job
 	executorType := buildMesage.ExecutorType
 
-	log.Printf("Job Type: %v, Executor: %v, Build Config: %#v", job, executorType, buildConfig)
+	log.Printf("Job Type: %v, Executor: %v, Build Config: %#v", job, executorType, redact.Map(buildConfig))
 
-	buildRegion := provider["buildRegion"].(string)
-	if buildRegion == "" {
-		buildRegion = provider["region"].(string)
+	buildRegion := buildMesage.BuildRegion()
+	// the token secret stays in the build region when the start fails over
+	tokenRegion := buildRegion
+	arch := matrix.Architecture(buildConfig)
+	if started != nil && started.Region != "" && started.Region != buildRegion {
+		log.Printf("Build %v started in region %v, stopping it there", buildConfig["buildId"], started.Region)
+		moveToRegion(buildMesage, started.Region)
+		buildRegion = started.Region
 	}
 
 	if executorType != "" && job != "" {
 		var hostname string
+		var imagePullStartTime time.Time
+		var executorStats map[string]interface{}
+		// region the build was started in before failing over to a fallback region
+		var failedOverFrom string
+		// images of the build before they are rewritten to the mirrors of the build region
+		var images sourceImages
+		// whether the executor accepted the start, the token secret of other starts is revoked
+		var accepted bool
+		buildID, _ := buildConfig["buildId"].(json.Number).Int64()
+		api, err := api(buildConfig["apiUri"].(string), buildConfig["token"].(string))
+		if err != nil {
+			// the build can't be updated without a client, the message is skipped
+			log.Printf("Failed to %v build %v: Got error creating api client: %v", job, buildID, err)
+			return fmt.Errorf("Got error creating api client of build %v: %v", buildID, err)
+		}
+		api = matrix.WrapAPI(api, buildConfig)
+		target.job, target.buildID, target.api = job, int(buildID), api
+
+		if job == "start" {
+			if err := awsconfig.ValidateRegion(buildRegion); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			if err := launcher.Select(provider, executorType); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			if err := sizing.Apply(provider, executorType); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			if err := CheckPolicy(buildConfig, buildRegion); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), executorState.StatusMessage(err), api)
+				return nil
+			}
+			if err := CheckBudget(buildConfig); err != nil {
+				if executorState.CategoryOf(err) != executorState.InfraTransient {
+					log.Printf("Failed to start build %v: %v", buildID, err)
+					FailBuild(int(buildID), executorState.StatusMessage(err), api)
+					return nil
+				}
+				if requeueStart(ctx, value, int(buildID), api, err) {
+					return nil
+				}
+				// builds are not rejected for a budget which cannot be checked
+				log.Printf("Not checking the budget of build %v: %v", buildID, err)
+			}
+			if err := applyEnvironmentPolicy(buildConfig); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			addHeartbeatEnvironment(buildConfig, int(buildID), arch)
+			if err := applyScopedRole(buildConfig, buildRegion); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			images = getSourceImages(buildConfig)
+			imageRewriter.RewriteBuildConfig(buildConfig, buildRegion)
+			if err := CheckImageScan(buildConfig); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			if err := exchangeToken(buildConfig, executorType, buildRegion, int(buildID), arch); err != nil {
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				FailBuild(int(buildID), err.Error(), api)
+				return nil
+			}
+			// rejected, skipped, requeued and aborted starts leave no token secret behind
+			defer func() {
+				if !accepted {
+					revokeToken(tokenRegion, int(buildID), arch)
+				}
+			}()
+			reportEffectiveConfig(buildConfig, executorType, buildRegion, int(buildID), api)
+		}
+
 		executor := GetExecutor(executorType, buildRegion)
+		if job == "describe" || job == "status" || job == "logs" || job == "cleanup" || job == "list" {
+			if executor == nil {
+				log.Printf("Unknown executor %v for build %v", executorType, buildID)
+				return nil
+			}
+			switch job {
+			case "describe":
+				reportCapabilities(executor, buildConfig, buildRegion, int(buildID), api)
+			case "status":
+				reportStatus(executor, buildConfig, int(buildID), api)
+			case "list":
+				reportResources(executor, buildConfig, int(buildID), api)
+			case "logs":
+				pushLogs(executor, buildConfig, int(buildID))
+			case "cleanup":
+				// the job is archived, the build is not touched
+				if err := executor.Cleanup(buildConfig); err != nil {
+					log.Printf("Failed to clean up job of build %v: %v", buildID, err)
+				}
+			}
+			return nil
+		}
+		if preflight, ok := executor.(IPreflight); ok && job == "start" && preflightEnabled() {
+			if err := preflight.Preflight(buildConfig); err != nil {
+				if executorState.CategoryOf(err) == executorState.InfraTransient && requeueStart(ctx, value, int(buildID), api, err) {
+					return nil
+				}
+				log.Printf("Failed to start build %v: %v", buildID, err)
+				statusMessage := fmt.Sprintf("Pre-flight check failed: %v", err)
+				FailBuild(int(buildID), statusMessage, api)
+				uploadStartDiagnostics(executor, buildConfig, buildRegion, int(buildID), err, statusMessage)
+				notifyStartFailure(buildConfig, int(buildID), err)
+				return nil
+			}
+		}
+		labels := map[string]string{"executor": executorType, "region": buildRegion}
 		switch string(job) {
 		case "start":
+			if beginStart(int(buildID), arch) {
+				log.Printf("Build %v was stopped before it started, skipping start", buildID)
+				return nil
+			}
+			stopWatch := watchDeadline(ctx, int(buildID), arch, value)
 			hostname, err = executor.Start(buildConfig)
+			if failover.IsRegionalOutage(err) {
+				var fallbackExecutor IExecutor
+				var fallbackRegion string
+				fallbackExecutor, fallbackRegion, hostname, err = startFallback(buildConfig, images, executorType, buildRegion, err)
+				if fallbackExecutor != nil {
+					executor, failedOverFrom, buildRegion = fallbackExecutor, buildRegion, fallbackRegion
+					labels["region"] = buildRegion
+				}
+			}
+			stopWatch()
+			category := executorState.CategoryOf(err)
+			if (failover.IsRegionalOutage(err) || category == executorState.InfraTransient) && requeueStart(ctx, value, int(buildID), api, err) {
+				return nil
+			}
+			// builds which can't start without a fix fail with the reason, like a rejection by a cluster policy,
+			// they never get to report a status themselves
+			switch category {
+			case executorState.UserError, executorState.InfraPermanent, executorState.Policy:
+				FailBuild(int(buildID), executorState.StatusMessage(err), api)
+			}
+			if err != nil {
+				uploadStartDiagnostics(executor, buildConfig, buildRegion, int(buildID), err, executorState.StatusMessage(err))
+			}
+			// builds failing by their own config are no systemic problem
+			if err != nil && category != executorState.UserError && category != executorState.Policy {
+				notifyStartFailure(buildConfig, int(buildID), err)
+			}
+			if err == nil && abortIfStopped(executor, buildConfig, int(buildID), arch) {
+				buildsAborted.Inc(labels)
+				return nil
+			}
+			if err == nil {
+				accepted = true
+				awaitHeartbeat(int(buildID), arch, value)
+			}
 		case "stop":
+			recordAbort(int(buildID), arch)
+			reportCacheStats(executor, buildConfig, int(buildID), api)
+			reportContainerExits(executor, buildConfig, int(buildID), api)
+			reportSizingRecommendation(executor, buildConfig, int(buildID), api)
 			err = executor.Stop(buildConfig)
+			if started == nil {
+				stopFallbacks(buildConfig, executorType, buildRegion)
+			}
+			revokeToken(tokenRegion, int(buildID), arch)
 		}
 		if err != nil {
-			log.Printf("Failed to %v build %v", job, err)
+			log.Printf("Failed to %v build %v", job, redact.String(err.Error()))
+			buildFailures.Inc(map[string]string{"executor": executorType, "region": buildRegion, "job": job})
 		} else {
 			log.Printf("%v build successful", job)
+			if job == "start" {
+				buildsStarted.Inc(labels)
+			} else {
+				buildsStopped.Inc(labels)
+			}
+			if job == "start" {
+				reportResourceLinks(executor, buildConfig, int(buildID), api)
+				publishReceipt(executor, buildConfig, executorType, buildRegion, int(buildID))
+				reportDebugSessionAsync(executor, buildConfig, int(buildID), api)
+				emitStartLatency(ctx, executor, buildConfig, map[string]string{"Executor": executorType, "Region": buildRegion})
+				imagePullStartTime = getImagePullStartTime(executor, buildConfig)
+				executorStats = getBuildStats(executor, buildConfig)
+				if failedOverFrom != "" {
+					if executorStats == nil {
+						executorStats = map[string]interface{}{}
+					}
+					executorStats["buildRegion"] = buildRegion
+					executorStats["failedOverFrom"] = failedOverFrom
+				}
+			}
 		}
-		buildID, _ := buildConfig["buildId"].(json.Number).Int64()
-		api, _ := api(buildConfig["apiUri"].(string), buildConfig["token"].(string))
-		UpdateBuildStats(hostname, int(buildID), api)
+		UpdateBuildStats(hostname, imagePullStartTime, executorStats, int(buildID), api)
 	}
 
 	return nil
 }
 
-// recovers panic
-func recoverPanic() {
+// build a recovered panic is reported to, filled in once the message is decoded
+type panicTarget struct {
+	job     string
+	buildID int
+	api     sd.API
+}
+
+// uploads stacktraces and diagnostics to s3
+var uploadObject = func(bucket string, key string, body []byte) error {
+	sess, err := awsconfig.NewSession("")
+	if err != nil {
+		return err
+	}
+	_, err = s3.New(sess).PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	return err
+}
+
+// recovers panic, uploading the stacktrace to SD_STACKTRACE_BUCKET and failing the started build
+func recoverPanic(target *panicTarget) {
 	if p := recover(); p != nil {
-		filename := fmt.Sprintf("stacktrace-%s", time.Now().Format(time.RFC3339))
+		now := time.Now()
+		stacktrace := debug.Stack()
+		filename := fmt.Sprintf("stacktrace-%s", now.Format(time.RFC3339))
 		tracefile := filepath.Join(os.TempDir(), filename)
 
 		log.Printf("ERROR: Internal Screwdriver error. Please file a bug about this: %v", p)
 		log.Printf("ERROR: Writing StackTrace to %s", tracefile)
-		err := ioutil.WriteFile(tracefile, debug.Stack(), 0600)
+		err := os.WriteFile(tracefile, stacktrace, 0600)
 		if err != nil {
 			log.Printf("ERROR: Unable to write stacktrace to file: %v", err)
 		}
+
+		var location string
+		if bucket := os.Getenv("SD_STACKTRACE_BUCKET"); bucket != "" {
+			key := fmt.Sprintf("stacktraces/%d/%s", target.buildID, now.UTC().Format(time.RFC3339Nano))
+			if err := uploadObject(bucket, key, stacktrace); err != nil {
+				log.Printf("ERROR: Unable to upload stacktrace to %s: %v", bucket, err)
+			} else {
+				location = fmt.Sprintf("s3://%s/%s", bucket, key)
+				log.Printf("ERROR: Uploaded StackTrace to %s", location)
+			}
+		}
+
+		if target.job == "start" && target.api != nil {
+			statusMessage := "Internal error in aws consumer service"
+			if location != "" {
+				statusMessage += ", stacktrace: " + location
+			}
+			FailBuild(target.buildID, statusMessage, target.api)
+		}
 	}
 }
 
@@ -186,6 +2147,59 @@ func finalRecover() {
 	}
 }
 
+// archives the records of a partition before they are processed, a failed upload does not hold up the builds
+func archiveRecords(records []events.KafkaRecord) {
+	if recordArchive == nil || len(records) == 0 {
+		return
+	}
+	key, err := recordArchive.Archive(records)
+	if err != nil {
+		log.Printf("Failed to archive %v records of partition %v: %v", len(records), records[0].Partition, err)
+		return
+	}
+	log.Printf("Archived %v records to %v", len(records), key)
+}
+
+// gets the job and executor type of a kafka record from its headers, empty when the producer did not set them
+func recordRoute(record events.KafkaRecord) (string, string) {
+	var job, executorType string
+	for _, header := range record.Headers {
+		if value, ok := header[jobHeader]; ok && job == "" {
+			job = string(value)
+		}
+		if value, ok := header[executorHeader]; ok && executorType == "" {
+			executorType = string(value)
+		}
+	}
+	return job, executorType
+}
+
+// splits the records of a partition into the waves they are processed in by the job of their headers.
+// Records of an executor the consumer does not know are dropped without decoding their value.
+// With the idempotency table, stops and cleanups are processed before the starts which may take up to the lambda
+// timeout: the table records the stop, so the start of the same build later in the batch is skipped instead of
+// leaving the build running. Without it all records are processed in one wave, a stop moved ahead of the start
+// of its build would not stop it. Records without headers are processed with the starts.
+func routeRecords(records []events.KafkaRecord) [][]int {
+	var first, rest []int
+	for i, record := range records {
+		job, executorType := recordRoute(record)
+		if _, ok := executorFactories[executorType]; executorType != "" && !ok {
+			log.Printf("Record: offset %v of unknown executor %v, skipping", record.Offset, executorType)
+			continue
+		}
+		if abortTracker != nil && (job == "stop" || job == "cleanup") {
+			first = append(first, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+	if first == nil {
+		return [][]int{rest}
+	}
+	return [][]int{first, rest}
+}
+
 // HandleRequest is a go lambda request handler with event type map[string][]KafkaRecord
 func HandleRequest(ctx context.Context, request events.KafkaEvent) (string, error) {
 	eventSize := unsafe.Sizeof(request)
@@ -193,26 +2207,180 @@ func HandleRequest(ctx context.Context, request events.KafkaEvent) (string, erro
 
 	defer finalRecover()
 
+	verifyPendingStarts()
+	verifyHeartbeats()
+
 	var totalRecords int
 	for k, record := range request.Records {
-		var wg sync.WaitGroup
 		count := len(record)
 		totalRecords += count
 		log.Printf("Received %v records for key %v", count, k)
-		wg.Add(count)
-		for i := 0; i < count; i++ {
-			log.Printf("Record: %v", record[i])
-			go ProcessMessage(i, record[i].Value, &wg, ctx)
+		archiveRecords(record)
+		for _, wave := range routeRecords(record) {
+			var wg sync.WaitGroup
+			wg.Add(len(wave))
+			for _, i := range wave {
+				log.Printf("Record: topic %v, partition %v, offset %v", record[i].Topic, record[i].Partition, record[i].Offset)
+				recordCtx := context.WithValue(ctx, enqueuedAtKey, record[i].Timestamp.Time)
+				if !record[i].Timestamp.IsZero() {
+					kafkaLag.Observe(time.Since(record[i].Timestamp.Time).Seconds(), map[string]string{"topic": record[i].Topic})
+				}
+				go ProcessMessage(i, record[i].Value, &wg, recordCtx)
+			}
+			wg.Wait()
 		}
-		wg.Wait()
-
+	}
+	debugSessions.Wait()
+	if updateQueue != nil {
+		updateQueue.Flush()
 	}
 	log.Printf("Finished processing %v records", totalRecords)
 
 	return fmt.Sprintf("Finished processing messages: %v", totalRecords), nil
 }
 
+// HandleQueueRequest processes the build messages requeued to the delay queue
+func HandleQueueRequest(ctx context.Context, request events.SQSEvent) (string, error) {
+	defer finalRecover()
+
+	var wg sync.WaitGroup
+	wg.Add(len(request.Records))
+	for i, record := range request.Records {
+		attempt := 0
+		if a, ok := record.MessageAttributes[requeue.AttemptAttribute]; ok && a.StringValue != nil {
+			attempt, _ = strconv.Atoi(*a.StringValue)
+		}
+		log.Printf("Record: message %v, attempt %v", record.MessageId, attempt)
+		go ProcessMessage(i, record.Body, &wg, context.WithValue(ctx, attemptKey, attempt))
+	}
+	wg.Wait()
+	debugSessions.Wait()
+	if updateQueue != nil {
+		updateQueue.Flush()
+	}
+	log.Printf("Finished processing %v requeued records", len(request.Records))
+
+	return fmt.Sprintf("Finished processing messages: %v", len(request.Records)), nil
+}
+
+// serves the prometheus metrics and optionally pprof on SD_METRICS_LISTEN_ADDR for long running consumers
+func serveMetrics() {
+	addr := os.Getenv("SD_METRICS_LISTEN_ADDR")
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	if enabled, _ := strconv.ParseBool(os.Getenv("SD_PPROF_ENABLED")); enabled {
+		diagnostics.Register(mux)
+	}
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Serving metrics on %v: %v", addr, err)
+		}
+	}()
+}
+
+// uploads the runtime diagnostics to SD_DIAGNOSTICS_BUCKET (or SD_STACKTRACE_BUCKET), logging them when no bucket is set
+func dumpDiagnostics() {
+	files, err := diagnostics.Collect()
+	if err != nil {
+		log.Printf("Collecting diagnostics: %v", err)
+		return
+	}
+	bucket := os.Getenv("SD_DIAGNOSTICS_BUCKET")
+	if bucket == "" {
+		bucket = os.Getenv("SD_STACKTRACE_BUCKET")
+	}
+	if bucket == "" {
+		log.Printf("Diagnostics memstats: %s", files["memstats.json"])
+		log.Printf("Diagnostics goroutines:\n%s", files["goroutines.txt"])
+		return
+	}
+
+	prefix := "diagnostics/" + time.Now().UTC().Format(time.RFC3339Nano)
+	for name, body := range files {
+		if err := uploadObject(bucket, prefix+"/"+name, body); err != nil {
+			log.Printf("Uploading diagnostics %v: %v", name, err)
+			continue
+		}
+		log.Printf("Uploaded diagnostics to s3://%s/%s/%s", bucket, prefix, name)
+	}
+}
+
+// reports the month to date costs of the pipelines to SD_COST_REPORT_BUCKET as cost-reports/<YYYY-MM>.json,
+// logging the report when no bucket is set
+func reportCosts() {
+	summary, err := costReporter.Report()
+	if err != nil {
+		log.Printf("Reporting costs: %v", err)
+		return
+	}
+	recordSpend(summary)
+	body, err := json.Marshal(summary)
+	if err != nil {
+		log.Printf("Encoding cost report: %v", err)
+		return
+	}
+	bucket := os.Getenv("SD_COST_REPORT_BUCKET")
+	if bucket == "" {
+		log.Printf("Cost report: %s", body)
+		return
+	}
+	key := "cost-reports/" + summary.Month + ".json"
+	if err := uploadObject(bucket, key, body); err != nil {
+		log.Printf("Uploading cost report: %v", err)
+		return
+	}
+	log.Printf("Uploaded cost report of %v pipelines to s3://%s/%s", len(summary.Pipelines), bucket, key)
+}
+
+// records the month to date costs of the report as the budget spend of the pipelines and accounts, adding the
+// cost since the spend was last recorded
+func recordSpend(summary *cost.Summary) {
+	if budgetTracker == nil {
+		return
+	}
+	type spend struct {
+		scope string
+		id    string
+		cost  float64
+	}
+	var spends []spend
+	for _, p := range summary.Pipelines {
+		spends = append(spends, spend{policy.PipelineScope, p.PipelineID, p.Cost})
+	}
+	for _, a := range summary.Accounts {
+		spends = append(spends, spend{policy.AccountScope, a.AccountID, a.Cost})
+	}
+	for _, s := range spends {
+		spent, err := budgetTracker.Spend(s.scope, s.id, summary.Month)
+		if err != nil {
+			log.Printf("Got error reading spend of %s %s: %v", s.scope, s.id, err)
+			continue
+		}
+		// the reported costs only grow within the month
+		if s.cost <= spent {
+			continue
+		}
+		if err := budgetTracker.Add(s.scope, s.id, summary.Month, s.cost-spent); err != nil {
+			log.Printf("Got error recording spend of %s %s: %v", s.scope, s.id, err)
+		}
+	}
+}
+
 // main function for go lambda
 func main() {
+	serveMetrics()
+	// the consumer of the delay queue runs the same binary
+	if os.Getenv("SD_CONSUMER_SOURCE") == "sqs" {
+		lambda.Start(HandleQueueRequest)
+		return
+	}
+	// the heartbeat endpoint runs the same binary too
+	if os.Getenv("SD_CONSUMER_SOURCE") == "heartbeat" {
+		lambda.Start(HandleHeartbeatRequest)
+		return
+	}
 	lambda.Start(HandleRequest)
 }
@@ -1,12 +1,44 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/screwdriver-cd/aws-consumer-service/abort"
+	"github.com/screwdriver-cd/aws-consumer-service/buildtoken"
+	"github.com/screwdriver-cd/aws-consumer-service/cost"
+	"github.com/screwdriver-cd/aws-consumer-service/diagnostics"
+	executorState "github.com/screwdriver-cd/aws-consumer-service/executor"
+	"github.com/screwdriver-cd/aws-consumer-service/heartbeat"
+	"github.com/screwdriver-cd/aws-consumer-service/image"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/message"
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+	"github.com/screwdriver-cd/aws-consumer-service/notify"
+	"github.com/screwdriver-cd/aws-consumer-service/overrides"
+	"github.com/screwdriver-cd/aws-consumer-service/policy"
+	"github.com/screwdriver-cd/aws-consumer-service/receipt"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/registry"
+	"github.com/screwdriver-cd/aws-consumer-service/role"
 	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+	"github.com/screwdriver-cd/aws-consumer-service/screwdriver/sdtest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,7 +51,8 @@ type mockEksExecutor struct {
 	name string
 }
 type mockSlsExecutor struct {
-	name string
+	name   string
+	region string
 }
 
 func (e *mockEksExecutor) Name() string {
@@ -34,7 +67,13 @@ var stopFn string
 var startSlsFn string
 var stopSlsFn string
 
+// error the mock eks executor fails to start builds with
+var startEksErr error
+
 func (e *mockEksExecutor) Start(config map[string]interface{}) (string, error) {
+	if startEksErr != nil {
+		return "", startEksErr
+	}
 	startFn = "starteks"
 	return "node123", nil
 }
@@ -42,72 +81,175 @@ func (e *mockEksExecutor) Stop(config map[string]interface{}) error {
 	stopFn = "stopeks"
 	return nil
 }
+
+var startSlsConfig map[string]interface{}
+var startSlsPanic bool
+
+// regions the mock sls executor fails to start builds in with a regional outage
+var unavailableSlsRegions map[string]bool
+
 func (e *mockSlsExecutor) Start(config map[string]interface{}) (string, error) {
+	if startSlsPanic {
+		panic("start panicked")
+	}
+	if unavailableSlsRegions[e.region] {
+		return "", errors.New("Got error building project: ServiceUnavailableException: Service Unavailable")
+	}
 	startSlsFn = "startsls"
+	startSlsConfig = config
 	return "proj123", nil
 }
+
+// region and config of the last build the mock sls executor stopped, with the regions of all stops
+var stopSlsRegion string
+var stopSlsConfig map[string]interface{}
+var stopSlsRegions []string
+
 func (e *mockSlsExecutor) Stop(config map[string]interface{}) error {
 	stopSlsFn = "stopsls"
+	stopSlsRegion, stopSlsConfig = e.region, config
+	stopSlsRegions = append(stopSlsRegions, e.region)
 	return nil
 }
-func newSls(region string) *mockSlsExecutor {
-	return &mockSlsExecutor{
-		name: "sls",
-	}
+
+func (e *mockEksExecutor) Status(config map[string]interface{}) (executorState.Status, error) {
+	return executorState.Status{}, errors.New("no pod found for build 1234")
 }
-func newEks(region string) *mockEksExecutor {
-	return &mockEksExecutor{
-		name: "eks",
+
+func (e *mockEksExecutor) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	return nil, errors.New("no pod found for build 1234")
+}
+
+func (e *mockSlsExecutor) Logs(config map[string]interface{}, since time.Time) ([]byte, error) {
+	return []byte(fmt.Sprintf("==> codebuild build deploy:1 (FAILED) <==\nsince %s token %s\n", since.Format(time.RFC3339), config["token"])), nil
+}
+
+var cleanedUp []string
+
+func (e *mockEksExecutor) Cleanup(config map[string]interface{}) error {
+	cleanedUp = append(cleanedUp, "eks")
+	return errors.New("failed to clean up job 6822: pods: forbidden")
+}
+
+func (e *mockSlsExecutor) Cleanup(config map[string]interface{}) error {
+	cleanedUp = append(cleanedUp, "sls")
+	return nil
+}
+
+func (e *mockSlsExecutor) Status(config map[string]interface{}) (executorState.Status, error) {
+	return executorState.Status{State: executorState.Failed, Reason: "FAILED in BUILD phase"}, nil
+}
+
+var runningAt time.Time
+
+func (e *mockEksExecutor) WaitRunning(config map[string]interface{}, timeout time.Duration) (time.Time, error) {
+	return runningAt, nil
+}
+
+var imagePullStartTime time.Time
+
+func (e *mockEksExecutor) ImagePullStartTime(config map[string]interface{}, timeout time.Duration) (time.Time, error) {
+	return imagePullStartTime, nil
+}
+
+func (e *mockEksExecutor) ResourceLinks(config map[string]interface{}) map[string]string {
+	return map[string]string{"cluster": "sd-build", "pod": "1234-abcde"}
+}
+
+func (e *mockEksExecutor) ResourceArn(config map[string]interface{}) string {
+	return "arn:aws:eks:us-east-2:111111111:cluster/sd-build"
+}
+
+func (e *mockSlsExecutor) Describe(config map[string]interface{}) (map[string]interface{}, error) {
+	return map[string]interface{}{"bucket": "sd-builds-use2", "bucketPresent": true}, nil
+}
+
+func (e *mockSlsExecutor) Resources(config map[string]interface{}) ([]executorState.Resource, error) {
+	if config["pipelineId"] != json.Number("1898") {
+		return nil, fmt.Errorf("Error-GetResources: AccessDeniedException")
 	}
+	return []executorState.Resource{
+		{Type: "codebuild-project", Name: "main-6822", Arn: "arn:aws:codebuild:us-east-2:111111111:project/main-6822"},
+	}, nil
 }
 
-func mockAPI(t *testing.T, testBuildID int) MockAPI {
-	return MockAPI{
-		updateBuild: func(stats map[string]interface{}, buildID int, statusMessage string) error {
-			if buildID != testBuildID {
-				t.Errorf("stats[\"hostname\"] == %s, want %s", stats["hostname"], "")
-				// Panic to get the stacktrace
-				panic(true)
-			}
-			if stats["hostname"] == "" {
-				t.Errorf("stats[\"hostname\"] == %s, want %s", stats["hostname"], "")
-				// Panic to get the stacktrace
-				panic(true)
-			}
-			return nil
-		},
-		getAPIURL: func() (string, error) {
-			return "https://api.screwdriver.cd/v4/", nil
-		},
+func (e *mockEksExecutor) BuildStats(config map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"podIPv6": "2600:1f14:abc::12"}
+}
+
+var containerExits []executorState.ContainerExit
+
+func (e *mockEksExecutor) ContainerExits(config map[string]interface{}) ([]executorState.ContainerExit, error) {
+	return containerExits, nil
+}
+
+var sizingRecommendation map[string]interface{}
+
+func (e *mockEksExecutor) SizingRecommendation(config map[string]interface{}) (map[string]interface{}, error) {
+	return sizingRecommendation, nil
+}
+
+func (e *mockEksExecutor) StartEvents(config map[string]interface{}) ([]string, error) {
+	return []string{"2022-03-01T10:00:00Z Warning FailedCreate Job/1234-job: admission webhook denied the request"}, nil
+}
+
+func (e *mockSlsExecutor) CacheStats(config map[string]interface{}) map[string]interface{} {
+	if provider, _ := config["provider"].(map[string]interface{}); provider["dlc"] != true {
+		return nil
 	}
+	return map[string]interface{}{"cache": map[string]interface{}{"modes": []string{"LOCAL_DOCKER_LAYER_CACHE"}, "warm": true}}
 }
 
-type MockAPI struct {
-	updateBuild func(stats map[string]interface{}, buildID int, statusMessage string) error
-	getAPIURL   func() (string, error)
+var debugSession executorState.DebugSession
+
+// blocks reporting the debug session until closed, when set
+var debugSessionReady chan struct{}
+
+func (e *mockSlsExecutor) DebugSession(config map[string]interface{}, timeout time.Duration) (executorState.DebugSession, error) {
+	// like the executor, builds without a debug session return at once
+	if enabled, _ := config["provider"].(map[string]interface{})["debugSession"].(bool); !enabled {
+		return executorState.DebugSession{}, nil
+	}
+	if debugSessionReady != nil {
+		<-debugSessionReady
+	}
+	return debugSession, nil
 }
 
-func (f MockAPI) UpdateBuild(stats map[string]interface{}, buildID int, statusMessage string) error {
-	if f.updateBuild != nil {
-		return f.updateBuild(stats, buildID, "")
+var preflightSlsErr error
+
+func (e *mockSlsExecutor) Preflight(config map[string]interface{}) error {
+	return preflightSlsErr
+}
+func newSls(region string) *mockSlsExecutor {
+	return &mockSlsExecutor{
+		name:   "sls",
+		region: region,
 	}
-	return nil
 }
-func (f MockAPI) GetAPIURL() (string, error) {
-	return "", nil
+func newEks(region string) *mockEksExecutor {
+	return &mockEksExecutor{
+		name: "eks",
+	}
 }
 
-func newSdAPI(apiURI string, token string) (sd.API, error) {
-	return sd.API(MockAPI{}), nil
+// number of executors constructed by the mock factories
+var executorsConstructed int
+
+var mockExecutorFactories = map[string]executorFactory{
+	"eks": func(region string) (IExecutor, error) { executorsConstructed++; return newEks(region), nil },
+	"sls": func(region string) (IExecutor, error) { executorsConstructed++; return newSls(region), nil },
 }
 
-func mockExecutorsList(region string) []IExecutor {
-	return []IExecutor{newSls(region), newEks(region)}
+// replaces the executors with the mocks, dropping executors constructed by previous tests
+func useMockExecutors() {
+	executorFactories = mockExecutorFactories
+	executors = newExecutorCache()
 }
 
 func TestHandleRequest(t *testing.T) {
-	executorsList = mockExecutorsList
-	api = newSdAPI
+	useMockExecutors()
+	api = sdtest.New().Factory()
 	tests := []struct {
 		request events.KafkaEvent
 		expect  string
@@ -146,7 +288,8 @@ func TestHandleRequest(t *testing.T) {
 }
 
 func TestGetExecutor(t *testing.T) {
-	executorsList = mockExecutorsList
+	useMockExecutors()
+	executorsConstructed = 0
 	tests := []struct {
 		name   string
 		region string
@@ -160,11 +303,39 @@ func TestGetExecutor(t *testing.T) {
 		response := GetExecutor(test.name, test.region)
 		assert.IsType(t, test.expect, response)
 	}
+	assert.Equal(t, 2, executorsConstructed)
+
+	// executors are memoized per region
+	assert.Same(t, GetExecutor("eks", "us-east-2"), GetExecutor("eks", "us-east-2"))
+	assert.Equal(t, 2, executorsConstructed)
+	assert.NotSame(t, GetExecutor("eks", "us-east-2"), GetExecutor("eks", "us-west-2"))
+	assert.Equal(t, 3, executorsConstructed)
+	assert.Nil(t, GetExecutor("batch", "us-east-2"))
+
+	// executors without an aws session fail their jobs and are constructed again by the next job
+	executorFactories = map[string]executorFactory{
+		"sls": func(region string) (IExecutor, error) {
+			executorsConstructed++
+			return nil, errors.New("LoadCustomCABundleError: unable to load custom CA bundle")
+		},
+	}
+	executors = newExecutorCache()
+	defer useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Infrastructure failure, contact your Screwdriver admins: Error creating sls executor: LoadCustomCABundleError: unable to load custom CA bundle"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+	assert.Equal(t, executorState.InfraPermanent, executorState.CategoryOf(GetExecutor("sls", "us-east-2").Stop(nil)))
+	assert.Equal(t, 5, executorsConstructed)
 }
 
 func TestEksStartMessage(t *testing.T) {
-	executorsList = mockExecutorsList
-	api = newSdAPI
+	useMockExecutors()
+	api = sdtest.New().Factory()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -188,8 +359,8 @@ func TestEksStartMessage(t *testing.T) {
 	}
 }
 func TestEksStopMessage(t *testing.T) {
-	executorsList = mockExecutorsList
-	api = newSdAPI
+	useMockExecutors()
+	api = sdtest.New().Factory()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -213,8 +384,8 @@ func TestEksStopMessage(t *testing.T) {
 	}
 }
 func TestSlsStopMessage(t *testing.T) {
-	executorsList = mockExecutorsList
-	api = newSdAPI
+	useMockExecutors()
+	api = sdtest.New().Factory()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -238,8 +409,8 @@ func TestSlsStopMessage(t *testing.T) {
 	}
 }
 func TestSlsStartMessage(t *testing.T) {
-	executorsList = mockExecutorsList
-	api = newSdAPI
+	useMockExecutors()
+	api = sdtest.New().Factory()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -262,3 +433,2607 @@ func TestSlsStartMessage(t *testing.T) {
 		assert.IsType(t, test.err, err)
 	}
 }
+
+func TestUpdateBuildStatsWithQueue(t *testing.T) {
+	testAPI := sdtest.New()
+	updateQueue = sd.NewUpdateQueue(time.Hour)
+	defer func() { updateQueue = nil }()
+
+	UpdateBuildStats("node123", time.Time{}, nil, TestBuildID, testAPI)
+	UpdateBuildStats("node456", time.Time{}, map[string]interface{}{"podIPv6": "2600:1f14:abc::12"}, TestBuildID, testAPI)
+	assert.Equal(t, 0, len(testAPI.UpdateBuildCalls()))
+	updateQueue.Flush()
+
+	calls := testAPI.UpdateBuildCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, TestBuildID, calls[0].BuildID)
+	assert.Equal(t, "node456", calls[0].Stats["hostname"])
+	assert.Equal(t, "2600:1f14:abc::12", calls[0].Stats["podIPv6"])
+}
+
+// builds a base64 encoded build message, modify can change the build config before encoding
+func testMessage(t *testing.T, job string, executorType string, modify func(buildConfig map[string]interface{})) string {
+	buildConfig := map[string]interface{}{
+		"jobId":        6822,
+		"jobName":      "main",
+		"isPR":         false,
+		"apiUri":       "https://api.screwdriver.cd",
+		"uiUri":        "https://screwdriver.cd",
+		"storeUri":     "https://store.screwdriver.cd",
+		"buildId":      TestBuildID,
+		"eventId":      346863,
+		"pipelineId":   1898,
+		"container":    "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12",
+		"token":        "testtoken",
+		"buildTimeout": 90,
+		"provider": map[string]interface{}{
+			"name":            "aws",
+			"region":          "us-east-2",
+			"accountId":       111111111,
+			"role":            "arn:aws:iam::111111111:role/cd.screwdriver.consumer.integration-sdbuild",
+			"executor":        executorType,
+			"launcherImage":   "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:launcherv6.0.147",
+			"launcherVersion": "v6.0.147",
+			"vpc": map[string]interface{}{
+				"vpcId":            "vpc-0914ca71b1f274167",
+				"securityGroupIds": []string{"sg-05e482e63a1802aa4"},
+				"subnetIds":        []string{"subnet-0a7baed8f632d41c6"},
+			},
+		},
+	}
+	if modify != nil {
+		modify(buildConfig)
+	}
+	message, err := json.Marshal(map[string]interface{}{
+		"job":          job,
+		"executorType": executorType,
+		"buildConfig":  buildConfig,
+	})
+	if err != nil {
+		t.Fatalf("marshaling message: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(message)
+}
+
+func TestStartRejectedByPolicy(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{AllowedAccounts: []string{"222222222"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Rejected by policy: account 111111111 is not allowed"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestCheckPolicyCategories(t *testing.T) {
+	buildConfig := map[string]interface{}{"provider": map[string]interface{}{"accountId": "111111111"}}
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{AllowedAccounts: []string{"222222222"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	err := CheckPolicy(buildConfig, "us-east-2")
+	assert.EqualError(t, err, "Rejected by policy: provider role is required")
+	assert.Equal(t, executorState.Policy, executorState.CategoryOf(err))
+	assert.True(t, policy.IsViolation(err))
+
+	loadPolicy = func() (*policy.Policy, error) {
+		return nil, errors.New("Error loading policy from /sd/policy: ThrottlingException")
+	}
+	err = CheckPolicy(buildConfig, "us-east-2")
+	assert.EqualError(t, err, "Error loading policy from /sd/policy: ThrottlingException")
+	assert.Equal(t, executorState.InfraTransient, executorState.CategoryOf(err))
+	assert.False(t, policy.IsViolation(err))
+	assert.Equal(t, "Temporary infrastructure failure, restart the build later: Error loading policy from /sd/policy: ThrottlingException", executorState.StatusMessage(err))
+}
+
+func TestStartRejectedByRegion(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["region"] = "mars-1"
+	}), &wg, context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `region "mars-1" does not belong to a known aws partition`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestStartRejectedByPartition(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["region"] = "us-gov-west-1"
+	}), &wg, context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Rejected by policy: provider role \"arn:aws:iam::111111111:role/cd.screwdriver.consumer.integration-sdbuild\" does not belong to partition aws-us-gov"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestStartPreflight(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	preflightSlsErr = errors.New("no free IP addresses in subnets subnet-0a7baed8f632d41c6")
+	defer func() {
+		loadPolicy = policy.Load
+		preflightSlsErr = nil
+	}()
+
+	tests := []struct {
+		message  string
+		enabled  string
+		started  string
+		failures []sdtest.UpdateBuildStatusCall
+	}{
+		{message: "disabled", enabled: "", started: "startsls"},
+		{message: "enabled", enabled: "true", started: "", failures: []sdtest.UpdateBuildStatusCall{
+			{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Pre-flight check failed: no free IP addresses in subnets subnet-0a7baed8f632d41c6"},
+		}},
+	}
+	for _, test := range tests {
+		t.Setenv("SD_PREFLIGHT_CHECKS", test.enabled)
+		fakeAPI := sdtest.New()
+		api = fakeAPI.Factory()
+		startSlsFn = ""
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		err := ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO())
+
+		assert.Nil(t, err, test.message)
+		assert.Equal(t, test.started, startSlsFn, test.message)
+		assert.Equal(t, test.failures, fakeAPI.UpdateBuildStatusCalls(), test.message)
+	}
+}
+
+func TestStartAllowedByPolicy(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{AllowedAccounts: []string{"111111111"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildStatusCalls()))
+}
+
+func TestStartRejectedByImagePolicy(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{AllowedRegistries: []string{"111111111.dkr.ecr.*.amazonaws.com/*"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	startFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := ProcessMessage(1, testMessage(t, "start", "eks", func(buildConfig map[string]interface{}) {
+		buildConfig["container"] = "node:12"
+	}), &wg, context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", startFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `Rejected by policy: container image "node:12" is not from an allowed registry`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestStartLatencyMetrics(t *testing.T) {
+	useMockExecutors()
+	api = sdtest.New().Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	var buf bytes.Buffer
+	metrics.SetOutput(&buf)
+	defer func() {
+		loadPolicy = policy.Load
+		metrics.SetOutput(os.Stdout)
+	}()
+	t.Setenv("SD_SLO_RUNNING_TIMEOUT_SECS", "60")
+	enqueuedAt := time.Now().Add(-2 * time.Second)
+	runningAt = enqueuedAt.Add(3 * time.Second)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ctx := context.WithValue(context.TODO(), enqueuedAtKey, enqueuedAt)
+	err := ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, ctx)
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var accepted, running map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &accepted))
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &running))
+	assert.Equal(t, "eks", accepted["Executor"])
+	assert.Equal(t, "us-east-2", accepted["Region"])
+	assert.GreaterOrEqual(t, accepted["BuildStartAcceptedLatency"], float64(2000))
+	assert.Equal(t, float64(3000), running["BuildRunningLatency"])
+
+	buf.Reset()
+	wg.Add(1)
+	err = ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "", buf.String())
+}
+
+func TestStartImagePullStartTime(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	imagePullStartTime = time.Date(2022, 3, 1, 10, 0, 5, 0, time.FixedZone("PST", -8*3600))
+	defer func() {
+		loadPolicy = policy.Load
+		imagePullStartTime = time.Time{}
+	}()
+
+	tests := []struct {
+		executorType string
+		expected     time.Time
+	}{
+		{executorType: "eks", expected: time.Date(2022, 3, 1, 18, 0, 5, 0, time.UTC)},
+		{executorType: "sls"},
+	}
+	for _, test := range tests {
+		fakeAPI := sdtest.New()
+		api = fakeAPI.Factory()
+		before := time.Now()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		err := ProcessMessage(1, testMessage(t, "start", test.executorType, nil), &wg, context.TODO())
+		assert.Nil(t, err)
+
+		calls := fakeAPI.UpdateBuildCalls()
+		assert.Equal(t, 1, len(calls), test.executorType)
+		pullStart := calls[0].Stats["imagePullStartTime"].(time.Time)
+		assert.Equal(t, utcLoc, pullStart.Location(), test.executorType)
+		if test.expected.IsZero() {
+			assert.False(t, pullStart.Before(before), test.executorType)
+			assert.NotContains(t, calls[0].Stats, "podIPv6", test.executorType)
+			continue
+		}
+		assert.True(t, test.expected.Equal(pullStart), test.executorType)
+		assert.Equal(t, "2600:1f14:abc::12", calls[0].Stats["podIPv6"], test.executorType)
+	}
+}
+
+func TestStartResourceLinks(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+
+	assert.Equal(t, []sdtest.UpdateBuildMetaCall{
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{
+			"links": map[string]string{"cluster": "sd-build", "pod": "1234-abcde"},
+		}}, BuildID: TestBuildID},
+	}, withoutEffectiveConfig(fakeAPI.UpdateBuildMetaCalls()))
+}
+
+// drops the meta updates of the effective config every start writes
+func withoutEffectiveConfig(calls []sdtest.UpdateBuildMetaCall) []sdtest.UpdateBuildMetaCall {
+	var filtered []sdtest.UpdateBuildMetaCall
+	for _, call := range calls {
+		if _, ok := call.Meta["aws"].(map[string]interface{})["config"]; !ok {
+			filtered = append(filtered, call)
+		}
+	}
+	return filtered
+}
+
+func TestStartEffectiveConfig(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["computeType"] = "BUILD_GENERAL1_LARGE"
+	}), &wg, context.TODO()))
+
+	calls := fakeAPI.UpdateBuildMetaCalls()
+	assert.Equal(t, 3, len(calls))
+	configs := make([]*diagnostics.EffectiveConfig, len(calls))
+	for i, call := range calls {
+		assert.Equal(t, TestBuildID, call.BuildID)
+		configs[i] = call.Meta["aws"].(map[string]interface{})["config"].(*diagnostics.EffectiveConfig)
+	}
+	assert.Equal(t, "sls", configs[0].Executor)
+	assert.Equal(t, "us-east-2", configs[0].Region)
+	assert.Equal(t, redact.Mask, configs[0].BuildConfig["token"])
+	assert.Equal(t, configs[0].Hash, configs[1].Hash)
+	assert.NotEqual(t, configs[0].Hash, configs[2].Hash)
+	assert.Equal(t, "BUILD_GENERAL1_LARGE", configs[2].BuildConfig["provider"].(map[string]interface{})["computeType"])
+}
+
+// records the published receipts
+type mockStartReceipts struct {
+	receipts []receipt.Receipt
+	err      error
+}
+
+func (m *mockStartReceipts) Put(r receipt.Receipt) error {
+	m.receipts = append(m.receipts, r)
+	return m.err
+}
+
+func (m *mockStartReceipts) Get(buildID int, arch string) (*receipt.Receipt, error) {
+	for i := len(m.receipts) - 1; i >= 0; i-- {
+		if r := m.receipts[i]; r.BuildID == buildID && r.Architecture == arch {
+			return &r, nil
+		}
+	}
+	return nil, m.err
+}
+
+func TestStopRoutedByReceipt(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	receipts := &mockStartReceipts{}
+	startReceipts = receipts
+	defer func() {
+		loadPolicy = policy.Load
+		startReceipts = nil
+	}()
+	api = sdtest.New().Factory()
+	var wg sync.WaitGroup
+
+	// without a receipt the stop runs with the message
+	stopSlsFn, stopSlsRegion = "", ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "us-east-2", stopSlsRegion)
+
+	// a build which failed over is stopped in the region it started in, with the fields of that fallback region
+	receipts.receipts = []receipt.Receipt{{BuildID: TestBuildID, Executor: "sls", Region: "us-west-2"}}
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "stop", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["fallbackRegions"] = []interface{}{map[string]interface{}{"region": "us-west-2", "bucket": "sd-builds-usw2"}}
+	}), &wg, context.TODO()))
+	assert.Equal(t, "us-west-2", stopSlsRegion)
+	assert.Equal(t, "us-west-2", stopSlsConfig["provider"].(map[string]interface{})["buildRegion"])
+	assert.Equal(t, "sd-builds-usw2", stopSlsConfig["provider"].(map[string]interface{})["bucket"])
+
+	// a build which started on another executor is stopped by that executor
+	receipts.receipts = []receipt.Receipt{{BuildID: TestBuildID, Executor: "eks", Region: "us-east-2"}}
+	stopFn, stopSlsFn = "", ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "stopeks", stopFn)
+	assert.Equal(t, "", stopSlsFn)
+
+	// a failed read stops the build with its message
+	receipts.receipts, receipts.err = nil, errors.New("ResourceNotFoundException")
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(4, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "stopsls", stopSlsFn)
+
+	// the stop finds the project by the name the build started with, whatever the naming is now
+	receipts.receipts, receipts.err = []receipt.Receipt{{BuildID: TestBuildID, Executor: "sls", Region: "us-east-2", ProjectName: "sd-main-6822"}}, nil
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{Naming: policy.Naming{Prefix: "screwdriver"}}, nil
+	}
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(5, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "sd-main-6822", stopSlsConfig[policy.ProjectNameKey])
+
+	// a policy which can't be loaded does not drop the stop, it uses the default naming
+	receipts.receipts = nil
+	loadPolicy = func() (*policy.Policy, error) {
+		return nil, errors.New("Error loading policy from /sd/policy: ThrottlingException")
+	}
+	stopSlsFn = ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(6, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "stopsls", stopSlsFn)
+	assert.Equal(t, "main-6822", stopSlsConfig[policy.ProjectNameKey])
+}
+
+func TestStartPublishesReceipt(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	receipts := &mockStartReceipts{}
+	startReceipts = receipts
+	defer func() {
+		loadPolicy = policy.Load
+		startReceipts = nil
+	}()
+	api = sdtest.New().Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	// a failed write does not fail the build
+	receipts.err = errors.New("ResourceNotFoundException")
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+
+	assert.Len(t, receipts.receipts, 2)
+	for _, r := range receipts.receipts {
+		_, err := time.Parse(time.RFC3339, r.StartedAt)
+		assert.Nil(t, err)
+	}
+	eksReceipt, slsReceipt := receipts.receipts[0], receipts.receipts[1]
+	eksReceipt.StartedAt, slsReceipt.StartedAt = "", ""
+	assert.Equal(t, receipt.Receipt{BuildID: TestBuildID, Executor: "eks", ResourceArn: "arn:aws:eks:us-east-2:111111111:cluster/sd-build", Region: "us-east-2", ProjectName: "main-6822"}, eksReceipt)
+	// the sls mock does not know its resource
+	assert.Equal(t, receipt.Receipt{BuildID: TestBuildID, Executor: "sls", Region: "us-east-2", ProjectName: "main-6822"}, slsReceipt)
+}
+
+func TestStartArchitectures(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	var mu sync.Mutex
+	var values []string
+	processMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		values = append(values, value)
+		return nil
+	}
+	defer func() {
+		loadPolicy = policy.Load
+		processMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
+			return ProcessMessage(id, value, wg, ctx)
+		}
+	}()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["architectures"] = []string{"amd64", "arm64"}
+	}), &wg, context.TODO()))
+	// the message of the build is processed as a message per architecture
+	assert.Equal(t, "", startSlsFn)
+	assert.Len(t, values, 2)
+	sort.Strings(values)
+	for i, arch := range []string{"amd64", "arm64"} {
+		buildMessage, err := decodeMessage(values[i])
+		assert.Nil(t, err)
+		assert.Equal(t, arch, buildMessage.BuildConfig[matrix.ArchitectureKey])
+		assert.Equal(t, arch, buildMessage.BuildConfig["provider"].(map[string]interface{})["architecture"])
+	}
+
+	// the build of an architecture runs in a project of its own and reports its stats under its architecture
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, values[1], &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, "main-6822-arm64", startSlsConfig[policy.ProjectNameKey])
+	calls := fakeAPI.UpdateBuildCalls()
+	assert.Equal(t, "proj123", calls[len(calls)-1].Stats["arm64.hostname"])
+	assert.Contains(t, calls[len(calls)-1].Stats, "arm64.imagePullStartTime")
+
+	// invalid architectures fail the build
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["architectures"] = []string{"amd64", "s390x"}
+	}), &wg, context.TODO()))
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `provider.architectures: unsupported architecture "s390x"`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+type mockRoleResolver struct {
+	templates []role.Template
+	err       error
+	// resolves a role per region
+	regional bool
+}
+
+func (r *mockRoleResolver) Resolve(t role.Template) (string, error) {
+	r.templates = append(r.templates, t)
+	if r.regional {
+		return "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-" + t.PipelineID + "-" + t.Region, r.err
+	}
+	return "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-" + t.PipelineID, r.err
+}
+
+func TestStartScopedRole(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	resolver := &mockRoleResolver{}
+	roleResolver = resolver
+	defer func() {
+		loadPolicy = policy.Load
+		roleResolver = nil
+	}()
+
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, []role.Template{{PipelineID: "1898", AccountID: "111111111", Region: "us-east-2"}}, resolver.templates)
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-1898", provider["role"])
+	assert.Equal(t, "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-1898", provider["scopedRole"])
+
+	resolver.err = errors.New("scoped role sd-pipeline-1898 is not provisioned")
+	startSlsFn = ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Got error resolving scoped role: scoped role sd-pipeline-1898 is not provisioned"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// the scoped role is checked against the role patterns of the policy
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{AllowedRolePatterns: []string{"arn:aws:iam::*:role/screwdriver/sd-pipeline-42"}}, nil
+	}
+	resolver.err = nil
+	fakeAPI = sdtest.New()
+	api = fakeAPI.Factory()
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["role"] = "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-42"
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	calls := fakeAPI.UpdateBuildStatusCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.Contains(t, calls[0].StatusMessage, "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-1898")
+}
+
+func TestStartImageRewrite(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	imageRewriter = &image.Rewriter{Rules: []image.Rule{
+		{Prefix: "docker.io/", Replacement: "{accountId}.dkr.ecr.{region}.amazonaws.com/docker-hub/"},
+	}}
+	defer func() {
+		loadPolicy = policy.Load
+		imageRewriter = nil
+	}()
+
+	api = sdtest.New().Factory()
+	startSlsFn = ""
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["container"] = "node:12"
+	}), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, "111111111.dkr.ecr.us-east-2.amazonaws.com/docker-hub/library/node:12", startSlsConfig["container"])
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:launcherv6.0.147", provider["launcherImage"])
+}
+
+type mockImageScanner struct {
+	images   []string
+	findings []policy.Finding
+}
+
+func (m *mockImageScanner) Findings(image string) ([]policy.Finding, error) {
+	m.images = append(m.images, image)
+	return m.findings, nil
+}
+
+func TestStartRejectedByImageScan(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{BlockedSeverities: []string{"CRITICAL"}}, nil
+	}
+	scanner := &mockImageScanner{findings: []policy.Finding{{ID: "CVE-2021-44228", Severity: "CRITICAL"}}}
+	imageScanner = scanner
+	defer func() {
+		loadPolicy = policy.Load
+		imageScanner = policy.NewECRScanner()
+	}()
+	startFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startFn)
+	assert.Equal(t, []string{"111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12"}, scanner.images)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `Rejected by policy: container image "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12" has blocked vulnerabilities: CVE-2021-44228 (CRITICAL)`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	scanner.findings = []policy.Finding{{ID: "CVE-2022-0778", Severity: "HIGH"}}
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, "starteks", startFn)
+}
+
+func TestStartLauncherArchitecture(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	t.Setenv("SD_LAUNCHER_IMAGES", `{"sls/arm64": "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:launcher{version}-arm64"}`)
+
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["environmentType"] = "ARM_CONTAINER"
+	}), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:launcherv6.0.147-arm64", provider["launcherImage"])
+
+	startSlsFn = ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["architecture"] = "mips"
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `unsupported architecture "mips"`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+// gets the value of a prometheus sample, 0 when not exposed yet
+func promValue(name string) float64 {
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			value, _ := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+			return value
+		}
+	}
+	return 0
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	useMockExecutors()
+	api = sdtest.New().Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+
+	started := `sd_aws_consumer_builds_started_total{executor="sls",region="us-east-2"}`
+	stopped := `sd_aws_consumer_builds_stopped_total{executor="eks",region="us-east-2"}`
+	lag := `sd_aws_consumer_kafka_lag_seconds_count{topic="` + TestTopic + `"}`
+	startedBefore, stoppedBefore, lagBefore := promValue(started), promValue(stopped), promValue(lag)
+
+	_, err := HandleRequest(context.TODO(), events.KafkaEvent{Records: map[string][]events.KafkaRecord{
+		"builds-0": {
+			{Topic: TestTopic, Timestamp: events.MilliSecondsEpochTime{Time: time.Now().Add(-time.Second)}, Value: testMessage(t, "start", "sls", nil)},
+			{Topic: TestTopic, Timestamp: events.MilliSecondsEpochTime{Time: time.Now().Add(-time.Second)}, Value: testMessage(t, "stop", "eks", nil)},
+		},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, startedBefore+1, promValue(started))
+	assert.Equal(t, stoppedBefore+1, promValue(stopped))
+	assert.Equal(t, lagBefore+2, promValue(lag))
+}
+
+func TestStartPanicStacktrace(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	var uploaded []string
+	upload := uploadObject
+	uploadObject = func(bucket string, key string, stacktrace []byte) error {
+		uploaded = append(uploaded, bucket+"/"+key)
+		assert.True(t, bytes.Contains(stacktrace, []byte("panic")))
+		return nil
+	}
+	startSlsPanic = true
+	defer func() {
+		loadPolicy = policy.Load
+		startSlsPanic = false
+		uploadObject = upload
+	}()
+	t.Setenv("SD_STACKTRACE_BUCKET", "sd-stacktraces")
+
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO())
+	wg.Wait()
+
+	assert.Equal(t, 1, len(uploaded))
+	assert.True(t, strings.HasPrefix(uploaded[0], fmt.Sprintf("sd-stacktraces/stacktraces/%d/", TestBuildID)), uploaded[0])
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Internal error in aws consumer service, stacktrace: s3://" + uploaded[0]},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// the build is failed without location when the upload fails
+	fakeAPI = sdtest.New()
+	api = fakeAPI.Factory()
+	uploaded = nil
+	uploadObject = func(bucket string, key string, stacktrace []byte) error {
+		return errors.New("AccessDenied")
+	}
+	wg.Add(1)
+	ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO())
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Internal error in aws consumer service"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestProcessMessageWithoutProvider(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn, stopSlsFn = "", ""
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		delete(buildConfig, "provider")
+	}), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"] = "sls"
+	}), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "stop", "sls", func(buildConfig map[string]interface{}) {
+		delete(buildConfig, "provider")
+	}), &wg, context.TODO()))
+	// builds without an api are only logged
+	assert.Nil(t, ProcessMessage(4, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		delete(buildConfig, "provider")
+		delete(buildConfig, "apiUri")
+	}), &wg, context.TODO()))
+
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, "", stopSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Invalid build configuration: buildConfig.provider is required"},
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Invalid build configuration: buildConfig.provider must be an object"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestProcessDiagnosticsMessage(t *testing.T) {
+	uploaded := map[string][]byte{}
+	upload := uploadObject
+	uploadObject = func(bucket string, key string, body []byte) error {
+		uploaded[bucket+"/"+key] = body
+		return nil
+	}
+	defer func() { uploadObject = upload }()
+	t.Setenv("SD_DIAGNOSTICS_BUCKET", "sd-diagnostics")
+
+	message := base64.StdEncoding.EncodeToString([]byte(`{"job": "diagnostics"}`))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, message, &wg, context.TODO()))
+
+	var names []string
+	for key := range uploaded {
+		assert.True(t, strings.HasPrefix(key, "sd-diagnostics/diagnostics/"), key)
+		names = append(names, key[strings.LastIndex(key, "/")+1:])
+	}
+	assert.ElementsMatch(t, []string{"goroutines.txt", "heap.pprof", "memstats.json"}, names)
+}
+
+type mockCostReporter struct {
+	summary *cost.Summary
+	err     error
+}
+
+func (m *mockCostReporter) Report() (*cost.Summary, error) {
+	return m.summary, m.err
+}
+
+func TestProcessCostReportMessage(t *testing.T) {
+	uploaded := map[string][]byte{}
+	upload := uploadObject
+	uploadObject = func(bucket string, key string, body []byte) error {
+		uploaded[bucket+"/"+key] = body
+		return nil
+	}
+	reporter := costReporter
+	defer func() { uploadObject, costReporter = upload, reporter }()
+	costReporter = &mockCostReporter{summary: &cost.Summary{
+		Month:     "2022-03",
+		Currency:  "USD",
+		Total:     12.5,
+		Pipelines: []cost.PipelineCost{{PipelineID: "1898", Cost: 12.5}},
+	}}
+	t.Setenv("SD_COST_REPORT_BUCKET", "sd-reports")
+
+	message := base64.StdEncoding.EncodeToString([]byte(`{"job": "cost-report"}`))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, message, &wg, context.TODO()))
+	assert.Contains(t, uploaded, "sd-reports/cost-reports/2022-03.json")
+	var summary cost.Summary
+	assert.Nil(t, json.Unmarshal(uploaded["sd-reports/cost-reports/2022-03.json"], &summary))
+	assert.Equal(t, []cost.PipelineCost{{PipelineID: "1898", Cost: 12.5}}, summary.Pipelines)
+
+	// nothing is uploaded when the costs cannot be read
+	uploaded = map[string][]byte{}
+	costReporter = &mockCostReporter{err: errors.New("Error-GetCostAndUsage: AccessDeniedException")}
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, message, &wg, context.TODO()))
+	assert.Empty(t, uploaded)
+}
+
+func BenchmarkProcessMessage(b *testing.B) {
+	useMockExecutors()
+	api = sdtest.New().Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	message := testMessage(&testing.T{}, "stop", "sls", nil)
+	var wg sync.WaitGroup
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		ProcessMessage(i, message, &wg, context.TODO())
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	message, err := decodeMessage(testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["prune"] = false
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "start", message.Job)
+	assert.Equal(t, "sls", message.ExecutorType)
+	assert.Equal(t, "default", message.BuildConfig["serviceAccountName"])
+	provider := message.BuildConfig["provider"].(map[string]interface{})
+	assert.Equal(t, false, provider["prune"])
+	assert.Equal(t, json.Number("5"), provider["queuedTimeout"])
+	assert.Equal(t, "LINUX_CONTAINER", provider["environmentType"])
+	assert.Equal(t, json.Number("111111111"), provider["accountId"])
+
+	_, err = decodeMessage(base64.StdEncoding.EncodeToString([]byte(`{"job": "start", "buildConfig": {"token": "eyJhbGciOi.eyJzdWIiOi.c2ln"`)))
+	assert.EqualError(t, err, `Error decoding message {"job": "start", "buildConfig": {"token": "***": unexpected EOF`)
+
+	_, err = decodeMessage("not a message")
+	assert.EqualError(t, err, "Error decoding base64 message: illegal base64 data at input byte 3")
+
+	// returned by ProcessMessage instead of exiting the consumer
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.EqualError(t, ProcessMessage(7, "not a message", &wg, context.TODO()),
+		"Got error decoding record 7: Error decoding base64 message: illegal base64 data at input byte 3")
+}
+
+func TestDecodePlainMessage(t *testing.T) {
+	encoded, _ := base64.StdEncoding.DecodeString(testMessage(t, "stop", "eks", nil))
+	message, err := decodeMessage("\n " + string(encoded))
+	assert.Nil(t, err)
+	assert.Equal(t, "stop", message.Job)
+	assert.Equal(t, "eks", message.ExecutorType)
+	assert.Equal(t, "default", message.BuildConfig["serviceAccountName"])
+
+	_, err = decodeMessage(`{"job": "start", "buildConfig": {"token": "eyJhbGciOi.eyJzdWIiOi.c2ln"`)
+	assert.EqualError(t, err, `Error decoding message {"job": "start", "buildConfig": {"token": "***": unexpected EOF`)
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	message := testMessage(&testing.T{}, "start", "sls", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeMessage(message); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDescribeJob(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	var checked []string
+	defer func(orig func(string, string) error) { checkRoleAssumable = orig }(checkRoleAssumable)
+	checkRoleAssumable = func(roleArn string, service string) error {
+		checked = append(checked, roleArn, service)
+		if service == "" {
+			return errors.New("Error-GetRole: AccessDenied")
+		}
+		return nil
+	}
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "describe", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "describe", "eks", nil), &wg, context.TODO()))
+
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []string{
+		"arn:aws:iam::111111111:role/cd.screwdriver.consumer.integration-sdbuild", "codebuild.amazonaws.com",
+		"arn:aws:iam::111111111:role/cd.screwdriver.consumer.integration-sdbuild", "",
+	}, checked)
+	assert.Equal(t, []sdtest.UpdateBuildMetaCall{
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{"capabilities": map[string]interface{}{
+			"sls": map[string]interface{}{"region": "us-east-2", "roleAssumable": true, "bucket": "sd-builds-use2", "bucketPresent": true},
+		}}}, BuildID: TestBuildID},
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{"capabilities": map[string]interface{}{
+			"eks": map[string]interface{}{"region": "us-east-2", "roleAssumable": false, "roleError": "Error-GetRole: AccessDenied"},
+		}}}, BuildID: TestBuildID},
+	}, fakeAPI.UpdateBuildMetaCalls())
+	assert.Empty(t, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestListJob(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "list", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "list", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = 1899
+	}), &wg, context.TODO()))
+	// executors without a lister leave the meta alone
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "list", "eks", nil), &wg, context.TODO()))
+
+	assert.Equal(t, "", startSlsFn)
+	calls := fakeAPI.UpdateBuildMetaCalls()
+	assert.Len(t, calls, 2)
+	listings := []map[string]interface{}{}
+	for _, call := range calls {
+		listing := call.Meta["aws"].(map[string]interface{})["resources"].(map[string]interface{})["sls"].(map[string]interface{})
+		_, err := time.Parse(time.RFC3339, listing["listedAt"].(string))
+		assert.Nil(t, err)
+		delete(listing, "listedAt")
+		listings = append(listings, listing)
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{"pipelineId": "1898", "items": []executorState.Resource{
+			{Type: "codebuild-project", Name: "main-6822", Arn: "arn:aws:codebuild:us-east-2:111111111:project/main-6822"},
+		}},
+		{"pipelineId": "1899", "error": "Error-GetResources: AccessDeniedException"},
+	}, listings)
+	assert.Empty(t, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestStatusJob(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "status", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "status", "eks", nil), &wg, context.TODO()))
+
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildMetaCall{
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{
+			"status": executorState.Status{State: executorState.Failed, Reason: "FAILED in BUILD phase"},
+		}}, BuildID: TestBuildID},
+	}, fakeAPI.UpdateBuildMetaCalls())
+	assert.Empty(t, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestLogsJob(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	fakeStore := sdtest.NewStore()
+	defer func(orig func(string, string) (sd.Store, error)) { store = orig }(store)
+	store = fakeStore.Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "logs", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["logsSince"] = "2022-03-01T10:00:00Z"
+	}), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "logs", "eks", nil), &wg, context.TODO()))
+
+	calls := fakeStore.UploadArtifactCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, TestBuildID, calls[0].BuildID)
+	assert.Equal(t, "aws-executor.log", calls[0].Name)
+	assert.Equal(t, "text/plain", calls[0].ContentType)
+	assert.True(t, strings.HasPrefix(calls[0].Body, "==> codebuild build deploy:1 (FAILED) <==\nsince 2022-03-01T10:00:00Z token "+redact.Mask), calls[0].Body)
+	assert.Empty(t, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestCleanupJob(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	cleanedUp = nil
+	stopSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "cleanup", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "cleanup", "eks", nil), &wg, context.TODO()))
+
+	assert.Equal(t, []string{"sls", "eks"}, cleanedUp)
+	assert.Equal(t, "", stopSlsFn)
+	assert.Empty(t, fakeAPI.UpdateBuildStatusCalls())
+}
+
+type mockAccountRegistry struct {
+	accounts map[string]*registry.Account
+}
+
+func (m *mockAccountRegistry) Lookup(alias string) (*registry.Account, error) {
+	if account, ok := m.accounts[alias]; ok {
+		return account, nil
+	}
+	return nil, fmt.Errorf("account %s is not registered", alias)
+}
+
+func TestStartAccountAlias(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	accountRegistry = &mockAccountRegistry{accounts: map[string]*registry.Account{
+		"team-a": {
+			Alias:     "team-a",
+			AccountID: "111111111",
+			Region:    "us-west-2",
+			Role:      "arn:aws:iam::111111111:role/sd-build",
+			Bucket:    "sd-team-a-builds",
+			VPC:       map[string]interface{}{"vpcId": "vpc-1", "securityGroupIds": []interface{}{"sg-1"}, "subnetIds": []interface{}{"subnet-1"}},
+			Executors: []string{"sls"},
+		},
+	}}
+	defer func() { accountRegistry = nil }()
+	aliased := func(buildConfig map[string]interface{}) {
+		provider := buildConfig["provider"].(map[string]interface{})
+		provider["accountAlias"] = "team-a"
+		for _, k := range []string{"accountId", "region", "role", "vpc"} {
+			delete(provider, k)
+		}
+	}
+	startSlsFn, startFn = "", ""
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", aliased), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "us-west-2", provider["region"])
+	assert.Equal(t, "arn:aws:iam::111111111:role/sd-build", provider["role"])
+	assert.Equal(t, "sd-team-a-builds", provider["accountBucket"])
+	assert.Equal(t, "vpc-1", provider["vpc"].(map[string]interface{})["vpcId"])
+
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "eks", aliased), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["accountAlias"] = "team-b"
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "executor eks is not allowed for account team-a"},
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Got error looking up provider account team-b: account team-b is not registered"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+type mockPipelineOverrides struct {
+	overrides map[string]*overrides.Overrides
+}
+
+func (m *mockPipelineOverrides) Lookup(pipelineID string) (*overrides.Overrides, error) {
+	if pipelineID == "1" {
+		return nil, errors.New("Error-GetItem: throttled")
+	}
+	return m.overrides[pipelineID], nil
+}
+
+func TestStartPipelineOverrides(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	pipelineOverrides = &mockPipelineOverrides{overrides: map[string]*overrides.Overrides{
+		"1898": {PipelineID: "1898", Provider: map[string]interface{}{"computeType": "BUILD_GENERAL1_LARGE", "privilegedMode": false}},
+	}}
+	defer func() { pipelineOverrides = nil }()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["privilegedMode"] = true
+	}), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "BUILD_GENERAL1_LARGE", provider["computeType"])
+	assert.Equal(t, false, provider["privilegedMode"])
+
+	// pipelines without overrides start as they are
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = 42
+	}), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, "BUILD_GENERAL1_SMALL", startSlsConfig["provider"].(map[string]interface{})["computeType"])
+
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = 1
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Got error looking up overrides of pipeline 1: Error-GetItem: throttled"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// stops don't need the overrides
+	stopSlsFn = ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(4, testMessage(t, "stop", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = 1
+	}), &wg, context.TODO()))
+	assert.Equal(t, "stopsls", stopSlsFn)
+}
+
+func TestStartEnvironmentPolicy(t *testing.T) {
+	useMockExecutors()
+	api = sdtest.New().Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{DeniedEnvironment: []string{"NPM_TOKEN"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["environment"] = map[string]interface{}{"NODE_ENV": "production", "NPM_TOKEN": "secret", "DOCKER_HOST": "tcp://evil:2375"}
+	}), &wg, context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"NODE_ENV": "production"}, startSlsConfig[policy.EnvironmentKey])
+}
+
+func TestStartSizing(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["size"] = "medium"
+	}), &wg, context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "BUILD_GENERAL1_MEDIUM", startSlsConfig["provider"].(map[string]interface{})["computeType"])
+
+	startSlsConfig = nil
+	err = ProcessMessage(2, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["size"] = "huge"
+	}), &wg, context.TODO())
+	assert.Nil(t, err)
+	assert.Nil(t, startSlsConfig)
+	calls := fakeAPI.UpdateBuildStatusCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, `unknown size "huge", valid sizes are micro, small, medium, large, xlarge`, calls[0].StatusMessage)
+}
+
+func TestStartAnnotations(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	err := ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["annotations"] = map[string]interface{}{"screwdriver.cd/ram": "HIGH", "screwdriver.cd/architecture": "arm64"}
+	}), &wg, context.TODO())
+	assert.Nil(t, err)
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "BUILD_GENERAL1_LARGE", provider["computeType"])
+	assert.Equal(t, "arm64", provider["architecture"])
+
+	startSlsConfig = nil
+	err = ProcessMessage(2, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["annotations"] = map[string]interface{}{"screwdriver.cd/cpu": "EXTREME"}
+	}), &wg, context.TODO())
+	assert.Nil(t, err)
+	assert.Nil(t, startSlsConfig)
+	calls := fakeAPI.UpdateBuildStatusCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, `annotation screwdriver.cd/cpu "EXTREME" must be one of MICRO, LOW, HIGH, TURBO or a positive number`, calls[0].StatusMessage)
+}
+
+func TestStartDebugSession(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	debugSession = executorState.DebugSession{
+		Target:    "i-0abc",
+		Command:   "aws ssm start-session --target i-0abc --region us-east-2",
+		ExpiresAt: time.Date(2022, 3, 1, 11, 0, 0, 0, time.UTC),
+	}
+	defer func() { debugSession = executorState.DebugSession{} }()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["debugSession"] = true
+	}), &wg, context.TODO()))
+	debugSessions.Wait()
+
+	assert.Equal(t, []sdtest.UpdateBuildMetaCall{
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{"debugSession": debugSession}}, BuildID: TestBuildID},
+	}, withoutEffectiveConfig(fakeAPI.UpdateBuildMetaCalls()))
+	// reported after the build stats of the start
+	calls := fakeAPI.UpdateBuildCalls()
+	assert.Equal(t, "Debug session ready, connect with: aws ssm start-session --target i-0abc --region us-east-2 (expires at 2022-03-01T11:00:00Z)", calls[len(calls)-1].StatusMessage)
+}
+
+func TestStartDebugSessionDoesNotBlockStart(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	debugSession = executorState.DebugSession{Target: "i-0abc", Command: "aws ssm start-session --target i-0abc --region us-east-2"}
+	debugSessionReady = make(chan struct{})
+	defer func() {
+		debugSession = executorState.DebugSession{}
+		debugSessionReady = nil
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	// returns while the session is not up yet
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["debugSession"] = true
+	}), &wg, context.TODO()))
+	assert.Empty(t, withoutEffectiveConfig(fakeAPI.UpdateBuildMetaCalls()))
+
+	close(debugSessionReady)
+	debugSessions.Wait()
+	assert.Equal(t, 1, len(withoutEffectiveConfig(fakeAPI.UpdateBuildMetaCalls())))
+}
+
+// abort tracker keeping the build states in memory, by the build id suffixed with the architecture of matrix builds
+type mockAbortTracker struct {
+	// guards the state, pending starts are recorded by the deadline watch
+	mu     sync.Mutex
+	states map[string]string
+	// stop arriving while the build starts
+	stopDuringStart bool
+	pending         []abort.Record
+	resolved        []string
+	awaiting        []abort.Record
+	// hostnames of the builds which posted a heartbeat
+	heartbeats map[string]string
+}
+
+func (m *mockAbortTracker) Starting(buildID int, arch string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states[matrix.Key(buildID, arch)] == "ABORTED" {
+		return true, nil
+	}
+	m.states[matrix.Key(buildID, arch)] = "STARTING"
+	return false, nil
+}
+
+func (m *mockAbortTracker) Started(buildID int, arch string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopDuringStart {
+		m.states[matrix.Key(buildID, arch)] = "ABORTED"
+	}
+	if m.states[matrix.Key(buildID, arch)] == "ABORTED" {
+		return true, nil
+	}
+	m.states[matrix.Key(buildID, arch)] = "STARTED"
+	return false, nil
+}
+
+func (m *mockAbortTracker) Abort(buildID int, arch string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	starting := m.states[matrix.Key(buildID, arch)] == "STARTING"
+	m.states[matrix.Key(buildID, arch)] = "ABORTED"
+	return starting, nil
+}
+
+func (m *mockAbortTracker) Pending(buildID int, arch string, message string, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, abort.Record{BuildID: buildID, Architecture: arch, State: m.states[matrix.Key(buildID, arch)], PendingAt: time.Now().Unix(), Message: message, Token: token})
+	return nil
+}
+
+func (m *mockAbortTracker) ListPending(limit int) ([]abort.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pending, nil
+}
+
+func (m *mockAbortTracker) Resolve(buildID int, arch string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolved = append(m.resolved, matrix.Key(buildID, arch))
+	return nil
+}
+
+func (m *mockAbortTracker) AwaitHeartbeat(buildID int, arch string, message string, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.heartbeats[matrix.Key(buildID, arch)]; ok {
+		return nil
+	}
+	m.awaiting = append(m.awaiting, abort.Record{BuildID: buildID, Architecture: arch, State: m.states[matrix.Key(buildID, arch)], PendingAt: time.Now().Unix(), Message: message, Token: token})
+	return nil
+}
+
+func (m *mockAbortTracker) ListAwaitingHeartbeat(limit int) ([]abort.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.awaiting, nil
+}
+
+func (m *mockAbortTracker) Heartbeat(buildID int, arch string, hostname string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.states[matrix.Key(buildID, arch)]; !ok {
+		return false, nil
+	}
+	m.heartbeats[matrix.Key(buildID, arch)] = hostname
+	return true, nil
+}
+
+func testHeartbeats(t *testing.T) {
+	t.Setenv("SD_HEARTBEAT_URL", "https://heartbeat.lambda-url.us-west-2.on.aws/")
+	t.Setenv("SD_HEARTBEAT_SECRET", "s3cret")
+	heartbeats = heartbeat.FromEnv()
+	t.Cleanup(func() { heartbeats = nil })
+}
+
+// exchanges tokens for secrets named by the region, build id and architecture
+type fakeTokenExchanger struct {
+	secrets map[string]string
+	err     error
+}
+
+func (f *fakeTokenExchanger) Exchange(region string, buildID int, arch string, token string, roleArn string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	arn := fmt.Sprintf("arn:aws:secretsmanager:%s:111111111:secret:sd-build-tokens/%s", region, matrix.Key(buildID, arch))
+	f.secrets[arn] = roleArn
+	return arn, nil
+}
+
+func (f *fakeTokenExchanger) Revoke(region string, buildID int, arch string) error {
+	delete(f.secrets, fmt.Sprintf("arn:aws:secretsmanager:%s:111111111:secret:sd-build-tokens/%s", region, matrix.Key(buildID, arch)))
+	return nil
+}
+
+func TestStartExchangesToken(t *testing.T) {
+	useMockExecutors()
+	exchanger := &fakeTokenExchanger{secrets: map[string]string{}}
+	tokenExchanger = exchanger
+	defer func() { tokenExchanger = nil }()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	secret := "arn:aws:secretsmanager:us-east-2:111111111:secret:sd-build-tokens/1234"
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	startSlsConfig = nil
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, secret, startSlsConfig[buildtoken.ArnKey])
+	assert.Equal(t, map[string]string{secret: "arn:aws:iam::111111111:role/cd.screwdriver.consumer.integration-sdbuild"}, exchanger.secrets)
+
+	// the stop revokes the secret
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, 0, len(exchanger.secrets))
+
+	// eks builds without a scoped role keep their token
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, 0, len(exchanger.secrets))
+
+	// a failed exchange fails the build
+	exchanger.err = errors.New("Error-CreateSecret: AccessDeniedException")
+	wg.Add(1)
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Got error exchanging build token: Error-CreateSecret: AccessDeniedException"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestStartRevokesTokenOfUnacceptedStarts(t *testing.T) {
+	useMockExecutors()
+	exchanger := &fakeTokenExchanger{secrets: map[string]string{}}
+	tokenExchanger = exchanger
+	tracker := &mockAbortTracker{states: map[string]string{}}
+	abortTracker = tracker
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	defer func() {
+		tokenExchanger = nil
+		abortTracker = nil
+		loadPolicy = policy.Load
+		preflightSlsErr = nil
+	}()
+	api = sdtest.New().Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+
+	// rejected by the pre-flight check
+	t.Setenv("SD_PREFLIGHT_CHECKS", "true")
+	preflightSlsErr = errors.New("no free IP addresses in subnets subnet-0a7baed8f632d41c6")
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, 0, len(exchanger.secrets))
+	preflightSlsErr = nil
+
+	// stopped before it started
+	tracker.states = map[string]string{"1234": "ABORTED"}
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, 0, len(exchanger.secrets))
+
+	// stopped while it started
+	tracker.states = map[string]string{}
+	tracker.stopDuringStart = true
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, 0, len(exchanger.secrets))
+
+	// accepted starts keep the secret
+	tracker.states = map[string]string{}
+	tracker.stopDuringStart = false
+	assert.Nil(t, ProcessMessage(4, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, 1, len(exchanger.secrets))
+}
+
+func TestTokenReader(t *testing.T) {
+	provider := map[string]interface{}{"role": "arn:aws:iam::111111111:role/sd-build"}
+	buildConfig := map[string]interface{}{"provider": provider}
+	assert.Equal(t, "arn:aws:iam::111111111:role/sd-build", tokenReader(buildConfig, "sls"))
+	assert.Equal(t, "arn:aws:iam::111111111:role/sd-build", tokenReader(buildConfig, "ecs"))
+	assert.Equal(t, "", tokenReader(buildConfig, "eks"))
+
+	// ecs tasks read the secret with their execution role
+	provider["executionRole"] = "arn:aws:iam::111111111:role/sd-task-execution"
+	assert.Equal(t, "arn:aws:iam::111111111:role/sd-task-execution", tokenReader(buildConfig, "ecs"))
+	assert.Equal(t, "arn:aws:iam::111111111:role/sd-build", tokenReader(buildConfig, "sls"))
+}
+
+func TestStartAwaitsHeartbeat(t *testing.T) {
+	useMockExecutors()
+	testHeartbeats(t)
+	tracker := &mockAbortTracker{states: map[string]string{}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+	api = sdtest.New().Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	startSlsConfig = nil
+	value := testMessage(t, "start", "sls", nil)
+	assert.Nil(t, ProcessMessage(1, value, &wg, context.TODO()))
+	environment := startSlsConfig[policy.EnvironmentKey].(map[string]string)
+	assert.Equal(t, "https://heartbeat.lambda-url.us-west-2.on.aws/", environment["SD_HEARTBEAT_URL"])
+	assert.True(t, heartbeats.Verify(TestBuildID, "", environment["SD_HEARTBEAT_TOKEN"]))
+	assert.Equal(t, 1, len(tracker.awaiting))
+	assert.Equal(t, TestBuildID, tracker.awaiting[0].BuildID)
+	// the message is kept without its token
+	stripped, _, _ := message.WithoutToken(value)
+	assert.Equal(t, stripped, tracker.awaiting[0].Message)
+	assert.NotContains(t, tracker.awaiting[0].Message, "testtoken")
+	assert.Equal(t, "testtoken", tracker.awaiting[0].Token)
+
+	// every architecture of a matrix build awaits a heartbeat of its own
+	wg.Add(1)
+	value = testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig[matrix.ArchitectureKey] = "arm64"
+	})
+	assert.Nil(t, ProcessMessage(2, value, &wg, context.TODO()))
+	environment = startSlsConfig[policy.EnvironmentKey].(map[string]string)
+	assert.Equal(t, "https://heartbeat.lambda-url.us-west-2.on.aws/?architecture=arm64", environment["SD_HEARTBEAT_URL"])
+	assert.True(t, heartbeats.Verify(TestBuildID, "arm64", environment["SD_HEARTBEAT_TOKEN"]))
+	assert.Equal(t, 2, len(tracker.awaiting))
+	assert.Equal(t, "arm64", tracker.awaiting[1].Architecture)
+	assert.Equal(t, map[string]string{"1234": "STARTED", "1234-arm64": "STARTED"}, tracker.states)
+
+	// a launcher posting its heartbeat before the start completed is not awaited
+	tracker.heartbeats = map[string]string{"1234": "ip-10-0-1-12"}
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, 2, len(tracker.awaiting))
+}
+
+func TestVerifyHeartbeats(t *testing.T) {
+	useMockExecutors()
+	testHeartbeats(t)
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	longAgo := time.Now().Add(-time.Hour).Unix()
+	tracker := &mockAbortTracker{awaiting: []abort.Record{
+		// within the timeout
+		{BuildID: 1, State: "STARTED", PendingAt: time.Now().Unix(), Message: testMessage(t, "start", "eks", nil)},
+		// eks has a started build whose launcher never initialized
+		{BuildID: 2, State: "STARTED", PendingAt: longAgo, Message: testMessage(t, "start", "eks", nil)},
+		// sls reports the build finished
+		{BuildID: 3, State: "STARTED", PendingAt: longAgo, Message: testMessage(t, "start", "sls", nil)},
+		{BuildID: 4, State: "ABORTED", PendingAt: longAgo, Message: testMessage(t, "start", "eks", nil)},
+		// the heartbeat arrived after all
+		{BuildID: 5, State: "STARTED", PendingAt: longAgo, HeartbeatAt: longAgo, Message: testMessage(t, "start", "eks", nil)},
+	}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+
+	stopFn, stopSlsFn = "", ""
+	verifyHeartbeats()
+	assert.Equal(t, []string{"2", "3", "4", "5"}, tracker.resolved)
+	assert.Equal(t, "stopeks", stopFn)
+	assert.Equal(t, "", stopSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: 2, StatusMessage: "The launcher did not initialize within 10m0s of the start of the build, restart the build"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestHandleHeartbeatRequest(t *testing.T) {
+	beat := func(method string, body string, token string) events.APIGatewayV2HTTPRequest {
+		request := events.APIGatewayV2HTTPRequest{Body: body, Headers: map[string]string{"authorization": "Bearer " + token}}
+		request.RequestContext.HTTP.Method = method
+		return request
+	}
+	response, _ := HandleHeartbeatRequest(context.TODO(), beat("POST", `{"buildId": 1234}`, ""))
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+
+	testHeartbeats(t)
+	tracker := &mockAbortTracker{states: map[string]string{"1234": "STARTED", "1234-arm64": "STARTED"}, heartbeats: map[string]string{}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+	token := heartbeats.Token(1234, "")
+
+	tests := []struct {
+		request events.APIGatewayV2HTTPRequest
+		status  int
+	}{
+		{beat("POST", `{"buildId": 1234, "hostname": "ip-10-0-1-12"}`, token), http.StatusNoContent},
+		{beat("GET", "", token), http.StatusMethodNotAllowed},
+		{beat("POST", `{"hostname": "ip-10-0-1-12"}`, token), http.StatusBadRequest},
+		{beat("POST", `{"buildId": 1234}`, heartbeats.Token(1235, "")), http.StatusUnauthorized},
+		{beat("POST", `{"buildId": 1235}`, heartbeats.Token(1235, "")), http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response, err := HandleHeartbeatRequest(context.TODO(), test.request)
+		assert.Nil(t, err)
+		assert.Equal(t, test.status, response.StatusCode, test.request.Body)
+	}
+	assert.Equal(t, map[string]string{"1234": "ip-10-0-1-12"}, tracker.heartbeats)
+
+	// function urls encode binary bodies
+	encoded := beat("POST", base64.StdEncoding.EncodeToString([]byte(`{"buildId": 1234, "hostname": "ip-10-0-1-13"}`)), token)
+	encoded.IsBase64Encoded = true
+	response, _ = HandleHeartbeatRequest(context.TODO(), encoded)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.Equal(t, "ip-10-0-1-13", tracker.heartbeats["1234"])
+
+	// matrix builds post to the url carrying their architecture
+	arm := beat("POST", `{"buildId": 1234, "hostname": "ip-10-0-1-14"}`, heartbeats.Token(1234, "arm64"))
+	arm.QueryStringParameters = map[string]string{"architecture": "arm64"}
+	response, _ = HandleHeartbeatRequest(context.TODO(), arm)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.Equal(t, "ip-10-0-1-14", tracker.heartbeats["1234-arm64"])
+	arm.Headers["authorization"] = "Bearer " + token
+	response, _ = HandleHeartbeatRequest(context.TODO(), arm)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
+
+func TestStartAborted(t *testing.T) {
+	useMockExecutors()
+	tracker := &mockAbortTracker{states: map[string]string{}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	// stop arrives before the start
+	startSlsFn, stopSlsFn = "", ""
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, "stopsls", stopSlsFn)
+
+	// stop arrives while the build starts
+	tracker.states = map[string]string{}
+	tracker.stopDuringStart = true
+	startSlsFn, stopSlsFn = "", ""
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, "stopsls", stopSlsFn)
+	assert.Equal(t, "ABORTED", tracker.states["1234"])
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildCalls()))
+}
+
+func TestWatchDeadline(t *testing.T) {
+	tracker := &mockAbortTracker{states: map[string]string{"1234": "STARTING"}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+	t.Setenv("SD_START_DEADLINE_MARGIN_SECS", "1")
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second+10*time.Millisecond)
+	defer cancel()
+	value := testMessage(t, "start", "sls", nil)
+	stopWatch := watchDeadline(ctx, TestBuildID, "", value)
+	time.Sleep(100 * time.Millisecond)
+	stopWatch()
+	stripped, _, _ := message.WithoutToken(value)
+	assert.Equal(t, []abort.Record{{BuildID: TestBuildID, State: "STARTING", PendingAt: tracker.pending[0].PendingAt, Message: stripped, Token: "testtoken"}}, tracker.pending)
+
+	// completes in time
+	tracker.pending = nil
+	watchDeadline(context.TODO(), TestBuildID, "", value)()
+	ctx, cancel = context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+	watchDeadline(ctx, TestBuildID, "", value)()
+	assert.Nil(t, tracker.pending)
+}
+
+// abort tracker signaling when a pending start is being recorded, and holding it until released
+type slowPendingTracker struct {
+	*mockAbortTracker
+	recording, release chan struct{}
+}
+
+func (m *slowPendingTracker) Pending(buildID int, arch string, message string, token string) error {
+	close(m.recording)
+	<-m.release
+	return m.mockAbortTracker.Pending(buildID, arch, message, token)
+}
+
+func TestWatchDeadlineWaitsForRecording(t *testing.T) {
+	tracker := &slowPendingTracker{mockAbortTracker: &mockAbortTracker{states: map[string]string{}},
+		recording: make(chan struct{}), release: make(chan struct{})}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+	t.Setenv("SD_START_DEADLINE_MARGIN_SECS", "1")
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	stopWatch := watchDeadline(ctx, TestBuildID, "", testMessage(t, "start", "sls", nil))
+	<-tracker.recording
+	stopped := make(chan struct{})
+	go func() {
+		stopWatch()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stopped the watch while the start is being recorded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(tracker.release)
+	<-stopped
+	assert.Equal(t, 1, len(tracker.pending))
+}
+
+func TestVerifyPendingStarts(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	longAgo := time.Now().Add(-time.Hour).Unix()
+	tracker := &mockAbortTracker{pending: []abort.Record{
+		// sls status reports the build failed
+		{BuildID: 1, State: "STARTING", PendingAt: time.Now().Unix(), Message: testMessage(t, "start", "sls", nil)},
+		// eks has no pod for the build yet
+		{BuildID: 2, State: "STARTING", PendingAt: time.Now().Unix(), Message: testMessage(t, "start", "eks", nil)},
+		// eks has no pod for the build long after the start
+		{BuildID: 3, State: "STARTING", PendingAt: longAgo, Message: testMessage(t, "start", "eks", nil)},
+		{BuildID: 4, State: "ABORTED", PendingAt: longAgo, Message: testMessage(t, "start", "sls", nil)},
+	}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+
+	stopSlsFn = ""
+	verifyPendingStarts()
+	assert.Equal(t, []string{"1", "3", "4"}, tracker.resolved)
+	assert.Equal(t, "stopsls", stopSlsFn)
+	calls := fakeAPI.UpdateBuildStatusCalls()
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, "Build failed to start: FAILED in BUILD phase", calls[0].StatusMessage)
+	assert.Equal(t, "Build did not start before the consumer timed out, restart the build", calls[1].StatusMessage)
+}
+
+func TestRecordBuildToken(t *testing.T) {
+	useMockExecutors()
+	var tokens []string
+	api = func(url, token string) (sd.API, error) {
+		tokens = append(tokens, token)
+		return sdtest.New(), nil
+	}
+	stripped, _, err := message.WithoutToken(testMessage(t, "start", "sls", nil))
+	assert.Nil(t, err)
+
+	// the unsealed token of the record, none without a kms key
+	_, buildConfig, _, ok := recordBuild(abort.Record{BuildID: TestBuildID, Message: stripped, Token: "sealedtoken"})
+	assert.True(t, ok)
+	assert.Equal(t, "sealedtoken", buildConfig["token"])
+	_, _, _, ok = recordBuild(abort.Record{BuildID: TestBuildID, Message: stripped})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"sealedtoken", ""}, tokens)
+}
+
+func TestStartFailover(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	unavailableSlsRegions = map[string]bool{"us-east-2": true, "us-east-1": true}
+	defer func() { unavailableSlsRegions = nil }()
+	fallbacks := func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["fallbackRegions"] = []interface{}{
+			"us-east-1",
+			map[string]interface{}{"region": "us-west-2", "vpc": map[string]interface{}{"vpcId": "vpc-usw2"}},
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	startSlsConfig = nil
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", fallbacks), &wg, context.TODO()))
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "us-west-2", provider["buildRegion"])
+	assert.Equal(t, map[string]interface{}{"vpcId": "vpc-usw2"}, provider["vpc"])
+	calls := fakeAPI.UpdateBuildCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.Equal(t, "us-west-2", calls[0].Stats["buildRegion"])
+	assert.Equal(t, "us-east-2", calls[0].Stats["failedOverFrom"])
+
+	// all regions unavailable
+	unavailableSlsRegions["us-west-2"] = true
+	startSlsConfig = nil
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", fallbacks), &wg, context.TODO()))
+	assert.Nil(t, startSlsConfig)
+	assert.Equal(t, 1, len(fakeAPI.UpdateBuildCalls()))
+}
+
+func TestStartFailoverResolvesRegion(t *testing.T) {
+	useMockExecutors()
+	resolver := &mockRoleResolver{regional: true}
+	roleResolver = resolver
+	imageRewriter = &image.Rewriter{Rules: []image.Rule{
+		{Prefix: "docker.io/", Replacement: "{accountId}.dkr.ecr.{region}.amazonaws.com/docker-hub/"},
+	}}
+	exchanger := &fakeTokenExchanger{secrets: map[string]string{}}
+	tokenExchanger = exchanger
+	unavailableSlsRegions = map[string]bool{"us-east-2": true}
+	defer func() {
+		roleResolver = nil
+		imageRewriter = nil
+		tokenExchanger = nil
+		unavailableSlsRegions = nil
+	}()
+	api = sdtest.New().Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	startSlsConfig = nil
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["container"] = "node:12"
+		buildConfig["provider"].(map[string]interface{})["fallbackRegions"] = []interface{}{"us-west-2"}
+	}), &wg, context.TODO()))
+	assert.Equal(t, "111111111.dkr.ecr.us-west-2.amazonaws.com/docker-hub/library/node:12", startSlsConfig["container"])
+	provider := startSlsConfig["provider"].(map[string]interface{})
+	assert.Equal(t, "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-1898-us-west-2", provider["scopedRole"])
+	assert.Equal(t, "us-west-2", resolver.templates[len(resolver.templates)-1].Region)
+	// the token secret stays in the build region, readable by the role of the fallback region
+	assert.Equal(t, map[string]string{
+		"arn:aws:secretsmanager:us-east-2:111111111:secret:sd-build-tokens/1234": "arn:aws:iam::111111111:role/screwdriver/sd-pipeline-1898-us-west-2",
+	}, exchanger.secrets)
+}
+
+func TestStopFallbackRegions(t *testing.T) {
+	useMockExecutors()
+	api = sdtest.New().Factory()
+
+	// without receipts a stop also stops the build in the fallback regions it may have failed over to
+	stopSlsRegions = nil
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["fallbackRegions"] = []interface{}{
+			"us-east-2",
+			map[string]interface{}{"region": "us-west-2", "bucket": "sd-builds-usw2"},
+		}
+	}), &wg, context.TODO()))
+	assert.Equal(t, []string{"us-east-2", "us-west-2"}, stopSlsRegions)
+	assert.Equal(t, "sd-builds-usw2", stopSlsConfig["provider"].(map[string]interface{})["bucket"])
+}
+
+// delay queue recording the requeued messages
+type mockRequeue struct {
+	attempts []int
+}
+
+func (m *mockRequeue) Requeue(value string, attempt int) (bool, error) {
+	if attempt > m.MaxAttempts() {
+		return false, nil
+	}
+	m.attempts = append(m.attempts, attempt)
+	return true, nil
+}
+
+func (m *mockRequeue) Delay(attempt int) time.Duration {
+	return time.Duration(attempt) * time.Minute
+}
+
+func (m *mockRequeue) MaxAttempts() int {
+	return 2
+}
+
+type mockPause struct {
+	paused bool
+	err    error
+}
+
+func (m *mockPause) Paused() (bool, error) {
+	return m.paused, m.err
+}
+
+func TestStartPaused(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	startPause = &mockPause{paused: true}
+	queue := &mockRequeue{}
+	requeueQueue = queue
+	defer func() {
+		loadPolicy = policy.Load
+		startPause = nil
+		requeueQueue = nil
+	}()
+
+	// paused starts are requeued without using up an attempt
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.WithValue(context.TODO(), attemptKey, 1)))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []int{1}, queue.attempts)
+	assert.Equal(t, []sdtest.UpdateBuildCall{
+		{BuildID: TestBuildID, StatusMessage: "Starts are paused by the Screwdriver admins, retrying in 1m0s"},
+	}, fakeAPI.UpdateBuildCalls())
+
+	// builds are still stopped
+	stopSlsFn = ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "stopsls", stopSlsFn)
+
+	// paused starts fail without a delay queue
+	requeueQueue = nil
+	fakeAPI = sdtest.New()
+	api = fakeAPI.Factory()
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Starts are paused by the Screwdriver admins, restart the build once they are resumed"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// builds start when the switch cannot be read
+	startPause = &mockPause{err: errors.New("Error-GetParameter: ThrottlingException")}
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+}
+
+func TestAPIClientErrors(t *testing.T) {
+	useMockExecutors()
+	api = func(url, token string) (sd.API, error) {
+		return nil, errors.New("configuring tls: open /etc/sd/client.pem: no such file or directory")
+	}
+	tracker := &mockAbortTracker{pending: []abort.Record{
+		{BuildID: TestBuildID, State: "ABORTED", PendingAt: time.Now().Unix(), Message: testMessage(t, "start", "sls", nil)},
+	}}
+	abortTracker = tracker
+	defer func() { abortTracker = nil }()
+
+	// the message is skipped instead of using a nil client
+	startSlsFn = ""
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.EqualError(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()),
+		"Got error creating api client of build 1234: configuring tls: open /etc/sd/client.pem: no such file or directory")
+	assert.Equal(t, "", startSlsFn)
+
+	// pending records are left alone
+	stopSlsFn = ""
+	executor, _, _, ok := recordBuild(tracker.pending[0])
+	assert.Nil(t, executor)
+	assert.False(t, ok)
+	assert.Equal(t, "", stopSlsFn)
+
+	// paused starts are still requeued
+	startPause = &mockPause{paused: true}
+	queue := &mockRequeue{}
+	requeueQueue = queue
+	defer func() {
+		startPause = nil
+		requeueQueue = nil
+	}()
+	value := testMessage(t, "start", "sls", nil)
+	buildMessage, _ := decodeMessage(value)
+	assert.True(t, pauseStart(context.TODO(), value, buildMessage))
+	assert.Equal(t, []int{0}, queue.attempts)
+	requeueQueue = nil
+	assert.True(t, pauseStart(context.TODO(), value, buildMessage))
+}
+
+func TestStartRejectedByAdmission(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	startEksErr = fmt.Errorf("Error creating pod %w", executorState.AdmissionErrorf("the build pod was rejected by a policy of the cluster, not by Screwdriver: %s",
+		`admission webhook "validation.gatekeeper.sh" denied the request: [no-host-path] hostPath volumes are not allowed`))
+	defer func() {
+		loadPolicy = policy.Load
+		startEksErr = nil
+	}()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `the build pod was rejected by a policy of the cluster, not by Screwdriver: admission webhook "validation.gatekeeper.sh" denied the request: [no-host-path] hostPath volumes are not allowed`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// other start errors are not reported as a policy rejection
+	startEksErr = errors.New("Error creating pod pods is forbidden: User \"sd-consumer\" cannot create resource \"pods\"")
+	fakeAPI = sdtest.New()
+	api = fakeAPI.Factory()
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildStatusCalls()))
+}
+
+func TestStartDiagnostics(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	fakeStore := sdtest.NewStore()
+	defer func(orig func(string, string) (sd.Store, error)) { store = orig }(store)
+	store = fakeStore.Factory()
+	defer func() {
+		loadPolicy = policy.Load
+		startEksErr = nil
+	}()
+	api = sdtest.New().Factory()
+	startEksErr = fmt.Errorf("Error creating pod %w", executorState.AdmissionErrorf("the build pod was rejected by a policy of the cluster, not by Screwdriver: %s",
+		`admission webhook "validation.gatekeeper.sh" denied the request`))
+
+	// diagnostics are uploaded once enabled
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Empty(t, fakeStore.UploadArtifactCalls())
+
+	t.Setenv("SD_START_DIAGNOSTICS", "true")
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	calls := fakeStore.UploadArtifactCalls()
+	assert.Len(t, calls, 1)
+	assert.Equal(t, TestBuildID, calls[0].BuildID)
+	assert.Equal(t, "aws-start-diagnostics.json", calls[0].Name)
+	assert.Equal(t, "application/json", calls[0].ContentType)
+	assert.NotContains(t, calls[0].Body, "testtoken")
+
+	var d diagnostics.Start
+	assert.Nil(t, json.Unmarshal([]byte(calls[0].Body), &d))
+	assert.Equal(t, "eks", d.Executor)
+	assert.Equal(t, "policy", d.Category)
+	assert.Equal(t, `the build pod was rejected by a policy of the cluster, not by Screwdriver: admission webhook "validation.gatekeeper.sh" denied the request`, d.StatusMessage)
+	assert.Len(t, d.Errors, 2)
+	assert.Equal(t, []string{"2022-03-01T10:00:00Z Warning FailedCreate Job/1234-job: admission webhook denied the request"}, d.Events)
+	assert.Equal(t, redact.Mask, d.BuildConfig["token"])
+}
+
+func TestStartCategorizedError(t *testing.T) {
+	useMockExecutors()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{}, nil
+	}
+	queue := &mockRequeue{}
+	requeueQueue = queue
+	defer func() {
+		loadPolicy = policy.Load
+		startEksErr = nil
+		requeueQueue = nil
+	}()
+
+	// errors which need a fix fail the build telling who can fix them
+	for _, test := range []struct {
+		err           error
+		statusMessage string
+	}{
+		{executorState.Errorf(executorState.UserError, "invalid workload %q, use one of pod or job", "deployment"), `Invalid build configuration: invalid workload "deployment", use one of pod or job`},
+		{executorState.Errorf(executorState.InfraPermanent, "Error creating pod pods is forbidden"), "Infrastructure failure, contact your Screwdriver admins: Error creating pod pods is forbidden"},
+	} {
+		startEksErr = test.err
+		fakeAPI := sdtest.New()
+		api = fakeAPI.Factory()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+		assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+			{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: test.statusMessage},
+		}, fakeAPI.UpdateBuildStatusCalls())
+	}
+	assert.Equal(t, 0, len(queue.attempts))
+
+	// transient errors are requeued
+	startEksErr = executorState.Errorf(executorState.InfraTransient, "Error creating pod throttled: the server has received too many requests")
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, []int{1}, queue.attempts)
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildStatusCalls()))
+}
+
+func TestStartRequeue(t *testing.T) {
+	useMockExecutors()
+	t.Setenv("SD_PREFLIGHT_CHECKS", "true")
+	preflightSlsErr = executorState.CapacityErrorf("concurrent build limit reached: 3 of 3 builds running")
+	queue := &mockRequeue{}
+	requeueQueue = queue
+	defer func() {
+		preflightSlsErr = nil
+		requeueQueue = nil
+	}()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+
+	response, err := HandleQueueRequest(context.TODO(), events.SQSEvent{Records: []events.SQSMessage{{
+		MessageId:         "1",
+		Body:              testMessage(t, "start", "sls", nil),
+		MessageAttributes: map[string]events.SQSMessageAttribute{"attempt": {StringValue: aws.String("1"), DataType: "Number"}},
+	}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "Finished processing messages: 1", response)
+	assert.Equal(t, []int{2}, queue.attempts)
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildStatusCalls()))
+	assert.Equal(t, "Waiting for capacity, retrying in 2m0s (attempt 2 of 2): concurrent build limit reached: 3 of 3 builds running", fakeAPI.UpdateBuildCalls()[0].StatusMessage)
+
+	// attempts are used up
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.WithValue(context.TODO(), attemptKey, 2)))
+	assert.Equal(t, []int{2}, queue.attempts)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Pre-flight check failed: concurrent build limit reached: 3 of 3 builds running"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// regional outages of the start are requeued
+	preflightSlsErr = nil
+	unavailableSlsRegions = map[string]bool{"us-east-2": true}
+	defer func() { unavailableSlsRegions = nil }()
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, []int{2, 1}, queue.attempts)
+}
+
+// records the notified events
+type mockSink struct {
+	events []notify.Event
+}
+
+func (m *mockSink) Send(event notify.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func TestNotifyInfrastructureFailures(t *testing.T) {
+	useMockExecutors()
+	testHeartbeats(t)
+	t.Setenv("SD_PREFLIGHT_CHECKS", "true")
+	preflightSlsErr = executorState.CapacityErrorf("concurrent build limit reached: 3 of 3 builds running")
+	requeueQueue = &mockRequeue{}
+	sink := &mockSink{}
+	notifier = notify.New(sink)
+	tracker := &mockAbortTracker{awaiting: []abort.Record{
+		{BuildID: 2, State: "STARTED", PendingAt: time.Now().Add(-time.Hour).Unix(), Message: testMessage(t, "start", "eks", nil)},
+	}}
+	abortTracker = tracker
+	defer func() {
+		preflightSlsErr = nil
+		requeueQueue = nil
+		notifier = nil
+		abortTracker = nil
+	}()
+	api = sdtest.New().Factory()
+
+	// the attempts of the start are used up
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.WithValue(context.TODO(), attemptKey, 2)))
+	verifyHeartbeats()
+
+	var notified []string
+	for _, event := range sink.events {
+		notified = append(notified, event.String())
+	}
+	assert.Equal(t, []string{
+		"aws consumer dead-letter: Gave up on build 1234 after 2 attempts: concurrent build limit reached: 3 of 3 builds running",
+		"aws consumer quota-exhausted of account 111111111: Build 1234 found no capacity: concurrent build limit reached: 3 of 3 builds running",
+		"aws consumer reconciler-kill of account 111111111: Stopped build 2 whose launcher did not post a heartbeat within 10m0s",
+	}, notified)
+}
+
+// spend by scope/id of every month
+type fakeSpend map[string]float64
+
+func (f fakeSpend) Spend(scope string, id string, month string) (float64, error) {
+	return f[scope+"/"+id], nil
+}
+
+func (f fakeSpend) Add(scope string, id string, month string, cost float64) error {
+	f[scope+"/"+id] += cost
+	return nil
+}
+
+func TestStartRejectedByBudget(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{Budgets: policy.Budgets{Pipelines: map[string]float64{"1898": 500}}}, nil
+	}
+	budgetTracker = fakeSpend{"pipeline/1898": 512.3}
+	defer func() {
+		loadPolicy = policy.Load
+		budgetTracker = nil
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["isPR"] = true
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	calls := fakeAPI.UpdateBuildStatusCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.True(t, strings.HasPrefix(calls[0].StatusMessage, "Rejected by policy: monthly budget of pipeline 1898 is exceeded, spent $512.30 of $500.00 in "), calls[0].StatusMessage)
+}
+
+// spend which cannot be read
+type unreadableSpend struct {
+	fakeSpend
+}
+
+func (unreadableSpend) Spend(scope string, id string, month string) (float64, error) {
+	return 0, errors.New("Error-GetItem: ProvisionedThroughputExceededException")
+}
+
+func TestStartBudgetUnreadable(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{Budgets: policy.Budgets{Pipelines: map[string]float64{"1898": 500}}}, nil
+	}
+	budgetTracker = unreadableSpend{}
+	queue := &mockRequeue{}
+	requeueQueue = queue
+	defer func() {
+		loadPolicy = policy.Load
+		budgetTracker = nil
+		requeueQueue = nil
+	}()
+	pr := func(buildConfig map[string]interface{}) {
+		buildConfig["isPR"] = true
+	}
+
+	// retried with a delay queue
+	var wg sync.WaitGroup
+	wg.Add(2)
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", pr), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []int{1}, queue.attempts)
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildStatusCalls()))
+
+	// started without one
+	requeueQueue = nil
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", pr), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	assert.Equal(t, 0, len(fakeAPI.UpdateBuildStatusCalls()))
+}
+
+func TestCostReportRecordsSpend(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{Budgets: policy.Budgets{Accounts: map[string]float64{"111111111": 100}}}, nil
+	}
+	spend := fakeSpend{"account/111111111": 40}
+	budgetTracker = spend
+	reporter := costReporter
+	defer func() {
+		loadPolicy = policy.Load
+		budgetTracker = nil
+		costReporter = reporter
+	}()
+	costReporter = &mockCostReporter{summary: &cost.Summary{
+		Month:     time.Now().UTC().Format("2006-01"),
+		Pipelines: []cost.PipelineCost{{PipelineID: "1898", Cost: 12.5}},
+		Accounts:  []cost.AccountCost{{AccountID: "111111111", Cost: 60}},
+	}}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	message := base64.StdEncoding.EncodeToString([]byte(`{"job": "cost-report"}`))
+	assert.Nil(t, ProcessMessage(1, message, &wg, context.TODO()))
+	assert.Equal(t, fakeSpend{"pipeline/1898": 12.5, "account/111111111": 60}, spend)
+
+	// the next report only adds the cost since the last one, going over the budget of the account
+	costReporter = &mockCostReporter{summary: &cost.Summary{
+		Month:    time.Now().UTC().Format("2006-01"),
+		Accounts: []cost.AccountCost{{AccountID: "111111111", Cost: 120}},
+	}}
+	assert.Nil(t, ProcessMessage(2, message, &wg, context.TODO()))
+	assert.Equal(t, float64(120), spend["account/111111111"])
+
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["isPR"] = true
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	calls := fakeAPI.UpdateBuildStatusCalls()
+	assert.Equal(t, 1, len(calls))
+	assert.True(t, strings.HasPrefix(calls[0].StatusMessage, "Rejected by policy: monthly budget of account 111111111 is exceeded, spent $120.00 of $100.00 in "), calls[0].StatusMessage)
+}
+
+func TestStartNaming(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	naming := policy.Naming{Prefix: "sd-prod", IncludePipelineID: true}
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{Naming: naming}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	startSlsFn, startSlsConfig = "", nil
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "startsls", startSlsFn)
+	pipelineID := fmt.Sprint(startSlsConfig["pipelineId"])
+	assert.Regexp(t, `^sd-prod-`+pipelineID+`-.+-[0-9]+$`, startSlsConfig[policy.ProjectNameKey])
+	assert.Equal(t, "sd-prod-"+pipelineID, startSlsConfig[policy.PodNamePrefixKey])
+
+	naming.Prefix = "SD_PROD"
+	startSlsFn = ""
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: `Rejected by policy: naming prefix "SD_PROD" must be up to 32 lowercase letters, digits or dashes`},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func routedRecord(offset int64, job string, executorType string) events.KafkaRecord {
+	record := events.KafkaRecord{Topic: TestTopic, Offset: offset, Value: fmt.Sprint(offset)}
+	if job != "" {
+		record.Headers = append(record.Headers, map[string][]byte{"sd-job": []byte(job)})
+	}
+	if executorType != "" {
+		record.Headers = append(record.Headers, map[string][]byte{"sd-executor": []byte(executorType)})
+	}
+	return record
+}
+
+func TestRouteRecords(t *testing.T) {
+	records := []events.KafkaRecord{
+		routedRecord(1, "start", "sls"),
+		routedRecord(2, "", ""),
+		routedRecord(3, "stop", "eks"),
+		routedRecord(4, "start", "ecs"),
+		routedRecord(5, "cleanup", ""),
+	}
+	// without the idempotency table the stop of a build must not overtake its start
+	assert.Equal(t, [][]int{{0, 1, 2, 4}}, routeRecords(records))
+	abortTracker = &mockAbortTracker{states: map[string]string{}}
+	assert.Equal(t, [][]int{{2, 4}, {0, 1}}, routeRecords(records))
+	abortTracker = nil
+
+	job, executorType := recordRoute(records[2])
+	assert.Equal(t, "stop", job)
+	assert.Equal(t, "eks", executorType)
+	job, executorType = recordRoute(records[1])
+	assert.Equal(t, "", job)
+	assert.Equal(t, "", executorType)
+}
+
+func TestHandleRequestRouting(t *testing.T) {
+	var mu sync.Mutex
+	var processed []string
+	processMessage := ProcessMessage
+	ProcessMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		processed = append(processed, value)
+		return nil
+	}
+	defer func() { ProcessMessage = processMessage }()
+
+	var event events.KafkaEvent
+	assert.Nil(t, json.Unmarshal([]byte(`{"records": {"builds-0": [
+		{"topic": "builds", "offset": 1, "value": "1", "headers": [{"sd-job": [115, 116, 97, 114, 116]}]},
+		{"topic": "builds", "offset": 2, "value": "2", "headers": [{"sd-job": [115, 116, 111, 112]}, {"sd-executor": [115, 108, 115]}]}
+	]}}`), &event))
+	// with the idempotency table the stop is processed before the start
+	abortTracker = &mockAbortTracker{states: map[string]string{}}
+	defer func() { abortTracker = nil }()
+	response, err := HandleRequest(context.TODO(), event)
+	assert.Nil(t, err)
+	assert.Equal(t, "Finished processing messages: 2", response)
+	assert.Equal(t, []string{"2", "1"}, processed)
+
+	// without it both are processed together, in no particular order
+	abortTracker = nil
+	processed = nil
+	response, err = HandleRequest(context.TODO(), event)
+	assert.Nil(t, err)
+	assert.Equal(t, "Finished processing messages: 2", response)
+	assert.ElementsMatch(t, []string{"1", "2"}, processed)
+}
+
+type fakeRecordArchive struct {
+	archived [][]events.KafkaRecord
+	err      error
+}
+
+func (a *fakeRecordArchive) Archive(records []events.KafkaRecord) (string, error) {
+	a.archived = append(a.archived, records)
+	return "records/dt=2022-03-01/topic=builds/0-1.jsonl", a.err
+}
+
+func TestHandleRequestArchive(t *testing.T) {
+	processMessage := ProcessMessage
+	var mu sync.Mutex
+	var processed int
+	ProcessMessage = func(id int, value string, wg *sync.WaitGroup, ctx context.Context) error {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		processed++
+		return nil
+	}
+	fake := &fakeRecordArchive{}
+	recordArchive = fake
+	defer func() { ProcessMessage, recordArchive = processMessage, nil }()
+
+	event := events.KafkaEvent{Records: map[string][]events.KafkaRecord{
+		"builds-0": {routedRecord(1, "start", "sls"), routedRecord(2, "stop", "sls")},
+	}}
+	_, err := HandleRequest(context.TODO(), event)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]events.KafkaRecord{event.Records["builds-0"]}, fake.archived)
+	assert.Equal(t, 2, processed)
+
+	// records are processed when the archive fails
+	fake.err = errors.New("Error-PutObject: AccessDenied: Access Denied")
+	_, err = HandleRequest(context.TODO(), event)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(fake.archived))
+	assert.Equal(t, 4, processed)
+}
+
+func TestStartExecutorOverride(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	loadPolicy = func() (*policy.Policy, error) {
+		return &policy.Policy{ExecutorOverrides: map[string]string{"1898": "eks"}}, nil
+	}
+	defer func() { loadPolicy = policy.Load }()
+	startFn, startSlsFn = "", ""
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = json.Number("1898")
+		provider := buildConfig["provider"].(map[string]interface{})
+		provider["namespace"] = "sd-builds"
+		provider["clusterName"] = "sd-build"
+	}), &wg, context.TODO()))
+	assert.Equal(t, "starteks", startFn)
+	assert.Equal(t, "", startSlsFn)
+	assert.Empty(t, fakeAPI.UpdateBuildStatusCalls())
+
+	// the provider of the pipeline is not set up for the forced executor
+	startFn = ""
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = json.Number("1898")
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startFn)
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "executor override eks of pipeline 1898: buildConfig.provider.namespace is required, buildConfig.provider.clusterName is required"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+
+	// stops go to the executor of the start receipt, or of their message, not the override
+	stopFn, stopSlsFn = "", ""
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(3, testMessage(t, "stop", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["pipelineId"] = json.Number("1898")
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", stopFn)
+	assert.Equal(t, "stopsls", stopSlsFn)
+}
+
+func TestStartExceedingLimits(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	startSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "start", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["jobName"] = strings.Repeat("j", 10000)
+	}), &wg, context.TODO()))
+	assert.Equal(t, "", startSlsFn)
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: TestBuildID, StatusMessage: "Build message exceeds limits: buildConfig.jobName is longer than 8192 characters"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+}
+
+func TestStopReportsCacheStats(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	stopSlsFn = ""
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "sls", nil), &wg, context.TODO()))
+	assert.Equal(t, "stopsls", stopSlsFn)
+	assert.Empty(t, fakeAPI.UpdateBuildCalls())
+
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "stop", "sls", func(buildConfig map[string]interface{}) {
+		buildConfig["provider"].(map[string]interface{})["dlc"] = true
+	}), &wg, context.TODO()))
+	assert.Equal(t, []sdtest.UpdateBuildCall{{
+		Stats:   map[string]interface{}{"cache": map[string]interface{}{"modes": []string{"LOCAL_DOCKER_LAYER_CACHE"}, "warm": true}},
+		BuildID: TestBuildID,
+	}}, fakeAPI.UpdateBuildCalls())
+}
+
+func TestStopReportsContainerExits(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	defer func() { containerExits = nil }()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, "stopeks", stopFn)
+	assert.Empty(t, fakeAPI.UpdateBuildCalls())
+
+	containerExits = []executorState.ContainerExit{
+		{Container: "1234", ExitCode: 137, Reason: "OOMKilled", OOMKilled: true, MemoryLimit: "2Gi"},
+		{Container: "launcher", Reason: "Completed"},
+	}
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "stop", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, []sdtest.UpdateBuildCall{{
+		Stats:         map[string]interface{}{"containerExits": containerExits},
+		BuildID:       TestBuildID,
+		StatusMessage: "Container 1234 was OOMKilled with exit code 137, it ran out of its memory limit of 2Gi",
+	}}, fakeAPI.UpdateBuildCalls())
+}
+
+func TestStopReportsSizingRecommendation(t *testing.T) {
+	useMockExecutors()
+	fakeAPI := sdtest.New()
+	api = fakeAPI.Factory()
+	defer func() { sizingRecommendation = nil }()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	assert.Nil(t, ProcessMessage(1, testMessage(t, "stop", "eks", nil), &wg, context.TODO()))
+	assert.Empty(t, fakeAPI.UpdateBuildMetaCalls())
+
+	sizingRecommendation = map[string]interface{}{
+		"source":      "metrics-server",
+		"annotations": map[string]string{"screwdriver.cd/cpu": "1.5", "screwdriver.cd/ram": "4"},
+	}
+	assert.Nil(t, ProcessMessage(2, testMessage(t, "stop", "eks", nil), &wg, context.TODO()))
+	assert.Equal(t, []sdtest.UpdateBuildMetaCall{
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{"sizing": sizingRecommendation}}, BuildID: TestBuildID},
+	}, fakeAPI.UpdateBuildMetaCalls())
+}
@@ -0,0 +1,93 @@
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// deployment level defaults of the launcher feature flags
+	habitatEnv         = "SD_LAUNCHER_HABITAT"
+	baseCommandPathEnv = "SD_LAUNCHER_BASE_COMMAND_PATH"
+	sourceDirEnv       = "SD_LAUNCHER_SOURCE_DIR"
+
+	defaultBaseCommandPath = "/sd/commands/"
+)
+
+// habitat is mounted from the eks nodes, codebuild images, fargate tasks and ec2 builds do not ship it
+var defaultHabitat = map[string]bool{
+	"ec2": false,
+	"ecs": false,
+	"eks": true,
+	"sls": false,
+}
+
+// Flags are the launcher feature flags of a build
+type Flags struct {
+	Habitat         bool
+	BaseCommandPath string
+	SourceDir       string
+}
+
+// EnvVar is a launcher environment variable
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// gets a flag from the provider, falling back to the deployment env
+func flagValue(provider map[string]interface{}, key string, env string) (string, bool) {
+	switch v := provider[key].(type) {
+	case string:
+		if v != "" {
+			return v, true
+		}
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	value := os.Getenv(env)
+	return value, value != ""
+}
+
+// GetFlags resolves the launcher feature flags of the executor from the provider
+// (habitat, baseCommandPath, sourceDir), falling back to SD_LAUNCHER_* and the executor defaults
+func GetFlags(provider map[string]interface{}, executor string) (Flags, error) {
+	flags := Flags{Habitat: defaultHabitat[executor], BaseCommandPath: defaultBaseCommandPath}
+
+	if value, ok := flagValue(provider, "habitat", habitatEnv); ok {
+		habitat, err := strconv.ParseBool(value)
+		if err != nil {
+			return Flags{}, fmt.Errorf("invalid habitat flag %q", value)
+		}
+		flags.Habitat = habitat
+	}
+	if value, ok := flagValue(provider, "baseCommandPath", baseCommandPathEnv); ok {
+		if !path.IsAbs(value) {
+			return Flags{}, fmt.Errorf("baseCommandPath %q is not an absolute path", value)
+		}
+		flags.BaseCommandPath = value
+	}
+	if value, ok := flagValue(provider, "sourceDir", sourceDirEnv); ok {
+		dir := path.Clean(value)
+		if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			return Flags{}, fmt.Errorf("sourceDir %q must be relative to the checkout", value)
+		}
+		flags.SourceDir = dir
+	}
+	return flags, nil
+}
+
+// Env gets the environment variables passing the flags to the launcher
+func (f Flags) Env() []EnvVar {
+	env := []EnvVar{
+		{Name: "SD_HAB_ENABLED", Value: strconv.FormatBool(f.Habitat)},
+		{Name: "SD_BASE_COMMAND_PATH", Value: f.BaseCommandPath},
+	}
+	if f.SourceDir != "" {
+		env = append(env, EnvVar{Name: "SD_SOURCE_DIR", Value: f.SourceDir})
+	}
+	return env
+}
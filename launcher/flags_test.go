@@ -0,0 +1,53 @@
+package launcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFlags(t *testing.T) {
+	t.Setenv(habitatEnv, "")
+	t.Setenv(baseCommandPathEnv, "")
+	t.Setenv(sourceDirEnv, "")
+
+	flags, err := GetFlags(map[string]interface{}{}, "eks")
+	assert.Nil(t, err)
+	assert.Equal(t, Flags{Habitat: true, BaseCommandPath: "/sd/commands/"}, flags)
+
+	flags, err = GetFlags(map[string]interface{}{}, "sls")
+	assert.Nil(t, err)
+	assert.Equal(t, Flags{Habitat: false, BaseCommandPath: "/sd/commands/"}, flags)
+
+	t.Setenv(habitatEnv, "true")
+	t.Setenv(baseCommandPathEnv, "/opt/sd/commands/")
+	flags, err = GetFlags(map[string]interface{}{}, "sls")
+	assert.Nil(t, err)
+	assert.Equal(t, Flags{Habitat: true, BaseCommandPath: "/opt/sd/commands/"}, flags)
+
+	// provider flags take precedence over the deployment
+	flags, err = GetFlags(map[string]interface{}{"habitat": false, "sourceDir": "services/api/"}, "sls")
+	assert.Nil(t, err)
+	assert.Equal(t, Flags{Habitat: false, BaseCommandPath: "/opt/sd/commands/", SourceDir: "services/api"}, flags)
+
+	_, err = GetFlags(map[string]interface{}{"habitat": "maybe"}, "eks")
+	assert.EqualError(t, err, `invalid habitat flag "maybe"`)
+	_, err = GetFlags(map[string]interface{}{"baseCommandPath": "commands"}, "eks")
+	assert.EqualError(t, err, `baseCommandPath "commands" is not an absolute path`)
+	_, err = GetFlags(map[string]interface{}{"sourceDir": "../../etc"}, "eks")
+	assert.EqualError(t, err, `sourceDir "../../etc" must be relative to the checkout`)
+	_, err = GetFlags(map[string]interface{}{"sourceDir": "/etc"}, "eks")
+	assert.EqualError(t, err, `sourceDir "/etc" must be relative to the checkout`)
+}
+
+func TestFlagsEnv(t *testing.T) {
+	assert.Equal(t, []EnvVar{
+		{Name: "SD_HAB_ENABLED", Value: "false"},
+		{Name: "SD_BASE_COMMAND_PATH", Value: "/sd/commands/"},
+	}, Flags{BaseCommandPath: "/sd/commands/"}.Env())
+	assert.Equal(t, []EnvVar{
+		{Name: "SD_HAB_ENABLED", Value: "true"},
+		{Name: "SD_BASE_COMMAND_PATH", Value: "/sd/commands/"},
+		{Name: "SD_SOURCE_DIR", Value: "services/api"},
+	}, Flags{Habitat: true, BaseCommandPath: "/sd/commands/", SourceDir: "services/api"}.Env())
+}
@@ -0,0 +1,90 @@
+// Package launcher selects the launcher image and sdinit bundle matching the build architecture
+package launcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// AMD64 is the default build architecture
+	AMD64 = "amd64"
+	// ARM64 is the architecture of graviton builds
+	ARM64 = "arm64"
+	// imagesEnv holds a json map of "<executor>/<arch>" or "<arch>" to launcher image,
+	// e.g. {"arm64": "screwdrivercd/launcher:{version}-arm64"}
+	imagesEnv = "SD_LAUNCHER_IMAGES"
+	// versionPlaceholder is replaced with the launcher version in launcher images
+	versionPlaceholder = "{version}"
+)
+
+// aliases of the supported architectures
+var architectures = map[string]string{
+	"amd64":   AMD64,
+	"x86_64":  AMD64,
+	"arm64":   ARM64,
+	"aarch64": ARM64,
+}
+
+// Architecture gets the build architecture from provider.architecture,
+// falling back to the arm codebuild environment types
+func Architecture(provider map[string]interface{}) (string, error) {
+	if value, _ := provider["architecture"].(string); value != "" {
+		arch, ok := architectures[strings.ToLower(value)]
+		if !ok {
+			return "", fmt.Errorf("unsupported architecture %q", value)
+		}
+		return arch, nil
+	}
+	if environmentType, _ := provider["environmentType"].(string); strings.HasPrefix(environmentType, "ARM_") {
+		return ARM64, nil
+	}
+	return AMD64, nil
+}
+
+// Bundle gets the name of the sdinit bundle of the launcher version, non default architectures get their own bundle
+func Bundle(provider map[string]interface{}) string {
+	version, _ := provider["launcherVersion"].(string)
+	if arch, err := Architecture(provider); err == nil && arch != AMD64 {
+		return version + "-" + arch
+	}
+	return version
+}
+
+// gets the launcher image configured for the executor and architecture
+func configuredImage(executor string, arch string) (string, error) {
+	value := os.Getenv(imagesEnv)
+	if value == "" {
+		return "", nil
+	}
+	var images map[string]string
+	if err := json.Unmarshal([]byte(value), &images); err != nil {
+		return "", fmt.Errorf("Got error parsing %s: %v", imagesEnv, err)
+	}
+	if image := images[executor+"/"+arch]; image != "" {
+		return image, nil
+	}
+	return images[arch], nil
+}
+
+// Select sets provider.launcherImage to the image configured for the executor and build architecture
+// and validates the launcher feature flags. The launcher image of the message is kept when no image is configured.
+func Select(provider map[string]interface{}, executor string) error {
+	if _, err := GetFlags(provider, executor); err != nil {
+		return err
+	}
+	arch, err := Architecture(provider)
+	if err != nil {
+		return err
+	}
+	image, err := configuredImage(executor, arch)
+	if err != nil || image == "" {
+		return err
+	}
+	version, _ := provider["launcherVersion"].(string)
+	provider["launcherImage"] = strings.ReplaceAll(image, versionPlaceholder, version)
+
+	return nil
+}
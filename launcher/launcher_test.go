@@ -0,0 +1,61 @@
+package launcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchitecture(t *testing.T) {
+	tests := []struct {
+		provider map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{}, AMD64},
+		{map[string]interface{}{"environmentType": "LINUX_CONTAINER"}, AMD64},
+		{map[string]interface{}{"environmentType": "ARM_CONTAINER"}, ARM64},
+		{map[string]interface{}{"architecture": "aarch64"}, ARM64},
+		{map[string]interface{}{"architecture": "X86_64", "environmentType": "ARM_CONTAINER"}, AMD64},
+	}
+	for _, test := range tests {
+		arch, err := Architecture(test.provider)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, arch, test.provider)
+	}
+
+	_, err := Architecture(map[string]interface{}{"architecture": "riscv64"})
+	assert.EqualError(t, err, `unsupported architecture "riscv64"`)
+}
+
+func TestBundle(t *testing.T) {
+	assert.Equal(t, "v6.0.147", Bundle(map[string]interface{}{"launcherVersion": "v6.0.147"}))
+	assert.Equal(t, "v6.0.147-arm64", Bundle(map[string]interface{}{"launcherVersion": "v6.0.147", "environmentType": "ARM_CONTAINER"}))
+}
+
+func TestSelect(t *testing.T) {
+	t.Setenv("SD_LAUNCHER_IMAGES", "")
+	provider := map[string]interface{}{"launcherVersion": "v6.0.147", "launcherImage": "screwdrivercd/launcher:v6.0.147", "architecture": "arm64"}
+	assert.Nil(t, Select(provider, "eks"))
+	assert.Equal(t, "screwdrivercd/launcher:v6.0.147", provider["launcherImage"])
+
+	t.Setenv("SD_LAUNCHER_IMAGES", `{"arm64": "screwdrivercd/launcher:{version}-arm64", "sls/arm64": "111111111111.dkr.ecr.us-west-2.amazonaws.com/sdinit:{version}-arm64"}`)
+	assert.Nil(t, Select(provider, "eks"))
+	assert.Equal(t, "screwdrivercd/launcher:v6.0.147-arm64", provider["launcherImage"])
+
+	assert.Nil(t, Select(provider, "sls"))
+	assert.Equal(t, "111111111111.dkr.ecr.us-west-2.amazonaws.com/sdinit:v6.0.147-arm64", provider["launcherImage"])
+
+	provider = map[string]interface{}{"launcherVersion": "v6.0.147", "launcherImage": "screwdrivercd/launcher:v6.0.147"}
+	assert.Nil(t, Select(provider, "sls"))
+	assert.Equal(t, "screwdrivercd/launcher:v6.0.147", provider["launcherImage"])
+
+	t.Setenv("SD_LAUNCHER_IMAGES", "{")
+	assert.EqualError(t, Select(provider, "sls"), "Got error parsing SD_LAUNCHER_IMAGES: unexpected end of JSON input")
+	assert.EqualError(t, Select(map[string]interface{}{"architecture": "s390x"}, "sls"), `unsupported architecture "s390x"`)
+}
+
+func TestSelectInvalidFlags(t *testing.T) {
+	t.Setenv("SD_LAUNCHER_IMAGES", "")
+	provider := map[string]interface{}{"launcherVersion": "v6.0.147", "sourceDir": "/etc"}
+	assert.EqualError(t, Select(provider, "sls"), `sourceDir "/etc" must be relative to the checkout`)
+}
@@ -0,0 +1,172 @@
+// Package matrix fans a build whose provider lists several architectures out into one build per architecture, each
+// with a codebuild project or pods of its own, and reports the stats and meta of each under its architecture
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+)
+
+const (
+	// ArchitecturesField lists the architectures of a multi-architecture build, e.g. ["amd64", "arm64"]
+	ArchitecturesField = "architectures"
+	// ArchitectureKey is the build config key of the architecture of a build fanned out of a multi-architecture build
+	ArchitectureKey = "matrixArchitecture"
+)
+
+// codebuild environment types of the architectures, the ones of other architectures are swapped for them
+var environmentTypes = map[string]string{
+	launcher.AMD64: "LINUX_CONTAINER",
+	launcher.ARM64: "ARM_CONTAINER",
+}
+
+// Architectures gets the distinct architectures of the provider, nil when the build is not fanned out
+func Architectures(provider map[string]interface{}) ([]string, error) {
+	value, ok := provider[ArchitecturesField]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("provider.%s must be a list of architectures", ArchitecturesField)
+	}
+	var architectures []string
+	seen := map[string]bool{}
+	for _, item := range list {
+		name, _ := item.(string)
+		arch, err := launcher.Architecture(map[string]interface{}{"architecture": name})
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("provider.%s: unsupported architecture %q", ArchitecturesField, fmt.Sprint(item))
+		}
+		if !seen[arch] {
+			seen[arch] = true
+			architectures = append(architectures, arch)
+		}
+	}
+	if len(architectures) < 2 {
+		return nil, fmt.Errorf("provider.%s must list at least two architectures, use provider.architecture for a single one", ArchitecturesField)
+	}
+	return architectures, nil
+}
+
+// copies a decoded json value
+func deepCopy(buildConfig map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(buildConfig)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var copied map[string]interface{}
+	if err := decoder.Decode(&copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// Expand gets the build configs of the architectures, copies of the build config running on each of them
+func Expand(buildConfig map[string]interface{}, architectures []string) ([]map[string]interface{}, error) {
+	configs := make([]map[string]interface{}, 0, len(architectures))
+	for _, arch := range architectures {
+		config, err := deepCopy(buildConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Got error copying build config for %s: %v", arch, err)
+		}
+		provider := config["provider"].(map[string]interface{})
+		delete(provider, ArchitecturesField)
+		provider["architecture"] = arch
+		// codebuild runs arm builds in arm environments
+		for _, field := range []string{"environmentType", "launcherEnvironmentType"} {
+			environmentType, _ := provider[field].(string)
+			for other, otherType := range environmentTypes {
+				if other != arch && environmentType == otherType {
+					provider[field] = environmentTypes[arch]
+				}
+			}
+		}
+		config[ArchitectureKey] = arch
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// Architecture gets the architecture of a build fanned out of a multi-architecture build, empty for other builds
+func Architecture(buildConfig map[string]interface{}) string {
+	arch, _ := buildConfig[ArchitectureKey].(string)
+	return arch
+}
+
+// Name gets the name of the resource of the build for its architecture
+func Name(buildConfig map[string]interface{}, name string) string {
+	if arch := Architecture(buildConfig); arch != "" && name != "" {
+		return name + "-" + arch
+	}
+	return name
+}
+
+// Key gets the key of the state of the build kept per architecture, the build id suffixed with the architecture like Name
+func Key(buildID int, arch string) string {
+	if arch != "" {
+		return strconv.Itoa(buildID) + "-" + arch
+	}
+	return strconv.Itoa(buildID)
+}
+
+// API reports the stats and meta of a build fanned out of a multi-architecture build under its architecture,
+// so the builds of the architectures do not overwrite each other
+type API struct {
+	sd.API
+	Arch string
+}
+
+// WrapAPI returns the api reporting under the architecture of the build, the api itself for other builds
+func WrapAPI(api sd.API, buildConfig map[string]interface{}) sd.API {
+	if arch := Architecture(buildConfig); arch != "" && api != nil {
+		return &API{API: api, Arch: arch}
+	}
+	return api
+}
+
+// Stats gets the stats prefixed with the architecture, like arm64.hostname
+func (a *API) Stats(stats map[string]interface{}) map[string]interface{} {
+	if stats == nil {
+		return nil
+	}
+	prefixed := make(map[string]interface{}, len(stats))
+	for k, v := range stats {
+		prefixed[a.Arch+"."+k] = v
+	}
+	return prefixed
+}
+
+// gets the status message prefixed with the architecture
+func (a *API) statusMessage(statusMessage string) string {
+	if statusMessage == "" {
+		return ""
+	}
+	return a.Arch + ": " + statusMessage
+}
+
+// UpdateBuild updates the stats of the architecture and status message of the build
+func (a *API) UpdateBuild(stats map[string]interface{}, buildID int, statusMessage string) error {
+	return a.API.UpdateBuild(a.Stats(stats), buildID, a.statusMessage(statusMessage))
+}
+
+// UpdateBuildStatus updates the status of the build with the status message of the architecture
+func (a *API) UpdateBuildStatus(status sd.BuildStatus, buildID int, statusMessage string) error {
+	return a.API.UpdateBuildStatus(status, buildID, a.statusMessage(statusMessage))
+}
+
+// UpdateBuildMeta updates the meta of the architecture, {"aws": {...}} is nested as {"aws": {"<arch>": {...}}}
+func (a *API) UpdateBuildMeta(meta map[string]interface{}, buildID int) error {
+	nested := make(map[string]interface{}, len(meta))
+	for k, v := range meta {
+		nested[k] = map[string]interface{}{a.Arch: v}
+	}
+	return a.API.UpdateBuildMeta(nested, buildID)
+}
@@ -0,0 +1,106 @@
+package matrix
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+	"github.com/screwdriver-cd/aws-consumer-service/screwdriver/sdtest"
+)
+
+func TestArchitectures(t *testing.T) {
+	architectures, err := Architectures(map[string]interface{}{})
+	assert.Nil(t, architectures)
+	assert.Nil(t, err)
+
+	architectures, err = Architectures(map[string]interface{}{"architectures": []interface{}{"x86_64", "arm64", "aarch64"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"amd64", "arm64"}, architectures)
+
+	for value, expected := range map[string]interface{}{
+		"provider.architectures must be a list of architectures":                                                  "arm64",
+		`provider.architectures: unsupported architecture "s390x"`:                                                []interface{}{"amd64", "s390x"},
+		`provider.architectures: unsupported architecture "1"`:                                                    []interface{}{"amd64", json.Number("1")},
+		"provider.architectures must list at least two architectures, use provider.architecture for a single one": []interface{}{"arm64", "aarch64"},
+	} {
+		_, err := Architectures(map[string]interface{}{"architectures": expected})
+		assert.EqualError(t, err, value)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	buildConfig := map[string]interface{}{
+		"buildId": json.Number("1234"),
+		"provider": map[string]interface{}{
+			"architectures":           []interface{}{"amd64", "arm64"},
+			"environmentType":         "ARM_CONTAINER",
+			"launcherEnvironmentType": "LINUX_CONTAINER",
+			"vpc":                     map[string]interface{}{"subnetIds": []interface{}{"subnet-1"}},
+		},
+	}
+	configs, err := Expand(buildConfig, []string{"amd64", "arm64"})
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{
+			"buildId":            json.Number("1234"),
+			"matrixArchitecture": "amd64",
+			"provider": map[string]interface{}{
+				"architecture":            "amd64",
+				"environmentType":         "LINUX_CONTAINER",
+				"launcherEnvironmentType": "LINUX_CONTAINER",
+				"vpc":                     map[string]interface{}{"subnetIds": []interface{}{"subnet-1"}},
+			},
+		},
+		{
+			"buildId":            json.Number("1234"),
+			"matrixArchitecture": "arm64",
+			"provider": map[string]interface{}{
+				"architecture":            "arm64",
+				"environmentType":         "ARM_CONTAINER",
+				"launcherEnvironmentType": "ARM_CONTAINER",
+				"vpc":                     map[string]interface{}{"subnetIds": []interface{}{"subnet-1"}},
+			},
+		},
+	}, configs)
+
+	// the copies do not share values with the build config
+	configs[0]["provider"].(map[string]interface{})["vpc"].(map[string]interface{})["subnetIds"] = nil
+	assert.Equal(t, []interface{}{"subnet-1"}, buildConfig["provider"].(map[string]interface{})["vpc"].(map[string]interface{})["subnetIds"])
+	assert.Contains(t, buildConfig["provider"], "architectures")
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "main-123", Name(map[string]interface{}{}, "main-123"))
+	assert.Equal(t, "main-123-arm64", Name(map[string]interface{}{ArchitectureKey: "arm64"}, "main-123"))
+	assert.Equal(t, "", Name(map[string]interface{}{ArchitectureKey: "arm64"}, ""))
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "1234", Key(1234, ""))
+	assert.Equal(t, "1234-arm64", Key(1234, "arm64"))
+}
+
+func TestWrapAPI(t *testing.T) {
+	fakeAPI := sdtest.New()
+	api, _ := fakeAPI.Factory()("https://api.screwdriver.cd", "token")
+	assert.Equal(t, api, WrapAPI(api, map[string]interface{}{}))
+
+	wrapped := WrapAPI(api, map[string]interface{}{ArchitectureKey: "arm64"})
+	assert.Nil(t, wrapped.UpdateBuild(map[string]interface{}{"hostname": "proj123"}, 1234, "Waiting for capacity"))
+	assert.Nil(t, wrapped.UpdateBuild(nil, 1234, ""))
+	assert.Nil(t, wrapped.UpdateBuildStatus(sd.Failure, 1234, "Pre-flight check failed"))
+	assert.Nil(t, wrapped.UpdateBuildMeta(map[string]interface{}{"aws": map[string]interface{}{"links": map[string]string{"pod": "1234-abcde"}}}, 1234))
+
+	assert.Equal(t, []sdtest.UpdateBuildCall{
+		{Stats: map[string]interface{}{"arm64.hostname": "proj123"}, BuildID: 1234, StatusMessage: "arm64: Waiting for capacity"},
+		{BuildID: 1234},
+	}, fakeAPI.UpdateBuildCalls())
+	assert.Equal(t, []sdtest.UpdateBuildStatusCall{
+		{Status: sd.Failure, BuildID: 1234, StatusMessage: "arm64: Pre-flight check failed"},
+	}, fakeAPI.UpdateBuildStatusCalls())
+	assert.Equal(t, []sdtest.UpdateBuildMetaCall{
+		{Meta: map[string]interface{}{"aws": map[string]interface{}{"arm64": map[string]interface{}{"links": map[string]string{"pod": "1234-abcde"}}}}, BuildID: 1234},
+	}, fakeAPI.UpdateBuildMetaCalls())
+}
@@ -0,0 +1,109 @@
+package message
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// size limits of build messages, larger values are rejected before they reach AWS APIs failing with vague validation errors
+const (
+	maxEnvironment    = 100
+	maxEnvNameLength  = 255
+	maxEnvValueLength = 16 * 1024
+	maxAnnotations    = 64
+	// codebuild vpc configs take up to 16 subnets and 5 security groups
+	maxSubnets        = 16
+	maxSecurityGroups = 5
+	maxServices       = 10
+	maxStringLength   = 8 * 1024
+	maxDepth          = 16
+)
+
+// maximum number of problems listed by LimitsError
+const maxListedProblems = 3
+
+// LimitsError gets the error of a message exceeding the limits, listing the first problems
+func LimitsError(problems []string) error {
+	listed := problems
+	if len(listed) > maxListedProblems {
+		listed = listed[:maxListedProblems]
+	}
+	message := strings.Join(listed, ", ")
+	if len(problems) > len(listed) {
+		message += fmt.Sprintf(" and %d more", len(problems)-len(listed))
+	}
+	return fmt.Errorf("Build message exceeds limits: %s", message)
+}
+
+// CheckLimits returns the fields of the message exceeding the size limits, nil when the message is within them
+func (m *BuildMessage) CheckLimits() []string {
+	var problems []string
+	if environment, ok := m.BuildConfig["environment"].(map[string]interface{}); ok {
+		if len(environment) > maxEnvironment {
+			problems = append(problems, fmt.Sprintf("buildConfig.environment has %d variables, at most %d are allowed", len(environment), maxEnvironment))
+		}
+		names := make([]string, 0, len(environment))
+		for name := range environment {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(name) > maxEnvNameLength {
+				problems = append(problems, fmt.Sprintf("buildConfig.environment has a variable name longer than %d characters", maxEnvNameLength))
+				continue
+			}
+			if value, ok := environment[name].(string); ok && len(value) > maxEnvValueLength {
+				problems = append(problems, fmt.Sprintf("buildConfig.environment.%s is longer than %d characters", name, maxEnvValueLength))
+			}
+		}
+	}
+	if annotations, ok := m.BuildConfig["annotations"].(map[string]interface{}); ok && len(annotations) > maxAnnotations {
+		problems = append(problems, fmt.Sprintf("buildConfig.annotations has %d annotations, at most %d are allowed", len(annotations), maxAnnotations))
+	}
+	provider, _ := m.BuildConfig["provider"].(map[string]interface{})
+	if vpc, ok := provider["vpc"].(map[string]interface{}); ok {
+		problems = append(problems, checkLength(vpc, "buildConfig.provider.vpc.", "subnetIds", maxSubnets)...)
+		problems = append(problems, checkLength(vpc, "buildConfig.provider.vpc.", "securityGroupIds", maxSecurityGroups)...)
+	}
+	problems = append(problems, checkLength(provider, "buildConfig.provider.", "services", maxServices)...)
+	problems = append(problems, checkStrings(m.BuildConfig, "buildConfig", 0)...)
+	return problems
+}
+
+// checks a list field has at most max items
+func checkLength(m map[string]interface{}, prefix string, name string, max int) []string {
+	if list, ok := m[name].([]interface{}); ok && len(list) > max {
+		return []string{fmt.Sprintf("%s%s has %d items, at most %d are allowed", prefix, name, len(list), max)}
+	}
+	return nil
+}
+
+// checks the strings of a decoded json value are not too long and its objects not nested too deep,
+// environment values have a limit of their own
+func checkStrings(value interface{}, path string, depth int) []string {
+	if depth > maxDepth {
+		return []string{fmt.Sprintf("%s is nested deeper than %d levels", path, maxDepth)}
+	}
+	var problems []string
+	switch v := value.(type) {
+	case string:
+		if len(v) > maxStringLength && !strings.HasPrefix(path, "buildConfig.environment.") {
+			problems = append(problems, fmt.Sprintf("%s is longer than %d characters", path, maxStringLength))
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			problems = append(problems, checkStrings(v[k], path+"."+k, depth+1)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			problems = append(problems, checkStrings(item, fmt.Sprintf("%s[%d]", path, i), depth+1)...)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,57 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLimits(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	assert.Nil(t, m.CheckLimits())
+
+	environment := map[string]interface{}{"LARGE": strings.Repeat("x", maxEnvValueLength+1), strings.Repeat("N", 300): "1"}
+	for i := 0; i < maxEnvironment; i++ {
+		environment[fmt.Sprintf("VAR_%d", i)] = "value"
+	}
+	m.BuildConfig["environment"] = environment
+	annotations := map[string]interface{}{}
+	for i := 0; i <= maxAnnotations; i++ {
+		annotations[fmt.Sprintf("screwdriver.cd/custom%d", i)] = "value"
+	}
+	m.BuildConfig["annotations"] = annotations
+	m.BuildConfig["jobName"] = strings.Repeat("j", maxStringLength+1)
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	vpc := provider["vpc"].(map[string]interface{})
+	vpc["subnetIds"] = make([]interface{}, maxSubnets+1)
+	vpc["securityGroupIds"] = []interface{}{"sg-1", "sg-2", "sg-3", "sg-4", "sg-5", "sg-6"}
+	provider["services"] = make([]interface{}, maxServices+1)
+
+	assert.Equal(t, []string{
+		"buildConfig.environment has 102 variables, at most 100 are allowed",
+		"buildConfig.environment.LARGE is longer than 16384 characters",
+		"buildConfig.environment has a variable name longer than 255 characters",
+		"buildConfig.annotations has 65 annotations, at most 64 are allowed",
+		"buildConfig.provider.vpc.subnetIds has 17 items, at most 16 are allowed",
+		"buildConfig.provider.vpc.securityGroupIds has 6 items, at most 5 are allowed",
+		"buildConfig.provider.services has 11 items, at most 10 are allowed",
+		"buildConfig.jobName is longer than 8192 characters",
+	}, m.CheckLimits())
+}
+
+func TestCheckLimitsDepth(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	var nested interface{} = "deep"
+	for i := 0; i < maxDepth; i++ {
+		nested = []interface{}{nested}
+	}
+	m.BuildConfig["pipeline"] = nested
+	assert.Equal(t, []string{"buildConfig.pipeline[0][0][0][0][0][0][0][0][0][0][0][0][0][0][0][0] is nested deeper than 16 levels"}, m.CheckLimits())
+}
+
+func TestLimitsError(t *testing.T) {
+	assert.EqualError(t, LimitsError([]string{"a is too long"}), "Build message exceeds limits: a is too long")
+	assert.EqualError(t, LimitsError([]string{"a", "b", "c", "d", "e"}), "Build message exceeds limits: a, b, c and 2 more")
+}
@@ -0,0 +1,405 @@
+// Package message decodes and validates the build messages produced by the Screwdriver queue service
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/codebuild"
+
+	"github.com/screwdriver-cd/aws-consumer-service/failover"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
+	"github.com/screwdriver-cd/aws-consumer-service/sizing"
+)
+
+// BuildMessage structure definition
+type BuildMessage struct {
+	Job          string                 `json:"job"`
+	BuildConfig  map[string]interface{} `json:"buildConfig"`
+	ExecutorType string                 `json:"executorType"`
+}
+
+// defaults of the provider config, set for keys missing in the message
+var providerDefaults = map[string]interface{}{
+	"executorLogs":             false,
+	"dlc":                      false,
+	"privilegedMode":           false,
+	"prune":                    true,
+	"imagePullCredentialsType": "SERVICE_ROLE",
+	"launcherEnvironmentType":  "LINUX_CONTAINER",
+	"environmentType":          "LINUX_CONTAINER",
+	"computeType":              "BUILD_GENERAL1_SMALL",
+	"queuedTimeout":            json.Number("5"),
+	"launcherComputeType":      "BUILD_GENERAL1_SMALL",
+	"buildRegion":              "",
+	"debugSession":             false,
+}
+
+// codebuild environment types the launcher phase of a build graph can run on
+var launcherEnvironmentTypes = []string{
+	codebuild.EnvironmentTypeLinuxContainer,
+	codebuild.EnvironmentTypeArmContainer,
+}
+
+// codebuild compute types running builds on lambda and their environment types, not in the enums of the sdk yet
+var lambdaComputeTypes = []string{"BUILD_LAMBDA_1GB", "BUILD_LAMBDA_2GB", "BUILD_LAMBDA_4GB", "BUILD_LAMBDA_8GB", "BUILD_LAMBDA_10GB"}
+var lambdaEnvironmentTypes = []string{"LINUX_LAMBDA_CONTAINER", "ARM_LAMBDA_CONTAINER"}
+
+// codebuild container compute types, BUILD_GENERAL1_XLARGE is not an enum value of the sdk yet
+var computeTypes = append(codebuild.ComputeType_Values(), "BUILD_GENERAL1_XLARGE")
+
+// volume modes of the launcher and tmp dirs of eks builds
+var volumeModes = []string{"hostPath", "ephemeral", "csi"}
+
+// workloads eks builds are started as
+var workloads = []string{"pod", "job"}
+
+// checks the provider of sls builds on lambda compute, which runs linux and arm containers only
+// and has no privileged mode, local caches or debug sessions
+func checkLambda(provider map[string]interface{}) []string {
+	var problems []string
+	problems = append(problems, checkEnum("buildConfig.provider.environmentType", provider["environmentType"],
+		append([]string{codebuild.EnvironmentTypeLinuxContainer, codebuild.EnvironmentTypeArmContainer}, lambdaEnvironmentTypes...))...)
+	for _, key := range []string{"privilegedMode", "dlc", "debugSession"} {
+		if enabled, _ := provider[key].(bool); enabled {
+			problems = append(problems, fmt.Sprintf("buildConfig.provider.%s is not supported by lambda compute type %v", key, provider["computeType"]))
+		}
+	}
+	return problems
+}
+
+// checks the local cache modes and custom cache paths of sls builds, which only apply with dlc
+func checkCache(provider map[string]interface{}) []string {
+	modes, hasModes := provider["cacheModes"]
+	paths, hasPaths := provider["cachePaths"]
+	if !hasModes && !hasPaths {
+		return nil
+	}
+	if dlc, _ := provider["dlc"].(bool); !dlc {
+		return []string{"buildConfig.provider.cacheModes and cachePaths require dlc"}
+	}
+	var problems []string
+	custom := false
+	if hasModes {
+		list, _ := modes.([]interface{})
+		if len(list) == 0 {
+			problems = append(problems, "buildConfig.provider.cacheModes must be a non empty array")
+		}
+		for _, mode := range list {
+			problems = append(problems, checkEnum("buildConfig.provider.cacheModes", mode, codebuild.CacheMode_Values())...)
+			custom = custom || mode == codebuild.CacheModeLocalCustomCache
+		}
+	}
+	if hasPaths {
+		list, _ := paths.([]interface{})
+		if len(list) == 0 {
+			problems = append(problems, "buildConfig.provider.cachePaths must be a non empty array")
+		}
+		for _, path := range list {
+			// paths are quoted into the buildspec
+			if s, _ := path.(string); s == "" || strings.ContainsAny(s, "'\"\\\n") {
+				problems = append(problems, fmt.Sprintf("buildConfig.provider.cachePaths has an invalid path %q", fmt.Sprint(path)))
+			}
+		}
+		if !custom {
+			problems = append(problems, "buildConfig.provider.cachePaths require the LOCAL_CUSTOM_CACHE cache mode")
+		}
+	}
+	return problems
+}
+
+// DecodeValue gets the json of a base64 encoded or plain json record value
+func DecodeValue(value string) ([]byte, error) {
+	data := []byte(strings.TrimSpace(value))
+	// self-hosted producers and test tooling send plain json, the opening brace is not part of the base64 alphabet
+	if len(data) > 0 && data[0] == '{' {
+		return data, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding base64 message: %v", err)
+	}
+	return decoded, nil
+}
+
+// WithoutToken gets the json of a record value without the token of its build config, and the token
+func WithoutToken(value string) (string, string, error) {
+	data, err := DecodeValue(value)
+	if err != nil {
+		return "", "", err
+	}
+	var m map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil {
+		return "", "", fmt.Errorf("Error decoding message %v: %v", redact.String(string(data)), err)
+	}
+	buildConfig, _ := m["buildConfig"].(map[string]interface{})
+	token, _ := buildConfig["token"].(string)
+	delete(buildConfig, "token")
+	stripped, err := json.Marshal(m)
+	if err != nil {
+		return "", "", fmt.Errorf("Error encoding message: %v", err)
+	}
+	return string(stripped), token, nil
+}
+
+// Decode decodes a json build message, setting the build config and provider defaults
+func Decode(data []byte) (*BuildMessage, error) {
+	m := &BuildMessage{
+		BuildConfig: map[string]interface{}{
+			//default values
+			"container":          "aws/codebuild/standard:5.0",
+			"serviceAccountName": "default",
+		},
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(m); err != nil {
+		return nil, fmt.Errorf("Error decoding message %v: %v", redact.String(string(data)), err)
+	}
+
+	if provider, ok := m.BuildConfig["provider"].(map[string]interface{}); ok {
+		for k, v := range providerDefaults {
+			if provider[k] == nil {
+				provider[k] = v
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// BuildRegion gets the region builds run in, provider.buildRegion falling back to provider.region
+func (m *BuildMessage) BuildRegion() string {
+	provider, _ := m.BuildConfig["provider"].(map[string]interface{})
+	if buildRegion, _ := provider["buildRegion"].(string); buildRegion != "" {
+		return buildRegion
+	}
+	region, _ := provider["region"].(string)
+	return region
+}
+
+// checks that fields of m are set and of the go type of their decoded json value
+func checkFields(m map[string]interface{}, prefix string, fields map[string]string) []string {
+	var problems []string
+	for _, name := range sortedKeys(fields) {
+		value, ok := m[name]
+		if !ok || value == nil {
+			problems = append(problems, fmt.Sprintf("%s%s is required", prefix, name))
+			continue
+		}
+		var valid bool
+		switch fields[name] {
+		case "string":
+			s, isString := value.(string)
+			valid = isString && s != ""
+		case "number":
+			_, valid = value.(json.Number)
+		case "bool":
+			_, valid = value.(bool)
+		case "object":
+			_, valid = value.(map[string]interface{})
+		case "array":
+			_, valid = value.([]interface{})
+		}
+		if !valid {
+			problems = append(problems, fmt.Sprintf("%s%s must be a non empty %s", prefix, name, fields[name]))
+		}
+	}
+	return problems
+}
+
+// checks that value is one of the allowed values
+func checkEnum(name string, value interface{}, allowed []string) []string {
+	s, _ := value.(string)
+	for _, a := range allowed {
+		if s == a {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("%s %q is not one of %v", name, s, allowed)}
+}
+
+// Validate returns the schema problems of the message, nil when the message is valid
+func (m *BuildMessage) Validate() []string {
+	var problems []string
+	problems = append(problems, checkEnum("job", m.Job, []string{"start", "stop", "describe", "status", "logs", "cleanup", "list"})...)
+	problems = append(problems, checkEnum("executorType", m.ExecutorType, []string{"sls", "eks", "ecs", "ec2"})...)
+
+	problems = append(problems, checkFields(m.BuildConfig, "buildConfig.", map[string]string{
+		"buildId":      "number",
+		"jobId":        "number",
+		"jobName":      "string",
+		"pipelineId":   "number",
+		"apiUri":       "string",
+		"storeUri":     "string",
+		"uiUri":        "string",
+		"token":        "string",
+		"container":    "string",
+		"buildTimeout": "number",
+		"provider":     "object",
+	})...)
+	if since, ok := m.BuildConfig["logsSince"]; ok {
+		if s, _ := since.(string); s == "" || !isRFC3339(s) {
+			problems = append(problems, "buildConfig.logsSince must be an RFC3339 time")
+		}
+	}
+	provider, ok := m.BuildConfig["provider"].(map[string]interface{})
+	if !ok {
+		return problems
+	}
+
+	providerFields := map[string]string{
+		"region":          "string",
+		"role":            "string",
+		"launcherImage":   "string",
+		"launcherVersion": "string",
+	}
+	switch m.ExecutorType {
+	case "sls":
+		providerFields["vpc"] = "object"
+		providerFields["computeType"] = "string"
+		providerFields["environmentType"] = "string"
+		providerFields["launcherComputeType"] = "string"
+		providerFields["launcherEnvironmentType"] = "string"
+	case "eks":
+		providerFields["namespace"] = "string"
+	case "ecs":
+		providerFields["vpc"] = "object"
+	case "ec2":
+		providerFields["vpc"] = "object"
+		providerFields["imageId"] = "string"
+	}
+	// the account registry fills in the infrastructure of an aliased account
+	aliased := provider["accountAlias"] != nil
+	if aliased {
+		providerFields["accountAlias"] = "string"
+		for _, k := range []string{"region", "role", "vpc"} {
+			if provider[k] == nil {
+				delete(providerFields, k)
+			}
+		}
+	}
+	problems = append(problems, checkFields(provider, "buildConfig.provider.", providerFields)...)
+	if m.ExecutorType == "eks" && !aliased {
+		// the cluster may be set on the build config or the provider
+		clusterName, _ := provider["clusterName"].(string)
+		if buildClusterName, _ := m.BuildConfig["clusterName"].(string); clusterName == "" && buildClusterName == "" {
+			problems = append(problems, "buildConfig.provider.clusterName is required")
+		}
+	}
+
+	if mode, ok := provider["volumeMode"]; ok && m.ExecutorType == "eks" {
+		problems = append(problems, checkEnum("buildConfig.provider.volumeMode", mode, volumeModes)...)
+	}
+	if schedulerName, ok := provider["schedulerName"]; ok && m.ExecutorType == "eks" {
+		if s, _ := schedulerName.(string); s == "" {
+			problems = append(problems, "buildConfig.provider.schedulerName must be a non empty string")
+		}
+	}
+	if workload, ok := provider["workload"]; ok && m.ExecutorType == "eks" {
+		problems = append(problems, checkEnum("buildConfig.provider.workload", workload, workloads)...)
+	}
+	if skew, ok := provider["zoneSpreadMaxSkew"]; ok && m.ExecutorType == "eks" {
+		if n, err := strconv.Atoi(fmt.Sprint(skew)); err != nil || n < 0 {
+			problems = append(problems, "buildConfig.provider.zoneSpreadMaxSkew must be a positive number")
+		}
+	}
+	if _, ok := provider[matrix.ArchitecturesField]; ok {
+		if _, err := matrix.Architectures(provider); err != nil {
+			problems = append(problems, "buildConfig."+err.Error())
+		}
+	}
+	if size, ok := provider[sizing.SizeField]; ok {
+		problems = append(problems, checkEnum("buildConfig.provider.size", size, sizing.Names())...)
+	}
+	if region, _ := provider["region"].(string); region != "" {
+		if buildRegion, _ := provider["buildRegion"].(string); buildRegion != "" {
+			region = buildRegion
+		}
+		if _, err := failover.Regions(provider, region); err != nil {
+			problems = append(problems, "buildConfig.provider."+err.Error())
+		}
+	}
+	if m.ExecutorType == "sls" {
+		if computeType, _ := provider["computeType"].(string); strings.HasPrefix(computeType, "BUILD_LAMBDA_") {
+			problems = append(problems, checkEnum("buildConfig.provider.computeType", computeType, lambdaComputeTypes)...)
+			problems = append(problems, checkLambda(provider)...)
+		} else {
+			problems = append(problems, checkEnum("buildConfig.provider.computeType", computeType, computeTypes)...)
+			problems = append(problems, checkEnum("buildConfig.provider.environmentType", provider["environmentType"], codebuild.EnvironmentType_Values())...)
+		}
+		problems = append(problems, checkEnum("buildConfig.provider.launcherComputeType", provider["launcherComputeType"], computeTypes)...)
+		// the launcher phase exports the sdinit bundle from /opt, the build phase may run any environment type
+		problems = append(problems, checkEnum("buildConfig.provider.launcherEnvironmentType", provider["launcherEnvironmentType"], launcherEnvironmentTypes)...)
+		if vpc, ok := provider["vpc"].(map[string]interface{}); ok {
+			problems = append(problems, checkFields(vpc, "buildConfig.provider.vpc.", map[string]string{
+				"vpcId":            "string",
+				"securityGroupIds": "array",
+				"subnetIds":        "array",
+			})...)
+		}
+		problems = append(problems, checkCache(provider)...)
+		if keepAlive, ok := provider["keepAliveMinutes"]; ok {
+			if n, err := strconv.Atoi(fmt.Sprint(keepAlive)); err != nil || n <= 0 {
+				problems = append(problems, "buildConfig.provider.keepAliveMinutes must be a positive number")
+			} else if debugSession, _ := provider["debugSession"].(bool); !debugSession {
+				problems = append(problems, "buildConfig.provider.keepAliveMinutes requires debugSession")
+			}
+		}
+	}
+
+	if m.ExecutorType == "ecs" {
+		// fargate tasks run in awsvpc mode, in the subnets and security groups of the vpc
+		if vpc, ok := provider["vpc"].(map[string]interface{}); ok {
+			problems = append(problems, checkFields(vpc, "buildConfig.provider.vpc.", map[string]string{
+				"securityGroupIds": "array",
+				"subnetIds":        "array",
+			})...)
+		}
+		if privileged, _ := provider["privilegedMode"].(bool); privileged {
+			problems = append(problems, "buildConfig.provider.privilegedMode is not supported by the ecs executor")
+		}
+	}
+
+	if m.ExecutorType == "ec2" {
+		// the instance gets a network interface in one of the subnets with the security groups
+		if vpc, ok := provider["vpc"].(map[string]interface{}); ok {
+			problems = append(problems, checkFields(vpc, "buildConfig.provider.vpc.", map[string]string{
+				"securityGroupIds": "array",
+				"subnetIds":        "array",
+			})...)
+		}
+		if instanceType, ok := provider["instanceType"]; ok {
+			if s, _ := instanceType.(string); s == "" {
+				problems = append(problems, "buildConfig.provider.instanceType must be a non empty string")
+			}
+		}
+	}
+
+	return append(problems, m.CheckLimits()...)
+}
+
+// checks that s is a time in RFC3339 format
+func isRFC3339(s string) bool {
+	_, err := time.Parse(time.RFC3339, s)
+	return err == nil
+}
+
+// gets the sorted keys of the fields
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
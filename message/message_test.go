@@ -0,0 +1,280 @@
+package message
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSlsMessage = `{
+	"job": "start",
+	"executorType": "sls",
+	"buildConfig": {
+		"buildId": 1234,
+		"jobId": 6822,
+		"jobName": "main",
+		"pipelineId": 1898,
+		"apiUri": "https://api.screwdriver.cd",
+		"storeUri": "https://store.screwdriver.cd",
+		"uiUri": "https://screwdriver.cd",
+		"token": "testtoken",
+		"buildTimeout": 90,
+		"provider": {
+			"region": "us-west-2",
+			"role": "arn:aws:iam::111111111:role/sd-build",
+			"launcherImage": "screwdrivercd/launcher:v6.0.147",
+			"launcherVersion": "v6.0.147",
+			"vpc": {"vpcId": "vpc-1", "securityGroupIds": ["sg-1"], "subnetIds": ["subnet-1"]}
+		}
+	}
+}`
+
+func TestDecode(t *testing.T) {
+	m, err := Decode([]byte(testSlsMessage))
+	assert.Nil(t, err)
+	assert.Equal(t, "start", m.Job)
+	assert.Equal(t, "sls", m.ExecutorType)
+	assert.Equal(t, "aws/codebuild/standard:5.0", m.BuildConfig["container"])
+	assert.Equal(t, json.Number("1234"), m.BuildConfig["buildId"])
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	assert.Equal(t, true, provider["prune"])
+	assert.Equal(t, json.Number("5"), provider["queuedTimeout"])
+	assert.Equal(t, "us-west-2", m.BuildRegion())
+
+	provider["buildRegion"] = "us-east-1"
+	assert.Equal(t, "us-east-1", m.BuildRegion())
+
+	_, err = Decode([]byte(`{"job": `))
+	assert.EqualError(t, err, `Error decoding message {"job": : unexpected EOF`)
+}
+
+func TestWithoutToken(t *testing.T) {
+	stripped, token, err := WithoutToken(base64.StdEncoding.EncodeToString([]byte(testSlsMessage)))
+	assert.Nil(t, err)
+	assert.Equal(t, "testtoken", token)
+	assert.NotContains(t, stripped, "testtoken")
+	m, err := Decode([]byte(stripped))
+	assert.Nil(t, err)
+	assert.Nil(t, m.BuildConfig["token"])
+	assert.Equal(t, json.Number("1234"), m.BuildConfig["buildId"])
+
+	_, _, err = WithoutToken("not base64")
+	assert.EqualError(t, err, "Error decoding base64 message: illegal base64 data at input byte 3")
+}
+
+func TestValidate(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	assert.Nil(t, m.Validate())
+
+	m, _ = Decode([]byte(testSlsMessage))
+	m.Job = "restart"
+	delete(m.BuildConfig, "token")
+	m.BuildConfig["buildTimeout"] = "90"
+	m.BuildConfig["logsSince"] = "yesterday"
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["computeType"] = "BUILD_GENERAL1_HUGE"
+	provider["size"] = "huge"
+	provider["launcherEnvironmentType"] = "WINDOWS_CONTAINER"
+	provider["fallbackRegions"] = []interface{}{"us-gov-west-1"}
+	delete(provider["vpc"].(map[string]interface{}), "subnetIds")
+	assert.Equal(t, []string{
+		`job "restart" is not one of [start stop describe status logs cleanup list]`,
+		"buildConfig.buildTimeout must be a non empty number",
+		"buildConfig.token is required",
+		"buildConfig.logsSince must be an RFC3339 time",
+		`buildConfig.provider.size "huge" is not one of [micro small medium large xlarge]`,
+		"buildConfig.provider.fallbackRegions[0] us-gov-west-1 is in partition aws-us-gov, not aws of region us-west-2",
+		`buildConfig.provider.computeType "BUILD_GENERAL1_HUGE" is not one of ` + "[BUILD_GENERAL1_SMALL BUILD_GENERAL1_MEDIUM BUILD_GENERAL1_LARGE BUILD_GENERAL1_2XLARGE BUILD_GENERAL1_XLARGE]",
+		`buildConfig.provider.launcherEnvironmentType "WINDOWS_CONTAINER" is not one of [LINUX_CONTAINER ARM_CONTAINER]`,
+		"buildConfig.provider.vpc.subnetIds is required",
+	}, m.Validate())
+
+	m, _ = Decode([]byte(testSlsMessage))
+	provider = m.BuildConfig["provider"].(map[string]interface{})
+	provider["environmentType"] = "WINDOWS_SERVER_2019_CONTAINER"
+	provider["launcherEnvironmentType"] = "ARM_CONTAINER"
+	assert.Nil(t, m.Validate())
+}
+
+func TestValidateCache(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["cacheModes"] = []interface{}{"LOCAL_SOURCE_CACHE"}
+	assert.Equal(t, []string{"buildConfig.provider.cacheModes and cachePaths require dlc"}, m.Validate())
+
+	provider["dlc"] = true
+	assert.Nil(t, m.Validate())
+	provider["cacheModes"] = []interface{}{"LOCAL_SOURCE_CACHE", "LOCAL_CUSTOM_CACHE"}
+	provider["cachePaths"] = []interface{}{"/root/.npm/**/*", "node_modules"}
+	assert.Nil(t, m.Validate())
+
+	provider["cacheModes"] = []interface{}{"S3"}
+	provider["cachePaths"] = []interface{}{"it's", ""}
+	assert.Equal(t, []string{
+		`buildConfig.provider.cacheModes "S3" is not one of [LOCAL_DOCKER_LAYER_CACHE LOCAL_SOURCE_CACHE LOCAL_CUSTOM_CACHE]`,
+		`buildConfig.provider.cachePaths has an invalid path "it's"`,
+		`buildConfig.provider.cachePaths has an invalid path ""`,
+		"buildConfig.provider.cachePaths require the LOCAL_CUSTOM_CACHE cache mode",
+	}, m.Validate())
+
+	provider["cacheModes"] = []interface{}{}
+	provider["cachePaths"] = "node_modules"
+	assert.Equal(t, []string{
+		"buildConfig.provider.cacheModes must be a non empty array",
+		"buildConfig.provider.cachePaths must be a non empty array",
+		"buildConfig.provider.cachePaths require the LOCAL_CUSTOM_CACHE cache mode",
+	}, m.Validate())
+}
+
+func TestValidateKeepAlive(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["keepAliveMinutes"] = json.Number("10")
+	assert.Equal(t, []string{"buildConfig.provider.keepAliveMinutes requires debugSession"}, m.Validate())
+
+	provider["debugSession"] = true
+	assert.Nil(t, m.Validate())
+	provider["keepAliveMinutes"] = "ten"
+	assert.Equal(t, []string{"buildConfig.provider.keepAliveMinutes must be a positive number"}, m.Validate())
+}
+
+func TestValidateLambda(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["computeType"] = "BUILD_LAMBDA_2GB"
+	assert.Nil(t, m.Validate())
+	provider["environmentType"] = "ARM_LAMBDA_CONTAINER"
+	assert.Nil(t, m.Validate())
+
+	provider["computeType"] = "BUILD_LAMBDA_3GB"
+	provider["environmentType"] = "WINDOWS_SERVER_2019_CONTAINER"
+	provider["privilegedMode"] = true
+	provider["debugSession"] = true
+	assert.Equal(t, []string{
+		`buildConfig.provider.computeType "BUILD_LAMBDA_3GB" is not one of [BUILD_LAMBDA_1GB BUILD_LAMBDA_2GB BUILD_LAMBDA_4GB BUILD_LAMBDA_8GB BUILD_LAMBDA_10GB]`,
+		`buildConfig.provider.environmentType "WINDOWS_SERVER_2019_CONTAINER" is not one of [LINUX_CONTAINER ARM_CONTAINER LINUX_LAMBDA_CONTAINER ARM_LAMBDA_CONTAINER]`,
+		"buildConfig.provider.privilegedMode is not supported by lambda compute type BUILD_LAMBDA_3GB",
+		"buildConfig.provider.debugSession is not supported by lambda compute type BUILD_LAMBDA_3GB",
+	}, m.Validate())
+
+	// lambda environments need a lambda compute type
+	provider["computeType"] = "BUILD_GENERAL1_SMALL"
+	provider["environmentType"] = "LINUX_LAMBDA_CONTAINER"
+	provider["privilegedMode"] = false
+	provider["debugSession"] = false
+	assert.Len(t, m.Validate(), 1)
+}
+
+func TestValidateArchitectures(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["architectures"] = []interface{}{"amd64", "arm64"}
+	assert.Nil(t, m.Validate())
+
+	provider["architectures"] = []interface{}{"arm64"}
+	assert.Equal(t, []string{"buildConfig.provider.architectures must list at least two architectures, use provider.architecture for a single one"}, m.Validate())
+}
+
+func TestValidateAccountAlias(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	m.ExecutorType = "eks"
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["accountAlias"] = "team-a"
+	provider["namespace"] = "sd-builds"
+	delete(provider, "role")
+	delete(provider, "region")
+	assert.Nil(t, m.Validate())
+
+	provider["accountAlias"] = 1
+	provider["role"] = 2
+	assert.Equal(t, []string{
+		"buildConfig.provider.accountAlias must be a non empty string",
+		"buildConfig.provider.role must be a non empty string",
+	}, m.Validate())
+}
+
+func TestValidateEks(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	m.ExecutorType = "eks"
+	assert.Equal(t, []string{
+		"buildConfig.provider.namespace is required",
+		"buildConfig.provider.clusterName is required",
+	}, m.Validate())
+
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["namespace"] = "sd-builds"
+	m.BuildConfig["clusterName"] = "sd-build"
+	assert.Nil(t, m.Validate())
+
+	provider["volumeMode"] = "ephemeral"
+	assert.Nil(t, m.Validate())
+	provider["volumeMode"] = "nfs"
+	provider["workload"] = "deployment"
+	provider["schedulerName"] = 1
+	provider["zoneSpreadMaxSkew"] = json.Number("-1")
+	assert.Equal(t, []string{
+		`buildConfig.provider.volumeMode "nfs" is not one of [hostPath ephemeral csi]`,
+		"buildConfig.provider.schedulerName must be a non empty string",
+		`buildConfig.provider.workload "deployment" is not one of [pod job]`,
+		"buildConfig.provider.zoneSpreadMaxSkew must be a positive number",
+	}, m.Validate())
+	provider["zoneSpreadMaxSkew"] = json.Number("2")
+	delete(provider, "volumeMode")
+	provider["workload"] = "job"
+	provider["schedulerName"] = "volcano"
+	assert.Nil(t, m.Validate())
+	delete(provider, "workload")
+
+	m, _ = Decode([]byte(`{"job": "stop", "executorType": "batch"}`))
+	assert.Equal(t, []string{
+		`executorType "batch" is not one of [sls eks ecs ec2]`,
+		"buildConfig.apiUri is required",
+		"buildConfig.buildId is required",
+		"buildConfig.buildTimeout is required",
+		"buildConfig.jobId is required",
+		"buildConfig.jobName is required",
+		"buildConfig.pipelineId is required",
+		"buildConfig.provider is required",
+		"buildConfig.storeUri is required",
+		"buildConfig.token is required",
+		"buildConfig.uiUri is required",
+	}, m.Validate())
+}
+
+func TestValidateEcs(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	m.ExecutorType = "ecs"
+	assert.Nil(t, m.Validate())
+
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["vpc"] = map[string]interface{}{"subnetIds": "subnet-1"}
+	provider["privilegedMode"] = true
+	assert.Equal(t, []string{
+		"buildConfig.provider.vpc.securityGroupIds is required",
+		"buildConfig.provider.vpc.subnetIds must be a non empty array",
+		"buildConfig.provider.privilegedMode is not supported by the ecs executor",
+	}, m.Validate())
+
+	delete(provider, "vpc")
+	delete(provider, "privilegedMode")
+	assert.Equal(t, []string{"buildConfig.provider.vpc is required"}, m.Validate())
+}
+
+func TestValidateEc2(t *testing.T) {
+	m, _ := Decode([]byte(testSlsMessage))
+	m.ExecutorType = "ec2"
+	provider := m.BuildConfig["provider"].(map[string]interface{})
+	provider["imageId"] = "ami-0abc"
+	assert.Nil(t, m.Validate())
+
+	provider["vpc"] = map[string]interface{}{"securityGroupIds": []interface{}{"sg-1"}}
+	provider["instanceType"] = ""
+	delete(provider, "imageId")
+	assert.Equal(t, []string{
+		"buildConfig.provider.imageId is required",
+		"buildConfig.provider.vpc.subnetIds is required",
+		"buildConfig.provider.instanceType must be a non empty string",
+	}, m.Validate())
+}
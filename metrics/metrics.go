@@ -0,0 +1,83 @@
+// Package metrics writes CloudWatch embedded metric format (EMF) log lines, which CloudWatch turns into metrics,
+// and exposes counters and histograms in the prometheus text format
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Milliseconds unit
+	Milliseconds = "Milliseconds"
+	// Count unit
+	Count = "Count"
+	// namespaceEnv overrides the metrics namespace
+	namespaceEnv     = "SD_METRICS_NAMESPACE"
+	defaultNamespace = "Screwdriver/AwsConsumer"
+)
+
+var (
+	mu     sync.Mutex
+	output io.Writer = os.Stdout
+	now              = time.Now
+)
+
+// SetOutput sets the destination of the metric lines
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// gets the metrics namespace
+func namespace() string {
+	if ns := os.Getenv(namespaceEnv); ns != "" {
+		return ns
+	}
+	return defaultNamespace
+}
+
+// Put writes a single metric value with its dimensions
+func Put(name string, value float64, unit string, dimensions map[string]string) {
+	keys := make([]string, 0, len(dimensions))
+	for k := range dimensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	line := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": now().UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace":  namespace(),
+					"Dimensions": [][]string{keys},
+					"Metrics":    []map[string]string{{"Name": name, "Unit": unit}},
+				},
+			},
+		},
+		name: value,
+	}
+	for k, v := range dimensions {
+		line[k] = v
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Fprintln(output, string(b))
+}
+
+// Since writes the milliseconds elapsed since start
+func Since(name string, start time.Time, dimensions map[string]string) {
+	Put(name, float64(now().Sub(start))/float64(time.Millisecond), Milliseconds, dimensions)
+}
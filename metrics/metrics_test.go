@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPut(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+	now = func() time.Time { return time.Unix(1600000000, 0) }
+	defer func() { now = time.Now }()
+
+	Put("BuildStarted", 1, Count, map[string]string{"Region": "us-west-2", "Executor": "sls"})
+
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1600000000000,
+			"CloudWatchMetrics": [{
+				"Namespace": "Screwdriver/AwsConsumer",
+				"Dimensions": [["Executor", "Region"]],
+				"Metrics": [{"Name": "BuildStarted", "Unit": "Count"}]
+			}]
+		},
+		"BuildStarted": 1,
+		"Executor": "sls",
+		"Region": "us-west-2"
+	}`, buf.String())
+}
+
+func TestSince(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+	t.Setenv("SD_METRICS_NAMESPACE", "Test")
+	now = func() time.Time { return time.Unix(1600000010, 0) }
+	defer func() { now = time.Now }()
+
+	Since("BuildStartLatency", time.Unix(1600000000, 0), nil)
+
+	assert.JSONEq(t, `{
+		"_aws": {
+			"Timestamp": 1600000010000,
+			"CloudWatchMetrics": [{
+				"Namespace": "Test",
+				"Dimensions": [[]],
+				"Metrics": [{"Name": "BuildStartLatency", "Unit": "Milliseconds"}]
+			}]
+		},
+		"BuildStartLatency": 10000
+	}`, buf.String())
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	counterType   = "counter"
+	histogramType = "histogram"
+)
+
+// escapes label values as required by the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// DefaultBuckets are the histogram buckets in seconds used for latencies
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// family is a named prometheus metric with one series per label set
+type family struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+// series holds the value of a counter or the observations of a histogram
+type series struct {
+	labels map[string]string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var (
+	familiesMu sync.Mutex
+	families   = map[string]*family{}
+)
+
+// registers a family, returning the existing one if the name is already registered
+func register(name, help, kind string, buckets []float64) *family {
+	familiesMu.Lock()
+	defer familiesMu.Unlock()
+
+	if f, ok := families[name]; ok {
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, buckets: buckets, series: map[string]*series{}}
+	families[name] = f
+	return f
+}
+
+// gets the series of the label set, creating it on first use
+func (f *family) get(labels map[string]string) *series {
+	keys := sortedKeys(labels)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+labels[k])
+	}
+	key := strings.Join(parts, ",")
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: labels, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a prometheus counter
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter exposed by Handler
+func NewCounter(name, help string) *Counter {
+	return &Counter{f: register(name, help, counterType, nil)}
+}
+
+// Inc increments the counter of the label set
+func (c *Counter) Inc(labels map[string]string) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.get(labels).value++
+}
+
+// Histogram is a prometheus histogram
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets, exposed by Handler
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return &Histogram{f: register(name, help, histogramType, buckets)}
+}
+
+// Observe adds a value to the histogram of the label set
+func (h *Histogram) Observe(value float64, labels map[string]string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	s := h.f.get(labels)
+	for i, bound := range h.f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// gets the sorted keys of a label set
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formats a label set, extra is appended as is
+func formatLabels(labels map[string]string, extra string) string {
+	parts := make([]string, 0, len(labels)+1)
+	for _, k := range sortedKeys(labels) {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, k, labelEscaper.Replace(labels[k])))
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// formats a sample value
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writes a family in the prometheus text format
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind == counterType {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(s.labels, ""), formatValue(s.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(s.labels, fmt.Sprintf(`le="%s"`, formatValue(bound))), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(s.labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(s.labels, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(s.labels, ""), s.count)
+	}
+}
+
+// WritePrometheus writes all registered metrics in the prometheus text format
+func WritePrometheus(w io.Writer) {
+	familiesMu.Lock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	familiesMu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		familiesMu.Lock()
+		f := families[name]
+		familiesMu.Unlock()
+		f.write(w)
+	}
+}
+
+// Handler serves the registered metrics for prometheus scraping
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w)
+	})
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_builds_total", "Builds started")
+	c.Inc(map[string]string{"executor": "sls"})
+	c.Inc(map[string]string{"executor": "sls"})
+	c.Inc(map[string]string{"executor": "eks"})
+	c.Inc(map[string]string{"executor": `a"b\`})
+
+	var buf bytes.Buffer
+	c.f.write(&buf)
+	assert.Equal(t, `# HELP test_builds_total Builds started
+# TYPE test_builds_total counter
+test_builds_total{executor="a\"b\\"} 1
+test_builds_total{executor="eks"} 1
+test_builds_total{executor="sls"} 2
+`, buf.String())
+
+	assert.Equal(t, c.f, NewCounter("test_builds_total", "Builds started").f)
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_lag_seconds", "Kafka lag", []float64{1, 5})
+	h.Observe(0.5, nil)
+	h.Observe(3, nil)
+	h.Observe(10, nil)
+
+	var buf bytes.Buffer
+	h.f.write(&buf)
+	assert.Equal(t, `# HELP test_lag_seconds Kafka lag
+# TYPE test_lag_seconds histogram
+test_lag_seconds_bucket{le="1"} 1
+test_lag_seconds_bucket{le="5"} 2
+test_lag_seconds_bucket{le="+Inf"} 3
+test_lag_seconds_sum 13.5
+test_lag_seconds_count 3
+`, buf.String())
+}
+
+func TestHandler(t *testing.T) {
+	NewCounter("test_handler_total", "Handler calls").Inc(map[string]string{"job": "start"})
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4", rec.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(rec.Body.String(), "# TYPE test_handler_total counter\ntest_handler_total{job=\"start\"} 1\n"))
+}
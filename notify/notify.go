@@ -0,0 +1,274 @@
+// Package notify sends the high severity events of the consumer, like builds given up for lack of capacity or killed
+// by the reconciler, to an SNS topic and a Slack webhook so on-call hears about systemic problems and not just the
+// failures of single builds
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/metrics"
+)
+
+const (
+	topicArnEnv      = "SD_NOTIFY_SNS_TOPIC_ARN"
+	slackWebhookEnv  = "SD_NOTIFY_SLACK_WEBHOOK_URL"
+	startFailuresEnv = "SD_NOTIFY_START_FAILURES"
+	windowEnv        = "SD_NOTIFY_WINDOW_SECS"
+
+	// defaultStartFailures is how many starts of an account fail within the window before it is notified
+	defaultStartFailures = 5
+	// defaultWindow is the window start failures are counted in, and the same event of an account is sent once in
+	defaultWindow = 10 * time.Minute
+
+	slackTimeout = 5 * time.Second
+	// maxSubjectLength is the longest subject sns accepts
+	maxSubjectLength = 100
+)
+
+// Kind is the kind of a notified event
+type Kind string
+
+const (
+	// DeadLetter is a build message given up after all its requeue attempts
+	DeadLetter Kind = "dead-letter"
+	// ReconcilerKill is a build failed or stopped by the reconciler, like a start cut short by the lambda deadline
+	// or a build whose launcher sent no heartbeat
+	ReconcilerKill Kind = "reconciler-kill"
+	// QuotaExhausted is a start failed by exhausted account concurrency or aws capacity
+	QuotaExhausted Kind = "quota-exhausted"
+	// StartFailures is an account failing to start builds repeatedly
+	StartFailures Kind = "start-failures"
+)
+
+// Event is a high severity event of the consumer
+type Event struct {
+	Kind Kind `json:"kind"`
+	// Account is the provider account of the event, empty when it is not known
+	Account string    `json:"account,omitempty"`
+	BuildID int       `json:"buildId,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// String gets the text of the event
+func (e Event) String() string {
+	if e.Account == "" {
+		return fmt.Sprintf("aws consumer %s: %s", e.Kind, e.Message)
+	}
+	return fmt.Sprintf("aws consumer %s of account %s: %s", e.Kind, e.Account, e.Message)
+}
+
+// Sink receives the notified events
+type Sink interface {
+	Send(event Event) error
+}
+
+// notifications sent by kind and status
+var notificationsSent = metrics.NewCounter("sd_aws_consumer_notifications_total", "Notifications of high severity events by kind and status")
+
+// SNSSink publishes events to an SNS topic as json, with their text as subject
+type SNSSink struct {
+	client   snsiface.SNSAPI
+	topicArn string
+}
+
+// NewSNSSink returns the sink publishing to the topic
+func NewSNSSink(topicArn string) *SNSSink {
+	return &SNSSink{topicArn: topicArn}
+}
+
+// gets the sns client of the region of the topic, creating it on first use
+func (s *SNSSink) sns() (snsiface.SNSAPI, error) {
+	if s.client == nil {
+		region := ""
+		if topicArn, err := arn.Parse(s.topicArn); err == nil {
+			region = topicArn.Region
+		}
+		sess, err := awsconfig.NewSession(region)
+		if err != nil {
+			return nil, err
+		}
+		s.client = sns.New(sess)
+	}
+	return s.client, nil
+}
+
+// Send publishes the event to the topic
+func (s *SNSSink) Send(event Event) error {
+	client, err := s.sns()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	subject := event.String()
+	if len(subject) > maxSubjectLength {
+		subject = subject[:maxSubjectLength-3] + "..."
+	}
+	_, err = client.Publish(&sns.PublishInput{
+		TopicArn: aws.String(s.topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error-Publish: %v", err)
+	}
+	return nil
+}
+
+// SlackSink posts the text of events to a Slack incoming webhook
+type SlackSink struct {
+	client *http.Client
+	url    string
+}
+
+// NewSlackSink returns the sink posting to the webhook
+func NewSlackSink(url string) *SlackSink {
+	return &SlackSink{client: &http.Client{Timeout: slackTimeout}, url: url}
+}
+
+// Send posts the event to the webhook
+func (s *SlackSink) Send(event Event) error {
+	body, err := json.Marshal(map[string]string{"text": event.String()})
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Got error posting to slack webhook: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("Got status %v posting to slack webhook", res.StatusCode)
+	}
+	return nil
+}
+
+// Notifier sends events to its sinks, sending the same event of an account once per window
+type Notifier struct {
+	sinks         []Sink
+	startFailures int
+	window        time.Duration
+	now           func() time.Time
+
+	mu sync.Mutex
+	// sent holds when an event was last sent by kind and account
+	sent map[string]time.Time
+	// failures holds the times of the failed starts of the window by account
+	failures map[string][]time.Time
+}
+
+// New returns the notifier of the sinks
+func New(sinks ...Sink) *Notifier {
+	return &Notifier{
+		sinks:         sinks,
+		startFailures: defaultStartFailures,
+		window:        defaultWindow,
+		now:           time.Now,
+		sent:          map[string]time.Time{},
+		failures:      map[string][]time.Time{},
+	}
+}
+
+// FromEnv returns the notifier of SD_NOTIFY_SNS_TOPIC_ARN and SD_NOTIFY_SLACK_WEBHOOK_URL, nil when neither is set
+func FromEnv() *Notifier {
+	var sinks []Sink
+	if topicArn := os.Getenv(topicArnEnv); topicArn != "" {
+		sinks = append(sinks, NewSNSSink(topicArn))
+	}
+	if url := os.Getenv(slackWebhookEnv); url != "" {
+		sinks = append(sinks, NewSlackSink(url))
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	n := New(sinks...)
+	if count, err := strconv.Atoi(strings.TrimSpace(os.Getenv(startFailuresEnv))); err == nil && count > 0 {
+		n.startFailures = count
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv(windowEnv))); err == nil && secs > 0 {
+		n.window = time.Duration(secs) * time.Second
+	}
+	return n
+}
+
+// Notify sends the event to all sinks unless the same event of the account was sent within the window,
+// failing sinks are logged
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = n.now()
+	}
+	key := string(event.Kind) + "/" + event.Account
+	n.mu.Lock()
+	if last, ok := n.sent[key]; ok && event.Time.Sub(last) < n.window {
+		n.mu.Unlock()
+		log.Printf("Not notifying %v, it was notified at %v", event, last.Format(time.RFC3339))
+		return
+	}
+	n.sent[key] = event.Time
+	n.mu.Unlock()
+
+	log.Printf("Notifying %v", event)
+	for _, sink := range n.sinks {
+		status := "success"
+		if err := sink.Send(event); err != nil {
+			status = "error"
+			log.Printf("Sending notification: %v", err)
+		}
+		notificationsSent.Inc(map[string]string{"kind": string(event.Kind), "status": status})
+	}
+}
+
+// StartFailed counts a failed start of the account, notifying once the account failed to start the configured number
+// of builds within the window
+func (n *Notifier) StartFailed(account string, buildID int, err error) {
+	if n == nil {
+		return
+	}
+	now := n.now()
+	n.mu.Lock()
+	failures := []time.Time{now}
+	for _, at := range n.failures[account] {
+		if now.Sub(at) < n.window {
+			failures = append(failures, at)
+		}
+	}
+	count := len(failures)
+	if count >= n.startFailures {
+		delete(n.failures, account)
+	} else {
+		n.failures[account] = failures
+	}
+	n.mu.Unlock()
+
+	if count < n.startFailures {
+		return
+	}
+	n.Notify(Event{
+		Kind:    StartFailures,
+		Account: account,
+		BuildID: buildID,
+		Message: fmt.Sprintf("%d builds failed to start within %v, the last one %d with: %v", count, n.window, buildID, err),
+		Time:    now,
+	})
+}
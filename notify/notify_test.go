@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSNS struct {
+	snsiface.SNSAPI
+	inputs []*sns.PublishInput
+	err    error
+}
+
+func (m *mockSNS) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	m.inputs = append(m.inputs, input)
+	return &sns.PublishOutput{}, m.err
+}
+
+type mockSink struct {
+	events []Event
+	err    error
+}
+
+func (m *mockSink) Send(event Event) error {
+	m.events = append(m.events, event)
+	return m.err
+}
+
+var testTime = time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// returns a notifier of the sink at the time the returned func advances
+func testNotifier(sinks ...Sink) (*Notifier, func(d time.Duration)) {
+	n := New(sinks...)
+	now := testTime
+	n.now = func() time.Time { return now }
+	return n, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(topicArnEnv, "")
+	t.Setenv(slackWebhookEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(topicArnEnv, "arn:aws:sns:us-west-2:123456789012:sd-oncall")
+	n := FromEnv()
+	assert.Len(t, n.sinks, 1)
+	assert.Equal(t, defaultStartFailures, n.startFailures)
+	assert.Equal(t, defaultWindow, n.window)
+
+	t.Setenv(slackWebhookEnv, "https://hooks.slack.com/services/T0/B0/x")
+	t.Setenv(startFailuresEnv, "3")
+	t.Setenv(windowEnv, "60")
+	n = FromEnv()
+	assert.Len(t, n.sinks, 2)
+	assert.Equal(t, 3, n.startFailures)
+	assert.Equal(t, time.Minute, n.window)
+}
+
+func TestEventString(t *testing.T) {
+	event := Event{Kind: QuotaExhausted, Account: "123456789012", Message: "Build 1 found no capacity"}
+	assert.Equal(t, "aws consumer quota-exhausted of account 123456789012: Build 1 found no capacity", event.String())
+	event.Account = ""
+	assert.Equal(t, "aws consumer quota-exhausted: Build 1 found no capacity", event.String())
+}
+
+func TestSNSSink(t *testing.T) {
+	client := &mockSNS{}
+	sink := NewSNSSink("arn:aws:sns:us-west-2:123456789012:sd-oncall")
+	sink.client = client
+	event := Event{Kind: DeadLetter, BuildID: 1234, Message: strings.Repeat("x", 200), Time: testTime}
+	assert.Nil(t, sink.Send(event))
+
+	input := client.inputs[0]
+	assert.Equal(t, "arn:aws:sns:us-west-2:123456789012:sd-oncall", *input.TopicArn)
+	assert.Len(t, *input.Subject, maxSubjectLength)
+	assert.True(t, strings.HasSuffix(*input.Subject, "..."))
+	var published Event
+	assert.Nil(t, json.Unmarshal([]byte(*input.Message), &published))
+	assert.Equal(t, event, published)
+
+	client.err = errors.New("AuthorizationError")
+	assert.EqualError(t, sink.Send(event), "Error-Publish: AuthorizationError")
+}
+
+func TestSlackSink(t *testing.T) {
+	var bodies []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewSlackSink(server.URL)
+	event := Event{Kind: ReconcilerKill, Account: "123456789012", BuildID: 1234, Message: "Build 1234 did not start"}
+	assert.Nil(t, sink.Send(event))
+	assert.Equal(t, []string{`{"text":"aws consumer reconciler-kill of account 123456789012: Build 1234 did not start"}`}, bodies)
+
+	status = http.StatusForbidden
+	assert.EqualError(t, sink.Send(event), "Got status 403 posting to slack webhook")
+}
+
+func TestNotify(t *testing.T) {
+	sink, failing := &mockSink{}, &mockSink{err: errors.New("unavailable")}
+	n, advance := testNotifier(failing, sink)
+	n.Notify(Event{Kind: QuotaExhausted, Account: "123456789012", Message: "no capacity"})
+	assert.Len(t, sink.events, 1)
+	assert.Equal(t, testTime, sink.events[0].Time)
+
+	// the same event of the account is sent once per window
+	advance(time.Minute)
+	n.Notify(Event{Kind: QuotaExhausted, Account: "123456789012", Message: "no capacity"})
+	n.Notify(Event{Kind: QuotaExhausted, Account: "210987654321", Message: "no capacity"})
+	n.Notify(Event{Kind: DeadLetter, Account: "123456789012", Message: "given up"})
+	assert.Len(t, sink.events, 3)
+
+	advance(defaultWindow)
+	n.Notify(Event{Kind: QuotaExhausted, Account: "123456789012", Message: "no capacity"})
+	assert.Len(t, sink.events, 4)
+	assert.Len(t, failing.events, 4)
+
+	var disabled *Notifier
+	disabled.Notify(Event{Kind: DeadLetter})
+	disabled.StartFailed("123456789012", 1, errors.New("failed"))
+}
+
+func TestStartFailed(t *testing.T) {
+	sink := &mockSink{}
+	n, advance := testNotifier(sink)
+	n.startFailures = 3
+	n.StartFailed("123456789012", 1, errors.New("failed"))
+	n.StartFailed("123456789012", 2, errors.New("failed"))
+	n.StartFailed("210987654321", 3, errors.New("failed"))
+	assert.Len(t, sink.events, 0)
+
+	// failures out of the window are not counted
+	advance(defaultWindow)
+	n.StartFailed("123456789012", 4, errors.New("failed"))
+	n.StartFailed("123456789012", 5, errors.New("failed"))
+	assert.Len(t, sink.events, 0)
+	advance(time.Minute)
+	n.StartFailed("123456789012", 6, errors.New("ServiceUnavailableException"))
+	assert.Equal(t, []Event{{
+		Kind:    StartFailures,
+		Account: "123456789012",
+		BuildID: 6,
+		Message: "3 builds failed to start within 10m0s, the last one 6 with: ServiceUnavailableException",
+		Time:    testTime.Add(defaultWindow + time.Minute),
+	}}, sink.events)
+
+	// the count starts over once notified
+	n.StartFailed("123456789012", 7, errors.New("failed"))
+	assert.Len(t, n.failures["123456789012"], 1)
+}
@@ -0,0 +1,146 @@
+// Package overrides looks up provider overrides of pipelines in a DynamoDB table, so admins can tune the builds of a
+// pipeline, e.g. force a compute type or turn off privileged mode, without redeploying the queue service
+package overrides
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	tableEnv = "SD_PIPELINE_OVERRIDES_TABLE"
+	ttlEnv   = "SD_PIPELINE_OVERRIDES_TTL_SECS"
+
+	defaultTTL = 5 * time.Minute
+)
+
+// Overrides are the provider fields forced for the builds of a pipeline, keyed by the string attribute pipelineId
+type Overrides struct {
+	PipelineID string                 `dynamodbav:"pipelineId"`
+	Provider   map[string]interface{} `dynamodbav:"provider"`
+}
+
+// cached lookup of a pipeline, overrides is nil for pipelines without overrides
+type entry struct {
+	overrides *Overrides
+	expires   time.Time
+}
+
+// Table looks up overrides in a DynamoDB table, caching them for the ttl
+type Table struct {
+	client  dynamodbiface.DynamoDBAPI
+	table   string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]entry
+	now     func() time.Time
+}
+
+// FromEnv returns the table of SD_PIPELINE_OVERRIDES_TABLE, nil when pipelines have no overrides
+func FromEnv() *Table {
+	table := os.Getenv(tableEnv)
+	if table == "" {
+		return nil
+	}
+	ttl := defaultTTL
+	if secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv(ttlEnv))); err == nil && secs >= 0 {
+		ttl = time.Duration(secs) * time.Second
+	}
+	return &Table{table: table, ttl: ttl, entries: map[string]entry{}, now: time.Now}
+}
+
+// gets the dynamodb client, creating it on first use
+func (t *Table) dynamodb() (dynamodbiface.DynamoDBAPI, error) {
+	if t.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		t.client = dynamodb.New(sess)
+	}
+	return t.client, nil
+}
+
+// Lookup returns the overrides of the pipeline, nil when it has none. Pipelines without overrides are cached too,
+// as most pipelines have none.
+func (t *Table) Lookup(pipelineID string) (*Overrides, error) {
+	t.mu.Lock()
+	if e, ok := t.entries[pipelineID]; ok && t.now().Before(e.expires) {
+		t.mu.Unlock()
+		return e.overrides, nil
+	}
+	client, err := t.dynamodb()
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// the lookups of other pipelines don't wait for the table
+	result, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(t.table),
+		Key:       map[string]*dynamodb.AttributeValue{"pipelineId": {S: aws.String(pipelineID)}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-GetItem: %v", err)
+	}
+	var overrides *Overrides
+	if len(result.Item) > 0 {
+		overrides = &Overrides{}
+		if err := dynamodbattribute.UnmarshalMap(result.Item, overrides); err != nil {
+			return nil, fmt.Errorf("Got error decoding overrides of pipeline %s: %v", pipelineID, err)
+		}
+		if overrides.Provider != nil {
+			overrides.Provider = toJSONNumbers(overrides.Provider).(map[string]interface{})
+		}
+	}
+	t.mu.Lock()
+	t.entries[pipelineID] = entry{overrides: overrides, expires: t.now().Add(t.ttl)}
+	t.mu.Unlock()
+	return overrides, nil
+}
+
+// converts the numbers of a decoded item to the json numbers of decoded build messages
+func toJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			converted[k] = toJSONNumbers(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = toJSONNumbers(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// Apply replaces the provider fields with the overrides, returns the names of the fields it changed
+func (o *Overrides) Apply(provider map[string]interface{}) []string {
+	var changed []string
+	for k, v := range o.Provider {
+		if fmt.Sprint(provider[k]) != fmt.Sprint(v) {
+			changed = append(changed, k)
+		}
+		provider[k] = v
+	}
+	sort.Strings(changed)
+	return changed
+}
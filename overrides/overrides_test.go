@@ -0,0 +1,138 @@
+package overrides
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mock.Mock
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.GetItemOutput), args.Error(1)
+}
+
+func getItemInput(pipelineID string) *dynamodb.GetItemInput {
+	return &dynamodb.GetItemInput{
+		TableName: aws.String("sd-pipeline-overrides"),
+		Key:       map[string]*dynamodb.AttributeValue{"pipelineId": {S: aws.String(pipelineID)}},
+	}
+}
+
+var testItem = map[string]*dynamodb.AttributeValue{
+	"pipelineId": {S: aws.String("1898")},
+	"provider": {M: map[string]*dynamodb.AttributeValue{
+		"computeType":    {S: aws.String("BUILD_GENERAL1_LARGE")},
+		"privilegedMode": {BOOL: aws.Bool(false)},
+		"queuedTimeout":  {N: aws.String("30")},
+	}},
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(tableEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(tableEnv, "sd-pipeline-overrides")
+	assert.Equal(t, defaultTTL, FromEnv().ttl)
+	t.Setenv(ttlEnv, "30")
+	table := FromEnv()
+	assert.Equal(t, "sd-pipeline-overrides", table.table)
+	assert.Equal(t, 30*time.Second, table.ttl)
+}
+
+func TestLookup(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("GetItem", getItemInput("1898")).Return(&dynamodb.GetItemOutput{Item: testItem}, nil).Once()
+	client.On("GetItem", getItemInput("42")).Return(&dynamodb.GetItemOutput{}, nil).Once()
+	client.On("GetItem", getItemInput("7")).Return(&dynamodb.GetItemOutput{}, errors.New("throttled"))
+	now := time.Now()
+	table := &Table{client: client, table: "sd-pipeline-overrides", ttl: time.Minute, entries: map[string]entry{}, now: func() time.Time { return now }}
+
+	overrides, err := table.Lookup("1898")
+	assert.Nil(t, err)
+	assert.Equal(t, &Overrides{PipelineID: "1898", Provider: map[string]interface{}{
+		"computeType":    "BUILD_GENERAL1_LARGE",
+		"privilegedMode": false,
+		"queuedTimeout":  json.Number("30"),
+	}}, overrides)
+
+	// pipelines with and without overrides are cached
+	for i := 0; i < 2; i++ {
+		overrides, err = table.Lookup("42")
+		assert.Nil(t, err)
+		assert.Nil(t, overrides)
+		_, err = table.Lookup("1898")
+		assert.Nil(t, err)
+	}
+	client.AssertNumberOfCalls(t, "GetItem", 2)
+
+	_, err = table.Lookup("7")
+	assert.EqualError(t, err, "Error-GetItem: throttled")
+
+	now = now.Add(time.Minute)
+	client.On("GetItem", getItemInput("42")).Return(&dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"pipelineId": {S: aws.String("42")},
+	}}, nil).Once()
+	overrides, err = table.Lookup("42")
+	assert.Nil(t, err)
+	assert.Equal(t, &Overrides{PipelineID: "42"}, overrides)
+}
+
+func TestLookupDoesNotBlockCachedPipelines(t *testing.T) {
+	reading, release := make(chan struct{}), make(chan struct{})
+	client := new(mockDynamoDB)
+	client.On("GetItem", getItemInput("1898")).Run(func(mock.Arguments) {
+		close(reading)
+		<-release
+	}).Return(&dynamodb.GetItemOutput{Item: testItem}, nil).Once()
+	now := time.Now()
+	table := &Table{client: client, table: "sd-pipeline-overrides", ttl: time.Minute, now: func() time.Time { return now },
+		entries: map[string]entry{"42": {expires: now.Add(time.Minute)}}}
+
+	done := make(chan error)
+	go func() {
+		_, err := table.Lookup("1898")
+		done <- err
+	}()
+	// the cached pipeline is looked up while the table is read
+	<-reading
+	overrides, err := table.Lookup("42")
+	assert.Nil(t, err)
+	assert.Nil(t, overrides)
+	close(release)
+	assert.Nil(t, <-done)
+	assert.NotNil(t, table.entries["1898"].overrides)
+}
+
+func TestApply(t *testing.T) {
+	overrides := &Overrides{PipelineID: "1898", Provider: map[string]interface{}{
+		"computeType":    "BUILD_GENERAL1_LARGE",
+		"privilegedMode": false,
+		"vpc":            map[string]interface{}{"subnetIds": []interface{}{"subnet-1"}},
+	}}
+	provider := map[string]interface{}{
+		"computeType":    "BUILD_GENERAL1_SMALL",
+		"privilegedMode": false,
+		"region":         "us-west-2",
+	}
+	assert.Equal(t, []string{"computeType", "vpc"}, overrides.Apply(provider))
+	assert.Equal(t, map[string]interface{}{
+		"computeType":    "BUILD_GENERAL1_LARGE",
+		"privilegedMode": false,
+		"region":         "us-west-2",
+		"vpc":            map[string]interface{}{"subnetIds": []interface{}{"subnet-1"}},
+	}, provider)
+	assert.Nil(t, overrides.Apply(provider))
+	assert.Nil(t, (&Overrides{}).Apply(provider))
+}
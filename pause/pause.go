@@ -0,0 +1,88 @@
+// Package pause lets operators pause the start of builds with an ssm parameter, so AWS build capacity can be drained
+// during incidents without disabling the event source mappings of the consumer. Builds are stopped while paused.
+package pause
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	// name of the ssm parameter pausing starts while it is true
+	parameterEnv = "SD_PAUSE_SSM_PARAMETER"
+	// how long the parameter value is reused, short so a pause takes effect quickly
+	cacheTTL = 30 * time.Second
+)
+
+// Switch reads whether the start of builds is paused from an ssm parameter
+type Switch struct {
+	ssm       ssmiface.SSMAPI
+	name      string
+	mu        sync.Mutex
+	paused    bool
+	checkedAt time.Time
+	now       func() time.Time
+}
+
+// FromEnv returns the switch of SD_PAUSE_SSM_PARAMETER, nil when starts cannot be paused
+func FromEnv() *Switch {
+	name := strings.TrimSpace(os.Getenv(parameterEnv))
+	if name == "" {
+		return nil
+	}
+	return &Switch{name: name, now: time.Now}
+}
+
+// gets the ssm client, creating it on first use
+func (s *Switch) client() (ssmiface.SSMAPI, error) {
+	if s.ssm == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		s.ssm = ssm.New(sess)
+	}
+	return s.ssm, nil
+}
+
+// Paused returns true if starts are paused, a missing parameter resumes them. When the parameter cannot be read,
+// the last value read is returned with the error and kept until the next check.
+func (s *Switch) Paused() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.checkedAt.IsZero() && s.now().Sub(s.checkedAt) < cacheTTL {
+		return s.paused, nil
+	}
+	s.checkedAt = s.now()
+	client, err := s.client()
+	if err != nil {
+		return s.paused, err
+	}
+	output, err := client.GetParameter(&ssm.GetParameterInput{Name: aws.String(s.name)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		s.paused = false
+		return false, nil
+	}
+	if err != nil {
+		return s.paused, fmt.Errorf("Error-GetParameter: %v", err)
+	}
+	value := strings.TrimSpace(aws.StringValue(output.Parameter.Value))
+	paused, err := strconv.ParseBool(value)
+	if err != nil && value != "" {
+		return s.paused, fmt.Errorf("Got invalid value %q of parameter %s, expected true or false", value, s.name)
+	}
+	s.paused = paused
+	return paused, nil
+}
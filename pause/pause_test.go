@@ -0,0 +1,82 @@
+package pause
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	value string
+	err   error
+	calls int
+}
+
+func (m *mockSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Name: input.Name, Value: aws.String(m.value)}}, nil
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(parameterEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(parameterEnv, "/screwdriver/consumer/paused")
+	assert.Equal(t, "/screwdriver/consumer/paused", FromEnv().name)
+}
+
+func TestPaused(t *testing.T) {
+	client := &mockSSM{value: "true"}
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &Switch{ssm: client, name: "/screwdriver/consumer/paused", now: func() time.Time { return now }}
+
+	paused, err := s.Paused()
+	assert.Nil(t, err)
+	assert.True(t, paused)
+
+	// the value is cached
+	client.value = "false"
+	paused, _ = s.Paused()
+	assert.True(t, paused)
+	assert.Equal(t, 1, client.calls)
+
+	now = now.Add(cacheTTL)
+	paused, err = s.Paused()
+	assert.Nil(t, err)
+	assert.False(t, paused)
+
+	// errors keep the last value
+	now = now.Add(cacheTTL)
+	client.value = "true"
+	paused, _ = s.Paused()
+	assert.True(t, paused)
+	now = now.Add(cacheTTL)
+	client.err = errors.New("ThrottlingException")
+	paused, err = s.Paused()
+	assert.EqualError(t, err, "Error-GetParameter: ThrottlingException")
+	assert.True(t, paused)
+
+	now = now.Add(cacheTTL)
+	client.err = nil
+	client.value = "drain"
+	paused, err = s.Paused()
+	assert.EqualError(t, err, `Got invalid value "drain" of parameter /screwdriver/consumer/paused, expected true or false`)
+	assert.True(t, paused)
+
+	// deleting the parameter resumes starts
+	now = now.Add(cacheTTL)
+	client.err = awserr.New(ssm.ErrCodeParameterNotFound, "not found", nil)
+	paused, err = s.Paused()
+	assert.Nil(t, err)
+	assert.False(t, paused)
+}
@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budgets are monthly spend limits in USD by pipeline id and provider account id
+type Budgets struct {
+	Pipelines map[string]float64 `json:"pipelines"`
+	Accounts  map[string]float64 `json:"accounts"`
+	// ExemptPipelines keep running all builds over budget
+	ExemptPipelines []string `json:"exemptPipelines"`
+	// ExemptAccounts are not blocked by their account budget, budgets of their pipelines still apply
+	ExemptAccounts []string `json:"exemptAccounts"`
+}
+
+// budget scopes
+const (
+	PipelineScope = "pipeline"
+	AccountScope  = "account"
+)
+
+// SpendReader reads the spend of a pipeline or account in a month (YYYY-MM)
+type SpendReader interface {
+	Spend(scope string, id string, month string) (float64, error)
+}
+
+// builds of pull requests and of branches other than the main branch of the pipeline,
+// builds without a branch are only told apart by isPR
+func offMainBranch(buildConfig map[string]interface{}) bool {
+	if isPR, _ := buildConfig["isPR"].(bool); isPR {
+		return true
+	}
+	branch, _ := buildConfig["branch"].(string)
+	pipelineBranch, _ := buildConfig["pipelineBranch"].(string)
+	return branch != "" && pipelineBranch != "" && branch != pipelineBranch
+}
+
+// CheckBudget rejects builds off the main branch of pipelines and accounts over their monthly budget,
+// builds of the main branch keep running. The budgets reset with the month.
+func (p *Policy) CheckBudget(buildConfig map[string]interface{}, spend SpendReader, now time.Time) error {
+	if len(p.Budgets.Pipelines) == 0 && len(p.Budgets.Accounts) == 0 {
+		return nil
+	}
+	if !offMainBranch(buildConfig) {
+		return nil
+	}
+	var pipelineID, accountID string
+	if buildConfig["pipelineId"] != nil {
+		pipelineID = fmt.Sprint(buildConfig["pipelineId"])
+	}
+	if contains(p.Budgets.ExemptPipelines, pipelineID) {
+		return nil
+	}
+	if provider, _ := buildConfig["provider"].(map[string]interface{}); provider["accountId"] != nil {
+		accountID = fmt.Sprint(provider["accountId"])
+	}
+
+	month := now.UTC().Format("2006-01")
+	checks := []struct {
+		scope  string
+		id     string
+		limits map[string]float64
+		exempt []string
+	}{
+		{PipelineScope, pipelineID, p.Budgets.Pipelines, nil},
+		{AccountScope, accountID, p.Budgets.Accounts, p.Budgets.ExemptAccounts},
+	}
+	for _, c := range checks {
+		limit, ok := c.limits[c.id]
+		if !ok || c.id == "" || contains(c.exempt, c.id) {
+			continue
+		}
+		spent, err := spend.Spend(c.scope, c.id, month)
+		if err != nil {
+			return fmt.Errorf("Got error reading spend of %s %s: %v", c.scope, c.id, err)
+		}
+		if spent >= limit {
+			return &Violation{Reason: fmt.Sprintf(
+				"monthly budget of %s %s is exceeded, spent $%.2f of $%.2f in %s. Builds off the main branch are blocked until the budget resets next month or the %s is exempted",
+				c.scope, c.id, spent, limit, month, c.scope)}
+		}
+	}
+	return nil
+}
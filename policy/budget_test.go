@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spend by scope/id of the month 2022-03
+type fakeSpend map[string]float64
+
+func (f fakeSpend) Spend(scope string, id string, month string) (float64, error) {
+	if month != "2022-03" {
+		return 0, errors.New("unexpected month " + month)
+	}
+	if spend, ok := f[scope+"/"+id]; ok && spend < 0 {
+		return 0, errors.New("throttled")
+	}
+	return f[scope+"/"+id], nil
+}
+
+func TestCheckBudget(t *testing.T) {
+	now := time.Date(2022, 3, 15, 10, 0, 0, 0, time.UTC)
+	buildConfig := func(isPR bool) map[string]interface{} {
+		return map[string]interface{}{
+			"isPR":       isPR,
+			"pipelineId": json.Number("1898"),
+			"provider":   map[string]interface{}{"accountId": json.Number("111111111")},
+		}
+	}
+	branchConfig := func(branch string) map[string]interface{} {
+		c := buildConfig(false)
+		c["branch"] = branch
+		c["pipelineBranch"] = "main"
+		return c
+	}
+	p := &Policy{Budgets: Budgets{
+		Pipelines: map[string]float64{"1898": 500},
+		Accounts:  map[string]float64{"111111111": 2000},
+	}}
+
+	assert.Nil(t, (&Policy{}).CheckBudget(buildConfig(true), fakeSpend{"pipeline/1898": 900}, now))
+	assert.Nil(t, p.CheckBudget(buildConfig(true), fakeSpend{"pipeline/1898": 499.99}, now))
+	assert.Nil(t, p.CheckBudget(buildConfig(false), fakeSpend{"pipeline/1898": 900}, now))
+
+	err := p.CheckBudget(buildConfig(true), fakeSpend{"pipeline/1898": 512.3}, now)
+	assert.True(t, IsViolation(err))
+	assert.EqualError(t, err, "Rejected by policy: monthly budget of pipeline 1898 is exceeded, spent $512.30 of $500.00 in 2022-03. "+
+		"Builds off the main branch are blocked until the budget resets next month or the pipeline is exempted")
+	assert.EqualError(t, p.CheckBudget(buildConfig(true), fakeSpend{"account/111111111": 2000}, now), "Rejected by policy: monthly budget of account 111111111 is exceeded, spent $2000.00 of $2000.00 in 2022-03. "+
+		"Builds off the main branch are blocked until the budget resets next month or the account is exempted")
+	assert.EqualError(t, p.CheckBudget(buildConfig(true), fakeSpend{"pipeline/1898": -1}, now), "Got error reading spend of pipeline 1898: throttled")
+
+	// builds of other branches than the main branch of the pipeline are blocked too
+	assert.True(t, IsViolation(p.CheckBudget(branchConfig("feature"), fakeSpend{"pipeline/1898": 900}, now)))
+	assert.Nil(t, p.CheckBudget(branchConfig("main"), fakeSpend{"pipeline/1898": 900}, now))
+
+	// exempt accounts are only exempt from their account budget
+	p.Budgets.ExemptAccounts = []string{"111111111"}
+	assert.Nil(t, p.CheckBudget(buildConfig(true), fakeSpend{"account/111111111": 2000}, now))
+	assert.True(t, IsViolation(p.CheckBudget(buildConfig(true), fakeSpend{"pipeline/1898": 900}, now)))
+
+	p.Budgets.ExemptPipelines = []string{"1898"}
+	assert.Nil(t, p.CheckBudget(buildConfig(true), fakeSpend{"pipeline/1898": 900}, now))
+	assert.Nil(t, p.CheckBudget(branchConfig("feature"), fakeSpend{"pipeline/1898": 900, "account/111111111": 2000}, now))
+}
@@ -0,0 +1,32 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+// CheckBuildConfig validates the build region, provider role and images of a build config
+func (p *Policy) CheckBuildConfig(buildConfig map[string]interface{}, buildRegion string) error {
+	provider, _ := buildConfig["provider"].(map[string]interface{})
+	if err := p.CheckRegion(buildRegion, ProviderAccount(provider)); err != nil {
+		return err
+	}
+	role, _ := provider["role"].(string)
+	var accountID string
+	if provider["accountId"] != nil {
+		accountID = fmt.Sprint(provider["accountId"])
+	}
+	if err := p.CheckRole(role, accountID, awsconfig.Partition(buildRegion)); err != nil {
+		return err
+	}
+
+	container, _ := buildConfig["container"].(string)
+	launcherImage, _ := provider["launcherImage"].(string)
+	var pipelineID string
+	if buildConfig["pipelineId"] != nil {
+		pipelineID = fmt.Sprint(buildConfig["pipelineId"])
+	}
+
+	return p.CheckImages(container, launcherImage, pipelineID)
+}
@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBuildConfig(t *testing.T) {
+	buildConfig := map[string]interface{}{
+		"pipelineId": json.Number("1898"),
+		"container":  "node:12",
+		"provider": map[string]interface{}{
+			"accountId":     json.Number("111111111"),
+			"role":          "arn:aws:iam::111111111:role/sd-build",
+			"launcherImage": "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:launcherv6.0.147",
+		},
+	}
+
+	assert.Nil(t, (&Policy{}).CheckBuildConfig(buildConfig, "us-east-2"))
+	assert.EqualError(t, (&Policy{AllowedAccounts: []string{"222222222"}}).CheckBuildConfig(buildConfig, "us-east-2"),
+		"Rejected by policy: account 111111111 is not allowed")
+	assert.EqualError(t, (&Policy{}).CheckBuildConfig(buildConfig, "us-gov-west-1"),
+		`Rejected by policy: provider role "arn:aws:iam::111111111:role/sd-build" does not belong to partition aws-us-gov`)
+
+	assert.EqualError(t, (&Policy{DeniedRegions: []string{"us-east-2"}}).CheckBuildConfig(buildConfig, "us-east-2"),
+		"Rejected by policy: region us-east-2 is denied")
+	assert.EqualError(t, (&Policy{RegionAccounts: map[string][]string{"us-east-2": {"222222222"}}}).CheckBuildConfig(buildConfig, "us-east-2"),
+		"Rejected by policy: account 111111111 is not allowed in region us-east-2")
+
+	p := &Policy{AllowedRegistries: []string{"111111111.dkr.ecr.*.amazonaws.com/*"}}
+	assert.EqualError(t, p.CheckBuildConfig(buildConfig, "us-east-2"), `Rejected by policy: container image "node:12" is not from an allowed registry`)
+	p.ExternalImagePipelines = []string{"1898"}
+	assert.Nil(t, p.CheckBuildConfig(buildConfig, "us-east-2"))
+}
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EnvironmentKey is the build config key of the environment filtered by the policy.
+// It holds a map[string]string which can not be decoded from a message, so executors only forward filtered variables.
+const EnvironmentKey = "passthroughEnvironment"
+
+// deniedEnvironment are never forwarded, they configure the launcher, the aws sdk or the docker daemon of the build
+var deniedEnvironment = []string{
+	"AWS_*", "CODEBUILD_*", "DOCKER_*", "KUBERNETES_*", "SD_*",
+	"API", "STORE", "UI", "TOKEN", "TIMEOUT", "SDBUILDID", "CONTAINER_IMAGE", "NODE_ID",
+	"HOME", "PATH", "LD_PRELOAD", "LD_LIBRARY_PATH", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY",
+}
+
+// EnvVar is an environment variable forwarded to the build
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// FilterEnvironment returns the variables of the build config environment allowed by the policy and the sorted names of the dropped ones.
+// Denied patterns take precedence, an empty allowlist allows every variable which is not denied.
+func (p *Policy) FilterEnvironment(environment map[string]interface{}) (map[string]string, []string) {
+	allowed := map[string]string{}
+	var dropped []string
+	for name, value := range environment {
+		if name == "" || matchAny(deniedEnvironment, name) || matchAny(p.DeniedEnvironment, name) ||
+			(len(p.AllowedEnvironment) > 0 && !matchAny(p.AllowedEnvironment, name)) {
+			dropped = append(dropped, name)
+			continue
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			dropped = append(dropped, name)
+			continue
+		}
+		allowed[name] = fmt.Sprint(value)
+	}
+	sort.Strings(dropped)
+	return allowed, dropped
+}
+
+// Environment gets the variables of the build config filtered by the policy, sorted by name
+func Environment(buildConfig map[string]interface{}) []EnvVar {
+	environment, _ := buildConfig[EnvironmentKey].(map[string]string)
+	vars := make([]EnvVar, 0, len(environment))
+	for name, value := range environment {
+		vars = append(vars, EnvVar{Name: name, Value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
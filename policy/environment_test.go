@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterEnvironment(t *testing.T) {
+	environment := map[string]interface{}{
+		"NODE_ENV":              "production",
+		"RETRIES":               json.Number("3"),
+		"DEBUG":                 true,
+		"AWS_ACCESS_KEY_ID":     "AKIA",
+		"DOCKER_HOST":           "tcp://evil:2375",
+		"SD_BUILD_ID":           "1",
+		"TOKEN":                 "stolen",
+		"LD_PRELOAD":            "/tmp/evil.so",
+		"NPM_CONFIG":            map[string]interface{}{"registry": "x"},
+		"INTERNAL_DEPLOY_TOKEN": "secret",
+	}
+
+	allowed, dropped := (&Policy{}).FilterEnvironment(environment)
+	assert.Equal(t, map[string]string{"NODE_ENV": "production", "RETRIES": "3", "DEBUG": "true", "INTERNAL_DEPLOY_TOKEN": "secret"}, allowed)
+	assert.Equal(t, []string{"AWS_ACCESS_KEY_ID", "DOCKER_HOST", "LD_PRELOAD", "NPM_CONFIG", "SD_BUILD_ID", "TOKEN"}, dropped)
+
+	p := &Policy{AllowedEnvironment: []string{"NODE_*", "DEBUG", "AWS_REGION"}, DeniedEnvironment: []string{"*_TOKEN"}}
+	allowed, dropped = p.FilterEnvironment(environment)
+	assert.Equal(t, map[string]string{"NODE_ENV": "production", "DEBUG": "true"}, allowed)
+	assert.Equal(t, []string{"AWS_ACCESS_KEY_ID", "DOCKER_HOST", "INTERNAL_DEPLOY_TOKEN", "LD_PRELOAD", "NPM_CONFIG", "RETRIES", "SD_BUILD_ID", "TOKEN"}, dropped)
+
+	allowed, dropped = p.FilterEnvironment(nil)
+	assert.Empty(t, allowed)
+	assert.Nil(t, dropped)
+}
+
+func TestEnvironment(t *testing.T) {
+	buildConfig := map[string]interface{}{EnvironmentKey: map[string]string{"B": "2", "A": "1"}}
+	assert.Equal(t, []EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}, Environment(buildConfig))
+
+	// only the filtered environment is forwarded, a decoded message can not set it
+	buildConfig = map[string]interface{}{EnvironmentKey: map[string]interface{}{"AWS_ACCESS_KEY_ID": "AKIA"}}
+	assert.Empty(t, Environment(buildConfig))
+}
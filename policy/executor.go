@@ -0,0 +1,13 @@
+package policy
+
+import "fmt"
+
+// ExecutorOverride gets the executor the deployment forces on the pipeline of the build config, taking precedence
+// over the executor type of the message
+func (p *Policy) ExecutorOverride(buildConfig map[string]interface{}) (string, bool) {
+	if len(p.ExecutorOverrides) == 0 || buildConfig["pipelineId"] == nil {
+		return "", false
+	}
+	executorType, ok := p.ExecutorOverrides[fmt.Sprint(buildConfig["pipelineId"])]
+	return executorType, ok && executorType != ""
+}
@@ -0,0 +1,26 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutorOverride(t *testing.T) {
+	buildConfig := map[string]interface{}{"pipelineId": json.Number("1898")}
+	_, ok := (&Policy{}).ExecutorOverride(buildConfig)
+	assert.False(t, ok)
+
+	p := &Policy{ExecutorOverrides: map[string]string{"1898": "eks", "42": ""}}
+	executorType, ok := p.ExecutorOverride(buildConfig)
+	assert.True(t, ok)
+	assert.Equal(t, "eks", executorType)
+
+	_, ok = p.ExecutorOverride(map[string]interface{}{"pipelineId": json.Number("42")})
+	assert.False(t, ok)
+	_, ok = p.ExecutorOverride(map[string]interface{}{"pipelineId": json.Number("7")})
+	assert.False(t, ok)
+	_, ok = p.ExecutorOverride(map[string]interface{}{})
+	assert.False(t, ok)
+}
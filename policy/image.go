@@ -0,0 +1,38 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/screwdriver-cd/aws-consumer-service/image"
+)
+
+// CheckImages validates the build container and launcher images against the allowed registries.
+// Pipelines listed in ExternalImagePipelines may use any build container image.
+func (p *Policy) CheckImages(container string, launcherImage string, pipelineID string) error {
+	if len(p.AllowedRegistries) == 0 {
+		return nil
+	}
+
+	if launcherImage != "" && !matchAny(p.AllowedRegistries, image.Repository(launcherImage)) {
+		return &Violation{Reason: fmt.Sprintf("launcher image %q is not from an allowed registry", launcherImage)}
+	}
+
+	if pipelineID != "" && contains(p.ExternalImagePipelines, pipelineID) {
+		return nil
+	}
+	if !matchAny(p.AllowedRegistries, image.Repository(container)) {
+		return &Violation{Reason: fmt.Sprintf("container image %q is not from an allowed registry", container)}
+	}
+
+	return nil
+}
+
+// checks if list contains value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
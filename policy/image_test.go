@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckImages(t *testing.T) {
+	restricted := &Policy{
+		AllowedRegistries:      []string{"111111111.dkr.ecr.*.amazonaws.com/*", "aws/codebuild/*"},
+		ExternalImagePipelines: []string{"1898"},
+	}
+	ecrImage := "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12"
+	launcher := "111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:launcherv6.0.147"
+
+	tests := []struct {
+		message    string
+		policy     *Policy
+		container  string
+		launcher   string
+		pipelineID string
+		err        error
+	}{
+		{message: "empty policy allows any image", policy: &Policy{}, container: "node:12", launcher: "screwdrivercd/launcher:v6"},
+		{message: "allowed ecr images", policy: restricted, container: ecrImage, launcher: launcher, pipelineID: "1"},
+		{message: "allowed codebuild image", policy: restricted, container: "aws/codebuild/standard:5.0", launcher: launcher},
+		{message: "external container", policy: restricted, container: "node:12", launcher: launcher, pipelineID: "1", err: &Violation{Reason: `container image "node:12" is not from an allowed registry`}},
+		{message: "external container for permitted pipeline", policy: restricted, container: "node:12", launcher: launcher, pipelineID: "1898"},
+		{message: "external launcher for permitted pipeline", policy: restricted, container: "node:12", launcher: "screwdrivercd/launcher:v6", pipelineID: "1898", err: &Violation{Reason: `launcher image "screwdrivercd/launcher:v6" is not from an allowed registry`}},
+	}
+	for _, test := range tests {
+		err := test.policy.CheckImages(test.container, test.launcher, test.pipelineID)
+		assert.Equal(t, test.err, err, test.message)
+	}
+}
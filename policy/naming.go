@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// build config keys of the names resolved by the naming of the policy
+const (
+	// ProjectNameKey is the name of the codebuild project of the build
+	ProjectNameKey = "projectName"
+	// PodNamePrefixKey starts the names of the pods of the build, empty for the buildId-random pod names
+	PodNamePrefixKey = "podNamePrefix"
+)
+
+const (
+	// codebuild project names are limited to 255 characters
+	maxProjectName = 255
+	// prefixes leave room for the pipeline id, build id and random suffix of pod names
+	maxNamePrefix    = 32
+	hashSuffixLength = 8
+)
+
+var (
+	namePrefixPattern   = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+	invalidProjectChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// Naming configures the names of the codebuild projects and pods of builds.
+// Without any setting projects are named jobName-jobId and pods buildId-random like before.
+type Naming struct {
+	// Prefix starts every name, like the name of the Screwdriver deployment sharing the account
+	Prefix string `json:"prefix"`
+	// IncludePipelineID adds the pipeline id after the prefix
+	IncludePipelineID bool `json:"includePipelineId"`
+	// HideJobName leaves the job name out of project names so it is not shown in the aws console
+	HideJobName bool `json:"hideJobName"`
+	// HashSuffix ends project names with a hash of the pipeline id and job name,
+	// keeping names unique once invalid characters are replaced or long job names truncated
+	HashSuffix bool `json:"hashSuffix"`
+}
+
+// Validate checks the prefix can start codebuild project and pod names
+func (n Naming) Validate() error {
+	if n.Prefix == "" {
+		return nil
+	}
+	if len(n.Prefix) > maxNamePrefix || !namePrefixPattern.MatchString(n.Prefix) {
+		return fmt.Errorf("naming prefix %q must be up to %d lowercase letters, digits or dashes", n.Prefix, maxNamePrefix)
+	}
+	return nil
+}
+
+// ProjectName gets the name of the codebuild project of a job
+func (n Naming) ProjectName(jobName string, isPR bool, pipelineID int64, jobID int64) string {
+	if isPR {
+		jobName = strings.Replace(jobName, ":", "-", 1)
+	}
+	if n == (Naming{}) {
+		return jobName + "-" + fmt.Sprint(jobID)
+	}
+
+	var head []string
+	if n.Prefix != "" {
+		head = append(head, n.Prefix)
+	}
+	if n.IncludePipelineID {
+		head = append(head, fmt.Sprint(pipelineID))
+	}
+	tail := []string{fmt.Sprint(jobID)}
+	if n.HashSuffix {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%d/%s", pipelineID, jobName)))
+		tail = append(tail, hex.EncodeToString(sum[:])[:hashSuffixLength])
+	}
+	if !n.HideJobName {
+		name := strings.Trim(invalidProjectChars.ReplaceAllString(jobName, "-"), "-_")
+		fixed := len(strings.Join(append(head, tail...), "-")) + 1
+		if len(name) > maxProjectName-fixed {
+			name = name[:maxProjectName-fixed]
+		}
+		if name != "" {
+			head = append(head, name)
+		}
+	}
+	return strings.Join(append(head, tail...), "-")
+}
+
+// PodNamePrefix gets the start of the pod names of a pipeline, empty without a prefix or pipeline id
+func (n Naming) PodNamePrefix(pipelineID int64) string {
+	var parts []string
+	if n.Prefix != "" {
+		parts = append(parts, n.Prefix)
+	}
+	if n.IncludePipelineID {
+		parts = append(parts, fmt.Sprint(pipelineID))
+	}
+	return strings.Join(parts, "-")
+}
+
+// ApplyNaming sets the codebuild project name and pod name prefix of the build config
+func (p *Policy) ApplyNaming(buildConfig map[string]interface{}) error {
+	if err := p.Naming.Validate(); err != nil {
+		return &Violation{Reason: err.Error()}
+	}
+	jobName, _ := buildConfig["jobName"].(string)
+	isPR, _ := buildConfig["isPR"].(bool)
+	pipelineNumber, _ := buildConfig["pipelineId"].(json.Number)
+	jobNumber, _ := buildConfig["jobId"].(json.Number)
+	pipelineID, _ := pipelineNumber.Int64()
+	jobID, _ := jobNumber.Int64()
+
+	buildConfig[ProjectNameKey] = p.Naming.ProjectName(jobName, isPR, pipelineID, jobID)
+	buildConfig[PodNamePrefixKey] = p.Naming.PodNamePrefix(pipelineID)
+	return nil
+}
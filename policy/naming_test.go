@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectName(t *testing.T) {
+	assert.Equal(t, "main-123", Naming{}.ProjectName("main", false, 42, 123))
+	assert.Equal(t, "PR-1-main-124", Naming{}.ProjectName("PR-1:main", true, 42, 124))
+
+	assert.Equal(t, "sd-prod-42-main-123", Naming{Prefix: "sd-prod", IncludePipelineID: true}.ProjectName("main", false, 42, 123))
+	assert.Equal(t, "sd-prod-123", Naming{Prefix: "sd-prod", HideJobName: true}.ProjectName("main", false, 42, 123))
+	assert.Equal(t, "sd-prod-123-b2b1c49f", Naming{Prefix: "sd-prod", HideJobName: true, HashSuffix: true}.ProjectName("main", false, 42, 123))
+	assert.Equal(t, "sd-publish-npm-125", Naming{Prefix: "sd"}.ProjectName("publish@npm", false, 42, 125))
+
+	long := Naming{Prefix: "sd", HashSuffix: true}.ProjectName(strings.Repeat("a", 300), false, 42, 123)
+	assert.Len(t, long, maxProjectName)
+	assert.True(t, strings.HasPrefix(long, "sd-aaa"))
+	assert.True(t, strings.HasSuffix(long, "-123-"+long[len(long)-hashSuffixLength:]))
+}
+
+func TestProjectNameCollisions(t *testing.T) {
+	// deployments sharing an account reuse job ids
+	assert.NotEqual(t,
+		Naming{Prefix: "sd-prod"}.ProjectName("main", false, 42, 123),
+		Naming{Prefix: "sd-beta"}.ProjectName("main", false, 42, 123))
+	assert.NotEqual(t,
+		Naming{IncludePipelineID: true}.ProjectName("main", false, 42, 123),
+		Naming{IncludePipelineID: true}.ProjectName("main", false, 43, 123))
+	// replaced characters and truncated names only differ by their hash
+	withHash := Naming{Prefix: "sd", HashSuffix: true}
+	assert.Equal(t, Naming{Prefix: "sd"}.ProjectName("a.b", false, 42, 123), Naming{Prefix: "sd"}.ProjectName("a@b", false, 42, 123))
+	assert.NotEqual(t, withHash.ProjectName("a.b", false, 42, 123), withHash.ProjectName("a@b", false, 42, 123))
+	assert.NotEqual(t,
+		withHash.ProjectName(strings.Repeat("a", 300)+"-x", false, 42, 123),
+		withHash.ProjectName(strings.Repeat("a", 300)+"-y", false, 42, 123))
+	// the hidden job name is replaced by the hash of the pipeline and job name
+	hidden := Naming{HideJobName: true, HashSuffix: true}
+	assert.NotEqual(t, hidden.ProjectName("main", false, 42, 123), hidden.ProjectName("main", false, 43, 123))
+	assert.NotContains(t, hidden.ProjectName("main", false, 42, 123), "main")
+}
+
+func TestPodNamePrefix(t *testing.T) {
+	assert.Equal(t, "", Naming{}.PodNamePrefix(42))
+	assert.Equal(t, "", Naming{HashSuffix: true}.PodNamePrefix(42))
+	assert.Equal(t, "sd-prod", Naming{Prefix: "sd-prod"}.PodNamePrefix(42))
+	assert.Equal(t, "sd-prod-42", Naming{Prefix: "sd-prod", IncludePipelineID: true}.PodNamePrefix(42))
+	assert.Equal(t, "42", Naming{IncludePipelineID: true}.PodNamePrefix(42))
+}
+
+func TestApplyNaming(t *testing.T) {
+	buildConfig := map[string]interface{}{"jobName": "PR-3:main", "isPR": true, "pipelineId": json.Number("42"), "jobId": json.Number("123")}
+	assert.Nil(t, (&Policy{}).ApplyNaming(buildConfig))
+	assert.Equal(t, "PR-3-main-123", buildConfig[ProjectNameKey])
+	assert.Equal(t, "", buildConfig[PodNamePrefixKey])
+
+	p := &Policy{Naming: Naming{Prefix: "sd-prod", IncludePipelineID: true}}
+	assert.Nil(t, p.ApplyNaming(buildConfig))
+	assert.Equal(t, "sd-prod-42-PR-3-main-123", buildConfig[ProjectNameKey])
+	assert.Equal(t, "sd-prod-42", buildConfig[PodNamePrefixKey])
+
+	for _, prefix := range []string{"SD", "sd_prod", "sd-", "-sd", strings.Repeat("s", 33)} {
+		err := (&Policy{Naming: Naming{Prefix: prefix}}).ApplyNaming(buildConfig)
+		assert.True(t, IsViolation(err), prefix)
+	}
+	assert.EqualError(t, (&Policy{Naming: Naming{Prefix: "sd_prod"}}).ApplyNaming(buildConfig),
+		`Rejected by policy: naming prefix "sd_prod" must be up to 32 lowercase letters, digits or dashes`)
+}
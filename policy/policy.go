@@ -0,0 +1,164 @@
+// Package policy enforces deployment level rules on build messages before any AWS resource is touched
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	// inline json policy
+	policyEnv = "SD_POLICY"
+	// name of the ssm parameter holding the json policy
+	policyParameterEnv = "SD_POLICY_SSM_PARAMETER"
+	// how long a loaded policy is reused
+	cacheTTL = 5 * time.Minute
+)
+
+// Policy defines the rules build messages are validated against, empty rules allow everything
+type Policy struct {
+	AllowedAccounts        []string `json:"allowedAccounts"`
+	AllowedRolePatterns    []string `json:"allowedRolePatterns"`
+	AllowedRegistries      []string `json:"allowedRegistries"`
+	ExternalImagePipelines []string `json:"externalImagePipelines"`
+	BlockedSeverities      []string `json:"blockedSeverities"`
+	IgnoredVulnerabilities []string `json:"ignoredVulnerabilities"`
+	RequireImageScan       bool     `json:"requireImageScan"`
+	AllowedEnvironment     []string `json:"allowedEnvironment"`
+	DeniedEnvironment      []string `json:"deniedEnvironment"`
+	Budgets                Budgets  `json:"budgets"`
+	Naming                 Naming   `json:"naming"`
+	AllowedRegions         []string `json:"allowedRegions"`
+	DeniedRegions          []string `json:"deniedRegions"`
+	// RegionAccounts are the accounts allowed in the regions matching a pattern
+	RegionAccounts map[string][]string `json:"regionAccounts"`
+	// ExecutorOverrides force the executor of pipelines by pipeline id
+	ExecutorOverrides map[string]string `json:"executorOverrides"`
+}
+
+// Violation is returned when a build message is rejected by the policy
+type Violation struct {
+	Reason string
+}
+
+// Error fn to format violation
+func (v *Violation) Error() string {
+	return fmt.Sprintf("Rejected by policy: %s", v.Reason)
+}
+
+// IsViolation reports whether err is a policy violation
+func IsViolation(err error) bool {
+	var violation *Violation
+	return errors.As(err, &violation)
+}
+
+// Loader loads and caches the policy of the deployment
+type Loader struct {
+	ssm      ssmiface.SSMAPI
+	mu       sync.Mutex
+	cached   *Policy
+	loadedAt time.Time
+	now      func() time.Time
+}
+
+var defaultLoader = &Loader{now: time.Now}
+
+// Load returns the deployment policy using the default loader
+func Load() (*Policy, error) {
+	return defaultLoader.Load()
+}
+
+// Load returns the policy from SD_POLICY or the ssm parameter named by SD_POLICY_SSM_PARAMETER
+func (l *Loader) Load() (*Policy, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cached != nil && l.now().Sub(l.loadedAt) < cacheTTL {
+		return l.cached, nil
+	}
+
+	document := strings.TrimSpace(os.Getenv(policyEnv))
+	if parameter := strings.TrimSpace(os.Getenv(policyParameterEnv)); document == "" && parameter != "" {
+		value, err := l.getParameter(parameter)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading policy from %s: %v", parameter, err)
+		}
+		document = value
+	}
+
+	p := &Policy{}
+	if document != "" {
+		if err := json.Unmarshal([]byte(document), p); err != nil {
+			return nil, fmt.Errorf("Error parsing policy: %v", err)
+		}
+	}
+	l.cached = p
+	l.loadedAt = l.now()
+
+	return p, nil
+}
+
+// gets a decrypted ssm parameter value
+func (l *Loader) getParameter(name string) (string, error) {
+	if l.ssm == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return "", err
+		}
+		l.ssm = ssm.New(sess)
+	}
+	output, err := l.ssm.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+// compiled expressions by pattern, the patterns come from the policy so each is compiled once
+var (
+	compiledPatternsMu sync.Mutex
+	compiledPatterns   = map[string]*regexp.Regexp{}
+)
+
+// gets the compiled expression of a pattern where * matches any characters
+func compilePattern(pattern string) *regexp.Regexp {
+	compiledPatternsMu.Lock()
+	defer compiledPatternsMu.Unlock()
+	if expr, ok := compiledPatterns[pattern]; ok {
+		return expr
+	}
+	// quoted patterns always compile
+	expr := regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+	compiledPatterns[pattern] = expr
+	return expr
+}
+
+// matches value against a pattern where * matches any characters
+func matchPattern(pattern string, value string) bool {
+	return compilePattern(pattern).MatchString(value)
+}
+
+// checks if value matches any of the patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
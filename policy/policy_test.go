@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	mock.Mock
+}
+
+func (m *mockSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ssm.GetParameterOutput), args.Error(1)
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	t.Setenv(policyEnv, `{"allowedAccounts":["111111111"]}`)
+	loader := &Loader{now: time.Now}
+
+	got, err := loader.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"111111111"}, got.AllowedAccounts)
+}
+
+func TestLoadFromSSM(t *testing.T) {
+	t.Setenv(policyEnv, "")
+	t.Setenv(policyParameterEnv, "/sd/policy")
+	input := &ssm.GetParameterInput{Name: aws.String("/sd/policy"), WithDecryption: aws.Bool(true)}
+	mockClient := new(mockSSM)
+	mockClient.On("GetParameter", input).Return(&ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Value: aws.String(`{"allowedRolePatterns":["arn:aws:iam::*:role/sd-*"]}`)},
+	}, nil).Once()
+
+	now := time.Now()
+	loader := &Loader{ssm: mockClient, now: func() time.Time { return now }}
+	got, err := loader.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"arn:aws:iam::*:role/sd-*"}, got.AllowedRolePatterns)
+
+	// cached within ttl
+	cached, err := loader.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, got, cached)
+	mockClient.AssertNumberOfCalls(t, "GetParameter", 1)
+
+	// reloaded after ttl
+	errClient := new(mockSSM)
+	errClient.On("GetParameter", input).Return(&ssm.GetParameterOutput{}, errors.New("AccessDenied"))
+	loader.ssm = errClient
+	now = now.Add(cacheTTL)
+	_, err = loader.Load()
+	assert.Equal(t, errors.New("Error loading policy from /sd/policy: AccessDenied"), err)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	t.Setenv(policyEnv, `{"allowedAccounts":`)
+	loader := &Loader{now: time.Now}
+
+	_, err := loader.Load()
+	assert.NotNil(t, err)
+}
+
+func TestMatchPattern(t *testing.T) {
+	assert.True(t, matchPattern("arn:aws:iam::*:role/sd-*", "arn:aws:iam::123:role/sd-builds/deploy"))
+	assert.True(t, matchPattern("123", "123"))
+	assert.False(t, matchPattern("123", "1234"))
+	assert.False(t, matchPattern("arn:aws:iam::123:role/sd.*", "arn:aws:iam::123:role/sdx"))
+
+	// patterns are compiled once
+	assert.Same(t, compilePattern("arn:aws:iam::*:role/sd-*"), compilePattern("arn:aws:iam::*:role/sd-*"))
+}
@@ -0,0 +1,54 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// CheckRegion validates the build region against the allowed and denied regions, and the account of the provider
+// against the accounts allowed in the region. Denied regions take precedence, empty lists allow every region and account.
+func (p *Policy) CheckRegion(region string, accountID string) error {
+	if matchAny(p.DeniedRegions, region) {
+		return &Violation{Reason: fmt.Sprintf("region %s is denied", region)}
+	}
+	if len(p.AllowedRegions) > 0 && !matchAny(p.AllowedRegions, region) {
+		return &Violation{Reason: fmt.Sprintf("region %s is not allowed", region)}
+	}
+	// the most specific pattern of the region applies, like us-east-1 over us-*
+	var patterns []string
+	for pattern := range p.RegionAccounts {
+		if matchPattern(pattern, region) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	if accountID == "" {
+		return &Violation{Reason: fmt.Sprintf("provider account is required in region %s", region)}
+	}
+	if !matchAny(p.RegionAccounts[patterns[0]], accountID) {
+		return &Violation{Reason: fmt.Sprintf("account %s is not allowed in region %s", accountID, region)}
+	}
+	return nil
+}
+
+// ProviderAccount gets the account of the provider, from its role when the account id is not set
+func ProviderAccount(provider map[string]interface{}) string {
+	if provider["accountId"] != nil {
+		return fmt.Sprint(provider["accountId"])
+	}
+	role, _ := provider["role"].(string)
+	if roleArn, err := arn.Parse(role); err == nil {
+		return roleArn.AccountID
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRegion(t *testing.T) {
+	assert.Nil(t, (&Policy{}).CheckRegion("ap-east-1", "111111111"))
+
+	p := &Policy{AllowedRegions: []string{"us-*", "eu-west-1"}, DeniedRegions: []string{"us-gov-*"}}
+	assert.Nil(t, p.CheckRegion("us-west-2", "111111111"))
+	assert.Nil(t, p.CheckRegion("eu-west-1", "111111111"))
+	assert.EqualError(t, p.CheckRegion("ap-east-1", "111111111"), "Rejected by policy: region ap-east-1 is not allowed")
+	assert.EqualError(t, p.CheckRegion("us-gov-west-1", "111111111"), "Rejected by policy: region us-gov-west-1 is denied")
+
+	p = &Policy{DeniedRegions: []string{"me-*"}, RegionAccounts: map[string][]string{
+		"us-*":      {"111111111", "222222222"},
+		"us-east-1": {"333333333"},
+	}}
+	assert.Nil(t, p.CheckRegion("us-west-2", "222222222"))
+	assert.Nil(t, p.CheckRegion("us-east-1", "333333333"))
+	assert.Nil(t, p.CheckRegion("eu-west-1", "444444444"))
+	assert.EqualError(t, p.CheckRegion("us-east-1", "111111111"), "Rejected by policy: account 111111111 is not allowed in region us-east-1")
+	assert.EqualError(t, p.CheckRegion("us-west-2", "333333333"), "Rejected by policy: account 333333333 is not allowed in region us-west-2")
+	assert.EqualError(t, p.CheckRegion("us-west-2", ""), "Rejected by policy: provider account is required in region us-west-2")
+	assert.EqualError(t, p.CheckRegion("me-south-1", "111111111"), "Rejected by policy: region me-south-1 is denied")
+}
+
+func TestProviderAccount(t *testing.T) {
+	assert.Equal(t, "111111111", ProviderAccount(map[string]interface{}{"accountId": json.Number("111111111"), "role": "arn:aws:iam::222222222:role/sd-build"}))
+	assert.Equal(t, "222222222", ProviderAccount(map[string]interface{}{"role": "arn:aws:iam::222222222:role/sd-build"}))
+	assert.Equal(t, "", ProviderAccount(map[string]interface{}{}))
+}
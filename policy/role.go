@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// CheckRole validates the provider role arn against the allowed accounts and role patterns.
+// When accountID or partition is not empty the role must belong to that account or partition.
+func (p *Policy) CheckRole(role string, accountID string, partition string) error {
+	if role == "" {
+		if len(p.AllowedAccounts) > 0 || len(p.AllowedRolePatterns) > 0 {
+			return &Violation{Reason: "provider role is required"}
+		}
+		return nil
+	}
+
+	roleArn, err := arn.Parse(role)
+	if err != nil || roleArn.Service != "iam" || !strings.HasPrefix(roleArn.Resource, "role/") {
+		return &Violation{Reason: fmt.Sprintf("provider role %q is not a valid IAM role arn", role)}
+	}
+
+	if partition != "" && roleArn.Partition != partition {
+		return &Violation{Reason: fmt.Sprintf("provider role %q does not belong to partition %s", role, partition)}
+	}
+
+	if accountID != "" && roleArn.AccountID != accountID {
+		return &Violation{Reason: fmt.Sprintf("provider role %q does not belong to account %s", role, accountID)}
+	}
+
+	if len(p.AllowedAccounts) > 0 && !matchAny(p.AllowedAccounts, roleArn.AccountID) {
+		return &Violation{Reason: fmt.Sprintf("account %s is not allowed", roleArn.AccountID)}
+	}
+
+	if len(p.AllowedRolePatterns) > 0 && !matchAny(p.AllowedRolePatterns, role) {
+		return &Violation{Reason: fmt.Sprintf("provider role %q is not allowed", role)}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRole(t *testing.T) {
+	role := "arn:aws:iam::111111111:role/cd.screwdriver.consumer.integration-sdbuild"
+	restricted := &Policy{
+		AllowedAccounts:     []string{"111111111", "22222222*"},
+		AllowedRolePatterns: []string{"arn:aws:iam::*:role/cd.screwdriver.*"},
+	}
+
+	tests := []struct {
+		message   string
+		policy    *Policy
+		role      string
+		accountID string
+		partition string
+		err       error
+	}{
+		{message: "empty policy allows any role", policy: &Policy{}, role: "arn:aws:iam::999:role/admin"},
+		{message: "empty policy allows missing role", policy: &Policy{}, role: ""},
+		{message: "allowed role", policy: restricted, role: role, accountID: "111111111"},
+		{message: "missing role", policy: restricted, role: "", err: &Violation{Reason: "provider role is required"}},
+		{message: "not an arn", policy: &Policy{}, role: "admin", err: &Violation{Reason: `provider role "admin" is not a valid IAM role arn`}},
+		{message: "not a role", policy: &Policy{}, role: "arn:aws:iam::111111111:user/admin", err: &Violation{Reason: `provider role "arn:aws:iam::111111111:user/admin" is not a valid IAM role arn`}},
+		{message: "account mismatch", policy: &Policy{}, role: role, accountID: "333", err: &Violation{Reason: `provider role "` + role + `" does not belong to account 333`}},
+		{message: "gov partition", policy: &Policy{}, role: "arn:aws-us-gov:iam::111111111:role/build", partition: "aws-us-gov"},
+		{message: "partition mismatch", policy: &Policy{}, role: role, partition: "aws-cn", err: &Violation{Reason: `provider role "` + role + `" does not belong to partition aws-cn`}},
+		{message: "account not allowed", policy: restricted, role: "arn:aws:iam::333:role/cd.screwdriver.build", err: &Violation{Reason: "account 333 is not allowed"}},
+		{message: "role not allowed", policy: restricted, role: "arn:aws:iam::222222223:role/admin", err: &Violation{Reason: `provider role "arn:aws:iam::222222223:role/admin" is not allowed`}},
+	}
+	for _, test := range tests {
+		err := test.policy.CheckRole(test.role, test.accountID, test.partition)
+		assert.Equal(t, test.err, err, test.message)
+		if test.err != nil {
+			assert.True(t, IsViolation(err), test.message)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/image"
+)
+
+// maximum number of vulnerabilities listed in a violation
+const maxListedVulnerabilities = 5
+
+// Finding is a vulnerability reported by an image scan
+type Finding struct {
+	ID       string
+	Severity string
+}
+
+// Scanner gets the vulnerability findings of an image
+type Scanner interface {
+	Findings(image string) ([]Finding, error)
+}
+
+// ECRScanner gets the findings of basic and enhanced ECR image scans
+type ECRScanner struct {
+	mu        sync.Mutex
+	clients   map[string]ecriface.ECRAPI
+	newClient func(region string) (ecriface.ECRAPI, error)
+}
+
+// NewECRScanner returns a scanner creating an ECR client per registry region
+func NewECRScanner() *ECRScanner {
+	return &ECRScanner{
+		clients: map[string]ecriface.ECRAPI{},
+		newClient: func(region string) (ecriface.ECRAPI, error) {
+			sess, err := awsconfig.NewSession(region)
+			if err != nil {
+				return nil, err
+			}
+			return ecr.New(sess), nil
+		},
+	}
+}
+
+// gets the cached ECR client of the region
+func (s *ECRScanner) client(region string) (ecriface.ECRAPI, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.clients[region]; ok {
+		return c, nil
+	}
+	c, err := s.newClient(region)
+	if err != nil {
+		return nil, err
+	}
+	s.clients[region] = c
+	return c, nil
+}
+
+// Findings returns the findings of the latest completed scan of an ECR image
+func (s *ECRScanner) Findings(ref string) ([]Finding, error) {
+	ecrImage, ok := image.ParseECR(ref)
+	if !ok {
+		return nil, fmt.Errorf("image is not hosted on ECR")
+	}
+	c, err := s.client(ecrImage.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     aws.String(ecrImage.RegistryID),
+		RepositoryName: aws.String(ecrImage.Repository),
+		ImageId:        &ecr.ImageIdentifier{},
+	}
+	if ecrImage.Digest != "" {
+		input.ImageId.ImageDigest = aws.String(ecrImage.Digest)
+	} else {
+		input.ImageId.ImageTag = aws.String(ecrImage.Tag)
+	}
+
+	var findings []Finding
+	var status string
+	err = c.DescribeImageScanFindingsPages(input, func(page *ecr.DescribeImageScanFindingsOutput, lastPage bool) bool {
+		if page.ImageScanStatus != nil {
+			status = aws.StringValue(page.ImageScanStatus.Status)
+		}
+		if page.ImageScanFindings == nil {
+			return true
+		}
+		for _, f := range page.ImageScanFindings.Findings {
+			findings = append(findings, Finding{ID: aws.StringValue(f.Name), Severity: aws.StringValue(f.Severity)})
+		}
+		for _, f := range page.ImageScanFindings.EnhancedFindings {
+			finding := Finding{ID: aws.StringValue(f.Title), Severity: aws.StringValue(f.Severity)}
+			if f.PackageVulnerabilityDetails != nil && f.PackageVulnerabilityDetails.VulnerabilityId != nil {
+				finding.ID = aws.StringValue(f.PackageVulnerabilityDetails.VulnerabilityId)
+			}
+			findings = append(findings, finding)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != ecr.ScanStatusComplete && status != ecr.ScanStatusActive {
+		return nil, fmt.Errorf("image scan status is %s", status)
+	}
+
+	return findings, nil
+}
+
+// CheckVulnerabilities rejects container images with scan findings of a blocked severity.
+// Images without scan results are allowed unless RequireImageScan is set.
+func (p *Policy) CheckVulnerabilities(scanner Scanner, container string) error {
+	if len(p.BlockedSeverities) == 0 || container == "" {
+		return nil
+	}
+
+	findings, err := scanner.Findings(container)
+	if err != nil {
+		if p.RequireImageScan {
+			return &Violation{Reason: fmt.Sprintf("container image %q has no scan results: %v", container, err)}
+		}
+		log.Printf("Skipping vulnerability check of %v: %v", container, err)
+		return nil
+	}
+
+	blocked := map[string]string{}
+	for _, f := range findings {
+		if !containsFold(p.BlockedSeverities, f.Severity) || contains(p.IgnoredVulnerabilities, f.ID) {
+			continue
+		}
+		blocked[f.ID] = strings.ToUpper(f.Severity)
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(blocked))
+	for id := range blocked {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	listed := make([]string, 0, maxListedVulnerabilities)
+	for _, id := range ids {
+		if len(listed) == maxListedVulnerabilities {
+			break
+		}
+		listed = append(listed, fmt.Sprintf("%s (%s)", id, blocked[id]))
+	}
+	reason := fmt.Sprintf("container image %q has blocked vulnerabilities: %s", container, strings.Join(listed, ", "))
+	if more := len(ids) - len(listed); more > 0 {
+		reason += fmt.Sprintf(" and %d more", more)
+	}
+
+	return &Violation{Reason: reason}
+}
+
+// checks if list contains value ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
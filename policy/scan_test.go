@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockECR struct {
+	ecriface.ECRAPI
+	mock.Mock
+}
+
+func (m *mockECR) DescribeImageScanFindingsPages(input *ecr.DescribeImageScanFindingsInput, fn func(*ecr.DescribeImageScanFindingsOutput, bool) bool) error {
+	args := m.Called(input)
+	pages := args.Get(0).([]*ecr.DescribeImageScanFindingsOutput)
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return args.Error(1)
+}
+
+type mockScanner struct {
+	findings []Finding
+	err      error
+}
+
+func (m *mockScanner) Findings(image string) ([]Finding, error) {
+	return m.findings, m.err
+}
+
+func newTestScanner(c ecriface.ECRAPI) *ECRScanner {
+	return &ECRScanner{
+		clients:   map[string]ecriface.ECRAPI{},
+		newClient: func(region string) (ecriface.ECRAPI, error) { return c, nil },
+	}
+}
+
+func TestECRScannerFindings(t *testing.T) {
+	mockClient := new(mockECR)
+	mockClient.On("DescribeImageScanFindingsPages", &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     aws.String("111111111111"),
+		RepositoryName: aws.String("screwdriver-hub"),
+		ImageId:        &ecr.ImageIdentifier{ImageTag: aws.String("node12")},
+	}).Return([]*ecr.DescribeImageScanFindingsOutput{
+		{
+			ImageScanStatus: &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusComplete)},
+			ImageScanFindings: &ecr.ImageScanFindings{Findings: []*ecr.ImageScanFinding{
+				{Name: aws.String("CVE-2021-44228"), Severity: aws.String("CRITICAL")},
+			}},
+		},
+		{
+			ImageScanStatus: &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusComplete)},
+			ImageScanFindings: &ecr.ImageScanFindings{EnhancedFindings: []*ecr.EnhancedImageScanFinding{
+				{
+					Title:                       aws.String("CVE-2022-0778 - openssl"),
+					Severity:                    aws.String("HIGH"),
+					PackageVulnerabilityDetails: &ecr.PackageVulnerabilityDetails{VulnerabilityId: aws.String("CVE-2022-0778")},
+				},
+			}},
+		},
+	}, nil)
+
+	s := newTestScanner(mockClient)
+	findings, err := s.Findings("111111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12")
+	assert.Nil(t, err)
+	assert.Equal(t, []Finding{
+		{ID: "CVE-2021-44228", Severity: "CRITICAL"},
+		{ID: "CVE-2022-0778", Severity: "HIGH"},
+	}, findings)
+}
+
+func TestECRScannerFindingsErrors(t *testing.T) {
+	mockClient := new(mockECR)
+	mockClient.On("DescribeImageScanFindingsPages", &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     aws.String("111111111111"),
+		RepositoryName: aws.String("node"),
+		ImageId:        &ecr.ImageIdentifier{ImageDigest: aws.String("sha256:abc")},
+	}).Return([]*ecr.DescribeImageScanFindingsOutput{
+		{ImageScanStatus: &ecr.ImageScanStatus{Status: aws.String(ecr.ScanStatusInProgress)}},
+	}, nil)
+	mockClient.On("DescribeImageScanFindingsPages", &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     aws.String("111111111111"),
+		RepositoryName: aws.String("node"),
+		ImageId:        &ecr.ImageIdentifier{ImageTag: aws.String("latest")},
+	}).Return([]*ecr.DescribeImageScanFindingsOutput{}, errors.New("ScanNotFoundException"))
+
+	s := newTestScanner(mockClient)
+	_, err := s.Findings("111111111111.dkr.ecr.us-east-2.amazonaws.com/node@sha256:abc")
+	assert.EqualError(t, err, "image scan status is IN_PROGRESS")
+	_, err = s.Findings("111111111111.dkr.ecr.us-east-2.amazonaws.com/node")
+	assert.EqualError(t, err, "ScanNotFoundException")
+	_, err = s.Findings("node:12")
+	assert.EqualError(t, err, "image is not hosted on ECR")
+}
+
+func TestCheckVulnerabilities(t *testing.T) {
+	container := "111111111111.dkr.ecr.us-east-2.amazonaws.com/screwdriver-hub:node12"
+	scanner := &mockScanner{findings: []Finding{
+		{ID: "CVE-2021-44228", Severity: "CRITICAL"},
+		{ID: "CVE-2022-0778", Severity: "HIGH"},
+		{ID: "CVE-2020-1967", Severity: "critical"},
+	}}
+
+	assert.Nil(t, (&Policy{}).CheckVulnerabilities(scanner, container))
+
+	p := &Policy{BlockedSeverities: []string{"CRITICAL"}}
+	err := p.CheckVulnerabilities(scanner, container)
+	assert.True(t, IsViolation(err))
+	assert.EqualError(t, err, `Rejected by policy: container image "`+container+`" has blocked vulnerabilities: CVE-2020-1967 (CRITICAL), CVE-2021-44228 (CRITICAL)`)
+
+	p.IgnoredVulnerabilities = []string{"CVE-2020-1967", "CVE-2021-44228"}
+	assert.Nil(t, p.CheckVulnerabilities(scanner, container))
+
+	var many []Finding
+	for i := 0; i < 7; i++ {
+		many = append(many, Finding{ID: fmt.Sprintf("CVE-2023-000%d", i), Severity: "CRITICAL"})
+	}
+	err = (&Policy{BlockedSeverities: []string{"CRITICAL"}}).CheckVulnerabilities(&mockScanner{findings: many}, container)
+	assert.EqualError(t, err, `Rejected by policy: container image "`+container+`" has blocked vulnerabilities: CVE-2023-0000 (CRITICAL), CVE-2023-0001 (CRITICAL), CVE-2023-0002 (CRITICAL), CVE-2023-0003 (CRITICAL), CVE-2023-0004 (CRITICAL) and 2 more`)
+}
+
+func TestCheckVulnerabilitiesWithoutScan(t *testing.T) {
+	scanner := &mockScanner{err: errors.New("image is not hosted on ECR")}
+
+	p := &Policy{BlockedSeverities: []string{"CRITICAL"}}
+	assert.Nil(t, p.CheckVulnerabilities(scanner, "node:12"))
+
+	p.RequireImageScan = true
+	err := p.CheckVulnerabilities(scanner, "node:12")
+	assert.True(t, IsViolation(err))
+	assert.EqualError(t, err, `Rejected by policy: container image "node:12" has no scan results: image is not hosted on ECR`)
+}
@@ -0,0 +1,148 @@
+// Package receipt publishes a compact receipt of every started build to a DynamoDB table shared with the Screwdriver
+// queue service, so it can make scheduling decisions and reconcile builds without calling AWS itself
+package receipt
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+	"github.com/screwdriver-cd/aws-consumer-service/matrix"
+)
+
+const (
+	tableEnv = "SD_RECEIPT_TABLE"
+	ttlEnv   = "SD_RECEIPT_TTL_HOURS"
+
+	// defaultTTL is how long receipts are kept through the table ttl attribute expiresAt, longer than builds run
+	defaultTTL = 7 * 24 * time.Hour
+)
+
+// Receipt is the start of a build, keyed by the number attribute buildId and the string attribute buildKey
+type Receipt struct {
+	BuildID int `dynamodbav:"buildId"`
+	// BuildKey is the build id, suffixed with the architecture for the builds of a multi-architecture build
+	BuildKey string `dynamodbav:"buildKey"`
+	Executor string `dynamodbav:"executor"`
+	// ResourceArn is the arn of the aws resource running the build, like the codebuild build or the eks cluster
+	ResourceArn string `dynamodbav:"resourceArn,omitempty"`
+	Region      string `dynamodbav:"region"`
+	// Architecture is the architecture of a build of a multi-architecture build
+	Architecture string `dynamodbav:"architecture,omitempty"`
+	// ProjectName and PodNamePrefix are the names the build started with, its stop finds them by these
+	// names after the naming of the deployment policy changed
+	ProjectName   string `dynamodbav:"projectName,omitempty"`
+	PodNamePrefix string `dynamodbav:"podNamePrefix,omitempty"`
+	// StartedAt is the RFC3339 time the start completed
+	StartedAt string `dynamodbav:"startedAt"`
+	ExpiresAt int64  `dynamodbav:"expiresAt"`
+}
+
+// Table writes receipts to a DynamoDB table and reads them back for stops
+type Table struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+	ttl    time.Duration
+}
+
+// FromEnv returns the table of SD_RECEIPT_TABLE, nil when no receipts are published
+func FromEnv() *Table {
+	table := os.Getenv(tableEnv)
+	if table == "" {
+		return nil
+	}
+	t := &Table{table: table, ttl: defaultTTL}
+	if hours, err := strconv.Atoi(strings.TrimSpace(os.Getenv(ttlEnv))); err == nil && hours > 0 {
+		t.ttl = time.Duration(hours) * time.Hour
+	}
+	return t
+}
+
+// gets the dynamodb client, creating it on first use
+func (t *Table) dynamodb() (dynamodbiface.DynamoDBAPI, error) {
+	if t.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		t.client = dynamodb.New(sess)
+	}
+	return t.client, nil
+}
+
+// New gets the receipt of a build started at the time
+func New(buildID int, executor string, resourceArn string, region string, startedAt time.Time) Receipt {
+	return Receipt{
+		BuildID:     buildID,
+		Executor:    executor,
+		ResourceArn: resourceArn,
+		Region:      region,
+		StartedAt:   startedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// gets the key of the receipt of the build for the architecture
+func key(buildID int, arch string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"buildId":  {N: aws.String(strconv.Itoa(buildID))},
+		"buildKey": {S: aws.String(matrix.Key(buildID, arch))},
+	}
+}
+
+// Put writes the receipt, replacing the receipt of an earlier start of the build on the architecture
+func (t *Table) Put(r Receipt) error {
+	client, err := t.dynamodb()
+	if err != nil {
+		return err
+	}
+	startedAt, err := time.Parse(time.RFC3339, r.StartedAt)
+	if err != nil {
+		return fmt.Errorf("Got error parsing start time of build %d: %v", r.BuildID, err)
+	}
+	r.ExpiresAt = startedAt.Add(t.ttl).Unix()
+	r.BuildKey = matrix.Key(r.BuildID, r.Architecture)
+	item, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(t.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("Error-PutItem: %v", err)
+	}
+	return nil
+}
+
+// Get reads the receipt of the build for the architecture, nil when the build has none
+func (t *Table) Get(buildID int, arch string) (*Receipt, error) {
+	client, err := t.dynamodb()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(t.table),
+		Key:            key(buildID, arch),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-GetItem: %v", err)
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	var r Receipt
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &r); err != nil {
+		return nil, fmt.Errorf("Got error decoding receipt of build %d: %v", buildID, err)
+	}
+	return &r, nil
+}
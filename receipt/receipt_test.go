@@ -0,0 +1,108 @@
+package receipt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	inputs []*dynamodb.PutItemInput
+	gets   []*dynamodb.GetItemInput
+	item   map[string]*dynamodb.AttributeValue
+	err    error
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.gets = append(m.gets, input)
+	return &dynamodb.GetItemOutput{Item: m.item}, m.err
+}
+
+func (m *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.inputs = append(m.inputs, input)
+	return &dynamodb.PutItemOutput{}, m.err
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(tableEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(tableEnv, "sd-build-receipts")
+	assert.Equal(t, &Table{table: "sd-build-receipts", ttl: defaultTTL}, FromEnv())
+
+	t.Setenv(ttlEnv, "48")
+	assert.Equal(t, 48*time.Hour, FromEnv().ttl)
+	t.Setenv(ttlEnv, "-1")
+	assert.Equal(t, defaultTTL, FromEnv().ttl)
+}
+
+func TestPut(t *testing.T) {
+	client := &mockDynamoDB{}
+	table := &Table{client: client, table: "sd-build-receipts", ttl: 24 * time.Hour}
+	startedAt := time.Date(2022, 3, 1, 23, 59, 0, 0, time.FixedZone("PST", -8*3600))
+	r := New(1234, "sls", "arn:aws:codebuild:us-west-2:111111111:build/main-1234:abc", "us-west-2", startedAt)
+	assert.Nil(t, table.Put(r))
+
+	assert.Equal(t, "sd-build-receipts", aws.StringValue(client.inputs[0].TableName))
+	assert.Equal(t, map[string]*dynamodb.AttributeValue{
+		"buildId":     {N: aws.String("1234")},
+		"buildKey":    {S: aws.String("1234")},
+		"executor":    {S: aws.String("sls")},
+		"resourceArn": {S: aws.String("arn:aws:codebuild:us-west-2:111111111:build/main-1234:abc")},
+		"region":      {S: aws.String("us-west-2")},
+		"startedAt":   {S: aws.String("2022-03-02T07:59:00Z")},
+		"expiresAt":   {N: aws.String("1646294340")},
+	}, client.inputs[0].Item)
+
+	// builds whose resource is not known are published without it
+	r.ResourceArn = ""
+	assert.Nil(t, table.Put(r))
+	assert.NotContains(t, client.inputs[1].Item, "resourceArn")
+	assert.NotContains(t, client.inputs[1].Item, "architecture")
+
+	r.Architecture = "arm64"
+	assert.Nil(t, table.Put(r))
+	assert.Equal(t, "arm64", aws.StringValue(client.inputs[2].Item["architecture"].S))
+	// the receipts of the architectures of a build do not replace each other
+	assert.Equal(t, "1234-arm64", aws.StringValue(client.inputs[2].Item["buildKey"].S))
+
+	client.err = errors.New("ResourceNotFoundException")
+	assert.EqualError(t, table.Put(r), "Error-PutItem: ResourceNotFoundException")
+
+	r.StartedAt = "yesterday"
+	assert.EqualError(t, table.Put(r), `Got error parsing start time of build 1234: parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`)
+}
+
+func TestGet(t *testing.T) {
+	client := &mockDynamoDB{}
+	table := &Table{client: client, table: "sd-build-receipts", ttl: 24 * time.Hour}
+	r, err := table.Get(1234, "")
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	assert.Equal(t, map[string]*dynamodb.AttributeValue{"buildId": {N: aws.String("1234")}, "buildKey": {S: aws.String("1234")}}, client.gets[0].Key)
+
+	client.item = map[string]*dynamodb.AttributeValue{
+		"buildId":      {N: aws.String("1234")},
+		"buildKey":     {S: aws.String("1234-arm64")},
+		"executor":     {S: aws.String("sls")},
+		"region":       {S: aws.String("us-east-1")},
+		"architecture": {S: aws.String("arm64")},
+		"projectName":  {S: aws.String("sd-1898-main-6822-arm64")},
+		"startedAt":    {S: aws.String("2022-03-02T07:59:00Z")},
+		"expiresAt":    {N: aws.String("1646294340")},
+	}
+	r, err = table.Get(1234, "arm64")
+	assert.Nil(t, err)
+	assert.Equal(t, &Receipt{BuildID: 1234, BuildKey: "1234-arm64", Executor: "sls", Region: "us-east-1", Architecture: "arm64", ProjectName: "sd-1898-main-6822-arm64", StartedAt: "2022-03-02T07:59:00Z", ExpiresAt: 1646294340}, r)
+	assert.Equal(t, "1234-arm64", aws.StringValue(client.gets[1].Key["buildKey"].S))
+
+	client.err = errors.New("ResourceNotFoundException")
+	_, err = table.Get(1234, "")
+	assert.EqualError(t, err, "Error-GetItem: ResourceNotFoundException")
+}
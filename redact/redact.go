@@ -0,0 +1,125 @@
+// Package redact masks tokens and other sensitive values before they are logged
+package redact
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values
+const Mask = "***"
+
+// suffixes of sensitive field names, matched case insensitive without separators.
+// Suffixes rather than fragments keep fields describing a secret, like imagePullCredentialsType, readable.
+var sensitiveKeys = []string{
+	"token",
+	"authorization",
+	"password",
+	"passwd",
+	"secret",
+	"credential",
+	"credentials",
+	"privatekey",
+	"apikey",
+	"accesskey",
+	"cookie",
+}
+
+// strips separators from keys before matching
+var keyNormalizer = strings.NewReplacer("-", "", "_", "", ".", "")
+
+var (
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+// IsSensitive reports whether the value of key must not be logged
+func IsSensitive(key string) bool {
+	normalized := strings.ToLower(keyNormalizer.Replace(key))
+	for _, k := range sensitiveKeys {
+		if strings.HasSuffix(normalized, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// Map returns a deep copy of m with the values of sensitive keys masked
+func Map(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if IsSensitive(k) {
+			redacted[k] = Mask
+			continue
+		}
+		redacted[k] = Value(v)
+	}
+	return redacted
+}
+
+// Value returns a copy of v with sensitive map keys and tokens in strings masked
+func Value(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return Map(val)
+	case []interface{}:
+		redacted := make([]interface{}, len(val))
+		for i, item := range val {
+			redacted[i] = Value(item)
+		}
+		return redacted
+	case string:
+		return String(val)
+	default:
+		return v
+	}
+}
+
+// String masks bearer tokens and JWTs in s
+func String(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "${1}"+Mask)
+	return jwtPattern.ReplaceAllString(s, Mask)
+}
+
+// JSON masks sensitive fields of a json document, falling back to String when s is not json
+func JSON(s string) string {
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return String(s)
+	}
+	redacted, err := json.Marshal(Value(doc))
+	if err != nil {
+		return String(s)
+	}
+	return string(redacted)
+}
+
+// Values masks every occurrence of the given secret values and tokens in s
+func Values(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, Mask)
+		}
+	}
+	return String(s)
+}
+
+// Header returns a copy of the http header with sensitive values masked
+func Header(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for k, v := range h {
+		if IsSensitive(k) {
+			redacted[k] = []string{Mask}
+			continue
+		}
+		redacted[k] = append([]string(nil), v...)
+	}
+	return redacted
+}
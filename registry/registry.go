@@ -0,0 +1,158 @@
+// Package registry looks up provider accounts by alias in a DynamoDB table so build messages only carry the alias
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	tableEnv = "SD_PROVIDER_REGISTRY_TABLE"
+	ttlEnv   = "SD_PROVIDER_REGISTRY_TTL_SECS"
+
+	defaultTTL = 5 * time.Minute
+	// AliasField is the provider field naming the registry account
+	AliasField = "accountAlias"
+)
+
+// Account is a registered provider account, keyed by alias
+type Account struct {
+	Alias       string                 `dynamodbav:"alias"`
+	AccountID   string                 `dynamodbav:"accountId"`
+	Region      string                 `dynamodbav:"region"`
+	Role        string                 `dynamodbav:"role"`
+	VPC         map[string]interface{} `dynamodbav:"vpc"`
+	Bucket      string                 `dynamodbav:"bucket"`
+	ClusterName string                 `dynamodbav:"clusterName"`
+	Executors   []string               `dynamodbav:"executors"`
+}
+
+// cached lookup of an account
+type entry struct {
+	account *Account
+	expires time.Time
+}
+
+// Registry looks up accounts in a DynamoDB table, caching them for the ttl
+type Registry struct {
+	client  dynamodbiface.DynamoDBAPI
+	table   string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// FromEnv returns the registry of SD_PROVIDER_REGISTRY_TABLE, nil when the registry is disabled
+func FromEnv() *Registry {
+	table := os.Getenv(tableEnv)
+	if table == "" {
+		return nil
+	}
+	ttl := defaultTTL
+	if secs, err := strconv.Atoi(os.Getenv(ttlEnv)); err == nil && secs >= 0 {
+		ttl = time.Duration(secs) * time.Second
+	}
+	return &Registry{table: table, ttl: ttl, entries: map[string]entry{}}
+}
+
+// gets the dynamodb client, creating it on first use
+func (r *Registry) dynamodb() (dynamodbiface.DynamoDBAPI, error) {
+	if r.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		r.client = dynamodb.New(sess)
+	}
+	return r.client, nil
+}
+
+// Lookup returns the account registered under the alias
+func (r *Registry) Lookup(alias string) (*Account, error) {
+	r.mu.Lock()
+	if e, ok := r.entries[alias]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.account, nil
+	}
+	client, err := r.dynamodb()
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// the lookups of other aliases don't wait for the table
+	result, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(r.table),
+		Key:       map[string]*dynamodb.AttributeValue{"alias": {S: aws.String(alias)}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error-GetItem: %v", err)
+	}
+	if len(result.Item) == 0 {
+		return nil, fmt.Errorf("account %s is not registered", alias)
+	}
+	account := &Account{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, account); err != nil {
+		return nil, fmt.Errorf("Got error decoding account %s: %v", alias, err)
+	}
+	r.mu.Lock()
+	r.entries[alias] = entry{account: account, expires: time.Now().Add(r.ttl)}
+	r.mu.Unlock()
+	return account, nil
+}
+
+// Apply fills in the provider fields missing from the message and checks the ones it sets against the account
+func (a *Account) Apply(provider map[string]interface{}, executor string) error {
+	if len(a.Executors) > 0 && !contains(a.Executors, executor) {
+		return fmt.Errorf("executor %s is not allowed for account %s", executor, a.Alias)
+	}
+	if provider["accountId"] != nil && fmt.Sprint(provider["accountId"]) != a.AccountID {
+		return fmt.Errorf("accountId %v does not match account %s", provider["accountId"], a.Alias)
+	}
+	if role, _ := provider["role"].(string); role != "" {
+		if roleArn, err := arn.Parse(role); err != nil || roleArn.AccountID != a.AccountID {
+			return fmt.Errorf("role %s does not belong to account %s", role, a.Alias)
+		}
+	}
+
+	if provider["accountId"] == nil && a.AccountID != "" {
+		provider["accountId"] = json.Number(a.AccountID)
+	}
+	setDefault(provider, "role", a.Role)
+	setDefault(provider, "region", a.Region)
+	// the sls executor derives the bucket of the build region from the account bucket
+	setDefault(provider, "accountBucket", a.Bucket)
+	setDefault(provider, "clusterName", a.ClusterName)
+	if provider["vpc"] == nil && a.VPC != nil {
+		provider["vpc"] = a.VPC
+	}
+	return nil
+}
+
+// sets the provider field unless the message set it
+func setDefault(provider map[string]interface{}, key string, value string) {
+	if s, _ := provider[key].(string); s == "" && value != "" {
+		provider[key] = value
+	}
+}
+
+// returns true if values contains s
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
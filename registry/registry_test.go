@@ -0,0 +1,147 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mock.Mock
+}
+
+func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*dynamodb.GetItemOutput), args.Error(1)
+}
+
+func getItemInput(alias string) *dynamodb.GetItemInput {
+	return &dynamodb.GetItemInput{
+		TableName: aws.String("sd-provider-accounts"),
+		Key:       map[string]*dynamodb.AttributeValue{"alias": {S: aws.String(alias)}},
+	}
+}
+
+var testItem = map[string]*dynamodb.AttributeValue{
+	"alias":     {S: aws.String("team-a")},
+	"accountId": {S: aws.String("111111111")},
+	"region":    {S: aws.String("us-west-2")},
+	"role":      {S: aws.String("arn:aws:iam::111111111:role/sd-build")},
+	"bucket":    {S: aws.String("sd-team-a-builds")},
+	"vpc": {M: map[string]*dynamodb.AttributeValue{
+		"vpcId":            {S: aws.String("vpc-1")},
+		"securityGroupIds": {L: []*dynamodb.AttributeValue{{S: aws.String("sg-1")}}},
+		"subnetIds":        {L: []*dynamodb.AttributeValue{{S: aws.String("subnet-1")}, {S: aws.String("subnet-2")}}},
+	}},
+	"executors": {L: []*dynamodb.AttributeValue{{S: aws.String("sls")}}},
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(tableEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(tableEnv, "sd-provider-accounts")
+	t.Setenv(ttlEnv, "30")
+	r := FromEnv()
+	assert.Equal(t, "sd-provider-accounts", r.table)
+	assert.Equal(t, 30*time.Second, r.ttl)
+}
+
+func TestLookup(t *testing.T) {
+	client := new(mockDynamoDB)
+	client.On("GetItem", getItemInput("team-a")).Return(&dynamodb.GetItemOutput{Item: testItem}, nil).Once()
+	client.On("GetItem", getItemInput("team-b")).Return(&dynamodb.GetItemOutput{}, nil)
+	client.On("GetItem", getItemInput("team-c")).Return(&dynamodb.GetItemOutput{}, errors.New("AccessDeniedException"))
+	r := &Registry{client: client, table: "sd-provider-accounts", ttl: time.Minute, entries: map[string]entry{}}
+
+	account, err := r.Lookup("team-a")
+	assert.Nil(t, err)
+	assert.Equal(t, &Account{
+		Alias:     "team-a",
+		AccountID: "111111111",
+		Region:    "us-west-2",
+		Role:      "arn:aws:iam::111111111:role/sd-build",
+		Bucket:    "sd-team-a-builds",
+		VPC: map[string]interface{}{
+			"vpcId":            "vpc-1",
+			"securityGroupIds": []interface{}{"sg-1"},
+			"subnetIds":        []interface{}{"subnet-1", "subnet-2"},
+		},
+		Executors: []string{"sls"},
+	}, account)
+
+	// served from the cache
+	cached, err := r.Lookup("team-a")
+	assert.Nil(t, err)
+	assert.Same(t, account, cached)
+	client.AssertNumberOfCalls(t, "GetItem", 1)
+
+	_, err = r.Lookup("team-b")
+	assert.EqualError(t, err, "account team-b is not registered")
+	_, err = r.Lookup("team-c")
+	assert.EqualError(t, err, "Error-GetItem: AccessDeniedException")
+}
+
+func TestLookupDoesNotBlockCachedAccounts(t *testing.T) {
+	reading, release := make(chan struct{}), make(chan struct{})
+	client := new(mockDynamoDB)
+	client.On("GetItem", getItemInput("team-a")).Run(func(mock.Arguments) {
+		close(reading)
+		<-release
+	}).Return(&dynamodb.GetItemOutput{Item: testItem}, nil).Once()
+	cached := &Account{Alias: "team-b"}
+	r := &Registry{client: client, table: "sd-provider-accounts", ttl: time.Minute,
+		entries: map[string]entry{"team-b": {account: cached, expires: time.Now().Add(time.Minute)}}}
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Lookup("team-a")
+		done <- err
+	}()
+	// the cached account is looked up while the table is read
+	<-reading
+	account, err := r.Lookup("team-b")
+	assert.Nil(t, err)
+	assert.Same(t, cached, account)
+	close(release)
+	assert.Nil(t, <-done)
+	assert.Equal(t, "111111111", r.entries["team-a"].account.AccountID)
+}
+
+func TestApply(t *testing.T) {
+	account := &Account{
+		Alias:     "team-a",
+		AccountID: "111111111",
+		Region:    "us-west-2",
+		Role:      "arn:aws:iam::111111111:role/sd-build",
+		Bucket:    "sd-team-a-builds",
+		VPC:       map[string]interface{}{"vpcId": "vpc-1"},
+		Executors: []string{"sls"},
+	}
+
+	provider := map[string]interface{}{"accountAlias": "team-a", "region": "us-east-1", "buildRegion": ""}
+	assert.Nil(t, account.Apply(provider, "sls"))
+	assert.Equal(t, map[string]interface{}{
+		"accountAlias":  "team-a",
+		"accountId":     json.Number("111111111"),
+		"region":        "us-east-1",
+		"buildRegion":   "",
+		"role":          "arn:aws:iam::111111111:role/sd-build",
+		"accountBucket": "sd-team-a-builds",
+		"vpc":           map[string]interface{}{"vpcId": "vpc-1"},
+	}, provider)
+
+	assert.EqualError(t, account.Apply(map[string]interface{}{}, "eks"), "executor eks is not allowed for account team-a")
+	assert.EqualError(t, account.Apply(map[string]interface{}{"accountId": json.Number("222222222")}, "sls"),
+		"accountId 222222222 does not match account team-a")
+	assert.EqualError(t, account.Apply(map[string]interface{}{"role": "arn:aws:iam::222222222:role/sd-build"}, "sls"),
+		"role arn:aws:iam::222222222:role/sd-build does not belong to account team-a")
+}
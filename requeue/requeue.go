@@ -0,0 +1,107 @@
+// Package requeue publishes build messages which cannot start for lack of capacity to an SQS delay queue,
+// the consumer of the queue retries them with the attempt count carried in the message attributes
+package requeue
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	queueURLEnv    = "SD_REQUEUE_QUEUE_URL"
+	maxAttemptsEnv = "SD_REQUEUE_MAX_ATTEMPTS"
+	delayEnv       = "SD_REQUEUE_DELAY_SECS"
+
+	defaultMaxAttempts = 5
+	defaultDelay       = time.Minute
+	// maxDelay is the longest delay sqs supports
+	maxDelay = 15 * time.Minute
+
+	// AttemptAttribute is the message attribute holding the number of times the message was requeued
+	AttemptAttribute = "attempt"
+)
+
+// Queue requeues build messages to an SQS queue with an exponential delay
+type Queue struct {
+	client      sqsiface.SQSAPI
+	url         string
+	maxAttempts int
+	delay       time.Duration
+}
+
+// FromEnv returns the queue of SD_REQUEUE_QUEUE_URL, nil when requeueing is disabled
+func FromEnv() *Queue {
+	url := os.Getenv(queueURLEnv)
+	if url == "" {
+		return nil
+	}
+	q := &Queue{url: url, maxAttempts: defaultMaxAttempts, delay: defaultDelay}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(maxAttemptsEnv))); err == nil && n > 0 {
+		q.maxAttempts = n
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv(delayEnv))); err == nil && secs > 0 {
+		q.delay = time.Duration(secs) * time.Second
+	}
+	return q
+}
+
+// gets the sqs client, creating it on first use
+func (q *Queue) sqs() (sqsiface.SQSAPI, error) {
+	if q.client == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		q.client = sqs.New(sess)
+	}
+	return q.client, nil
+}
+
+// MaxAttempts returns how many times a message is requeued before it is given up
+func (q *Queue) MaxAttempts() int {
+	return q.maxAttempts
+}
+
+// Delay returns the delay of the attempt, doubling with each attempt up to the sqs maximum
+func (q *Queue) Delay(attempt int) time.Duration {
+	delay := q.delay
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Requeue publishes the message for the attempt, returns false without publishing once all attempts are used up
+func (q *Queue) Requeue(value string, attempt int) (bool, error) {
+	if attempt > q.maxAttempts {
+		return false, nil
+	}
+	client, err := q.sqs()
+	if err != nil {
+		return false, err
+	}
+	_, err = client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:     aws.String(q.url),
+		MessageBody:  aws.String(value),
+		DelaySeconds: aws.Int64(int64(q.Delay(attempt).Seconds())),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			AttemptAttribute: {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(attempt))},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error-SendMessage: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package requeue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockSQS struct {
+	sqsiface.SQSAPI
+	mock.Mock
+}
+
+func (m *mockSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*sqs.SendMessageOutput), args.Error(1)
+}
+
+const testQueueURL = "https://sqs.us-west-2.amazonaws.com/111111111/sd-builds-delay"
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(queueURLEnv, "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv(queueURLEnv, testQueueURL)
+	assert.Equal(t, &Queue{url: testQueueURL, maxAttempts: 5, delay: time.Minute}, FromEnv())
+
+	t.Setenv(maxAttemptsEnv, "3")
+	t.Setenv(delayEnv, "30")
+	assert.Equal(t, &Queue{url: testQueueURL, maxAttempts: 3, delay: 30 * time.Second}, FromEnv())
+}
+
+func TestDelay(t *testing.T) {
+	q := &Queue{delay: time.Minute}
+	assert.Equal(t, time.Minute, q.Delay(1))
+	assert.Equal(t, 2*time.Minute, q.Delay(2))
+	assert.Equal(t, 8*time.Minute, q.Delay(4))
+	assert.Equal(t, 15*time.Minute, q.Delay(5))
+	assert.Equal(t, 15*time.Minute, q.Delay(20))
+}
+
+func TestRequeue(t *testing.T) {
+	client := new(mockSQS)
+	client.On("SendMessage", &sqs.SendMessageInput{
+		QueueUrl:     aws.String(testQueueURL),
+		MessageBody:  aws.String("eyJqb2IiOiAic3RhcnQifQ=="),
+		DelaySeconds: aws.Int64(120),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"attempt": {DataType: aws.String("Number"), StringValue: aws.String("2")},
+		},
+	}).Return(&sqs.SendMessageOutput{}, nil).Once()
+	q := &Queue{client: client, url: testQueueURL, maxAttempts: 2, delay: time.Minute}
+
+	requeued, err := q.Requeue("eyJqb2IiOiAic3RhcnQifQ==", 2)
+	assert.Nil(t, err)
+	assert.True(t, requeued)
+
+	requeued, err = q.Requeue("eyJqb2IiOiAic3RhcnQifQ==", 3)
+	assert.Nil(t, err)
+	assert.False(t, requeued)
+
+	client.On("SendMessage", mock.Anything).Return(&sqs.SendMessageOutput{}, errors.New("AccessDenied")).Once()
+	_, err = q.Requeue("eyJqb2IiOiAic3RhcnQifQ==", 1)
+	assert.EqualError(t, err, "Error-SendMessage: AccessDenied")
+	client.AssertExpectations(t)
+}
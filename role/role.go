@@ -0,0 +1,239 @@
+// Package role resolves a narrowly scoped IAM role per pipeline instead of sharing the broad provider role
+package role
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+
+	"github.com/screwdriver-cd/aws-consumer-service/awsconfig"
+)
+
+const (
+	// ModeSelect uses roles pre-provisioned with the name template
+	ModeSelect = "select"
+	// ModeCreate creates missing roles from the trust and policy templates
+	ModeCreate = "create"
+
+	modeEnv           = "SD_SCOPED_ROLE_MODE"
+	nameTemplateEnv   = "SD_SCOPED_ROLE_NAME_TEMPLATE"
+	trustTemplateEnv  = "SD_SCOPED_ROLE_TRUST_TEMPLATE"
+	policyTemplateEnv = "SD_SCOPED_ROLE_POLICY_TEMPLATE"
+	boundaryEnv       = "SD_SCOPED_ROLE_PERMISSIONS_BOUNDARY"
+	accountRoleEnv    = "SD_SCOPED_ROLE_ACCOUNT_ROLE"
+
+	defaultNameTemplate = "sd-pipeline-{pipelineId}"
+	rolePath            = "/screwdriver/"
+	policyName          = "screwdriver-build"
+	// codebuild can assume the role by default, IRSA needs a trust template with the cluster oidc provider
+	defaultTrustTemplate = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"codebuild.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+)
+
+// Template holds the placeholder values of the role templates
+type Template struct {
+	PipelineID string
+	AccountID  string
+	Region     string
+}
+
+// renders the {pipelineId}, {accountId}, {region} and {partition} placeholders
+func (t Template) render(s string) string {
+	return strings.NewReplacer(
+		"{pipelineId}", t.PipelineID,
+		"{accountId}", t.AccountID,
+		"{region}", t.Region,
+		"{partition}", awsconfig.Partition(t.Region),
+	).Replace(s)
+}
+
+// Manager resolves scoped roles, caching the arns of the roles it resolved
+type Manager struct {
+	iam            iamiface.IAMAPI
+	mode           string
+	nameTemplate   string
+	trustTemplate  string
+	policyTemplate string
+	boundary       string
+	// accountRole is the template of the role assumed to manage the scoped roles of a provider account
+	accountRole string
+	mu          sync.Mutex
+	arns        map[string]string
+	// accounts are the iam clients of the provider accounts by the arn of their account role
+	accounts map[string]iamiface.IAMAPI
+}
+
+// FromEnv returns the manager configured by SD_SCOPED_ROLE_MODE, nil when scoped roles are disabled
+func FromEnv() *Manager {
+	mode := os.Getenv(modeEnv)
+	if mode == "" {
+		return nil
+	}
+	m := &Manager{
+		mode:           mode,
+		nameTemplate:   os.Getenv(nameTemplateEnv),
+		trustTemplate:  os.Getenv(trustTemplateEnv),
+		policyTemplate: os.Getenv(policyTemplateEnv),
+		boundary:       os.Getenv(boundaryEnv),
+		accountRole:    os.Getenv(accountRoleEnv),
+		arns:           map[string]string{},
+		accounts:       map[string]iamiface.IAMAPI{},
+	}
+	if m.nameTemplate == "" {
+		m.nameTemplate = defaultNameTemplate
+	}
+	if m.trustTemplate == "" {
+		m.trustTemplate = defaultTrustTemplate
+	}
+	return m
+}
+
+// gets the iam client of the provider account of the template, creating it on first use. Without an account
+// role the roles are managed with the credentials of the consumer.
+func (m *Manager) client(t Template) (iamiface.IAMAPI, error) {
+	if m.accountRole != "" && t.AccountID != "" {
+		roleArn := t.render(m.accountRole)
+		if client, ok := m.accounts[roleArn]; ok {
+			return client, nil
+		}
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		m.accounts[roleArn] = iam.New(sess, &aws.Config{Credentials: stscreds.NewCredentials(sess, roleArn)})
+		return m.accounts[roleArn], nil
+	}
+	if m.iam == nil {
+		sess, err := awsconfig.NewSession("")
+		if err != nil {
+			return nil, err
+		}
+		m.iam = iam.New(sess)
+	}
+	return m.iam, nil
+}
+
+// Resolve returns the arn of the scoped role of the pipeline, creating it in create mode
+func (m *Manager) Resolve(t Template) (string, error) {
+	if m.mode != ModeSelect && m.mode != ModeCreate {
+		return "", fmt.Errorf("unknown scoped role mode %q", m.mode)
+	}
+	if t.PipelineID == "" {
+		return "", fmt.Errorf("pipeline id is required for a scoped role")
+	}
+	name := t.render(m.nameTemplate)
+	// the roles of the accounts share their names
+	cacheKey := t.AccountID + "/" + name
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if arn, ok := m.arns[cacheKey]; ok {
+		return arn, nil
+	}
+	client, err := m.client(t)
+	if err != nil {
+		return "", err
+	}
+
+	getResult, err := client.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if err == nil {
+		roleArn := aws.StringValue(getResult.Role.Arn)
+		if err := checkAccount(roleArn, t); err != nil {
+			return "", err
+		}
+		m.arns[cacheKey] = roleArn
+		return roleArn, nil
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != iam.ErrCodeNoSuchEntityException {
+		return "", fmt.Errorf("Error-GetRole: %v", err)
+	}
+	if m.mode == ModeSelect {
+		return "", fmt.Errorf("scoped role %s is not provisioned", name)
+	}
+
+	roleArn, err := m.create(client, name, t)
+	if err != nil {
+		return "", err
+	}
+	m.arns[cacheKey] = roleArn
+	return roleArn, nil
+}
+
+// checks that the role belongs to the provider account of the template
+func checkAccount(roleArn string, t Template) error {
+	if t.AccountID == "" {
+		return nil
+	}
+	parsed, err := arn.Parse(roleArn)
+	if err != nil {
+		return fmt.Errorf("Got error parsing scoped role arn %s: %v", roleArn, err)
+	}
+	if parsed.AccountID != t.AccountID {
+		return fmt.Errorf("scoped role %s is not in the provider account %s, %s is required to manage the roles of other accounts",
+			roleArn, t.AccountID, accountRoleEnv)
+	}
+	return nil
+}
+
+// deletes a role created without its policy, so the next build creates it again
+func deleteRole(client iamiface.IAMAPI, name string) {
+	if _, err := client.DeleteRole(&iam.DeleteRoleInput{RoleName: aws.String(name)}); err != nil {
+		log.Printf("Failed to delete scoped role %s: %v", name, err)
+	}
+}
+
+// creates the role from the templates and attaches the inline policy
+func (m *Manager) create(client iamiface.IAMAPI, name string, t Template) (string, error) {
+	if m.policyTemplate == "" {
+		return "", fmt.Errorf("%s is required to create scoped roles", policyTemplateEnv)
+	}
+	input := &iam.CreateRoleInput{
+		RoleName:                 aws.String(name),
+		Path:                     aws.String(rolePath),
+		AssumeRolePolicyDocument: aws.String(t.render(m.trustTemplate)),
+		Description:              aws.String(fmt.Sprintf("Screwdriver builds of pipeline %s", t.PipelineID)),
+		Tags:                     []*iam.Tag{{Key: aws.String("sd-pipeline-id"), Value: aws.String(t.PipelineID)}},
+	}
+	if m.boundary != "" {
+		input.PermissionsBoundary = aws.String(t.render(m.boundary))
+	}
+	createResult, err := client.CreateRole(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == iam.ErrCodeEntityAlreadyExistsException {
+		// created concurrently by another consumer
+		getResult, err := client.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+		if err != nil {
+			return "", fmt.Errorf("Error-GetRole: %v", err)
+		}
+		roleArn := aws.StringValue(getResult.Role.Arn)
+		if err := checkAccount(roleArn, t); err != nil {
+			return "", err
+		}
+		return roleArn, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error-CreateRole: %v", err)
+	}
+	roleArn := aws.StringValue(createResult.Role.Arn)
+	if err := checkAccount(roleArn, t); err != nil {
+		deleteRole(client, name)
+		return "", err
+	}
+	_, err = client.PutRolePolicy(&iam.PutRolePolicyInput{
+		RoleName:       aws.String(name),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(t.render(m.policyTemplate)),
+	})
+	if err != nil {
+		deleteRole(client, name)
+		return "", fmt.Errorf("Error-PutRolePolicy: %v", err)
+	}
+	return roleArn, nil
+}
@@ -0,0 +1,190 @@
+package role
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockIAM struct {
+	iamiface.IAMAPI
+	mock.Mock
+}
+
+func (m *mockIAM) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.GetRoleOutput), args.Error(1)
+}
+
+func (m *mockIAM) CreateRole(input *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.CreateRoleOutput), args.Error(1)
+}
+
+func (m *mockIAM) PutRolePolicy(input *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.PutRolePolicyOutput), args.Error(1)
+}
+
+func (m *mockIAM) DeleteRole(input *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*iam.DeleteRoleOutput), args.Error(1)
+}
+
+var (
+	testTemplate = Template{PipelineID: "1898", AccountID: "111111111", Region: "us-gov-west-1"}
+	testRoleArn  = "arn:aws-us-gov:iam::111111111:role/screwdriver/sd-pipeline-1898"
+	notFound     = awserr.New(iam.ErrCodeNoSuchEntityException, "role not found", nil)
+)
+
+func testManager(mode string, client *mockIAM) *Manager {
+	return &Manager{
+		iam:            client,
+		mode:           mode,
+		nameTemplate:   defaultNameTemplate,
+		trustTemplate:  defaultTrustTemplate,
+		policyTemplate: `{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:{partition}:s3:::sd-artifacts/{pipelineId}/*"}]}`,
+		arns:           map[string]string{},
+		accounts:       map[string]iamiface.IAMAPI{},
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("SD_SCOPED_ROLE_MODE", "")
+	assert.Nil(t, FromEnv())
+
+	t.Setenv("SD_SCOPED_ROLE_MODE", "select")
+	t.Setenv("SD_SCOPED_ROLE_NAME_TEMPLATE", "")
+	m := FromEnv()
+	assert.Equal(t, ModeSelect, m.mode)
+	assert.Equal(t, defaultNameTemplate, m.nameTemplate)
+	assert.Equal(t, defaultTrustTemplate, m.trustTemplate)
+}
+
+func TestRender(t *testing.T) {
+	assert.Equal(t, "arn:aws-us-gov:iam::111111111:policy/boundary-1898-us-gov-west-1", testTemplate.render("arn:{partition}:iam::{accountId}:policy/boundary-{pipelineId}-{region}"))
+}
+
+func TestResolveSelect(t *testing.T) {
+	client := new(mockIAM)
+	client.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-pipeline-1898")}).
+		Return(&iam.GetRoleOutput{Role: &iam.Role{Arn: aws.String(testRoleArn)}}, nil).Once()
+	client.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-pipeline-42")}).
+		Return(&iam.GetRoleOutput{}, notFound)
+	m := testManager(ModeSelect, client)
+
+	arn, err := m.Resolve(testTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, testRoleArn, arn)
+	// cached
+	arn, err = m.Resolve(testTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, testRoleArn, arn)
+
+	_, err = m.Resolve(Template{PipelineID: "42", Region: "us-west-2"})
+	assert.EqualError(t, err, "scoped role sd-pipeline-42 is not provisioned")
+
+	_, err = m.Resolve(Template{Region: "us-west-2"})
+	assert.EqualError(t, err, "pipeline id is required for a scoped role")
+	client.AssertExpectations(t)
+}
+
+func TestResolveCreate(t *testing.T) {
+	client := new(mockIAM)
+	client.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-pipeline-1898")}).Return(&iam.GetRoleOutput{}, notFound)
+	client.On("CreateRole", &iam.CreateRoleInput{
+		RoleName:                 aws.String("sd-pipeline-1898"),
+		Path:                     aws.String("/screwdriver/"),
+		AssumeRolePolicyDocument: aws.String(defaultTrustTemplate),
+		Description:              aws.String("Screwdriver builds of pipeline 1898"),
+		Tags:                     []*iam.Tag{{Key: aws.String("sd-pipeline-id"), Value: aws.String("1898")}},
+		PermissionsBoundary:      aws.String("arn:aws-us-gov:iam::111111111:policy/sd-boundary"),
+	}).Return(&iam.CreateRoleOutput{Role: &iam.Role{Arn: aws.String(testRoleArn)}}, nil)
+	client.On("PutRolePolicy", &iam.PutRolePolicyInput{
+		RoleName:       aws.String("sd-pipeline-1898"),
+		PolicyName:     aws.String("screwdriver-build"),
+		PolicyDocument: aws.String(`{"Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"arn:aws-us-gov:s3:::sd-artifacts/1898/*"}]}`),
+	}).Return(&iam.PutRolePolicyOutput{}, nil)
+	m := testManager(ModeCreate, client)
+	m.boundary = "arn:{partition}:iam::{accountId}:policy/sd-boundary"
+
+	arn, err := m.Resolve(testTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, testRoleArn, arn)
+	client.AssertExpectations(t)
+}
+
+func TestResolveCreateErrors(t *testing.T) {
+	client := new(mockIAM)
+	client.On("GetRole", mock.Anything).Return(&iam.GetRoleOutput{}, errors.New("AccessDenied"))
+	_, err := testManager(ModeCreate, client).Resolve(testTemplate)
+	assert.EqualError(t, err, "Error-GetRole: AccessDenied")
+
+	client = new(mockIAM)
+	client.On("GetRole", mock.Anything).Return(&iam.GetRoleOutput{}, notFound)
+	m := testManager(ModeCreate, client)
+	m.policyTemplate = ""
+	_, err = m.Resolve(testTemplate)
+	assert.EqualError(t, err, "SD_SCOPED_ROLE_POLICY_TEMPLATE is required to create scoped roles")
+
+	client = new(mockIAM)
+	client.On("GetRole", mock.Anything).Return(&iam.GetRoleOutput{}, notFound).Once()
+	client.On("CreateRole", mock.Anything).Return(&iam.CreateRoleOutput{}, awserr.New(iam.ErrCodeEntityAlreadyExistsException, "exists", nil))
+	client.On("GetRole", mock.Anything).Return(&iam.GetRoleOutput{Role: &iam.Role{Arn: aws.String(testRoleArn)}}, nil)
+	arn, err := testManager(ModeCreate, client).Resolve(testTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, testRoleArn, arn)
+
+	// roles without their policy are deleted
+	client = new(mockIAM)
+	client.On("GetRole", mock.Anything).Return(&iam.GetRoleOutput{}, notFound)
+	client.On("CreateRole", mock.Anything).Return(&iam.CreateRoleOutput{Role: &iam.Role{Arn: aws.String(testRoleArn)}}, nil)
+	client.On("PutRolePolicy", mock.Anything).Return(&iam.PutRolePolicyOutput{}, errors.New("MalformedPolicyDocument"))
+	client.On("DeleteRole", &iam.DeleteRoleInput{RoleName: aws.String("sd-pipeline-1898")}).Return(&iam.DeleteRoleOutput{}, nil)
+	_, err = testManager(ModeCreate, client).Resolve(testTemplate)
+	assert.EqualError(t, err, "Error-PutRolePolicy: MalformedPolicyDocument")
+	client.AssertExpectations(t)
+
+	// roles created outside of the provider account are deleted
+	client = new(mockIAM)
+	client.On("GetRole", mock.Anything).Return(&iam.GetRoleOutput{}, notFound)
+	client.On("CreateRole", mock.Anything).Return(&iam.CreateRoleOutput{Role: &iam.Role{Arn: aws.String("arn:aws-us-gov:iam::999999999:role/screwdriver/sd-pipeline-1898")}}, nil)
+	client.On("DeleteRole", &iam.DeleteRoleInput{RoleName: aws.String("sd-pipeline-1898")}).Return(&iam.DeleteRoleOutput{}, nil)
+	_, err = testManager(ModeCreate, client).Resolve(testTemplate)
+	assert.EqualError(t, err, "scoped role arn:aws-us-gov:iam::999999999:role/screwdriver/sd-pipeline-1898 is not in the provider account 111111111, SD_SCOPED_ROLE_ACCOUNT_ROLE is required to manage the roles of other accounts")
+	client.AssertExpectations(t)
+
+	_, err = testManager("sometimes", new(mockIAM)).Resolve(testTemplate)
+	assert.EqualError(t, err, `unknown scoped role mode "sometimes"`)
+}
+
+func TestResolveAccountRole(t *testing.T) {
+	consumer := new(mockIAM)
+	account := new(mockIAM)
+	account.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-pipeline-1898")}).
+		Return(&iam.GetRoleOutput{Role: &iam.Role{Arn: aws.String(testRoleArn)}}, nil).Once()
+	other := new(mockIAM)
+	other.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-pipeline-1898")}).
+		Return(&iam.GetRoleOutput{Role: &iam.Role{Arn: aws.String("arn:aws-us-gov:iam::222222222:role/screwdriver/sd-pipeline-1898")}}, nil).Once()
+	m := testManager(ModeSelect, consumer)
+	m.accountRole = "arn:{partition}:iam::{accountId}:role/sd-scoped-roles"
+	m.accounts["arn:aws-us-gov:iam::111111111:role/sd-scoped-roles"] = account
+	m.accounts["arn:aws-us-gov:iam::222222222:role/sd-scoped-roles"] = other
+
+	// the roles of pipelines are resolved in their provider account
+	arn, err := m.Resolve(testTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, testRoleArn, arn)
+	arn, err = m.Resolve(Template{PipelineID: "1898", AccountID: "222222222", Region: "us-gov-west-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:aws-us-gov:iam::222222222:role/screwdriver/sd-pipeline-1898", arn)
+	account.AssertExpectations(t)
+	other.AssertExpectations(t)
+	consumer.AssertNotCalled(t, "GetRole", mock.Anything)
+}
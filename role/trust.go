@@ -0,0 +1,72 @@
+package role
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// trustPolicy is the subset of an assume role policy document needed to check its principals
+type trustPolicy struct {
+	Statement []struct {
+		Effect    string
+		Action    interface{}
+		Principal interface{}
+	}
+}
+
+// returns true if the value, a string or a list of strings, contains s
+func containsValue(value interface{}, s string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == s || v == "*"
+	case []interface{}:
+		for _, item := range v {
+			if containsValue(item, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckAssumable returns an error if the role does not exist or its trust policy does not allow the service principal to assume it.
+// An empty service only checks that the role exists.
+func CheckAssumable(client iamiface.IAMAPI, roleArn string, service string) error {
+	parsed, err := arn.Parse(roleArn)
+	if err != nil || !strings.HasPrefix(parsed.Resource, "role/") {
+		return fmt.Errorf("%q is not a role arn", roleArn)
+	}
+	name := parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:]
+	result, err := client.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil {
+		return fmt.Errorf("Error-GetRole: %v", err)
+	}
+	if service == "" {
+		return nil
+	}
+
+	document, err := url.QueryUnescape(aws.StringValue(result.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return fmt.Errorf("Got error decoding trust policy of %s: %v", name, err)
+	}
+	var policy trustPolicy
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return fmt.Errorf("Got error parsing trust policy of %s: %v", name, err)
+	}
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" || !containsValue(statement.Action, "sts:AssumeRole") {
+			continue
+		}
+		if principal, ok := statement.Principal.(map[string]interface{}); ok && containsValue(principal["Service"], service) {
+			return nil
+		}
+	}
+	return fmt.Errorf("role %s can not be assumed by %s", name, service)
+}
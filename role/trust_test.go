@@ -0,0 +1,30 @@
+package role
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAssumable(t *testing.T) {
+	client := new(mockIAM)
+	client.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-pipeline-1898")}).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{AssumeRolePolicyDocument: aws.String(url.QueryEscape(defaultTrustTemplate))},
+	}, nil)
+	client.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("sd-lambda")}).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{AssumeRolePolicyDocument: aws.String(`{"Statement":[{"Effect":"Allow","Principal":{"Service":["lambda.amazonaws.com"]},"Action":["sts:AssumeRole"]}]}`)},
+	}, nil)
+	client.On("GetRole", &iam.GetRoleInput{RoleName: aws.String("missing")}).Return(&iam.GetRoleOutput{}, errors.New("NoSuchEntity"))
+
+	assert.Nil(t, CheckAssumable(client, testRoleArn, "codebuild.amazonaws.com"))
+	assert.Nil(t, CheckAssumable(client, testRoleArn, ""))
+	assert.EqualError(t, CheckAssumable(client, "arn:aws:iam::111111111:role/sd-lambda", "codebuild.amazonaws.com"),
+		"role sd-lambda can not be assumed by codebuild.amazonaws.com")
+	assert.Nil(t, CheckAssumable(client, "arn:aws:iam::111111111:role/sd-lambda", "lambda.amazonaws.com"))
+	assert.EqualError(t, CheckAssumable(client, "arn:aws:iam::111111111:role/missing", ""), "Error-GetRole: NoSuchEntity")
+	assert.EqualError(t, CheckAssumable(client, "sd-role", ""), `"sd-role" is not a role arn`)
+}
@@ -0,0 +1,97 @@
+package screwdriver
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// UpdateQueue coalesces successive stats updates for the same build and flushes them asynchronously
+type UpdateQueue struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	pending map[int]*pendingUpdate
+	wg      sync.WaitGroup
+}
+
+// pendingUpdate holds the merged stats of a build waiting to be flushed
+type pendingUpdate struct {
+	api           API
+	stats         map[string]interface{}
+	statusMessage string
+	timer         *time.Timer
+}
+
+// NewUpdateQueue returns a new queue which flushes coalesced updates after delay
+func NewUpdateQueue(delay time.Duration) *UpdateQueue {
+	return &UpdateQueue{
+		delay:   delay,
+		pending: map[int]*pendingUpdate{},
+	}
+}
+
+// Add merges the stats into the pending update of the build, scheduling a flush if none is pending
+func (q *UpdateQueue) Add(api API, stats map[string]interface{}, buildID int, statusMessage string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if p, ok := q.pending[buildID]; ok {
+		for k, v := range stats {
+			p.stats[k] = v
+		}
+		// latest client wins, it carries the most recent token
+		p.api = api
+		if statusMessage != "" {
+			p.statusMessage = statusMessage
+		}
+		return
+	}
+
+	p := &pendingUpdate{
+		api:           api,
+		stats:         make(map[string]interface{}, len(stats)),
+		statusMessage: statusMessage,
+	}
+	for k, v := range stats {
+		p.stats[k] = v
+	}
+	q.pending[buildID] = p
+	q.wg.Add(1)
+	p.timer = time.AfterFunc(q.delay, func() {
+		defer q.wg.Done()
+		q.flush(buildID)
+	})
+}
+
+// sends the pending update of a build to the SD API
+func (q *UpdateQueue) flush(buildID int) {
+	q.mu.Lock()
+	p, ok := q.pending[buildID]
+	delete(q.pending, buildID)
+	q.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := p.api.UpdateBuild(p.stats, buildID, p.statusMessage); err != nil {
+		log.Printf("Updating build stats for build %d: %v", buildID, err)
+	}
+}
+
+// Flush sends all pending updates immediately and waits for in-flight updates to complete
+func (q *UpdateQueue) Flush() {
+	q.mu.Lock()
+	var buildIDs []int
+	for buildID, p := range q.pending {
+		if p.timer.Stop() {
+			q.wg.Done()
+			buildIDs = append(buildIDs, buildID)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, buildID := range buildIDs {
+		q.flush(buildID)
+	}
+	q.wg.Wait()
+}
@@ -0,0 +1,51 @@
+package screwdriver_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+	"github.com/screwdriver-cd/aws-consumer-service/screwdriver/sdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateQueueCoalesces(t *testing.T) {
+	api := sdtest.New()
+	q := sd.NewUpdateQueue(time.Hour)
+
+	q.Add(api, map[string]interface{}{"hostname": "node123"}, 15, "")
+	q.Add(api, map[string]interface{}{"imagePullStartTime": "now"}, 15, "pulling image")
+	q.Add(api, map[string]interface{}{"hostname": "node456"}, 16, "")
+	q.Flush()
+
+	calls := api.UpdateBuildCalls()
+	assert.Equal(t, 2, len(calls))
+	for _, call := range calls {
+		switch call.BuildID {
+		case 15:
+			assert.Equal(t, map[string]interface{}{"hostname": "node123", "imagePullStartTime": "now"}, call.Stats)
+			assert.Equal(t, "pulling image", call.StatusMessage)
+		case 16:
+			assert.Equal(t, map[string]interface{}{"hostname": "node456"}, call.Stats)
+		default:
+			t.Errorf("unexpected build id %d", call.BuildID)
+		}
+	}
+}
+
+func TestUpdateQueueFlushesAfterDelay(t *testing.T) {
+	api := sdtest.New()
+	api.UpdateBuildErr = errors.New("api down")
+	q := sd.NewUpdateQueue(time.Millisecond)
+
+	q.Add(api, map[string]interface{}{"hostname": "node123"}, 15, "")
+	time.Sleep(50 * time.Millisecond)
+	q.Add(api, map[string]interface{}{"hostname": "node456"}, 15, "")
+	q.Flush()
+
+	calls := api.UpdateBuildCalls()
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, "node123", calls[0].Stats["hostname"])
+	assert.Equal(t, "node456", calls[1].Stats["hostname"])
+}
@@ -2,37 +2,106 @@ package screwdriver
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/screwdriver-cd/aws-consumer-service/fault"
+	"github.com/screwdriver-cd/aws-consumer-service/redact"
 )
 
 const retryWaitMin = 100
 const retryWaitMax = 300
 
-var maxRetries = 5
-var httpTimeout = time.Duration(20) * time.Second
+// maximum size of a response body read from the SD API
+const maxResponseBytes = 5 << 20
+
+const defaultMaxRetries = 5
+const defaultHTTPTimeout = time.Duration(20) * time.Second
+
+const (
+	serviceName     = "sd-aws-consumer-service"
+	requestIDHeader = "X-Request-Id"
+)
+
+// version of the service, set at build time with -ldflags
+var version = "dev"
+
+// environment variables for tls configuration of SD API connections
+const (
+	tlsCertFileEnv = "SDAPI_TLS_CERT_FILE"
+	tlsKeyFileEnv  = "SDAPI_TLS_KEY_FILE"
+	caBundleEnv    = "SDAPI_CA_BUNDLE_FILE"
+)
 
 // API interface definition
 type API interface {
 	UpdateBuild(stats map[string]interface{}, buildID int, statusMessage string) error
+	UpdateBuildStatus(status BuildStatus, buildID int, statusMessage string) error
 	GetAPIURL() (string, error)
+	GetBuildSecrets(buildID int) ([]Secret, error)
+	UpdateBuildMeta(meta map[string]interface{}, buildID int) error
+	PostArtifactManifest(manifest ArtifactManifest, buildID int) error
+	PostCoverageSummary(summary CoverageSummary, buildID int) error
 }
 
+// BuildStatus is the status of a build
+type BuildStatus string
+
+// Build statuses set by the consumer
+const (
+	Failure BuildStatus = "FAILURE"
+	Aborted BuildStatus = "ABORTED"
+)
+
 // BuildUpdatePayload structure definition
 type BuildUpdatePayload struct {
-	Stats         map[string]interface{} `json:"stats"`
+	Status        BuildStatus            `json:"status,omitempty"`
+	Stats         map[string]interface{} `json:"stats,omitempty"`
 	StatusMessage string                 `json:"statusMessage,omitempty"`
+	Meta          map[string]interface{} `json:"meta,omitempty"`
+}
+
+// ArtifactManifest lists the artifacts uploaded by a build
+type ArtifactManifest struct {
+	Location string         `json:"location"`
+	Files    []ArtifactFile `json:"files"`
+}
+
+// ArtifactFile is a single uploaded artifact
+type ArtifactFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// CoverageSummary is the coverage and test results summary of a build
+type CoverageSummary struct {
+	Coverage string `json:"coverage"`
+	Results  string `json:"results,omitempty"`
+}
+
+// Secret is a pipeline secret available to a build
+type Secret struct {
+	ID         int    `json:"id"`
+	PipelineID int    `json:"pipelineId"`
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	AllowInPR  bool   `json:"allowInPR"`
 }
 
 // Token is a Screwdriver API token.
@@ -62,22 +131,162 @@ func tokenHeader(token string) string {
 	return fmt.Sprintf("Bearer %s", token)
 }
 
-// New returns a new API object
-func New(url, token string) (API, error) {
-	if strings.TrimSpace(os.Getenv("SDAPI_TIMEOUT_SECS")) != "" {
-		apiTimeout, _ := strconv.Atoi(os.Getenv("SDAPI_TIMEOUT_SECS"))
-		httpTimeout = time.Duration(apiTimeout) * time.Second
+// UserAgent returns the user agent sent with SD API requests
+func UserAgent() string {
+	return fmt.Sprintf("%s/%s", serviceName, version)
+}
+
+// generates a correlation id for a SD API request
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// gets the tls config with client certificate and ca bundle, nil when not configured
+func getTLSConfig() (*tls.Config, error) {
+	certFile := strings.TrimSpace(os.Getenv(tlsCertFileEnv))
+	keyFile := strings.TrimSpace(os.Getenv(tlsKeyFileEnv))
+	caFile := strings.TrimSpace(os.Getenv(caBundleEnv))
+
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both %s and %s are required for mTLS", tlsCertFileEnv, tlsKeyFileEnv)
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		caBundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no valid certificates found in ca bundle %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
 	}
 
-	if strings.TrimSpace(os.Getenv("SDAPI_MAXRETRIES")) != "" {
-		maxRetries, _ = strconv.Atoi(os.Getenv("SDAPI_MAXRETRIES"))
+	return tlsConfig, nil
+}
+
+// gets the transport for SD API connections, proxies are resolved from HTTPS_PROXY/NO_PROXY
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// files of the tls configuration of SD API connections
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+// transports shared by the clients of a tls configuration, so connections are pooled across messages
+var (
+	transportsMu sync.Mutex
+	transports   = map[tlsFiles]*http.Transport{}
+)
+
+// gets the shared transport of the tls configuration from env, the files are read once per configuration
+func sharedTransport() (*http.Transport, error) {
+	files := tlsFiles{
+		certFile: strings.TrimSpace(os.Getenv(tlsCertFileEnv)),
+		keyFile:  strings.TrimSpace(os.Getenv(tlsKeyFileEnv)),
+		caFile:   strings.TrimSpace(os.Getenv(caBundleEnv)),
+	}
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if transport, ok := transports[files]; ok {
+		return transport, nil
+	}
+	tlsConfig, err := getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := newTransport(tlsConfig)
+	transports[files] = transport
+	return transport, nil
+}
+
+// Config holds the settings of a SD API client, it is copied into the client on creation
+type Config struct {
+	MaxRetries  int
+	HTTPTimeout time.Duration
+	// Transport is used for requests, the default transport with tls and proxy settings from env when nil
+	Transport http.RoundTripper
+}
+
+// ConfigFromEnv returns the client config from SDAPI_TIMEOUT_SECS and SDAPI_MAXRETRIES
+func ConfigFromEnv() Config {
+	config := Config{
+		MaxRetries:  defaultMaxRetries,
+		HTTPTimeout: defaultHTTPTimeout,
+	}
+
+	if apiTimeout, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SDAPI_TIMEOUT_SECS"))); err == nil && apiTimeout > 0 {
+		config.HTTPTimeout = time.Duration(apiTimeout) * time.Second
 	}
+
+	if retries, err := strconv.Atoi(strings.TrimSpace(os.Getenv("SDAPI_MAXRETRIES"))); err == nil && retries >= 0 {
+		config.MaxRetries = retries
+	}
+
+	return config
+}
+
+// New returns a new API object
+func New(url, token string) (API, error) {
+	return NewWithConfig(url, token, ConfigFromEnv())
+}
+
+// NewWithTransport returns a new API object sending requests through the given transport.
+// The default transport with tls and proxy settings from env is used when transport is nil.
+func NewWithTransport(url, token string, transport http.RoundTripper) (API, error) {
+	config := ConfigFromEnv()
+	config.Transport = transport
+	return NewWithConfig(url, token, config)
+}
+
+// NewWithConfig returns a new API object with the given config
+func NewWithConfig(url, token string, config Config) (API, error) {
 	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
+	retryClient.RetryMax = config.MaxRetries
 	retryClient.RetryWaitMin = time.Duration(retryWaitMin) * time.Millisecond
 	retryClient.RetryWaitMax = time.Duration(retryWaitMax) * time.Millisecond
 	retryClient.Backoff = retryablehttp.LinearJitterBackoff
-	retryClient.HTTPClient.Timeout = httpTimeout
+	retryClient.HTTPClient.Timeout = config.HTTPTimeout
+
+	transport := config.Transport
+	if transport == nil {
+		shared, err := sharedTransport()
+		if err != nil {
+			return nil, fmt.Errorf("configuring tls: %w", err)
+		}
+		transport = shared
+	}
+	transport = fault.FromEnv().WrapTransport(fault.Screwdriver, transport)
+	retryClient.HTTPClient.Transport = transport
+
 	newapi := SDAPI{
 		url,
 		token,
@@ -85,8 +294,8 @@ func New(url, token string) (API, error) {
 	}
 	return API(newapi), nil
 }
+
 func (a SDAPI) write(url *url.URL, requestType string, bodyType string, payload io.Reader) ([]byte, error) {
-	req := &http.Request{}
 	buf := new(bytes.Buffer)
 
 	size, err := buf.ReadFrom(payload)
@@ -94,9 +303,8 @@ func (a SDAPI) write(url *url.URL, requestType string, bodyType string, payload
 		log.Printf("WARNING: error:[%v], not able to read payload: %v", err, payload)
 		return nil, fmt.Errorf("WARNING: error:[%v], not able to read payload: %v", err, payload)
 	}
-	p := buf.String()
 
-	req, err = http.NewRequest(requestType, url.String(), strings.NewReader(p))
+	req, err := http.NewRequest(requestType, url.String(), bytes.NewReader(buf.Bytes()))
 	if err != nil {
 		log.Printf("WARNING: received error generating new request for %s(%s): %v ", requestType, url.String(), err)
 		return nil, fmt.Errorf("WARNING: received error generating new request for %s(%s): %v ", requestType, url.String(), err)
@@ -104,8 +312,11 @@ func (a SDAPI) write(url *url.URL, requestType string, bodyType string, payload
 
 	defer a.client.HTTPClient.CloseIdleConnections()
 
+	requestID := newRequestID()
 	req.Header.Set("Authorization", tokenHeader(a.token))
 	req.Header.Set("Content-Type", bodyType)
+	req.Header.Set("User-Agent", UserAgent())
+	req.Header.Set(requestIDHeader, requestID)
 	req.ContentLength = size
 
 	res, err := a.client.StandardClient().Do(req)
@@ -114,37 +325,69 @@ func (a SDAPI) write(url *url.URL, requestType string, bodyType string, payload
 	}
 
 	if err != nil {
-		log.Printf("WARNING: received error from %s(%s): %v ", requestType, url.String(), err)
+		log.Printf("WARNING: received error from %s(%s) [request id: %s]: %v ", requestType, url.String(), requestID, err)
 		return nil, fmt.Errorf("WARNING: received error from %s(%s): %v ", requestType, url.String(), err)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	responseID := res.Header.Get(requestIDHeader)
+	log.Printf("%s(%s) responded %d [request id: %s, response request id: %s]", requestType, url.String(), res.StatusCode, requestID, responseID)
+
+	body, err := readBody(res)
 	if err != nil {
 		log.Printf("reading response Body from Screwdriver: %v", err)
 		return nil, fmt.Errorf("reading response Body from Screwdriver: %v", err)
 	}
 
 	if res.StatusCode/100 != 2 {
+		// the status tells what failed, the body may be the page of a proxy in front of the api
 		var errParse SDError
-		parseError := json.Unmarshal(body, &errParse)
-		if parseError != nil {
+		if parseError := json.Unmarshal(body, &errParse); parseError != nil {
 			log.Printf("unparseable error response from Screwdriver: %v", parseError)
-			return nil, fmt.Errorf("unparseable error response from Screwdriver: %v", parseError)
 		}
 
-		log.Printf("WARNING: received response %d from %s ", res.StatusCode, url.String())
+		log.Printf("WARNING: received response %d from %s [request id: %s, response request id: %s]", res.StatusCode, url.String(), requestID, responseID)
 		return nil, fmt.Errorf("WARNING: received response %d from %s ", res.StatusCode, url.String())
 	}
 
 	return body, nil
 }
 
+// reads a response body up to maxResponseBytes, successful responses must be json
+func readBody(res *http.Response) ([]byte, error) {
+	if res.ContentLength > maxResponseBytes {
+		return nil, fmt.Errorf("response size %d exceeds limit of %d bytes", res.ContentLength, maxResponseBytes)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseBytes {
+		return nil, fmt.Errorf("response exceeds limit of %d bytes", maxResponseBytes)
+	}
+
+	// error responses are handled by their status
+	if res.StatusCode/100 == 2 && len(bytes.TrimSpace(body)) > 0 {
+		contentType := res.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			return nil, fmt.Errorf("unexpected content type %q", contentType)
+		}
+	}
+
+	return body, nil
+}
+
 func (a SDAPI) makeURL(path string) (*url.URL, error) {
 	version := "v4"
 	fullpath := fmt.Sprintf("%s/%s/%s", a.baseURL, version, path)
 	return url.Parse(fullpath)
 }
 
+func (a SDAPI) get(url *url.URL) ([]byte, error) {
+	return a.write(url, "GET", "application/json", bytes.NewReader(nil))
+}
+
 func (a SDAPI) put(url *url.URL, bodyType string, payload io.Reader) ([]byte, error) {
 	return a.write(url, "PUT", bodyType, payload)
 }
@@ -155,6 +398,23 @@ func (a SDAPI) GetAPIURL() (string, error) {
 	return url.String(), err
 }
 
+// stats which must not be empty when provided, other fields are validated by the SD API schema
+var requiredStatValues = []string{"hostname", "imagePullStartTime"}
+
+// validates the provided build stats
+func validateStats(stats map[string]interface{}) error {
+	for _, key := range requiredStatValues {
+		val, ok := stats[key]
+		if !ok {
+			continue
+		}
+		if str, isString := val.(string); val == nil || (isString && strings.TrimSpace(str) == "") {
+			return fmt.Errorf("%s value is empty or invalid: %v", key, val)
+		}
+	}
+	return nil
+}
+
 // UpdateBuild function calls sd api to update build
 func (a SDAPI) UpdateBuild(stats map[string]interface{}, buildID int, statusMessage string) error {
 	u, err := a.makeURL(fmt.Sprintf("builds/%d", buildID))
@@ -164,11 +424,11 @@ func (a SDAPI) UpdateBuild(stats map[string]interface{}, buildID int, statusMess
 
 	var payload []byte
 	bs := &BuildUpdatePayload{}
-	if val, ok := stats["hostname"]; !ok {
-		return fmt.Errorf("hostname value is empty or invalid: %v", val)
+	if len(stats) == 0 && statusMessage == "" {
+		return fmt.Errorf("stats or statusMessage is required")
 	}
-	if val, ok := stats["imagePullStartTime"]; !ok {
-		return fmt.Errorf("imagePullStartTime value is empty or invalid: %v", val)
+	if err := validateStats(stats); err != nil {
+		return err
 	}
 	bs.Stats = stats
 	if statusMessage != "" {
@@ -178,7 +438,7 @@ func (a SDAPI) UpdateBuild(stats map[string]interface{}, buildID int, statusMess
 	if err != nil {
 		return fmt.Errorf("Marshaling JSON for Build Stats: %v", err)
 	}
-	log.Printf("payload: %v", string(payload))
+	log.Printf("payload: %v", redact.JSON(string(payload)))
 
 	_, err = a.put(u, "application/json", bytes.NewReader(payload))
 	if err != nil {
@@ -187,3 +447,88 @@ func (a SDAPI) UpdateBuild(stats map[string]interface{}, buildID int, statusMess
 
 	return nil
 }
+
+// GetBuildSecrets function calls sd api to get the secrets of a build
+func (a SDAPI) GetBuildSecrets(buildID int) ([]Secret, error) {
+	u, err := a.makeURL(fmt.Sprintf("builds/%d/secrets", buildID))
+	if err != nil {
+		return nil, fmt.Errorf("creating url: %v", err)
+	}
+
+	body, err := a.get(u)
+	if err != nil {
+		return nil, fmt.Errorf("Getting Build Secrets: %v", err)
+	}
+
+	var secrets []Secret
+	if err := json.Unmarshal(body, &secrets); err != nil {
+		return nil, fmt.Errorf("Parsing JSON for Build Secrets: %v", err)
+	}
+
+	return secrets, nil
+}
+
+// UpdateBuildStatus function calls sd api to update the status of a build
+func (a SDAPI) UpdateBuildStatus(status BuildStatus, buildID int, statusMessage string) error {
+	u, err := a.makeURL(fmt.Sprintf("builds/%d", buildID))
+	if err != nil {
+		return fmt.Errorf("creating url: %v", err)
+	}
+	if status == "" {
+		return fmt.Errorf("status value is empty or invalid: %v", status)
+	}
+
+	payload, err := json.Marshal(&BuildUpdatePayload{Status: status, StatusMessage: statusMessage})
+	if err != nil {
+		return fmt.Errorf("Marshaling JSON for Build Status: %v", err)
+	}
+	log.Printf("payload: %v", redact.JSON(string(payload)))
+
+	_, err = a.put(u, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("Posting to Build Status: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateBuildMeta function calls sd api to merge meta into the build meta
+func (a SDAPI) UpdateBuildMeta(meta map[string]interface{}, buildID int) error {
+	u, err := a.makeURL(fmt.Sprintf("builds/%d", buildID))
+	if err != nil {
+		return fmt.Errorf("creating url: %v", err)
+	}
+	if len(meta) == 0 {
+		return fmt.Errorf("meta value is empty or invalid: %v", meta)
+	}
+
+	payload, err := json.Marshal(&BuildUpdatePayload{Meta: meta})
+	if err != nil {
+		return fmt.Errorf("Marshaling JSON for Build Meta: %v", err)
+	}
+
+	_, err = a.put(u, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("Posting to Build Meta: %v", err)
+	}
+
+	return nil
+}
+
+// PostArtifactManifest function records the uploaded artifacts in the build meta
+func (a SDAPI) PostArtifactManifest(manifest ArtifactManifest, buildID int) error {
+	if manifest.Location == "" {
+		return fmt.Errorf("artifact location is empty or invalid: %v", manifest.Location)
+	}
+	return a.UpdateBuildMeta(map[string]interface{}{
+		"aws": map[string]interface{}{"artifacts": manifest},
+	}, buildID)
+}
+
+// PostCoverageSummary function records the coverage summary in the build meta
+func (a SDAPI) PostCoverageSummary(summary CoverageSummary, buildID int) error {
+	if summary.Coverage == "" {
+		return fmt.Errorf("coverage value is empty or invalid: %v", summary.Coverage)
+	}
+	return a.UpdateBuildMeta(map[string]interface{}{"tests": summary}, buildID)
+}
@@ -2,15 +2,28 @@ package screwdriver
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -31,8 +44,8 @@ func makeFakeHTTPClient(t *testing.T, code int, body string) *http.Client {
 		wantTokenHeader := fmt.Sprintf("Bearer %s", wantToken)
 
 		validateHeader(t, "Authorization", wantTokenHeader)
-		w.WriteHeader(code)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
 		fmt.Fprintln(w, body)
 	}))
 
@@ -54,11 +67,12 @@ func makeValidatedFakeHTTPClient(t *testing.T, code int, body string, v func(r *
 		validateHeader(t, "Authorization", wantTokenHeader)
 		v(r)
 
-		w.WriteHeader(code)
 		if code == 500 {
+			w.WriteHeader(code)
 			time.Sleep(time.Duration(2) * time.Second)
 		} else {
 			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
 			_, _ = w.Write([]byte(body))
 		}
 	}))
@@ -113,7 +127,11 @@ func TestUpdateBuild(t *testing.T) {
 		err           error
 	}{
 		{mockStatsObj, "", 200, nil},
-		{emptyStatsObj, "", 200, errors.New("hostname value is empty or invalid: <nil>")},
+		{emptyStatsObj, "", 200, errors.New("stats or statusMessage is required")},
+		{emptyStatsObj, "launcher started", 200, nil},
+		{map[string]interface{}{"projectArn": "arn:aws:codebuild:us-west-2:123:project/deploy-123"}, "", 200, nil},
+		{map[string]interface{}{"hostname": ""}, "", 200, errors.New("hostname value is empty or invalid: ")},
+		{map[string]interface{}{"hostname": "node123", "imagePullStartTime": nil}, "", 200, errors.New("imagePullStartTime value is empty or invalid: <nil>")},
 		{errorStatsObj, "", 400, errors.New("Posting to Build Stats: WARNING: received response 400 from http://fakeurl/v4/builds/15 ")},
 	}
 
@@ -150,20 +168,394 @@ func TestGetAPIURL(t *testing.T) {
 }
 
 func TestNewDefaults(t *testing.T) {
-	maxRetries = 5
-	httpTimeout = time.Duration(20) * time.Second
-
 	os.Setenv("SDAPI_TIMEOUT_SECS", "")
 	os.Setenv("SDAPI_MAXRETRIES", "")
-	_, _ = New("http://fakeurl", "fake")
-	assert.Equal(t, httpTimeout, time.Duration(20)*time.Second)
-	assert.Equal(t, maxRetries, 5)
+	testAPI, _ := New("http://fakeurl", "fake")
+	client := testAPI.(SDAPI).client
+	assert.Equal(t, client.HTTPClient.Timeout, time.Duration(20)*time.Second)
+	assert.Equal(t, client.RetryMax, 5)
 }
 
 func TestNew(t *testing.T) {
 	os.Setenv("SDAPI_TIMEOUT_SECS", "10")
 	os.Setenv("SDAPI_MAXRETRIES", "1")
-	_, _ = New("http://fakeurl", "fake")
-	assert.Equal(t, httpTimeout, time.Duration(10)*time.Second)
-	assert.Equal(t, maxRetries, 1)
+	testAPI, _ := New("http://fakeurl", "fake")
+	client := testAPI.(SDAPI).client
+	assert.Equal(t, client.HTTPClient.Timeout, time.Duration(10)*time.Second)
+	assert.Equal(t, client.RetryMax, 1)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		timeout string
+		retries string
+		expect  Config
+	}{
+		{"", "", Config{MaxRetries: 5, HTTPTimeout: 20 * time.Second}},
+		{"3", "0", Config{MaxRetries: 0, HTTPTimeout: 3 * time.Second}},
+		{"abc", "-1", Config{MaxRetries: 5, HTTPTimeout: 20 * time.Second}},
+	}
+	for _, test := range tests {
+		t.Setenv("SDAPI_TIMEOUT_SECS", test.timeout)
+		t.Setenv("SDAPI_MAXRETRIES", test.retries)
+		assert.Equal(t, test.expect, ConfigFromEnv())
+	}
+}
+
+// run with -race to detect shared state between clients
+func TestNewWithConfigConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(retries int) {
+			defer wg.Done()
+			transport := &recordingTransport{}
+			testAPI, err := NewWithConfig("http://fakeurl", "faketoken", Config{MaxRetries: retries, HTTPTimeout: time.Second, Transport: transport})
+			assert.Nil(t, err)
+			assert.Equal(t, retries, testAPI.(SDAPI).client.RetryMax)
+			assert.Nil(t, testAPI.UpdateBuild(map[string]interface{}{"hostname": "node123"}, retries, ""))
+		}(i)
+	}
+	wg.Wait()
+}
+
+// writes a self signed certificate and key to dir, returns the file paths
+func writeTestCert(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestGetTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "client")
+	invalidCA := filepath.Join(dir, "invalid.pem")
+	_ = os.WriteFile(invalidCA, []byte("not a cert"), 0600)
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		ca      string
+		wantNil bool
+		err     error
+	}{
+		{name: "not configured", wantNil: true},
+		{name: "cert and key", cert: certFile, key: keyFile},
+		{name: "ca bundle only", ca: certFile},
+		{name: "cert without key", cert: certFile, wantNil: true, err: errors.New("both SDAPI_TLS_CERT_FILE and SDAPI_TLS_KEY_FILE are required for mTLS")},
+		{name: "invalid ca bundle", ca: invalidCA, wantNil: true, err: fmt.Errorf("no valid certificates found in ca bundle %s", invalidCA)},
+	}
+	for _, test := range tests {
+		t.Setenv(tlsCertFileEnv, test.cert)
+		t.Setenv(tlsKeyFileEnv, test.key)
+		t.Setenv(caBundleEnv, test.ca)
+		got, err := getTLSConfig()
+		assert.Equal(t, test.err, err, test.name)
+		assert.Equal(t, test.wantNil, got == nil, test.name)
+	}
+}
+
+func TestNewWithMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeTestCert(t, dir, "127.0.0.1")
+	clientCert, clientKey := writeTestCert(t, dir, "client")
+
+	clientPEM, _ := os.ReadFile(clientCert)
+	clientPool := x509.NewCertPool()
+	clientPool.AppendCertsFromPEM(clientPEM)
+	serverPair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("loading server certificate: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, "{}")
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientPool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	t.Setenv("SDAPI_MAXRETRIES", "0")
+	t.Setenv(tlsCertFileEnv, clientCert)
+	t.Setenv(tlsKeyFileEnv, clientKey)
+	t.Setenv(caBundleEnv, serverCert)
+	testAPI, err := New(server.URL, "faketoken")
+	assert.Nil(t, err)
+	err = testAPI.UpdateBuild(map[string]interface{}{"hostname": "node123", "imagePullStartTime": "now"}, 15, "")
+	assert.Nil(t, err)
+
+	t.Setenv(tlsCertFileEnv, "")
+	t.Setenv(tlsKeyFileEnv, "")
+	testAPI, _ = New(server.URL, "faketoken")
+	err = testAPI.UpdateBuild(map[string]interface{}{"hostname": "node123", "imagePullStartTime": "now"}, 15, "")
+	assert.NotNil(t, err)
+}
+
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.requests = append(rt.requests, r)
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+		Request:    r,
+	}, nil
+}
+
+func TestNewWithFaults(t *testing.T) {
+	t.Setenv("SD_FAULTS", `[{"target": "sd", "service": "/v4/builds", "operation": "PUT", "fault": "throttle", "rate": 1}]`)
+	transport := &recordingTransport{}
+	testAPI, err := NewWithConfig("http://fakeurl", "faketoken", Config{MaxRetries: 0, HTTPTimeout: time.Second, Transport: transport})
+	assert.Nil(t, err)
+	assert.NotNil(t, testAPI.UpdateBuild(map[string]interface{}{"hostname": "node123"}, 1, ""))
+	assert.Empty(t, transport.requests)
+
+	// invalid rules are skipped
+	t.Setenv("SD_FAULTS", `[{"fault": "crash"}]`)
+	_, err = NewWithConfig("http://fakeurl", "faketoken", Config{Transport: transport})
+	assert.Nil(t, err)
+}
+
+func TestNewWithTransport(t *testing.T) {
+	transport := &recordingTransport{}
+	testAPI, err := NewWithTransport("http://fakeurl", "faketoken", transport)
+	assert.Nil(t, err)
+
+	err = testAPI.UpdateBuild(map[string]interface{}{"hostname": "node123", "imagePullStartTime": "now"}, 15, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(transport.requests))
+	assert.Equal(t, "http://fakeurl/v4/builds/15", transport.requests[0].URL.String())
+	assert.Equal(t, "Bearer faketoken", transport.requests[0].Header.Get("Authorization"))
+	assert.Equal(t, "sd-aws-consumer-service/dev", transport.requests[0].Header.Get("User-Agent"))
+	assert.Regexp(t, "^[0-9a-f]{32}$", transport.requests[0].Header.Get("X-Request-Id"))
+}
+
+func TestNewTransport(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	transport := newTransport(tlsConfig)
+	assert.NotNil(t, transport.Proxy)
+	assert.Equal(t, tlsConfig, transport.TLSClientConfig)
+}
+
+func TestSharedTransport(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _ := writeTestCert(t, dir, "ca")
+
+	// clients of the same tls configuration share their transport
+	first, err := New("http://fakeurl", "faketoken")
+	assert.Nil(t, err)
+	second, _ := New("http://fakeurl", "othertoken")
+	assert.Same(t, first.(SDAPI).client.HTTPClient.Transport, second.(SDAPI).client.HTTPClient.Transport)
+
+	t.Setenv(caBundleEnv, certFile)
+	third, err := New("http://fakeurl", "faketoken")
+	assert.Nil(t, err)
+	assert.NotSame(t, first.(SDAPI).client.HTTPClient.Transport, third.(SDAPI).client.HTTPClient.Transport)
+	assert.NotNil(t, third.(SDAPI).client.HTTPClient.Transport.(*http.Transport).TLSClientConfig.RootCAs)
+}
+
+func TestNewWithConfigTLSError(t *testing.T) {
+	t.Setenv(caBundleEnv, filepath.Join(t.TempDir(), "missing.pem"))
+	_, err := NewWithConfig("http://fakeurl", "faketoken", ConfigFromEnv())
+	assert.True(t, errors.Is(err, os.ErrNotExist), err)
+	assert.Contains(t, err.Error(), "configuring tls: reading ca bundle")
+}
+
+func TestNewRequestID(t *testing.T) {
+	first := newRequestID()
+	second := newRequestID()
+	assert.Equal(t, 32, len(first))
+	assert.NotEqual(t, first, second)
+}
+
+func TestGetBuildSecrets(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		body       string
+		expected   []Secret
+		err        error
+	}{
+		{200, `[{"id":1,"pipelineId":12,"name":"NPM_TOKEN","value":"abc","allowInPR":false}]`, []Secret{{ID: 1, PipelineID: 12, Name: "NPM_TOKEN", Value: "abc"}}, nil},
+		{200, `[]`, []Secret{}, nil},
+		{404, `{"statusCode":404,"error":"Not Found","message":"Build does not exist"}`, nil, errors.New("Getting Build Secrets: WARNING: received response 404 from http://fakeurl/v4/builds/15/secrets ")},
+		{200, `not json`, nil, errors.New("Parsing JSON for Build Secrets: invalid character 'o' in literal null (expecting 'u')")},
+	}
+
+	for _, test := range tests {
+		client := makeRetryableHTTPClient(testMaxRetries, testRetryWaitMin, testRetryWaitMax, testHTTPTimeout)
+		client.HTTPClient = makeValidatedFakeHTTPClient(t, test.statusCode, test.body, func(r *http.Request) {
+			assert.Equal(t, "GET", r.Method)
+			assert.Equal(t, "/v4/builds/15/secrets", r.URL.Path)
+		})
+		testAPI := SDAPI{"http://fakeurl", "faketoken", client}
+		secrets, err := testAPI.GetBuildSecrets(15)
+
+		assert.Equal(t, test.err, err)
+		assert.Equal(t, test.expected, secrets)
+	}
+}
+
+func TestUpdateBuildMeta(t *testing.T) {
+	tests := []struct {
+		meta       map[string]interface{}
+		statusCode int
+		want       string
+		err        error
+	}{
+		{map[string]interface{}{"aws": map[string]interface{}{"region": "us-west-2"}}, 200, `{"meta":{"aws":{"region":"us-west-2"}}}`, nil},
+		{map[string]interface{}{}, 200, "", errors.New("meta value is empty or invalid: map[]")},
+		{map[string]interface{}{"foo": "bar"}, 403, `{"meta":{"foo":"bar"}}`, errors.New("Posting to Build Meta: WARNING: received response 403 from http://fakeurl/v4/builds/15 ")},
+	}
+
+	for _, test := range tests {
+		client := makeRetryableHTTPClient(testMaxRetries, testRetryWaitMin, testRetryWaitMax, testHTTPTimeout)
+		client.HTTPClient = makeValidatedFakeHTTPClient(t, test.statusCode, "{}", func(r *http.Request) {
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(r.Body)
+			assert.Equal(t, "PUT", r.Method)
+			assert.Equal(t, test.want, buf.String())
+		})
+		testAPI := SDAPI{"http://fakeurl", "faketoken", client}
+		err := testAPI.UpdateBuildMeta(test.meta, 15)
+		assert.Equal(t, test.err, err)
+	}
+}
+
+func TestPostArtifactManifestAndCoverage(t *testing.T) {
+	var bodies []string
+	client := makeRetryableHTTPClient(testMaxRetries, testRetryWaitMin, testRetryWaitMax, testHTTPTimeout)
+	client.HTTPClient = makeValidatedFakeHTTPClient(t, 200, "{}", func(r *http.Request) {
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		bodies = append(bodies, buf.String())
+	})
+	testAPI := SDAPI{"http://fakeurl", "faketoken", client}
+
+	err := testAPI.PostArtifactManifest(ArtifactManifest{
+		Location: "s3://test-bucket/builds/15",
+		Files:    []ArtifactFile{{Path: "report.html", Size: 42}},
+	}, 15)
+	assert.Nil(t, err)
+	err = testAPI.PostCoverageSummary(CoverageSummary{Coverage: "85.2", Results: "10/11"}, 15)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		`{"meta":{"aws":{"artifacts":{"location":"s3://test-bucket/builds/15","files":[{"path":"report.html","size":42}]}}}}`,
+		`{"meta":{"tests":{"coverage":"85.2","results":"10/11"}}}`,
+	}, bodies)
+
+	assert.Equal(t, errors.New("artifact location is empty or invalid: "), testAPI.PostArtifactManifest(ArtifactManifest{}, 15))
+	assert.Equal(t, errors.New("coverage value is empty or invalid: "), testAPI.PostCoverageSummary(CoverageSummary{}, 15))
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		statusCode    int
+		contentType   string
+		body          string
+		contentLength int64
+		err           error
+	}{
+		{name: "json body", contentType: "application/json; charset=utf-8", body: `{"id":1}`},
+		{name: "empty body without content type", body: ""},
+		{name: "html body", contentType: "text/html", body: "<html></html>", err: errors.New(`unexpected content type "text/html"`)},
+		{name: "html error body", statusCode: 502, contentType: "text/html", body: "<html>Bad Gateway</html>"},
+		{name: "declared length too large", contentType: "application/json", body: "{}", contentLength: maxResponseBytes + 1, err: fmt.Errorf("response size %d exceeds limit of %d bytes", maxResponseBytes+1, maxResponseBytes)},
+		{name: "streamed body too large", contentType: "application/json", body: strings.Repeat("a", maxResponseBytes+1), contentLength: -1, err: fmt.Errorf("response exceeds limit of %d bytes", maxResponseBytes)},
+	}
+	for _, test := range tests {
+		if test.statusCode == 0 {
+			test.statusCode = 200
+		}
+		res := &http.Response{
+			StatusCode:    test.statusCode,
+			Header:        http.Header{"Content-Type": []string{test.contentType}},
+			Body:          io.NopCloser(strings.NewReader(test.body)),
+			ContentLength: test.contentLength,
+		}
+		body, err := readBody(res)
+		assert.Equal(t, test.err, err, test.name)
+		if err == nil {
+			assert.Equal(t, test.body, string(body), test.name)
+		}
+	}
+}
+
+func TestErrorResponseWithoutJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(403)
+		fmt.Fprintln(w, "<html>Forbidden</html>")
+	}))
+	defer server.Close()
+	transport := &http.Transport{Proxy: func(req *http.Request) (*url.URL, error) { return url.Parse(server.URL) }}
+
+	client := makeRetryableHTTPClient(testMaxRetries, testRetryWaitMin, testRetryWaitMax, testHTTPTimeout)
+	client.HTTPClient = &http.Client{Transport: transport}
+	testAPI := SDAPI{"http://fakeurl", "faketoken", client}
+	// the status of the response is reported, not its content type
+	err := testAPI.UpdateBuildStatus(Aborted, 15, "")
+	assert.Equal(t, errors.New("Posting to Build Status: WARNING: received response 403 from http://fakeurl/v4/builds/15 "), err)
+}
+
+func TestUpdateBuildStatus(t *testing.T) {
+	tests := []struct {
+		status        BuildStatus
+		statusMessage string
+		statusCode    int
+		want          string
+		err           error
+	}{
+		{Failure, "Rejected by policy", 200, `{"status":"FAILURE","statusMessage":"Rejected by policy"}`, nil},
+		{"", "", 200, "", errors.New("status value is empty or invalid: ")},
+		{Aborted, "", 403, `{"status":"ABORTED"}`, errors.New("Posting to Build Status: WARNING: received response 403 from http://fakeurl/v4/builds/15 ")},
+	}
+
+	for _, test := range tests {
+		client := makeRetryableHTTPClient(testMaxRetries, testRetryWaitMin, testRetryWaitMax, testHTTPTimeout)
+		client.HTTPClient = makeValidatedFakeHTTPClient(t, test.statusCode, "{}", func(r *http.Request) {
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(r.Body)
+			assert.Equal(t, test.want, buf.String())
+		})
+		testAPI := SDAPI{"http://fakeurl", "faketoken", client}
+		err := testAPI.UpdateBuildStatus(test.status, 15, test.statusMessage)
+		assert.Equal(t, test.err, err)
+	}
 }
@@ -0,0 +1,201 @@
+// Package sdtest provides a configurable in-memory fake of the Screwdriver API for tests
+package sdtest
+
+import (
+	"sync"
+
+	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+)
+
+// UpdateBuildCall records the arguments of an UpdateBuild call
+type UpdateBuildCall struct {
+	Stats         map[string]interface{}
+	BuildID       int
+	StatusMessage string
+}
+
+// FakeAPI is an in-memory implementation of the screwdriver API interface
+type FakeAPI struct {
+	// APIURL is returned by GetAPIURL
+	APIURL string
+	// UpdateBuildErr is returned by UpdateBuild when set
+	UpdateBuildErr error
+	// Secrets are returned by GetBuildSecrets keyed by build id
+	Secrets map[int][]sd.Secret
+	// GetBuildSecretsErr is returned by GetBuildSecrets when set
+	GetBuildSecretsErr error
+	// UpdateBuildStatusErr is returned by UpdateBuildStatus when set
+	UpdateBuildStatusErr error
+	// UpdateBuildMetaErr is returned by the meta methods when set
+	UpdateBuildMetaErr error
+
+	mu               sync.Mutex
+	updateBuildCalls []UpdateBuildCall
+	statusCalls      []UpdateBuildStatusCall
+	metaCalls        []UpdateBuildMetaCall
+}
+
+// UpdateBuildStatusCall records the arguments of an UpdateBuildStatus call
+type UpdateBuildStatusCall struct {
+	Status        sd.BuildStatus
+	BuildID       int
+	StatusMessage string
+}
+
+// UpdateBuildMetaCall records the arguments of a meta update
+type UpdateBuildMetaCall struct {
+	Meta    map[string]interface{}
+	BuildID int
+}
+
+var _ sd.API = (*FakeAPI)(nil)
+
+// New returns a new fake API
+func New() *FakeAPI {
+	return &FakeAPI{
+		APIURL:  "https://api.screwdriver.cd/v4/",
+		Secrets: map[int][]sd.Secret{},
+	}
+}
+
+// Factory returns a constructor with the signature of screwdriver.New which always returns the fake
+func (f *FakeAPI) Factory() func(url, token string) (sd.API, error) {
+	return func(url, token string) (sd.API, error) {
+		return f, nil
+	}
+}
+
+// UpdateBuild records the call and returns UpdateBuildErr
+func (f *FakeAPI) UpdateBuild(stats map[string]interface{}, buildID int, statusMessage string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updateBuildCalls = append(f.updateBuildCalls, UpdateBuildCall{
+		Stats:         stats,
+		BuildID:       buildID,
+		StatusMessage: statusMessage,
+	})
+	return f.UpdateBuildErr
+}
+
+// UpdateBuildStatus records the call and returns UpdateBuildStatusErr
+func (f *FakeAPI) UpdateBuildStatus(status sd.BuildStatus, buildID int, statusMessage string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statusCalls = append(f.statusCalls, UpdateBuildStatusCall{
+		Status:        status,
+		BuildID:       buildID,
+		StatusMessage: statusMessage,
+	})
+	return f.UpdateBuildStatusErr
+}
+
+// UpdateBuildStatusCalls returns the recorded UpdateBuildStatus calls
+func (f *FakeAPI) UpdateBuildStatusCalls() []UpdateBuildStatusCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]UpdateBuildStatusCall(nil), f.statusCalls...)
+}
+
+// GetAPIURL returns APIURL
+func (f *FakeAPI) GetAPIURL() (string, error) {
+	return f.APIURL, nil
+}
+
+// GetBuildSecrets returns the configured secrets of the build
+func (f *FakeAPI) GetBuildSecrets(buildID int) ([]sd.Secret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.GetBuildSecretsErr != nil {
+		return nil, f.GetBuildSecretsErr
+	}
+	return f.Secrets[buildID], nil
+}
+
+// UpdateBuildMeta records the call and returns UpdateBuildMetaErr
+func (f *FakeAPI) UpdateBuildMeta(meta map[string]interface{}, buildID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.metaCalls = append(f.metaCalls, UpdateBuildMetaCall{Meta: meta, BuildID: buildID})
+	return f.UpdateBuildMetaErr
+}
+
+// PostArtifactManifest records the manifest as a meta update
+func (f *FakeAPI) PostArtifactManifest(manifest sd.ArtifactManifest, buildID int) error {
+	return f.UpdateBuildMeta(map[string]interface{}{
+		"aws": map[string]interface{}{"artifacts": manifest},
+	}, buildID)
+}
+
+// PostCoverageSummary records the summary as a meta update
+func (f *FakeAPI) PostCoverageSummary(summary sd.CoverageSummary, buildID int) error {
+	return f.UpdateBuildMeta(map[string]interface{}{"tests": summary}, buildID)
+}
+
+// UpdateBuildMetaCalls returns the recorded meta updates
+func (f *FakeAPI) UpdateBuildMetaCalls() []UpdateBuildMetaCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]UpdateBuildMetaCall(nil), f.metaCalls...)
+}
+
+// UpdateBuildCalls returns the recorded UpdateBuild calls
+func (f *FakeAPI) UpdateBuildCalls() []UpdateBuildCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]UpdateBuildCall(nil), f.updateBuildCalls...)
+}
+
+// Reset clears all recorded calls
+func (f *FakeAPI) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updateBuildCalls = nil
+	f.statusCalls = nil
+	f.metaCalls = nil
+}
+
+// UploadArtifactCall records the arguments of an UploadArtifact call
+type UploadArtifactCall struct {
+	BuildID     int
+	Name        string
+	ContentType string
+	Body        string
+}
+
+// FakeStore is an in-memory implementation of the screwdriver store interface
+type FakeStore struct {
+	// UploadArtifactErr is returned by UploadArtifact when set
+	UploadArtifactErr error
+
+	mu    sync.Mutex
+	calls []UploadArtifactCall
+}
+
+var _ sd.Store = (*FakeStore)(nil)
+
+// NewStore returns a new fake store
+func NewStore() *FakeStore {
+	return &FakeStore{}
+}
+
+// Factory returns a constructor with the signature of screwdriver.NewStore which always returns the fake
+func (f *FakeStore) Factory() func(url, token string) (sd.Store, error) {
+	return func(url, token string) (sd.Store, error) {
+		return f, nil
+	}
+}
+
+// UploadArtifact records the call and returns UploadArtifactErr
+func (f *FakeStore) UploadArtifact(buildID int, name, contentType string, body []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, UploadArtifactCall{BuildID: buildID, Name: name, ContentType: contentType, Body: string(body)})
+	return f.UploadArtifactErr
+}
+
+// UploadArtifactCalls returns the recorded UploadArtifact calls
+func (f *FakeStore) UploadArtifactCalls() []UploadArtifactCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]UploadArtifactCall(nil), f.calls...)
+}
@@ -0,0 +1,77 @@
+package sdtest
+
+import (
+	"errors"
+	"testing"
+
+	sd "github.com/screwdriver-cd/aws-consumer-service/screwdriver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeAPI(t *testing.T) {
+	fake := New()
+	api, err := fake.Factory()("https://api.screwdriver.cd", "token")
+	assert.Nil(t, err)
+
+	url, _ := api.GetAPIURL()
+	assert.Equal(t, "https://api.screwdriver.cd/v4/", url)
+
+	assert.Nil(t, api.UpdateBuild(map[string]interface{}{"hostname": "node123"}, 15, ""))
+	fake.UpdateBuildErr = errors.New("api down")
+	assert.Equal(t, fake.UpdateBuildErr, api.UpdateBuild(map[string]interface{}{"hostname": "node456"}, 16, "failed"))
+
+	calls := fake.UpdateBuildCalls()
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, UpdateBuildCall{Stats: map[string]interface{}{"hostname": "node123"}, BuildID: 15}, calls[0])
+	assert.Equal(t, "failed", calls[1].StatusMessage)
+
+	fake.Reset()
+	assert.Equal(t, 0, len(fake.UpdateBuildCalls()))
+}
+
+func TestFakeAPIGetBuildSecrets(t *testing.T) {
+	fake := New()
+	fake.Secrets[15] = []sd.Secret{{Name: "NPM_TOKEN", Value: "abc"}}
+
+	secrets, err := fake.GetBuildSecrets(15)
+	assert.Nil(t, err)
+	assert.Equal(t, "NPM_TOKEN", secrets[0].Name)
+
+	fake.GetBuildSecretsErr = errors.New("forbidden")
+	_, err = fake.GetBuildSecrets(15)
+	assert.Equal(t, fake.GetBuildSecretsErr, err)
+}
+
+func TestFakeAPIMeta(t *testing.T) {
+	fake := New()
+
+	assert.Nil(t, fake.PostCoverageSummary(sd.CoverageSummary{Coverage: "80"}, 15))
+	assert.Nil(t, fake.UpdateBuildMeta(map[string]interface{}{"foo": "bar"}, 15))
+
+	calls := fake.UpdateBuildMetaCalls()
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, map[string]interface{}{"tests": sd.CoverageSummary{Coverage: "80"}}, calls[0].Meta)
+	assert.Equal(t, map[string]interface{}{"foo": "bar"}, calls[1].Meta)
+}
+
+func TestFakeAPIUpdateBuildStatus(t *testing.T) {
+	fake := New()
+
+	assert.Nil(t, fake.UpdateBuildStatus(sd.Failure, 15, "rejected"))
+	assert.Equal(t, []UpdateBuildStatusCall{{Status: sd.Failure, BuildID: 15, StatusMessage: "rejected"}}, fake.UpdateBuildStatusCalls())
+}
+
+func TestFakeStore(t *testing.T) {
+	fake := NewStore()
+	store, err := fake.Factory()("https://store.screwdriver.cd", "token")
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.UploadArtifact(15, "aws-executor.log", "text/plain", []byte("logs")))
+	fake.UploadArtifactErr = errors.New("store down")
+	assert.Equal(t, fake.UploadArtifactErr, store.UploadArtifact(16, "aws-executor.log", "text/plain", nil))
+
+	assert.Equal(t, []UploadArtifactCall{
+		{BuildID: 15, Name: "aws-executor.log", ContentType: "text/plain", Body: "logs"},
+		{BuildID: 16, Name: "aws-executor.log", ContentType: "text/plain"},
+	}, fake.UploadArtifactCalls())
+}
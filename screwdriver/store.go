@@ -0,0 +1,48 @@
+package screwdriver
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+)
+
+// Store interface definition of the SD store
+type Store interface {
+	UploadArtifact(buildID int, name, contentType string, body []byte) error
+}
+
+// SDStore structure definition, it shares the request handling of the SD API client
+type SDStore struct {
+	api SDAPI
+}
+
+// NewStore returns a new Store object for the SD store authenticated with the build token
+func NewStore(storeURL, token string) (Store, error) {
+	api, err := NewWithConfig(storeURL, token, ConfigFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	return SDStore{api.(SDAPI)}, nil
+}
+
+func (s SDStore) makeURL(path string) (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("%s/v1/%s", s.api.baseURL, path))
+}
+
+// UploadArtifact function calls sd store to upload a file into the artifacts of a build
+func (s SDStore) UploadArtifact(buildID int, name, contentType string, body []byte) error {
+	if name == "" {
+		return fmt.Errorf("artifact name is empty or invalid: %v", name)
+	}
+	u, err := s.makeURL(fmt.Sprintf("builds/%d/ARTIFACTS/%s", buildID, url.PathEscape(name)))
+	if err != nil {
+		return fmt.Errorf("creating url: %v", err)
+	}
+
+	_, err = s.api.put(u, contentType, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Uploading Build Artifact: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package screwdriver
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadArtifact(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		err        error
+	}{
+		{"aws-executor.log", 202, nil},
+		{"", 202, errors.New("artifact name is empty or invalid: ")},
+		{"aws-executor.log", 403, errors.New("Uploading Build Artifact: WARNING: received response 403 from http://fakeurl/v1/builds/15/ARTIFACTS/aws-executor.log ")},
+	}
+
+	for _, test := range tests {
+		client := makeRetryableHTTPClient(testMaxRetries, testRetryWaitMin, testRetryWaitMax, testHTTPTimeout)
+		client.HTTPClient = makeValidatedFakeHTTPClient(t, test.statusCode, "{}", func(r *http.Request) {
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(r.Body)
+			assert.Equal(t, "PUT", r.Method)
+			assert.Equal(t, "/v1/builds/15/ARTIFACTS/aws-executor.log", r.URL.Path)
+			assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+			assert.Equal(t, "pod pending", buf.String())
+		})
+		testStore := SDStore{SDAPI{"http://fakeurl", "faketoken", client}}
+		err := testStore.UploadArtifact(15, test.name, "text/plain", []byte("pod pending"))
+		assert.Equal(t, test.err, err)
+	}
+}
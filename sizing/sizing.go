@@ -0,0 +1,284 @@
+// Package sizing maps executor independent build sizes to the compute settings of each executor
+package sizing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/codebuild"
+
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+)
+
+const (
+	// SizeField selects a named size in the provider
+	SizeField = "size"
+	// CPUField overrides the vCPUs of the size in the provider
+	CPUField = "cpu"
+	// MemoryField overrides the memory of the size in MiB in the provider
+	MemoryField = "memory"
+	// DiskField sets the disk space of the build in GiB in the provider
+	DiskField = "disk"
+)
+
+// Size is the cpu and memory of a build
+type Size struct {
+	Name string
+	// CPU is the number of vCPUs
+	CPU float64
+	// MemoryMiB is the memory in MiB
+	MemoryMiB int64
+	// DiskGiB is the disk space in GiB, zero for the executor default
+	DiskGiB int64
+}
+
+// named sizes, roughly following the codebuild compute types
+var sizes = map[string]Size{
+	"micro":  {Name: "micro", CPU: 1, MemoryMiB: 2048},
+	"small":  {Name: "small", CPU: 2, MemoryMiB: 3072},
+	"medium": {Name: "medium", CPU: 4, MemoryMiB: 7168},
+	"large":  {Name: "large", CPU: 8, MemoryMiB: 15360},
+	"xlarge": {Name: "xlarge", CPU: 16, MemoryMiB: 30720},
+}
+
+// Names returns the named sizes from the smallest to the largest
+func Names() []string {
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return sizes[names[i]].CPU < sizes[names[j]].CPU })
+	return names
+}
+
+// Get returns the named size
+func Get(name string) (Size, error) {
+	size, ok := sizes[name]
+	if !ok {
+		return Size{}, fmt.Errorf("unknown size %q, valid sizes are %s", name, strings.Join(Names(), ", "))
+	}
+	return size, nil
+}
+
+// gets a positive number of the provider
+func number(provider map[string]interface{}, key string) (float64, bool, error) {
+	value, ok := provider[key]
+	if !ok || value == nil {
+		return 0, false, nil
+	}
+	n, isNumber := value.(json.Number)
+	if !isNumber {
+		return 0, false, fmt.Errorf("%s must be a number", key)
+	}
+	f, err := n.Float64()
+	if err != nil || f <= 0 {
+		return 0, false, fmt.Errorf("%s must be a positive number", key)
+	}
+	return f, true, nil
+}
+
+// FromProvider gets the size of the provider, a named size with optional cpu and memory overrides.
+// ok is false when the provider sets none of them.
+func FromProvider(provider map[string]interface{}) (size Size, ok bool, err error) {
+	if name, _ := provider[SizeField].(string); name != "" {
+		if size, err = Get(name); err != nil {
+			return Size{}, false, err
+		}
+		ok = true
+	}
+	cpu, hasCPU, err := number(provider, CPUField)
+	if err != nil {
+		return Size{}, false, err
+	}
+	memory, hasMemory, err := number(provider, MemoryField)
+	if err != nil {
+		return Size{}, false, err
+	}
+	disk, hasDisk, err := number(provider, DiskField)
+	if err != nil {
+		return Size{}, false, err
+	}
+	if !ok && (hasCPU || hasMemory || hasDisk) {
+		// custom numbers start from the smallest size
+		size, ok = sizes["micro"], true
+		size.Name = "custom"
+	}
+	if hasCPU {
+		size.CPU = cpu
+	}
+	if hasMemory {
+		size.MemoryMiB = int64(math.Ceil(memory))
+	}
+	if hasDisk {
+		size.DiskGiB = int64(math.Ceil(disk))
+	}
+	return size, ok, nil
+}
+
+const (
+	// computeTypeBuildGeneral1Xlarge is not an enum value of the sdk yet
+	computeTypeBuildGeneral1Xlarge = "BUILD_GENERAL1_XLARGE"
+	// lambdaComputePrefix prefixes the codebuild compute types running builds on lambda, which sizes do not apply to
+	lambdaComputePrefix = "BUILD_LAMBDA_"
+)
+
+// codebuild linux container compute types from the smallest to the largest
+var computeTypes = []Size{
+	{Name: codebuild.ComputeTypeBuildGeneral1Small, CPU: 2, MemoryMiB: 3072, DiskGiB: 64},
+	{Name: codebuild.ComputeTypeBuildGeneral1Medium, CPU: 4, MemoryMiB: 7168, DiskGiB: 128},
+	{Name: codebuild.ComputeTypeBuildGeneral1Large, CPU: 8, MemoryMiB: 15360, DiskGiB: 128},
+	{Name: computeTypeBuildGeneral1Xlarge, CPU: 36, MemoryMiB: 71680, DiskGiB: 256},
+	{Name: codebuild.ComputeTypeBuildGeneral12xlarge, CPU: 72, MemoryMiB: 148480, DiskGiB: 824},
+}
+
+// codebuild arm container compute types from the smallest to the largest
+var armComputeTypes = []Size{
+	{Name: codebuild.ComputeTypeBuildGeneral1Small, CPU: 2, MemoryMiB: 4096, DiskGiB: 64},
+	{Name: codebuild.ComputeTypeBuildGeneral1Large, CPU: 8, MemoryMiB: 16384, DiskGiB: 128},
+}
+
+// CodeBuildComputeType returns the smallest codebuild compute type of the architecture fitting the size
+func (s Size) CodeBuildComputeType(arch string) string {
+	types := computeTypes
+	if arch == launcher.ARM64 {
+		types = armComputeTypes
+	}
+	for _, computeType := range types {
+		if computeType.CPU >= s.CPU && computeType.MemoryMiB >= s.MemoryMiB && computeType.DiskGiB >= s.DiskGiB {
+			return computeType.Name
+		}
+	}
+	return types[len(types)-1].Name
+}
+
+// KubernetesLimits returns the cpu and memory resource quantities of the size
+func (s Size) KubernetesLimits() (cpu string, memory string) {
+	return strconv.FormatFloat(s.CPU, 'f', -1, 64), fmt.Sprintf("%dMi", s.MemoryMiB)
+}
+
+// KubernetesDisk returns the ephemeral storage quantity of the size, empty for the node default
+func (s Size) KubernetesDisk() string {
+	if s.DiskGiB == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dGi", s.DiskGiB)
+}
+
+// fargate cpu units with their memory range in MiB
+var fargateSizes = []struct {
+	cpu, minMemory, maxMemory int64
+}{
+	{256, 512, 2048},
+	{512, 1024, 4096},
+	{1024, 2048, 8192},
+	{2048, 4096, 16384},
+	{4096, 8192, 30720},
+	{8192, 16384, 61440},
+	{16384, 32768, 122880},
+}
+
+// ECSTaskSize returns the smallest fargate task cpu units and memory in MiB fitting the size
+func (s Size) ECSTaskSize() (cpu string, memory string) {
+	cpuUnits := int64(math.Ceil(s.CPU * 1024))
+	// fargate memory is set in GiB steps
+	memoryMiB := int64(math.Ceil(float64(s.MemoryMiB)/1024)) * 1024
+	for _, f := range fargateSizes {
+		if f.cpu < cpuUnits || f.maxMemory < memoryMiB {
+			continue
+		}
+		if memoryMiB < f.minMemory {
+			memoryMiB = f.minMemory
+		}
+		return strconv.FormatInt(f.cpu, 10), strconv.FormatInt(memoryMiB, 10)
+	}
+	largest := fargateSizes[len(fargateSizes)-1]
+	return strconv.FormatInt(largest.cpu, 10), strconv.FormatInt(largest.maxMemory, 10)
+}
+
+// BatchResources returns the vcpus and memory in MiB of an aws batch job definition fitting the size
+func (s Size) BatchResources() (vcpus string, memory string) {
+	return strconv.FormatFloat(math.Ceil(s.CPU), 'f', -1, 64), strconv.FormatInt(s.MemoryMiB, 10)
+}
+
+// general purpose ec2 instance sizes from the smallest to the largest, shared by the m5 and m6g families
+var instanceSizes = []Size{
+	{Name: "large", CPU: 2, MemoryMiB: 8192},
+	{Name: "xlarge", CPU: 4, MemoryMiB: 16384},
+	{Name: "2xlarge", CPU: 8, MemoryMiB: 32768},
+	{Name: "4xlarge", CPU: 16, MemoryMiB: 65536},
+	{Name: "8xlarge", CPU: 32, MemoryMiB: 131072},
+	{Name: "12xlarge", CPU: 48, MemoryMiB: 196608},
+	{Name: "16xlarge", CPU: 64, MemoryMiB: 262144},
+}
+
+// EC2InstanceType returns the smallest general purpose instance type of the architecture fitting the size
+func (s Size) EC2InstanceType(arch string) string {
+	family := "m5."
+	if arch == launcher.ARM64 {
+		family = "m6g."
+	}
+	for _, instanceSize := range instanceSizes {
+		if instanceSize.CPU >= s.CPU && instanceSize.MemoryMiB >= s.MemoryMiB {
+			return family + instanceSize.Name
+		}
+	}
+	return family + instanceSizes[len(instanceSizes)-1].Name
+}
+
+// headroom added to the peak usage of a build by Recommend
+const recommendHeadroom = 1.2
+
+// Recommend returns the size fitting the peak cpu and memory usage of a build with 20% headroom,
+// the cpu rounded up to half vCPUs and the memory to GiB
+func Recommend(usage Size) Size {
+	cpu := math.Max(math.Ceil(usage.CPU*recommendHeadroom*2)/2, 0.5)
+	memoryGiB := math.Max(math.Ceil(float64(usage.MemoryMiB)*recommendHeadroom/1024), 1)
+	return Size{Name: "recommended", CPU: cpu, MemoryMiB: int64(memoryGiB) * 1024}
+}
+
+// Apply sets the executor specific compute settings of the provider from its size,
+// they are left untouched when the provider sets no size
+func Apply(provider map[string]interface{}, executor string) error {
+	size, ok, err := FromProvider(provider)
+	if err != nil || !ok {
+		return err
+	}
+	switch executor {
+	case "sls":
+		// lambda compute is picked by its memory, not by size
+		if computeType, _ := provider["computeType"].(string); strings.HasPrefix(computeType, lambdaComputePrefix) {
+			return nil
+		}
+		arch, err := launcher.Architecture(provider)
+		if err != nil {
+			return err
+		}
+		provider["computeType"] = size.CodeBuildComputeType(arch)
+	case "eks":
+		provider["cpuLimit"], provider["memoryLimit"] = size.KubernetesLimits()
+		if disk := size.KubernetesDisk(); disk != "" {
+			provider["diskLimit"] = disk
+		}
+	case "ecs":
+		provider["taskCpu"], provider["taskMemory"] = size.ECSTaskSize()
+		if size.DiskGiB > 0 {
+			provider["taskDisk"] = strconv.FormatInt(size.DiskGiB, 10)
+		}
+	case "ec2":
+		arch, err := launcher.Architecture(provider)
+		if err != nil {
+			return err
+		}
+		provider["instanceType"] = size.EC2InstanceType(arch)
+		if size.DiskGiB > 0 {
+			provider["instanceDisk"] = strconv.FormatInt(size.DiskGiB, 10)
+		}
+	default:
+		return fmt.Errorf("executor %s does not support sizes", executor)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package sizing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/screwdriver-cd/aws-consumer-service/launcher"
+)
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, []string{"micro", "small", "medium", "large", "xlarge"}, Names())
+}
+
+func TestFromProvider(t *testing.T) {
+	size, ok, err := FromProvider(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	size, ok, err = FromProvider(map[string]interface{}{"size": "medium"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Size{Name: "medium", CPU: 4, MemoryMiB: 7168}, size)
+
+	size, _, err = FromProvider(map[string]interface{}{"size": "medium", "memory": json.Number("12288")})
+	assert.Nil(t, err)
+	assert.Equal(t, Size{Name: "medium", CPU: 4, MemoryMiB: 12288}, size)
+
+	size, _, err = FromProvider(map[string]interface{}{"cpu": json.Number("0.5")})
+	assert.Nil(t, err)
+	assert.Equal(t, Size{Name: "custom", CPU: 0.5, MemoryMiB: 2048}, size)
+
+	_, _, err = FromProvider(map[string]interface{}{"size": "huge"})
+	assert.EqualError(t, err, `unknown size "huge", valid sizes are micro, small, medium, large, xlarge`)
+	_, _, err = FromProvider(map[string]interface{}{"cpu": "2"})
+	assert.EqualError(t, err, "cpu must be a number")
+	_, _, err = FromProvider(map[string]interface{}{"memory": json.Number("-1")})
+	assert.EqualError(t, err, "memory must be a positive number")
+}
+
+func TestCodeBuildComputeType(t *testing.T) {
+	tests := map[string]string{
+		"micro":  "BUILD_GENERAL1_SMALL",
+		"small":  "BUILD_GENERAL1_SMALL",
+		"medium": "BUILD_GENERAL1_MEDIUM",
+		"large":  "BUILD_GENERAL1_LARGE",
+		"xlarge": "BUILD_GENERAL1_XLARGE",
+	}
+	for name, expected := range tests {
+		size, _ := Get(name)
+		assert.Equal(t, expected, size.CodeBuildComputeType("amd64"), name)
+	}
+	assert.Equal(t, "BUILD_GENERAL1_MEDIUM", Size{CPU: 2, MemoryMiB: 4096}.CodeBuildComputeType("amd64"))
+	assert.Equal(t, "BUILD_GENERAL1_2XLARGE", Size{CPU: 48, MemoryMiB: 4096}.CodeBuildComputeType("amd64"))
+	assert.Equal(t, "BUILD_GENERAL1_2XLARGE", Size{CPU: 96, MemoryMiB: 4096}.CodeBuildComputeType("amd64"))
+	assert.Equal(t, "BUILD_GENERAL1_XLARGE", Size{CPU: 2, MemoryMiB: 3072, DiskGiB: 200}.CodeBuildComputeType("amd64"))
+
+	// arm containers only run on the small and large compute types
+	armTests := map[string]string{
+		"micro":  "BUILD_GENERAL1_SMALL",
+		"small":  "BUILD_GENERAL1_SMALL",
+		"medium": "BUILD_GENERAL1_LARGE",
+		"large":  "BUILD_GENERAL1_LARGE",
+		"xlarge": "BUILD_GENERAL1_LARGE",
+	}
+	for name, expected := range armTests {
+		size, _ := Get(name)
+		assert.Equal(t, expected, size.CodeBuildComputeType("arm64"), name)
+	}
+	assert.Equal(t, "BUILD_GENERAL1_SMALL", Size{CPU: 2, MemoryMiB: 4096}.CodeBuildComputeType("arm64"))
+}
+
+func TestKubernetesLimits(t *testing.T) {
+	cpu, memory := Size{CPU: 0.5, MemoryMiB: 2048}.KubernetesLimits()
+	assert.Equal(t, "0.5", cpu)
+	assert.Equal(t, "2048Mi", memory)
+	assert.Equal(t, "", Size{CPU: 1}.KubernetesDisk())
+	assert.Equal(t, "128Gi", Size{CPU: 1, DiskGiB: 128}.KubernetesDisk())
+}
+
+func TestECSTaskSize(t *testing.T) {
+	tests := []struct {
+		size   Size
+		cpu    string
+		memory string
+	}{
+		{Size{CPU: 1, MemoryMiB: 2048}, "1024", "2048"},
+		{Size{CPU: 2, MemoryMiB: 3072}, "2048", "4096"},
+		{Size{CPU: 0.5, MemoryMiB: 3000}, "512", "3072"},
+		{Size{CPU: 1, MemoryMiB: 10240}, "2048", "10240"},
+		{Size{CPU: 16, MemoryMiB: 30720}, "16384", "32768"},
+		{Size{CPU: 64, MemoryMiB: 30720}, "16384", "122880"},
+	}
+	for _, test := range tests {
+		cpu, memory := test.size.ECSTaskSize()
+		assert.Equal(t, test.cpu, cpu, "%v", test.size)
+		assert.Equal(t, test.memory, memory, "%v", test.size)
+	}
+}
+
+func TestEC2InstanceType(t *testing.T) {
+	assert.Equal(t, "m5.large", Size{CPU: 1, MemoryMiB: 2048}.EC2InstanceType(launcher.AMD64))
+	assert.Equal(t, "m5.2xlarge", Size{CPU: 4, MemoryMiB: 20480}.EC2InstanceType(launcher.AMD64))
+	assert.Equal(t, "m6g.xlarge", Size{CPU: 4, MemoryMiB: 7168}.EC2InstanceType(launcher.ARM64))
+	assert.Equal(t, "m5.16xlarge", Size{CPU: 128, MemoryMiB: 2048}.EC2InstanceType(launcher.AMD64))
+}
+
+func TestBatchResources(t *testing.T) {
+	vcpus, memory := Size{CPU: 0.5, MemoryMiB: 3072}.BatchResources()
+	assert.Equal(t, "1", vcpus)
+	assert.Equal(t, "3072", memory)
+}
+
+func TestRecommend(t *testing.T) {
+	assert.Equal(t, Size{Name: "recommended", CPU: 1.5, MemoryMiB: 4096}, Recommend(Size{CPU: 1.2, MemoryMiB: 3100}))
+	assert.Equal(t, Size{Name: "recommended", CPU: 0.5, MemoryMiB: 1024}, Recommend(Size{CPU: 0.01, MemoryMiB: 100}))
+	assert.Equal(t, Size{Name: "recommended", CPU: 5, MemoryMiB: 10240}, Recommend(Size{CPU: 4, MemoryMiB: 8192}))
+}
+
+func TestApply(t *testing.T) {
+	provider := map[string]interface{}{"size": "large", "computeType": "BUILD_GENERAL1_SMALL"}
+	assert.Nil(t, Apply(provider, "sls"))
+	assert.Equal(t, "BUILD_GENERAL1_LARGE", provider["computeType"])
+
+	provider = map[string]interface{}{"size": "medium", "environmentType": "ARM_CONTAINER"}
+	assert.Nil(t, Apply(provider, "sls"))
+	assert.Equal(t, "BUILD_GENERAL1_LARGE", provider["computeType"])
+
+	// lambda compute types are kept
+	provider = map[string]interface{}{"size": "large", "computeType": "BUILD_LAMBDA_4GB"}
+	assert.Nil(t, Apply(provider, "sls"))
+	assert.Equal(t, "BUILD_LAMBDA_4GB", provider["computeType"])
+
+	provider = map[string]interface{}{"size": "small", "cpu": json.Number("1.5")}
+	assert.Nil(t, Apply(provider, "eks"))
+	assert.Equal(t, "1.5", provider["cpuLimit"])
+	assert.Equal(t, "3072Mi", provider["memoryLimit"])
+
+	provider = map[string]interface{}{"size": "small", "disk": json.Number("100")}
+	assert.Nil(t, Apply(provider, "eks"))
+	assert.Equal(t, "100Gi", provider["diskLimit"])
+
+	provider = map[string]interface{}{"cpuLimit": "2", "memoryLimit": "4Gi"}
+	assert.Nil(t, Apply(provider, "eks"))
+	assert.Equal(t, "2", provider["cpuLimit"])
+
+	provider = map[string]interface{}{"size": "small", "disk": json.Number("100")}
+	assert.Nil(t, Apply(provider, "ecs"))
+	assert.Equal(t, "2048", provider["taskCpu"])
+	assert.Equal(t, "4096", provider["taskMemory"])
+	assert.Equal(t, "100", provider["taskDisk"])
+
+	provider = map[string]interface{}{"size": "medium", "disk": json.Number("200")}
+	assert.Nil(t, Apply(provider, "ec2"))
+	assert.Equal(t, "m5.xlarge", provider["instanceType"])
+	assert.Equal(t, "200", provider["instanceDisk"])
+
+	provider = map[string]interface{}{"size": "xlarge", "architecture": "arm64"}
+	assert.Nil(t, Apply(provider, "ec2"))
+	assert.Equal(t, "m6g.4xlarge", provider["instanceType"])
+
+	assert.EqualError(t, Apply(map[string]interface{}{"size": "small"}, "batch"), "executor batch does not support sizes")
+	assert.EqualError(t, Apply(map[string]interface{}{"size": "tiny"}, "sls"), `unknown size "tiny", valid sizes are micro, small, medium, large, xlarge`)
+}
@@ -0,0 +1,79 @@
+// Package subnet selects the subnet of a vpc build to spread builds across availability zones
+package subnet
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// RoundRobin spreads builds over the subnets by build id
+	RoundRobin = "round-robin"
+	// MostFreeIPs picks the subnet with the most available ip addresses
+	MostFreeIPs = "most-free-ips"
+	// strategyEnv is the default strategy when the provider vpc config has no subnetStrategy
+	strategyEnv = "SD_SUBNET_STRATEGY"
+)
+
+// Strategy gets the subnet strategy of the provider vpc config, falling back to SD_SUBNET_STRATEGY
+func Strategy(vpc map[string]interface{}) string {
+	if strategy, _ := vpc["subnetStrategy"].(string); strategy != "" {
+		return strategy
+	}
+	return os.Getenv(strategyEnv)
+}
+
+// IDs gets the subnet ids of the provider vpc config
+func IDs(vpc map[string]interface{}) []string {
+	subnets, _ := vpc["subnetIds"].([]interface{})
+
+	var subnetIDs []string
+	for _, sn := range subnets {
+		if id, ok := sn.(string); ok && id != "" {
+			subnetIDs = append(subnetIDs, id)
+		}
+	}
+	return subnetIDs
+}
+
+// Select describes the subnets and returns the one picked by the strategy
+func Select(client ec2iface.EC2API, strategy string, subnetIDs []string, buildID int64) (*ec2.Subnet, error) {
+	if strategy != RoundRobin && strategy != MostFreeIPs {
+		return nil, fmt.Errorf("unknown subnet strategy %q", strategy)
+	}
+	if len(subnetIDs) == 0 {
+		return nil, fmt.Errorf("no subnets to select from")
+	}
+	result, err := client.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnetIDs)})
+	if err != nil {
+		return nil, fmt.Errorf("Error-DescribeSubnets: %v", err)
+	}
+	subnets := result.Subnets
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("subnets %v not found", subnetIDs)
+	}
+	// describe order is not guaranteed, sort to keep round robin stable
+	sort.Slice(subnets, func(i, j int) bool {
+		return aws.StringValue(subnets[i].SubnetId) < aws.StringValue(subnets[j].SubnetId)
+	})
+
+	if strategy == RoundRobin {
+		if buildID < 0 {
+			buildID = -buildID
+		}
+		return subnets[buildID%int64(len(subnets))], nil
+	}
+
+	selected := subnets[0]
+	for _, s := range subnets[1:] {
+		if aws.Int64Value(s.AvailableIpAddressCount) > aws.Int64Value(selected.AvailableIpAddressCount) {
+			selected = s
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,85 @@
+package subnet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockEC2Client struct {
+	ec2iface.EC2API
+	mock.Mock
+}
+
+func (m *mockEC2Client) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ec2.DescribeSubnetsOutput), args.Error(1)
+}
+
+var testSubnetIDs = []string{"subnet-3333", "subnet-1111", "subnet-2222"}
+
+func newMockClient(err error) *mockEC2Client {
+	client := new(mockEC2Client)
+	client.On("DescribeSubnets", &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(testSubnetIDs)}).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-2222"), AvailabilityZone: aws.String("us-west-2b"), AvailableIpAddressCount: aws.Int64(200)},
+		{SubnetId: aws.String("subnet-3333"), AvailabilityZone: aws.String("us-west-2c"), AvailableIpAddressCount: aws.Int64(10)},
+		{SubnetId: aws.String("subnet-1111"), AvailabilityZone: aws.String("us-west-2a"), AvailableIpAddressCount: aws.Int64(3)},
+	}}, err)
+	return client
+}
+
+func TestStrategy(t *testing.T) {
+	t.Setenv("SD_SUBNET_STRATEGY", "")
+	assert.Equal(t, "", Strategy(map[string]interface{}{}))
+	assert.Equal(t, RoundRobin, Strategy(map[string]interface{}{"subnetStrategy": RoundRobin}))
+
+	t.Setenv("SD_SUBNET_STRATEGY", MostFreeIPs)
+	assert.Equal(t, MostFreeIPs, Strategy(nil))
+	assert.Equal(t, RoundRobin, Strategy(map[string]interface{}{"subnetStrategy": RoundRobin}))
+}
+
+func TestIDs(t *testing.T) {
+	vpc := map[string]interface{}{"subnetIds": []interface{}{"subnet-1111", "", "subnet-2222"}}
+	assert.Equal(t, []string{"subnet-1111", "subnet-2222"}, IDs(vpc))
+	assert.Nil(t, IDs(nil))
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		message  string
+		strategy string
+		buildID  int64
+		expected string
+	}{
+		{message: "most free ips", strategy: MostFreeIPs, buildID: 1, expected: "subnet-2222"},
+		{message: "round robin first", strategy: RoundRobin, buildID: 3, expected: "subnet-1111"},
+		{message: "round robin second", strategy: RoundRobin, buildID: 4, expected: "subnet-2222"},
+		{message: "round robin third", strategy: RoundRobin, buildID: 5, expected: "subnet-3333"},
+	}
+	for _, test := range tests {
+		selected, err := Select(newMockClient(nil), test.strategy, testSubnetIDs, test.buildID)
+		assert.Nil(t, err, test.message)
+		assert.Equal(t, test.expected, aws.StringValue(selected.SubnetId), test.message)
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	_, err := Select(newMockClient(nil), "random", testSubnetIDs, 1)
+	assert.EqualError(t, err, `unknown subnet strategy "random"`)
+
+	_, err = Select(newMockClient(nil), RoundRobin, nil, 1)
+	assert.EqualError(t, err, "no subnets to select from")
+
+	_, err = Select(newMockClient(errors.New("AccessDenied")), RoundRobin, testSubnetIDs, 1)
+	assert.EqualError(t, err, "Error-DescribeSubnets: AccessDenied")
+
+	client := new(mockEC2Client)
+	client.On("DescribeSubnets", mock.Anything).Return(&ec2.DescribeSubnetsOutput{}, nil)
+	_, err = Select(client, MostFreeIPs, []string{"subnet-9999"}, 1)
+	assert.EqualError(t, err, "subnets [subnet-9999] not found")
+}
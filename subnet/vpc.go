@@ -0,0 +1,97 @@
+package subnet
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// anyIPv6 is the ipv6 cidr matching all addresses
+const anyIPv6 = "::/0"
+
+// SecurityGroupIDs gets the security group ids of the provider vpc config
+func SecurityGroupIDs(vpc map[string]interface{}) []string {
+	groups, _ := vpc["securityGroupIds"].([]interface{})
+
+	var groupIDs []string
+	for _, sg := range groups {
+		if id, ok := sg.(string); ok && id != "" {
+			groupIDs = append(groupIDs, id)
+		}
+	}
+	return groupIDs
+}
+
+// IsDualStack returns true if an ipv6 cidr block is associated with the subnet
+func IsDualStack(s *ec2.Subnet) bool {
+	for _, association := range s.Ipv6CidrBlockAssociationSet {
+		if association.Ipv6CidrBlockState != nil && aws.StringValue(association.Ipv6CidrBlockState.State) == ec2.SubnetCidrBlockStateCodeAssociated {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the security group allows outbound ipv6 traffic to any address
+func allowsIPv6Egress(group *ec2.SecurityGroup) bool {
+	for _, permission := range group.IpPermissionsEgress {
+		for _, r := range permission.Ipv6Ranges {
+			if aws.StringValue(r.CidrIpv6) == anyIPv6 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckVPC checks that codebuild can attach a build to the subnets and security groups of the vpc.
+// Missing ipv6 egress from dual stack subnets does not fail ipv4 traffic and is returned as a warning.
+func CheckVPC(client ec2iface.EC2API, vpcID string, subnetIDs []string, securityGroupIDs []string) ([]string, error) {
+	if len(subnetIDs) == 0 {
+		return nil, nil
+	}
+	subnets, err := client.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnetIDs)})
+	if err != nil {
+		return nil, fmt.Errorf("Error-DescribeSubnets: %v", err)
+	}
+
+	var problems, warnings, dualStack []string
+	for _, s := range subnets.Subnets {
+		id := aws.StringValue(s.SubnetId)
+		if subnetVPC := aws.StringValue(s.VpcId); vpcID != "" && subnetVPC != vpcID {
+			problems = append(problems, fmt.Sprintf("subnet %s belongs to vpc %s, not %s", id, subnetVPC, vpcID))
+		}
+		if aws.BoolValue(s.Ipv6Native) {
+			problems = append(problems, fmt.Sprintf("subnet %s is IPv6 only, codebuild needs subnets with IPv4 addresses", id))
+		}
+		if IsDualStack(s) {
+			dualStack = append(dualStack, id)
+		}
+	}
+
+	if len(securityGroupIDs) > 0 {
+		groups, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: aws.StringSlice(securityGroupIDs)})
+		if err != nil {
+			return nil, fmt.Errorf("Error-DescribeSecurityGroups: %v", err)
+		}
+		ipv6Egress := false
+		for _, group := range groups.SecurityGroups {
+			if groupVPC := aws.StringValue(group.VpcId); vpcID != "" && groupVPC != vpcID {
+				problems = append(problems, fmt.Sprintf("security group %s belongs to vpc %s, not %s", aws.StringValue(group.GroupId), groupVPC, vpcID))
+			}
+			ipv6Egress = ipv6Egress || allowsIPv6Egress(group)
+		}
+		if len(dualStack) > 0 && !ipv6Egress {
+			warnings = append(warnings, fmt.Sprintf("security groups %s allow no IPv6 egress from dual stack subnets %s",
+				strings.Join(securityGroupIDs, ", "), strings.Join(dualStack, ", ")))
+		}
+	}
+
+	if len(problems) > 0 {
+		return warnings, fmt.Errorf("invalid vpc config: %s", strings.Join(problems, "; "))
+	}
+	return warnings, nil
+}
@@ -0,0 +1,81 @@
+package subnet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func (m *mockEC2Client) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	args := m.Called(input)
+	return args.Get(0).(*ec2.DescribeSecurityGroupsOutput), args.Error(1)
+}
+
+func dualStackSubnet(id string, vpcID string) *ec2.Subnet {
+	return &ec2.Subnet{
+		SubnetId: aws.String(id),
+		VpcId:    aws.String(vpcID),
+		Ipv6CidrBlockAssociationSet: []*ec2.SubnetIpv6CidrBlockAssociation{
+			{Ipv6CidrBlockState: &ec2.SubnetCidrBlockState{State: aws.String("associated")}},
+		},
+	}
+}
+
+func TestSecurityGroupIDs(t *testing.T) {
+	assert.Equal(t, []string{"sg-1", "sg-2"}, SecurityGroupIDs(map[string]interface{}{"securityGroupIds": []interface{}{"sg-1", "", "sg-2"}}))
+	assert.Nil(t, SecurityGroupIDs(nil))
+}
+
+func TestIsDualStack(t *testing.T) {
+	assert.True(t, IsDualStack(dualStackSubnet("subnet-1", "vpc-1")))
+	assert.False(t, IsDualStack(&ec2.Subnet{SubnetId: aws.String("subnet-1")}))
+	assert.False(t, IsDualStack(&ec2.Subnet{Ipv6CidrBlockAssociationSet: []*ec2.SubnetIpv6CidrBlockAssociation{
+		{Ipv6CidrBlockState: &ec2.SubnetCidrBlockState{State: aws.String("disassociated")}},
+	}}))
+}
+
+func TestCheckVPC(t *testing.T) {
+	subnetsInput := &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice([]string{"subnet-1", "subnet-2"})}
+	groupsInput := &ec2.DescribeSecurityGroupsInput{GroupIds: aws.StringSlice([]string{"sg-1"})}
+
+	client := new(mockEC2Client)
+	client.On("DescribeSubnets", subnetsInput).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		dualStackSubnet("subnet-1", "vpc-1"),
+		{SubnetId: aws.String("subnet-2"), VpcId: aws.String("vpc-1")},
+	}}, nil)
+	client.On("DescribeSecurityGroups", groupsInput).Return(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+		{GroupId: aws.String("sg-1"), VpcId: aws.String("vpc-1"), IpPermissionsEgress: []*ec2.IpPermission{
+			{Ipv6Ranges: []*ec2.Ipv6Range{{CidrIpv6: aws.String("::/0")}}},
+		}},
+	}}, nil)
+	warnings, err := CheckVPC(client, "vpc-1", []string{"subnet-1", "subnet-2"}, []string{"sg-1"})
+	assert.Nil(t, err)
+	assert.Nil(t, warnings)
+
+	client = new(mockEC2Client)
+	client.On("DescribeSubnets", subnetsInput).Return(&ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		dualStackSubnet("subnet-1", "vpc-1"),
+		{SubnetId: aws.String("subnet-2"), VpcId: aws.String("vpc-2"), Ipv6Native: aws.Bool(true)},
+	}}, nil)
+	client.On("DescribeSecurityGroups", groupsInput).Return(&ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+		{GroupId: aws.String("sg-1"), VpcId: aws.String("vpc-2"), IpPermissionsEgress: []*ec2.IpPermission{
+			{IpRanges: []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}}},
+		}},
+	}}, nil)
+	warnings, err = CheckVPC(client, "vpc-1", []string{"subnet-1", "subnet-2"}, []string{"sg-1"})
+	assert.EqualError(t, err, "invalid vpc config: subnet subnet-2 belongs to vpc vpc-2, not vpc-1; "+
+		"subnet subnet-2 is IPv6 only, codebuild needs subnets with IPv4 addresses; security group sg-1 belongs to vpc vpc-2, not vpc-1")
+	assert.Equal(t, []string{"security groups sg-1 allow no IPv6 egress from dual stack subnets subnet-1"}, warnings)
+
+	client = new(mockEC2Client)
+	client.On("DescribeSubnets", subnetsInput).Return(&ec2.DescribeSubnetsOutput{}, errors.New("UnauthorizedOperation"))
+	_, err = CheckVPC(client, "vpc-1", []string{"subnet-1", "subnet-2"}, []string{"sg-1"})
+	assert.EqualError(t, err, "Error-DescribeSubnets: UnauthorizedOperation")
+
+	warnings, err = CheckVPC(client, "vpc-1", nil, []string{"sg-1"})
+	assert.Nil(t, err)
+	assert.Nil(t, warnings)
+}
@@ -0,0 +1,41 @@
+// Package tags gets the tags of the aws resources the consumer creates for builds, which attribute costs and
+// ownership of the resources to pipelines and jobs
+package tags
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// ManagedBy marks the resources managed by Screwdriver with the value Screwdriver
+	ManagedBy = "managed-by"
+	// Screwdriver is the value of ManagedBy
+	Screwdriver = "screwdriver"
+	// PipelineID is the tag of the pipeline id of a resource
+	PipelineID = "sd-pipeline-id"
+	// JobID is the tag of the job id of a resource
+	JobID = "sd-job-id"
+)
+
+// Build gets the tags of the resources of a build
+func Build(config map[string]interface{}) map[string]string {
+	tags := map[string]string{ManagedBy: Screwdriver}
+	if config["pipelineId"] != nil {
+		tags[PipelineID] = fmt.Sprint(config["pipelineId"])
+	}
+	if config["jobId"] != nil {
+		tags[JobID] = fmt.Sprint(config["jobId"])
+	}
+	return tags
+}
+
+// Keys gets the keys of the tags in order, for apis taking lists of tags
+func Keys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
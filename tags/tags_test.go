@@ -0,0 +1,21 @@
+package tags
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	assert.Equal(t, map[string]string{"managed-by": "screwdriver"}, Build(map[string]interface{}{}))
+	assert.Equal(t, map[string]string{
+		"managed-by":     "screwdriver",
+		"sd-pipeline-id": "1898",
+		"sd-job-id":      "6822",
+	}, Build(map[string]interface{}{"pipelineId": json.Number("1898"), "jobId": json.Number("6822")}))
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, []string{"managed-by", "sd-job-id", "sd-pipeline-id"}, Keys(Build(map[string]interface{}{"pipelineId": 1, "jobId": 2})))
+}